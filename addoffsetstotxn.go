@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_AddOffsetsToTxn
+type addOffsetsToTxnRequestV0 struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+
+	// GroupID is the consumer group whose offsets are committed as part of
+	// the transaction.
+	GroupID string
+}
+
+func (t addOffsetsToTxnRequestV0) size() int32 {
+	return sizeofString(t.TransactionalID) +
+		sizeofInt64(t.ProducerID) +
+		sizeofInt16(t.ProducerEpoch) +
+		sizeofString(t.GroupID)
+}
+
+func (t addOffsetsToTxnRequestV0) writeTo(w *bufio.Writer) {
+	writeString(w, t.TransactionalID)
+	writeInt64(w, t.ProducerID)
+	writeInt16(w, t.ProducerEpoch)
+	writeString(w, t.GroupID)
+}
+
+type addOffsetsToTxnResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+	ErrorCode      int16
+}
+
+func (t addOffsetsToTxnResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t addOffsetsToTxnResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *addOffsetsToTxnResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+// AddOffsetsToTxn adds the offsets of the consumer group groupID to the ongoing
+// transaction of producer. The offsets are then committed with
+// Conn.TxnOffsetCommit, and become the committed offsets of the group only if
+// the transaction is committed, atomically with the records written by the
+// transaction. This is what lets programs consuming records, and producing the
+// result of their processing to kafka, process each record exactly once.
+//
+// The request must be sent to the transaction coordinator of the producer (see
+// Dialer.LookupCoordinator), and is only supported by kafka 0.11 and above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_AddOffsetsToTxn
+func (c *Conn) AddOffsetsToTxn(producer TransactionalProducer, groupID string) error {
+	var response addOffsetsToTxnResponseV0
+
+	if _, err := c.negotiatedVersion(addOffsetsToTxnRequest); err != nil {
+		return err
+	}
+
+	request := addOffsetsToTxnRequestV0{
+		TransactionalID: producer.TransactionalID,
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+		GroupID:         groupID,
+	}
+
+	err := c.writeOperation(
+		addOffsetsToTxnRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(addOffsetsToTxnRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if response.ErrorCode != 0 {
+		return Error(response.ErrorCode)
+	}
+	return nil
+}
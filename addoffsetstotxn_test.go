@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAddOffsetsToTxnResponseV0(t *testing.T) {
+	item := addOffsetsToTxnResponseV0{
+		ThrottleTimeMS: 1,
+		ErrorCode:      int16(ConcurrentTransactions),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found addOffsetsToTxnResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestAddOffsetsToTxnRequestV0(t *testing.T) {
+	request := addOffsetsToTxnRequestV0{
+		TransactionalID: "txn",
+		ProducerID:      42,
+		ProducerEpoch:   3,
+		GroupID:         "group",
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
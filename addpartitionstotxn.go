@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_AddPartitionsToTxn
+type addPartitionsToTxnRequestV0 struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+
+	// Topics holds the partitions to add to the transaction.
+	Topics []addPartitionsToTxnRequestV0Topic
+}
+
+func (t addPartitionsToTxnRequestV0) size() int32 {
+	return sizeofString(t.TransactionalID) +
+		sizeofInt64(t.ProducerID) +
+		sizeofInt16(t.ProducerEpoch) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t addPartitionsToTxnRequestV0) writeTo(w *bufio.Writer) {
+	writeString(w, t.TransactionalID)
+	writeInt64(w, t.ProducerID)
+	writeInt16(w, t.ProducerEpoch)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+type addPartitionsToTxnRequestV0Topic struct {
+	Name       string
+	Partitions []int32
+}
+
+func (t addPartitionsToTxnRequestV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofInt32Array(t.Partitions)
+}
+
+func (t addPartitionsToTxnRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeInt32Array(w, t.Partitions)
+}
+
+type addPartitionsToTxnResponseV0Partition struct {
+	PartitionIndex int32
+	ErrorCode      int16
+}
+
+func (t addPartitionsToTxnResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t addPartitionsToTxnResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *addPartitionsToTxnResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+type addPartitionsToTxnResponseV0Topic struct {
+	Name       string
+	Partitions []addPartitionsToTxnResponseV0Partition
+}
+
+func (t addPartitionsToTxnResponseV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t addPartitionsToTxnResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+func (t *addPartitionsToTxnResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item addPartitionsToTxnResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type addPartitionsToTxnResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// Topics holds the results of the partitions of the request.
+	Topics []addPartitionsToTxnResponseV0Topic
+}
+
+func (t addPartitionsToTxnResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t addPartitionsToTxnResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+func (t *addPartitionsToTxnResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item addPartitionsToTxnResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// addPartitionsToTxn adds the partitions of the request to the ongoing
+// transaction of the producer.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_AddPartitionsToTxn
+func (c *Conn) addPartitionsToTxn(request addPartitionsToTxnRequestV0) (addPartitionsToTxnResponseV0, error) {
+	var response addPartitionsToTxnResponseV0
+
+	if _, err := c.negotiatedVersion(addPartitionsToTxnRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		addPartitionsToTxnRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(addPartitionsToTxnRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return addPartitionsToTxnResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// AddPartitionsToTxn adds partitions, which maps topics to partition numbers,
+// to the ongoing transaction of producer, which starts a new transaction if
+// the producer has none. Records may only be written to the partitions of a
+// transaction with Conn.Produce once they were added to it.
+//
+// When adding some of the partitions fails, none of them are added and the
+// method returns the error of the first partition which failed.
+//
+// The request must be sent to the transaction coordinator of the producer (see
+// Dialer.LookupCoordinator), and is only supported by kafka 0.11 and above.
+func (c *Conn) AddPartitionsToTxn(producer TransactionalProducer, partitions map[string][]int) error {
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	request := addPartitionsToTxnRequestV0{
+		TransactionalID: producer.TransactionalID,
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+	}
+	for _, topic := range topics {
+		requestTopic := addPartitionsToTxnRequestV0Topic{Name: topic}
+		for _, p := range partitions[topic] {
+			requestTopic.Partitions = append(requestTopic.Partitions, int32(p))
+		}
+		request.Topics = append(request.Topics, requestTopic)
+	}
+
+	response, err := c.addPartitionsToTxn(request)
+	if err != nil {
+		return err
+	}
+
+	// The partitions which were not added because another one failed report
+	// error 55 (OPERATION_NOT_ATTEMPTED, BrokerAuthorizationFailed in older
+	// versions of kafka), the error of the partition which failed is returned
+	// instead.
+	for _, t := range response.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 && (err == nil || err == BrokerAuthorizationFailed) {
+				err = Error(p.ErrorCode)
+			}
+		}
+	}
+	return err
+}
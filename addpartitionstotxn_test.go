@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAddPartitionsToTxnResponseV0(t *testing.T) {
+	item := addPartitionsToTxnResponseV0{
+		ThrottleTimeMS: 1,
+		Topics: []addPartitionsToTxnResponseV0Topic{
+			{
+				Name: "a",
+				Partitions: []addPartitionsToTxnResponseV0Partition{
+					{PartitionIndex: 0, ErrorCode: int16(BrokerAuthorizationFailed)},
+					{PartitionIndex: 1, ErrorCode: int16(UnknownTopicOrPartition)},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found addPartitionsToTxnResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestAddPartitionsToTxnRequestV0(t *testing.T) {
+	request := addPartitionsToTxnRequestV0{
+		TransactionalID: "txn",
+		ProducerID:      42,
+		ProducerEpoch:   3,
+		Topics: []addPartitionsToTxnRequestV0Topic{
+			{Name: "a", Partitions: []int32{0, 1}},
+			{Name: "b", Partitions: []int32{2}},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
@@ -0,0 +1,294 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_AlterPartitionReassignments
+type alterPartitionReassignmentsRequestV0 struct {
+	// TimeoutMS is the time in milliseconds to wait for the request to
+	// complete.
+	TimeoutMS int32
+
+	// Topics holds the partitions to reassign.
+	Topics []alterPartitionReassignmentsRequestV0Topic
+}
+
+func (t alterPartitionReassignmentsRequestV0) size() int32 {
+	return sizeofInt32(t.TimeoutMS) +
+		sizeofCompactArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsRequestV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.TimeoutMS)
+	writeCompactArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+type alterPartitionReassignmentsRequestV0Topic struct {
+	Name       string
+	Partitions []alterPartitionReassignmentsRequestV0Partition
+}
+
+func (t alterPartitionReassignmentsRequestV0Topic) size() int32 {
+	return sizeofCompactString(t.Name) +
+		sizeofCompactArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeCompactString(w, t.Name)
+	writeCompactArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+type alterPartitionReassignmentsRequestV0Partition struct {
+	PartitionIndex int32
+
+	// Replicas holds the IDs of the brokers that the partition is reassigned
+	// to, a null array cancels the pending reassignment of the partition.
+	Replicas []int32
+}
+
+func (t alterPartitionReassignmentsRequestV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofCompactInt32Array(t.Replicas) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsRequestV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeCompactInt32Array(w, t.Replicas)
+	writeTaggedFields(w)
+}
+
+type alterPartitionReassignmentsResponseV0Partition struct {
+	PartitionIndex int32
+	ErrorCode      int16
+	ErrorMessage   string
+}
+
+func (t alterPartitionReassignmentsResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofCompactString(t.ErrorMessage) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt16(w, t.ErrorCode)
+	writeCompactString(w, t.ErrorMessage)
+	writeTaggedFields(w)
+}
+
+func (t *alterPartitionReassignmentsResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readCompactString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+type alterPartitionReassignmentsResponseV0Topic struct {
+	Name       string
+	Partitions []alterPartitionReassignmentsResponseV0Partition
+}
+
+func (t alterPartitionReassignmentsResponseV0Topic) size() int32 {
+	return sizeofCompactString(t.Name) +
+		sizeofCompactArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeCompactString(w, t.Name)
+	writeCompactArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+func (t *alterPartitionReassignmentsResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readCompactString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item alterPartitionReassignmentsResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+type alterPartitionReassignmentsResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode is the top level error of the request.
+	ErrorCode    int16
+	ErrorMessage string
+
+	// Responses holds the results of the reassignment of each partition.
+	Responses []alterPartitionReassignmentsResponseV0Topic
+}
+
+func (t alterPartitionReassignmentsResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofCompactString(t.ErrorMessage) +
+		sizeofCompactArray(len(t.Responses), func(i int) int32 { return t.Responses[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t alterPartitionReassignmentsResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeCompactString(w, t.ErrorMessage)
+	writeCompactArray(w, len(t.Responses), func(i int) { t.Responses[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+func (t *alterPartitionReassignmentsResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readCompactString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item alterPartitionReassignmentsResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Responses = append(t.Responses, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+// alterPartitionReassignments reassigns the partitions of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_AlterPartitionReassignments
+func (c *Conn) alterPartitionReassignments(request alterPartitionReassignmentsRequestV0) (alterPartitionReassignmentsResponseV0, error) {
+	var response alterPartitionReassignmentsResponseV0
+
+	if _, err := c.negotiatedVersion(alterPartitionReassignmentsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		alterPartitionReassignmentsRequest,
+		func(deadline time.Time, id int32) error {
+			if request.TimeoutMS == 0 {
+				now := time.Now()
+				deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
+				request.TimeoutMS = milliseconds(deadlineToTimeout(deadline, now))
+			}
+			return c.writeFlexibleRequest(alterPartitionReassignmentsRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				// tagged fields of the response header
+				if remain, err = discardTaggedFields(&c.rbuf, size); err != nil {
+					return
+				}
+				return (&response).readFrom(&c.rbuf, remain)
+			}())
+		},
+	)
+	if err != nil {
+		return alterPartitionReassignmentsResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// AlterPartitionReassignments moves the replicas of the partitions of topic to
+// the brokers in reassignments, which maps partition numbers to the IDs of the
+// brokers that the partitions are reassigned to, the first one being the
+// preferred leader. A nil list of brokers cancels the pending reassignment of
+// a partition.
+//
+// The method returns when the reassignments were started, which may take a
+// long time to complete while the new replicas catch up with the leaders.
+// ListPartitionReassignments reports the reassignments that are in progress.
+// When the reassignment of a partition fails, the method returns the error
+// of the first partition which failed.
+//
+// The request must be sent to the controller of the cluster (see Controller),
+// and is only supported by kafka 2.4 and above.
+func (c *Conn) AlterPartitionReassignments(topic string, reassignments map[int][]int) error {
+	partitions := make([]int, 0, len(reassignments))
+	for p := range reassignments {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+
+	requestTopic := alterPartitionReassignmentsRequestV0Topic{Name: topic}
+	for _, p := range partitions {
+		var replicas []int32
+		if brokers := reassignments[p]; brokers != nil {
+			replicas = make([]int32, len(brokers))
+			for i, b := range brokers {
+				replicas[i] = int32(b)
+			}
+		}
+		requestTopic.Partitions = append(requestTopic.Partitions, alterPartitionReassignmentsRequestV0Partition{
+			PartitionIndex: int32(p),
+			Replicas:       replicas,
+		})
+	}
+
+	response, err := c.alterPartitionReassignments(alterPartitionReassignmentsRequestV0{
+		Topics: []alterPartitionReassignmentsRequestV0Topic{requestTopic},
+	})
+	if err != nil {
+		return err
+	}
+	if response.ErrorCode != 0 {
+		return Error(response.ErrorCode)
+	}
+
+	for _, t := range response.Responses {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 {
+				return Error(p.ErrorCode)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAlterPartitionReassignmentsResponseV0(t *testing.T) {
+	item := alterPartitionReassignmentsResponseV0{
+		ThrottleTimeMS: 1,
+		Responses: []alterPartitionReassignmentsResponseV0Topic{
+			{
+				Name: "a",
+				Partitions: []alterPartitionReassignmentsResponseV0Partition{
+					{
+						PartitionIndex: 0,
+					},
+					{
+						PartitionIndex: 1,
+						ErrorCode:      int16(NoReassignmentInProgress),
+						ErrorMessage:   "no reassignment in progress",
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found alterPartitionReassignmentsResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestAlterPartitionReassignmentsRequestV0(t *testing.T) {
+	request := alterPartitionReassignmentsRequestV0{
+		TimeoutMS: 1000,
+		Topics: []alterPartitionReassignmentsRequestV0Topic{
+			{
+				Name: "a",
+				Partitions: []alterPartitionReassignmentsRequestV0Partition{
+					{PartitionIndex: 0, Replicas: []int32{1, 2, 3}},
+					{PartitionIndex: 1},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
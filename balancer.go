@@ -5,6 +5,7 @@ import (
 	"hash/fnv"
 	"sort"
 	"sync"
+	"time"
 )
 
 // The Balancer interface provides an abstraction of the message distribution
@@ -22,9 +23,27 @@ type Balancer interface {
 	// sets of partitions (from different topics for examples), use one balancer
 	// instance for each partition set, so the balancer can detect when the
 	// partitions change and assume that the kafka topic has been rebalanced.
+	//
+	// Programs may call Balance to find out which partition a message would be
+	// routed to, Writer.PartitionFor does it with the partitions of the topic
+	// of a writer for the balancers which implement PartitionLocator. Writers
+	// never call the method concurrently, so balancers are not required to be
+	// safe to use from multiple goroutines.
 	Balance(msg Message, partitions ...int) (partition int)
 }
 
+// PartitionLocator is implemented by the balancers which can tell which
+// partition a message would be routed to without changing their state, like
+// balancers routing messages by key or by ranges of keys. Writer.PartitionFor
+// only supports the balancers implementing it.
+type PartitionLocator interface {
+	// PartitionFor returns the partition that Balance would route msg to, or
+	// false if it depends on the state of the balancer, which Balance would
+	// change. Like Balance, the method is never called concurrently by
+	// writers.
+	PartitionFor(msg Message, partitions ...int) (partition int, ok bool)
+}
+
 // BalancerFunc is an implementation of the Balancer interface that makes it
 // possible to use regular functions to distribute messages across partitions.
 type BalancerFunc func(Message, ...int) int
@@ -34,6 +53,21 @@ func (f BalancerFunc) Balance(msg Message, partitions ...int) int {
 	return f(msg, partitions...)
 }
 
+// StatelessBalancerFunc is like BalancerFunc for functions whose result only
+// depends on their arguments, for example to route messages by ranges of keys.
+// Unlike BalancerFunc, it implements PartitionLocator.
+type StatelessBalancerFunc func(Message, ...int) int
+
+// Balance calls f, satisfies the Balancer interface.
+func (f StatelessBalancerFunc) Balance(msg Message, partitions ...int) int {
+	return f(msg, partitions...)
+}
+
+// PartitionFor calls f, satisfies the PartitionLocator interface.
+func (f StatelessBalancerFunc) PartitionFor(msg Message, partitions ...int) (int, bool) {
+	return f(msg, partitions...), true
+}
+
 // RoundRobin is an Balancer implementation that equally distributes messages
 // across all available partitions.
 type RoundRobin struct {
@@ -129,13 +163,35 @@ var (
 // By default, Hash uses the FNV-1a algorithm.  This is the same algorithm used
 // by the Sarama Producer and ensures that messages produced by kafka-go will
 // be delivered to the same topics that the Sarama producer would be delivered to
+//
+// Messages with a nil key can't be hashed, they are routed by the Fallback
+// balancer instead, which distributes them in a round robin fashion unless it
+// is set.
 type Hash struct {
-	rr     RoundRobin
+	// Hasher is the hash function applied to the keys of messages, for example
+	// crc32.NewIEEE() to route messages like producers using CRC32 do. Since
+	// the writer synchronizes the calls to Balance, the hasher is not required
+	// to be safe to use concurrently, but it must not be shared with other
+	// balancers.
+	//
+	// Default: FNV-1a
 	Hasher hash.Hash32
+
+	// Fallback is the balancer routing the messages with a nil key. Note that
+	// messages with an empty, non-nil key are hashed like the others.
+	//
+	// Default: RoundRobin
+	Fallback Balancer
+
+	rr RoundRobin
 }
 
+// Balance satisfies the Balancer interface.
 func (h *Hash) Balance(msg Message, partitions ...int) (partition int) {
 	if msg.Key == nil {
+		if h.Fallback != nil {
+			return h.Fallback.Balance(msg, partitions...)
+		}
 		return h.rr.Balance(msg, partitions...)
 	}
 
@@ -158,3 +214,108 @@ func (h *Hash) Balance(msg Message, partitions ...int) (partition int) {
 
 	return
 }
+
+// PartitionFor satisfies the PartitionLocator interface. The partition of
+// messages with a nil key is only known when the Fallback balancer implements
+// PartitionLocator, since the default one routes them in a round robin fashion.
+func (h *Hash) PartitionFor(msg Message, partitions ...int) (int, bool) {
+	if msg.Key == nil {
+		if l, ok := h.Fallback.(PartitionLocator); ok {
+			return l.PartitionFor(msg, partitions...)
+		}
+		return -1, false
+	}
+	return h.Balance(msg, partitions...), true
+}
+
+// StickyBalancer is a Balancer that routes the messages without keys to the
+// same partition until BatchSize messages were routed to it, or Interval
+// elapsed, before moving on to the next partition. Like the sticky partitioner
+// of the java client, it fills larger batches than RoundRobin does, which
+// reduces the number of produce requests and improves the compression ratio
+// of the batches.
+//
+// Messages with keys are routed like the Hash balancer does, all messages with
+// the same key are sent to the same partition and stay ordered. Messages
+// without keys are only ordered while they stick to a partition, the order of
+// those routed to different partitions is not preserved.
+//
+// Unlike the other balancers, a StickyBalancer is safe to use concurrently, so
+// it may be passed to WriteMessagesWith by writers sharing it to fill the same
+// partitions. It must not be copied after its first use.
+type StickyBalancer struct {
+	// BatchSize is the number of messages without keys routed to a partition
+	// before moving on to the next one.
+	//
+	// Default: 100, the default batch size of writers
+	BatchSize int
+
+	// Interval is the maximum amount of time that messages without keys are
+	// routed to the same partition, zero means no limit.
+	Interval time.Duration
+
+	// Hasher is the hash function used to route messages with keys, see Hash.
+	//
+	// Default: FNV-1a
+	Hasher hash.Hash32
+
+	mutex     sync.Mutex
+	hash      Hash
+	partition int
+	count     int
+	since     time.Time
+	next      int
+}
+
+// Balance satisfies the Balancer interface.
+func (sb *StickyBalancer) Balance(msg Message, partitions ...int) int {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if msg.Key != nil {
+		sb.hash.Hasher = sb.Hasher
+		return sb.hash.Balance(msg, partitions...)
+	}
+
+	batchSize := sb.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	now := time.Now()
+	switch {
+	case sb.count == 0, sb.count >= batchSize:
+	case sb.Interval > 0 && now.Sub(sb.since) >= sb.Interval:
+	case !containsPartition(partitions, sb.partition):
+		// The partitions changed and the one that messages were routed to
+		// is not available anymore.
+	default:
+		sb.count++
+		return sb.partition
+	}
+
+	sb.partition = partitions[sb.next%len(partitions)]
+	sb.next++
+	sb.count = 1
+	sb.since = now
+	return sb.partition
+}
+
+// PartitionFor satisfies the PartitionLocator interface. Only the partition of
+// messages with keys is known, the others depend on the partition that the
+// balancer currently sticks to.
+func (sb *StickyBalancer) PartitionFor(msg Message, partitions ...int) (int, bool) {
+	if msg.Key == nil {
+		return -1, false
+	}
+	return sb.Balance(msg, partitions...), true
+}
+
+func containsPartition(partitions []int, partition int) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
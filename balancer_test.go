@@ -3,7 +3,12 @@ package kafka
 import (
 	"hash"
 	"hash/crc32"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestHashBalancer(t *testing.T) {
@@ -54,3 +59,106 @@ func TestHashBalancer(t *testing.T) {
 		})
 	}
 }
+
+func TestHashBalancerFallback(t *testing.T) {
+	h := Hash{
+		Fallback: BalancerFunc(func(msg Message, partitions ...int) int {
+			return partitions[len(partitions)-1]
+		}),
+	}
+
+	for i := 0; i != 3; i++ {
+		if p := h.Balance(Message{}, 0, 1, 2); p != 2 {
+			t.Errorf("expected messages with a nil key to be routed by the fallback balancer to partition 2; got %d", p)
+		}
+	}
+
+	// Empty keys are hashed, FNV-1a hashes them to 2166136261.
+	if p := h.Balance(Message{Key: []byte{}}, 0, 1, 2); p != 1 {
+		t.Errorf("expected messages with an empty key to be hashed to partition 1; got %d", p)
+	}
+}
+
+func TestStickyBalancer(t *testing.T) {
+	balance := func(sb *StickyBalancer, msg Message, n int, partitions ...int) []int {
+		routed := make([]int, n)
+		for i := range routed {
+			routed[i] = sb.Balance(msg, partitions...)
+		}
+		return routed
+	}
+
+	t.Run("messages without keys stick to a partition", func(t *testing.T) {
+		sb := &StickyBalancer{BatchSize: 3}
+		routed := balance(sb, Message{}, 10, 0, 1, 2)
+		expected := []int{0, 0, 0, 1, 1, 1, 2, 2, 2, 0}
+		if !reflect.DeepEqual(routed, expected) {
+			t.Errorf("expected %v; got %v", expected, routed)
+		}
+	})
+
+	t.Run("messages with keys are hashed", func(t *testing.T) {
+		sb := &StickyBalancer{BatchSize: 3}
+		for _, key := range []string{"blah", "boop"} {
+			msg := Message{Key: []byte(key)}
+			expected := (&Hash{}).Balance(msg, 0, 1, 2)
+			if p := sb.Balance(msg, 0, 1, 2); p != expected {
+				t.Errorf("%s: expected %d; got %d", key, expected, p)
+			}
+		}
+		if p := sb.Balance(Message{}, 0, 1, 2); p != 0 {
+			t.Errorf("expected messages with keys not to count against the batch size; got %d", p)
+		}
+	})
+
+	t.Run("partitions change", func(t *testing.T) {
+		sb := &StickyBalancer{BatchSize: 3}
+		routed := append(balance(sb, Message{}, 2, 0, 1, 2), balance(sb, Message{}, 2, 1, 2)...)
+		expected := []int{0, 0, 2, 2}
+		if !reflect.DeepEqual(routed, expected) {
+			t.Errorf("expected %v; got %v", expected, routed)
+		}
+	})
+
+	t.Run("interval elapses", func(t *testing.T) {
+		sb := &StickyBalancer{Interval: time.Millisecond}
+		first := sb.Balance(Message{}, 0, 1, 2)
+		time.Sleep(2 * time.Millisecond)
+		if p := sb.Balance(Message{}, 0, 1, 2); p == first {
+			t.Errorf("expected to move on from partition %d after the interval elapsed", p)
+		}
+	})
+
+	t.Run("concurrent calls", func(t *testing.T) {
+		sb := &StickyBalancer{BatchSize: 10, Hasher: crc32.NewIEEE()}
+
+		var wg sync.WaitGroup
+		var counts [3]int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 30; j++ {
+					msg := Message{}
+					if j%2 == 0 {
+						msg.Key = []byte(strconv.Itoa(i))
+					}
+					atomic.AddInt32(&counts[sb.Balance(msg, 0, 1, 2)], 1)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// The messages without keys were routed in batches of 10 messages,
+		// 50 to each partition.
+		keyed := [3]int32{}
+		for i := 0; i < 10; i++ {
+			keyed[(&Hash{Hasher: crc32.NewIEEE()}).Balance(Message{Key: []byte(strconv.Itoa(i))}, 0, 1, 2)] += 15
+		}
+		for p := range counts {
+			if n := counts[p] - keyed[p]; n != 50 {
+				t.Errorf("expected 50 messages without keys to be routed to partition %d; got %d", p, n)
+			}
+		}
+	})
+}
@@ -17,17 +17,48 @@ import (
 //
 // Batches are safe to use concurrently from multiple goroutines.
 type Batch struct {
-	mutex         sync.Mutex
-	conn          *Conn
-	lock          *sync.Mutex
-	msgs          *messageSetReader
-	deadline      time.Time
-	throttle      time.Duration
-	topic         string
-	partition     int
-	offset        int64
-	highWaterMark int64
-	err           error
+	mutex          sync.Mutex
+	conn           *Conn
+	lock           *sync.Mutex
+	msgs           *messageSetReader
+	deadline       time.Time
+	throttle       time.Duration
+	topic          string
+	partition      int
+	offset         int64
+	highWaterMark  int64
+	logStartOffset int64
+	readReplica    int
+	err            error
+
+	// buffers and functions reused by ReadRecord to avoid allocating memory
+	// for each record.
+	key       []byte
+	value     []byte
+	keyBuf    []byte
+	valueBuf  []byte
+	readKey   func(*bufio.Reader, int, int) (int, error)
+	readValue func(*bufio.Reader, int, int) (int, error)
+}
+
+// Record is a view of a message read from a batch by ReadRecord.
+//
+// The Key and Value slices are not copied from the batch, they point to buffers
+// owned by the batch and are only valid until the next call to ReadRecord or
+// Close. Programs that need to retain them for longer must copy them.
+type Record struct {
+	Offset  int64
+	Time    time.Time
+	Key     []byte
+	Value   []byte
+	Headers []Header
+
+	// Attributes of the record batch that the record was read from, as
+	// defined by the kafka protocol: the compression codec in bits 0-2, the
+	// timestamp type in bit 3 (set for log append time), and the transactional
+	// flag in bit 4. Messages of the v0 and v1 formats only carry the codec
+	// and timestamp type. Ignored when producing records.
+	Attributes int16
 }
 
 // Throttle gives the throttling duration applied by the kafka server on the
@@ -36,11 +67,76 @@ func (batch *Batch) Throttle() time.Duration {
 	return batch.throttle
 }
 
-// Watermark returns the current highest watermark in a partition.
+// HighWaterMark returns the current highest watermark in a partition, which
+// is the offset of the next message to be produced to the partition. It is
+// reported by the kafka server even when the batch contains no messages.
 func (batch *Batch) HighWaterMark() int64 {
 	return batch.highWaterMark
 }
 
+// LogStartOffset returns the first offset available in the partition, which
+// moves forward as old messages are deleted by the retention policy. Like the
+// high watermark, it is reported even when the batch contains no messages.
+//
+// The log start offset is only reported by kafka 0.11 and above, the method
+// returns -1 when it is unknown.
+func (batch *Batch) LogStartOffset() int64 {
+	return batch.logStartOffset
+}
+
+// PreferredReadReplica returns the ID of the broker that the partition leader
+// designated to fetch from instead of itself, based on the rack ID set in the
+// ReadBatchConfig. The batch contains no messages when the leader designates
+// another replica.
+//
+// The method returns -1 when the leader did not designate a replica, or with
+// kafka versions prior to 2.4.
+func (batch *Batch) PreferredReadReplica() int {
+	if batch.msgs == nil { // the fetch request failed
+		return -1
+	}
+	return batch.readReplica
+}
+
+// ProducerID returns the ID of the producer that wrote the record batch of the
+// last message read from the batch. Together with ProducerEpoch and
+// BaseSequence it identifies the producer instance, which helps diagnosing
+// duplicated or reordered messages.
+//
+// The method returns -1 when the record batch was not written by an
+// idempotent producer, when no message was read yet, or with the message
+// formats prior to kafka 0.11.
+func (batch *Batch) ProducerID() int64 {
+	id, _, _ := batch.producer()
+	return id
+}
+
+// ProducerEpoch returns the epoch of the producer that wrote the record batch
+// of the last message read from the batch, or -1 if it is unknown (see
+// ProducerID).
+func (batch *Batch) ProducerEpoch() int {
+	_, epoch, _ := batch.producer()
+	return int(epoch)
+}
+
+// BaseSequence returns the sequence number of the first record of the record
+// batch of the last message read from the batch, or -1 if it is unknown (see
+// ProducerID).
+func (batch *Batch) BaseSequence() int {
+	_, _, sequence := batch.producer()
+	return int(sequence)
+}
+
+func (batch *Batch) producer() (int64, int16, int32) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+
+	if batch.msgs == nil {
+		return -1, -1, -1
+	}
+	return batch.msgs.producer()
+}
+
 // Offset returns the offset of the next message in the batch.
 func (batch *Batch) Offset() int64 {
 	batch.mutex.Lock()
@@ -190,6 +286,89 @@ func (batch *Batch) ReadMessage() (Message, error) {
 	return msg, err
 }
 
+// ReadRecord reads and returns the next message from the batch as a Record.
+//
+// Unlike ReadMessage, the method doesn't allocate memory buffers for the key
+// and value of each message, it reuses buffers owned by the batch instead. The
+// Key and Value of the returned record alias those buffers, their content is
+// overwritten by the next call to ReadRecord, and they must not be used after
+// the batch is closed. This makes ReadRecord well suited to programs that
+// process high volumes of messages and don't retain them, since it doesn't
+// put pressure on the garbage collector.
+//
+// Errors are reported the same way as ReadMessage.
+func (batch *Batch) ReadRecord() (Record, error) {
+	batch.mutex.Lock()
+
+	if batch.readKey == nil {
+		batch.readKey = func(r *bufio.Reader, size int, nbytes int) (remain int, err error) {
+			if batch.key, remain, err = readBytesInto(r, size, nbytes, batch.keyBuf); cap(batch.key) > cap(batch.keyBuf) {
+				batch.keyBuf = batch.key
+			}
+			return
+		}
+		batch.readValue = func(r *bufio.Reader, size int, nbytes int) (remain int, err error) {
+			if batch.value, remain, err = readBytesInto(r, size, nbytes, batch.valueBuf); cap(batch.value) > cap(batch.valueBuf) {
+				batch.valueBuf = batch.value
+			}
+			return
+		}
+	}
+
+	// reset the key and value so a failed read never reports the ones of the
+	// previous record
+	batch.key, batch.value = nil, nil
+
+	offset, timestamp, headers, err := batch.readMessage(batch.readKey, batch.readValue)
+	for batch.conn != nil && offset < batch.conn.offset {
+		if err != nil {
+			break
+		}
+		offset, timestamp, headers, err = batch.readMessage(batch.readKey, batch.readValue)
+	}
+
+	rec := Record{
+		Offset:  offset,
+		Time:    timestampToTime(timestamp),
+		Key:     batch.key,
+		Value:   batch.value,
+		Headers: headers,
+	}
+	if err == nil {
+		rec.Attributes = batch.msgs.attributes()
+	}
+
+	batch.mutex.Unlock()
+	return rec, err
+}
+
+// Records calls fn with each record of the batch in order, until the end of the
+// batch is reached or fn returns an error. It gives programs access to all the
+// fields of the records, including the attributes of the record batches they
+// were read from.
+//
+// Records are read with ReadRecord: compressed record batches are
+// decompressed, control records are skipped, and the Key and Value of each
+// record are only valid until fn returns.
+//
+// The method returns nil when the end of the batch was reached, the error
+// returned by fn when it stopped the iteration, or the error which ended the
+// batch otherwise.
+func (batch *Batch) Records(fn func(Record) error) error {
+	for {
+		rec, err := batch.ReadRecord()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
 func (batch *Batch) readMessage(
 	key func(*bufio.Reader, int, int) (int, error),
 	val func(*bufio.Reader, int, int) (int, error),
@@ -203,6 +382,11 @@ func (batch *Batch) readMessage(
 	case nil:
 		batch.offset = offset + 1
 	case errShortRead:
+		// The batches of control records at the end of the response are
+		// skipped as well, so they are not fetched again.
+		if skipped := batch.msgs.skipped(); skipped > batch.offset {
+			batch.offset = skipped
+		}
 		// As an "optimization" kafka truncates the returned response after
 		// producing MaxBytes, which could then cause the code to return
 		// errShortRead.
@@ -1,11 +1,18 @@
 package kafka
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"net"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestBatchDontExpectEOF(t *testing.T) {
@@ -38,3 +45,237 @@ func TestBatchDontExpectEOF(t *testing.T) {
 		t.Error("bad error when closing the batch:", err)
 	}
 }
+
+// makeRecordBatch encodes msgs in a record batch starting at baseOffset.
+func makeRecordBatch(attributes int16, baseOffset int64, msgs ...Message) []byte {
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+
+	writeRecordBatch(w, nil, attributes, recordBatchSize(msgs...), func(w *bufio.Writer) {
+		for i, msg := range msgs {
+			writeRecord(w, 0, msgs[0].Time, int64(i), msg)
+		}
+	}, msgs...)
+	w.Flush()
+
+	b := buf.Bytes()
+	binary.BigEndian.PutUint64(b, uint64(baseOffset))
+	return b
+}
+
+func TestBatchSkipsControlRecords(t *testing.T) {
+	const transactional = 1 << 4
+	const control = 1 << 5
+
+	now := time.Now()
+	commit := Message{
+		Key:   []byte{0, 0, 0, 1},       // version 0, commit marker
+		Value: []byte{0, 0, 0, 0, 0, 0}, // version 0, coordinator epoch
+		Time:  now,
+	}
+
+	var b []byte
+	b = append(b, makeRecordBatch(transactional, 0, Message{Value: []byte("0"), Time: now}, Message{Value: []byte("1"), Time: now})...)
+	b = append(b, makeRecordBatch(transactional|control, 2, commit)...)
+	b = append(b, makeRecordBatch(transactional, 3, Message{Value: []byte("3"), Time: now})...)
+	b = append(b, makeRecordBatch(transactional|control, 4, commit)...)
+
+	for _, checkCRC := range []bool{false, true} {
+		t.Run(fmt.Sprintf("checkCRC=%t", checkCRC), func(t *testing.T) {
+			msgs, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs.v2.checkCRC = checkCRC
+			batch := &Batch{msgs: msgs}
+
+			for _, offset := range []int64{0, 1, 3} {
+				m, err := batch.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if m.Offset != offset || string(m.Value) != strconv.FormatInt(offset, 10) {
+					t.Fatalf("expected message %d; got %q at offset %d", offset, m.Value, m.Offset)
+				}
+			}
+
+			if _, err := batch.ReadMessage(); err != io.EOF {
+				t.Fatalf("expected io.EOF; got %v", err)
+			}
+			if offset := batch.Offset(); offset != 5 {
+				t.Errorf("expected the batch to end after the commit marker at offset 5; got %d", offset)
+			}
+		})
+	}
+}
+
+func TestBatchRecords(t *testing.T) {
+	const transactional = 1 << 4
+	const control = 1 << 5
+
+	now := time.Now().Truncate(time.Millisecond)
+	commit := Message{
+		Key:   []byte{0, 0, 0, 1},       // version 0, commit marker
+		Value: []byte{0, 0, 0, 0, 0, 0}, // version 0, coordinator epoch
+		Time:  now,
+	}
+
+	var b []byte
+	b = append(b, makeRecordBatch(0, 0, Message{Key: []byte("k"), Value: []byte("0"), Headers: []Header{{Key: "h", Value: []byte("v")}}, Time: now})...)
+	b = append(b, makeRecordBatch(transactional, 1, Message{Value: []byte("1"), Time: now.Add(time.Second)})...)
+	b = append(b, makeRecordBatch(transactional|control, 2, commit)...)
+	b = append(b, makeRecordBatch(0, 3, Message{Value: []byte("3"), Time: now})...)
+
+	newBatch := func() *Batch {
+		msgs, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Batch{msgs: msgs}
+	}
+
+	var records []Record
+	err := newBatch().Records(func(r Record) error {
+		// the key and value are only valid until the function returns
+		r.Key = append([]byte(nil), r.Key...)
+		r.Value = append([]byte(nil), r.Value...)
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Record{
+		{Offset: 0, Time: now, Key: []byte("k"), Value: []byte("0"), Headers: []Header{{Key: "h", Value: []byte("v")}}},
+		{Offset: 1, Time: now.Add(time.Second), Key: []byte{}, Value: []byte("1"), Headers: []Header{}, Attributes: transactional},
+		{Offset: 3, Time: now, Key: []byte{}, Value: []byte("3"), Headers: []Header{}},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records; got %d", len(expected), len(records))
+	}
+	for i, r := range records {
+		e := expected[i]
+		if r.Offset != e.Offset || !r.Time.Equal(e.Time) || !bytes.Equal(r.Key, e.Key) || !bytes.Equal(r.Value, e.Value) || r.Attributes != e.Attributes {
+			t.Errorf("record %d: expected %+v; got %+v", i, e, r)
+		}
+		if len(r.Headers) != len(e.Headers) || (len(e.Headers) != 0 && (r.Headers[0].Key != e.Headers[0].Key || !bytes.Equal(r.Headers[0].Value, e.Headers[0].Value))) {
+			t.Errorf("record %d: expected headers %v; got %v", i, e.Headers, r.Headers)
+		}
+	}
+
+	// The iteration stops at the first error returned by the function.
+	stop := errors.New("stop")
+	n := 0
+	err = newBatch().Records(func(r Record) error {
+		n++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("expected %v; got %v", stop, err)
+	}
+	if n != 1 {
+		t.Errorf("expected the iteration to stop after 1 record; got %d", n)
+	}
+}
+
+func TestBatchProducer(t *testing.T) {
+	now := time.Now()
+
+	// The record batches made by makeRecordBatch have no producer, the
+	// producer of the first one is set before its CRC is computed again.
+	idempotent := makeRecordBatch(0, 0, Message{Value: []byte("0"), Time: now})
+	binary.BigEndian.PutUint64(idempotent[43:], 42) // producer ID
+	binary.BigEndian.PutUint16(idempotent[51:], 3)  // producer epoch
+	binary.BigEndian.PutUint32(idempotent[53:], 7)  // base sequence
+	binary.BigEndian.PutUint32(idempotent[17:], crc32.Checksum(idempotent[21:], crc32cTable))
+
+	b := append(idempotent, makeRecordBatch(0, 1, Message{Value: []byte("1"), Time: now})...)
+
+	msgs, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs.v2.checkCRC = true
+	batch := &Batch{msgs: msgs}
+
+	check := func(id int64, epoch, sequence int) {
+		t.Helper()
+		if batch.ProducerID() != id || batch.ProducerEpoch() != epoch || batch.BaseSequence() != sequence {
+			t.Errorf("expected producer %d, epoch %d, and sequence %d; got %d, %d, and %d",
+				id, epoch, sequence, batch.ProducerID(), batch.ProducerEpoch(), batch.BaseSequence())
+		}
+	}
+
+	check(-1, -1, -1)
+
+	if _, err := batch.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	check(42, 3, 7)
+
+	if _, err := batch.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	check(-1, -1, -1)
+}
+
+func TestBatchNullValues(t *testing.T) {
+	now := time.Now()
+	msgs := []Message{
+		{Key: []byte("k"), Value: nil, Time: now},
+		{Key: []byte("k"), Value: []byte{}, Time: now},
+		{Key: nil, Value: []byte("v"), Headers: []Header{{Key: "h"}}, Time: now},
+	}
+	b := makeRecordBatch(0, 0, msgs...)
+
+	newBatch := func() *Batch {
+		msgSet, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Batch{msgs: msgSet}
+	}
+
+	check := func(i int, key, value, headerValue []byte) {
+		t.Helper()
+		if (key == nil) != (msgs[i].Key == nil) || !bytes.Equal(key, msgs[i].Key) {
+			t.Errorf("message %d: expected key %#v; got %#v", i, msgs[i].Key, key)
+		}
+		if (value == nil) != (msgs[i].Value == nil) || !bytes.Equal(value, msgs[i].Value) {
+			t.Errorf("message %d: expected value %#v; got %#v", i, msgs[i].Value, value)
+		}
+		if len(msgs[i].Headers) != 0 && headerValue != nil {
+			t.Errorf("message %d: expected a null header value; got %#v", i, headerValue)
+		}
+	}
+
+	batch := newBatch()
+	for i := range msgs {
+		msg, err := batch.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headerValue []byte
+		if len(msg.Headers) != 0 {
+			headerValue = msg.Headers[0].Value
+		}
+		check(i, msg.Key, msg.Value, headerValue)
+		if msg.IsTombstone() != (i == 0) {
+			t.Errorf("message %d: expected IsTombstone to be %t", i, i == 0)
+		}
+	}
+
+	batch = newBatch()
+	for i := range msgs {
+		r, err := batch.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headerValue []byte
+		if len(r.Headers) != 0 {
+			headerValue = r.Headers[0].Value
+		}
+		check(i, r.Key, r.Value, headerValue)
+	}
+}
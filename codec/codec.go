@@ -0,0 +1,25 @@
+// Package codec provides implementations of the kafka.Serializer and
+// kafka.Deserializer interfaces which encode the keys and values of messages
+// to JSON. The Protobuf codecs are in the codec/protobuf package, so programs
+// which don't use them don't depend on the protobuf module.
+//
+// The serializers encode nil values to nil bytes, and the deserializers decode
+// nil bytes to nil values, so tombstones go through the codecs unchanged.
+package codec
+
+import "fmt"
+
+// TypeError is returned by serializers which are given a value of a type that
+// they cannot encode.
+type TypeError struct {
+	// Codec is the name of the codec that returned the error.
+	Codec string
+
+	// Value is the value that could not be encoded.
+	Value interface{}
+}
+
+// Error satisfies the error interface.
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("codec: %s cannot encode values of type %T", e.Codec, e.Value)
+}
@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// JSONSerializer is a kafka.Serializer which encodes values to JSON with the
+// encoding/json package.
+type JSONSerializer struct{}
+
+// Serialize satisfies the kafka.Serializer interface.
+func (JSONSerializer) Serialize(topic string, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// JSONDeserializer is a kafka.Deserializer which decodes JSON values with the
+// encoding/json package.
+type JSONDeserializer struct {
+	// New returns the value that the data is decoded into, which must be a
+	// pointer, for example:
+	//
+	//	func() interface{} { return new(Event) }
+	//
+	// When New is nil, the data is decoded into an interface{}, which holds
+	// the generic types of the encoding/json package.
+	New func() interface{}
+}
+
+// NewJSONDeserializer returns a JSONDeserializer which decodes data into new
+// values of the type of v. When v is a pointer, the deserializer returns
+// pointers to the values that it decoded, otherwise it returns the values.
+//
+//	codec.NewJSONDeserializer(Event{})  // returns Event values
+//	codec.NewJSONDeserializer(&Event{}) // returns *Event values
+func NewJSONDeserializer(v interface{}) kafka.Deserializer {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return JSONDeserializer{}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		return JSONDeserializer{
+			New: func() interface{} { return reflect.New(t).Interface() },
+		}
+	}
+
+	return kafka.DeserializerFunc(func(topic string, data []byte) (interface{}, error) {
+		if data == nil {
+			return nil, nil
+		}
+		p := reflect.New(t)
+		if err := json.Unmarshal(data, p.Interface()); err != nil {
+			return nil, err
+		}
+		return p.Elem().Interface(), nil
+	})
+}
+
+// Deserialize satisfies the kafka.Deserializer interface.
+func (d JSONDeserializer) Deserialize(topic string, data []byte) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	if d.New == nil {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	v := d.New()
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type event struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestJSON(t *testing.T) {
+	data, err := JSONSerializer{}.Serialize("topic", event{Name: "A", Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"name":"A","count":1}` {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+
+	tests := []struct {
+		scenario string
+		decoder  kafka.Deserializer
+		value    interface{}
+	}{
+		{
+			scenario: "decoding into the values returned by New",
+			decoder:  JSONDeserializer{New: func() interface{} { return new(event) }},
+			value:    &event{Name: "A", Count: 1},
+		},
+		{
+			scenario: "decoding into pointers to the type of a pointer",
+			decoder:  NewJSONDeserializer(&event{}),
+			value:    &event{Name: "A", Count: 1},
+		},
+		{
+			scenario: "decoding into the type of a value",
+			decoder:  NewJSONDeserializer(event{}),
+			value:    event{Name: "A", Count: 1},
+		},
+		{
+			scenario: "decoding into generic values",
+			decoder:  JSONDeserializer{},
+			value:    map[string]interface{}{"name": "A", "count": 1.0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			v, err := test.decoder.Deserialize("topic", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, test.value) {
+				t.Errorf("expected %#v, got %#v", test.value, v)
+			}
+
+			if _, err := test.decoder.Deserialize("topic", []byte("{")); err == nil {
+				t.Error("expected an error decoding invalid JSON")
+			}
+
+			if v, err := test.decoder.Deserialize("topic", nil); v != nil || err != nil {
+				t.Errorf("expected nil data to be decoded to nil; got %#v, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestJSONSerializerTombstones(t *testing.T) {
+	data, err := JSONSerializer{}.Serialize("topic", nil)
+	if data != nil || err != nil {
+		t.Errorf("expected nil values to be encoded to nil; got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,70 @@
+// Package protobuf provides implementations of the kafka.Serializer and
+// kafka.Deserializer interfaces which encode the keys and values of messages
+// to the Protobuf wire format.
+//
+// Like the codecs of the codec package, the serializer encodes nil values to
+// nil bytes, and the deserializer decodes nil bytes to nil values.
+package protobuf
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/segmentio/kafka-go/codec"
+)
+
+// Serializer is a kafka.Serializer which encodes values implementing the
+// proto.Message interface. It returns a *codec.TypeError for values of other
+// types.
+type Serializer struct{}
+
+// Serialize satisfies the kafka.Serializer interface.
+func (Serializer) Serialize(topic string, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	m, ok := value.(proto.Message)
+	if !ok {
+		return nil, &codec.TypeError{Codec: "protobuf", Value: value}
+	}
+	return proto.Marshal(m)
+}
+
+// Deserializer is a kafka.Deserializer which decodes values into messages of a
+// single type. Deserializers are created by calling NewDeserializer.
+type Deserializer struct {
+	newMessage func() proto.Message
+}
+
+var errNoDeserializer = errors.New("protobuf: the deserializer was not created by NewDeserializer")
+
+// NewDeserializer returns a Deserializer which decodes data into the messages
+// returned by newMessage, for example:
+//
+//	protobuf.NewDeserializer(func() proto.Message { return new(pb.Event) })
+//
+// The function panics if newMessage is nil, or returns a nil message.
+func NewDeserializer(newMessage func() proto.Message) Deserializer {
+	if newMessage == nil {
+		panic("protobuf.NewDeserializer: the message constructor must not be nil")
+	}
+	if newMessage() == nil {
+		panic("protobuf.NewDeserializer: the message constructor returned a nil message")
+	}
+	return Deserializer{newMessage: newMessage}
+}
+
+// Deserialize satisfies the kafka.Deserializer interface.
+func (d Deserializer) Deserialize(topic string, data []byte) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	if d.newMessage == nil {
+		return nil, errNoDeserializer
+	}
+	m := d.newMessage()
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
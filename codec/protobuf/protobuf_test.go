@@ -0,0 +1,73 @@
+package protobuf
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/segmentio/kafka-go/codec"
+)
+
+func TestProtobuf(t *testing.T) {
+	data, err := Serializer{}.Serialize("topic", &wrappers.StringValue{Value: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewDeserializer(func() proto.Message { return new(wrappers.StringValue) }).Deserialize("topic", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(*wrappers.StringValue); !ok || m.Value != "A" {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+}
+
+func TestProtobufNil(t *testing.T) {
+	data, err := Serializer{}.Serialize("topic", nil)
+	if err != nil || data != nil {
+		t.Fatalf("expected nil data, got %q (%v)", data, err)
+	}
+
+	v, err := NewDeserializer(func() proto.Message { return new(wrappers.StringValue) }).Deserialize("topic", nil)
+	if err != nil || v != nil {
+		t.Fatalf("expected a nil value, got %#v (%v)", v, err)
+	}
+}
+
+func TestProtobufErrors(t *testing.T) {
+	if _, err := (Serializer{}).Serialize("topic", "A"); err == nil {
+		t.Error("expected an error serializing a value which is not a proto.Message")
+	} else if _, ok := err.(*codec.TypeError); !ok {
+		t.Errorf("expected a *codec.TypeError, got %T", err)
+	}
+
+	if _, err := (Deserializer{}).Deserialize("topic", []byte{}); err != errNoDeserializer {
+		t.Errorf("expected %v, got %v", errNoDeserializer, err)
+	}
+
+	tests := []struct {
+		scenario   string
+		newMessage func() proto.Message
+	}{
+		{
+			scenario:   "a nil constructor",
+			newMessage: nil,
+		},
+		{
+			scenario:   "a constructor returning nil messages",
+			newMessage: func() proto.Message { return nil },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected NewDeserializer to panic")
+				}
+			}()
+			NewDeserializer(test.newMessage)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package kafka
 
+import "time"
+
 // A commit represents the instruction of publishing an update of the last
 // offset read by a program for a topic and partition.
 type commit struct {
@@ -36,4 +38,12 @@ func makeCommits(msgs ...Message) []commit {
 type commitRequest struct {
 	commits []commit
 	errch   chan<- error
+
+	// offsets is set by CommitOffsets, it holds offsets which are committed
+	// as-is instead of being merged with the offsets of previous commits.
+	offsets offsetStash
+
+	// retention is set by CommitOffsetsWith, it holds the retention time of
+	// the explicit offsets, or zero to apply ReaderConfig.RetentionTime.
+	retention time.Duration
 }
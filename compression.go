@@ -1,7 +1,10 @@
 package kafka
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"io"
 	"sync"
 )
 
@@ -30,6 +33,19 @@ func resolveCodec(code int8) (codec CompressionCodec, err error) {
 	return
 }
 
+// NoCompression is a CompressionCodec which leaves messages uncompressed. It
+// lets WriteMessagesWith write messages uncompressed with a Writer configured
+// with a compression codec.
+var NoCompression CompressionCodec = noCompression{}
+
+type noCompression struct{}
+
+func (noCompression) Code() int8 { return 0 }
+
+func (noCompression) Encode(src []byte) ([]byte, error) { return src, nil }
+
+func (noCompression) Decode(src []byte) ([]byte, error) { return src, nil }
+
 // CompressionCodec represents a compression codec to encode and decode
 // the messages.
 // See : https://cwiki.apache.org/confluence/display/KAFKA/Compression
@@ -47,6 +63,55 @@ type CompressionCodec interface {
 	Decode(src []byte) ([]byte, error)
 }
 
+// StreamingCompressionCodec is implemented by compression codecs which can
+// decompress data incrementally.
+//
+// When the codec of a compressed record batch (kafka 0.11 and above)
+// implements this interface, the records are decoded while the batch is being
+// decompressed, instead of decompressing the whole batch in memory first. The
+// message sets of older versions of the protocol are always decompressed with
+// Decode because the offsets of their messages can only be computed once the
+// whole set was decompressed.
+type StreamingCompressionCodec interface {
+	CompressionCodec
+
+	// NewReader returns a reader decompressing the data read from r. The
+	// reader is closed once the records of the batch have been read, which
+	// may happen before all the data was read from r.
+	NewReader(r io.Reader) io.ReadCloser
+}
+
 const compressionCodecMask int8 = 0x03
 const DefaultCompressionLevel int = -1
 const CompressionNoneCode = 0
+
+// encodeBuffer holds the uncompressed data of a batch while it is passed to
+// CompressionCodec.Encode, the buffers are pooled so producing batches doesn't
+// allocate them over and over.
+//
+// The buffer must only be released once the output of Encode was written,
+// since codecs like NoCompression may return their input.
+type encodeBuffer struct {
+	buf bytes.Buffer
+	w   *bufio.Writer
+}
+
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := &encodeBuffer{}
+		b.w = bufio.NewWriter(&b.buf)
+		return b
+	},
+}
+
+func acquireEncodeBuffer(size int) *encodeBuffer {
+	b := encodeBufferPool.Get().(*encodeBuffer)
+	b.buf.Reset()
+	b.buf.Grow(size)
+	b.w.Reset(&b.buf)
+	return b
+}
+
+func releaseEncodeBuffer(b *encodeBuffer) {
+	encodeBufferPool.Put(b)
+}
@@ -1,10 +1,14 @@
 package kafka_test
 
 import (
+	"bytes"
+	stdgzip "compress/gzip"
 	"context"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,9 +25,125 @@ func TestCompression(t *testing.T) {
 
 	testEncodeDecode(t, msg, gzip.NewCompressionCodec())
 	testEncodeDecode(t, msg, snappy.NewCompressionCodec())
+	testEncodeDecode(t, msg, snappy.NewCompressionCodecWith(snappy.Framed))
 	testEncodeDecode(t, msg, lz4.NewCompressionCodec())
 }
 
+func TestCompressionGzipLevels(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible "), 1000)
+
+	encode := func(level int) []byte {
+		encoded, err := gzip.NewCompressionCodecWith(level).Encode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := gzip.NewCompressionCodec().Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, value) {
+			t.Fatalf("decoded value doesn't match the original value at level %d", level)
+		}
+		return encoded
+	}
+
+	// the levels must be honored even though the writers are pooled, so
+	// interleave them
+	for i := 0; i < 3; i++ {
+		huffman := encode(stdgzip.HuffmanOnly)
+		compressed := encode(stdgzip.BestCompression)
+		if len(huffman) <= len(value)/10 {
+			t.Errorf("expected the value to only be huffman encoded: %d <= %d", len(huffman), len(value)/10)
+		}
+		if len(compressed) >= len(value)/10 {
+			t.Errorf("expected the value to be compressed: %d >= %d", len(compressed), len(value)/10)
+		}
+	}
+
+	// the zero value uses the default level rather than storing the value
+	// uncompressed.
+	if zero, def := encode(0), encode(stdgzip.DefaultCompression); !bytes.Equal(zero, def) {
+		t.Errorf("expected the zero level to use the default compression: %d != %d bytes", len(zero), len(def))
+	}
+
+	if _, err := gzip.NewCompressionCodecWith(42).Encode(value); err == nil {
+		t.Error("expected an error for an invalid compression level")
+	}
+}
+
+func TestCompressionConcurrentEncoders(t *testing.T) {
+	codecs := []kafka.CompressionCodec{
+		gzip.NewCompressionCodec(),
+		gzip.NewCompressionCodecWith(stdgzip.BestSpeed),
+		snappy.NewCompressionCodecWith(snappy.Framed),
+		lz4.NewCompressionCodec(),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := []byte(strings.Repeat(strconv.Itoa(i), 1000+i))
+			for j := 0; j < 100; j++ {
+				codec := codecs[j%len(codecs)]
+				encoded, err := codec.Encode(value)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				decoded, err := codec.Decode(encoded)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(decoded, value) {
+					t.Errorf("%s: decoded value doesn't match the original value", codecToStr(codec.Code()))
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCompressionSnappyFraming(t *testing.T) {
+	// larger than the size of xerial chunks to produce multiple of them
+	value := make([]byte, 100*1024)
+	rand.New(rand.NewSource(0)).Read(value[:len(value)/2])
+
+	xerialHeader := []byte{130, 83, 78, 65, 80, 80, 89, 0}
+
+	for _, framing := range []snappy.Framing{snappy.Unframed, snappy.Framed} {
+		t.Run(fmt.Sprintf("framing=%d", framing), func(t *testing.T) {
+			encoded, err := snappy.NewCompressionCodecWith(framing).Encode(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			framed := bytes.HasPrefix(encoded, xerialHeader)
+			if framed != (framing == snappy.Framed) {
+				t.Errorf("expected xerial header to be present: %v", !framed)
+			}
+
+			// the framing is detected when decoding regardless of the
+			// configuration of the codec
+			for _, codec := range []snappy.CompressionCodec{
+				snappy.NewCompressionCodecWith(snappy.Unframed),
+				snappy.NewCompressionCodecWith(snappy.Framed),
+			} {
+				decoded, err := codec.Decode(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, value) {
+					t.Error("decoded value doesn't match the original value")
+				}
+			}
+		})
+	}
+}
+
 func testEncodeDecode(t *testing.T, m kafka.Message, codec kafka.CompressionCodec) {
 	var r1, r2 []byte
 	var err error
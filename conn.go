@@ -2,13 +2,16 @@ package kafka
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,11 +32,32 @@ type Broker struct {
 
 // Partition carries the metadata associated with a kafka partition.
 type Partition struct {
-	Topic    string
-	Leader   Broker
+	Topic string
+
+	// Leader is the broker hosting the leader replica of the partition. Only
+	// the ID is set, to -1, when the partition is offline because it has no
+	// leader.
+	Leader Broker
+
+	// LeaderEpoch is the epoch of the partition leader, it is incremented each
+	// time a new leader is elected. The value is -1 if the kafka server doesn't
+	// report leader epochs (before kafka 2.1).
+	LeaderEpoch int
+
+	// Replicas is the list of brokers hosting a replica of the partition.
 	Replicas []Broker
-	Isr      []Broker
-	ID       int
+
+	// Isr is the list of replicas which are in sync with the leader, the
+	// partition is under-replicated when it has fewer brokers than Replicas.
+	Isr []Broker
+
+	// OfflineReplicas is the list of replicas hosted on brokers which are down
+	// or on log directories which failed, it is always empty before kafka 1.0.
+	// Only the IDs are set for the brokers which are not part of the cluster
+	// anymore.
+	OfflineReplicas []Broker
+
+	ID int
 }
 
 // Conn represents a connection to a kafka broker.
@@ -47,6 +71,16 @@ type Conn struct {
 	mutex  sync.Mutex
 	offset int64
 
+	// broker that the connection is established to, the node ID is -1 until it
+	// is known, brokerLookedUp is true once Broker looked it up in the metadata
+	// of the cluster (synchronized on the mutex field)
+	broker         Broker
+	brokerLookedUp bool
+
+	// client instance ID assigned by the broker for telemetry (KIP-714), zero
+	// until it is known (synchronized on the mutex field)
+	clientInstanceID uuid
+
 	// read buffer (synchronized on rlock)
 	rlock sync.Mutex
 	rbuf  bufio.Reader
@@ -66,13 +100,31 @@ type Conn struct {
 	fetchMaxBytes int32
 	fetchMinSize  int32
 
+	// leader epoch of the partition sent in fetch requests, -1 if unknown
+	leaderEpoch int32
+
+	// incremental fetch session held with the broker by ReadBatchWith
+	// (synchronized on the mutex field)
+	fetchSession fetchSession
+
 	// correlation ID generator (synchronized on wlock)
 	correlationID int32
 
 	// number of replica acks required when publishing to a partition
 	requiredAcks int32
 	apiVersions  map[apiKey]ApiVersion
+	versions     map[apiKey]apiVersion
 	fetchVersion apiVersion
+
+	// throttle time of the last produce or fetch response, and the time until
+	// which requests are delayed to honor it (synchronized on throttleMutex)
+	throttleMutex sync.Mutex
+	throttle      time.Duration
+	throttleUntil time.Time
+
+	// function called after each round trip, set by OnRequestComplete (holds
+	// a func(int16, time.Duration))
+	requestComplete atomic.Value
 }
 
 // ConnConfig is a configuration object used to create new instances of Conn.
@@ -80,17 +132,87 @@ type ConnConfig struct {
 	ClientID  string
 	Topic     string
 	Partition int
+
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers that
+	// the connection reads responses and writes requests through. Larger
+	// buffers reduce the number of system calls made to transfer large
+	// batches of messages over fast networks.
+	//
+	// The default, which is also the minimum, is 4KB.
+	ReadBufferSize  int
+	WriteBufferSize int
 }
 
 // ReadBatchConfig is a configuration object used for reading batches of messages.
 type ReadBatchConfig struct {
+	// MinBytes indicates to the broker the minimum batch size that the consumer
+	// will accept. Setting a high minimum when consuming from a low-volume topic
+	// may result in delayed delivery when the broker does not have enough data to
+	// satisfy the defined minimum.
 	MinBytes int
+
+	// MaxBytes indicates to the broker the maximum batch size that the consumer
+	// will accept. The broker will truncate a message to satisfy this maximum, so
+	// choose a value that is high enough for your largest message size.
 	MaxBytes int
 
+	// PartitionMaxBytes is the maximum number of bytes of records that the
+	// broker returns for the partition, while MaxBytes bounds the size of the
+	// whole response. The broker always returns the first record batch of the
+	// partition, even when it exceeds the limit. Fetch requests older than
+	// kafka 0.10.1 only carry the limit of the partition, which is then used
+	// for the whole response.
+	//
+	// Default: MaxBytes
+	PartitionMaxBytes int
+
+	// MaxWait is the amount of time for the broker to wait while trying to hit
+	// the MinBytes requirement before returning.
+	//
+	// When zero, the broker waits until the read deadline of the connection is
+	// reached (minus the expected round trip time). When both are set, the
+	// shortest of the two is used.
+	MaxWait time.Duration
+
 	// IsolationLevel controls the visibility of transactional records.
 	// ReadUncommitted makes all records visible. With ReadCommitted only
 	// non-transactional and committed records are visible.
 	IsolationLevel IsolationLevel
+
+	// CheckCRCs controls whether the CRC32C checksums of the record batches are
+	// validated, reading a batch fails with ErrCorruptBatch when they mismatch.
+	// Only the record batches of the message format introduced by kafka 0.11
+	// carry a CRC32C checksum.
+	//
+	// Validating the checksums requires reading each record batch in memory
+	// before decoding its records, programs that need to read messages as fast
+	// as possible may set the field to SkipCRCs.
+	//
+	// Default: ValidateCRCs
+	CheckCRCs CRCValidation
+
+	// RackID is the rack of the consumer, which lets the partition leader
+	// designate a replica in the same rack to fetch from (KIP-392), reported
+	// by Batch.PreferredReadReplica. The leader only does so when the brokers
+	// are configured with a replica selector, which requires kafka 2.4 or
+	// above.
+	RackID string
+
+	// FetchSession makes the connection fetch the partition within an
+	// incremental fetch session (KIP-227). The first fetch request creates the
+	// session on the broker, which then caches the fetch parameters of the
+	// partition: the following requests only carry the partition when its
+	// offset changed, and the responses omit it when it has no new messages.
+	// When the broker evicted the session, reading the batch fails with
+	// FetchSessionIDNotFound or InvalidFetchSessionEpoch and the next fetch
+	// request establishes a new session.
+	//
+	// The connection holds a single session for its partition, batches should
+	// not be read with the option by multiple goroutines at once. Each session
+	// takes one of the slots of the session cache of the broker, which are
+	// shared by all clients (see ReaderConfig.FetchSessions). Fetch sessions
+	// require kafka 2.1 or above, the option is ignored for older brokers.
+	FetchSession bool
 }
 
 type IsolationLevel int8
@@ -100,6 +222,19 @@ const (
 	ReadCommitted   IsolationLevel = 1
 )
 
+// CRCValidation is an enumeration of the ways to check the CRCs of the record
+// batches read from kafka.
+type CRCValidation int8
+
+const (
+	ValidateCRCs CRCValidation = 0
+	SkipCRCs     CRCValidation = 1
+)
+
+// defaultBufferSize is the default size of the read and write buffers of
+// connections.
+const defaultBufferSize = 4096
+
 var (
 	// DefaultClientID is the default value used as ClientID of kafka
 	// connections.
@@ -131,15 +266,32 @@ func NewConnWith(conn net.Conn, config ConnConfig) *Conn {
 		panic(fmt.Sprintf("invalid partition number: %d", config.Partition))
 	}
 
+	if config.ReadBufferSize < defaultBufferSize {
+		config.ReadBufferSize = defaultBufferSize
+	}
+
+	if config.WriteBufferSize < defaultBufferSize {
+		config.WriteBufferSize = defaultBufferSize
+	}
+
 	c := &Conn{
 		conn:         conn,
-		rbuf:         *bufio.NewReader(conn),
-		wbuf:         *bufio.NewWriter(conn),
+		rbuf:         *bufio.NewReaderSize(conn, config.ReadBufferSize),
+		wbuf:         *bufio.NewWriterSize(conn, config.WriteBufferSize),
 		clientID:     config.ClientID,
 		topic:        config.Topic,
 		partition:    int32(config.Partition),
 		offset:       FirstOffset,
 		requiredAcks: -1,
+		leaderEpoch:  -1,
+		broker:       Broker{ID: -1},
+	}
+
+	if addr := conn.RemoteAddr(); addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			c.broker.Host = host
+			c.broker.Port, _ = strconv.Atoi(port)
+		}
 	}
 
 	// The fetch request needs to ask for a MaxBytes value that is at least
@@ -170,20 +322,87 @@ func (c *Conn) selectVersions() {
 			c.apiVersions[apiKey(v.ApiKey)] = v
 		}
 	}
-	for _, v := range c.apiVersions {
-		if apiKey(v.ApiKey) == fetchRequest {
-			if v.MaxVersion >= 5 {
-				c.fetchVersion = 5
-			} else {
-				c.fetchVersion = 2
+	c.versions = negotiateVersions(c.apiVersions, clientApiVersions)
+	c.fetchVersion = c.negotiatedVersionOrLowest(fetchRequest)
+}
+
+// clientApiVersions lists the versions implemented by the client of the
+// requests which can be sent using multiple versions, in ascending order.
+var clientApiVersions = map[apiKey][]apiVersion{
+	produceRequest:                     {v2, v3},
+	fetchRequest:                       {v2, v5, v9, v11},
+	metadataRequest:                    {v1, v7},
+	offsetCommitRequest:                {v2, v6},
+	saslHandshakeRequest:               {v0, v1},
+	joinGroupRequest:                   {v1, v5},
+	syncGroupRequest:                   {v0, v3},
+	heartbeatRequest:                   {v0, v3},
+	groupCoordinatorRequest:            {v0, v1},
+	describeAclsRequest:                {v1},
+	createAclsRequest:                  {v1},
+	deleteAclsRequest:                  {v1},
+	describeLogDirsRequest:             {v0},
+	electLeadersRequest:                {v1},
+	alterPartitionReassignmentsRequest: {v0},
+	listPartitionReassignmentsRequest:  {v0},
+	deleteRecordsRequest:               {v0},
+	offsetForLeaderEpochRequest:        {v2},
+	initProducerIDRequest:              {v0},
+	addPartitionsToTxnRequest:          {v0},
+	addOffsetsToTxnRequest:             {v0},
+	endTxnRequest:                      {v0},
+	txnOffsetCommitRequest:             {v0},
+	getTelemetrySubscriptionsRequest:   {v0},
+}
+
+// negotiateVersions returns the highest version of each request that is
+// supported by both the client and the broker. Requests for which there are no
+// versions in common are omitted from the returned map.
+func negotiateVersions(broker map[apiKey]ApiVersion, client map[apiKey][]apiVersion) map[apiKey]apiVersion {
+	versions := make(map[apiKey]apiVersion, len(client))
+
+	for key, clientVersions := range client {
+		brokerVersions, ok := broker[key]
+		if !ok {
+			continue
+		}
+		for i := len(clientVersions) - 1; i >= 0; i-- {
+			if v := int16(clientVersions[i]); v >= brokerVersions.MinVersion && v <= brokerVersions.MaxVersion {
+				versions[key] = clientVersions[i]
+				break
 			}
 		}
 	}
+
+	return versions
+}
+
+// negotiatedVersion returns the version of the request to send on the
+// connection, or UnsupportedVersion if the broker doesn't support any of the
+// versions implemented by the client.
+func (c *Conn) negotiatedVersion(key apiKey) (apiVersion, error) {
+	v, ok := c.versions[key]
+	if !ok {
+		return 0, UnsupportedVersion
+	}
+	return v, nil
+}
+
+// negotiatedVersionOrLowest is like negotiatedVersion but falls back to the
+// lowest version implemented by the client when no versions could be
+// negotiated, for example because the broker is too old to report the versions
+// that it supports.
+func (c *Conn) negotiatedVersionOrLowest(key apiKey) apiVersion {
+	if v, err := c.negotiatedVersion(key); err == nil {
+		return v
+	}
+	return clientApiVersions[key][0]
 }
 
 // Controller requests kafka for the current controller and returns its URL
 func (c *Conn) Controller() (broker Broker, err error) {
 	err = c.readOperation(
+		metadataRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(metadataRequest, v1, id, topicMetadataRequestV1([]string{}))
 		},
@@ -208,10 +427,51 @@ func (c *Conn) Controller() (broker Broker, err error) {
 	return broker, err
 }
 
+// Broker returns the broker that the connection is established to.
+//
+// The node ID and rack of the broker are known when the connection was opened
+// to a partition leader by DialLeader or DialPartition. Otherwise the first
+// call sends a metadata request on the connection to look them up, which blocks
+// until the response is received or the deadline of the connection expires,
+// and the node ID is -1 if the broker couldn't be found, which happens when the
+// brokers advertise addresses which differ from the one that the connection
+// was established to. The result of the lookup is kept for the following
+// calls, which return without sending requests, unless the metadata request
+// failed.
+func (c *Conn) Broker() Broker {
+	c.mutex.Lock()
+	broker, lookedUp := c.broker, c.brokerLookedUp
+	c.mutex.Unlock()
+
+	if broker.ID >= 0 || lookedUp {
+		return broker
+	}
+
+	brokers, err := c.Brokers()
+	if err != nil {
+		return broker
+	}
+
+	remote := c.conn.RemoteAddr().String()
+	for _, b := range brokers {
+		addr := net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+		if (b.Host == broker.Host && b.Port == broker.Port) || addr == remote {
+			broker = b
+			break
+		}
+	}
+
+	c.mutex.Lock()
+	c.broker, c.brokerLookedUp = broker, true
+	c.mutex.Unlock()
+	return broker
+}
+
 // Brokers retrieve the broker list from the Kafka metadata
 func (c *Conn) Brokers() ([]Broker, error) {
 	var brokers []Broker
 	err := c.readOperation(
+		metadataRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(metadataRequest, v1, id, topicMetadataRequestV1([]string{}))
 		},
@@ -252,6 +512,7 @@ func (c *Conn) describeGroups(request describeGroupsRequestV0) (describeGroupsRe
 	var response describeGroupsResponseV0
 
 	err := c.readOperation(
+		describeGroupsRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(describeGroupsRequest, v0, id, request)
 		},
@@ -280,6 +541,7 @@ func (c *Conn) findCoordinator(request findCoordinatorRequestV0) (findCoordinato
 	var response findCoordinatorResponseV0
 
 	err := c.readOperation(
+		groupCoordinatorRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(groupCoordinatorRequest, v0, id, request)
 
@@ -300,6 +562,70 @@ func (c *Conn) findCoordinator(request findCoordinatorRequestV0) (findCoordinato
 	return response, nil
 }
 
+// findCoordinatorV1 is like findCoordinator but sends the type of the key,
+// which lets it look up the coordinators of transactions.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_FindCoordinator
+func (c *Conn) findCoordinatorV1(request findCoordinatorRequestV1) (findCoordinatorResponseV1, error) {
+	var response findCoordinatorResponseV1
+
+	err := c.readOperation(
+		groupCoordinatorRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(groupCoordinatorRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return findCoordinatorResponseV1{}, err
+	}
+	if response.ErrorCode != 0 {
+		return findCoordinatorResponseV1{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
+// lookupCoordinator returns the coordinator for key, using the version of
+// the FindCoordinator request supported by the broker. Only group
+// coordinators can be looked up from brokers which do not support the
+// version 1 of the request (before kafka 0.11).
+func (c *Conn) lookupCoordinator(keyType CoordinatorKeyType, key string) (Broker, error) {
+	var coordinator findCoordinatorResponseCoordinatorV0
+
+	if v := c.negotiatedVersionOrLowest(groupCoordinatorRequest); v >= v1 {
+		response, err := c.findCoordinatorV1(findCoordinatorRequestV1{
+			CoordinatorKey:  key,
+			CoordinatorType: int8(keyType),
+		})
+		if err != nil {
+			return Broker{}, err
+		}
+		coordinator = response.Coordinator
+	} else {
+		if keyType != GroupCoordinator {
+			return Broker{}, UnsupportedVersion
+		}
+		response, err := c.findCoordinator(findCoordinatorRequestV0{
+			CoordinatorKey: key,
+		})
+		if err != nil {
+			return Broker{}, err
+		}
+		coordinator = response.Coordinator
+	}
+
+	return Broker{
+		Host: coordinator.Host,
+		Port: int(coordinator.Port),
+		ID:   int(coordinator.NodeID),
+	}, nil
+}
+
 // heartbeat sends a heartbeat message required by consumer groups
 //
 // See http://kafka.apache.org/protocol.html#The_Messages_Heartbeat
@@ -307,6 +633,7 @@ func (c *Conn) heartbeat(request heartbeatRequestV0) (heartbeatResponseV0, error
 	var response heartbeatResponseV0
 
 	err := c.writeOperation(
+		heartbeatRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(heartbeatRequest, v0, id, request)
 		},
@@ -326,6 +653,38 @@ func (c *Conn) heartbeat(request heartbeatRequestV0) (heartbeatResponseV0, error
 	return response, nil
 }
 
+// heartbeatV3 sends a heartbeat message on behalf of a static member of a
+// consumer group, it requires kafka 2.3 or above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_Heartbeat
+func (c *Conn) heartbeatV3(request heartbeatRequestV3) (heartbeatResponseV3, error) {
+	var response heartbeatResponseV3
+
+	if v, err := c.negotiatedVersion(heartbeatRequest); err != nil || v < v3 {
+		return response, UnsupportedVersion
+	}
+
+	err := c.writeOperation(
+		heartbeatRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(heartbeatRequest, v3, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return heartbeatResponseV3{}, err
+	}
+	if response.ErrorCode != 0 {
+		return heartbeatResponseV3{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
 // joinGroup attempts to join a consumer group
 //
 // See http://kafka.apache.org/protocol.html#The_Messages_JoinGroup
@@ -333,6 +692,7 @@ func (c *Conn) joinGroup(request joinGroupRequestV1) (joinGroupResponseV1, error
 	var response joinGroupResponseV1
 
 	err := c.writeOperation(
+		joinGroupRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(joinGroupRequest, v1, id, request)
 		},
@@ -352,6 +712,38 @@ func (c *Conn) joinGroup(request joinGroupRequestV1) (joinGroupResponseV1, error
 	return response, nil
 }
 
+// joinGroupV5 attempts to join a consumer group as a static member, it
+// requires kafka 2.3 or above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_JoinGroup
+func (c *Conn) joinGroupV5(request joinGroupRequestV5) (joinGroupResponseV5, error) {
+	var response joinGroupResponseV5
+
+	if v, err := c.negotiatedVersion(joinGroupRequest); err != nil || v < v5 {
+		return response, UnsupportedVersion
+	}
+
+	err := c.writeOperation(
+		joinGroupRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(joinGroupRequest, v5, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return joinGroupResponseV5{}, err
+	}
+	if response.ErrorCode != 0 {
+		return joinGroupResponseV5{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
 // leaveGroup leaves the consumer from the consumer group
 //
 // See http://kafka.apache.org/protocol.html#The_Messages_LeaveGroup
@@ -359,6 +751,7 @@ func (c *Conn) leaveGroup(request leaveGroupRequestV0) (leaveGroupResponseV0, er
 	var response leaveGroupResponseV0
 
 	err := c.writeOperation(
+		leaveGroupRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(leaveGroupRequest, v0, id, request)
 		},
@@ -378,6 +771,43 @@ func (c *Conn) leaveGroup(request leaveGroupRequestV0) (leaveGroupResponseV0, er
 	return response, nil
 }
 
+// leaveGroupV3 removes members from a consumer group, including static members
+// identified by their group instance ID, it requires kafka 2.4 or above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_LeaveGroup
+func (c *Conn) leaveGroupV3(request leaveGroupRequestV3) (leaveGroupResponseV3, error) {
+	var response leaveGroupResponseV3
+
+	if v, err := c.negotiatedVersion(leaveGroupRequest); err != nil || v < v3 {
+		return response, UnsupportedVersion
+	}
+
+	err := c.writeOperation(
+		leaveGroupRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(leaveGroupRequest, v3, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return leaveGroupResponseV3{}, err
+	}
+	if response.ErrorCode != 0 {
+		return leaveGroupResponseV3{}, Error(response.ErrorCode)
+	}
+	for _, m := range response.Members {
+		if m.ErrorCode != 0 {
+			return leaveGroupResponseV3{}, Error(m.ErrorCode)
+		}
+	}
+
+	return response, nil
+}
+
 // listGroups lists all the consumer groups
 //
 // See http://kafka.apache.org/protocol.html#The_Messages_ListGroups
@@ -385,6 +815,7 @@ func (c *Conn) listGroups(request listGroupsRequestV1) (listGroupsResponseV1, er
 	var response listGroupsResponseV1
 
 	err := c.readOperation(
+		listGroupsRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(listGroupsRequest, v1, id, request)
 		},
@@ -411,6 +842,7 @@ func (c *Conn) offsetCommit(request offsetCommitRequestV2) (offsetCommitResponse
 	var response offsetCommitResponseV2
 
 	err := c.writeOperation(
+		offsetCommitRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(offsetCommitRequest, v2, id, request)
 		},
@@ -426,7 +858,47 @@ func (c *Conn) offsetCommit(request offsetCommitRequestV2) (offsetCommitResponse
 	for _, r := range response.Responses {
 		for _, pr := range r.PartitionResponses {
 			if pr.ErrorCode != 0 {
-				return offsetCommitResponseV2{}, Error(pr.ErrorCode)
+				// The response is returned along with the error so callers can
+				// inspect the error code of each partition.
+				return response, Error(pr.ErrorCode)
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// offsetCommitV6 commits the specified topic partition offsets along with the
+// leader epochs of the partitions, it requires kafka 2.1 or above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_OffsetCommit
+func (c *Conn) offsetCommitV6(request offsetCommitRequestV6) (offsetCommitResponseV6, error) {
+	var response offsetCommitResponseV6
+
+	if v, err := c.negotiatedVersion(offsetCommitRequest); err != nil || v < v6 {
+		return response, UnsupportedVersion
+	}
+
+	err := c.writeOperation(
+		offsetCommitRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(offsetCommitRequest, v6, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return offsetCommitResponseV6{}, err
+	}
+	for _, r := range response.Responses {
+		for _, pr := range r.PartitionResponses {
+			if pr.ErrorCode != 0 {
+				// The response is returned along with the error so callers can
+				// inspect the error code of each partition.
+				return response, Error(pr.ErrorCode)
 			}
 		}
 	}
@@ -442,6 +914,7 @@ func (c *Conn) offsetFetch(request offsetFetchRequestV1) (offsetFetchResponseV1,
 	var response offsetFetchResponseV1
 
 	err := c.readOperation(
+		offsetFetchRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(offsetFetchRequest, v1, id, request)
 		},
@@ -472,6 +945,7 @@ func (c *Conn) syncGroups(request syncGroupRequestV0) (syncGroupResponseV0, erro
 	var response syncGroupResponseV0
 
 	err := c.readOperation(
+		syncGroupRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(syncGroupRequest, v0, id, request)
 		},
@@ -491,6 +965,38 @@ func (c *Conn) syncGroups(request syncGroupRequestV0) (syncGroupResponseV0, erro
 	return response, nil
 }
 
+// syncGroupsV3 completes the handshake to join a consumer group as a static
+// member, it requires kafka 2.3 or above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_SyncGroup
+func (c *Conn) syncGroupsV3(request syncGroupRequestV3) (syncGroupResponseV3, error) {
+	var response syncGroupResponseV3
+
+	if v, err := c.negotiatedVersion(syncGroupRequest); err != nil || v < v3 {
+		return response, UnsupportedVersion
+	}
+
+	err := c.readOperation(
+		syncGroupRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(syncGroupRequest, v3, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return syncGroupResponseV3{}, err
+	}
+	if response.ErrorCode != 0 {
+		return syncGroupResponseV3{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
 // Close closes the kafka connection.
 func (c *Conn) Close() error {
 	return c.conn.Close()
@@ -506,6 +1012,38 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
+// NetConn returns the network connection that c was created with, to set socket
+// options that the Dialer does not expose, like the keep-alive parameters of
+// TCP connections. It is a *tls.Conn when the connection uses TLS.
+//
+// The connection must not be read from, written to, nor have its deadlines
+// set, which would corrupt the state of the kafka protocol on c.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// OnRequestComplete installs f to be called after each round trip to the
+// broker, with the API key of the request (for example 0 for produce and 1 for
+// fetch requests) and the time elapsed between writing the request and
+// receiving its response. Note that fetch requests are held by the broker
+// until enough data is available or their max wait time elapsed, and that
+// produce requests which don't require acknowledges have no response.
+//
+// f is called by the goroutine which performed the request while it holds the
+// connection's read lock, it must be fast and must not use the connection.
+// Passing nil removes the function.
+func (c *Conn) OnRequestComplete(f func(apiKey int16, duration time.Duration)) {
+	c.requestComplete.Store(f)
+}
+
+// observeRequest reports the duration of the round trip of a request of api
+// which started at start to the function set by OnRequestComplete.
+func (c *Conn) observeRequest(api apiKey, start time.Time) {
+	if f, _ := c.requestComplete.Load().(func(int16, time.Duration)); f != nil {
+		f(int16(api), time.Since(start))
+	}
+}
+
 // SetDeadline sets the read and write deadlines associated with the connection.
 // It is equivalent to calling both SetReadDeadline and SetWriteDeadline.
 //
@@ -662,6 +1200,64 @@ func (c *Conn) ReadMessage(maxBytes int) (Message, error) {
 	return msg, coalesceErrors(silentEOF(err), batch.Close())
 }
 
+// ErrNoMessageAtOffset is returned by ReadMessageAt when the offset is within
+// the range of offsets of the partition but holds no message, because it was
+// removed by log compaction or is a control record of a transaction.
+var ErrNoMessageAtOffset = errors.New("kafka: no message at the offset")
+
+// readMessageAtBytes is the max bytes of the first fetch request sent by
+// ReadMessageAt, doubled until the message fits in the response.
+const readMessageAtBytes = 64 * 1024
+
+// ReadMessageAt reads the message at offset from the connection's partition,
+// without reading the messages which follow it. It fails with OffsetOutOfRange
+// if the offset is not within the range of offsets of the partition, or with
+// ErrNoMessageAtOffset if there is no message at this offset.
+//
+// The broker responds with whole batches, which start before the offset when
+// it falls inside a compressed batch, the messages preceding the offset are
+// decoded and skipped. Like ReadMessage, the offset of the connection is moved
+// to the offset following the message on success.
+func (c *Conn) ReadMessageAt(offset int64) (Message, error) {
+	first, last, err := c.ReadOffsets()
+	if err != nil {
+		return Message{}, err
+	}
+	if offset < first || offset >= last {
+		return Message{}, OffsetOutOfRange
+	}
+
+	c.mutex.Lock()
+	c.offset = offset
+	c.mutex.Unlock()
+
+	for maxBytes := readMessageAtBytes; ; maxBytes *= 2 {
+		if maxBytes > int(c.fetchMaxBytes) {
+			maxBytes = int(c.fetchMaxBytes)
+		}
+
+		batch := c.ReadBatch(1, maxBytes)
+		msg, err := batch.ReadMessage()
+		truncated := err == io.EOF
+		if err = coalesceErrors(silentEOF(err), batch.Close()); err != nil {
+			return Message{}, err
+		}
+
+		switch next, _ := c.Offset(); {
+		case !truncated && msg.Offset == offset:
+			return msg, nil
+		case !truncated, next > offset:
+			// The first message of the response follows the offset, or
+			// the control records at the offset were skipped.
+			return Message{}, ErrNoMessageAtOffset
+		case maxBytes == int(c.fetchMaxBytes):
+			return Message{}, io.ErrUnexpectedEOF
+		}
+		// The message did not fit in maxBytes, older brokers truncate the
+		// response instead of returning messages larger than the limit.
+	}
+}
+
 // ReadBatch reads a batch of messages from the kafka server. The method always
 // returns a non-nil Batch value. If an error occurred, either sending the fetch
 // request or reading the response, the error will be made available by the
@@ -697,17 +1293,74 @@ func (c *Conn) ReadBatchWith(cfg ReadBatchConfig) *Batch {
 	if cfg.MinBytes > cfg.MaxBytes {
 		return &Batch{err: fmt.Errorf("kafka.(*Conn).ReadBatch: minBytes (%d) > maxBytes (%d)", cfg.MinBytes, cfg.MaxBytes)}
 	}
+	if cfg.PartitionMaxBytes < 0 || cfg.PartitionMaxBytes > maxFetch {
+		return &Batch{err: fmt.Errorf("kafka.(*Conn).ReadBatch: partitionMaxBytes of %d out of [1,%d] bounds", cfg.PartitionMaxBytes, maxFetch)}
+	}
+	if cfg.MaxWait < 0 || (cfg.MaxWait/time.Millisecond) > math.MaxInt32 {
+		return &Batch{err: fmt.Errorf("kafka.(*Conn).ReadBatch: maxWait of %s out of bounds", cfg.MaxWait)}
+	}
 
 	offset, err := c.Seek(c.Offset())
 	if err != nil {
 		return &Batch{err: dontExpectEOF(err)}
 	}
 
-	id, err := c.doRequest(&c.rdeadline, func(deadline time.Time, id int32) error {
+	maxBytes := cfg.MaxBytes + int(c.fetchMinSize)
+	partitionMaxBytes := maxBytes
+	if cfg.PartitionMaxBytes != 0 {
+		partitionMaxBytes = cfg.PartitionMaxBytes
+	}
+
+	useSession := cfg.FetchSession && c.fetchVersion >= v9
+	session := noFetchSession
+
+	id, start, err := c.doRequest(&c.rdeadline, func(deadline time.Time, id int32) error {
 		now := time.Now()
 		deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
 		adjustedDeadline = deadline
+		timeout := deadlineToTimeout(deadline, now)
+		if cfg.MaxWait != 0 && cfg.MaxWait < timeout {
+			timeout = cfg.MaxWait
+		}
+		if useSession {
+			c.mutex.Lock()
+			session = c.fetchSession.next(offset, partitionMaxBytes, c.leaderEpoch)
+			c.mutex.Unlock()
+		}
 		switch c.fetchVersion {
+		case v11:
+			return writeFetchRequestV11(
+				&c.wbuf,
+				id,
+				c.clientID,
+				c.topic,
+				c.partition,
+				c.leaderEpoch,
+				offset,
+				cfg.MinBytes,
+				maxBytes,
+				partitionMaxBytes,
+				timeout,
+				int8(cfg.IsolationLevel),
+				session,
+				cfg.RackID,
+			)
+		case v9:
+			return writeFetchRequestV9(
+				&c.wbuf,
+				id,
+				c.clientID,
+				c.topic,
+				c.partition,
+				c.leaderEpoch,
+				offset,
+				cfg.MinBytes,
+				maxBytes,
+				partitionMaxBytes,
+				timeout,
+				int8(cfg.IsolationLevel),
+				session,
+			)
 		case v5:
 			return writeFetchRequestV5(
 				&c.wbuf,
@@ -717,8 +1370,9 @@ func (c *Conn) ReadBatchWith(cfg ReadBatchConfig) *Batch {
 				c.partition,
 				offset,
 				cfg.MinBytes,
-				cfg.MaxBytes+int(c.fetchMinSize),
-				deadlineToTimeout(deadline, now),
+				maxBytes,
+				partitionMaxBytes,
+				timeout,
 				int8(cfg.IsolationLevel),
 			)
 		default:
@@ -730,57 +1384,156 @@ func (c *Conn) ReadBatchWith(cfg ReadBatchConfig) *Batch {
 				c.partition,
 				offset,
 				cfg.MinBytes,
-				cfg.MaxBytes+int(c.fetchMinSize),
-				deadlineToTimeout(deadline, now),
+				partitionMaxBytes,
+				timeout,
 			)
 		}
 	})
-	if err != nil {
-		return &Batch{err: dontExpectEOF(err)}
+	var size int
+	var lock *sync.Mutex
+	if err == nil {
+		_, size, lock, err = c.waitResponse(&c.rdeadline, id)
 	}
-
-	_, size, lock, err := c.waitResponse(&c.rdeadline, id)
 	if err != nil {
+		if useSession {
+			c.mutex.Lock()
+			c.fetchSession.update(0, err)
+			c.mutex.Unlock()
+		}
 		return &Batch{err: dontExpectEOF(err)}
 	}
+	c.observeRequest(fetchRequest, start)
 
 	var throttle int32
+	var sessionID int32
 	var highWaterMark int64
+	var logStartOffset int64 = -1
+	var preferredReadReplica int32 = -1
 	var remain int
 
 	switch c.fetchVersion {
+	case v11:
+		throttle, sessionID, highWaterMark, logStartOffset, preferredReadReplica, remain, err = readFetchResponseHeaderV11(&c.rbuf, size)
+	case v9:
+		throttle, sessionID, highWaterMark, logStartOffset, remain, err = readFetchResponseHeaderV9(&c.rbuf, size)
 	case v5:
-		throttle, highWaterMark, remain, err = readFetchResponseHeaderV5(&c.rbuf, size)
+		throttle, highWaterMark, logStartOffset, remain, err = readFetchResponseHeaderV5(&c.rbuf, size)
 	default:
 		throttle, highWaterMark, remain, err = readFetchResponseHeaderV2(&c.rbuf, size)
 	}
+	c.setThrottle(duration(throttle))
 	if err == errShortRead {
 		err = checkTimeoutErr(adjustedDeadline)
 	}
 
+	// Incremental fetch responses omit the partition when it has no new
+	// messages, the watermarks of the last response that carried it apply.
+	omitted := false
+	if useSession {
+		c.mutex.Lock()
+		c.fetchSession.update(sessionID, err)
+		if err == nil {
+			if omitted = highWaterMark < 0; omitted {
+				highWaterMark, logStartOffset = c.fetchSession.highWaterMark, c.fetchSession.logStartOffset
+			} else {
+				c.fetchSession.highWaterMark, c.fetchSession.logStartOffset = highWaterMark, logStartOffset
+			}
+		}
+		c.mutex.Unlock()
+	}
+
+	if _, ok := err.(Error); ok && remain > 0 {
+		// The connection remains open when the partition has an error, the
+		// rest of the response is skipped so it doesn't get in the way of the
+		// next one.
+		if _, discardErr := discardN(&c.rbuf, remain, remain); discardErr != nil {
+			err = discardErr
+		}
+	}
+
 	var msgs *messageSetReader
 	if err == nil {
-		if highWaterMark == offset {
+		if highWaterMark == offset || omitted || (preferredReadReplica >= 0 && remain == 0) {
+			// The partition leader sends no messages when it designates
+			// another replica to fetch from.
 			msgs = &messageSetReader{empty: true}
 		} else {
-			msgs, err = newMessageSetReader(&c.rbuf, remain)
+			if msgs, err = newMessageSetReader(&c.rbuf, remain); err == nil {
+				msgs.v2.checkCRC = cfg.CheckCRCs != SkipCRCs
+			}
 		}
 	}
 	if err == errShortRead {
 		err = checkTimeoutErr(adjustedDeadline)
 	}
 	return &Batch{
-		conn:          c,
-		msgs:          msgs,
-		deadline:      adjustedDeadline,
-		throttle:      duration(throttle),
-		lock:          lock,
-		topic:         c.topic,          // topic is copied to Batch to prevent race with Batch.close
-		partition:     int(c.partition), // partition is copied to Batch to prevent race with Batch.close
-		offset:        offset,
-		highWaterMark: highWaterMark,
-		err:           dontExpectEOF(err),
+		conn:           c,
+		msgs:           msgs,
+		deadline:       adjustedDeadline,
+		throttle:       duration(throttle),
+		lock:           lock,
+		topic:          c.topic,          // topic is copied to Batch to prevent race with Batch.close
+		partition:      int(c.partition), // partition is copied to Batch to prevent race with Batch.close
+		offset:         offset,
+		highWaterMark:  highWaterMark,
+		logStartOffset: logStartOffset,
+		readReplica:    int(preferredReadReplica),
+		err:            dontExpectEOF(err),
+	}
+}
+
+// ReadBatchContext is like ReadBatchWith but aborts the fetch when ctx is
+// canceled, the error of the returned batch is then ctx.Err(). The deadline of
+// ctx applies to the fetch when it is earlier than the read deadline of the
+// connection, which is restored before the method returns.
+//
+// A fetch aborted after the request was sent leaves the response unread, so
+// the connection is closed like it is when the read deadline is reached, and
+// the program must establish a new one. The messages of a batch returned
+// before ctx was canceled are read under the read deadline of the connection.
+// A read deadline set by calling SetReadDeadline or SetDeadline during the
+// fetch is not overwritten when the method returns.
+func (c *Conn) ReadBatchContext(ctx context.Context, cfg ReadBatchConfig) *Batch {
+	if err := ctx.Err(); err != nil {
+		return &Batch{err: err}
+	}
+
+	// restore is the deadline given back to the connection, current is the one
+	// set by the method, which the program may replace during the fetch.
+	restore := c.rdeadline.deadline()
+	current := restore
+	if t, ok := ctx.Deadline(); ok && (restore.IsZero() || t.Before(restore)) {
+		if c.rdeadline.compareAndSwapDeadline(restore, t) {
+			current = t
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// a deadline in the past interrupts the blocking read or write
+			// of the fetch in progress.
+			now := time.Now()
+			if old := c.rdeadline.swapDeadline(now); !old.Equal(current) {
+				restore = old
+			}
+			current = now
+		case <-stop:
+		}
+	}()
+
+	batch := c.ReadBatchWith(cfg)
+	close(stop)
+	<-done
+	c.rdeadline.compareAndSwapDeadline(current, restore)
+
+	if batch.err != nil && ctx.Err() != nil {
+		batch.err = ctx.Err()
 	}
+	return batch
 }
 
 // ReadOffset returns the offset of the first message with a timestamp equal or
@@ -817,6 +1570,7 @@ func (c *Conn) ReadOffsets() (first, last int64, err error) {
 
 func (c *Conn) readOffset(t int64) (offset int64, err error) {
 	err = c.readOperation(
+		listOffsetRequest,
 		func(deadline time.Time, id int32) error {
 			return writeListOffsetRequestV1(&c.wbuf, id, c.clientID, c.topic, c.partition, t)
 		},
@@ -849,12 +1603,40 @@ func (c *Conn) readOffset(t int64) (offset int64, err error) {
 	return
 }
 
+// listOffsets lists the offsets of the partitions of the request, which must
+// all be led by the broker that the connection is established to.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ListOffsets
+func (c *Conn) listOffsets(request listOffsetRequestV1) (listOffsetResponseV1, error) {
+	var response listOffsetResponseV1
+
+	err := c.readOperation(
+		listOffsetRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(listOffsetRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 // ReadPartitions returns the list of available partitions for the given list of
 // topics.
 //
 // If the method is called with no topic, it uses the topic configured on the
 // connection. If there are none, the method fetches all partitions of the kafka
 // cluster.
+//
+// The partitions hold their leader, replicas, in-sync replicas, and offline
+// replicas, which lets programs detect under-replicated and offline partitions.
 func (c *Conn) ReadPartitions(topics ...string) (partitions []Partition, err error) {
 	defaultTopics := [...]string{c.topic}
 
@@ -862,15 +1644,32 @@ func (c *Conn) ReadPartitions(topics ...string) (partitions []Partition, err err
 		topics = defaultTopics[:]
 	}
 
+	version := c.negotiatedVersionOrLowest(metadataRequest)
+
 	err = c.readOperation(
+		metadataRequest,
 		func(deadline time.Time, id int32) error {
+			if version == v7 {
+				return c.writeRequest(metadataRequest, v7, id, topicMetadataRequestV7{
+					Topics:                 topics,
+					AllowAutoTopicCreation: true,
+				})
+			}
 			return c.writeRequest(metadataRequest, v1, id, topicMetadataRequestV1(topics))
 		},
 		func(deadline time.Time, size int) error {
-			var res metadataResponseV1
+			var res metadataResponseV7
 
-			if err := c.readResponse(size, &res); err != nil {
-				return err
+			if version == v7 {
+				if err := c.readResponse(size, &res); err != nil {
+					return err
+				}
+			} else {
+				var resV1 metadataResponseV1
+				if err := c.readResponse(size, &resV1); err != nil {
+					return err
+				}
+				res = resV1.toV7()
 			}
 
 			brokers := make(map[int32]Broker, len(res.Brokers))
@@ -883,10 +1682,20 @@ func (c *Conn) ReadPartitions(topics ...string) (partitions []Partition, err err
 				}
 			}
 
+			makeBroker := func(id int32) Broker {
+				b, ok := brokers[id]
+				if !ok {
+					// The broker is down, or the partition has no leader when
+					// id is -1.
+					b = Broker{ID: int(id)}
+				}
+				return b
+			}
+
 			makeBrokers := func(ids ...int32) []Broker {
 				b := make([]Broker, len(ids))
 				for i, id := range ids {
-					b[i] = brokers[id]
+					b[i] = makeBroker(id)
 				}
 				return b
 			}
@@ -899,13 +1708,18 @@ func (c *Conn) ReadPartitions(topics ...string) (partitions []Partition, err err
 					return Error(t.TopicErrorCode)
 				}
 				for _, p := range t.Partitions {
-					partitions = append(partitions, Partition{
-						Topic:    t.TopicName,
-						Leader:   brokers[p.Leader],
-						Replicas: makeBrokers(p.Replicas...),
-						Isr:      makeBrokers(p.Isr...),
-						ID:       int(p.PartitionID),
-					})
+					partition := Partition{
+						Topic:       t.TopicName,
+						Leader:      makeBroker(p.Leader),
+						LeaderEpoch: int(p.LeaderEpoch),
+						Replicas:    makeBrokers(p.Replicas...),
+						Isr:         makeBrokers(p.Isr...),
+						ID:          int(p.PartitionID),
+					}
+					if len(p.OfflineReplicas) != 0 {
+						partition.OfflineReplicas = makeBrokers(p.OfflineReplicas...)
+					}
+					partitions = append(partitions, partition)
 				}
 			}
 			return nil
@@ -937,7 +1751,10 @@ func (c *Conn) WriteMessages(msgs ...Message) (int, error) {
 // and partition, returning the number of bytes written. The write is an atomic
 // operation, it either fully succeeds or fails.
 //
-// If the compression codec is not nil, the messages will be compressed.
+// If the compression codec is not nil, the messages will be compressed by the
+// client into a single batch before being sent. The number of bytes returned is
+// the size of the keys and values before compression. Like the other writes,
+// the operation is bounded by the write deadline of the connection.
 func (c *Conn) WriteCompressedMessages(codec CompressionCodec, msgs ...Message) (nbytes int, err error) {
 	nbytes, _, _, _, err = c.writeCompressedMessages(codec, msgs...)
 	return
@@ -948,6 +1765,10 @@ func (c *Conn) WriteCompressedMessages(codec CompressionCodec, msgs ...Message)
 // and timestamp assigned by the kafka broker to the message set. The write is an atomic
 // operation, it either fully succeeds or fails.
 //
+// The broker only assigns the timestamp of topics configured with
+// message.timestamp.type=LogAppendTime, the returned time is zero for topics
+// using CreateTime, where the messages keep the time they were written with.
+//
 // If the compression codec is not nil, the messages will be compressed.
 func (c *Conn) WriteCompressedMessagesAt(codec CompressionCodec, msgs ...Message) (nbytes int, partition int32, offset int64, appendTime time.Time, err error) {
 	return c.writeCompressedMessages(codec, msgs...)
@@ -959,6 +1780,10 @@ func (c *Conn) writeCompressedMessages(codec CompressionCodec, msgs ...Message)
 		return
 	}
 
+	if codec == NoCompression {
+		codec = nil
+	}
+
 	writeTime := time.Now()
 	for i, msg := range msgs {
 		// users may believe they can set the Topic and/or Partition
@@ -979,83 +1804,139 @@ func (c *Conn) writeCompressedMessages(codec CompressionCodec, msgs ...Message)
 		nbytes += len(msg.Key) + len(msg.Value)
 	}
 
-	err = c.writeOperation(
-		func(deadline time.Time, id int32) error {
-			now := time.Now()
-			deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
-			if c.apiVersions[produceRequest].MaxVersion >= 3 {
-				return writeProduceRequestV3(
-					&c.wbuf,
-					codec,
-					id,
-					c.clientID,
-					c.topic,
-					c.partition,
-					deadlineToTimeout(deadline, now),
-					int16(atomic.LoadInt32(&c.requiredAcks)),
-					msgs...,
-				)
-			}
-			return writeProduceRequestV2(
+	offset, appendTime, err = c.produce(
+		c.negotiatedVersionOrLowest(produceRequest),
+		codec,
+		c.partition,
+		0,
+		int16(atomic.LoadInt32(&c.requiredAcks)),
+		nil,
+		msgs...,
+	)
+
+	if err != nil {
+		nbytes = 0
+	} else {
+		partition = c.partition
+	}
+
+	return
+}
+
+// produce sends a produce request for msgs to the partition of the connection's
+// topic, returning the offset of the first message and the append time set by
+// the broker. A timeout of zero means to use the write deadline of the
+// connection.
+//
+// When acks is 0 the broker does not respond and the offset returned is -1.
+// When producer is not nil, the messages are written in a record batch of the
+// producer, which requires version 3.
+func (c *Conn) produce(version apiVersion, codec CompressionCodec, partition int32, timeout time.Duration, acks int16, producer *batchProducer, msgs ...Message) (offset int64, appendTime time.Time, err error) {
+	write := func(deadline time.Time, id int32) error {
+		now := time.Now()
+		deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
+		if timeout == 0 {
+			timeout = deadlineToTimeout(deadline, now)
+		}
+		if version == v3 {
+			return writeProduceRequestV3(
 				&c.wbuf,
 				codec,
 				id,
 				c.clientID,
 				c.topic,
-				c.partition,
-				deadlineToTimeout(deadline, now),
-				int16(atomic.LoadInt32(&c.requiredAcks)),
+				partition,
+				timeout,
+				acks,
+				producer,
 				msgs...,
 			)
-		},
-		func(deadline time.Time, size int) error {
-			return expectZeroSize(readArrayWith(&c.rbuf, size, func(r *bufio.Reader, size int) (int, error) {
-				// Skip the topic, we've produced the message to only one topic,
-				// no need to waste resources loading it in memory.
-				size, err := discardString(r, size)
-				if err != nil {
-					return size, err
-				}
+		}
+		return writeProduceRequestV2(
+			&c.wbuf,
+			codec,
+			id,
+			c.clientID,
+			c.topic,
+			partition,
+			timeout,
+			acks,
+			msgs...,
+		)
+	}
 
-				// Read the list of partitions, there should be only one since
-				// we've produced a message to a single partition.
-				size, err = readArrayWith(r, size, func(r *bufio.Reader, size int) (int, error) {
-					var p produceResponsePartitionV2
-					size, err := p.readFrom(r, size)
-					if err == nil && p.ErrorCode != 0 {
-						err = Error(p.ErrorCode)
-					}
-					if err == nil {
-						partition = p.Partition
-						offset = p.Offset
-						appendTime = time.Unix(0, p.Timestamp*int64(time.Millisecond))
+	if acks == 0 {
+		// The broker never responds to produce requests which don't require
+		// acknowledges, the write is complete once the request was sent and
+		// the offset of the messages is unknown.
+		if _, _, err = c.doRequest(&c.wdeadline, write); err == nil {
+			offset = -1
+		}
+	} else {
+		err = c.writeOperation(
+			produceRequest,
+			write,
+			func(deadline time.Time, size int) error {
+				// The error code of the partition is returned after reading the
+				// whole response, which would otherwise be left on the
+				// connection and read as part of the next response.
+				var partitionErr error
+				var throttle int32
+
+				if err := expectZeroSize(readArrayWith(&c.rbuf, size, func(r *bufio.Reader, size int) (int, error) {
+					// Skip the topic, we've produced the message to only one topic,
+					// no need to waste resources loading it in memory.
+					size, err := discardString(r, size)
+					if err != nil {
+						return size, err
 					}
 
-					return size, err
-				})
-				if err != nil {
-					return size, err
-				}
+					// Read the list of partitions, there should be only one since
+					// we've produced a message to a single partition.
+					size, err = readArrayWith(r, size, func(r *bufio.Reader, size int) (int, error) {
+						var p produceResponsePartitionV2
+						size, err := p.readFrom(r, size)
+						if err == nil && p.ErrorCode != 0 {
+							partitionErr = Error(p.ErrorCode)
+						} else if err == nil {
+							offset = p.Offset
+							if p.Timestamp != -1 {
+								appendTime = time.Unix(0, p.Timestamp*int64(time.Millisecond))
+							}
+						}
 
-				// The response is trailed by the throttle time, also skipping
-				// since it's not interesting here.
-				return discardInt32(r, size)
-			}))
-		},
-	)
+						return size, err
+					})
+					if err != nil {
+						return size, err
+					}
 
-	if err != nil {
-		nbytes = 0
+					// The response is trailed by the throttle time, which delays
+					// the next requests sent on the connection.
+					return readInt32(r, size, &throttle)
+				})); err != nil {
+					return err
+				}
+				c.setThrottle(duration(throttle))
+				return partitionErr
+			},
+		)
 	}
 
 	return
 }
 
 // SetRequiredAcks sets the number of acknowledges from replicas that the
-// connection requests when producing messages.
+// connection requests when producing messages: -1 to wait for all in-sync
+// replicas, 1 to wait for the partition leader only, and 0 to not wait for any
+// acknowledge.
+//
+// When set to 0 the broker does not respond to produce requests, writes return
+// as soon as the messages were sent, errors that occur on the broker are not
+// reported and WriteCompressedMessagesAt returns an offset of -1.
 func (c *Conn) SetRequiredAcks(n int) error {
 	switch n {
-	case -1, 1:
+	case -1, 0, 1:
 		atomic.StoreInt32(&c.requiredAcks, int32(n))
 		return nil
 	default:
@@ -1077,6 +1958,17 @@ func (c *Conn) writeRequest(apiKey apiKey, apiVersion apiVersion, correlationID
 	return c.wbuf.Flush()
 }
 
+// writeFlexibleRequest is like writeRequest for the flexible versions of the
+// requests (KIP-482), which end the request header with tagged fields.
+func (c *Conn) writeFlexibleRequest(apiKey apiKey, apiVersion apiVersion, correlationID int32, req request) error {
+	hdr := c.requestHeader(apiKey, apiVersion, correlationID)
+	hdr.Size = (hdr.size() + sizeofTaggedFields() + req.size()) - 4
+	hdr.writeTo(&c.wbuf)
+	writeTaggedFields(&c.wbuf)
+	req.writeTo(&c.wbuf)
+	return c.wbuf.Flush()
+}
+
 func (c *Conn) readResponse(size int, res interface{}) error {
 	size, err := read(&c.rbuf, size, res)
 	switch err.(type) {
@@ -1110,16 +2002,16 @@ func (c *Conn) writeDeadline() time.Time {
 	return c.wdeadline.deadline()
 }
 
-func (c *Conn) readOperation(write func(time.Time, int32) error, read func(time.Time, int) error) error {
-	return c.do(&c.rdeadline, write, read)
+func (c *Conn) readOperation(api apiKey, write func(time.Time, int32) error, read func(time.Time, int) error) error {
+	return c.do(&c.rdeadline, api, write, read)
 }
 
-func (c *Conn) writeOperation(write func(time.Time, int32) error, read func(time.Time, int) error) error {
-	return c.do(&c.wdeadline, write, read)
+func (c *Conn) writeOperation(api apiKey, write func(time.Time, int32) error, read func(time.Time, int) error) error {
+	return c.do(&c.wdeadline, api, write, read)
 }
 
-func (c *Conn) do(d *connDeadline, write func(time.Time, int32) error, read func(time.Time, int) error) error {
-	id, err := c.doRequest(d, write)
+func (c *Conn) do(d *connDeadline, api apiKey, write func(time.Time, int32) error, read func(time.Time, int) error) error {
+	id, start, err := c.doRequest(d, write)
 	if err != nil {
 		return err
 	}
@@ -1128,6 +2020,7 @@ func (c *Conn) do(d *connDeadline, write func(time.Time, int32) error, read func
 	if err != nil {
 		return err
 	}
+	c.observeRequest(api, start)
 
 	if err = read(deadline, size); err != nil {
 		switch err.(type) {
@@ -1142,8 +2035,11 @@ func (c *Conn) do(d *connDeadline, write func(time.Time, int32) error, read func
 	return err
 }
 
-func (c *Conn) doRequest(d *connDeadline, write func(time.Time, int32) error) (id int32, err error) {
-	c.wlock.Lock()
+// doRequest writes a request with write, returning its correlation ID and the
+// time it started being written, which excludes the throttle time.
+func (c *Conn) doRequest(d *connDeadline, write func(time.Time, int32) error) (id int32, start time.Time, err error) {
+	c.lockThrottled(d.deadline())
+	start = time.Now()
 	c.correlationID++
 	id = c.correlationID
 	err = write(d.setConnWriteDeadline(c.conn), id)
@@ -1160,6 +2056,60 @@ func (c *Conn) doRequest(d *connDeadline, write func(time.Time, int32) error) (i
 	return
 }
 
+// setThrottle records the throttle time reported by the broker in a response.
+// Brokers expect clients exceeding their quotas to hold off sending requests
+// until the throttle time elapsed, or mute the connection otherwise.
+func (c *Conn) setThrottle(throttle time.Duration) {
+	c.throttleMutex.Lock()
+	c.throttle = throttle
+	if throttle > 0 {
+		c.throttleUntil = time.Now().Add(throttle)
+	}
+	c.throttleMutex.Unlock()
+}
+
+// throttled returns the throttle time of the last produce or fetch response,
+// and the time remaining until it elapses.
+func (c *Conn) throttled() (last time.Duration, remain time.Duration) {
+	c.throttleMutex.Lock()
+	last, remain = c.throttle, time.Until(c.throttleUntil)
+	c.throttleMutex.Unlock()
+	if remain < 0 {
+		remain = 0
+	}
+	return
+}
+
+// throttleDelay returns the time remaining until the throttle time elapses, or
+// until deadline is reached if it comes first.
+func (c *Conn) throttleDelay(deadline time.Time) time.Duration {
+	_, delay := c.throttled()
+	if !deadline.IsZero() {
+		if d := time.Until(deadline); d < delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// lockThrottled acquires the write lock once the throttle time elapsed, or
+// deadline is reached. The connection is not locked while waiting, so other
+// goroutines may use it in the meantime.
+func (c *Conn) lockThrottled(deadline time.Time) {
+	for {
+		if delay := c.throttleDelay(deadline); delay > 0 {
+			time.Sleep(delay)
+		}
+		c.wlock.Lock()
+		// A response received while waiting for the lock may have throttled
+		// the connection again.
+		if c.throttleDelay(deadline) <= 0 {
+			return
+		}
+		c.wlock.Unlock()
+	}
+}
+
 func (c *Conn) waitResponse(d *connDeadline, id int32) (deadline time.Time, size int, lock *sync.Mutex, err error) {
 	for {
 		var rsz int32
@@ -1222,8 +2172,15 @@ var defaultApiVersions map[apiKey]ApiVersion = map[apiKey]ApiVersion{
 	deleteTopicsRequest:     ApiVersion{int16(deleteTopicsRequest), int16(v1), int16(v1)},
 }
 
+// ApiVersions returns the versions of each request supported by the broker
+// that the connection is established to.
+//
+// The versions are retrieved when the connection is created and cached, the
+// connection then uses the highest version of each request supported by both
+// the client and the broker. Calling this method sends a new request to the
+// broker but doesn't change the versions used by the connection.
 func (c *Conn) ApiVersions() ([]ApiVersion, error) {
-	id, err := c.doRequest(&c.rdeadline, func(deadline time.Time, id int32) error {
+	id, start, err := c.doRequest(&c.rdeadline, func(deadline time.Time, id int32) error {
 		now := time.Now()
 		deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
 
@@ -1247,6 +2204,7 @@ func (c *Conn) ApiVersions() ([]ApiVersion, error) {
 		return nil, err
 	}
 	defer lock.Unlock()
+	c.observeRequest(apiVersionsRequest, start)
 
 	var errorCode int16
 	if size, err = readInt16(&c.rbuf, size, &errorCode); err != nil {
@@ -1294,6 +2252,32 @@ func (d *connDeadline) deadline() time.Time {
 
 func (d *connDeadline) setDeadline(t time.Time) {
 	d.mutex.Lock()
+	d.setDeadlineLocked(t)
+	d.mutex.Unlock()
+}
+
+// swapDeadline sets the deadline to t and returns the previous one.
+func (d *connDeadline) swapDeadline(t time.Time) time.Time {
+	d.mutex.Lock()
+	old := d.value
+	d.setDeadlineLocked(t)
+	d.mutex.Unlock()
+	return old
+}
+
+// compareAndSwapDeadline sets the deadline to t if it is still old, and
+// reports whether it did.
+func (d *connDeadline) compareAndSwapDeadline(old time.Time, t time.Time) bool {
+	d.mutex.Lock()
+	swapped := d.value.Equal(old)
+	if swapped {
+		d.setDeadlineLocked(t)
+	}
+	d.mutex.Unlock()
+	return swapped
+}
+
+func (d *connDeadline) setDeadlineLocked(t time.Time) {
 	d.value = t
 
 	if d.rconn != nil {
@@ -1303,8 +2287,6 @@ func (d *connDeadline) setDeadline(t time.Time) {
 	if d.wconn != nil {
 		d.wconn.SetWriteDeadline(t)
 	}
-
-	d.mutex.Unlock()
 }
 
 func (d *connDeadline) setConnReadDeadline(conn net.Conn) time.Time {
@@ -1342,23 +2324,28 @@ func (d *connDeadline) unsetConnWriteDeadline() {
 // error out with UnsupportedSASLMechanism.
 //
 // If the mechanism is unsupported, the handshake request will reply with the
-// list of the cluster's configured mechanisms, which could potentially be used
-// to facilitate negotiation.  At the moment, we are not negotiating the
-// mechanism as we believe that brokers are usually known to the client, and
-// therefore the client should already know which mechanisms are supported.
+// list of the cluster's configured mechanisms, which the Dialer uses to pick
+// another one of the mechanisms it is configured with (see
+// Dialer.SASLMechanisms).
 //
 // See http://kafka.apache.org/protocol.html#The_Messages_SaslHandshake
 func (c *Conn) saslHandshake(mechanism string) error {
+	_, err := c.saslHandshakeMechanisms(mechanism)
+	return err
+}
+
+// saslHandshakeMechanisms is like saslHandshake but also returns the
+// mechanisms enabled on the broker, which it reports even when it rejects the
+// mechanism of the handshake.
+func (c *Conn) saslHandshakeMechanisms(mechanism string) ([]string, error) {
 	// The wire format for V0 and V1 is identical, but the version
 	// number will affect how the SASL authentication
 	// challenge/responses are sent
 	var resp saslHandshakeResponseV0
-	version := v0
-	if c.apiVersions[saslHandshakeRequest].MaxVersion >= 1 {
-		version = v1
-	}
+	version := c.negotiatedVersionOrLowest(saslHandshakeRequest)
 
 	err := c.writeOperation(
+		saslHandshakeRequest,
 		func(deadline time.Time, id int32) error {
 			return c.writeRequest(saslHandshakeRequest, version, id, &saslHandshakeRequestV0{Mechanism: mechanism})
 		},
@@ -1371,7 +2358,7 @@ func (c *Conn) saslHandshake(mechanism string) error {
 	if err == nil && resp.ErrorCode != 0 {
 		err = Error(resp.ErrorCode)
 	}
-	return err
+	return resp.EnabledMechanisms, err
 }
 
 // saslAuthenticate sends the SASL authenticate message.  This function must
@@ -1382,11 +2369,12 @@ func (c *Conn) saslAuthenticate(data []byte) ([]byte, error) {
 	// if we sent a v1 handshake, then we must encapsulate the authentication
 	// request in a saslAuthenticateRequest.  otherwise, we read and write raw
 	// bytes.
-	if c.apiVersions[saslHandshakeRequest].MaxVersion >= 1 {
+	if c.negotiatedVersionOrLowest(saslHandshakeRequest) == v1 {
 		var request = saslAuthenticateRequestV0{Data: data}
 		var response saslAuthenticateResponseV0
 
 		err := c.writeOperation(
+			saslAuthenticateRequest,
 			func(deadline time.Time, id int32) error {
 				return c.writeRequest(saslAuthenticateRequest, v0, id, request)
 			},
@@ -1,12 +1,15 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"reflect"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -126,6 +129,17 @@ func TestConn(t *testing.T) {
 			function: testConnWrite,
 		},
 
+		{
+			scenario: "writing a message without requiring acks should not wait for a response",
+			function: testConnWriteRequireNone,
+		},
+
+		{
+			scenario:   "producing record batches returns the offset of their first record",
+			function:   testConnProduce,
+			minVersion: "0.11.0",
+		},
+
 		{
 			scenario: "writing a message to a closed kafka connection should fail",
 			function: testConnCloseAndWrite,
@@ -176,11 +190,27 @@ func TestConn(t *testing.T) {
 			function: testConnReadEmptyWithDeadline,
 		},
 
+		{
+			scenario: "reading a batch from an empty partition should return after the max wait time",
+			function: testConnReadBatchWithMaxWait,
+		},
+
 		{
 			scenario: "write batch of messages and read the highest offset (watermark)",
 			function: testConnReadWatermarkFromBatch,
 		},
 
+		{
+			scenario:   "read the watermark and log start offset from an empty batch",
+			function:   testConnReadOffsetsFromEmptyBatch,
+			minVersion: "0.11.0",
+		},
+
+		{
+			scenario: "write batch of messages and read them as records reusing the batch buffers",
+			function: testConnReadRecordsFromBatch,
+		},
+
 		{
 			scenario:   "describe groups retrieves all groups when no groupID specified",
 			function:   testConnDescribeGroupRetrievesAllGroups,
@@ -222,6 +252,11 @@ func TestConn(t *testing.T) {
 			function: testConnSyncGroupErr,
 		},
 
+		{
+			scenario: "join, sync, heartbeat and leave a group with the exported methods",
+			function: testConnGroupMembership,
+		},
+
 		{
 			scenario:   "test list groups",
 			function:   testConnListGroupsReturnsGroups,
@@ -242,6 +277,10 @@ func TestConn(t *testing.T) {
 			scenario: "test delete topics with an invalid topic",
 			function: testDeleteTopicsInvalidTopic,
 		},
+		{
+			scenario: "test create topics and wait for their partitions",
+			function: testConnCreateTopicsAndWait,
+		},
 		{
 			scenario: "test retrieve controller",
 			function: testController,
@@ -351,6 +390,110 @@ func testConnWrite(t *testing.T, conn *Conn) {
 	}
 }
 
+func testConnWriteRequireNone(t *testing.T, conn *Conn) {
+	if err := conn.SetRequiredAcks(0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, offset, _, err := conn.WriteCompressedMessagesAt(nil, Message{Value: []byte("0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != -1 {
+		t.Errorf("expected the offset to be unknown; got %d", offset)
+	}
+
+	// the next request must not be confused by the missing response
+	if err := conn.SetRequiredAcks(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, offset, _, err = conn.WriteCompressedMessagesAt(nil, Message{Value: []byte("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 1 {
+		t.Errorf("expected the message to be written at offset 1; got %d", offset)
+	}
+
+	for i := 0; i != 2; i++ {
+		msg, err := conn.ReadMessage(128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(msg.Value); s != strconv.Itoa(i) {
+			t.Errorf("bad message read at offset %d: %s", i, s)
+		}
+	}
+}
+
+func testConnProduce(t *testing.T, conn *Conn) {
+	for i, expected := range []int64{0, 2} {
+		offset, err := conn.Produce(0, RecordBatch{
+			Records: []Record{
+				{Key: []byte("key"), Value: []byte(strconv.Itoa(2 * i))},
+				{Value: []byte(strconv.Itoa(2*i + 1)), Headers: []Header{{Key: "h", Value: []byte("v")}}},
+			},
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != expected {
+			t.Errorf("expected the batch to be written at offset %d; got %d", expected, offset)
+		}
+	}
+
+	for i := 0; i != 4; i++ {
+		msg, err := conn.ReadMessage(1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(msg.Value); s != strconv.Itoa(i) {
+			t.Errorf("bad message read at offset %d: %s", i, s)
+		}
+		if i%2 == 1 && (len(msg.Headers) != 1 || msg.Headers[0].Key != "h") {
+			t.Errorf("expected the header of the record at offset %d; got %+v", i, msg.Headers)
+		}
+	}
+}
+
+func TestConnProduceInvalidBatch(t *testing.T) {
+	tests := []struct {
+		scenario string
+		version  apiVersion
+		batch    RecordBatch
+		err      error
+	}{
+		{
+			scenario: "record batches require produce v3",
+			version:  v2,
+			batch:    RecordBatch{Records: []Record{{Value: []byte("A")}}},
+			err:      UnsupportedVersion,
+		},
+		{
+			scenario: "empty batches are rejected",
+			version:  v3,
+			err:      errEmptyRecordBatch,
+		},
+		{
+			scenario: "invalid required acks are rejected",
+			version:  v3,
+			batch:    RecordBatch{Records: []Record{{Value: []byte("A")}}, RequiredAcks: 2},
+			err:      InvalidRequiredAcks,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			c := &Conn{versions: map[apiKey]apiVersion{produceRequest: test.version}}
+			if offset, err := c.Produce(0, test.batch); err != test.err || offset != -1 {
+				t.Errorf("expected (-1, %v); got (%d, %v)", test.err, offset, err)
+			}
+		})
+	}
+}
+
 func testConnCloseAndWrite(t *testing.T, conn *Conn) {
 	conn.Close()
 
@@ -510,6 +653,63 @@ func testConnReadWatermarkFromBatch(t *testing.T, conn *Conn) {
 	batch.Close()
 }
 
+func testConnReadOffsetsFromEmptyBatch(t *testing.T, conn *Conn) {
+	if _, err := conn.WriteMessages(makeTestSequence(10)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Seek(10, SeekAbsolute); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	batch := conn.ReadBatch(1, 10e6)
+
+	if _, err := batch.ReadMessage(); err == nil {
+		t.Error("expected the batch to be empty")
+	}
+	batch.Close()
+
+	if hwm := batch.HighWaterMark(); hwm != 10 {
+		t.Error("expected high watermark to be 10, got", hwm)
+	}
+
+	if lso := batch.LogStartOffset(); lso != 0 {
+		t.Error("expected log start offset to be 0, got", lso)
+	}
+}
+
+func testConnReadRecordsFromBatch(t *testing.T, conn *Conn) {
+	msgs := makeTestSequence(10)
+	msgs[3].Key = []byte("key")
+
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := conn.ReadBatch(1, 10e6)
+	defer batch.Close()
+
+	for i := 0; i < 10; i++ {
+		rec, err := batch.ReadRecord()
+		if err != nil {
+			t.Fatal("error reading record from batch:", err)
+		}
+		if rec.Offset != int64(i) {
+			t.Errorf("bad record offset: expected %d, got %d", i, rec.Offset)
+		}
+		if s := string(rec.Value); s != strconv.Itoa(i) {
+			t.Errorf("bad record value at offset %d: %s", i, s)
+		}
+		if i == 3 && string(rec.Key) != "key" {
+			t.Errorf("bad record key at offset %d: %q", i, rec.Key)
+		}
+		if i != 3 && rec.Key != nil {
+			t.Errorf("expected null record key at offset %d: %q", i, rec.Key)
+		}
+	}
+}
+
 func waitForCoordinator(t *testing.T, conn *Conn, groupID string) {
 	// ensure that kafka has allocated a group coordinator.  oddly, issue doesn't
 	// appear to happen if the kafka been running for a while.
@@ -710,6 +910,69 @@ func testConnSyncGroupErr(t *testing.T, conn *Conn) {
 	}
 }
 
+func testConnGroupMembership(t *testing.T, conn *Conn) {
+	groupID := makeGroupID()
+	waitForCoordinator(t, conn, groupID)
+
+	var join JoinGroupResponse
+	var err error
+
+	for attempt := 0; attempt < 10; attempt++ {
+		join, err = conn.JoinGroup(JoinGroupRequest{
+			GroupID:          groupID,
+			SessionTimeout:   time.Minute,
+			RebalanceTimeout: time.Second,
+			Protocols: []GroupProtocol{
+				{Name: "range", Topics: []string{"A", "B"}, UserData: []byte("data")},
+			},
+		})
+		if err != NotCoordinatorForGroup {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("bad JoinGroup: %s", err)
+	}
+
+	if join.LeaderID != join.MemberID || join.GroupProtocol != "range" {
+		t.Fatalf("expected to lead the group with the range protocol: %+v", join)
+	}
+	if len(join.Members) != 1 || !reflect.DeepEqual(join.Members[0].Topics, []string{"A", "B"}) || string(join.Members[0].UserData) != "data" {
+		t.Errorf("bad group members: %+v", join.Members)
+	}
+
+	sync, err := conn.SyncGroup(SyncGroupRequest{
+		GroupID:      groupID,
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+		Assignments: GroupMemberAssignments{
+			join.MemberID: {"A": {0, 1}, "B": {0}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("bad SyncGroup: %s", err)
+	}
+	if expected := map[string][]int{"A": {0, 1}, "B": {0}}; !reflect.DeepEqual(expected, sync.Assignments) {
+		t.Errorf("expected assignments %v; got %v", expected, sync.Assignments)
+	}
+
+	if err := conn.Heartbeat(HeartbeatRequest{
+		GroupID:      groupID,
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+	}); err != nil {
+		t.Errorf("bad Heartbeat: %s", err)
+	}
+
+	if err := conn.LeaveGroup(LeaveGroupRequest{
+		GroupID:  groupID,
+		MemberID: join.MemberID,
+	}); err != nil {
+		t.Errorf("bad LeaveGroup: %s", err)
+	}
+}
+
 func testConnListGroupsReturnsGroups(t *testing.T, conn *Conn) {
 	group1 := makeGroupID()
 	_, _, stop1 := createGroup(t, conn, group1)
@@ -888,6 +1151,31 @@ func testConnReadEmptyWithDeadline(t *testing.T, conn *Conn) {
 	}
 }
 
+func testConnReadBatchWithMaxWait(t *testing.T, conn *Conn) {
+	const maxWait = 250 * time.Millisecond
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(10 * time.Second))
+
+	batch := conn.ReadBatchWith(ReadBatchConfig{
+		MinBytes: 1,
+		MaxBytes: 10e6,
+		MaxWait:  maxWait,
+	})
+
+	if _, err := batch.ReadMessage(); err != io.EOF {
+		t.Error("expected io.EOF but got", err)
+	}
+
+	if err := batch.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("the fetch request did not honor the max wait time, it returned after", elapsed)
+	}
+}
+
 func testDeleteTopics(t *testing.T, conn *Conn) {
 	topic1 := makeTopic()
 	topic2 := makeTopic()
@@ -946,6 +1234,35 @@ func testDeleteTopicsInvalidTopic(t *testing.T, conn *Conn) {
 	}
 }
 
+func testConnCreateTopicsAndWait(t *testing.T, conn *Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	err := conn.CreateTopicsAndWait(ctx, TopicConfig{
+		Topic:             topic,
+		NumPartitions:     3,
+		ReplicationFactor: 1,
+	})
+	if err != nil {
+		t.Fatalf("bad CreateTopicsAndWait: %v", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	partitions, err := conn.ReadPartitions(topic)
+	if err != nil {
+		t.Fatalf("bad ReadPartitions: %v", err)
+	}
+	if len(partitions) != 3 {
+		t.Fatalf("expected 3 partitions; got %d", len(partitions))
+	}
+	for _, p := range partitions {
+		if p.Leader.ID < 0 {
+			t.Errorf("partition %d has no leader", p.ID)
+		}
+	}
+}
+
 func testController(t *testing.T, conn *Conn) {
 	b, err := conn.Controller()
 	if err != nil {
@@ -1020,6 +1337,16 @@ func BenchmarkConn(b *testing.B) {
 			function: benchmarkConnReadBatch,
 		},
 
+		{
+			scenario: "ReadBatchMessage",
+			function: benchmarkConnReadBatchMessage,
+		},
+
+		{
+			scenario: "ReadBatchRecord",
+			function: benchmarkConnReadBatchRecord,
+		},
+
 		{
 			scenario: "ReadOffsets",
 			function: benchmarkConnReadOffsets,
@@ -1120,6 +1447,51 @@ func benchmarkConnReadBatch(b *testing.B, conn *Conn, a []byte) {
 	b.SetBytes(int64(n / i))
 }
 
+func benchmarkConnReadBatchMessage(b *testing.B, conn *Conn, _ []byte) {
+	benchmarkConnReadBatchWith(b, conn, func(batch *Batch) (int, error) {
+		msg, err := batch.ReadMessage()
+		return len(msg.Value), err
+	})
+}
+
+func benchmarkConnReadBatchRecord(b *testing.B, conn *Conn, _ []byte) {
+	benchmarkConnReadBatchWith(b, conn, func(batch *Batch) (int, error) {
+		rec, err := batch.ReadRecord()
+		return len(rec.Value), err
+	})
+}
+
+func benchmarkConnReadBatchWith(b *testing.B, conn *Conn, read func(*Batch) (int, error)) {
+	const minBytes = 1
+	const maxBytes = 10e6 // 10 MB
+
+	b.ReportAllocs()
+
+	batch := conn.ReadBatch(minBytes, maxBytes)
+	i := 0
+	n := 0
+
+	for i != b.N {
+		c, err := read(batch)
+		if err != nil {
+			if err = batch.Close(); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err = conn.Seek(0, SeekStart); err != nil {
+				b.Error(err)
+				return
+			}
+			batch = conn.ReadBatch(minBytes, maxBytes)
+		}
+		n += c
+		i++
+	}
+
+	batch.Close()
+	b.SetBytes(int64(n / i))
+}
+
 func benchmarkConnReadOffsets(b *testing.B, conn *Conn, _ []byte) {
 	for i := 0; i != b.N; i++ {
 		_, _, err := conn.ReadOffsets()
@@ -1147,3 +1519,753 @@ func benchmarkConnWrite(b *testing.B, conn *Conn, _ []byte) {
 
 	b.SetBytes(int64(n / i))
 }
+
+// syscallCountingConn counts the calls to the Read and Write methods of the
+// network connection, each one being a system call.
+type syscallCountingConn struct {
+	net.Conn
+	reads  int64
+	writes int64
+}
+
+func (c *syscallCountingConn) Read(b []byte) (int, error) {
+	atomic.AddInt64(&c.reads, 1)
+	return c.Conn.Read(b)
+}
+
+func (c *syscallCountingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func BenchmarkConnBufferSize(b *testing.B) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	value := make([]byte, 10e3) // 10 KB
+	msgs := make([]Message, benchmarkMessageCount)
+	for i := range msgs {
+		msgs[i].Value = value
+	}
+
+	for _, size := range []int{4096, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			c, err := net.Dial("tcp", broker.Addr())
+			if err != nil {
+				b.Fatal(err)
+			}
+			counter := &syscallCountingConn{Conn: c}
+			conn := NewConnWith(counter, ConnConfig{
+				Topic:           "test",
+				ReadBufferSize:  size,
+				WriteBufferSize: size,
+			})
+			defer conn.Close()
+
+			atomic.StoreInt64(&counter.reads, 0)
+			atomic.StoreInt64(&counter.writes, 0)
+			b.SetBytes(int64(2 * len(value) * len(msgs)))
+			b.ResetTimer()
+
+			for i := 0; i != b.N; i++ {
+				if _, err := conn.WriteMessages(msgs...); err != nil {
+					b.Fatal(err)
+				}
+
+				if _, err := conn.Seek(int64(i*len(msgs)), SeekAbsolute); err != nil {
+					b.Fatal(err)
+				}
+				batch := conn.ReadBatch(1, 10e6)
+				for {
+					if _, err := batch.ReadMessage(); err != nil {
+						break
+					}
+				}
+				if err := batch.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(atomic.LoadInt64(&counter.reads))/float64(b.N), "reads/op")
+			b.ReportMetric(float64(atomic.LoadInt64(&counter.writes))/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestNegotiateVersions(t *testing.T) {
+	broker := map[apiKey]ApiVersion{
+		produceRequest:       {ApiKey: int16(produceRequest), MinVersion: 0, MaxVersion: 7},
+		fetchRequest:         {ApiKey: int16(fetchRequest), MinVersion: 0, MaxVersion: 4},
+		saslHandshakeRequest: {ApiKey: int16(saslHandshakeRequest), MinVersion: 0, MaxVersion: 0},
+		describeAclsRequest:  {ApiKey: int16(describeAclsRequest), MinVersion: 0, MaxVersion: 0},
+	}
+
+	found := negotiateVersions(broker, clientApiVersions)
+	expected := map[apiKey]apiVersion{
+		produceRequest:       v3,
+		fetchRequest:         v2,
+		saslHandshakeRequest: v0,
+	}
+
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("expected %v; got %v", expected, found)
+	}
+
+	c := &Conn{versions: found}
+
+	if v, err := c.negotiatedVersion(describeAclsRequest); err != UnsupportedVersion {
+		t.Errorf("expected UnsupportedVersion for a request without versions in common; got %v (%v)", v, err)
+	}
+
+	if v := c.negotiatedVersionOrLowest(deleteAclsRequest); v != v1 {
+		t.Errorf("expected to fall back to the lowest client version; got %v", v)
+	}
+}
+
+func TestConnThrottle(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	const throttle = 100 * time.Millisecond
+	var throttled int32 = 1
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce && atomic.AddInt32(&throttled, -1) >= 0 {
+			return MockResponse{ThrottleTime: throttle}
+		}
+		return MockResponse{}
+	})
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.WriteMessages(Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := conn.throttled(); last != throttle {
+		t.Errorf("expected the throttle time of the response to be %s; got %s", throttle, last)
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.WriteMessages(Message{Value: []byte("B")})
+		done <- err
+	}()
+
+	// The write lock must not be held while waiting for the throttle time to
+	// elapse.
+	time.Sleep(throttle / 10)
+	conn.wlock.Lock()
+	conn.wlock.Unlock()
+	if elapsed := time.Since(start); elapsed > throttle/2 {
+		t.Errorf("expected the write lock to be available while the request is throttled; it took %s to acquire", elapsed)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// The broker did not throttle the second request, the time it took is the
+	// time the connection waited before sending it.
+	if elapsed := time.Since(start); elapsed < throttle*9/10 {
+		t.Errorf("expected the request to be delayed by the throttle time of %s; it took %s", throttle, elapsed)
+	}
+	if last, _ := conn.throttled(); last != 0 {
+		t.Errorf("expected the second response not to be throttled; got %s", last)
+	}
+}
+
+func TestConnOnRequestComplete(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	const delay = 50 * time.Millisecond
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			time.Sleep(delay)
+		}
+		return MockResponse{}
+	})
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	type roundTrip struct {
+		apiKey   int16
+		duration time.Duration
+	}
+	var roundTrips []roundTrip
+	conn.OnRequestComplete(func(apiKey int16, duration time.Duration) {
+		roundTrips = append(roundTrips, roundTrip{apiKey, duration})
+	})
+
+	if _, err := conn.ReadPartitions("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteMessages(Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	batch := conn.ReadBatch(1, 1e6)
+	if _, err := batch.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading the batch also lists the offsets of the partition before
+	// fetching the messages.
+	apiKeys := make([]int16, len(roundTrips))
+	for i, rt := range roundTrips {
+		apiKeys[i] = rt.apiKey
+	}
+	expected := []int16{int16(metadataRequest), int16(produceRequest), int16(listOffsetRequest), int16(listOffsetRequest), int16(fetchRequest)}
+	if !reflect.DeepEqual(apiKeys, expected) {
+		t.Fatalf("expected round trips of API keys %v; got %v", expected, apiKeys)
+	}
+	if d := roundTrips[1].duration; d < delay {
+		t.Errorf("expected the produce round trip to take at least %s; got %s", delay, d)
+	}
+
+	conn.OnRequestComplete(nil)
+	if _, err := conn.ReadPartitions("test"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(roundTrips) - len(expected); n != 0 {
+		t.Errorf("expected no round trips to be reported after removing the function; got %d", n)
+	}
+}
+
+func TestConnReadMessageAt(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	msgs := make([]Message, 5)
+	for i := range msgs {
+		msgs[i].Value = []byte(strconv.Itoa(i))
+	}
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int64{3, 0, 4} {
+		msg, err := conn.ReadMessageAt(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Offset != offset || string(msg.Value) != strconv.Itoa(int(offset)) {
+			t.Errorf("expected the message at offset %d; got %q at offset %d", offset, msg.Value, msg.Offset)
+		}
+		if next, _ := conn.Offset(); next != offset+1 {
+			t.Errorf("expected the offset of the connection to be %d; got %d", offset+1, next)
+		}
+	}
+
+	for _, offset := range []int64{-1, 5, 6} {
+		if _, err := conn.ReadMessageAt(offset); err != OffsetOutOfRange {
+			t.Errorf("offset %d: expected %v; got %v", offset, OffsetOutOfRange, err)
+		}
+	}
+}
+
+func TestConnReadMessageAtCompressed(t *testing.T) {
+	codec := testGzipCodec{code: 5}
+	defer registerTestCodec(codec)()
+
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+	broker.SetCompression("test", codec)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The messages are produced in two batches, the broker returns the whole
+	// batch holding the offset.
+	for _, values := range [][]string{{"0", "1", "2"}, {"3", "4", "5", "6"}} {
+		msgs := make([]Message, len(values))
+		for i, value := range values {
+			msgs[i].Value = []byte(value)
+		}
+		if _, err := conn.WriteMessages(msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, offset := range []int64{5, 1, 3, 6, 0} {
+		msg, err := conn.ReadMessageAt(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Offset != offset || string(msg.Value) != strconv.Itoa(int(offset)) {
+			t.Errorf("expected the message at offset %d; got %q at offset %d", offset, msg.Value, msg.Offset)
+		}
+		if next, _ := conn.Offset(); next != offset+1 {
+			t.Errorf("expected the offset of the connection to be %d; got %d", offset+1, next)
+		}
+	}
+}
+
+func TestConnReadBatchPartitionMaxBytes(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	msgs := make([]Message, 5)
+	for i := range msgs {
+		msgs[i].Value = make([]byte, 100)
+	}
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		partitionMaxBytes int
+		count             int
+	}{
+		{partitionMaxBytes: 0, count: 5},
+		{partitionMaxBytes: 300, count: 2},
+		{partitionMaxBytes: 1, count: 1},
+	} {
+		if _, err := conn.Seek(0, SeekAbsolute); err != nil {
+			t.Fatal(err)
+		}
+
+		batch := conn.ReadBatchWith(ReadBatchConfig{
+			MinBytes:          1,
+			MaxBytes:          1e6,
+			PartitionMaxBytes: test.partitionMaxBytes,
+		})
+
+		count := 0
+		for {
+			if _, err := batch.ReadMessage(); err != nil {
+				break
+			}
+			count++
+		}
+		if err := batch.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != test.count {
+			t.Errorf("partition max bytes %d: expected %d messages; got %d", test.partitionMaxBytes, test.count, count)
+		}
+	}
+
+	if err := conn.ReadBatchWith(ReadBatchConfig{MaxBytes: 1e6, PartitionMaxBytes: -1}).Close(); err == nil {
+		t.Error("expected an error for a negative partition max bytes")
+	}
+}
+
+func TestConnReadBatchFetchSession(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	write := func(n int) {
+		msgs := make([]Message, n)
+		for i := range msgs {
+			msgs[i].Value = []byte("hello")
+		}
+		if _, err := conn.WriteMessages(msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(count int, highWaterMark int64) {
+		t.Helper()
+		batch := conn.ReadBatchWith(ReadBatchConfig{
+			MinBytes:     1,
+			MaxBytes:     1e6,
+			MaxWait:      10 * time.Millisecond,
+			FetchSession: true,
+		})
+		n := 0
+		for {
+			if _, err := batch.ReadMessage(); err != nil {
+				break
+			}
+			n++
+		}
+		if err := batch.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n != count {
+			t.Errorf("expected %d messages; got %d", count, n)
+		}
+		if hwm := batch.HighWaterMark(); hwm != highWaterMark {
+			t.Errorf("expected high watermark %d; got %d", highWaterMark, hwm)
+		}
+	}
+
+	write(3)
+	read(3, 3)
+
+	conn.mutex.Lock()
+	session := conn.fetchSession
+	conn.mutex.Unlock()
+	if session.id == 0 || session.epoch != 1 {
+		t.Fatalf("expected the first fetch request to create a session; got id=%d epoch=%d", session.id, session.epoch)
+	}
+
+	// The partition has no new messages, the response omits it.
+	read(0, 3)
+	read(0, 3)
+
+	write(2)
+	read(2, 5)
+
+	// The next fetch request of the evicted session fails, and the one
+	// following it creates a new session.
+	broker.EvictFetchSessions()
+	if err := conn.ReadBatchWith(ReadBatchConfig{MaxBytes: 1e6, MaxWait: 10 * time.Millisecond, FetchSession: true}).Close(); err != FetchSessionIDNotFound {
+		t.Fatalf("expected %v; got %v", FetchSessionIDNotFound, err)
+	}
+	read(0, 5)
+
+	write(1)
+	read(1, 6)
+}
+
+func TestConnTombstones(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.WriteMessages(
+		Message{Key: []byte("deleted"), Value: nil},
+		Message{Key: []byte("empty"), Value: []byte{}},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := conn.ReadBatch(1, 1e6)
+	defer batch.Close()
+
+	tombstone, err := batch.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tombstone.IsTombstone() || tombstone.Value != nil {
+		t.Errorf("expected a tombstone with a nil value; got %#v", tombstone.Value)
+	}
+
+	empty, err := batch.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.IsTombstone() || empty.Value == nil || len(empty.Value) != 0 {
+		t.Errorf("expected an empty, non-nil value; got %#v", empty.Value)
+	}
+}
+
+func TestConnNetConn(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tcp, ok := conn.NetConn().(*net.TCPConn)
+	if !ok {
+		t.Fatalf("expected a *net.TCPConn; got %T", conn.NetConn())
+	}
+	if err := tcp.SetKeepAlivePeriod(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Setting socket options leaves the connection usable.
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnWriteCompressedMessages(t *testing.T) {
+	codec := testGzipCodec{code: 5}
+	defer registerTestCodec(codec)()
+
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msgs := makeRandomMessages(10, 100)
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+	n, err := conn.WriteCompressedMessages(codec, msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10*100 {
+		t.Errorf("expected %d bytes to be written; got %d", 10*100, n)
+	}
+
+	written := broker.Messages("test", 0)
+	if len(written) != len(msgs) {
+		t.Fatalf("expected %d messages to be written; got %d", len(msgs), len(written))
+	}
+	for i, msg := range written {
+		if !bytes.Equal(msg.Value, msgs[i].Value) {
+			t.Errorf("value of message %d mismatch", i)
+		}
+	}
+
+	// The write deadline of the connection applies to compressed writes.
+	conn.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := conn.WriteCompressedMessages(codec, msgs...); !isTimeout(err) {
+		t.Errorf("expected a timeout error; got %v", err)
+	}
+}
+
+func TestConnBroker(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+	broker.MoveLeader("test", 0, 1)
+
+	var metadata int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockMetadata {
+			atomic.AddInt32(&metadata, 1)
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := broker.Addrs()[1]
+	host, port, _ := net.SplitHostPort(addr)
+	expected := Broker{Host: host, Port: mustAtoi(t, port), ID: 1}
+
+	// The broker is known from the metadata used to find the partition leader.
+	leader, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	if b := leader.Broker(); b != expected {
+		t.Errorf("expected the leader to be %+v; got %+v", expected, b)
+	}
+
+	// The broker is looked up in the metadata of the cluster.
+	conn, err := DialContext(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Only the first call sends a metadata request.
+	atomic.StoreInt32(&metadata, 0)
+	for i := 0; i < 3; i++ {
+		if b := conn.Broker(); b != expected {
+			t.Errorf("expected the broker to be %+v; got %+v", expected, b)
+		}
+	}
+	if n := atomic.LoadInt32(&metadata); n != 1 {
+		t.Errorf("expected 1 metadata request; got %d", n)
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestConnReadBatchContext(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	dial := func() *Conn {
+		conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	cfg := ReadBatchConfig{MinBytes: 1, MaxBytes: 1e6, MaxWait: 5 * time.Second}
+
+	// The fetch waits for messages until the context is canceled.
+	conn := dial()
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	t0 := time.Now()
+	batch := conn.ReadBatchContext(ctx, cfg)
+	if err := batch.Close(); err != context.Canceled {
+		t.Errorf("expected %v; got %v", context.Canceled, err)
+	}
+	if elapsed := time.Since(t0); elapsed > time.Second {
+		t.Errorf("expected the fetch to be aborted when the context was canceled; took %s", elapsed)
+	}
+
+	if batch := conn.ReadBatchContext(ctx, cfg); batch.Close() != context.Canceled {
+		t.Error("expected the fetch to fail with a canceled context")
+	}
+
+	// The deadline of the context applies to the fetch, and the deadline of
+	// the connection is restored after.
+	conn = dial()
+	defer conn.Close()
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	conn.SetReadDeadline(deadline)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	batch = conn.ReadBatchContext(ctx, cfg)
+	msg, err := batch.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Value) != "hello" {
+		t.Errorf("expected message %q; got %q", "hello", msg.Value)
+	}
+	if err := batch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := conn.rdeadline.deadline(); !d.Equal(deadline) {
+		t.Errorf("expected the read deadline to be restored to %v; got %v", deadline, d)
+	}
+}
+
+func TestConnReadBatchContextSetReadDeadline(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The program sets a new read deadline while the fetch is in progress,
+	// it is kept when the method returns.
+	deadline := time.Now().Add(time.Hour)
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch {
+			conn.SetReadDeadline(deadline)
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	batch := conn.ReadBatchContext(ctx, ReadBatchConfig{MinBytes: 1, MaxBytes: 1e6, MaxWait: 5 * time.Second})
+	if err := batch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := conn.rdeadline.deadline(); !d.Equal(deadline) {
+		t.Errorf("expected the read deadline set during the fetch to be kept; got %v", d)
+	}
+}
@@ -61,6 +61,10 @@ func (c *crc32Buffer) update() {
 	c.sum = crc32Update(c.sum, c.buf.Bytes())
 }
 
+// crc32cTable is used to compute the CRC32C checksums of record batches, which
+// were introduced with the v2 message format.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 func crc32Update(sum uint32, b []byte) uint32 {
 	return crc32.Update(sum, crc32.IEEETable, b)
 }
@@ -0,0 +1,268 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// ResourceType is the type of the resources that ACLs apply to.
+type ResourceType int8
+
+const (
+	ResourceTypeUnknown         ResourceType = 0
+	ResourceTypeAny             ResourceType = 1
+	ResourceTypeTopic           ResourceType = 2
+	ResourceTypeGroup           ResourceType = 3
+	ResourceTypeCluster         ResourceType = 4
+	ResourceTypeTransactionalID ResourceType = 5
+	ResourceTypeDelegationToken ResourceType = 6
+)
+
+// PatternType defines how the resource name of ACLs is matched against the
+// names of resources.
+type PatternType int8
+
+const (
+	PatternTypeUnknown PatternType = 0
+	// PatternTypeAny only applies to filters, it matches ACLs of any pattern
+	// type.
+	PatternTypeAny PatternType = 1
+	// PatternTypeMatch only applies to filters, it matches ACLs that would
+	// apply to the resource name of the filter, whatever their pattern type.
+	PatternTypeMatch    PatternType = 2
+	PatternTypeLiteral  PatternType = 3
+	PatternTypePrefixed PatternType = 4
+)
+
+// ACLOperationType is the operation that ACLs allow or deny.
+type ACLOperationType int8
+
+const (
+	ACLOperationTypeUnknown         ACLOperationType = 0
+	ACLOperationTypeAny             ACLOperationType = 1
+	ACLOperationTypeAll             ACLOperationType = 2
+	ACLOperationTypeRead            ACLOperationType = 3
+	ACLOperationTypeWrite           ACLOperationType = 4
+	ACLOperationTypeCreate          ACLOperationType = 5
+	ACLOperationTypeDelete          ACLOperationType = 6
+	ACLOperationTypeAlter           ACLOperationType = 7
+	ACLOperationTypeDescribe        ACLOperationType = 8
+	ACLOperationTypeClusterAction   ACLOperationType = 9
+	ACLOperationTypeDescribeConfigs ACLOperationType = 10
+	ACLOperationTypeAlterConfigs    ACLOperationType = 11
+	ACLOperationTypeIdempotentWrite ACLOperationType = 12
+)
+
+// ACLPermissionType defines whether ACLs allow or deny operations.
+type ACLPermissionType int8
+
+const (
+	ACLPermissionTypeUnknown ACLPermissionType = 0
+	ACLPermissionTypeAny     ACLPermissionType = 1
+	ACLPermissionTypeDeny    ACLPermissionType = 2
+	ACLPermissionTypeAllow   ACLPermissionType = 3
+)
+
+// ACLEntry is an access control entry, granting or denying a principal the
+// permission to perform an operation on a resource.
+type ACLEntry struct {
+	// ResourceType and ResourceName identify the resource that the entry
+	// applies to.
+	ResourceType ResourceType
+	ResourceName string
+
+	// ResourcePatternType defines how ResourceName is matched against resource
+	// names, either PatternTypeLiteral or PatternTypePrefixed.
+	ResourcePatternType PatternType
+
+	// Principal is the principal that the entry applies to, for example
+	// "User:alice".
+	Principal string
+
+	// Host is the host that the entry applies to, "*" for all hosts.
+	Host string
+
+	Operation      ACLOperationType
+	PermissionType ACLPermissionType
+}
+
+func (e ACLEntry) toCreateAclsRequestV1Creation() createAclsRequestV1Creation {
+	return createAclsRequestV1Creation{
+		ResourceType:        int8(e.ResourceType),
+		ResourceName:        e.ResourceName,
+		ResourcePatternType: int8(e.ResourcePatternType),
+		Principal:           e.Principal,
+		Host:                e.Host,
+		Operation:           int8(e.Operation),
+		PermissionType:      int8(e.PermissionType),
+	}
+}
+
+type createAclsRequestV1Creation struct {
+	ResourceType        int8
+	ResourceName        string
+	ResourcePatternType int8
+	Principal           string
+	Host                string
+	Operation           int8
+	PermissionType      int8
+}
+
+func (t createAclsRequestV1Creation) size() int32 {
+	return sizeofInt8(t.ResourceType) +
+		sizeofString(t.ResourceName) +
+		sizeofInt8(t.ResourcePatternType) +
+		sizeofString(t.Principal) +
+		sizeofString(t.Host) +
+		sizeofInt8(t.Operation) +
+		sizeofInt8(t.PermissionType)
+}
+
+func (t createAclsRequestV1Creation) writeTo(w *bufio.Writer) {
+	writeInt8(w, t.ResourceType)
+	writeString(w, t.ResourceName)
+	writeInt8(w, t.ResourcePatternType)
+	writeString(w, t.Principal)
+	writeString(w, t.Host)
+	writeInt8(w, t.Operation)
+	writeInt8(w, t.PermissionType)
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_CreateAcls
+type createAclsRequestV1 struct {
+	// Creations holds the ACLs to create
+	Creations []createAclsRequestV1Creation
+}
+
+func (t createAclsRequestV1) size() int32 {
+	return sizeofArray(len(t.Creations), func(i int) int32 { return t.Creations[i].size() })
+}
+
+func (t createAclsRequestV1) writeTo(w *bufio.Writer) {
+	writeArray(w, len(t.Creations), func(i int) { t.Creations[i].writeTo(w) })
+}
+
+type createAclsResponseV1CreationResponse struct {
+	ErrorCode    int16
+	ErrorMessage string
+}
+
+func (t createAclsResponseV1CreationResponse) size() int32 {
+	return sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage)
+}
+
+func (t createAclsResponseV1CreationResponse) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.ErrorCode)
+	writeString(w, t.ErrorMessage)
+}
+
+func (t *createAclsResponseV1CreationResponse) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt16(r, size, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+	return
+}
+
+type createAclsResponseV1 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// CreationResponses holds the results of each ACL creation, in the order
+	// of the request
+	CreationResponses []createAclsResponseV1CreationResponse
+}
+
+func (t createAclsResponseV1) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.CreationResponses), func(i int) int32 { return t.CreationResponses[i].size() })
+}
+
+func (t createAclsResponseV1) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.CreationResponses), func(i int) { t.CreationResponses[i].writeTo(w) })
+}
+
+func (t *createAclsResponseV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item createAclsResponseV1CreationResponse
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.CreationResponses = append(t.CreationResponses, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// createAcls creates the ACLs of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_CreateAcls
+func (c *Conn) createAcls(request createAclsRequestV1) (createAclsResponseV1, error) {
+	var response createAclsResponseV1
+
+	if _, err := c.negotiatedVersion(createAclsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		createAclsRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(createAclsRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return createAclsResponseV1{}, err
+	}
+
+	return response, nil
+}
+
+// CreateAcls creates the ACL entries passed as arguments.
+//
+// The creation of each entry may fail independently, the method returns a
+// slice of errors holding the result of each creation, in the order of the
+// arguments, where nil means that the entry was created. The second return
+// value is not nil if the request itself failed.
+//
+// ACLs are only supported by kafka 2.0 and above, and require an authorizer to
+// be configured on the brokers.
+func (c *Conn) CreateAcls(acls ...ACLEntry) ([]error, error) {
+	var creations []createAclsRequestV1Creation
+	for _, acl := range acls {
+		creations = append(creations, acl.toCreateAclsRequestV1Creation())
+	}
+
+	response, err := c.createAcls(createAclsRequestV1{
+		Creations: creations,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(response.CreationResponses))
+	for i, r := range response.CreationResponses {
+		if r.ErrorCode != 0 {
+			errs[i] = Error(r.ErrorCode)
+		}
+	}
+	return errs, nil
+}
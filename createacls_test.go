@@ -0,0 +1,40 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCreateAclsResponseV1(t *testing.T) {
+	item := createAclsResponseV1{
+		ThrottleTimeMS: 1,
+		CreationResponses: []createAclsResponseV1CreationResponse{
+			{
+				ErrorCode: 0,
+			},
+			{
+				ErrorCode:    int16(InvalidRequest),
+				ErrorMessage: "invalid principal",
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found createAclsResponseV1
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
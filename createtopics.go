@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"bufio"
+	"context"
 	"time"
 )
 
@@ -216,6 +217,7 @@ func (c *Conn) createTopics(request createTopicsRequestV0) (createTopicsResponse
 	var response createTopicsResponseV0
 
 	err := c.writeOperation(
+		createTopicsRequest,
 		func(deadline time.Time, id int32) error {
 			if request.Timeout == 0 {
 				now := time.Now()
@@ -265,3 +267,84 @@ func (c *Conn) CreateTopics(topics ...TopicConfig) error {
 		return err
 	}
 }
+
+// CreateTopicsAndWait creates the topics like CreateTopics, then polls the
+// metadata of the cluster until the topics have the requested number of
+// partitions and all of them have elected a leader, which lets programs
+// produce to the topics right away.
+//
+// When ctx has a deadline, it is set as the deadline of the connection. If ctx
+// is canceled or its deadline is exceeded before the topics are ready, the
+// method returns the last error that it got while polling the metadata, or
+// the error of ctx if there was none.
+func (c *Conn) CreateTopicsAndWait(ctx context.Context, topics ...TopicConfig) error {
+	const (
+		backoffDelayMin = 100 * time.Millisecond
+		backoffDelayMax = 1 * time.Second
+	)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	if err := c.CreateTopics(topics...); err != nil {
+		return err
+	}
+
+	names := make([]string, len(topics))
+	for i, t := range topics {
+		names[i] = t.Topic
+	}
+
+	var lastErr error
+
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 {
+			if !sleep(ctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
+				if lastErr == nil {
+					lastErr = ctx.Err()
+				}
+				return lastErr
+			}
+		}
+
+		partitions, err := c.ReadPartitions(names...)
+		if err != nil {
+			if !isTemporary(err) {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		if lastErr = topicsReady(topics, partitions); lastErr == nil {
+			return nil
+		}
+	}
+}
+
+// topicsReady returns nil if the partitions of the topics have leaders and the
+// topics have the number of partitions they were created with, or an error
+// describing why they are not ready yet otherwise.
+func topicsReady(topics []TopicConfig, partitions []Partition) error {
+	counts := make(map[string]int, len(topics))
+
+	for _, p := range partitions {
+		if p.Leader.ID < 0 {
+			return LeaderNotAvailable
+		}
+		counts[p.Topic]++
+	}
+
+	for _, t := range topics {
+		want := t.NumPartitions
+		if len(t.ReplicaAssignments) != 0 {
+			want = len(t.ReplicaAssignments)
+		}
+		if have := counts[t.Topic]; have == 0 || (want > 0 && have < want) {
+			return UnknownTopicOrPartition
+		}
+	}
+
+	return nil
+}
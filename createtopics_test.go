@@ -37,3 +37,54 @@ func TestCreateTopicsResponseV0(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestTopicsReady(t *testing.T) {
+	leader := Broker{ID: 1}
+	topics := []TopicConfig{
+		{Topic: "A", NumPartitions: 2},
+		{Topic: "B", NumPartitions: -1},
+	}
+
+	tests := map[string]struct {
+		partitions []Partition
+		err        error
+	}{
+		"ready": {
+			partitions: []Partition{
+				{Topic: "A", ID: 0, Leader: leader},
+				{Topic: "A", ID: 1, Leader: leader},
+				{Topic: "B", ID: 0, Leader: leader},
+			},
+		},
+		"missing partition": {
+			partitions: []Partition{
+				{Topic: "A", ID: 0, Leader: leader},
+				{Topic: "B", ID: 0, Leader: leader},
+			},
+			err: UnknownTopicOrPartition,
+		},
+		"missing topic": {
+			partitions: []Partition{
+				{Topic: "A", ID: 0, Leader: leader},
+				{Topic: "A", ID: 1, Leader: leader},
+			},
+			err: UnknownTopicOrPartition,
+		},
+		"no leader": {
+			partitions: []Partition{
+				{Topic: "A", ID: 0, Leader: leader},
+				{Topic: "A", ID: 1, Leader: Broker{ID: -1}},
+				{Topic: "B", ID: 0, Leader: leader},
+			},
+			err: LeaderNotAvailable,
+		},
+	}
+
+	for label, test := range tests {
+		t.Run(label, func(t *testing.T) {
+			if err := topicsReady(topics, test.partitions); err != test.err {
+				t.Errorf("expected %v; got %v", test.err, err)
+			}
+		})
+	}
+}
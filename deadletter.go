@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Header keys set by DeadLetterWriter on the messages it produces, they carry
+// the reason why the original message failed and where it was read from.
+const (
+	DeadLetterErrorHeader     = "kafka-dlq-error"
+	DeadLetterTopicHeader     = "kafka-dlq-topic"
+	DeadLetterPartitionHeader = "kafka-dlq-partition"
+	DeadLetterOffsetHeader    = "kafka-dlq-offset"
+	DeadLetterTimeHeader      = "kafka-dlq-time"
+)
+
+// DeadLetterWriter republishes messages that a program failed to process to a
+// dead letter topic, where they can be inspected and reprocessed later.
+//
+// The messages produced to the dead letter topic keep the key, value, headers
+// and time of the original messages, the processing error and the origin of
+// the message are added as headers (see the DeadLetter*Header constants).
+// Partition and offset are encoded as decimal numbers, and the time at which
+// the message was dead-lettered is formatted with time.RFC3339Nano.
+//
+// Note that headers are only supported by kafka 0.11 and above.
+type DeadLetterWriter struct {
+	writer *Writer
+	now    func() time.Time
+}
+
+// NewDeadLetterWriter creates and returns a new DeadLetterWriter producing to
+// the topic set in config.
+//
+// Unless a balancer is configured, messages are distributed using the Hash
+// balancer so records with the same key end up on the same partition of the
+// dead letter topic, which lets programs reprocess them in order.
+func NewDeadLetterWriter(config WriterConfig) *DeadLetterWriter {
+	if config.Balancer == nil {
+		config.Balancer = &Hash{}
+	}
+	return &DeadLetterWriter{
+		writer: NewWriter(config),
+		now:    time.Now,
+	}
+}
+
+// WriteDeadLetter produces msg to the dead letter topic, along with err which
+// is the reason why processing the message failed.
+//
+// The method has the same semantics as Writer.WriteMessages.
+func (d *DeadLetterWriter) WriteDeadLetter(ctx context.Context, msg Message, err error) error {
+	return d.writer.WriteMessages(ctx, makeDeadLetter(msg, err, d.now()))
+}
+
+// Stats returns a snapshot of the stats of the underlying writer.
+func (d *DeadLetterWriter) Stats() WriterStats {
+	return d.writer.Stats()
+}
+
+// Close flushes all buffered messages and closes the dead letter writer.
+func (d *DeadLetterWriter) Close() error {
+	return d.writer.Close()
+}
+
+func makeDeadLetter(msg Message, err error, now time.Time) Message {
+	var reason string
+	if err != nil {
+		reason = err.Error()
+	}
+
+	headers := make([]Header, 0, len(msg.Headers)+5)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		Header{Key: DeadLetterErrorHeader, Value: []byte(reason)},
+		Header{Key: DeadLetterTopicHeader, Value: []byte(msg.Topic)},
+		Header{Key: DeadLetterPartitionHeader, Value: []byte(strconv.Itoa(msg.Partition))},
+		Header{Key: DeadLetterOffsetHeader, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		Header{Key: DeadLetterTimeHeader, Value: []byte(now.UTC().Format(time.RFC3339Nano))},
+	)
+
+	// Topic, partition and offset must not be set when writing messages, they
+	// are assigned when producing to the dead letter topic.
+	return Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    msg.Time,
+	}
+}
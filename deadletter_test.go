@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMakeDeadLetter(t *testing.T) {
+	now := time.Date(2019, 4, 1, 12, 30, 0, 0, time.UTC)
+	msg := Message{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   []Header{{Key: "trace-id", Value: []byte("abc")}},
+		Time:      now.Add(-time.Hour),
+	}
+
+	found := makeDeadLetter(msg, errors.New("bad payload"), now)
+	expected := Message{
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Headers: []Header{
+			{Key: "trace-id", Value: []byte("abc")},
+			{Key: DeadLetterErrorHeader, Value: []byte("bad payload")},
+			{Key: DeadLetterTopicHeader, Value: []byte("orders")},
+			{Key: DeadLetterPartitionHeader, Value: []byte("3")},
+			{Key: DeadLetterOffsetHeader, Value: []byte("42")},
+			{Key: DeadLetterTimeHeader, Value: []byte("2019-04-01T12:30:00Z")},
+		},
+		Time: now.Add(-time.Hour),
+	}
+
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("expected %+v; got %+v", expected, found)
+	}
+}
+
+func TestDeadLetterWriter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	w := NewDeadLetterWriter(WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   topic,
+	})
+	defer w.Close()
+
+	msg := Message{Topic: "orders", Partition: 1, Offset: 10, Key: []byte("key"), Value: []byte("value")}
+	if err := w.WriteDeadLetter(ctx, msg, errors.New("bad payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:  []string{"localhost:9092"},
+		Topic:    topic,
+		MinBytes: 1,
+		MaxBytes: 10e6,
+		MaxWait:  100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(m.Key) != "key" || string(m.Value) != "value" {
+		t.Errorf("unexpected key/value: %q/%q", m.Key, m.Value)
+	}
+
+	headers := make(map[string]string)
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	for key, value := range map[string]string{
+		DeadLetterErrorHeader:     "bad payload",
+		DeadLetterTopicHeader:     "orders",
+		DeadLetterPartitionHeader: "1",
+		DeadLetterOffsetHeader:    "10",
+	} {
+		if headers[key] != value {
+			t.Errorf("expected header %s to be %q; got %q", key, value, headers[key])
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// DeleteAclsResult is the result of deleting the ACL entries matching a filter.
+type DeleteAclsResult struct {
+	// Error is set if no entries could be deleted for the filter.
+	Error error
+
+	// MatchingAcls holds the entries that matched the filter, along with the
+	// result of their deletion.
+	MatchingAcls []DeleteAclsMatchingAcl
+}
+
+// DeleteAclsMatchingAcl is an ACL entry which matched a filter passed to
+// DeleteAcls.
+type DeleteAclsMatchingAcl struct {
+	ACLEntry
+
+	// Error is set if the entry could not be deleted.
+	Error error
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_DeleteAcls
+type deleteAclsRequestV1 struct {
+	// Filters holds the filters selecting the ACLs to delete
+	Filters []aclsRequestV1Filter
+}
+
+func (t deleteAclsRequestV1) size() int32 {
+	return sizeofArray(len(t.Filters), func(i int) int32 { return t.Filters[i].size() })
+}
+
+func (t deleteAclsRequestV1) writeTo(w *bufio.Writer) {
+	writeArray(w, len(t.Filters), func(i int) { t.Filters[i].writeTo(w) })
+}
+
+type deleteAclsResponseV1MatchingAcl struct {
+	ErrorCode      int16
+	ErrorMessage   string
+	ResourceType   int8
+	ResourceName   string
+	PatternType    int8
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (t deleteAclsResponseV1MatchingAcl) size() int32 {
+	return sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage) +
+		sizeofInt8(t.ResourceType) +
+		sizeofString(t.ResourceName) +
+		sizeofInt8(t.PatternType) +
+		sizeofString(t.Principal) +
+		sizeofString(t.Host) +
+		sizeofInt8(t.Operation) +
+		sizeofInt8(t.PermissionType)
+}
+
+func (t deleteAclsResponseV1MatchingAcl) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.ErrorCode)
+	writeString(w, t.ErrorMessage)
+	writeInt8(w, t.ResourceType)
+	writeString(w, t.ResourceName)
+	writeInt8(w, t.PatternType)
+	writeString(w, t.Principal)
+	writeString(w, t.Host)
+	writeInt8(w, t.Operation)
+	writeInt8(w, t.PermissionType)
+}
+
+func (t *deleteAclsResponseV1MatchingAcl) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt16(r, size, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.ResourceType); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ResourceName); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.PatternType); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.Principal); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.Host); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.Operation); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.PermissionType); err != nil {
+		return
+	}
+	return
+}
+
+type deleteAclsResponseV1FilterResponse struct {
+	ErrorCode    int16
+	ErrorMessage string
+	MatchingAcls []deleteAclsResponseV1MatchingAcl
+}
+
+func (t deleteAclsResponseV1FilterResponse) size() int32 {
+	return sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage) +
+		sizeofArray(len(t.MatchingAcls), func(i int) int32 { return t.MatchingAcls[i].size() })
+}
+
+func (t deleteAclsResponseV1FilterResponse) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.ErrorCode)
+	writeString(w, t.ErrorMessage)
+	writeArray(w, len(t.MatchingAcls), func(i int) { t.MatchingAcls[i].writeTo(w) })
+}
+
+func (t *deleteAclsResponseV1FilterResponse) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt16(r, size, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item deleteAclsResponseV1MatchingAcl
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.MatchingAcls = append(t.MatchingAcls, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type deleteAclsResponseV1 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// FilterResponses holds the results of each filter, in the order of the
+	// request
+	FilterResponses []deleteAclsResponseV1FilterResponse
+}
+
+func (t deleteAclsResponseV1) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.FilterResponses), func(i int) int32 { return t.FilterResponses[i].size() })
+}
+
+func (t deleteAclsResponseV1) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.FilterResponses), func(i int) { t.FilterResponses[i].writeTo(w) })
+}
+
+func (t *deleteAclsResponseV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item deleteAclsResponseV1FilterResponse
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.FilterResponses = append(t.FilterResponses, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// deleteAcls deletes the ACLs matching the filters of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_DeleteAcls
+func (c *Conn) deleteAcls(request deleteAclsRequestV1) (deleteAclsResponseV1, error) {
+	var response deleteAclsResponseV1
+
+	if _, err := c.negotiatedVersion(deleteAclsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		deleteAclsRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(deleteAclsRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return deleteAclsResponseV1{}, err
+	}
+
+	return response, nil
+}
+
+// DeleteAcls deletes the ACL entries matching the filters passed as arguments.
+//
+// The deletion of entries may fail independently, the method returns the
+// result of each filter, in the order of the arguments. The error of a result
+// is set if the filter failed as a whole, while the errors of its matching
+// entries report which of them could not be deleted. The second return value
+// is not nil if the request itself failed.
+//
+// ACLs are only supported by kafka 2.0 and above, and require an authorizer to
+// be configured on the brokers.
+func (c *Conn) DeleteAcls(filters ...ACLFilter) ([]DeleteAclsResult, error) {
+	var requestFilters []aclsRequestV1Filter
+	for _, filter := range filters {
+		requestFilters = append(requestFilters, filter.toAclsRequestV1Filter())
+	}
+
+	response, err := c.deleteAcls(deleteAclsRequestV1{
+		Filters: requestFilters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DeleteAclsResult, len(response.FilterResponses))
+	for i, r := range response.FilterResponses {
+		if r.ErrorCode != 0 {
+			results[i].Error = Error(r.ErrorCode)
+		}
+		for _, acl := range r.MatchingAcls {
+			matching := DeleteAclsMatchingAcl{
+				ACLEntry: ACLEntry{
+					ResourceType:        ResourceType(acl.ResourceType),
+					ResourceName:        acl.ResourceName,
+					ResourcePatternType: PatternType(acl.PatternType),
+					Principal:           acl.Principal,
+					Host:                acl.Host,
+					Operation:           ACLOperationType(acl.Operation),
+					PermissionType:      ACLPermissionType(acl.PermissionType),
+				},
+			}
+			if acl.ErrorCode != 0 {
+				matching.Error = Error(acl.ErrorCode)
+			}
+			results[i].MatchingAcls = append(results[i].MatchingAcls, matching)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDeleteAclsResponseV1(t *testing.T) {
+	item := deleteAclsResponseV1{
+		ThrottleTimeMS: 1,
+		FilterResponses: []deleteAclsResponseV1FilterResponse{
+			{
+				MatchingAcls: []deleteAclsResponseV1MatchingAcl{
+					{
+						ResourceType:   int8(ResourceTypeGroup),
+						ResourceName:   "g",
+						PatternType:    int8(PatternTypePrefixed),
+						Principal:      "User:bob",
+						Host:           "*",
+						Operation:      int8(ACLOperationTypeRead),
+						PermissionType: int8(ACLPermissionTypeDeny),
+					},
+				},
+			},
+			{
+				ErrorCode:    int16(SecurityDisabled),
+				ErrorMessage: "no authorizer",
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found deleteAclsResponseV1
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
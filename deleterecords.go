@@ -0,0 +1,249 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_DeleteRecords
+type deleteRecordsRequestV0 struct {
+	// Topics holds the partitions to delete records from.
+	Topics []deleteRecordsRequestV0Topic
+
+	// TimeoutMS is the time in milliseconds to wait for the records to be
+	// deleted from all replicas.
+	TimeoutMS int32
+}
+
+func (t deleteRecordsRequestV0) size() int32 {
+	return sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() }) +
+		sizeofInt32(t.TimeoutMS)
+}
+
+func (t deleteRecordsRequestV0) writeTo(w *bufio.Writer) {
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+	writeInt32(w, t.TimeoutMS)
+}
+
+type deleteRecordsRequestV0Topic struct {
+	Name       string
+	Partitions []deleteRecordsRequestV0Partition
+}
+
+func (t deleteRecordsRequestV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t deleteRecordsRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+type deleteRecordsRequestV0Partition struct {
+	PartitionIndex int32
+
+	// Offset is the offset before which records are deleted, -1 deletes all
+	// the records up to the high watermark of the partition.
+	Offset int64
+}
+
+func (t deleteRecordsRequestV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt64(t.Offset)
+}
+
+func (t deleteRecordsRequestV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt64(w, t.Offset)
+}
+
+type deleteRecordsResponseV0Partition struct {
+	PartitionIndex int32
+	LowWatermark   int64
+	ErrorCode      int16
+}
+
+func (t deleteRecordsResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt64(t.LowWatermark) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t deleteRecordsResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt64(w, t.LowWatermark)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *deleteRecordsResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt64(r, remain, &t.LowWatermark); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+type deleteRecordsResponseV0Topic struct {
+	Name       string
+	Partitions []deleteRecordsResponseV0Partition
+}
+
+func (t deleteRecordsResponseV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t deleteRecordsResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+func (t *deleteRecordsResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item deleteRecordsResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type deleteRecordsResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// Topics holds the results of the deletion in each partition.
+	Topics []deleteRecordsResponseV0Topic
+}
+
+func (t deleteRecordsResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t deleteRecordsResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+func (t *deleteRecordsResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item deleteRecordsResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// deleteRecords deletes the records of the partitions of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_DeleteRecords
+func (c *Conn) deleteRecords(request deleteRecordsRequestV0) (deleteRecordsResponseV0, error) {
+	var response deleteRecordsResponseV0
+
+	if _, err := c.negotiatedVersion(deleteRecordsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		deleteRecordsRequest,
+		func(deadline time.Time, id int32) error {
+			if request.TimeoutMS == 0 {
+				now := time.Now()
+				deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
+				request.TimeoutMS = milliseconds(deadlineToTimeout(deadline, now))
+			}
+			return c.writeRequest(deleteRecordsRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return deleteRecordsResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// DeleteRecords deletes the records of the partitions of topic which are
+// before the offsets in offsets, which maps partition numbers to offsets. The
+// offsets may be LastOffset to delete all the records of a partition, while
+// offsets beyond the high watermark of a partition are rejected by kafka with
+// OffsetOutOfRange.
+//
+// The method returns the new low watermark of each partition, which is the
+// offset of the first record left in the partition. When the deletion fails
+// in a partition, the method returns the error of the first partition which
+// failed, along with the low watermarks of the partitions which succeeded.
+//
+// Records are deleted by the leaders of the partitions, so the connection must
+// be established to the leader of all the partitions (see DialLeader).
+func (c *Conn) DeleteRecords(topic string, offsets map[int]int64) (map[int]int64, error) {
+	partitions := make([]int, 0, len(offsets))
+	for p := range offsets {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+
+	requestTopic := deleteRecordsRequestV0Topic{Name: topic}
+	for _, p := range partitions {
+		requestTopic.Partitions = append(requestTopic.Partitions, deleteRecordsRequestV0Partition{
+			PartitionIndex: int32(p),
+			Offset:         offsets[p],
+		})
+	}
+
+	response, err := c.deleteRecords(deleteRecordsRequestV0{
+		Topics: []deleteRecordsRequestV0Topic{requestTopic},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lowWatermarks := make(map[int]int64, len(partitions))
+	for _, t := range response.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 {
+				if err == nil {
+					err = Error(p.ErrorCode)
+				}
+				continue
+			}
+			lowWatermarks[int(p.PartitionIndex)] = p.LowWatermark
+		}
+	}
+	return lowWatermarks, err
+}
@@ -0,0 +1,143 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeleteRecordsResponseV0(t *testing.T) {
+	item := deleteRecordsResponseV0{
+		ThrottleTimeMS: 1,
+		Topics: []deleteRecordsResponseV0Topic{
+			{
+				Name: "a",
+				Partitions: []deleteRecordsResponseV0Partition{
+					{
+						PartitionIndex: 0,
+						LowWatermark:   42,
+					},
+					{
+						PartitionIndex: 1,
+						LowWatermark:   -1,
+						ErrorCode:      int16(OffsetOutOfRange),
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found deleteRecordsResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestDeleteRecordsRequestV0(t *testing.T) {
+	request := deleteRecordsRequestV0{
+		Topics: []deleteRecordsRequestV0Topic{
+			{
+				Name: "a",
+				Partitions: []deleteRecordsRequestV0Partition{
+					{PartitionIndex: 0, Offset: 42},
+					{PartitionIndex: 1, Offset: LastOffset},
+				},
+			},
+		},
+		TimeoutMS: 1000,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
+
+func TestConnDeleteRecords(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 2)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	for p := 0; p != 2; p++ {
+		leader, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leader.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err = leader.WriteMessages(Message{Value: []byte("A")}, Message{Value: []byte("B")}, Message{Value: []byte("C")})
+		leader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Offsets beyond the high watermark are rejected, the other partitions
+	// are still trimmed.
+	lowWatermarks, err := conn.DeleteRecords("test", map[int]int64{0: 2, 1: 4})
+	if err != OffsetOutOfRange {
+		t.Errorf("expected %v; got %v", OffsetOutOfRange, err)
+	}
+	if want := map[int]int64{0: 2}; !reflect.DeepEqual(lowWatermarks, want) {
+		t.Errorf("expected low watermarks %v; got %v", want, lowWatermarks)
+	}
+	if n := len(broker.Messages("test", 1)); n != 3 {
+		t.Errorf("expected the 3 messages of partition 1 to be kept; got %d", n)
+	}
+
+	// The high watermark itself and LastOffset delete all the records, the
+	// low watermark never moves backward.
+	lowWatermarks, err = conn.DeleteRecords("test", map[int]int64{0: 1, 1: LastOffset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{0: 2, 1: 3}; !reflect.DeepEqual(lowWatermarks, want) {
+		t.Errorf("expected low watermarks %v; got %v", want, lowWatermarks)
+	}
+	if msgs := broker.Messages("test", 0); len(msgs) != 1 || string(msgs[0].Value) != "C" {
+		t.Errorf("expected message C to be left in partition 0; got %v", msgs)
+	}
+	if n := len(broker.Messages("test", 1)); n != 0 {
+		t.Errorf("expected all the messages of partition 1 to be deleted; got %d", n)
+	}
+
+	first, err := conn.ReadFirstOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 2 {
+		t.Errorf("expected the first offset of partition 0 to be 2; got %d", first)
+	}
+}
@@ -88,6 +88,7 @@ func (t deleteTopicsResponseV0TopicErrorCode) writeTo(w *bufio.Writer) {
 func (c *Conn) deleteTopics(request deleteTopicsRequestV0) (deleteTopicsResponseV0, error) {
 	var response deleteTopicsResponseV0
 	err := c.writeOperation(
+		deleteTopicsRequest,
 		func(deadline time.Time, id int32) error {
 			if request.Timeout == 0 {
 				now := time.Now()
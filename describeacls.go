@@ -0,0 +1,267 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// ACLFilter selects ACL entries to describe or delete.
+//
+// The zero-value of ResourceName, Principal and Host matches any value, the
+// ResourceTypeAny, PatternTypeAny, ACLOperationTypeAny and ACLPermissionTypeAny
+// constants can be used to match any type.
+type ACLFilter struct {
+	ResourceType        ResourceType
+	ResourceName        string
+	ResourcePatternType PatternType
+	Principal           string
+	Host                string
+	Operation           ACLOperationType
+	PermissionType      ACLPermissionType
+}
+
+func (f ACLFilter) toAclsRequestV1Filter() aclsRequestV1Filter {
+	return aclsRequestV1Filter{
+		ResourceType:        int8(f.ResourceType),
+		ResourceName:        f.ResourceName,
+		ResourcePatternType: int8(f.ResourcePatternType),
+		Principal:           f.Principal,
+		Host:                f.Host,
+		Operation:           int8(f.Operation),
+		PermissionType:      int8(f.PermissionType),
+	}
+}
+
+// aclsRequestV1Filter is the filter used by both the DescribeAcls and
+// DeleteAcls requests, empty strings are sent as null strings.
+type aclsRequestV1Filter struct {
+	ResourceType        int8
+	ResourceName        string
+	ResourcePatternType int8
+	Principal           string
+	Host                string
+	Operation           int8
+	PermissionType      int8
+}
+
+func (t aclsRequestV1Filter) size() int32 {
+	return sizeofInt8(t.ResourceType) +
+		sizeofString(t.ResourceName) +
+		sizeofInt8(t.ResourcePatternType) +
+		sizeofString(t.Principal) +
+		sizeofString(t.Host) +
+		sizeofInt8(t.Operation) +
+		sizeofInt8(t.PermissionType)
+}
+
+func (t aclsRequestV1Filter) writeTo(w *bufio.Writer) {
+	writeInt8(w, t.ResourceType)
+	writeNullableString(w, t.ResourceName)
+	writeInt8(w, t.ResourcePatternType)
+	writeNullableString(w, t.Principal)
+	writeNullableString(w, t.Host)
+	writeInt8(w, t.Operation)
+	writeInt8(w, t.PermissionType)
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_DescribeAcls
+type describeAclsRequestV1 struct {
+	aclsRequestV1Filter
+}
+
+type describeAclsResponseV1Acl struct {
+	Principal      string
+	Host           string
+	Operation      int8
+	PermissionType int8
+}
+
+func (t describeAclsResponseV1Acl) size() int32 {
+	return sizeofString(t.Principal) +
+		sizeofString(t.Host) +
+		sizeofInt8(t.Operation) +
+		sizeofInt8(t.PermissionType)
+}
+
+func (t describeAclsResponseV1Acl) writeTo(w *bufio.Writer) {
+	writeString(w, t.Principal)
+	writeString(w, t.Host)
+	writeInt8(w, t.Operation)
+	writeInt8(w, t.PermissionType)
+}
+
+func (t *describeAclsResponseV1Acl) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Principal); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.Host); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.Operation); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.PermissionType); err != nil {
+		return
+	}
+	return
+}
+
+type describeAclsResponseV1Resource struct {
+	ResourceType int8
+	ResourceName string
+	PatternType  int8
+	Acls         []describeAclsResponseV1Acl
+}
+
+func (t describeAclsResponseV1Resource) size() int32 {
+	return sizeofInt8(t.ResourceType) +
+		sizeofString(t.ResourceName) +
+		sizeofInt8(t.PatternType) +
+		sizeofArray(len(t.Acls), func(i int) int32 { return t.Acls[i].size() })
+}
+
+func (t describeAclsResponseV1Resource) writeTo(w *bufio.Writer) {
+	writeInt8(w, t.ResourceType)
+	writeString(w, t.ResourceName)
+	writeInt8(w, t.PatternType)
+	writeArray(w, len(t.Acls), func(i int) { t.Acls[i].writeTo(w) })
+}
+
+func (t *describeAclsResponseV1Resource) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt8(r, size, &t.ResourceType); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ResourceName); err != nil {
+		return
+	}
+	if remain, err = readInt8(r, remain, &t.PatternType); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item describeAclsResponseV1Acl
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Acls = append(t.Acls, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type describeAclsResponseV1 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	ErrorCode    int16
+	ErrorMessage string
+
+	// Resources holds the resources and their ACLs matching the filter
+	Resources []describeAclsResponseV1Resource
+}
+
+func (t describeAclsResponseV1) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage) +
+		sizeofArray(len(t.Resources), func(i int) int32 { return t.Resources[i].size() })
+}
+
+func (t describeAclsResponseV1) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeString(w, t.ErrorMessage)
+	writeArray(w, len(t.Resources), func(i int) { t.Resources[i].writeTo(w) })
+}
+
+func (t *describeAclsResponseV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item describeAclsResponseV1Resource
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Resources = append(t.Resources, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// describeAcls retrieves the ACLs matching the filter of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_DescribeAcls
+func (c *Conn) describeAcls(request describeAclsRequestV1) (describeAclsResponseV1, error) {
+	var response describeAclsResponseV1
+
+	if _, err := c.negotiatedVersion(describeAclsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.readOperation(
+		describeAclsRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(describeAclsRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return describeAclsResponseV1{}, err
+	}
+	if response.ErrorCode != 0 {
+		return describeAclsResponseV1{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
+// DescribeAcls returns the ACL entries matching filter.
+//
+// ACLs are only supported by kafka 2.0 and above, and require an authorizer to
+// be configured on the brokers.
+func (c *Conn) DescribeAcls(filter ACLFilter) ([]ACLEntry, error) {
+	response, err := c.describeAcls(describeAclsRequestV1{
+		aclsRequestV1Filter: filter.toAclsRequestV1Filter(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var acls []ACLEntry
+	for _, resource := range response.Resources {
+		for _, acl := range resource.Acls {
+			acls = append(acls, ACLEntry{
+				ResourceType:        ResourceType(resource.ResourceType),
+				ResourceName:        resource.ResourceName,
+				ResourcePatternType: PatternType(resource.PatternType),
+				Principal:           acl.Principal,
+				Host:                acl.Host,
+				Operation:           ACLOperationType(acl.Operation),
+				PermissionType:      ACLPermissionType(acl.PermissionType),
+			})
+		}
+	}
+	return acls, nil
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDescribeAclsRequestV1(t *testing.T) {
+	item := describeAclsRequestV1{
+		aclsRequestV1Filter: ACLFilter{
+			ResourceType:        ResourceTypeTopic,
+			ResourceName:        "a",
+			ResourcePatternType: PatternTypeAny,
+			Operation:           ACLOperationTypeAny,
+			PermissionType:      ACLPermissionTypeAny,
+		}.toAclsRequestV1Filter(),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	expected := []byte{
+		2,         // resource type
+		0, 1, 'a', // resource name
+		1,          // pattern type
+		0xff, 0xff, // null principal
+		0xff, 0xff, // null host
+		1, // operation
+		1, // permission type
+	}
+	if !bytes.Equal(expected, buf.Bytes()) {
+		t.Fatalf("expected %v, got %v", expected, buf.Bytes())
+	}
+	if size := int(item.size()); size != len(expected) {
+		t.Fatalf("expected size %v, got %v", len(expected), size)
+	}
+}
+
+func TestDescribeAclsResponseV1(t *testing.T) {
+	item := describeAclsResponseV1{
+		ThrottleTimeMS: 1,
+		Resources: []describeAclsResponseV1Resource{
+			{
+				ResourceType: int8(ResourceTypeTopic),
+				ResourceName: "a",
+				PatternType:  int8(PatternTypeLiteral),
+				Acls: []describeAclsResponseV1Acl{
+					{
+						Principal:      "User:alice",
+						Host:           "*",
+						Operation:      int8(ACLOperationTypeRead),
+						PermissionType: int8(ACLPermissionTypeAllow),
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found describeAclsResponseV1
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
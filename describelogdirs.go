@@ -0,0 +1,324 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// LogDirDescription describes a log directory of a broker, as returned by
+// Conn.DescribeLogDirs.
+type LogDirDescription struct {
+	// Path is the absolute path of the log directory on the broker.
+	Path string
+
+	// Error is nil if the log directory could be described. It is
+	// KafkaStorageError when the directory is offline, in which case it has
+	// no partitions.
+	Error error
+
+	// Partitions holds the replicas stored in the log directory.
+	Partitions []LogDirPartition
+}
+
+// LogDirPartition describes the replica of a partition stored in a log
+// directory.
+type LogDirPartition struct {
+	Topic     string
+	Partition int
+
+	// Size is the size of the log segments of the replica in bytes.
+	Size int64
+
+	// OffsetLag is the number of messages that the replica lags behind the
+	// high watermark of the partition when it is the current replica, or
+	// behind the current replica when it is a future one.
+	OffsetLag int64
+
+	// IsFuture is true if the replica is being moved to the log directory by
+	// a reassignment and will replace the current replica once it catches up.
+	IsFuture bool
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_DescribeLogDirs
+type describeLogDirsRequestV0 struct {
+	// Topics holds the partitions to describe, a null array is sent when nil
+	// to describe all partitions.
+	Topics []describeLogDirsRequestV0Topic
+}
+
+func (t describeLogDirsRequestV0) size() int32 {
+	return sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t describeLogDirsRequestV0) writeTo(w *bufio.Writer) {
+	if t.Topics == nil {
+		writeInt32(w, -1)
+	} else {
+		writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+	}
+}
+
+type describeLogDirsRequestV0Topic struct {
+	Topic            string
+	PartitionIndexes []int32
+}
+
+func (t describeLogDirsRequestV0Topic) size() int32 {
+	return sizeofString(t.Topic) +
+		sizeofInt32Array(t.PartitionIndexes)
+}
+
+func (t describeLogDirsRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Topic)
+	writeInt32Array(w, t.PartitionIndexes)
+}
+
+type describeLogDirsResponseV0Partition struct {
+	PartitionIndex int32
+	PartitionSize  int64
+	OffsetLag      int64
+	IsFutureKey    bool
+}
+
+func (t describeLogDirsResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt64(t.PartitionSize) +
+		sizeofInt64(t.OffsetLag) +
+		sizeofBool(t.IsFutureKey)
+}
+
+func (t describeLogDirsResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt64(w, t.PartitionSize)
+	writeInt64(w, t.OffsetLag)
+	writeBool(w, t.IsFutureKey)
+}
+
+func (t *describeLogDirsResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt64(r, remain, &t.PartitionSize); err != nil {
+		return
+	}
+	if remain, err = readInt64(r, remain, &t.OffsetLag); err != nil {
+		return
+	}
+	if remain, err = readBool(r, remain, &t.IsFutureKey); err != nil {
+		return
+	}
+	return
+}
+
+type describeLogDirsResponseV0Topic struct {
+	Name       string
+	Partitions []describeLogDirsResponseV0Partition
+}
+
+func (t describeLogDirsResponseV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t describeLogDirsResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+func (t *describeLogDirsResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item describeLogDirsResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type describeLogDirsResponseV0Result struct {
+	ErrorCode int16
+	LogDir    string
+	Topics    []describeLogDirsResponseV0Topic
+}
+
+func (t describeLogDirsResponseV0Result) size() int32 {
+	return sizeofInt16(t.ErrorCode) +
+		sizeofString(t.LogDir) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t describeLogDirsResponseV0Result) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.ErrorCode)
+	writeString(w, t.LogDir)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+func (t *describeLogDirsResponseV0Result) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt16(r, size, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.LogDir); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item describeLogDirsResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type describeLogDirsResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// Results holds the description of each log directory of the broker.
+	Results []describeLogDirsResponseV0Result
+}
+
+func (t describeLogDirsResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Results), func(i int) int32 { return t.Results[i].size() })
+}
+
+func (t describeLogDirsResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Results), func(i int) { t.Results[i].writeTo(w) })
+}
+
+func (t *describeLogDirsResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item describeLogDirsResponseV0Result
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Results = append(t.Results, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// describeLogDirs describes the log directories of the broker.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_DescribeLogDirs
+func (c *Conn) describeLogDirs(request describeLogDirsRequestV0) (describeLogDirsResponseV0, error) {
+	var response describeLogDirsResponseV0
+
+	if _, err := c.negotiatedVersion(describeLogDirsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		describeLogDirsRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(describeLogDirsRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return describeLogDirsResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// DescribeLogDirs describes the log directories of the broker that the
+// connection is established to, with the size and offset lag of the replicas
+// of the partitions of topics that each of them stores. All the partitions
+// stored by the broker are described when topics is empty.
+//
+// The log directories of a cluster are described by sending the request to
+// each of its brokers (see Brokers). The method returns UnsupportedVersion if
+// the broker does not support the request, which requires kafka 1.0 or above.
+func (c *Conn) DescribeLogDirs(topics []string) ([]LogDirDescription, error) {
+	if _, err := c.negotiatedVersion(describeLogDirsRequest); err != nil {
+		return nil, err
+	}
+
+	var request describeLogDirsRequestV0
+	if len(topics) != 0 {
+		partitions, err := c.ReadPartitions(topics...)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes := make(map[string][]int32, len(topics))
+		for _, p := range partitions {
+			indexes[p.Topic] = append(indexes[p.Topic], int32(p.ID))
+		}
+
+		request.Topics = []describeLogDirsRequestV0Topic{}
+		for _, topic := range topics {
+			request.Topics = append(request.Topics, describeLogDirsRequestV0Topic{
+				Topic:            topic,
+				PartitionIndexes: indexes[topic],
+			})
+		}
+	}
+
+	response, err := c.describeLogDirs(request)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]LogDirDescription, 0, len(response.Results))
+	for _, r := range response.Results {
+		dir := LogDirDescription{Path: r.LogDir}
+		if r.ErrorCode != 0 {
+			dir.Error = Error(r.ErrorCode)
+		}
+		for _, t := range r.Topics {
+			for _, p := range t.Partitions {
+				dir.Partitions = append(dir.Partitions, LogDirPartition{
+					Topic:     t.Name,
+					Partition: int(p.PartitionIndex),
+					Size:      p.PartitionSize,
+					OffsetLag: p.OffsetLag,
+					IsFuture:  p.IsFutureKey,
+				})
+			}
+		}
+		sort.Slice(dir.Partitions, func(i, j int) bool {
+			a, b := dir.Partitions[i], dir.Partitions[j]
+			if a.Topic != b.Topic {
+				return a.Topic < b.Topic
+			}
+			return a.Partition < b.Partition
+		})
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
@@ -0,0 +1,95 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDescribeLogDirsResponseV0(t *testing.T) {
+	item := describeLogDirsResponseV0{
+		ThrottleTimeMS: 1,
+		Results: []describeLogDirsResponseV0Result{
+			{
+				LogDir: "/var/lib/kafka/data-0",
+				Topics: []describeLogDirsResponseV0Topic{
+					{
+						Name: "a",
+						Partitions: []describeLogDirsResponseV0Partition{
+							{PartitionIndex: 0, PartitionSize: 1024, OffsetLag: 0},
+							{PartitionIndex: 1, PartitionSize: 512, OffsetLag: 42, IsFutureKey: true},
+						},
+					},
+				},
+			},
+			{
+				ErrorCode: int16(KafkaStorageError),
+				LogDir:    "/var/lib/kafka/data-1",
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found describeLogDirsResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestDescribeLogDirsRequestV0(t *testing.T) {
+	for _, request := range []describeLogDirsRequestV0{
+		{},
+		{
+			Topics: []describeLogDirsRequestV0Topic{
+				{Topic: "a", PartitionIndexes: []int32{0, 1}},
+				{Topic: "b", PartitionIndexes: []int32{0}},
+			},
+		},
+	} {
+		buf := bytes.NewBuffer(nil)
+		w := bufio.NewWriter(buf)
+		request.writeTo(w)
+		w.Flush()
+
+		if size := request.size(); int(size) != buf.Len() {
+			t.Errorf("expected size %d, got %d", buf.Len(), size)
+		}
+	}
+}
+
+func TestConnDescribeLogDirsUnsupported(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	conn, err := DialContext(context.Background(), "tcp", broker.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.DescribeLogDirs(nil); err != UnsupportedVersion {
+		t.Errorf("expected %v; got %v", UnsupportedVersion, err)
+	}
+}
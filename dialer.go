@@ -3,7 +3,10 @@ package kafka
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"strconv"
 	"strings"
@@ -16,6 +19,13 @@ import (
 // connections instead of raw network connections.
 type Dialer struct {
 	// Unique identifier for client connections established by this Dialer.
+	//
+	// It is sent in the header of every request made on the connections, so
+	// it appears in the request logs of the brokers and is used to apply the
+	// client quotas. Readers and writers configured with the Dialer send it as
+	// well.
+	//
+	// The default is DefaultClientID.
 	ClientID string
 
 	// Timeout is the maximum amount of time a dial will wait for a connect to
@@ -58,16 +68,68 @@ type Dialer struct {
 	// support keep-alives ignore this field.
 	KeepAlive time.Duration
 
+	// EnableNagle enables Nagle's algorithm on the TCP connections opened by
+	// the dialer. By default the TCP_NODELAY option is set on the connections
+	// so small requests, like the produce requests of a few messages, are
+	// sent right away instead of being delayed until the acknowledgement of
+	// the previous segment. Programs sending many small requests may trade
+	// latency for fewer packets by enabling it.
+	EnableNagle bool
+
 	// Resolver optionally specifies an alternate resolver to use.
+	//
+	// The resolver is invoked each time a connection is established so
+	// changes to the addresses of the brokers are picked up, the addresses
+	// that it returns are tried in order until one accepts the connection.
 	Resolver Resolver
 
 	// TLS enables Dialer to open secure connections.  If nil, standard net.Conn
 	// will be used.
+	//
+	// The configuration is used for every connection that the dialer opens,
+	// including the ones that readers and writers open again after losing a
+	// connection. Programs authenticating with client certificates which are
+	// rotated (for example short-lived SPIFFE SVIDs) should set the
+	// GetClientCertificate function of the configuration rather than its
+	// Certificates, it is called during the handshake of each connection so
+	// the connections opened after a rotation use the new certificate, while
+	// the connections already open keep the one they were established with.
+	// Resumed TLS sessions are authenticated with the certificate of the
+	// session they resume, leave ClientSessionCache nil for the certificate
+	// to be presented on every connection.
 	TLS *tls.Config
 
 	// SASLMechanism configures the Dialer to use SASL authentication.  If nil,
 	// no authentication will be performed.
 	SASLMechanism sasl.Mechanism
+
+	// SASLMechanisms configures the Dialer to authenticate with the first of
+	// the SASL mechanisms that the broker has enabled, in order of preference.
+	// The Dialer attempts the first mechanism (SASLMechanism if set), and when
+	// the broker rejects it, picks the first of the others that the broker
+	// reported in its SaslHandshake response and reconnects with it. When
+	// none of them is enabled, dialing fails with a *SASLMechanismError listing
+	// the mechanisms enabled by the broker. The mechanisms implementing
+	// sasl.Namer are only started to authenticate.
+	SASLMechanisms []sasl.Mechanism
+
+	// AllowPlaintextSASL must be set to authenticate with the SASL PLAIN
+	// mechanism when TLS is nil, which sends the username and password in
+	// clear text over the network. Without it, the Dialer refuses to send the
+	// credentials and fails with ErrPlaintextSASL, so that a misconfigured
+	// program doesn't leak them. It should only be set for development
+	// clusters.
+	AllowPlaintextSASL bool
+
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers of the
+	// connections established by the Dialer, see the fields of ConnConfig of
+	// the same names. Programs transferring large batches of messages over
+	// fast networks may set them to a higher value, like 1MB, to reduce the
+	// number of system calls.
+	//
+	// The default, which is also the minimum, is 4KB.
+	ReadBufferSize  int
+	WriteBufferSize int
 }
 
 // Dial connects to the address on the named network.
@@ -112,23 +174,81 @@ func (d *Dialer) DialContext(ctx context.Context, network string, address string
 // the partition leader for the topic and return a connection to that server.
 // The original address is only used as a mechanism to discover the
 // configuration of the kafka cluster that we're connecting to.
+//
+// With kafka 2.1 and above, the connection sends the epoch of the leader that
+// it was established to in fetch requests, reads then fail with
+// FencedLeaderEpoch or UnknownLeaderEpoch if the leadership of the partition
+// changed, in which case the program should dial the new leader.
+//
+// The partition may not have a leader yet, for example right after the topic
+// was created, in which case the lookup is retried with a backoff until ctx is
+// canceled or its deadline is exceeded, and the method then returns the last
+// error that it got (LeaderNotAvailable or UnknownTopicOrPartition).
 func (d *Dialer) DialLeader(ctx context.Context, network string, address string, topic string, partition int) (*Conn, error) {
-	p, err := d.LookupPartition(ctx, network, address, topic, partition)
+	p, err := d.lookupLeader(ctx, network, address, topic, partition)
 	if err != nil {
 		return nil, err
 	}
-	return d.DialPartition(ctx, network, address, p)
+	c, err := d.DialPartition(ctx, network, address, p)
+	if err != nil {
+		return nil, err
+	}
+	// The leader epoch is sent in fetch requests so the broker rejects them
+	// with FencedLeaderEpoch or UnknownLeaderEpoch if the metadata used to
+	// find the leader was stale.
+	c.leaderEpoch = int32(p.LeaderEpoch)
+	return c, nil
+}
+
+// lookupLeader looks up the partition until it has a leader, see DialLeader.
+func (d *Dialer) lookupLeader(ctx context.Context, network string, address string, topic string, partition int) (Partition, error) {
+	const (
+		backoffDelayMin = 100 * time.Millisecond
+		backoffDelayMax = 1 * time.Second
+	)
+
+	var lastErr error
+
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 {
+			if !sleep(ctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
+				return Partition{}, lastErr
+			}
+		}
+
+		p, err := d.LookupPartition(ctx, network, address, topic, partition)
+		if err == nil && p.Leader.ID < 0 {
+			err = LeaderNotAvailable
+		}
+
+		switch err {
+		case nil:
+			return p, nil
+		case LeaderNotAvailable, UnknownTopicOrPartition:
+			lastErr = err
+		default:
+			if lastErr != nil && ctx.Err() != nil {
+				return Partition{}, lastErr
+			}
+			return Partition{}, err
+		}
+	}
 }
 
 // DialPartition opens a connection to the leader of the partition specified by partition
 // descriptor. It's strongly advised to use descriptor of the partition that comes out of
 // functions LookupPartition or LookupPartitions.
 func (d *Dialer) DialPartition(ctx context.Context, network string, address string, partition Partition) (*Conn, error) {
-	return d.connect(ctx, network, net.JoinHostPort(partition.Leader.Host, strconv.Itoa(partition.Leader.Port)), ConnConfig{
+	c, err := d.connect(ctx, network, net.JoinHostPort(partition.Leader.Host, strconv.Itoa(partition.Leader.Port)), ConnConfig{
 		ClientID:  d.ClientID,
 		Topic:     partition.Topic,
 		Partition: partition.ID,
 	})
+	if err != nil {
+		return nil, err
+	}
+	c.broker = partition.Leader
+	return c, nil
 }
 
 // LookupLeader searches for the kafka broker that is the leader of the
@@ -138,6 +258,73 @@ func (d *Dialer) LookupLeader(ctx context.Context, network string, address strin
 	return p.Leader, err
 }
 
+// LookupCoordinator searches for the broker which is the coordinator of the
+// consumer group or transactional producer identified by key, depending on
+// keyType. Admin tools may then dial the coordinator to send it the requests
+// of the group or transactions.
+//
+// The coordinator may not be available while the cluster is starting or
+// electing a new coordinator, in which case the lookup is retried a few times
+// before returning GroupCoordinatorNotAvailable, which kafka returns for all
+// types of coordinators. Transaction coordinators can only be looked up with
+// kafka 0.11 and above.
+func (d *Dialer) LookupCoordinator(ctx context.Context, network string, address string, keyType CoordinatorKeyType, key string) (Broker, error) {
+	const (
+		maxAttempts     = 10
+		backoffDelayMin = 100 * time.Millisecond
+		backoffDelayMax = 1 * time.Second
+	)
+
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return Broker{}, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	for attempt := 0; attempt != maxAttempts; attempt++ {
+		if attempt != 0 {
+			if !sleep(ctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
+				return Broker{}, ctx.Err()
+			}
+		}
+
+		var broker Broker
+		if broker, err = conn.lookupCoordinator(keyType, key); err != GroupCoordinatorNotAvailable {
+			return broker, err
+		}
+	}
+
+	return Broker{}, err
+}
+
+// refreshLeader looks up the leader of the partition of topic from one of
+// brokers in the background, giving up after timeout, and sends it to the
+// returned channel. The broker has a negative ID when the lookup failed, the
+// leader is then looked up again by the next call, and requests sent to a
+// deposed leader fail with errors which make the clients look it up.
+func (d *Dialer) refreshLeader(ctx context.Context, network string, timeout time.Duration, brokers []string, topic string, partition int) <-chan Broker {
+	leader := make(chan Broker, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		for _, address := range brokers {
+			if p, err := d.LookupPartition(ctx, network, address, topic, partition); err == nil {
+				leader <- p.Leader
+				return
+			}
+		}
+
+		leader <- Broker{ID: -1}
+	}()
+
+	return leader
+}
+
 // LookupPartition searches for the description of specified partition id.
 func (d *Dialer) LookupPartition(ctx context.Context, network string, address string, topic string, partition int) (Partition, error) {
 	c, err := d.DialContext(ctx, network, address)
@@ -249,10 +436,27 @@ func (d *Dialer) connect(ctx context.Context, network, address string, connCfg C
 	if err != nil {
 		return nil, err
 	}
+	connCfg.ReadBufferSize = d.ReadBufferSize
+	connCfg.WriteBufferSize = d.WriteBufferSize
 	conn := NewConnWith(c, connCfg)
 
-	if d.SASLMechanism != nil {
-		if err := d.authenticateSASL(ctx, conn); err != nil {
+	// The host is the one that was dialed rather than the address that it
+	// resolved to, which is how brokers advertise themselves.
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		conn.broker.Host = host
+		conn.broker.Port, _ = strconv.Atoi(port)
+	}
+
+	if mechanisms := d.saslMechanisms(); len(mechanisms) != 0 {
+		enabled, err := d.authenticateSASL(ctx, conn, mechanisms[0])
+		if err == UnsupportedSASLMechanism && len(mechanisms) > 1 {
+			// The broker closes the connection after rejecting the mechanism
+			// of a handshake, the one which was negotiated is authenticated
+			// on a new connection.
+			_ = conn.Close()
+			return d.connectSASL(ctx, network, address, connCfg, mechanisms, enabled)
+		}
+		if err != nil {
 			_ = conn.Close()
 			return nil, err
 		}
@@ -261,20 +465,97 @@ func (d *Dialer) connect(ctx context.Context, network, address string, connCfg C
 	return conn, nil
 }
 
+// connectSASL opens a new connection to the broker and authenticates it with
+// the first of mechanisms that is part of enabled, the list of mechanisms
+// enabled by the broker.
+func (d *Dialer) connectSASL(ctx context.Context, network, address string, connCfg ConnConfig, mechanisms []sasl.Mechanism, enabled []string) (*Conn, error) {
+	names := make([]string, 0, len(mechanisms))
+
+	for _, m := range mechanisms {
+		name, err := saslMechanismName(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range enabled {
+			if name == e {
+				dialer := *d
+				dialer.SASLMechanism = m
+				dialer.SASLMechanisms = nil
+				return dialer.connect(ctx, network, address, connCfg)
+			}
+		}
+
+		names = append(names, name)
+	}
+
+	return nil, &SASLMechanismError{Mechanisms: names, Enabled: enabled}
+}
+
+// saslMechanismName returns the name of m. Mechanisms which don't implement
+// sasl.Namer are started to get it, the mechanisms following the selected one
+// are never started since they are looked up in order of preference.
+func saslMechanismName(ctx context.Context, m sasl.Mechanism) (string, error) {
+	if n, ok := m.(sasl.Namer); ok {
+		return n.Name(), nil
+	}
+	name, _, err := m.Start(ctx)
+	return name, err
+}
+
+// saslMechanisms returns the SASL mechanisms that the dialer is configured
+// with, in order of preference.
+func (d *Dialer) saslMechanisms() []sasl.Mechanism {
+	if d.SASLMechanism == nil {
+		return d.SASLMechanisms
+	}
+	return append([]sasl.Mechanism{d.SASLMechanism}, d.SASLMechanisms...)
+}
+
+// SASLMechanismError is returned by the Dialer when none of the SASL
+// mechanisms that it is configured with are enabled on the broker.
+type SASLMechanismError struct {
+	// Mechanisms holds the names of the mechanisms that the Dialer is
+	// configured with.
+	Mechanisms []string
+
+	// Enabled holds the names of the mechanisms enabled on the broker, as
+	// reported by its SaslHandshake response.
+	Enabled []string
+}
+
+// Error satisfies the error interface.
+func (e *SASLMechanismError) Error() string {
+	return fmt.Sprintf("kafka: none of the SASL mechanisms [%s] are enabled on the broker, which supports [%s]",
+		strings.Join(e.Mechanisms, ", "), strings.Join(e.Enabled, ", "))
+}
+
+// ErrPlaintextSASL is returned by the Dialer when it is configured to
+// authenticate with the SASL PLAIN mechanism over a connection without TLS,
+// unless AllowPlaintextSASL is set.
+var ErrPlaintextSASL = errors.New("kafka: refusing to send SASL PLAIN credentials over a connection without TLS, set Dialer.AllowPlaintextSASL to allow it")
+
 // authenticateSASL performs all of the required requests to authenticate this
 // connection.  If any step fails, this function returns with an error.  A nil
 // error indicates successful authentication.
 //
 // In case of error, this function *does not* close the connection.  That is the
 // responsibility of the caller.
-func (d *Dialer) authenticateSASL(ctx context.Context, conn *Conn) error {
-	mech, state, err := d.SASLMechanism.Start(ctx)
+//
+// The function also returns the mechanisms enabled on the broker, which is
+// how the caller picks another mechanism when the broker rejects this one
+// with UnsupportedSASLMechanism.
+func (d *Dialer) authenticateSASL(ctx context.Context, conn *Conn, mechanism sasl.Mechanism) ([]string, error) {
+	mech, state, err := mechanism.Start(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = conn.saslHandshake(mech)
+	if mech == "PLAIN" && d.TLS == nil && !d.AllowPlaintextSASL {
+		return nil, ErrPlaintextSASL
+	}
+	enabled, err := conn.saslHandshakeMechanisms(mech)
 	if err != nil {
-		return err
+		return enabled, err
 	}
 
 	var completed bool
@@ -286,36 +567,57 @@ func (d *Dialer) authenticateSASL(ctx context.Context, conn *Conn) error {
 			// the broker may communicate a failed exchange by closing the
 			// connection (esp. in the case where we're passing opaque sasl
 			// data over the wire since there's no protocol info).
-			return SASLAuthenticationFailed
+			return enabled, SASLAuthenticationFailed
 		default:
-			return err
+			return enabled, err
 		}
 
-		completed, state, err = d.SASLMechanism.Next(ctx, challenge)
+		completed, state, err = mechanism.Next(ctx, challenge)
 		if err != nil {
-			return err
+			return enabled, err
 		}
 	}
 
-	return nil
+	return enabled, nil
 }
 
 func (d *Dialer) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
-	if r := d.Resolver; r != nil {
-		host, port := splitHostPort(address)
-		addrs, err := r.LookupHost(ctx, host)
-		if err != nil {
-			return nil, err
+	r := d.Resolver
+	if r == nil {
+		return d.dialAddress(ctx, network, address)
+	}
+
+	// The host is resolved on every dial so changes to the DNS records are
+	// picked up, each address is tried in order until one accepts the
+	// connection.
+	host, port := splitHostPort(address)
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		addrs = []string{host}
+	}
+
+	for _, addr := range addrs {
+		if len(port) != 0 {
+			addr, _ = splitHostPort(addr)
+			addr = net.JoinHostPort(addr, port)
 		}
-		if len(addrs) != 0 {
-			address = addrs[0]
+
+		var conn net.Conn
+		if conn, err = d.dialAddress(ctx, network, addr); err == nil {
+			return conn, nil
 		}
-		if len(port) != 0 {
-			address, _ = splitHostPort(address)
-			address = net.JoinHostPort(address, port)
+		if ctx.Err() != nil {
+			break
 		}
 	}
 
+	return nil, err
+}
+
+func (d *Dialer) dialAddress(ctx context.Context, network string, address string) (net.Conn, error) {
 	conn, err := (&net.Dialer{
 		LocalAddr:     d.LocalAddr,
 		DualStack:     d.DualStack,
@@ -326,6 +628,13 @@ func (d *Dialer) dialContext(ctx context.Context, network string, address string
 		return nil, err
 	}
 
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.SetNoDelay(!d.EnableNagle); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	if d.TLS != nil {
 		c := d.TLS
 		// If no ServerName is set, infer the ServerName
@@ -417,6 +726,30 @@ func backoff(attempt int, min time.Duration, max time.Duration) time.Duration {
 	return d
 }
 
+// exponentialBackoff returns the upper bound of the delay to wait before the
+// given attempt (starting at 1): min doubled after each attempt, capped to max.
+func exponentialBackoff(attempt int, min time.Duration, max time.Duration) time.Duration {
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// jitteredBackoff returns a random delay between zero and the exponential
+// backoff of the given attempt ("full jitter"), spreading the retries of
+// concurrent clients over time.
+func jitteredBackoff(attempt int, min time.Duration, max time.Duration) time.Duration {
+	d := exponentialBackoff(attempt, min, max)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
 func splitHostPort(s string) (host string, port string) {
 	host, port, _ = net.SplitHostPort(s)
 	if len(host) == 0 && len(port) == 0 {
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package kafka
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestDialerTCPNoDelay(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	for _, enableNagle := range []bool{false, true} {
+		d := &Dialer{EnableNagle: enableNagle}
+
+		conn, err := d.DialContext(context.Background(), "tcp", broker.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := conn.NetConn().(*net.TCPConn).SyscallConn()
+		if err != nil {
+			conn.Close()
+			t.Fatal(err)
+		}
+
+		var noDelay int
+		var sockErr error
+		if err := raw.Control(func(fd uintptr) {
+			noDelay, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		}); err != nil {
+			sockErr = err
+		}
+		conn.Close()
+		if sockErr != nil {
+			t.Fatal(sockErr)
+		}
+
+		if (noDelay != 0) == enableNagle {
+			t.Errorf("enable nagle %t: expected TCP_NODELAY to be %t; got %d", enableNagle, !enableNagle, noDelay)
+		}
+	}
+}
@@ -2,14 +2,26 @@ package kafka
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"io"
+	"math/big"
 	"net"
 	"reflect"
 	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	ktesting "github.com/segmentio/kafka-go/testing"
 )
 
 func TestDialer(t *testing.T) {
@@ -21,6 +33,10 @@ func TestDialer(t *testing.T) {
 			scenario: "looking up partitions returns the list of available partitions for a topic",
 			function: testDialerLookupPartitions,
 		},
+		{
+			scenario: "dialing the leader right after creating a topic waits for the leader to be elected",
+			function: testDialerDialLeaderAfterCreateTopic,
+		},
 	}
 
 	for _, test := range tests {
@@ -61,11 +77,12 @@ func testDialerLookupPartitions(t *testing.T, ctx context.Context, d *Dialer) {
 
 	want := []Partition{
 		{
-			Topic:    "test-dialer-LookupPartitions",
-			Leader:   Broker{Host: "localhost", Port: 9092, ID: 1},
-			Replicas: []Broker{{Host: "localhost", Port: 9092, ID: 1}},
-			Isr:      []Broker{{Host: "localhost", Port: 9092, ID: 1}},
-			ID:       0,
+			Topic:       "test-dialer-LookupPartitions",
+			Leader:      Broker{Host: "localhost", Port: 9092, ID: 1},
+			LeaderEpoch: newPartitionLeaderEpoch(),
+			Replicas:    []Broker{{Host: "localhost", Port: 9092, ID: 1}},
+			Isr:         []Broker{{Host: "localhost", Port: 9092, ID: 1}},
+			ID:          0,
 		},
 	}
 	if !reflect.DeepEqual(partitions, want) {
@@ -73,6 +90,35 @@ func testDialerLookupPartitions(t *testing.T, ctx context.Context, d *Dialer) {
 	}
 }
 
+func testDialerDialLeaderAfterCreateTopic(t *testing.T, ctx context.Context, d *Dialer) {
+	topic := makeTopic()
+
+	conn, err := d.DialContext(ctx, "tcp", "localhost:9092")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.CreateTopics(TopicConfig{Topic: topic, NumPartitions: 2, ReplicationFactor: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	leader, err := d.DialLeader(ctx, "tcp", "localhost:9092", topic, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Close()
+}
+
+// newPartitionLeaderEpoch returns the leader epoch expected for the partitions
+// of topics created by the tests, which is unknown before kafka 2.1.
+func newPartitionLeaderEpoch() int {
+	if ktesting.KafkaIsAtLeast("2.1.0") {
+		return 0
+	}
+	return -1
+}
+
 func tlsConfig(t *testing.T) *tls.Config {
 	const (
 		certPEM = `-----BEGIN CERTIFICATE-----
@@ -232,11 +278,12 @@ func TestDialerTLS(t *testing.T) {
 
 	want := []Partition{
 		{
-			Topic:    topic,
-			Leader:   Broker{Host: "localhost", Port: 9092, ID: 1},
-			Replicas: []Broker{{Host: "localhost", Port: 9092, ID: 1}},
-			Isr:      []Broker{{Host: "localhost", Port: 9092, ID: 1}},
-			ID:       0,
+			Topic:       topic,
+			Leader:      Broker{Host: "localhost", Port: 9092, ID: 1},
+			LeaderEpoch: newPartitionLeaderEpoch(),
+			Replicas:    []Broker{{Host: "localhost", Port: 9092, ID: 1}},
+			Isr:         []Broker{{Host: "localhost", Port: 9092, ID: 1}},
+			ID:          0,
 		},
 	}
 	if !reflect.DeepEqual(partitions, want) {
@@ -244,6 +291,112 @@ func TestDialerTLS(t *testing.T) {
 	}
 }
 
+// makeClientCertificate returns a self-signed client certificate with
+// commonName as subject.
+func makeClientCertificate(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestDialerTLSClientCertificateRotation(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The TLS proxy in front of the broker reports the common name of the
+	// client certificate of each connection.
+	config := tlsConfig(t)
+	config.ClientAuth = tls.RequireAnyClientCert
+	l, err := tls.Listen("tcp", "127.0.0.1:", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	peers := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return // intentionally ignored
+			}
+
+			go func(in *tls.Conn) {
+				defer in.Close()
+				if err := in.Handshake(); err != nil {
+					return
+				}
+				peers <- in.ConnectionState().PeerCertificates[0].Subject.CommonName
+
+				out, err := net.Dial("tcp", broker.Addr())
+				if err != nil {
+					return
+				}
+				defer out.Close()
+
+				go io.Copy(in, out)
+				io.Copy(out, in)
+			}(conn.(*tls.Conn))
+		}
+	}()
+
+	var certificate atomic.Value
+	d := &Dialer{
+		Timeout: 10 * time.Second,
+		TLS: &tls.Config{
+			InsecureSkipVerify: true,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				c := certificate.Load().(tls.Certificate)
+				return &c, nil
+			},
+		},
+	}
+
+	for _, name := range []string{"before-rotation", "after-rotation"} {
+		certificate.Store(makeClientCertificate(t, name))
+
+		conn, err := d.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err = conn.ReadPartitions("test")
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case peer := <-peers:
+			if peer != name {
+				t.Errorf("expected the connection to use the certificate of %s; got %s", name, peer)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the client certificate")
+		}
+	}
+}
+
 type MockConn struct {
 	net.Conn
 	done       chan struct{}
@@ -302,3 +455,215 @@ func TestDialerConnectTLSHonorsContext(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+type rotatingResolver struct {
+	mutex   sync.Mutex
+	lookups int
+	addrs   [][]string
+}
+
+func (r *rotatingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	addrs := r.addrs[r.lookups%len(r.addrs)]
+	r.lookups++
+	return addrs, nil
+}
+
+func TestDialerResolverFailover(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// Nothing listens on 127.0.0.2, the dialer must fail over to the next
+	// address, and pick up the new records on the second dial.
+	r := &rotatingResolver{addrs: [][]string{
+		{"127.0.0.2", "127.0.0.1"},
+		{"127.0.0.1"},
+	}}
+	d := &Dialer{Resolver: r, Timeout: 5 * time.Second}
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.dialContext(context.Background(), "tcp", net.JoinHostPort("kafka", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := conn.RemoteAddr().String(); addr != l.Addr().String() {
+			t.Errorf("expected connection to %s; got %s", l.Addr(), addr)
+		}
+		conn.Close()
+	}
+
+	if r.lookups != 2 {
+		t.Errorf("expected the host to be resolved on each dial; got %d lookups", r.lookups)
+	}
+}
+
+func TestDialerPlaintextSASL(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	d := &Dialer{
+		SASLMechanism: plain.Mechanism{Username: "user", Password: "secret"},
+	}
+	if _, err := d.DialContext(ctx, "tcp", broker.Addr()); err != ErrPlaintextSASL {
+		t.Fatalf("expected %v; got %v", ErrPlaintextSASL, err)
+	}
+
+	// The mock broker has no SASL mechanisms enabled, so the handshake fails
+	// once the dialer is allowed to send the credentials.
+	d.AllowPlaintextSASL = true
+	if _, err := d.DialContext(ctx, "tcp", broker.Addr()); err == nil || err == ErrPlaintextSASL {
+		t.Fatalf("expected the SASL handshake to be attempted; got %v", err)
+	}
+}
+
+// testMechanism is a SASL mechanism which completes after sending its initial
+// response, and records how many times it was started and whether it was used
+// to authenticate.
+type testMechanism struct {
+	name          string
+	started       int
+	authenticated bool
+}
+
+func (m *testMechanism) Name() string {
+	return m.name
+}
+
+func (m *testMechanism) Start(ctx context.Context) (string, []byte, error) {
+	m.started++
+	return m.name, []byte{}, nil
+}
+
+func (m *testMechanism) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	m.authenticated = true
+	return true, nil, nil
+}
+
+func TestDialerSASLMechanisms(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.SetSASLMechanisms("SCRAM-SHA-256", "GSSAPI")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	scram512 := &testMechanism{name: "SCRAM-SHA-512"}
+	scram256 := &testMechanism{name: "SCRAM-SHA-256"}
+	gssapi := &testMechanism{name: "GSSAPI"}
+
+	d := &Dialer{SASLMechanisms: []sasl.Mechanism{scram512, scram256, gssapi}}
+	conn, err := d.DialContext(ctx, "tcp", broker.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if scram512.authenticated || !scram256.authenticated || gssapi.authenticated {
+		t.Errorf("expected to authenticate with SCRAM-SHA-256 only; got SCRAM-SHA-512=%t SCRAM-SHA-256=%t GSSAPI=%t",
+			scram512.authenticated, scram256.authenticated, gssapi.authenticated)
+	}
+
+	// The mechanisms are only started to authenticate, the rejected one and
+	// the selected one once each.
+	if scram512.started != 1 || scram256.started != 1 || gssapi.started != 0 {
+		t.Errorf("expected SCRAM-SHA-512 and SCRAM-SHA-256 to be started once; got SCRAM-SHA-512=%d SCRAM-SHA-256=%d GSSAPI=%d",
+			scram512.started, scram256.started, gssapi.started)
+	}
+
+	// The connection remains usable after the mechanism was negotiated.
+	if _, err := conn.ApiVersions(); err != nil {
+		t.Error(err)
+	}
+
+	d = &Dialer{
+		SASLMechanism:  &testMechanism{name: "OAUTHBEARER"},
+		SASLMechanisms: []sasl.Mechanism{&testMechanism{name: "PLAIN"}},
+	}
+	_, err = d.DialContext(ctx, "tcp", broker.Addr())
+
+	mechanismErr, ok := err.(*SASLMechanismError)
+	if !ok {
+		t.Fatalf("expected a *SASLMechanismError; got %v", err)
+	}
+	if !reflect.DeepEqual(mechanismErr.Mechanisms, []string{"OAUTHBEARER", "PLAIN"}) {
+		t.Errorf("unexpected configured mechanisms: %v", mechanismErr.Mechanisms)
+	}
+	if !reflect.DeepEqual(mechanismErr.Enabled, []string{"SCRAM-SHA-256", "GSSAPI"}) {
+		t.Errorf("unexpected enabled mechanisms: %v", mechanismErr.Enabled)
+	}
+}
+
+func TestDialerLookupCoordinator(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	var mutex sync.Mutex
+	lookups := map[string]int{}
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API != MockFindCoordinator {
+			return MockResponse{}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		lookups[req.Group]++
+		// The coordinator becomes available on the third attempt.
+		if lookups[req.Group] < 3 {
+			return MockResponse{Error: GroupCoordinatorNotAvailable}
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	host, port, _ := net.SplitHostPort(broker.Addr())
+	expected := Broker{Host: host, ID: 0}
+	expected.Port, _ = strconv.Atoi(port)
+
+	for _, keyType := range []CoordinatorKeyType{GroupCoordinator, TransactionCoordinator} {
+		key := "key-" + strconv.Itoa(int(keyType))
+
+		coordinator, err := DefaultDialer.LookupCoordinator(ctx, "tcp", broker.Addr(), keyType, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if coordinator != expected {
+			t.Errorf("expected coordinator %+v; got %+v", expected, coordinator)
+		}
+
+		mutex.Lock()
+		n := lookups[key]
+		mutex.Unlock()
+		if n != 3 {
+			t.Errorf("expected the lookup to be retried until the coordinator is available; got %d lookups", n)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// ElectionType is the type of leader election triggered by
+// Conn.ElectLeaders.
+type ElectionType int8
+
+const (
+	// PreferredElection moves the leadership of partitions back to their
+	// preferred replica, which is the first replica of the partition.
+	PreferredElection ElectionType = 0
+
+	// UncleanElection elects a replica which is not in sync as the leader of
+	// partitions which have no leader, possibly losing messages.
+	UncleanElection ElectionType = 1
+)
+
+// ElectionResult is the result of the leader election of a partition.
+type ElectionResult struct {
+	Topic     string
+	Partition int
+
+	// Error is nil if a leader was elected. It is ElectionNotNeeded when the
+	// partition was already led by its preferred replica, or had a leader in
+	// the case of unclean elections.
+	Error error
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_ElectLeaders
+type electLeadersRequestV1 struct {
+	ElectionType int8
+
+	// TopicPartitions holds the partitions to elect leaders for, a null array
+	// is sent when empty to elect leaders for all partitions.
+	TopicPartitions []electLeadersRequestV1Topic
+
+	// TimeoutMS is the time in milliseconds to wait for the elections to
+	// complete.
+	TimeoutMS int32
+}
+
+func (t electLeadersRequestV1) size() int32 {
+	return sizeofInt8(t.ElectionType) +
+		sizeofArray(len(t.TopicPartitions), func(i int) int32 { return t.TopicPartitions[i].size() }) +
+		sizeofInt32(t.TimeoutMS)
+}
+
+func (t electLeadersRequestV1) writeTo(w *bufio.Writer) {
+	writeInt8(w, t.ElectionType)
+	if t.TopicPartitions == nil {
+		writeInt32(w, -1)
+	} else {
+		writeArray(w, len(t.TopicPartitions), func(i int) { t.TopicPartitions[i].writeTo(w) })
+	}
+	writeInt32(w, t.TimeoutMS)
+}
+
+type electLeadersRequestV1Topic struct {
+	Topic        string
+	PartitionIDs []int32
+}
+
+func (t electLeadersRequestV1Topic) size() int32 {
+	return sizeofString(t.Topic) +
+		sizeofInt32Array(t.PartitionIDs)
+}
+
+func (t electLeadersRequestV1Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Topic)
+	writeInt32Array(w, t.PartitionIDs)
+}
+
+type electLeadersResponseV1PartitionResult struct {
+	PartitionID  int32
+	ErrorCode    int16
+	ErrorMessage string
+}
+
+func (t electLeadersResponseV1PartitionResult) size() int32 {
+	return sizeofInt32(t.PartitionID) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage)
+}
+
+func (t electLeadersResponseV1PartitionResult) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionID)
+	writeInt16(w, t.ErrorCode)
+	writeNullableString(w, t.ErrorMessage)
+}
+
+func (t *electLeadersResponseV1PartitionResult) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionID); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+	return
+}
+
+type electLeadersResponseV1ReplicaElectionResult struct {
+	Topic            string
+	PartitionResults []electLeadersResponseV1PartitionResult
+}
+
+func (t electLeadersResponseV1ReplicaElectionResult) size() int32 {
+	return sizeofString(t.Topic) +
+		sizeofArray(len(t.PartitionResults), func(i int) int32 { return t.PartitionResults[i].size() })
+}
+
+func (t electLeadersResponseV1ReplicaElectionResult) writeTo(w *bufio.Writer) {
+	writeString(w, t.Topic)
+	writeArray(w, len(t.PartitionResults), func(i int) { t.PartitionResults[i].writeTo(w) })
+}
+
+func (t *electLeadersResponseV1ReplicaElectionResult) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Topic); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item electLeadersResponseV1PartitionResult
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.PartitionResults = append(t.PartitionResults, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type electLeadersResponseV1 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode is the top level error of the request, it is not zero when
+	// none of the elections could be triggered.
+	ErrorCode int16
+
+	// ReplicaElectionResults holds the results of the election of each
+	// partition.
+	ReplicaElectionResults []electLeadersResponseV1ReplicaElectionResult
+}
+
+func (t electLeadersResponseV1) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofArray(len(t.ReplicaElectionResults), func(i int) int32 { return t.ReplicaElectionResults[i].size() })
+}
+
+func (t electLeadersResponseV1) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeArray(w, len(t.ReplicaElectionResults), func(i int) { t.ReplicaElectionResults[i].writeTo(w) })
+}
+
+func (t *electLeadersResponseV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item electLeadersResponseV1ReplicaElectionResult
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.ReplicaElectionResults = append(t.ReplicaElectionResults, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// electLeaders triggers the leader elections of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ElectLeaders
+func (c *Conn) electLeaders(request electLeadersRequestV1) (electLeadersResponseV1, error) {
+	var response electLeadersResponseV1
+
+	if _, err := c.negotiatedVersion(electLeadersRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		electLeadersRequest,
+		func(deadline time.Time, id int32) error {
+			if request.TimeoutMS == 0 {
+				now := time.Now()
+				deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
+				request.TimeoutMS = milliseconds(deadlineToTimeout(deadline, now))
+			}
+			return c.writeRequest(electLeadersRequest, v1, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return electLeadersResponseV1{}, err
+	}
+
+	return response, nil
+}
+
+// ElectLeaders triggers the election of leaders for the partitions of the
+// topics in topicPartitions, or all partitions of the cluster if it is empty.
+// Preferred elections move the leadership of partitions back to their
+// preferred replica, like the kafka-leader-election.sh tool does, for example
+// after a broker was restarted for maintenance.
+//
+// The election of each partition may fail independently, the method returns
+// the result of each election. Partitions which were already led by their
+// preferred replica have ElectionNotNeeded as error. The error return value is
+// not nil if the request itself failed.
+//
+// The request must be sent to the controller of the cluster (see Controller),
+// and is only supported by kafka 2.4 and above.
+func (c *Conn) ElectLeaders(topicPartitions map[string][]int, electionType ElectionType) ([]ElectionResult, error) {
+	topics := make([]string, 0, len(topicPartitions))
+	for topic := range topicPartitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	request := electLeadersRequestV1{ElectionType: int8(electionType)}
+	for _, topic := range topics {
+		partitions := make([]int32, len(topicPartitions[topic]))
+		for i, p := range topicPartitions[topic] {
+			partitions[i] = int32(p)
+		}
+		request.TopicPartitions = append(request.TopicPartitions, electLeadersRequestV1Topic{
+			Topic:        topic,
+			PartitionIDs: partitions,
+		})
+	}
+
+	response, err := c.electLeaders(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != 0 {
+		return nil, Error(response.ErrorCode)
+	}
+
+	var results []ElectionResult
+	for _, t := range response.ReplicaElectionResults {
+		for _, p := range t.PartitionResults {
+			result := ElectionResult{Topic: t.Topic, Partition: int(p.PartitionID)}
+			if p.ErrorCode != 0 {
+				result.Error = Error(p.ErrorCode)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestElectLeadersResponseV1(t *testing.T) {
+	item := electLeadersResponseV1{
+		ThrottleTimeMS: 1,
+		ReplicaElectionResults: []electLeadersResponseV1ReplicaElectionResult{
+			{
+				Topic: "a",
+				PartitionResults: []electLeadersResponseV1PartitionResult{
+					{
+						PartitionID: 0,
+					},
+					{
+						PartitionID:  1,
+						ErrorCode:    int16(ElectionNotNeeded),
+						ErrorMessage: "leader election not needed",
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found electLeadersResponseV1
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestElectLeadersRequestV1(t *testing.T) {
+	for _, request := range []electLeadersRequestV1{
+		{ElectionType: int8(PreferredElection), TimeoutMS: 1000},
+		{
+			ElectionType: int8(UncleanElection),
+			TopicPartitions: []electLeadersRequestV1Topic{
+				{Topic: "a", PartitionIDs: []int32{0, 1}},
+			},
+			TimeoutMS: 1000,
+		},
+	} {
+		buf := bytes.NewBuffer(nil)
+		w := bufio.NewWriter(buf)
+		request.writeTo(w)
+		w.Flush()
+
+		if size := request.size(); int(size) != buf.Len() {
+			t.Errorf("expected size %d, got %d", buf.Len(), size)
+		}
+	}
+}
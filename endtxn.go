@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_EndTxn
+type endTxnRequestV0 struct {
+	TransactionalID string
+	ProducerID      int64
+	ProducerEpoch   int16
+
+	// Committed is true to commit the transaction, false to abort it.
+	Committed bool
+}
+
+func (t endTxnRequestV0) size() int32 {
+	return sizeofString(t.TransactionalID) +
+		sizeofInt64(t.ProducerID) +
+		sizeofInt16(t.ProducerEpoch) +
+		sizeofBool(t.Committed)
+}
+
+func (t endTxnRequestV0) writeTo(w *bufio.Writer) {
+	writeString(w, t.TransactionalID)
+	writeInt64(w, t.ProducerID)
+	writeInt16(w, t.ProducerEpoch)
+	writeBool(w, t.Committed)
+}
+
+type endTxnResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+	ErrorCode      int16
+}
+
+func (t endTxnResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t endTxnResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *endTxnResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+// EndTxn completes the ongoing transaction of producer, committing it when
+// commit is true or aborting it otherwise. Once committed, the records written
+// by the transaction become visible to the consumers reading committed records
+// (see ReadCommitted), and the offsets committed with Conn.TxnOffsetCommit
+// become the committed offsets of their consumer group.
+//
+// All the records of the transaction must have been written before the
+// transaction is committed. A transaction may be aborted at any time, the
+// coordinator also aborts transactions which are not completed before the
+// timeout passed to Conn.InitProducerID.
+//
+// The request must be sent to the transaction coordinator of the producer (see
+// Dialer.LookupCoordinator), and is only supported by kafka 0.11 and above.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_EndTxn
+func (c *Conn) EndTxn(producer TransactionalProducer, commit bool) error {
+	var response endTxnResponseV0
+
+	if _, err := c.negotiatedVersion(endTxnRequest); err != nil {
+		return err
+	}
+
+	request := endTxnRequestV0{
+		TransactionalID: producer.TransactionalID,
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+		Committed:       commit,
+	}
+
+	err := c.writeOperation(
+		endTxnRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(endTxnRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if response.ErrorCode != 0 {
+		return Error(response.ErrorCode)
+	}
+	return nil
+}
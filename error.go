@@ -86,8 +86,15 @@ const (
 	FencedLeaderEpoch                  Error = 74
 	UnknownLeaderEpoch                 Error = 75
 	UnsupportedCompressionType         Error = 76
+	StaleBrokerEpoch                   Error = 77
 	OffsetNotAvailable                 Error = 78
+	MemberIDRequired                   Error = 79
 	PreferredLeaderNotAvailable        Error = 80
+	GroupMaxSizeReached                Error = 81
+	FencedInstanceID                   Error = 82
+	EligibleLeadersNotAvailable        Error = 83
+	ElectionNotNeeded                  Error = 84
+	NoReassignmentInProgress           Error = 85
 )
 
 // Error satisfies the error interface.
@@ -281,10 +288,24 @@ func (e Error) Title() string {
 		return "Unknown Leader Epoch"
 	case UnsupportedCompressionType:
 		return "Unsupported Compression Type"
+	case StaleBrokerEpoch:
+		return "Stale Broker Epoch"
 	case OffsetNotAvailable:
 		return "Offset Not Available"
+	case MemberIDRequired:
+		return "Member ID Required"
 	case PreferredLeaderNotAvailable:
 		return "Preferred Leader not available"
+	case GroupMaxSizeReached:
+		return "Group Max Size Reached"
+	case FencedInstanceID:
+		return "Fenced Instance ID"
+	case EligibleLeadersNotAvailable:
+		return "Eligible Leaders Not Available"
+	case ElectionNotNeeded:
+		return "Election Not Needed"
+	case NoReassignmentInProgress:
+		return "No Reassignment In Progress"
 	}
 	return ""
 }
@@ -444,6 +465,20 @@ func (e Error) Description() string {
 		return "the leader epoch in the request is newer than the epoch on the broker"
 	case UnsupportedCompressionType:
 		return "the requesting client does not support the compression type of given partition"
+	case StaleBrokerEpoch:
+		return "the broker epoch has changed"
+	case MemberIDRequired:
+		return "the group member needs to have a valid member ID before actually entering a consumer group"
+	case GroupMaxSizeReached:
+		return "the consumer group has reached its max size"
+	case FencedInstanceID:
+		return "the broker rejected this static consumer since another consumer with the same group instance ID has registered with a different member ID"
+	case EligibleLeadersNotAvailable:
+		return "eligible topic partition leaders are not available"
+	case ElectionNotNeeded:
+		return "leader election not needed for topic partition"
+	case NoReassignmentInProgress:
+		return "no partition reassignment is in progress"
 	}
 	return ""
 }
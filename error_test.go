@@ -85,6 +85,13 @@ func TestError(t *testing.T) {
 		FencedLeaderEpoch,
 		UnknownLeaderEpoch,
 		UnsupportedCompressionType,
+		StaleBrokerEpoch,
+		MemberIDRequired,
+		GroupMaxSizeReached,
+		FencedInstanceID,
+		EligibleLeadersNotAvailable,
+		ElectionNotNeeded,
+		NoReassignmentInProgress,
 	}
 
 	for _, err := range errorCodes {
@@ -1,6 +1,9 @@
 package kafka
 
-import "bufio"
+import (
+	"bufio"
+	"math"
+)
 
 type fetchRequestV2 struct {
 	ReplicaID   int32
@@ -99,3 +102,237 @@ func (p fetchResponsePartitionV2) writeTo(w *bufio.Writer) {
 	writeInt32(w, p.MessageSetSize)
 	p.MessageSet.writeTo(w)
 }
+
+type fetchRequestV11 struct {
+	ReplicaID       int32
+	MaxWaitTime     int32
+	MinBytes        int32
+	MaxBytes        int32
+	IsolationLevel  int8
+	SessionID       int32
+	SessionEpoch    int32
+	Topics          []fetchRequestTopicV11
+	ForgottenTopics []fetchRequestForgottenTopicV11
+	RackID          string
+}
+
+func (r fetchRequestV11) size() int32 {
+	return 4 + 4 + 4 + 4 + 1 + 4 + 4 +
+		sizeofArray(len(r.Topics), func(i int) int32 { return r.Topics[i].size() }) +
+		sizeofArray(len(r.ForgottenTopics), func(i int) int32 { return r.ForgottenTopics[i].size() }) +
+		sizeofString(r.RackID)
+}
+
+func (r fetchRequestV11) writeTo(w *bufio.Writer) {
+	writeInt32(w, r.ReplicaID)
+	writeInt32(w, r.MaxWaitTime)
+	writeInt32(w, r.MinBytes)
+	writeInt32(w, r.MaxBytes)
+	writeInt8(w, r.IsolationLevel)
+	writeInt32(w, r.SessionID)
+	writeInt32(w, r.SessionEpoch)
+	writeArray(w, len(r.Topics), func(i int) { r.Topics[i].writeTo(w) })
+	writeArray(w, len(r.ForgottenTopics), func(i int) { r.ForgottenTopics[i].writeTo(w) })
+	writeString(w, r.RackID)
+}
+
+type fetchRequestTopicV11 struct {
+	TopicName  string
+	Partitions []fetchRequestPartitionV11
+}
+
+func (t fetchRequestTopicV11) size() int32 {
+	return sizeofString(t.TopicName) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t fetchRequestTopicV11) writeTo(w *bufio.Writer) {
+	writeString(w, t.TopicName)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+type fetchRequestPartitionV11 struct {
+	Partition          int32
+	CurrentLeaderEpoch int32
+	FetchOffset        int64
+	LogStartOffset     int64
+	MaxBytes           int32
+}
+
+func (p fetchRequestPartitionV11) size() int32 {
+	return 4 + 4 + 8 + 8 + 4
+}
+
+func (p fetchRequestPartitionV11) writeTo(w *bufio.Writer) {
+	writeInt32(w, p.Partition)
+	writeInt32(w, p.CurrentLeaderEpoch)
+	writeInt64(w, p.FetchOffset)
+	writeInt64(w, p.LogStartOffset)
+	writeInt32(w, p.MaxBytes)
+}
+
+type fetchRequestForgottenTopicV11 struct {
+	TopicName  string
+	Partitions []int32
+}
+
+func (t fetchRequestForgottenTopicV11) size() int32 {
+	return sizeofString(t.TopicName) + sizeofInt32Array(t.Partitions)
+}
+
+func (t fetchRequestForgottenTopicV11) writeTo(w *bufio.Writer) {
+	writeString(w, t.TopicName)
+	writeInt32Array(w, t.Partitions)
+}
+
+type fetchResponseV11 struct {
+	ThrottleTime int32
+	ErrorCode    int16
+	SessionID    int32
+	Topics       []fetchResponseTopicV11
+}
+
+func (r fetchResponseV11) size() int32 {
+	return 4 + 2 + 4 + sizeofArray(len(r.Topics), func(i int) int32 { return r.Topics[i].size() })
+}
+
+func (r fetchResponseV11) writeTo(w *bufio.Writer) {
+	writeInt32(w, r.ThrottleTime)
+	writeInt16(w, r.ErrorCode)
+	writeInt32(w, r.SessionID)
+	writeArray(w, len(r.Topics), func(i int) { r.Topics[i].writeTo(w) })
+}
+
+type fetchResponseTopicV11 struct {
+	TopicName  string
+	Partitions []fetchResponsePartitionV11
+}
+
+func (t fetchResponseTopicV11) size() int32 {
+	return sizeofString(t.TopicName) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t fetchResponseTopicV11) writeTo(w *bufio.Writer) {
+	writeString(w, t.TopicName)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+// fetchResponsePartitionV11 is the partition of a fetch response v11, the
+// aborted transactions are always written as an empty array. The messages are
+// written from RecordBatches instead of MessageSet when it is not nil, which
+// holds record batches of the v2 message format.
+type fetchResponsePartitionV11 struct {
+	Partition            int32
+	ErrorCode            int16
+	HighwaterMarkOffset  int64
+	LastStableOffset     int64
+	LogStartOffset       int64
+	PreferredReadReplica int32
+	MessageSetSize       int32
+	MessageSet           messageSet
+	RecordBatches        []byte
+}
+
+func (p fetchResponsePartitionV11) size() int32 {
+	if p.RecordBatches != nil {
+		return 4 + 2 + 8 + 8 + 8 + 4 + 4 + 4 + int32(len(p.RecordBatches))
+	}
+	return 4 + 2 + 8 + 8 + 8 + 4 + 4 + 4 + p.MessageSet.size()
+}
+
+func (p fetchResponsePartitionV11) writeTo(w *bufio.Writer) {
+	writeInt32(w, p.Partition)
+	writeInt16(w, p.ErrorCode)
+	writeInt64(w, p.HighwaterMarkOffset)
+	writeInt64(w, p.LastStableOffset)
+	writeInt64(w, p.LogStartOffset)
+	writeArrayLen(w, 0) // aborted transactions
+	writeInt32(w, p.PreferredReadReplica)
+	writeInt32(w, p.MessageSetSize)
+	if p.RecordBatches != nil {
+		w.Write(p.RecordBatches)
+	} else {
+		p.MessageSet.writeTo(w)
+	}
+}
+
+// fetchSessionRequest holds the fetch session fields of fetch requests v7 and
+// above (KIP-227). Session ID 0 with epoch -1 makes a full fetch request
+// outside of any session, with epoch 0 it asks the broker to create one.
+type fetchSessionRequest struct {
+	id    int32
+	epoch int32
+
+	// omitPartition is true when the broker caches the fetch parameters of the
+	// partition in the session, the request then carries no topic.
+	omitPartition bool
+}
+
+var noFetchSession = fetchSessionRequest{id: 0, epoch: -1}
+
+// sizeofTopics returns the size of the topics of a fetch request for the
+// partition of topic, excluding the array length.
+func (s fetchSessionRequest) sizeofTopics(topic string) int32 {
+	if s.omitPartition {
+		return 0
+	}
+	return sizeofString(topic) +
+		4 + // partition array length
+		4 + // partition
+		4 + // current leader epoch
+		8 + // offset
+		8 + // log start offset
+		4 // max bytes
+}
+
+// fetchSession is the state of the fetch session that a connection holds with
+// the partition leader, its zero value has no session.
+type fetchSession struct {
+	id    int32
+	epoch int32
+
+	// fetch parameters of the partition cached by the broker
+	offset            int64
+	partitionMaxBytes int
+	leaderEpoch       int32
+
+	// high watermark and log start offset of the partition in the last
+	// response that carried it, incremental fetch responses omit the
+	// partition when they have nothing new for it
+	highWaterMark  int64
+	logStartOffset int64
+}
+
+// next returns the session fields of the next fetch request of the partition,
+// which is omitted when its fetch parameters did not change since the last
+// request of the session.
+func (s *fetchSession) next(offset int64, partitionMaxBytes int, leaderEpoch int32) fetchSessionRequest {
+	req := fetchSessionRequest{
+		id:    s.id,
+		epoch: s.epoch,
+		omitPartition: s.id != 0 &&
+			s.offset == offset &&
+			s.partitionMaxBytes == partitionMaxBytes &&
+			s.leaderEpoch == leaderEpoch,
+	}
+	s.offset, s.partitionMaxBytes, s.leaderEpoch = offset, partitionMaxBytes, leaderEpoch
+	return req
+}
+
+// update moves the session to its next epoch after reading the header of a
+// fetch response, err being the error returned by the header. The session is
+// reset when the broker closed it or when the response could not be read, the
+// next request then creates a new session with a full fetch.
+func (s *fetchSession) update(sessionID int32, err error) {
+	if _, ok := err.(Error); sessionID == 0 || (err != nil && !ok) {
+		*s = fetchSession{}
+		return
+	}
+	s.id = sessionID
+	if s.epoch == math.MaxInt32 {
+		s.epoch = 1 // epoch 0 is reserved for the creation of sessions
+	} else {
+		s.epoch++
+	}
+}
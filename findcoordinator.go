@@ -84,3 +84,82 @@ func (t *findCoordinatorResponseV0) readFrom(r *bufio.Reader, size int) (remain
 	}
 	return
 }
+
+// CoordinatorKeyType is the type of the keys that coordinators are looked up
+// with by Dialer.LookupCoordinator.
+type CoordinatorKeyType int8
+
+const (
+	// GroupCoordinator looks up the coordinator of a consumer group, the key
+	// is the group ID.
+	GroupCoordinator CoordinatorKeyType = 0
+
+	// TransactionCoordinator looks up the coordinator of the transactions of
+	// a producer, the key is its transactional ID.
+	TransactionCoordinator CoordinatorKeyType = 1
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_FindCoordinator
+type findCoordinatorRequestV1 struct {
+	// CoordinatorKey holds id to use for finding the coordinator (for groups, this is
+	// the groupId, for transactional producers, this is the transactional id)
+	CoordinatorKey string
+
+	// CoordinatorType is the type of the key, 0 for groups and 1 for
+	// transactions.
+	CoordinatorType int8
+}
+
+func (t findCoordinatorRequestV1) size() int32 {
+	return sizeofString(t.CoordinatorKey) +
+		sizeofInt8(t.CoordinatorType)
+}
+
+func (t findCoordinatorRequestV1) writeTo(w *bufio.Writer) {
+	writeString(w, t.CoordinatorKey)
+	writeInt8(w, t.CoordinatorType)
+}
+
+type findCoordinatorResponseV1 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode holds response error code
+	ErrorCode    int16
+	ErrorMessage string
+
+	// Coordinator holds host and port information for the coordinator
+	Coordinator findCoordinatorResponseCoordinatorV0
+}
+
+func (t findCoordinatorResponseV1) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofString(t.ErrorMessage) +
+		t.Coordinator.size()
+}
+
+func (t findCoordinatorResponseV1) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeNullableString(w, t.ErrorMessage)
+	t.Coordinator.writeTo(w)
+}
+
+func (t *findCoordinatorResponseV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+	if remain, err = (&t.Coordinator).readFrom(r, remain); err != nil {
+		return
+	}
+	return
+}
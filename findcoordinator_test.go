@@ -37,3 +37,33 @@ func TestFindCoordinatorResponseV0(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestFindCoordinatorResponseV1(t *testing.T) {
+	item := findCoordinatorResponseV1{
+		ThrottleTimeMS: 1,
+		ErrorCode:      2,
+		ErrorMessage:   "a",
+		Coordinator: findCoordinatorResponseCoordinatorV0{
+			NodeID: 3,
+			Host:   "b",
+			Port:   4,
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found findCoordinatorResponseV1
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
@@ -0,0 +1,48 @@
+// Package gometrics reports the statistics of kafka readers and writers to
+// registries of the github.com/rcrowley/go-metrics package.
+package gometrics
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+// NewRegistry returns a kafka.MetricsRegistry which registers the metrics it
+// receives in r, or in metrics.DefaultRegistry if r is nil.
+//
+// Histograms are registered with an exponentially decaying sample, the same as
+// the timers created by the go-metrics package.
+func NewRegistry(r metrics.Registry) kafka.MetricsRegistry {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	return registry{registry: r}
+}
+
+type registry struct {
+	registry metrics.Registry
+}
+
+func (r registry) Counter(name string, delta int64) {
+	metrics.GetOrRegisterCounter(name, r.registry).Inc(delta)
+}
+
+func (r registry) Gauge(name string, value int64) {
+	metrics.GetOrRegisterGauge(name, r.registry).Update(value)
+}
+
+func (r registry) Histogram(name string, value int64) {
+	r.registry.GetOrRegister(name, newHistogram).(metrics.Histogram).Update(value)
+}
+
+func (r registry) Timer(name string, duration time.Duration) {
+	metrics.GetOrRegisterTimer(name, r.registry).Update(duration)
+}
+
+// newHistogram is passed to GetOrRegister, which only calls it when the
+// histogram does not exist yet, to avoid allocating a sample on every call.
+func newHistogram() metrics.Histogram {
+	return metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))
+}
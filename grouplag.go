@@ -0,0 +1,207 @@
+package kafka
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// LagInfo describes how far a consumer group is behind the end of a partition,
+// as returned by Dialer.ConsumerGroupLag.
+type LagInfo struct {
+	// CommittedOffset is the offset committed by the group for the partition,
+	// which is the offset of the next message that the group consumes, or -1
+	// if the group has not committed an offset for the partition.
+	CommittedOffset int64
+
+	// HighWatermark is the offset of the next message written to the
+	// partition.
+	HighWatermark int64
+
+	// Lag is the number of messages between the committed offset and the
+	// high watermark, or -1 if the group has not committed an offset for the
+	// partition.
+	Lag int64
+}
+
+// ConsumerGroupLag returns the lag of the consumer group groupID on the
+// partitions of the topics that it committed offsets for, indexed by topic and
+// partition. Partitions of those topics that the group has not committed an
+// offset for yet are reported with a CommittedOffset and a Lag of -1.
+//
+// The committed offsets are fetched from the coordinator of the group and the
+// high watermarks from the leaders of the partitions, which the method looks
+// up by sending a metadata request to address. Since the group may have
+// committed offsets for any topic, the offsets of the partitions of all the
+// topics of the cluster are fetched from the coordinator.
+func (d *Dialer) ConsumerGroupLag(ctx context.Context, network string, address string, groupID string) (map[string]map[int]LagInfo, error) {
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	coordinator, err := d.LookupCoordinator(ctx, network, address, GroupCoordinator, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := d.fetchGroupOffsets(ctx, network, coordinator, groupID, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the partitions of the topics that the group consumes are reported,
+	// they are grouped by leader to list their high watermarks.
+	leaders := make(map[int]Broker)
+	requests := make(map[int]map[string][]int32)
+
+	for _, p := range partitions {
+		if _, ok := committed[p.Topic]; !ok {
+			continue
+		}
+		if p.Leader.ID < 0 {
+			return nil, LeaderNotAvailable
+		}
+		if _, ok := requests[p.Leader.ID]; !ok {
+			leaders[p.Leader.ID] = p.Leader
+			requests[p.Leader.ID] = make(map[string][]int32)
+		}
+		requests[p.Leader.ID][p.Topic] = append(requests[p.Leader.ID][p.Topic], int32(p.ID))
+	}
+
+	lag := make(map[string]map[int]LagInfo, len(committed))
+
+	for id, topics := range requests {
+		highWatermarks, err := d.listHighWatermarks(ctx, network, leaders[id], topics)
+		if err != nil {
+			return nil, err
+		}
+
+		for topic, offsets := range highWatermarks {
+			if lag[topic] == nil {
+				lag[topic] = make(map[int]LagInfo, len(offsets))
+			}
+			for partition, highWatermark := range offsets {
+				info := LagInfo{CommittedOffset: -1, HighWatermark: highWatermark, Lag: -1}
+				if offset, ok := committed[topic][partition]; ok {
+					info.CommittedOffset = offset
+					info.Lag = highWatermark - offset
+				}
+				lag[topic][partition] = info
+			}
+		}
+	}
+
+	return lag, nil
+}
+
+// fetchGroupOffsets fetches the offsets committed by the group for partitions
+// from its coordinator. Only the partitions which have a committed offset are
+// present in the result, the method fails with the error of the first
+// partition that the coordinator could not fetch the offset of.
+func (d *Dialer) fetchGroupOffsets(ctx context.Context, network string, coordinator Broker, groupID string, partitions []Partition) (map[string]map[int]int64, error) {
+	conn, err := d.DialContext(ctx, network, net.JoinHostPort(coordinator.Host, strconv.Itoa(coordinator.Port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	topics := make(map[string][]int32)
+	for _, p := range partitions {
+		topics[p.Topic] = append(topics[p.Topic], int32(p.ID))
+	}
+
+	request := offsetFetchRequestV1{GroupID: groupID}
+	for _, topic := range sortedTopics(topics) {
+		request.Topics = append(request.Topics, offsetFetchRequestV1Topic{
+			Topic:      topic,
+			Partitions: topics[topic],
+		})
+	}
+
+	response, err := conn.offsetFetch(request)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int]int64)
+	for _, t := range response.Responses {
+		for _, p := range t.PartitionResponses {
+			if p.ErrorCode != 0 {
+				return nil, Error(p.ErrorCode)
+			}
+			if p.Offset < 0 {
+				continue
+			}
+			if offsets[t.Topic] == nil {
+				offsets[t.Topic] = make(map[int]int64)
+			}
+			offsets[t.Topic][int(p.Partition)] = p.Offset
+		}
+	}
+	return offsets, nil
+}
+
+// listHighWatermarks lists the high watermarks of the partitions of topics,
+// which must all be led by leader.
+func (d *Dialer) listHighWatermarks(ctx context.Context, network string, leader Broker, topics map[string][]int32) (map[string]map[int]int64, error) {
+	conn, err := d.DialContext(ctx, network, net.JoinHostPort(leader.Host, strconv.Itoa(leader.Port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	request := listOffsetRequestV1{ReplicaID: -1}
+	for _, topic := range sortedTopics(topics) {
+		requestTopic := listOffsetRequestTopicV1{TopicName: topic}
+		for _, p := range topics[topic] {
+			requestTopic.Partitions = append(requestTopic.Partitions, listOffsetRequestPartitionV1{
+				Partition: p,
+				Time:      LastOffset,
+			})
+		}
+		request.Topics = append(request.Topics, requestTopic)
+	}
+
+	response, err := conn.listOffsets(request)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int]int64, len(response))
+	for _, t := range response {
+		offsets[t.TopicName] = make(map[int]int64, len(t.PartitionOffsets))
+		for _, p := range t.PartitionOffsets {
+			if p.ErrorCode != 0 {
+				return nil, Error(p.ErrorCode)
+			}
+			offsets[t.TopicName][int(p.Partition)] = p.Offset
+		}
+	}
+	return offsets, nil
+}
+
+func sortedTopics(topics map[string][]int32) []string {
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDialerConsumerGroupLag(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	broker.CreateTopic("a", 2)
+	broker.CreateTopic("b", 1)
+	broker.CreateTopic("c", 1)
+	broker.MoveLeader("a", 1, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	produce := func(topic string, partition int, n int) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), topic, partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		msgs := make([]Message, n)
+		for i := range msgs {
+			msgs[i] = Message{Value: []byte("hello")}
+		}
+		if _, err := conn.WriteMessages(msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	produce("a", 0, 10)
+	produce("a", 1, 5)
+	produce("b", 0, 3)
+	produce("c", 0, 7)
+
+	// The group commits offsets for the partitions of topic a only, except
+	// for partition 1.
+	conn, err := Dial("tcp", broker.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.offsetCommit(offsetCommitRequestV2{
+		GroupID: "group",
+		Topics: []offsetCommitRequestV2Topic{{
+			Topic:      "a",
+			Partitions: []offsetCommitRequestV2Partition{{Partition: 0, Offset: 4}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lag, err := DefaultDialer.ConsumerGroupLag(ctx, "tcp", broker.Addr(), "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]map[int]LagInfo{
+		"a": {
+			0: {CommittedOffset: 4, HighWatermark: 10, Lag: 6},
+			1: {CommittedOffset: -1, HighWatermark: 5, Lag: -1},
+		},
+	}
+	if !reflect.DeepEqual(lag, expected) {
+		t.Errorf("unexpected lag:\nexpected: %+v\nfound:    %+v", expected, lag)
+	}
+
+	lag, err = DefaultDialer.ConsumerGroupLag(ctx, "tcp", broker.Addr(), "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lag) != 0 {
+		t.Errorf("expected no lag for a group without offsets; got %+v", lag)
+	}
+
+	// The errors of the partitions are reported instead of being mistaken for
+	// partitions without committed offsets.
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockOffsetFetch && req.Group == "denied" {
+			return MockResponse{Error: GroupAuthorizationFailed}
+		}
+		return MockResponse{}
+	})
+	if _, err := DefaultDialer.ConsumerGroupLag(ctx, "tcp", broker.Addr(), "denied"); err != GroupAuthorizationFailed {
+		t.Errorf("expected %v; got %v", GroupAuthorizationFailed, err)
+	}
+}
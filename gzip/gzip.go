@@ -3,6 +3,8 @@ package gzip
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"sync"
 
@@ -11,7 +13,17 @@ import (
 
 var (
 	readerPool sync.Pool
-	writerPool sync.Pool
+
+	// bufferPool holds the buffers that messages are compressed to, the
+	// output is copied out of them.
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+
+	// writerPools holds a pool of writers for each compression level, indexed
+	// by level - gzip.HuffmanOnly, because the level of a gzip.Writer can't be
+	// changed once it was created.
+	writerPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
 
 	// emptyGzipBytes is the binary value for an empty file that has been
 	// gzipped.  It is used to initialize gzip.Reader before adding it to the
@@ -31,10 +43,14 @@ func init() {
 			return reader
 		},
 	}
-	writerPool = sync.Pool{
-		New: func() interface{} {
-			return gzip.NewWriter(bytes.NewBuffer(nil))
-		},
+	for i := range writerPools {
+		level := gzip.HuffmanOnly + i
+		writerPools[i] = sync.Pool{
+			New: func() interface{} {
+				writer, _ := gzip.NewWriterLevel(nil, level)
+				return writer
+			},
+		}
 	}
 
 	kafka.RegisterCompressionCodec(func() kafka.CompressionCodec {
@@ -43,7 +59,10 @@ func init() {
 }
 
 type CompressionCodec struct {
-	// CompressionLevel is the level of compression to use on messages.
+	// CompressionLevel is the level of compression to use on messages, one
+	// of the levels defined by the compress/gzip package. The zero value
+	// selects gzip.DefaultCompression rather than gzip.NoCompression, which
+	// is better achieved by not compressing messages at all.
 	CompressionLevel int
 }
 
@@ -66,10 +85,21 @@ func (c CompressionCodec) Code() int8 {
 
 // Encode implements the kafka.CompressionCodec interface.
 func (c CompressionCodec) Encode(src []byte) ([]byte, error) {
-	buf := bytes.Buffer{}
+	level := c.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	writerPool := &writerPools[level-gzip.HuffmanOnly]
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
 	buf.Grow(len(src)) // guess a size to avoid repeat allocations.
 	writer := writerPool.Get().(*gzip.Writer)
-	writer.Reset(&buf)
+	writer.Reset(buf)
 
 	_, err := writer.Write(src)
 	if err != nil {
@@ -88,7 +118,7 @@ func (c CompressionCodec) Encode(src []byte) ([]byte, error) {
 
 	writerPool.Put(writer)
 
-	return buf.Bytes(), err
+	return append([]byte(nil), buf.Bytes()...), nil
 }
 
 // Decode implements the kafka.CompressionCodec interface.
@@ -105,3 +135,39 @@ func (c CompressionCodec) Decode(src []byte) ([]byte, error) {
 	}
 	return res, err
 }
+
+// NewReader implements the kafka.StreamingCompressionCodec interface.
+func (c CompressionCodec) NewReader(r io.Reader) io.ReadCloser {
+	reader := readerPool.Get().(*gzip.Reader)
+	if err := reader.Reset(r); err != nil {
+		// don't return reader to pool on error.
+		return &decoder{err: err}
+	}
+	return &decoder{reader: reader}
+}
+
+// decoder returns the gzip reader to the pool when it is closed, unless
+// reading failed.
+type decoder struct {
+	reader *gzip.Reader
+	err    error
+}
+
+func (d *decoder) Read(b []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.reader.Read(b)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+func (d *decoder) Close() error {
+	if d.reader != nil && d.err == nil {
+		readerPool.Put(d.reader)
+	}
+	d.reader, d.err = nil, io.ErrClosedPipe
+	return nil
+}
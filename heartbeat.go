@@ -44,3 +44,102 @@ func (t *heartbeatResponseV0) readFrom(r *bufio.Reader, sz int) (remain int, err
 	}
 	return
 }
+
+// heartbeatRequestV3 adds the group instance ID used by static members.
+type heartbeatRequestV3 struct {
+	// GroupID holds the unique group identifier
+	GroupID string
+
+	// GenerationID holds the generation of the group.
+	GenerationID int32
+
+	// MemberID assigned by the group coordinator
+	MemberID string
+
+	// GroupInstanceID holds the unique identifier of the consumer instance
+	// provided by end user, or the zero string for dynamic members.
+	GroupInstanceID string
+}
+
+func (t heartbeatRequestV3) size() int32 {
+	return sizeofString(t.GroupID) +
+		sizeofInt32(t.GenerationID) +
+		sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID)
+}
+
+func (t heartbeatRequestV3) writeTo(w *bufio.Writer) {
+	writeString(w, t.GroupID)
+	writeInt32(w, t.GenerationID)
+	writeString(w, t.MemberID)
+	writeNullableString(w, t.GroupInstanceID)
+}
+
+type heartbeatResponseV3 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode holds response error code
+	ErrorCode int16
+}
+
+func (t heartbeatResponseV3) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t heartbeatResponseV3) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *heartbeatResponseV3) readFrom(r *bufio.Reader, sz int) (remain int, err error) {
+	if remain, err = readInt32(r, sz, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+// HeartbeatRequest is the request passed to Conn.Heartbeat.
+type HeartbeatRequest struct {
+	// GroupID is the ID of the consumer group.
+	GroupID string
+
+	// GenerationID and MemberID are the values of the JoinGroup response.
+	GenerationID int
+	MemberID     string
+
+	// GroupInstanceID must be set to the value passed to JoinGroup by static
+	// members.
+	GroupInstanceID string
+}
+
+// Heartbeat signals to the coordinator of the group that the member is alive,
+// it must be called more often than the session timeout of the member.
+//
+// The connection must be established to the coordinator of the group, other
+// brokers respond with NotCoordinatorForGroup. RebalanceInProgress is returned
+// when the member must join the group again.
+func (c *Conn) Heartbeat(request HeartbeatRequest) error {
+	if request.GroupInstanceID == "" {
+		_, err := c.heartbeat(heartbeatRequestV0{
+			GroupID:      request.GroupID,
+			GenerationID: int32(request.GenerationID),
+			MemberID:     request.MemberID,
+		})
+		return err
+	}
+
+	_, err := c.heartbeatV3(heartbeatRequestV3{
+		GroupID:         request.GroupID,
+		GenerationID:    int32(request.GenerationID),
+		MemberID:        request.MemberID,
+		GroupInstanceID: request.GroupInstanceID,
+	})
+	return err
+}
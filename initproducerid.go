@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// TransactionalProducer identifies a producer writing records in transactions,
+// it is returned by Conn.InitProducerID and passed to the other requests of the
+// transactions of the producer.
+//
+// Transactions are supported by kafka 0.11 and above.
+type TransactionalProducer struct {
+	// TransactionalID is the ID assigned by the program to the producer, it
+	// must stay the same across restarts of the program so the coordinator
+	// completes the transactions left open by the previous instance.
+	TransactionalID string
+
+	// ProducerID and ProducerEpoch are assigned by the transaction coordinator
+	// of the producer. The epoch is bumped each time the producer is
+	// initialized, which fences the previous instances using the same
+	// TransactionalID.
+	ProducerID    int64
+	ProducerEpoch int16
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_InitProducerId
+type initProducerIDRequestV0 struct {
+	// TransactionalID is the transactional ID of the producer, or empty to
+	// get a producer ID for an idempotent producer.
+	TransactionalID string
+
+	// TransactionTimeoutMS is the time in milliseconds after which the
+	// coordinator aborts the transactions of the producer which are still
+	// open.
+	TransactionTimeoutMS int32
+}
+
+func (t initProducerIDRequestV0) size() int32 {
+	return sizeofString(t.TransactionalID) +
+		sizeofInt32(t.TransactionTimeoutMS)
+}
+
+func (t initProducerIDRequestV0) writeTo(w *bufio.Writer) {
+	writeNullableString(w, t.TransactionalID)
+	writeInt32(w, t.TransactionTimeoutMS)
+}
+
+type initProducerIDResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+	ErrorCode      int16
+	ProducerID     int64
+	ProducerEpoch  int16
+}
+
+func (t initProducerIDResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofInt64(t.ProducerID) +
+		sizeofInt16(t.ProducerEpoch)
+}
+
+func (t initProducerIDResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeInt64(w, t.ProducerID)
+	writeInt16(w, t.ProducerEpoch)
+}
+
+func (t *initProducerIDResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readInt64(r, remain, &t.ProducerID); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ProducerEpoch); err != nil {
+		return
+	}
+	return
+}
+
+// initProducerID assigns a producer ID to the producer of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_InitProducerId
+func (c *Conn) initProducerID(request initProducerIDRequestV0) (initProducerIDResponseV0, error) {
+	var response initProducerIDResponseV0
+
+	if _, err := c.negotiatedVersion(initProducerIDRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		initProducerIDRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(initProducerIDRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return initProducerIDResponseV0{}, err
+	}
+	if response.ErrorCode != 0 {
+		return initProducerIDResponseV0{}, Error(response.ErrorCode)
+	}
+
+	return response, nil
+}
+
+// InitProducerID initializes the transactional producer identified by
+// transactionalID, returning the producer ID and epoch that its transactions
+// must be written with. The coordinator aborts the transactions which are left
+// open for longer than timeout, which must not exceed the
+// transaction.max.timeout.ms setting of the brokers.
+//
+// Initializing a producer completes the transaction left open by the previous
+// instance with the same transactionalID, and bumps the epoch of the producer
+// so the previous instance can't write to its transactions anymore. The method
+// fails with ConcurrentTransactions while the previous transaction is being
+// completed, it may then be retried.
+//
+// The request must be sent to the transaction coordinator of transactionalID
+// (see Dialer.LookupCoordinator), and is only supported by kafka 0.11 and
+// above.
+func (c *Conn) InitProducerID(transactionalID string, timeout time.Duration) (TransactionalProducer, error) {
+	response, err := c.initProducerID(initProducerIDRequestV0{
+		TransactionalID:      transactionalID,
+		TransactionTimeoutMS: milliseconds(timeout),
+	})
+	if err != nil {
+		return TransactionalProducer{}, err
+	}
+	return TransactionalProducer{
+		TransactionalID: transactionalID,
+		ProducerID:      response.ProducerID,
+		ProducerEpoch:   response.ProducerEpoch,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInitProducerIDResponseV0(t *testing.T) {
+	item := initProducerIDResponseV0{
+		ThrottleTimeMS: 1,
+		ProducerID:     42,
+		ProducerEpoch:  3,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found initProducerIDResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestInitProducerIDRequestV0(t *testing.T) {
+	for _, request := range []initProducerIDRequestV0{
+		{TransactionalID: "txn", TransactionTimeoutMS: 60000},
+		{TransactionTimeoutMS: 60000},
+	} {
+		buf := bytes.NewBuffer(nil)
+		w := bufio.NewWriter(buf)
+		request.writeTo(w)
+		w.Flush()
+
+		if size := request.size(); int(size) != buf.Len() {
+			t.Errorf("expected size %d, got %d", buf.Len(), size)
+		}
+	}
+}
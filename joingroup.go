@@ -3,6 +3,8 @@ package kafka
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"time"
 )
 
 type memberGroupMetadata struct {
@@ -157,7 +159,7 @@ func (t joinGroupResponseV1) size() int32 {
 		sizeofString(t.GroupProtocol) +
 		sizeofString(t.LeaderID) +
 		sizeofString(t.MemberID) +
-		sizeofArray(len(t.MemberID), func(i int) int32 { return t.Members[i].size() })
+		sizeofArray(len(t.Members), func(i int) int32 { return t.Members[i].size() })
 }
 
 func (t joinGroupResponseV1) writeTo(w *bufio.Writer) {
@@ -200,3 +202,329 @@ func (t *joinGroupResponseV1) readFrom(r *bufio.Reader, size int) (remain int, e
 
 	return
 }
+
+// joinGroupRequestV5 adds the group instance ID used by static members, it is
+// only sent when ReaderConfig.GroupInstanceID is set.
+//
+// See https://cwiki.apache.org/confluence/display/KAFKA/KIP-345%3A+Introduce+static+membership+protocol+to+reduce+consumer+rebalances
+type joinGroupRequestV5 struct {
+	// GroupID holds the unique group identifier
+	GroupID string
+
+	// SessionTimeout holds the coordinator considers the consumer dead if it
+	// receives no heartbeat after this timeout in ms.
+	SessionTimeout int32
+
+	// RebalanceTimeout holds the maximum time that the coordinator will wait
+	// for each member to rejoin when rebalancing the group in ms
+	RebalanceTimeout int32
+
+	// MemberID assigned by the group coordinator or the zero string if joining
+	// for the first time.
+	MemberID string
+
+	// GroupInstanceID holds the unique identifier of the consumer instance
+	// provided by end user, or the zero string for dynamic members.
+	GroupInstanceID string
+
+	// ProtocolType holds the unique name for class of protocols implemented by group
+	ProtocolType string
+
+	// GroupProtocols holds the list of protocols that the member supports
+	GroupProtocols []joinGroupRequestGroupProtocolV1
+}
+
+func (t joinGroupRequestV5) size() int32 {
+	return sizeofString(t.GroupID) +
+		sizeofInt32(t.SessionTimeout) +
+		sizeofInt32(t.RebalanceTimeout) +
+		sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID) +
+		sizeofString(t.ProtocolType) +
+		sizeofArray(len(t.GroupProtocols), func(i int) int32 { return t.GroupProtocols[i].size() })
+}
+
+func (t joinGroupRequestV5) writeTo(w *bufio.Writer) {
+	writeString(w, t.GroupID)
+	writeInt32(w, t.SessionTimeout)
+	writeInt32(w, t.RebalanceTimeout)
+	writeString(w, t.MemberID)
+	writeNullableString(w, t.GroupInstanceID)
+	writeString(w, t.ProtocolType)
+	writeArray(w, len(t.GroupProtocols), func(i int) { t.GroupProtocols[i].writeTo(w) })
+}
+
+type joinGroupResponseMemberV5 struct {
+	// MemberID assigned by the group coordinator
+	MemberID string
+
+	// GroupInstanceID holds the instance ID of static members, the zero
+	// string for dynamic members.
+	GroupInstanceID string
+	MemberMetadata  []byte
+}
+
+func (t joinGroupResponseMemberV5) size() int32 {
+	return sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID) +
+		sizeofBytes(t.MemberMetadata)
+}
+
+func (t joinGroupResponseMemberV5) writeTo(w *bufio.Writer) {
+	writeString(w, t.MemberID)
+	writeString(w, t.GroupInstanceID)
+	writeBytes(w, t.MemberMetadata)
+}
+
+func (t *joinGroupResponseMemberV5) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.MemberID); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.GroupInstanceID); err != nil {
+		return
+	}
+	if remain, err = readBytes(r, remain, &t.MemberMetadata); err != nil {
+		return
+	}
+	return
+}
+
+type joinGroupResponseV5 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode holds response error code
+	ErrorCode int16
+
+	// GenerationID holds the generation of the group.
+	GenerationID int32
+
+	// GroupProtocol holds the group protocol selected by the coordinator
+	GroupProtocol string
+
+	// LeaderID holds the leader of the group
+	LeaderID string
+
+	// MemberID assigned by the group coordinator
+	MemberID string
+	Members  []joinGroupResponseMemberV5
+}
+
+func (t joinGroupResponseV5) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofInt32(t.GenerationID) +
+		sizeofString(t.GroupProtocol) +
+		sizeofString(t.LeaderID) +
+		sizeofString(t.MemberID) +
+		sizeofArray(len(t.Members), func(i int) int32 { return t.Members[i].size() })
+}
+
+func (t joinGroupResponseV5) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeInt32(w, t.GenerationID)
+	writeString(w, t.GroupProtocol)
+	writeString(w, t.LeaderID)
+	writeString(w, t.MemberID)
+	writeArray(w, len(t.Members), func(i int) { t.Members[i].writeTo(w) })
+}
+
+func (t *joinGroupResponseV5) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readInt32(r, remain, &t.GenerationID); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.GroupProtocol); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.LeaderID); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.MemberID); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item joinGroupResponseMemberV5
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Members = append(t.Members, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// toV1 converts the response to the form used by the consumer group handshake
+// of the reader, the group instance IDs of the members are dropped.
+func (t joinGroupResponseV5) toV1() joinGroupResponseV1 {
+	response := joinGroupResponseV1{
+		ErrorCode:     t.ErrorCode,
+		GenerationID:  t.GenerationID,
+		GroupProtocol: t.GroupProtocol,
+		LeaderID:      t.LeaderID,
+		MemberID:      t.MemberID,
+	}
+	for _, member := range t.Members {
+		response.Members = append(response.Members, joinGroupResponseMemberV1{
+			MemberID:       member.MemberID,
+			MemberMetadata: member.MemberMetadata,
+		})
+	}
+	return response
+}
+
+// makeGroupMembers decodes the subscriptions of the members of a group, as
+// sent to the leader in the JoinGroup response.
+func makeGroupMembers(in []joinGroupResponseMemberV1) ([]GroupMember, error) {
+	members := make([]GroupMember, 0, len(in))
+	for _, item := range in {
+		metadata := groupMetadata{}
+		reader := bufio.NewReader(bytes.NewReader(item.MemberMetadata))
+		if remain, err := (&metadata).readFrom(reader, len(item.MemberMetadata)); err != nil || remain != 0 {
+			return nil, fmt.Errorf("unable to read metadata for member, %v: %v\n", item.MemberID, err)
+		}
+
+		members = append(members, GroupMember{
+			ID:       item.MemberID,
+			Topics:   metadata.Topics,
+			UserData: metadata.UserData,
+		})
+	}
+	return members, nil
+}
+
+// JoinGroupRequest is the request passed to Conn.JoinGroup.
+type JoinGroupRequest struct {
+	// GroupID is the ID of the consumer group to join.
+	GroupID string
+
+	// MemberID is the ID that the coordinator assigned to the member when it
+	// previously joined the group, it is empty when joining for the first time.
+	MemberID string
+
+	// GroupInstanceID makes the member static when set, static membership is
+	// only supported by kafka 2.3 and above.
+	GroupInstanceID string
+
+	// SessionTimeout is the time after which the coordinator removes the member
+	// from the group if it didn't receive a heartbeat.
+	SessionTimeout time.Duration
+
+	// RebalanceTimeout is the maximum time that the coordinator waits for the
+	// members to rejoin the group when rebalancing.
+	RebalanceTimeout time.Duration
+
+	// Protocols lists the assignment protocols supported by the member, by
+	// order of preference.
+	Protocols []GroupProtocol
+}
+
+// GroupProtocol is an assignment protocol that a member supports when joining
+// a consumer group.
+type GroupProtocol struct {
+	// Name is the name of the protocol, for example the ProtocolName of a
+	// GroupBalancer.
+	Name string
+
+	// Topics is the list of topics that the member subscribes to.
+	Topics []string
+
+	// UserData is passed to the leader of the group along with the topics.
+	UserData []byte
+}
+
+// JoinGroupResponse is the response returned by Conn.JoinGroup.
+type JoinGroupResponse struct {
+	// GenerationID is the generation of the group that the member joined.
+	GenerationID int
+
+	// GroupProtocol is the name of the assignment protocol selected by the
+	// coordinator.
+	GroupProtocol string
+
+	// LeaderID is the ID of the member that leads the group, and MemberID the
+	// one assigned to the member that joined. When they are equal, the member
+	// is responsible for computing the assignments passed to SyncGroup.
+	LeaderID string
+	MemberID string
+
+	// Members holds the subscriptions of the members of the group, it is only
+	// set in the response received by the leader.
+	Members []GroupMember
+}
+
+// JoinGroup joins the consumer group described by the request, with the
+// "consumer" protocol type used by the kafka clients.
+//
+// The connection must be established to the coordinator of the group, other
+// brokers respond with NotCoordinatorForGroup. The call blocks until all the
+// members joined the group or the rebalance timeout expired, the deadlines of
+// the connection must account for it.
+func (c *Conn) JoinGroup(request JoinGroupRequest) (JoinGroupResponse, error) {
+	var protocols []joinGroupRequestGroupProtocolV1
+	for _, p := range request.Protocols {
+		protocols = append(protocols, joinGroupRequestGroupProtocolV1{
+			ProtocolName: p.Name,
+			ProtocolMetadata: groupMetadata{
+				Version:  1,
+				Topics:   p.Topics,
+				UserData: p.UserData,
+			}.bytes(),
+		})
+	}
+
+	var response joinGroupResponseV1
+	var err error
+
+	if request.GroupInstanceID == "" {
+		response, err = c.joinGroup(joinGroupRequestV1{
+			GroupID:          request.GroupID,
+			SessionTimeout:   milliseconds(request.SessionTimeout),
+			RebalanceTimeout: milliseconds(request.RebalanceTimeout),
+			MemberID:         request.MemberID,
+			ProtocolType:     defaultProtocolType,
+			GroupProtocols:   protocols,
+		})
+	} else {
+		var responseV5 joinGroupResponseV5
+		responseV5, err = c.joinGroupV5(joinGroupRequestV5{
+			GroupID:          request.GroupID,
+			SessionTimeout:   milliseconds(request.SessionTimeout),
+			RebalanceTimeout: milliseconds(request.RebalanceTimeout),
+			MemberID:         request.MemberID,
+			GroupInstanceID:  request.GroupInstanceID,
+			ProtocolType:     defaultProtocolType,
+			GroupProtocols:   protocols,
+		})
+		response = responseV5.toV1()
+	}
+	if err != nil {
+		return JoinGroupResponse{}, err
+	}
+
+	members, err := makeGroupMembers(response.Members)
+	if err != nil {
+		return JoinGroupResponse{}, err
+	}
+
+	return JoinGroupResponse{
+		GenerationID:  int(response.GenerationID),
+		GroupProtocol: response.GroupProtocol,
+		LeaderID:      response.LeaderID,
+		MemberID:      response.MemberID,
+		Members:       members,
+	}, nil
+}
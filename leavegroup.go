@@ -40,3 +40,146 @@ func (t *leaveGroupResponseV0) readFrom(r *bufio.Reader, size int) (remain int,
 	}
 	return
 }
+
+// leaveGroupRequestV3 removes a batch of members from the group, identifying
+// static members by their group instance ID.
+type leaveGroupRequestV3 struct {
+	// GroupID holds the unique group identifier
+	GroupID string
+
+	// Members holds the members leaving the group.
+	Members []leaveGroupRequestV3Member
+}
+
+func (t leaveGroupRequestV3) size() int32 {
+	return sizeofString(t.GroupID) +
+		sizeofArray(len(t.Members), func(i int) int32 { return t.Members[i].size() })
+}
+
+func (t leaveGroupRequestV3) writeTo(w *bufio.Writer) {
+	writeString(w, t.GroupID)
+	writeArray(w, len(t.Members), func(i int) { t.Members[i].writeTo(w) })
+}
+
+type leaveGroupRequestV3Member struct {
+	// MemberID assigned by the group coordinator
+	MemberID string
+
+	// GroupInstanceID holds the unique identifier of the consumer instance
+	// provided by end user, or the zero string for dynamic members.
+	GroupInstanceID string
+}
+
+func (t leaveGroupRequestV3Member) size() int32 {
+	return sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID)
+}
+
+func (t leaveGroupRequestV3Member) writeTo(w *bufio.Writer) {
+	writeString(w, t.MemberID)
+	writeNullableString(w, t.GroupInstanceID)
+}
+
+type leaveGroupResponseV3 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode holds response error code
+	ErrorCode int16
+
+	// Members holds the results of each member of the request.
+	Members []leaveGroupResponseV3Member
+}
+
+func (t leaveGroupResponseV3) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofArray(len(t.Members), func(i int) int32 { return t.Members[i].size() })
+}
+
+func (t leaveGroupResponseV3) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeArray(w, len(t.Members), func(i int) { t.Members[i].writeTo(w) })
+}
+
+func (t *leaveGroupResponseV3) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item leaveGroupResponseV3Member
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Members = append(t.Members, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+	return
+}
+
+type leaveGroupResponseV3Member struct {
+	MemberID        string
+	GroupInstanceID string
+	ErrorCode       int16
+}
+
+func (t leaveGroupResponseV3Member) size() int32 {
+	return sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t leaveGroupResponseV3Member) writeTo(w *bufio.Writer) {
+	writeString(w, t.MemberID)
+	writeNullableString(w, t.GroupInstanceID)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *leaveGroupResponseV3Member) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.MemberID); err != nil {
+		return
+	}
+	if remain, err = readString(r, remain, &t.GroupInstanceID); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+// LeaveGroupRequest is the request passed to Conn.LeaveGroup.
+type LeaveGroupRequest struct {
+	// GroupID is the ID of the consumer group.
+	GroupID string
+
+	// MemberID is the value of the JoinGroup response.
+	MemberID string
+}
+
+// LeaveGroup removes the member from the group, which triggers a rebalance
+// without waiting for the session of the member to time out.
+//
+// Static members are not expected to leave the group, the coordinator keeps
+// their partitions until their session times out so they can rejoin without
+// triggering a rebalance.
+//
+// The connection must be established to the coordinator of the group, other
+// brokers respond with NotCoordinatorForGroup.
+func (c *Conn) LeaveGroup(request LeaveGroupRequest) error {
+	_, err := c.leaveGroup(leaveGroupRequestV0{
+		GroupID:  request.GroupID,
+		MemberID: request.MemberID,
+	})
+	return err
+}
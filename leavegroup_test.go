@@ -32,3 +32,53 @@ func TestLeaveGroupResponseV0(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestLeaveGroupRequestV3(t *testing.T) {
+	request := leaveGroupRequestV3{
+		GroupID: "group",
+		Members: []leaveGroupRequestV3Member{
+			{MemberID: "a", GroupInstanceID: "instance-a"},
+			{MemberID: "b"},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
+
+func TestLeaveGroupResponseV3(t *testing.T) {
+	item := leaveGroupResponseV3{
+		ThrottleTimeMS: 1,
+		Members: []leaveGroupResponseV3Member{
+			{MemberID: "a", GroupInstanceID: "instance-a"},
+			{MemberID: "b", ErrorCode: int16(UnknownMemberId)},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found leaveGroupResponseV3
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
@@ -55,6 +55,18 @@ func (r listOffsetResponseV1) writeTo(w *bufio.Writer) {
 	writeArray(w, len(r), func(i int) { r[i].writeTo(w) })
 }
 
+func (r *listOffsetResponseV1) readFrom(rb *bufio.Reader, size int) (remain int, err error) {
+	fn := func(rb *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item listOffsetResponseTopicV1
+		if fnRemain, fnErr = (&item).readFrom(rb, size); fnErr != nil {
+			return
+		}
+		*r = append(*r, item)
+		return
+	}
+	return readArrayWith(rb, size, fn)
+}
+
 type listOffsetResponseTopicV1 struct {
 	TopicName        string
 	PartitionOffsets []partitionOffsetV1
@@ -70,6 +82,26 @@ func (t listOffsetResponseTopicV1) writeTo(w *bufio.Writer) {
 	writeArray(w, len(t.PartitionOffsets), func(i int) { t.PartitionOffsets[i].writeTo(w) })
 }
 
+func (t *listOffsetResponseTopicV1) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.TopicName); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item partitionOffsetV1
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.PartitionOffsets = append(t.PartitionOffsets, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
 type partitionOffsetV1 struct {
 	Partition int32
 	ErrorCode int16
@@ -0,0 +1,307 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// PartitionReassignment describes the reassignment of a partition which is in
+// progress.
+type PartitionReassignment struct {
+	Topic     string
+	Partition int
+
+	// Replicas holds the IDs of the brokers which are replicas of the
+	// partition, including the ones being added or removed.
+	Replicas []int
+
+	// AddingReplicas holds the IDs of the brokers that the partition is being
+	// moved to.
+	AddingReplicas []int
+
+	// RemovingReplicas holds the IDs of the brokers that the partition is
+	// being moved away from.
+	RemovingReplicas []int
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_ListPartitionReassignments
+type listPartitionReassignmentsRequestV0 struct {
+	// TimeoutMS is the time in milliseconds to wait for the request to
+	// complete.
+	TimeoutMS int32
+
+	// Topics holds the partitions to list the reassignments of, a null array
+	// is sent when empty to list all the reassignments in progress.
+	Topics []listPartitionReassignmentsRequestV0Topic
+}
+
+func (t listPartitionReassignmentsRequestV0) size() int32 {
+	return sizeofInt32(t.TimeoutMS) +
+		sizeofCompactArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t listPartitionReassignmentsRequestV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.TimeoutMS)
+	if t.Topics == nil {
+		writeCompactArrayLen(w, -1)
+	} else {
+		writeCompactArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+	}
+	writeTaggedFields(w)
+}
+
+type listPartitionReassignmentsRequestV0Topic struct {
+	Name             string
+	PartitionIndexes []int32
+}
+
+func (t listPartitionReassignmentsRequestV0Topic) size() int32 {
+	return sizeofCompactString(t.Name) +
+		sizeofCompactInt32Array(t.PartitionIndexes) +
+		sizeofTaggedFields()
+}
+
+func (t listPartitionReassignmentsRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeCompactString(w, t.Name)
+	writeCompactInt32Array(w, t.PartitionIndexes)
+	writeTaggedFields(w)
+}
+
+type listPartitionReassignmentsResponseV0Partition struct {
+	PartitionIndex   int32
+	Replicas         []int32
+	AddingReplicas   []int32
+	RemovingReplicas []int32
+}
+
+func (t listPartitionReassignmentsResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofCompactInt32Array(t.Replicas) +
+		sizeofCompactInt32Array(t.AddingReplicas) +
+		sizeofCompactInt32Array(t.RemovingReplicas) +
+		sizeofTaggedFields()
+}
+
+func (t listPartitionReassignmentsResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeCompactInt32Array(w, t.Replicas)
+	writeCompactInt32Array(w, t.AddingReplicas)
+	writeCompactInt32Array(w, t.RemovingReplicas)
+	writeTaggedFields(w)
+}
+
+func (t *listPartitionReassignmentsResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readCompactInt32Array(r, remain, &t.Replicas); err != nil {
+		return
+	}
+	if remain, err = readCompactInt32Array(r, remain, &t.AddingReplicas); err != nil {
+		return
+	}
+	if remain, err = readCompactInt32Array(r, remain, &t.RemovingReplicas); err != nil {
+		return
+	}
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+type listPartitionReassignmentsResponseV0Topic struct {
+	Name       string
+	Partitions []listPartitionReassignmentsResponseV0Partition
+}
+
+func (t listPartitionReassignmentsResponseV0Topic) size() int32 {
+	return sizeofCompactString(t.Name) +
+		sizeofCompactArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t listPartitionReassignmentsResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeCompactString(w, t.Name)
+	writeCompactArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+func (t *listPartitionReassignmentsResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readCompactString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item listPartitionReassignmentsResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+type listPartitionReassignmentsResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode is the top level error of the request.
+	ErrorCode    int16
+	ErrorMessage string
+
+	// Topics holds the reassignments in progress.
+	Topics []listPartitionReassignmentsResponseV0Topic
+}
+
+func (t listPartitionReassignmentsResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofCompactString(t.ErrorMessage) +
+		sizeofCompactArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() }) +
+		sizeofTaggedFields()
+}
+
+func (t listPartitionReassignmentsResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeCompactString(w, t.ErrorMessage)
+	writeCompactArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+	writeTaggedFields(w)
+}
+
+func (t *listPartitionReassignmentsResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readCompactString(r, remain, &t.ErrorMessage); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item listPartitionReassignmentsResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+// listPartitionReassignments lists the reassignments in progress of the
+// partitions of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ListPartitionReassignments
+func (c *Conn) listPartitionReassignments(request listPartitionReassignmentsRequestV0) (listPartitionReassignmentsResponseV0, error) {
+	var response listPartitionReassignmentsResponseV0
+
+	if _, err := c.negotiatedVersion(listPartitionReassignmentsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.readOperation(
+		listPartitionReassignmentsRequest,
+		func(deadline time.Time, id int32) error {
+			if request.TimeoutMS == 0 {
+				now := time.Now()
+				deadline = adjustDeadlineForRTT(deadline, now, defaultRTT)
+				request.TimeoutMS = milliseconds(deadlineToTimeout(deadline, now))
+			}
+			return c.writeFlexibleRequest(listPartitionReassignmentsRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				// tagged fields of the response header
+				if remain, err = discardTaggedFields(&c.rbuf, size); err != nil {
+					return
+				}
+				return (&response).readFrom(&c.rbuf, remain)
+			}())
+		},
+	)
+	if err != nil {
+		return listPartitionReassignmentsResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// ListPartitionReassignments returns the reassignments in progress of the
+// partitions of the topics in topicPartitions, or of all partitions of the
+// cluster if it is empty. A reassignment started by
+// AlterPartitionReassignments is complete once it is not listed anymore.
+//
+// The request must be sent to the controller of the cluster (see Controller),
+// and is only supported by kafka 2.4 and above.
+func (c *Conn) ListPartitionReassignments(topicPartitions map[string][]int) ([]PartitionReassignment, error) {
+	var request listPartitionReassignmentsRequestV0
+
+	topics := make([]string, 0, len(topicPartitions))
+	for topic := range topicPartitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		partitions := make([]int32, len(topicPartitions[topic]))
+		for i, p := range topicPartitions[topic] {
+			partitions[i] = int32(p)
+		}
+		request.Topics = append(request.Topics, listPartitionReassignmentsRequestV0Topic{
+			Name:             topic,
+			PartitionIndexes: partitions,
+		})
+	}
+
+	response, err := c.listPartitionReassignments(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != 0 {
+		return nil, Error(response.ErrorCode)
+	}
+
+	var reassignments []PartitionReassignment
+	for _, t := range response.Topics {
+		for _, p := range t.Partitions {
+			reassignments = append(reassignments, PartitionReassignment{
+				Topic:            t.Name,
+				Partition:        int(p.PartitionIndex),
+				Replicas:         makeBrokerIDs(p.Replicas),
+				AddingReplicas:   makeBrokerIDs(p.AddingReplicas),
+				RemovingReplicas: makeBrokerIDs(p.RemovingReplicas),
+			})
+		}
+	}
+	return reassignments, nil
+}
+
+func makeBrokerIDs(ids []int32) []int {
+	brokers := make([]int, len(ids))
+	for i, id := range ids {
+		brokers[i] = int(id)
+	}
+	return brokers
+}
@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestListPartitionReassignmentsResponseV0(t *testing.T) {
+	item := listPartitionReassignmentsResponseV0{
+		ThrottleTimeMS: 1,
+		Topics: []listPartitionReassignmentsResponseV0Topic{
+			{
+				Name: "a",
+				Partitions: []listPartitionReassignmentsResponseV0Partition{
+					{
+						PartitionIndex:   0,
+						Replicas:         []int32{1, 2, 3},
+						AddingReplicas:   []int32{3},
+						RemovingReplicas: []int32{1},
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found listPartitionReassignmentsResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestListPartitionReassignmentsRequestV0(t *testing.T) {
+	for _, request := range []listPartitionReassignmentsRequestV0{
+		{TimeoutMS: 1000},
+		{
+			TimeoutMS: 1000,
+			Topics: []listPartitionReassignmentsRequestV0Topic{
+				{Name: "a", PartitionIndexes: []int32{0, 1}},
+			},
+		},
+	} {
+		buf := bytes.NewBuffer(nil)
+		w := bufio.NewWriter(buf)
+		request.writeTo(w)
+		w.Flush()
+
+		if size := request.size(); int(size) != buf.Len() {
+			t.Errorf("expected size %d, got %d", buf.Len(), size)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the interface used by readers and writers to report what happens
+// internally. Each entry is emitted at a level and carries a message along
+// with a list of alternating keys and values giving structured context, for
+// example:
+//
+//	logger.Info("joined consumer group", "group", "my-group", "generation", 42)
+//
+// Implementations must be safe to use concurrently from multiple goroutines.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// LoggerFunc is a bridge between Logger and any third party logger exposing a
+// printf-style API, such as (*log.Logger).Printf:
+//
+//	kafka.LoggerFunc(log.New(os.Stderr, "kafka: ", 0).Printf)
+//
+// Entries are rendered on a single line in logfmt style, starting with the
+// level and the message followed by the key/value pairs.
+type LoggerFunc func(string, ...interface{})
+
+// Printf calls f, it makes LoggerFunc usable where a printf-style logger is
+// expected.
+func (f LoggerFunc) Printf(msg string, args ...interface{}) { f(msg, args...) }
+
+// Debug satisfies the Logger interface.
+func (f LoggerFunc) Debug(msg string, keyvals ...interface{}) { f.log("debug", msg, keyvals) }
+
+// Info satisfies the Logger interface.
+func (f LoggerFunc) Info(msg string, keyvals ...interface{}) { f.log("info", msg, keyvals) }
+
+// Warn satisfies the Logger interface.
+func (f LoggerFunc) Warn(msg string, keyvals ...interface{}) { f.log("warn", msg, keyvals) }
+
+// Error satisfies the Logger interface.
+func (f LoggerFunc) Error(msg string, keyvals ...interface{}) { f.log("error", msg, keyvals) }
+
+func (f LoggerFunc) log(level string, msg string, keyvals []interface{}) {
+	f("%s", formatLogEntry(level, msg, keyvals))
+}
+
+// formatLogEntry renders a log entry in logfmt style. A missing value for the
+// last key is reported as "MISSING" rather than being silently dropped.
+func formatLogEntry(level string, msg string, keyvals []interface{}) string {
+	b := &bytes.Buffer{}
+	b.WriteString("level=")
+	b.WriteString(level)
+	b.WriteString(" msg=")
+	b.WriteString(formatLogValue(msg))
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "MISSING"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(val))
+	}
+
+	return b.String()
+}
+
+func formatLogValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// stdLogger adapts the *log.Logger values of the reader and writer
+// configurations to the Logger interface. Debug and info entries are sent to
+// logger, warnings and errors to errorLogger, or logger if it is nil.
+type stdLogger struct {
+	logger      *log.Logger
+	errorLogger *log.Logger
+}
+
+func (l stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if l.logger != nil {
+		LoggerFunc(l.logger.Printf).Debug(msg, keyvals...)
+	}
+}
+
+func (l stdLogger) Info(msg string, keyvals ...interface{}) {
+	if l.logger != nil {
+		LoggerFunc(l.logger.Printf).Info(msg, keyvals...)
+	}
+}
+
+func (l stdLogger) Warn(msg string, keyvals ...interface{}) {
+	if logger := l.errorLoggerOrDefault(); logger != nil {
+		LoggerFunc(logger.Printf).Warn(msg, keyvals...)
+	}
+}
+
+func (l stdLogger) Error(msg string, keyvals ...interface{}) {
+	if logger := l.errorLoggerOrDefault(); logger != nil {
+		LoggerFunc(logger.Printf).Error(msg, keyvals...)
+	}
+}
+
+func (l stdLogger) errorLoggerOrDefault() *log.Logger {
+	if l.errorLogger != nil {
+		return l.errorLogger
+	}
+	return l.logger
+}
+
+// makeLogger returns the Logger used internally by readers and writers. The
+// structured logger takes precedence when it is set, otherwise entries are
+// forwarded to the standard loggers (which may both be nil, in which case
+// nothing is logged).
+func makeLogger(structured Logger, logger *log.Logger, errorLogger *log.Logger) Logger {
+	if structured != nil {
+		return structured
+	}
+	return stdLogger{logger: logger, errorLogger: errorLogger}
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestLoggerFunc(t *testing.T) {
+	tests := []struct {
+		scenario string
+		log      func(Logger)
+		expected string
+	}{
+		{
+			scenario: "message without key/value pairs",
+			log:      func(l Logger) { l.Info("hello") },
+			expected: "level=info msg=hello",
+		},
+		{
+			scenario: "message and values with spaces are quoted",
+			log:      func(l Logger) { l.Warn("partition leader changed", "topic", "my topic", "partition", 1) },
+			expected: `level=warn msg="partition leader changed" topic="my topic" partition=1`,
+		},
+		{
+			scenario: "missing value is reported",
+			log:      func(l Logger) { l.Error("failed", "error") },
+			expected: "level=error msg=failed error=MISSING",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			var found string
+			test.log(LoggerFunc(func(msg string, args ...interface{}) {
+				found = fmt.Sprintf(msg, args...)
+			}))
+			if found != test.expected {
+				t.Errorf("expected %q; got %q", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestMakeLoggerRoutesLevels(t *testing.T) {
+	info := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+
+	l := makeLogger(nil, log.New(info, "", 0), log.New(errs, "", 0))
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	if expected := "level=debug msg=debug\nlevel=info msg=info\n"; info.String() != expected {
+		t.Errorf("expected %q; got %q", expected, info.String())
+	}
+	if expected := "level=warn msg=warn\nlevel=error msg=error\n"; errs.String() != expected {
+		t.Errorf("expected %q; got %q", expected, errs.String())
+	}
+
+	// no loggers configured must not panic
+	makeLogger(nil, nil, nil).Error("error")
+}
@@ -2,6 +2,7 @@ package lz4
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"sync"
 
@@ -12,6 +13,12 @@ import (
 var (
 	readerPool sync.Pool
 	writerPool sync.Pool
+
+	// bufferPool holds the buffers that messages are compressed to, the
+	// output is copied out of them.
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
 )
 
 func init() {
@@ -46,10 +53,12 @@ func (c CompressionCodec) Code() int8 {
 
 // Encode implements the kafka.CompressionCodec interface.
 func (c CompressionCodec) Encode(src []byte) ([]byte, error) {
-	buf := bytes.Buffer{}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
 	buf.Grow(len(src)) // guess a size to avoid repeat allocations.
 	writer := writerPool.Get().(*lz4.Writer)
-	writer.Reset(&buf)
+	writer.Reset(buf)
 
 	_, err := writer.Write(src)
 	if err != nil {
@@ -65,7 +74,7 @@ func (c CompressionCodec) Encode(src []byte) ([]byte, error) {
 
 	writerPool.Put(writer)
 
-	return buf.Bytes(), err
+	return append([]byte(nil), buf.Bytes()...), nil
 }
 
 // Decode implements the kafka.CompressionCodec interface.
@@ -79,3 +88,36 @@ func (c CompressionCodec) Decode(src []byte) ([]byte, error) {
 	}
 	return res, err
 }
+
+// NewReader implements the kafka.StreamingCompressionCodec interface.
+func (c CompressionCodec) NewReader(r io.Reader) io.ReadCloser {
+	reader := readerPool.Get().(*lz4.Reader)
+	reader.Reset(r)
+	return &decoder{reader: reader}
+}
+
+// decoder returns the lz4 reader to the pool when it is closed, unless
+// reading failed.
+type decoder struct {
+	reader *lz4.Reader
+	err    error
+}
+
+func (d *decoder) Read(b []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.reader.Read(b)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+func (d *decoder) Close() error {
+	if d.reader != nil && d.err == nil {
+		readerPool.Put(d.reader)
+	}
+	d.reader, d.err = nil, io.ErrClosedPipe
+	return nil
+}
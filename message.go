@@ -3,10 +3,19 @@ package kafka
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
 	"time"
 )
 
+// ErrCorruptBatch is returned when reading a record batch whose CRC32C checksum
+// does not match its content, which indicates that the batch was corrupted by
+// the broker, on its disks, or in transit.
+var ErrCorruptBatch = errors.New("kafka: the CRC of the record batch does not match its content")
+
 // Message is a data structure representing kafka messages.
 type Message struct {
 	// Topic is reads only and MUST NOT be set when writing messages
@@ -20,8 +29,40 @@ type Message struct {
 	Headers   []Header
 
 	// If not set at the creation, Time will be automatically set when
-	// writing the message.
+	// writing the message. Otherwise it is written as the timestamp of the
+	// record, with millisecond precision, which topics configured with
+	// message.timestamp.type=CreateTime keep as is.
 	Time time.Time
+
+	// DecodedKey and DecodedValue hold the key and value of the message decoded
+	// by the KeyDeserializer and ValueDeserializer of the reader that the
+	// message was read from, they are nil if none were configured.
+	//
+	// When writing, they are encoded into Key and Value by the KeySerializer
+	// and ValueSerializer of the writer.
+	DecodedKey   interface{}
+	DecodedValue interface{}
+}
+
+// IsTombstone returns true if the value of msg is null. On compacted topics
+// these messages are tombstones, which mark the deletion of their key: kafka
+// removes the previous messages with the same key during compaction, and the
+// tombstones themselves after the delete.retention.ms setting of the topic.
+//
+// A null value is distinct from an empty one, the messages written with a nil
+// Value are read back with a nil Value, while an empty value is read back as
+// an empty, non-nil slice.
+func (msg Message) IsTombstone() bool {
+	return msg.Value == nil
+}
+
+// size returns the sum of the lengths of the key, value and headers of msg.
+func (msg Message) size() int {
+	n := len(msg.Key) + len(msg.Value)
+	for _, h := range msg.Headers {
+		n += len(h.Key) + len(h.Value)
+	}
+	return n
 }
 
 func (msg Message) item() messageSetItem {
@@ -145,6 +186,45 @@ func (r *messageSetReader) remaining() (remain int) {
 	}
 }
 
+// skipped returns the offset following the last batch of records that the
+// reader skipped because they were not messages, or zero if there were none.
+// Only the v2 message format has such batches.
+func (r *messageSetReader) skipped() int64 {
+	if r.empty || r.version != 2 {
+		return 0
+	}
+	return r.v2.skipped
+}
+
+// producer returns the producer ID, producer epoch, and base sequence of the
+// current record batch, which are -1 when the batch was not written by an
+// idempotent producer. Only the v2 message format carries them.
+func (r *messageSetReader) producer() (id int64, epoch int16, sequence int32) {
+	if r.empty || r.version != 2 || r.v2.header.magic != 2 {
+		return -1, -1, -1
+	}
+	h := &r.v2.header
+	return h.producerId, h.producerEpoch, h.firstSequence
+}
+
+// attributes returns the attributes of the record batch of the message that was
+// read last. The v0 and v1 message formats have no record batches, the
+// attributes of the message itself are returned, they only carry the
+// compression codec and the timestamp type.
+func (r *messageSetReader) attributes() int16 {
+	if r.empty {
+		return 0
+	}
+	switch r.version {
+	case 1:
+		return int16(r.v1.msgAttributes)
+	case 2:
+		return r.v2.header.batchAttributes
+	default:
+		panic("Invalid messageSetReader - unknown message reader version")
+	}
+}
+
 func (r *messageSetReader) discard() (err error) {
 	if r.empty {
 		return nil
@@ -161,6 +241,10 @@ func (r *messageSetReader) discard() (err error) {
 
 type messageSetReaderV1 struct {
 	*readerStack
+
+	// attributes of the message that was read last, including the compression
+	// codec of the message that it was wrapped in.
+	msgAttributes int8
 }
 
 type readerStack struct {
@@ -168,6 +252,33 @@ type readerStack struct {
 	remain int
 	base   int64
 	parent *readerStack
+
+	// When the stack entry decompresses a record batch incrementally, the
+	// size of the decompressed data is unknown and remain is set to
+	// unknownRemain. compressed bounds the reads of the decompressor to the
+	// batch, and stream is closed when the entry is popped.
+	compressed *io.LimitedReader
+	stream     io.Closer
+
+	// attributes of the compressed message which held the entry, only set by
+	// the v0 and v1 message formats.
+	attributes int8
+}
+
+// unknownRemain is the size of readerStack entries decompressing data
+// incrementally.
+const unknownRemain = math.MaxInt32
+
+// pop removes the top entry of the stack and returns its parent. The bytes of
+// a compressed batch which were not consumed by the decompressor are skipped.
+func (s *readerStack) pop() (parent *readerStack, err error) {
+	if s.stream != nil {
+		err = s.stream.Close()
+		if _, discardErr := s.parent.reader.Discard(int(s.compressed.N)); err == nil {
+			err = discardErr
+		}
+	}
+	return s.parent, err
 }
 
 func newMessageSetReader(reader *bufio.Reader, remain int) (*messageSetReader, error) {
@@ -187,7 +298,7 @@ func newMessageSetReader(reader *bufio.Reader, remain int) (*messageSetReader, e
 	case 0, 1:
 		return &messageSetReader{
 			version: 1,
-			v1: messageSetReaderV1{&readerStack{
+			v1: messageSetReaderV1{readerStack: &readerStack{
 				reader: reader,
 				remain: remain,
 			}}}, nil
@@ -261,10 +372,11 @@ func (r *messageSetReaderV1) readMessage(min int64,
 			}
 
 			r.readerStack = &readerStack{
-				reader: bufio.NewReader(bytes.NewReader(decompressed)),
-				remain: len(decompressed),
-				base:   offset,
-				parent: r.readerStack,
+				reader:     bufio.NewReader(bytes.NewReader(decompressed)),
+				remain:     len(decompressed),
+				base:       offset,
+				parent:     r.readerStack,
+				attributes: attributes,
 			}
 			continue
 		}
@@ -286,6 +398,7 @@ func (r *messageSetReaderV1) readMessage(min int64,
 			continue
 		}
 
+		r.msgAttributes = attributes | r.readerStack.attributes
 		if r.remain, err = readBytesWith(r.reader, r.remain, key); err != nil {
 			return
 		}
@@ -382,15 +495,15 @@ func (h *messageSetHeaderV2) compression() int8 {
 }
 
 func (h *messageSetHeaderV2) timestampType() timestampType {
-	return timestampType(h.batchAttributes & 8)
+	return timestampType((h.batchAttributes >> 3) & 1)
 }
 
 func (h *messageSetHeaderV2) transactionType() transactionType {
-	return transactionType(h.batchAttributes & 16)
+	return transactionType((h.batchAttributes >> 4) & 1)
 }
 
 func (h *messageSetHeaderV2) controlType() controlType {
-	return controlType(h.batchAttributes & 32)
+	return controlType((h.batchAttributes >> 5) & 1)
 }
 
 type messageSetReaderV2 struct {
@@ -398,6 +511,18 @@ type messageSetReaderV2 struct {
 	messageCount int
 
 	header messageSetHeaderV2
+
+	// skipped is the offset following the last batch that was skipped because
+	// it held control records or no records at all.
+	skipped int64
+
+	// When checkCRC is true each record batch is read in memory to validate
+	// its CRC before its records are decoded, the buffers are reused across
+	// batches.
+	checkCRC    bool
+	batch       []byte
+	batchReader bytes.Reader
+	batchBuffer *bufio.Reader
 }
 
 func (r *messageSetReaderV2) readHeader() (err error) {
@@ -417,6 +542,11 @@ func (r *messageSetReaderV2) readHeader() (err error) {
 	if r.remain, err = readInt32(r.reader, r.remain, &h.crc); err != nil {
 		return
 	}
+	if r.checkCRC {
+		if err = r.readBatch(); err != nil {
+			return
+		}
+	}
 	if r.remain, err = readInt16(r.reader, r.remain, &h.batchAttributes); err != nil {
 		return
 	}
@@ -447,42 +577,139 @@ func (r *messageSetReaderV2) readHeader() (err error) {
 	return nil
 }
 
+// readBatch reads the rest of the record batch, which is covered by its CRC, in
+// memory and pushes it on the stack once it has been validated.
+func (r *messageSetReaderV2) readBatch() error {
+	// The CRC covers the batch from the attributes to the last record, the
+	// length includes the partition leader epoch, magic byte and CRC.
+	size := int(r.header.length) - 9
+	if size < 0 {
+		return ErrCorruptBatch
+	}
+	if size > r.remain {
+		// The batch was truncated by the broker, which happens with the last
+		// batch of fetch responses. It is fetched again by the next request.
+		return errShortRead
+	}
+
+	if cap(r.batch) < size {
+		r.batch = make([]byte, size)
+	}
+	r.batch = r.batch[:size]
+
+	n, err := io.ReadFull(r.reader, r.batch)
+	r.remain -= n
+	if err != nil {
+		return err
+	}
+
+	if crc32.Checksum(r.batch, crc32cTable) != uint32(r.header.crc) {
+		return ErrCorruptBatch
+	}
+
+	r.batchReader.Reset(r.batch)
+	if r.batchBuffer == nil {
+		r.batchBuffer = bufio.NewReader(&r.batchReader)
+	} else {
+		r.batchBuffer.Reset(&r.batchReader)
+	}
+
+	r.readerStack = &readerStack{
+		reader: r.batchBuffer,
+		remain: size,
+		base:   -1, // base is unused here
+		parent: r.readerStack,
+	}
+	return nil
+}
+
 func (r *messageSetReaderV2) readMessage(min int64,
 	key func(*bufio.Reader, int, int) (int, error),
 	val func(*bufio.Reader, int, int) (int, error),
 ) (offset int64, timestamp int64, headers []Header, err error) {
-
-	if r.messageCount == 0 {
-		if r.remain == 0 {
-			if r.parent != nil {
-				r.readerStack = r.parent
+	for {
+		if r.messageCount == 0 {
+			if err = r.nextBatch(); err != nil {
+				return
 			}
+			if r.messageCount == 0 || r.header.controlType() == controlMessage {
+				// Control records mark the commits and aborts of transactions,
+				// they are not messages and are never returned to the program
+				// even when reading uncommitted records. Empty batches are
+				// left by compaction.
+				r.skipped = r.header.firstOffset + int64(r.header.lastOffsetDelta) + 1
+			}
+			continue
 		}
-		if err = r.readHeader(); err != nil {
+
+		if r.header.controlType() != controlMessage {
+			return r.readRecord(key, val)
+		}
+
+		if _, _, _, err = r.readRecord(discardRecordBytes, discardRecordBytes); err != nil {
 			return
 		}
-		code := r.header.compression()
-		var decompressed []byte
-		if code != 0 {
-			var codec CompressionCodec
-			if codec, err = resolveCodec(code); err != nil {
-				return
-			}
-			batchRemain := int(r.header.length - 49)
-			if batchRemain > r.remain {
-				err = errShortRead
-				return
+	}
+}
+
+// discardRecordBytes discards the key or value of a record, which may be null.
+func discardRecordBytes(r *bufio.Reader, sz int, n int) (int, error) {
+	if n < 0 {
+		return sz, nil
+	}
+	return discardN(r, sz, n)
+}
+
+// nextBatch reads the header of the next record batch, once all the records of
+// the previous one were read.
+func (r *messageSetReaderV2) nextBatch() (err error) {
+	// all the records of a compressed or validated batch were read, resume
+	// reading from the fetch response.
+	for r.parent != nil {
+		if r.readerStack, err = r.readerStack.pop(); err != nil {
+			return
+		}
+	}
+	if err = r.readHeader(); err != nil {
+		return
+	}
+	code := r.header.compression()
+	if code != 0 {
+		var codec CompressionCodec
+		if codec, err = resolveCodec(code); err != nil {
+			return
+		}
+		batchRemain := int(r.header.length - 49)
+		if batchRemain > r.remain {
+			err = errShortRead
+			return
+		}
+
+		if streaming, ok := codec.(StreamingCompressionCodec); ok {
+			// the records are decoded while the batch is being
+			// decompressed, the batch is consumed from the parent
+			// reader by the decompressor.
+			r.remain -= batchRemain
+			compressed := &io.LimitedReader{R: r.reader, N: int64(batchRemain)}
+			stream := streaming.NewReader(compressed)
+
+			r.readerStack = &readerStack{
+				reader:     bufio.NewReader(stream),
+				remain:     unknownRemain,
+				base:       -1, // base is unused here
+				parent:     r.readerStack,
+				compressed: compressed,
+				stream:     stream,
 			}
+		} else {
 			var b []byte
-			if b, err = r.reader.Peek(batchRemain); err != nil {
+			if b, r.remain, err = readNewBytes(r.reader, r.remain, batchRemain); err != nil {
 				return
 			}
+			var decompressed []byte
 			if decompressed, err = codec.Decode(b); err != nil {
 				return
 			}
-			if r.remain, err = discardN(r.reader, r.remain, batchRemain); err != nil {
-				return
-			}
 
 			r.readerStack = &readerStack{
 				reader: bufio.NewReader(bytes.NewReader(decompressed)),
@@ -492,7 +719,14 @@ func (r *messageSetReaderV2) readMessage(min int64,
 			}
 		}
 	}
+	return nil
+}
 
+// readRecord reads the next record of the current batch.
+func (r *messageSetReaderV2) readRecord(
+	key func(*bufio.Reader, int, int) (int, error),
+	val func(*bufio.Reader, int, int) (int, error),
+) (offset int64, timestamp int64, headers []Header, err error) {
 	var length int64
 	if r.remain, err = readVarInt(r.reader, r.remain, &length); err != nil {
 		return
@@ -562,10 +796,22 @@ func (r *messageSetReaderV2) readMessageHeader(header *Header) (err error) {
 }
 
 func (r *messageSetReaderV2) remaining() (remain int) {
-	return r.remain
+	for s := r.readerStack; s != nil; s = s.parent {
+		if s.stream == nil {
+			remain += s.remain
+		}
+	}
+	return
 }
 
 func (r *messageSetReaderV2) discard() (err error) {
+	// rewind up to the top-most reader b/c it's the only one that's doing
+	// actual i/o, the others are decompressing record batches.
+	for r.parent != nil {
+		if r.readerStack, err = r.readerStack.pop(); err != nil {
+			return
+		}
+	}
 	r.remain, err = discardN(r.reader, r.remain, r.remain)
 	return
 }
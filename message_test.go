@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testGzipCodec is a gzip codec registered under a code unused by the package
+// to test the compressed read path without importing the gzip subpackage.
+type testGzipCodec struct {
+	code int8
+}
+
+func (c testGzipCodec) Code() int8 { return c.code }
+
+func (c testGzipCodec) Encode(src []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c testGzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// testStreamingGzipCodec also implements StreamingCompressionCodec.
+type testStreamingGzipCodec struct {
+	testGzipCodec
+}
+
+func (c testStreamingGzipCodec) NewReader(r io.Reader) io.ReadCloser {
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return ioutil.NopCloser(&errorReader{err: err})
+	}
+	return z
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+func registerTestCodec(codec CompressionCodec) func() {
+	RegisterCompressionCodec(func() CompressionCodec { return codec })
+	return func() {
+		codecsMutex.Lock()
+		delete(codecs, codec.Code())
+		codecsMutex.Unlock()
+	}
+}
+
+func makeCompressedRecordBatches(codec CompressionCodec, batches int, msgs ...Message) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+
+	for i := 0; i < batches; i++ {
+		records := &bytes.Buffer{}
+		rw := bufio.NewWriter(records)
+		for i, msg := range msgs {
+			writeRecord(rw, 0, msgs[0].Time, int64(i), msg)
+		}
+		rw.Flush()
+
+		compressed, err := codec.Encode(records.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		size := recordBatchHeaderSize() + int32(len(compressed))
+		if err := writeRecordBatch(w, nil, int16(codec.Code()), size, func(w *bufio.Writer) {
+			w.Write(compressed)
+		}, msgs...); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), nil
+}
+
+func makeRandomMessages(n int, size int) []Message {
+	prng := rand.New(rand.NewSource(0))
+	now := time.Now()
+	msgs := make([]Message, n)
+	for i := range msgs {
+		value := make([]byte, size)
+		prng.Read(value)
+		msgs[i] = Message{Value: value, Time: now}
+	}
+	return msgs
+}
+
+func TestMessageSetReaderCompressedRecordBatches(t *testing.T) {
+	// the batches are larger than the buffer of the connection reader, which
+	// used to be required to hold the whole compressed batch.
+	msgs := makeRandomMessages(500, 100)
+
+	for _, codec := range []CompressionCodec{
+		testGzipCodec{code: 5},
+		testStreamingGzipCodec{testGzipCodec{code: 6}},
+	} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			defer registerTestCodec(codec)()
+
+			const batches = 2
+			b, err := makeCompressedRecordBatches(codec, batches, msgs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < batches*len(msgs); i++ {
+				var value []byte
+				offset, _, _, err := r.readMessage(0, discardRecordBytes,
+					func(r *bufio.Reader, sz int, n int) (remain int, err error) {
+						value, remain, err = readNewBytes(r, sz, n)
+						return
+					},
+				)
+				if err != nil {
+					t.Fatalf("error reading message %d: %v", i, err)
+				}
+				if expected := int64(i % len(msgs)); offset != expected {
+					t.Fatalf("expected offset %d; got %d", expected, offset)
+				}
+				if !bytes.Equal(value, msgs[i%len(msgs)].Value) {
+					t.Fatalf("value of message %d mismatch", i)
+				}
+			}
+
+			if _, _, _, err := r.readMessage(0, discardRecordBytes, discardRecordBytes); err != errShortRead {
+				t.Errorf("expected errShortRead at the end of the message set; got %v", err)
+			}
+			if err := r.discard(); err != nil {
+				t.Error(err)
+			}
+			if remain := r.remaining(); remain != 0 {
+				t.Errorf("expected no bytes to remain; got %d", remain)
+			}
+		})
+	}
+}
+
+func TestMessageSetReaderDiscardCompressedRecordBatch(t *testing.T) {
+	codec := testStreamingGzipCodec{testGzipCodec{code: 6}}
+	defer registerTestCodec(codec)()
+
+	b, err := makeCompressedRecordBatches(codec, 1, makeRandomMessages(100, 100)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := r.readMessage(0, discardRecordBytes, discardRecordBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.discard(); err != nil {
+		t.Fatal(err)
+	}
+	if remain := r.remaining(); remain != 0 {
+		t.Errorf("expected no bytes to remain; got %d", remain)
+	}
+}
+
+func TestMessageSetReaderCheckCRC(t *testing.T) {
+	codec := testStreamingGzipCodec{testGzipCodec{code: 6}}
+	defer registerTestCodec(codec)()
+
+	msgs := makeRandomMessages(100, 100)
+	b, err := makeCompressedRecordBatches(codec, 2, msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(b []byte) (n int, err error) {
+		r, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+		if err != nil {
+			return 0, err
+		}
+		r.v2.checkCRC = true
+
+		for {
+			if _, _, _, err = r.readMessage(0, discardRecordBytes, discardRecordBytes); err != nil {
+				if err == errShortRead {
+					err = nil
+				}
+				return n, err
+			}
+			n++
+		}
+	}
+
+	if n, err := read(b); err != nil || n != 2*len(msgs) {
+		t.Fatalf("expected to read %d messages; got %d (err = %v)", 2*len(msgs), n, err)
+	}
+
+	// corrupting the last byte of the second batch is detected before any of
+	// its messages are returned.
+	b[len(b)-1]++
+	if n, err := read(b); err != ErrCorruptBatch || n != len(msgs) {
+		t.Errorf("expected %v after reading %d messages; got %v after %d", ErrCorruptBatch, len(msgs), err, n)
+	}
+}
+
+func BenchmarkMessageSetReaderCompressedRecordBatch(b *testing.B) {
+	msgs := makeRandomMessages(10000, 1000)
+
+	for _, codec := range []CompressionCodec{
+		testGzipCodec{code: 5},
+		testStreamingGzipCodec{testGzipCodec{code: 6}},
+	} {
+		b.Run(fmt.Sprintf("%T", codec), func(b *testing.B) {
+			defer registerTestCodec(codec)()
+
+			batch, err := makeCompressedRecordBatches(codec, 1, msgs...)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(batch)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				r, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(batch)), len(batch))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for range msgs {
+					if _, _, _, err := r.readMessage(0, discardRecordBytes, discardRecordBytes); err != nil {
+						b.Fatal(err)
+					}
+				}
+				r.discard()
+			}
+		})
+	}
+}
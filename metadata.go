@@ -87,3 +87,120 @@ func (p partitionMetadataV1) writeTo(w *bufio.Writer) {
 	writeInt32Array(w, p.Replicas)
 	writeInt32Array(w, p.Isr)
 }
+
+// topicMetadataRequestV7 is the version of the metadata request sent to
+// brokers which report the leader epoch of partitions (kafka 2.1 and above).
+type topicMetadataRequestV7 struct {
+	Topics                 []string
+	AllowAutoTopicCreation bool
+}
+
+func (r topicMetadataRequestV7) size() int32 {
+	return sizeofStringArray(r.Topics) + sizeofBool(r.AllowAutoTopicCreation)
+}
+
+func (r topicMetadataRequestV7) writeTo(w *bufio.Writer) {
+	writeStringArray(w, r.Topics)
+	writeBool(w, r.AllowAutoTopicCreation)
+}
+
+type metadataResponseV7 struct {
+	ThrottleTimeMS int32
+	Brokers        []brokerMetadataV1
+	ClusterID      string
+	ControllerID   int32
+	Topics         []topicMetadataV7
+}
+
+func (r metadataResponseV7) size() int32 {
+	n1 := sizeofArray(len(r.Brokers), func(i int) int32 { return r.Brokers[i].size() })
+	n2 := sizeofArray(len(r.Topics), func(i int) int32 { return r.Topics[i].size() })
+	return 4 + n1 + sizeofString(r.ClusterID) + 4 + n2
+}
+
+func (r metadataResponseV7) writeTo(w *bufio.Writer) {
+	writeInt32(w, r.ThrottleTimeMS)
+	writeArray(w, len(r.Brokers), func(i int) { r.Brokers[i].writeTo(w) })
+	writeString(w, r.ClusterID)
+	writeInt32(w, r.ControllerID)
+	writeArray(w, len(r.Topics), func(i int) { r.Topics[i].writeTo(w) })
+}
+
+type topicMetadataV7 struct {
+	TopicErrorCode int16
+	TopicName      string
+	Internal       bool
+	Partitions     []partitionMetadataV7
+}
+
+func (t topicMetadataV7) size() int32 {
+	return 2 + 1 +
+		sizeofString(t.TopicName) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t topicMetadataV7) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.TopicErrorCode)
+	writeString(w, t.TopicName)
+	writeBool(w, t.Internal)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+type partitionMetadataV7 struct {
+	PartitionErrorCode int16
+	PartitionID        int32
+	Leader             int32
+	LeaderEpoch        int32
+	Replicas           []int32
+	Isr                []int32
+	OfflineReplicas    []int32
+}
+
+func (p partitionMetadataV7) size() int32 {
+	return 2 + 4 + 4 + 4 +
+		sizeofInt32Array(p.Replicas) +
+		sizeofInt32Array(p.Isr) +
+		sizeofInt32Array(p.OfflineReplicas)
+}
+
+func (p partitionMetadataV7) writeTo(w *bufio.Writer) {
+	writeInt16(w, p.PartitionErrorCode)
+	writeInt32(w, p.PartitionID)
+	writeInt32(w, p.Leader)
+	writeInt32(w, p.LeaderEpoch)
+	writeInt32Array(w, p.Replicas)
+	writeInt32Array(w, p.Isr)
+	writeInt32Array(w, p.OfflineReplicas)
+}
+
+// toV7 converts the response to the form of the version reporting the leader
+// epochs of partitions, which are set to -1 since they are unknown.
+func (r metadataResponseV1) toV7() metadataResponseV7 {
+	res := metadataResponseV7{
+		Brokers:      r.Brokers,
+		ControllerID: r.ControllerID,
+		Topics:       make([]topicMetadataV7, len(r.Topics)),
+	}
+
+	for i, t := range r.Topics {
+		partitions := make([]partitionMetadataV7, len(t.Partitions))
+		for j, p := range t.Partitions {
+			partitions[j] = partitionMetadataV7{
+				PartitionErrorCode: p.PartitionErrorCode,
+				PartitionID:        p.PartitionID,
+				Leader:             p.Leader,
+				LeaderEpoch:        -1,
+				Replicas:           p.Replicas,
+				Isr:                p.Isr,
+			}
+		}
+		res.Topics[i] = topicMetadataV7{
+			TopicErrorCode: t.TopicErrorCode,
+			TopicName:      t.TopicName,
+			Internal:       t.Internal,
+			Partitions:     partitions,
+		}
+	}
+
+	return res
+}
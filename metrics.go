@@ -0,0 +1,69 @@
+package kafka
+
+import "time"
+
+// MetricsRegistry is the interface implemented by registries that readers and
+// writers report their statistics to as soon as they are observed, see the
+// MetricsRegistry fields of ReaderConfig and WriterConfig.
+//
+// The metrics are named after the metric tags of the ReaderStats and
+// WriterStats fields, for example "kafka.writer.message.count". Statistics
+// derived from the configuration and the queue length are only available by
+// calling Stats.
+//
+// The methods may be called concurrently from multiple goroutines, and are
+// called on the hot path of readers and writers so they must not block.
+//
+// The gometrics subpackage adapts registries of the
+// github.com/rcrowley/go-metrics package.
+type MetricsRegistry interface {
+	// Counter increments the counter registered under name by delta.
+	Counter(name string, delta int64)
+
+	// Gauge sets the value of the gauge registered under name.
+	Gauge(name string, value int64)
+
+	// Histogram records a value in the histogram registered under name.
+	Histogram(name string, value int64)
+
+	// Timer records a duration in the timer registered under name.
+	Timer(name string, duration time.Duration)
+}
+
+// metric receives the values observed by a statistic to forward them to a
+// MetricsRegistry.
+//
+// Being an interface, it spans two words which keeps the size of the counter
+// and gauge types a multiple of 8 bytes on 32-bit platforms, and the values of
+// the statistics that follow them 64-bit aligned.
+type metric interface {
+	observe(v int64)
+}
+
+type counterMetric struct {
+	registry MetricsRegistry
+	name     string
+}
+
+func (m counterMetric) observe(v int64) { m.registry.Counter(m.name, v) }
+
+type gaugeMetric struct {
+	registry MetricsRegistry
+	name     string
+}
+
+func (m gaugeMetric) observe(v int64) { m.registry.Gauge(m.name, v) }
+
+type histogramMetric struct {
+	registry MetricsRegistry
+	name     string
+}
+
+func (m histogramMetric) observe(v int64) { m.registry.Histogram(m.name, v) }
+
+type timerMetric struct {
+	registry MetricsRegistry
+	name     string
+}
+
+func (m timerMetric) observe(v int64) { m.registry.Timer(m.name, time.Duration(v)) }
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetricsRegistry struct {
+	mutex      sync.Mutex
+	counters   map[string]int64
+	gauges     map[string]int64
+	histograms map[string][]int64
+	timers     map[string][]time.Duration
+}
+
+func newTestMetricsRegistry() *testMetricsRegistry {
+	return &testMetricsRegistry{
+		counters:   map[string]int64{},
+		gauges:     map[string]int64{},
+		histograms: map[string][]int64{},
+		timers:     map[string][]time.Duration{},
+	}
+}
+
+func (r *testMetricsRegistry) Counter(name string, delta int64) {
+	r.mutex.Lock()
+	r.counters[name] += delta
+	r.mutex.Unlock()
+}
+
+func (r *testMetricsRegistry) Gauge(name string, value int64) {
+	r.mutex.Lock()
+	r.gauges[name] = value
+	r.mutex.Unlock()
+}
+
+func (r *testMetricsRegistry) Histogram(name string, value int64) {
+	r.mutex.Lock()
+	r.histograms[name] = append(r.histograms[name], value)
+	r.mutex.Unlock()
+}
+
+func (r *testMetricsRegistry) Timer(name string, duration time.Duration) {
+	r.mutex.Lock()
+	r.timers[name] = append(r.timers[name], duration)
+	r.mutex.Unlock()
+}
+
+func TestWriterStatsRegister(t *testing.T) {
+	registry := newTestMetricsRegistry()
+
+	stats := &writerStats{dialTime: makeSummary(), batchSize: makeSummary()}
+	stats.register(registry)
+
+	stats.messages.observe(2)
+	stats.messages.observe(3)
+	stats.dialTime.observeDuration(time.Second)
+	stats.batchSize.observe(5)
+
+	if expected := map[string]int64{"kafka.writer.message.count": 5}; !reflect.DeepEqual(expected, registry.counters) {
+		t.Errorf("expected counters %v; got %v", expected, registry.counters)
+	}
+	if expected := map[string][]time.Duration{"kafka.writer.dial.seconds": {time.Second}}; !reflect.DeepEqual(expected, registry.timers) {
+		t.Errorf("expected timers %v; got %v", expected, registry.timers)
+	}
+	if expected := map[string][]int64{"kafka.writer.batch.size": {5}}; !reflect.DeepEqual(expected, registry.histograms) {
+		t.Errorf("expected histograms %v; got %v", expected, registry.histograms)
+	}
+
+	// snapshots are not affected by the registry, and don't reset it
+	if n := stats.messages.snapshot(); n != 5 {
+		t.Errorf("expected a snapshot of 5 messages; got %d", n)
+	}
+	stats.messages.observe(1)
+	if n := registry.counters["kafka.writer.message.count"]; n != 6 {
+		t.Errorf("expected the registry to count 6 messages; got %d", n)
+	}
+}
+
+func TestReaderStatsRegister(t *testing.T) {
+	registry := newTestMetricsRegistry()
+
+	stats := &readerStats{}
+	stats.register(registry)
+
+	stats.lag.observe(10)
+	stats.lag.observe(4)
+	stats.fetches.observe(1)
+
+	if expected := map[string]int64{"kafka.reader.lag": 4}; !reflect.DeepEqual(expected, registry.gauges) {
+		t.Errorf("expected gauges %v; got %v", expected, registry.gauges)
+	}
+	if expected := map[string]int64{"kafka.reader.fetch.count": 1}; !reflect.DeepEqual(expected, registry.counters) {
+		t.Errorf("expected counters %v; got %v", expected, registry.counters)
+	}
+	if lag := stats.lag.snapshot(); lag != 4 {
+		t.Errorf("expected a snapshot of the lag to be 4; got %d", lag)
+	}
+}
+
+func TestMetricsNoGoMetricsDependency(t *testing.T) {
+	// The go-metrics adapter lives in the gometrics subpackage so programs
+	// which don't use it don't depend on go-metrics.
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range pkgs {
+		for name, file := range pkg.Files {
+			for _, spec := range file.Imports {
+				if path, _ := strconv.Unquote(spec.Path.Value); strings.HasPrefix(path, "github.com/rcrowley/go-metrics") {
+					t.Errorf("%s imports %s", name, path)
+				}
+			}
+		}
+	}
+}
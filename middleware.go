@@ -0,0 +1,153 @@
+package kafka
+
+import "fmt"
+
+// MiddlewareError is returned by Reader.FetchMessage and Reader.ReadMessage
+// along with a message that one of the middleware of the reader failed to
+// transform. Like with a DeserializationError, the reader moves on to the next
+// message and the program decides whether to skip the message, for example by
+// writing it to a dead-letter topic, or to stop consuming.
+//
+// It is also reported by Writer.WriteMessages, in a WriteErrors, when the
+// middleware of the writer failed to transform a message, which is not written
+// while the other messages passed to WriteMessages are.
+type MiddlewareError struct {
+	// Topic, Partition and Offset identify the message. Only the topic is
+	// known for messages passed to a writer, the partition and offset are -1.
+	Topic     string
+	Partition int
+	Offset    int64
+
+	// Index is the position in the middleware chain of the function which
+	// failed.
+	Index int
+
+	// Err is the error returned by the middleware.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e *MiddlewareError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("middleware %d failed to transform a message written to %s: %v", e.Index, e.Topic, e.Err)
+	}
+	return fmt.Sprintf("middleware %d failed to transform the message at offset %d of %s/%d: %v", e.Index, e.Offset, e.Topic, e.Partition, e.Err)
+}
+
+// transform applies the middleware chain configured on the reader to msg. When
+// a middleware fails, msg is left as it was fetched.
+func (r *Reader) transform(msg *Message) error {
+	m := *msg
+
+	for i, middleware := range r.config.Middleware {
+		var err error
+		if m, err = middleware(m); err != nil {
+			return &MiddlewareError{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Index:     i,
+				Err:       err,
+			}
+		}
+	}
+
+	*msg = m
+	return nil
+}
+
+// WriteErrors is returned by Writer.WriteMessages when some of the messages
+// could not be encoded by the serializers or transformed by the middleware of
+// the writer. It holds the error of each message passed to WriteMessages, at
+// the same index, which is nil for the messages which were written. The errors
+// are *SerializationError or *MiddlewareError values.
+type WriteErrors []error
+
+// Count returns the number of messages which failed.
+func (err WriteErrors) Count() int {
+	n := 0
+	for _, e := range err {
+		if e != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Error satisfies the error interface.
+func (err WriteErrors) Error() string {
+	return fmt.Sprintf("kafka write errors (%d/%d)", err.Count(), len(err))
+}
+
+// prepare applies the serializers and the middleware chain configured on the
+// writer to msgs, in the configured order. The messages which were prepared are
+// returned in a new slice, along with a WriteErrors holding the error of each
+// message when some of them failed to be.
+func (w *Writer) prepare(msgs []Message) ([]Message, error) {
+	serialize := w.config.KeySerializer != nil || w.config.ValueSerializer != nil
+	if !serialize && len(w.config.Middleware) == 0 {
+		return msgs, nil
+	}
+
+	var errs WriteErrors
+	prepared := make([]Message, 0, len(msgs))
+
+	for i, msg := range msgs {
+		var err error
+
+		if w.config.MiddlewareBeforeSerialization {
+			if msg, err = w.transform(msg); err == nil && serialize {
+				msg, err = w.serialize(msg, i)
+			}
+		} else {
+			if serialize {
+				msg, err = w.serialize(msg, i)
+			}
+			if err == nil {
+				msg, err = w.transform(msg)
+			}
+		}
+
+		if err != nil {
+			if errs == nil {
+				errs = make(WriteErrors, len(msgs))
+			}
+			errs[i] = err
+			continue
+		}
+
+		prepared = append(prepared, msg)
+	}
+
+	if errs != nil {
+		return prepared, errs
+	}
+	return prepared, nil
+}
+
+// transform applies the middleware chain configured on the writer to msg.
+func (w *Writer) transform(msg Message) (Message, error) {
+	var err error
+	topic := w.topicOf(msg)
+
+	for i, middleware := range w.config.Middleware {
+		if msg, err = middleware(msg); err != nil {
+			err = &MiddlewareError{Topic: topic, Partition: -1, Offset: -1, Index: i, Err: err}
+			w.logger().Error("failed to transform message", "topic", topic, "error", err)
+			w.stats.errors.observe(1)
+			break
+		}
+	}
+
+	return msg, err
+}
+
+// isMessageError returns true if err is the error of a single message returned
+// by the reader, which moves on to the next message.
+func isMessageError(err error) bool {
+	switch err.(type) {
+	case *DeserializationError, *MiddlewareError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReaderMiddleware(t *testing.T) {
+	errRedacted := errors.New("redacted")
+
+	r := &Reader{
+		config: ReaderConfig{
+			Middleware: []func(Message) (Message, error){
+				func(m Message) (Message, error) {
+					m.Value = bytes.ToUpper(m.Value)
+					return m, nil
+				},
+				func(m Message) (Message, error) {
+					if bytes.Equal(m.Value, []byte("SECRET")) {
+						return m, errRedacted
+					}
+					m.Value = append(m.Value, '!')
+					return m, nil
+				},
+			},
+			ValueDeserializer: DeserializerFunc(func(topic string, data []byte) (interface{}, error) {
+				return string(data), nil
+			}),
+		},
+		msgs:    make(chan readerMessage, 3),
+		version: 1,
+	}
+
+	for i, value := range []string{"a", "secret", "c"} {
+		r.msgs <- readerMessage{
+			version: 1,
+			message: Message{Topic: "A", Partition: 2, Offset: int64(i), Value: []byte(value)},
+		}
+	}
+
+	ctx := context.Background()
+
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Value) != "A!" || m.DecodedValue != "A!" {
+		t.Errorf("expected the middleware to be applied in order before the deserializers; got %q, %v", m.Value, m.DecodedValue)
+	}
+
+	m, err = r.ReadMessage(ctx)
+	merr, ok := err.(*MiddlewareError)
+	if !ok {
+		t.Fatalf("expected a *MiddlewareError; got %v", err)
+	}
+	if merr.Err != errRedacted || merr.Index != 1 || merr.Topic != "A" || merr.Partition != 2 || merr.Offset != 1 {
+		t.Errorf("unexpected middleware error: %+v", merr)
+	}
+	if string(m.Value) != "secret" || m.DecodedValue != nil {
+		t.Errorf("expected the message to be returned as fetched with the error; got %+v", m)
+	}
+
+	m, err = r.FetchMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset != 2 || string(m.Value) != "C!" {
+		t.Errorf("expected the reader to move past the message which failed to be transformed; got %+v", m)
+	}
+}
+
+func TestWriterMiddleware(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	newWriter := func(beforeSerialization bool) *Writer {
+		return NewWriter(WriterConfig{
+			Brokers: []string{"localhost:9092"},
+			Topic:   "A",
+			ValueSerializer: SerializerFunc(func(topic string, value interface{}) ([]byte, error) {
+				return []byte(value.(string)), nil
+			}),
+			Middleware: []func(Message) (Message, error){
+				func(m Message) (Message, error) {
+					m.Headers = append(m.Headers, Header{Key: "trace", Value: []byte("1")})
+					return m, nil
+				},
+				func(m Message) (Message, error) {
+					if s, ok := m.DecodedValue.(string); ok && s == "oops" {
+						return m, errRejected
+					}
+					if m.Value != nil {
+						m.Value = bytes.ToUpper(m.Value)
+					}
+					return m, nil
+				},
+			},
+			MiddlewareBeforeSerialization: beforeSerialization,
+		})
+	}
+
+	w := newWriter(false)
+	defer w.Close()
+
+	msgs, err := w.prepare([]Message{
+		{DecodedValue: "a"},
+		{DecodedValue: "oops"},
+		{Value: []byte("b")},
+	})
+
+	errs, ok := err.(WriteErrors)
+	if !ok || len(errs) != 3 || errs.Count() != 1 {
+		t.Fatalf("expected WriteErrors for the second message; got %v", err)
+	}
+	merr, ok := errs[1].(*MiddlewareError)
+	if !ok {
+		t.Fatalf("expected a *MiddlewareError; got %v", errs[1])
+	}
+	if merr.Err != errRejected || merr.Index != 1 || merr.Topic != "A" || merr.Offset != -1 {
+		t.Errorf("unexpected middleware error: %+v", merr)
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("expected the message which failed to be transformed to be dropped; got %d messages", len(msgs))
+	}
+	for i, value := range []string{"A", "B"} {
+		if string(msgs[i].Value) != value || len(msgs[i].Headers) != 1 {
+			t.Errorf("expected the middleware to be applied in order after the serializers; got %q with headers %v", msgs[i].Value, msgs[i].Headers)
+		}
+	}
+
+	before := newWriter(true)
+	defer before.Close()
+
+	// Before the serializers, the middleware doesn't see the encoded value.
+	msgs, err = before.prepare([]Message{{DecodedValue: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msgs[0].Value) != "a" || len(msgs[0].Headers) != 1 {
+		t.Errorf("expected the middleware to be applied before the serializers; got %q with headers %v", msgs[0].Value, msgs[0].Headers)
+	}
+
+	if err := w.WriteMessages(context.Background(), Message{DecodedValue: "oops"}); err == nil {
+		t.Error("expected an error writing a message which fails to be transformed")
+	}
+}
@@ -0,0 +1,312 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSkipMessage may be returned by the Transform function of a Mirror to drop
+// a message instead of copying it.
+var ErrSkipMessage = errors.New("kafka: skip message")
+
+// MirrorConfig is a configuration object used to create new instances of
+// Mirror.
+type MirrorConfig struct {
+	// Source configures the reader consuming the messages to copy.
+	//
+	// When GroupID is set, the offsets of the messages are committed once they
+	// were written to the destination topic, which lets the mirror resume
+	// where it stopped after a restart, and the partitions of the topic may be
+	// shared by multiple mirrors.
+	Source ReaderConfig
+
+	// Destination configures the writer producing the copies, its Brokers and
+	// Dialer may point to a different cluster than the ones of Source.
+	//
+	// The writer must not be asynchronous, since the mirror waits for the
+	// messages to be written before reading the next ones from the partition.
+	// Unless they are set, BatchTimeout defaults to 10ms for the same reason,
+	// and messages are distributed using the Hash balancer so messages with the
+	// same key stay ordered.
+	Destination WriterConfig
+
+	// Transform is an optional function called with each message before it is
+	// copied, it returns the message to write, or ErrSkipMessage to drop it.
+	// Any other error stops the mirror.
+	//
+	// The message passed to the function is the copy which is about to be
+	// written, with its key, value, headers and time. It is called from
+	// multiple goroutines when Parallelism is greater than one.
+	Transform func(Message) (Message, error)
+
+	// Parallelism is the number of goroutines copying messages. The messages
+	// of a partition are always copied by the same goroutine so they stay
+	// ordered, the partitions are spread across the goroutines.
+	//
+	// Default: 1
+	Parallelism int
+
+	// PreservePartitions writes the copies to the partition that the messages
+	// were read from, instead of using the balancer of the writer, as long as
+	// the destination topic has this partition.
+	PreservePartitions bool
+}
+
+// MirrorStats is a data structure returned by a call to Mirror.Stats that
+// exposes details about the behavior of the mirror.
+type MirrorStats struct {
+	// Copied counts the messages which were written to the destination topic
+	// since the previous call to Stats.
+	Copied int64 `metric:"kafka.mirror.copied.count" type:"counter"`
+
+	// Skipped counts the messages which were dropped by the Transform function
+	// since the previous call to Stats.
+	Skipped int64 `metric:"kafka.mirror.skipped.count" type:"counter"`
+
+	// Lag is the number of messages left to copy from the source partition,
+	// see ReaderStats.Lag.
+	Lag int64 `metric:"kafka.mirror.lag" type:"gauge"`
+
+	Reader ReaderStats
+	Writer WriterStats
+}
+
+type mirrorStats struct {
+	copied  counter
+	skipped counter
+}
+
+// Mirror copies the messages of a topic to another topic, which may live in a
+// different kafka cluster.
+//
+// Copies keep the key, value, headers and time of the original messages, note
+// that headers are only supported by kafka 0.11 and above. The mirror provides
+// at-least-once delivery: messages may be copied more than once when the mirror
+// is restarted or the partitions of its group are rebalanced.
+type Mirror struct {
+	config MirrorConfig
+	reader *Reader
+	writer *Writer
+	stats  mirrorStats
+}
+
+// NewMirror creates and returns a new Mirror configured with config.
+func NewMirror(config MirrorConfig) *Mirror {
+	if config.Destination.Async {
+		panic("the destination writer of a mirror cannot be asynchronous")
+	}
+
+	if config.Parallelism < 0 {
+		panic(fmt.Sprintf("Parallelism out of bounds: %d", config.Parallelism))
+	}
+
+	if config.Parallelism == 0 {
+		config.Parallelism = 1
+	}
+
+	if config.Destination.BatchTimeout == 0 {
+		config.Destination.BatchTimeout = 10 * time.Millisecond
+	}
+
+	if config.Destination.Balancer == nil {
+		config.Destination.Balancer = &Hash{}
+	}
+
+	return &Mirror{
+		config: config,
+		reader: NewReader(config.Source),
+		writer: NewWriter(config.Destination),
+	}
+}
+
+// Run copies messages until ctx is canceled or an error occurs, it returns the
+// error that stopped the mirror. Messages which were read but not written yet
+// when the mirror stops are not committed, they are copied again by the next
+// run when the source reader has a GroupID.
+//
+// Run must not be called multiple times concurrently.
+func (m *Mirror) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var err error
+
+	stop := func(e error) {
+		once.Do(func() {
+			err = e
+			cancel()
+		})
+	}
+
+	queues := make([]chan Message, m.config.Parallelism)
+	for i := range queues {
+		queues[i] = make(chan Message, m.batchSize())
+		wg.Add(1)
+		go func(queue <-chan Message) {
+			defer wg.Done()
+			stop(m.copyLoop(ctx, queue))
+		}(queues[i])
+	}
+
+	for ctx.Err() == nil {
+		msg, e := m.reader.FetchMessage(ctx)
+		if e != nil {
+			stop(e)
+			break
+		}
+
+		select {
+		case queues[msg.Partition%len(queues)] <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	stop(ctx.Err())
+	wg.Wait()
+	return err
+}
+
+// copyLoop copies the messages received from queue, writing them in batches of
+// the messages which are available.
+func (m *Mirror) copyLoop(ctx context.Context, queue <-chan Message) error {
+	batch := make([]Message, 0, m.batchSize())
+
+	for {
+		select {
+		case msg := <-queue:
+			batch = append(batch[:0], msg)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	fill:
+		for len(batch) < cap(batch) {
+			select {
+			case msg := <-queue:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		if err := m.copy(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// copy writes the copies of msgs to the destination topic, then commits the
+// offsets of msgs if the source reader is part of a group.
+func (m *Mirror) copy(ctx context.Context, msgs []Message) error {
+	// The copies are grouped by source partition when partitions are
+	// preserved, so each group can be written with its own balancer.
+	copies := make(map[int][]Message)
+
+	for _, msg := range msgs {
+		c, err := m.transform(makeMirrorMessage(msg))
+		switch err {
+		case nil:
+		case ErrSkipMessage:
+			m.stats.skipped.observe(1)
+			continue
+		default:
+			return err
+		}
+
+		partition := -1
+		if m.config.PreservePartitions {
+			partition = msg.Partition
+		}
+		copies[partition] = append(copies[partition], c)
+	}
+
+	for partition, msgs := range copies {
+		var opts WriteOptions
+		if partition >= 0 {
+			opts.Balancer = &mirrorBalancer{
+				partition: partition,
+				fallback:  m.config.Destination.Balancer,
+			}
+		}
+		if err := m.writer.WriteMessagesWith(ctx, opts, msgs...); err != nil {
+			return err
+		}
+		m.stats.copied.observe(int64(len(msgs)))
+	}
+
+	if m.config.Source.GroupID != "" {
+		return m.reader.CommitMessages(ctx, msgs...)
+	}
+	return nil
+}
+
+func (m *Mirror) transform(msg Message) (Message, error) {
+	if m.config.Transform == nil {
+		return msg, nil
+	}
+	return m.config.Transform(msg)
+}
+
+func (m *Mirror) batchSize() int {
+	if m.config.Destination.BatchSize > 0 {
+		return m.config.Destination.BatchSize
+	}
+	return 100
+}
+
+// Stats returns a snapshot of the mirror stats since the last time the method
+// was called, or since the mirror was created if it is called for the first
+// time.
+func (m *Mirror) Stats() MirrorStats {
+	reader := m.reader.Stats()
+	return MirrorStats{
+		Copied:  m.stats.copied.snapshot(),
+		Skipped: m.stats.skipped.snapshot(),
+		Lag:     reader.Lag,
+		Reader:  reader,
+		Writer:  m.writer.Stats(),
+	}
+}
+
+// Close closes the reader and the writer of the mirror, the program must not
+// call Run after closing the mirror.
+func (m *Mirror) Close() error {
+	rerr := m.reader.Close()
+	werr := m.writer.Close()
+	if rerr != nil {
+		return rerr
+	}
+	return werr
+}
+
+// makeMirrorMessage returns the copy of msg written by a mirror. Topic,
+// partition and offset must not be set when writing messages, they are
+// assigned when producing to the destination topic.
+func makeMirrorMessage(msg Message) Message {
+	return Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers,
+		Time:    msg.Time,
+	}
+}
+
+// mirrorBalancer routes messages to the partition that they were read from
+// when the topic has it, and uses fallback otherwise.
+type mirrorBalancer struct {
+	partition int
+	fallback  Balancer
+}
+
+// Balance satisfies the Balancer interface.
+func (b *mirrorBalancer) Balance(msg Message, partitions ...int) int {
+	if containsPartition(partitions, b.partition) {
+		return b.partition
+	}
+	return b.fallback.Balance(msg, partitions...)
+}
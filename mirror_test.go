@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMakeMirrorMessage(t *testing.T) {
+	now := time.Now()
+	msg := Message{
+		Topic:     "source",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   []Header{{Key: "header", Value: []byte("value")}},
+		Time:      now,
+	}
+
+	expected := Message{
+		Key:     []byte("key"),
+		Value:   []byte("value"),
+		Headers: []Header{{Key: "header", Value: []byte("value")}},
+		Time:    now,
+	}
+	if c := makeMirrorMessage(msg); !reflect.DeepEqual(c, expected) {
+		t.Errorf("unexpected copy:\nexpected: %+v\nfound:    %+v", expected, c)
+	}
+}
+
+func TestMirrorBalancer(t *testing.T) {
+	fallback := BalancerFunc(func(Message, ...int) int { return -1 })
+
+	b := &mirrorBalancer{partition: 1, fallback: fallback}
+	if p := b.Balance(Message{}, 0, 1, 2); p != 1 {
+		t.Errorf("expected partition 1; got %d", p)
+	}
+
+	b = &mirrorBalancer{partition: 3, fallback: fallback}
+	if p := b.Balance(Message{}, 0, 1, 2); p != -1 {
+		t.Errorf("expected the fallback balancer to be used; got %d", p)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	// The source and destination topics live in different clusters.
+	source, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	source.CreateTopic("source", 2)
+
+	destination, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	destination.CreateTopic("destination", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", source.Addr(), "source", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	msgs := make([]Message, 10)
+	for i := range msgs {
+		msgs[i] = Message{
+			Key:   []byte(strconv.Itoa(i)),
+			Value: []byte("value-" + strconv.Itoa(i)),
+			Time:  past,
+		}
+	}
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMirror(MirrorConfig{
+		Source: ReaderConfig{
+			Brokers:   []string{source.Addr()},
+			Topic:     "source",
+			Partition: 1,
+			MaxWait:   10 * time.Millisecond,
+		},
+		Destination: WriterConfig{
+			Brokers: []string{destination.Addr()},
+			Topic:   "destination",
+		},
+		Transform: func(msg Message) (Message, error) {
+			if string(msg.Key) == "0" {
+				return msg, ErrSkipMessage
+			}
+			return msg, nil
+		},
+		Parallelism:        2,
+		PreservePartitions: true,
+	})
+	defer m.Close()
+
+	runctx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- m.Run(runctx) }()
+
+	var copied, skipped int64
+	for copied < int64(len(msgs)-1) {
+		select {
+		case err := <-done:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		stats := m.Stats()
+		copied += stats.Copied
+		skipped += stats.Skipped
+	}
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected %v; got %v", context.Canceled, err)
+	}
+
+	copies := destination.Messages("destination", 1)
+	if len(copies) != len(msgs)-1 {
+		t.Fatalf("expected %d copies; got %d", len(msgs)-1, len(copies))
+	}
+	for i, c := range copies {
+		msg := msgs[i+1]
+		if string(c.Key) != string(msg.Key) || string(c.Value) != string(msg.Value) || !c.Time.Equal(msg.Time) {
+			t.Errorf("copy %d mismatch: expected %s=%s at %s; got %s=%s at %s",
+				i, msg.Key, msg.Value, msg.Time, c.Key, c.Value, c.Time)
+		}
+	}
+	if n := len(destination.Messages("destination", 0)); n != 0 {
+		t.Errorf("expected no messages to be copied to partition 0; got %d", n)
+	}
+	if copied != int64(len(msgs)-1) || skipped != 1 {
+		t.Errorf("expected %d copied and 1 skipped messages; got %d and %d", len(msgs)-1, copied, skipped)
+	}
+}
@@ -0,0 +1,2075 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MockAPI is an enumeration of the requests served by a MockBroker which can be
+// intercepted with MockBroker.OnRequest.
+type MockAPI int16
+
+const (
+	MockProduce      = MockAPI(produceRequest)
+	MockFetch        = MockAPI(fetchRequest)
+	MockListOffsets  = MockAPI(listOffsetRequest)
+	MockMetadata     = MockAPI(metadataRequest)
+	MockOffsetCommit = MockAPI(offsetCommitRequest)
+	MockOffsetFetch  = MockAPI(offsetFetchRequest)
+
+	MockDeleteRecords = MockAPI(deleteRecordsRequest)
+
+	MockFindCoordinator = MockAPI(groupCoordinatorRequest)
+	MockJoinGroup       = MockAPI(joinGroupRequest)
+	MockSyncGroup       = MockAPI(syncGroupRequest)
+	MockHeartbeat       = MockAPI(heartbeatRequest)
+	MockLeaveGroup      = MockAPI(leaveGroupRequest)
+)
+
+func (api MockAPI) String() string {
+	switch api {
+	case MockProduce:
+		return "Produce"
+	case MockFetch:
+		return "Fetch"
+	case MockListOffsets:
+		return "ListOffsets"
+	case MockMetadata:
+		return "Metadata"
+	case MockOffsetCommit:
+		return "OffsetCommit"
+	case MockOffsetFetch:
+		return "OffsetFetch"
+	case MockDeleteRecords:
+		return "DeleteRecords"
+	case MockFindCoordinator:
+		return "FindCoordinator"
+	case MockJoinGroup:
+		return "JoinGroup"
+	case MockSyncGroup:
+		return "SyncGroup"
+	case MockHeartbeat:
+		return "Heartbeat"
+	case MockLeaveGroup:
+		return "LeaveGroup"
+	default:
+		return "Unknown"
+	}
+}
+
+// MockRequest is passed to the function installed with MockBroker.OnRequest for
+// each partition of the requests received by the broker.
+type MockRequest struct {
+	API       MockAPI
+	Topic     string
+	Partition int
+
+	// Group is the consumer group of OffsetCommit, OffsetFetch, JoinGroup,
+	// SyncGroup, Heartbeat and LeaveGroup requests, and the key of
+	// FindCoordinator requests.
+	Group string
+
+	// ClientID is the client ID sent in the header of the request, which is
+	// configured with the ClientID field of the Dialer.
+	ClientID string
+
+	// Node is the ID of the node of the broker that received the request.
+	Node int
+}
+
+// MockResponse is returned by the function installed with MockBroker.OnRequest
+// to alter the response to a request, the zero-value lets the broker handle it
+// normally.
+type MockResponse struct {
+	// Error is the error code returned for the partition instead of handling
+	// the request. For Metadata requests it is returned for the topic, for
+	// FindCoordinator requests for the key, and for the requests of the
+	// members of consumer groups for the whole request.
+	Error Error
+
+	// ThrottleTime delays the response, which reports the longest throttle
+	// time of its partitions when the protocol has a field for it, like
+	// kafka versions prior to 2.0 do when clients exceed their quotas.
+	ThrottleTime time.Duration
+
+	// Corrupt alters the record batch returned for the partition of a fetch
+	// request v5 and above, whose checksum then mismatches its content, like
+	// batches corrupted on the disks of the brokers or in transit.
+	Corrupt bool
+}
+
+// MockBroker is a kafka broker running in the program and keeping messages in
+// memory, it is intended to test code using the package without a kafka
+// cluster.
+//
+// The broker serves the Metadata, Produce, Fetch, ListOffsets, DeleteRecords,
+// FindCoordinator, JoinGroup, SyncGroup, Heartbeat, LeaveGroup, OffsetCommit,
+// OffsetFetch, SaslHandshake and SaslAuthenticate APIs, it is the leader of all
+// partitions and the coordinator of all groups. A broker started by
+// NewMockCluster runs several nodes sharing the same topics, where node 0 is
+// the coordinator of all groups and leads the partitions until they are moved
+// to other nodes by calling MoveLeader. The other nodes are followers which
+// serve the fetch requests of consumers configured with a rack ID, the leader
+// designates the follower in the rack of the consumer as preferred read
+// replica when one was assigned to it by calling SetRack. Readers configured
+// with a GroupID can join consumer groups, which are rebalanced when members
+// join or leave, or when their session times out, but static membership is
+// not supported. Offset commits are not checked against the generation of the
+// group. Messages are returned by fetch requests v5 and above as record
+// batches, fetch requests v2 return them in the v1 format, which drops their
+// headers.
+//
+// Topics are not created automatically, they must be declared by calling
+// CreateTopic.
+type MockBroker struct {
+	nodes []*mockNode
+
+	mutex    sync.Mutex
+	topics   map[string][][]Message
+	leaders  map[string][]int32
+	offsets  map[string]map[string]map[int]int64
+	appends  map[string]bool
+	codecs   map[string]CompressionCodec
+	batches  map[string]map[int][]int64
+	starts   map[string]map[int]int64
+	sessions map[int32]*mockFetchSession
+	session  int32
+	groups   map[string]*mockGroup
+	sasl     []string
+	hook     func(MockRequest) MockResponse
+	conns    map[net.Conn]struct{}
+	produced chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	join     sync.WaitGroup
+}
+
+// mockNode is one of the nodes of a MockBroker, which are all listening on a
+// different port.
+type mockNode struct {
+	id       int32
+	listener net.Listener
+	host     string
+	port     int32
+	rack     string
+}
+
+func listenMockNode(id int32) (*mockNode, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &mockNode{id: id, listener: l, host: host, port: int32(portNumber)}, nil
+}
+
+// NewMockBroker starts a MockBroker listening on a random port of the loopback
+// interface.
+func NewMockBroker() (*MockBroker, error) {
+	return NewMockCluster(1)
+}
+
+// NewMockCluster starts a MockBroker running the given number of nodes, each
+// listening on a random port of the loopback interface.
+func NewMockCluster(nodes int) (*MockBroker, error) {
+	if nodes < 1 {
+		return nil, errors.New("kafka.NewMockCluster: the number of nodes must be at least 1")
+	}
+
+	b := &MockBroker{
+		topics:   make(map[string][][]Message),
+		leaders:  make(map[string][]int32),
+		offsets:  make(map[string]map[string]map[int]int64),
+		appends:  make(map[string]bool),
+		codecs:   make(map[string]CompressionCodec),
+		batches:  make(map[string]map[int][]int64),
+		starts:   make(map[string]map[int]int64),
+		sessions: make(map[int32]*mockFetchSession),
+		groups:   make(map[string]*mockGroup),
+		conns:    make(map[net.Conn]struct{}),
+		produced: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for i := 0; i != nodes; i++ {
+		node, err := listenMockNode(int32(i))
+		if err != nil {
+			for _, node := range b.nodes {
+				node.listener.Close()
+			}
+			return nil, err
+		}
+		b.nodes = append(b.nodes, node)
+	}
+
+	for _, node := range b.nodes {
+		b.join.Add(1)
+		go b.run(node)
+	}
+	return b, nil
+}
+
+// Addr returns the address that node 0 of the broker listens on, to be used as
+// the bootstrap broker of readers and writers.
+func (b *MockBroker) Addr() string {
+	return b.nodes[0].listener.Addr().String()
+}
+
+// Addrs returns the addresses that the nodes of the broker listen on, indexed
+// by node ID.
+func (b *MockBroker) Addrs() []string {
+	addrs := make([]string, len(b.nodes))
+	for i, node := range b.nodes {
+		addrs[i] = node.listener.Addr().String()
+	}
+	return addrs
+}
+
+// Close stops the broker, closing all connections to it.
+func (b *MockBroker) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		for _, node := range b.nodes {
+			if e := node.listener.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+
+		b.mutex.Lock()
+		for conn := range b.conns {
+			conn.Close()
+		}
+		b.mutex.Unlock()
+
+		b.join.Wait()
+	})
+	return err
+}
+
+// CreateTopic creates topic with the given number of partitions, it does
+// nothing if the topic already exists.
+func (b *MockBroker) CreateTopic(topic string, partitions int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make([][]Message, partitions)
+		b.leaders[topic] = make([]int32, partitions)
+	}
+}
+
+// AddPartitions adds count partitions to topic, led by the first node like the
+// partitions created by CreateTopic. It does nothing if the topic does not
+// exist.
+func (b *MockBroker) AddPartitions(topic string, count int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.topics[topic]; ok {
+		b.topics[topic] = append(b.topics[topic], make([][]Message, count)...)
+		b.leaders[topic] = append(b.leaders[topic], make([]int32, count)...)
+	}
+}
+
+// MoveLeader makes node the leader of partition of topic, the other nodes
+// respond to the requests for the partition with NotLeaderForPartition, like
+// kafka brokers do after the leader of a partition changed. It does nothing if
+// the partition or the node do not exist.
+func (b *MockBroker) MoveLeader(topic string, partition int, node int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.partition(topic, partition); !ok || node < 0 || node >= len(b.nodes) {
+		return
+	}
+	b.leaders[topic][partition] = int32(node)
+
+	// Wakes up the fetch requests waiting for messages so they respond with
+	// NotLeaderForPartition.
+	close(b.produced)
+	b.produced = make(chan struct{})
+}
+
+// SetRack assigns node to rack, which is advertised in the metadata of the
+// broker. Like kafka brokers configured with the rack aware replica selector,
+// the partition leaders designate a node in the rack of the consumers sending
+// fetch requests as their preferred read replica. It does nothing if the node
+// does not exist.
+func (b *MockBroker) SetRack(node int, rack string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if node < 0 || node >= len(b.nodes) {
+		return
+	}
+	b.nodes[node].rack = rack
+}
+
+// SetLogAppendTime configures topic like kafka topics using
+// message.timestamp.type=LogAppendTime, the broker ignores the time of the
+// messages produced to the topic, assigns them the time they were appended to
+// the partition instead, and returns it in the produce responses.
+func (b *MockBroker) SetLogAppendTime(topic string) {
+	b.mutex.Lock()
+	b.appends[topic] = true
+	b.mutex.Unlock()
+}
+
+// SetCompression configures topic like kafka topics storing compressed batches,
+// the fetch responses v5 and above return the batches produced to the topic
+// whole and compressed with codec, starting at the first message of the batch
+// holding the fetch offset, so consumers have to decompress and skip the
+// messages which precede it.
+func (b *MockBroker) SetCompression(topic string, codec CompressionCodec) {
+	b.mutex.Lock()
+	b.codecs[topic] = codec
+	b.mutex.Unlock()
+}
+
+// SetSASLMechanisms enables the SASL mechanisms on the broker, which then
+// accepts the SaslHandshake requests of clients selecting one of them, and any
+// credentials in the SaslAuthenticate requests which follow. Like kafka
+// brokers, it closes the connection after rejecting the handshake of a client
+// selecting another mechanism. No mechanisms are enabled by default.
+func (b *MockBroker) SetSASLMechanisms(mechanisms ...string) {
+	b.mutex.Lock()
+	b.sasl = append([]string{}, mechanisms...)
+	b.mutex.Unlock()
+}
+
+// EvictFetchSessions evicts the incremental fetch sessions (KIP-227) created
+// by consumers, like kafka brokers do when their session cache is full. The
+// next incremental fetch request of each session fails with
+// FetchSessionIDNotFound.
+func (b *MockBroker) EvictFetchSessions() {
+	b.mutex.Lock()
+	b.sessions = make(map[int32]*mockFetchSession)
+	b.mutex.Unlock()
+}
+
+// Messages returns the messages written to partition of topic which were not
+// deleted by DeleteRecords requests.
+func (b *MockBroker) Messages(topic string, partition int) []Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	log, ok := b.partition(topic, partition)
+	if !ok {
+		return nil
+	}
+	return append([]Message(nil), log[b.logStart(topic, partition):]...)
+}
+
+// OnRequest installs f to be called for each partition of the requests received
+// by the broker. It lets tests inject errors, for example returning
+// NotLeaderForPartition simulates a leader change, or throttle requests.
+//
+// f is called from the goroutines serving the connections, possibly
+// concurrently, and may call the methods of the broker. Passing nil removes
+// the function.
+func (b *MockBroker) OnRequest(f func(MockRequest) MockResponse) {
+	b.mutex.Lock()
+	b.hook = f
+	b.mutex.Unlock()
+}
+
+func (b *MockBroker) intercept(req MockRequest) MockResponse {
+	b.mutex.Lock()
+	hook := b.hook
+	b.mutex.Unlock()
+
+	if hook == nil {
+		return MockResponse{}
+	}
+	return hook(req)
+}
+
+func (b *MockBroker) run(node *mockNode) {
+	defer b.join.Done()
+
+	for {
+		conn, err := node.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mutex.Lock()
+		select {
+		case <-b.done:
+			conn.Close()
+		default:
+			b.conns[conn] = struct{}{}
+			b.join.Add(1)
+			go b.serve(conn, node.id)
+		}
+		b.mutex.Unlock()
+	}
+}
+
+// serve reads requests sent to node from conn and writes their responses in
+// order until the connection is closed, or a request cannot be decoded.
+func (b *MockBroker) serve(conn net.Conn, node int32) {
+	defer b.join.Done()
+	defer func() {
+		b.mutex.Lock()
+		delete(b.conns, conn)
+		b.mutex.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		var size int32
+		var h requestHeader
+
+		if _, err := readInt32(r, 4, &size); err != nil {
+			return
+		}
+
+		sz, err := readAll(r, int(size), &h.ApiKey, &h.ApiVersion, &h.CorrelationID, &h.ClientID)
+		if err != nil {
+			return
+		}
+
+		res, throttle, sz, err := b.handle(r, sz, apiKey(h.ApiKey), apiVersion(h.ApiVersion), mockClient{node: node, id: h.ClientID})
+		if err != nil {
+			return
+		}
+		if _, err := discardN(r, sz, sz); err != nil {
+			return
+		}
+
+		if throttle > 0 {
+			timer := time.NewTimer(throttle)
+			select {
+			case <-timer.C:
+			case <-b.done:
+				timer.Stop()
+				return
+			}
+		}
+
+		if res == nil { // produce requests with no acks have no response
+			continue
+		}
+
+		writeInt32(w, 4+res.size())
+		writeInt32(w, h.CorrelationID)
+		res.writeTo(w)
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		if r, ok := res.(saslHandshakeResponseV0); ok && r.ErrorCode != 0 {
+			return
+		}
+	}
+}
+
+// mockApiVersions lists the versions of the requests supported by the broker.
+// Like the client, the broker serves fetch requests v2, v5, v9 and v11, the
+// negotiation never selects the versions in between.
+var mockApiVersions = mockApiVersionsResponse{
+	{ApiKey: int16(produceRequest), MinVersion: int16(v2), MaxVersion: int16(v3)},
+	{ApiKey: int16(fetchRequest), MinVersion: int16(v2), MaxVersion: int16(v11)},
+	{ApiKey: int16(listOffsetRequest), MinVersion: int16(v1), MaxVersion: int16(v1)},
+	{ApiKey: int16(metadataRequest), MinVersion: int16(v1), MaxVersion: int16(v1)},
+	{ApiKey: int16(offsetCommitRequest), MinVersion: int16(v2), MaxVersion: int16(v2)},
+	{ApiKey: int16(offsetFetchRequest), MinVersion: int16(v1), MaxVersion: int16(v1)},
+	{ApiKey: int16(deleteRecordsRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+	{ApiKey: int16(groupCoordinatorRequest), MinVersion: int16(v0), MaxVersion: int16(v1)},
+	{ApiKey: int16(joinGroupRequest), MinVersion: int16(v1), MaxVersion: int16(v1)},
+	{ApiKey: int16(syncGroupRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+	{ApiKey: int16(heartbeatRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+	{ApiKey: int16(leaveGroupRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+	{ApiKey: int16(saslHandshakeRequest), MinVersion: int16(v1), MaxVersion: int16(v1)},
+	{ApiKey: int16(saslAuthenticateRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+	{ApiKey: int16(apiVersionsRequest), MinVersion: int16(v0), MaxVersion: int16(v0)},
+}
+
+type mockApiVersionsResponse []ApiVersion
+
+func (r mockApiVersionsResponse) size() int32 {
+	return 2 + 4 + 6*int32(len(r))
+}
+
+func (r mockApiVersionsResponse) writeTo(w *bufio.Writer) {
+	writeInt16(w, 0)
+	writeArray(w, len(r), func(i int) {
+		writeInt16(w, r[i].ApiKey)
+		writeInt16(w, r[i].MinVersion)
+		writeInt16(w, r[i].MaxVersion)
+	})
+}
+
+var (
+	errMockUnsupportedRequest = errors.New("kafka.(*MockBroker): unsupported request")
+	errMockClosed             = errors.New("kafka.(*MockBroker): closed")
+)
+
+// mockClient identifies the client sending a request to a node of the broker.
+type mockClient struct {
+	node int32
+	id   string
+}
+
+func (b *MockBroker) handle(r *bufio.Reader, sz int, key apiKey, version apiVersion, client mockClient) (res request, throttle time.Duration, remain int, err error) {
+	switch key {
+	case apiVersionsRequest:
+		return mockApiVersions, 0, sz, nil
+	case produceRequest:
+		return b.produce(r, sz, version, client)
+	case fetchRequest:
+		return b.fetch(r, sz, version, client)
+	case listOffsetRequest:
+		return b.listOffsets(r, sz, client)
+	case metadataRequest:
+		return b.metadata(r, sz, client)
+	case groupCoordinatorRequest:
+		return b.findCoordinator(r, sz, version, client)
+	case joinGroupRequest:
+		return b.joinGroup(r, sz, client)
+	case syncGroupRequest:
+		return b.syncGroup(r, sz, client)
+	case heartbeatRequest:
+		return b.heartbeat(r, sz, client)
+	case leaveGroupRequest:
+		return b.leaveGroup(r, sz, client)
+	case offsetCommitRequest:
+		return b.offsetCommit(r, sz, client)
+	case offsetFetchRequest:
+		return b.offsetFetch(r, sz, client)
+	case deleteRecordsRequest:
+		return b.deleteRecords(r, sz, client)
+	case saslHandshakeRequest:
+		return b.saslHandshake(r, sz)
+	case saslAuthenticateRequest:
+		return b.saslAuthenticate(r, sz)
+	default:
+		return nil, 0, sz, errMockUnsupportedRequest
+	}
+}
+
+// partition returns the messages of partition of topic, and whether it exists.
+// The mutex must be held.
+func (b *MockBroker) partition(topic string, partition int) ([]Message, bool) {
+	partitions := b.topics[topic]
+	if partition < 0 || partition >= len(partitions) {
+		return nil, false
+	}
+	return partitions[partition], true
+}
+
+// logStart returns the offset of the first message of partition of topic which
+// was not deleted, the mutex must be held.
+func (b *MockBroker) logStart(topic string, partition int) int64 {
+	return b.starts[topic][partition]
+}
+
+// leads returns whether node is the leader of partition of topic, the mutex
+// must be held.
+func (b *MockBroker) leads(node int32, topic string, partition int) bool {
+	return b.leaders[topic][partition] == node
+}
+
+func (b *MockBroker) produce(r *bufio.Reader, sz int, version apiVersion, client mockClient) (request, time.Duration, int, error) {
+	var err error
+	var acks int16
+	var timeout int32
+	var transactionalID string
+	var res produceResponseV2
+	var throttle time.Duration
+
+	if version >= v3 {
+		if sz, err = readString(r, sz, &transactionalID); err != nil {
+			return nil, 0, sz, err
+		}
+	}
+	if sz, err = readAll(r, sz, &acks, &timeout); err != nil {
+		return nil, 0, sz, err
+	}
+
+	sz, err = readArrayWith(r, sz, func(r *bufio.Reader, sz int) (int, error) {
+		topic := produceResponseTopicV2{}
+
+		sz, err := readString(r, sz, &topic.TopicName)
+		if err != nil {
+			return sz, err
+		}
+
+		sz, err = readArrayWith(r, sz, func(r *bufio.Reader, sz int) (int, error) {
+			var partition int32
+			var msgs []Message
+
+			sz, err := readInt32(r, sz, &partition)
+			if err != nil {
+				return sz, err
+			}
+			if sz, msgs, err = readMockMessageSet(r, sz); err != nil {
+				return sz, err
+			}
+
+			mock := b.intercept(MockRequest{API: MockProduce, Topic: topic.TopicName, Partition: int(partition), ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+
+			p := produceResponsePartitionV2{Partition: partition, Timestamp: -1}
+			if mock.Error != 0 {
+				p.ErrorCode = int16(mock.Error)
+			} else {
+				var appendTime time.Time
+				p.Offset, appendTime, p.ErrorCode = b.append(client.node, topic.TopicName, int(partition), msgs)
+				if !appendTime.IsZero() {
+					p.Timestamp = appendTime.UnixNano() / int64(time.Millisecond)
+				}
+			}
+
+			topic.Partitions = append(topic.Partitions, p)
+			return sz, nil
+		})
+
+		res.Topics = append(res.Topics, topic)
+		return sz, err
+	})
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	if acks == 0 {
+		return nil, throttle, sz, nil
+	}
+	res.ThrottleTime = int32(throttle / time.Millisecond)
+	return res, throttle, sz, nil
+}
+
+// readMockMessageSet reads the size prefixed message set of a produce request.
+func readMockMessageSet(r *bufio.Reader, sz int) (int, []Message, error) {
+	var size int32
+	var msgs []Message
+
+	sz, err := readInt32(r, sz, &size)
+	if err != nil {
+		return sz, nil, err
+	}
+	if int(size) > sz {
+		return sz, nil, errShortRead
+	}
+	if size == 0 {
+		return sz, nil, nil
+	}
+
+	set, err := newMessageSetReader(r, int(size))
+	if err != nil {
+		return sz, nil, err
+	}
+
+	for {
+		var msg Message
+
+		_, ts, headers, err := set.readMessage(math.MinInt64,
+			func(r *bufio.Reader, sz int, n int) (remain int, err error) {
+				msg.Key, remain, err = readNewBytes(r, sz, n)
+				return
+			},
+			func(r *bufio.Reader, sz int, n int) (remain int, err error) {
+				msg.Value, remain, err = readNewBytes(r, sz, n)
+				return
+			},
+		)
+		if err == errShortRead {
+			break
+		}
+		if err != nil {
+			return sz, nil, err
+		}
+
+		if ts > 0 {
+			msg.Time = timestampToTime(ts)
+		}
+		msg.Headers = headers
+		msgs = append(msgs, msg)
+	}
+
+	if err := set.discard(); err != nil {
+		return sz, nil, err
+	}
+	return sz - int(size), msgs, nil
+}
+
+// append adds msgs written to node to partition of topic, returning the offset
+// of the first message and the log append time if the topic uses it, or the
+// error code of the partition.
+func (b *MockBroker) append(node int32, topic string, partition int, msgs []Message) (int64, time.Time, int16) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	log, ok := b.partition(topic, partition)
+	if !ok {
+		return -1, time.Time{}, int16(UnknownTopicOrPartition)
+	}
+	if !b.leads(node, topic, partition) {
+		return -1, time.Time{}, int16(NotLeaderForPartition)
+	}
+
+	base := int64(len(log))
+	now := time.Now().Truncate(time.Millisecond)
+
+	var appendTime time.Time
+	if b.appends[topic] {
+		appendTime = now
+	}
+
+	for i, msg := range msgs {
+		msg.Topic, msg.Partition, msg.Offset = topic, partition, base+int64(i)
+		if msg.Time.IsZero() || !appendTime.IsZero() {
+			msg.Time = now
+		}
+		log = append(log, msg)
+	}
+	b.topics[topic][partition] = log
+
+	if len(msgs) != 0 {
+		if b.batches[topic] == nil {
+			b.batches[topic] = make(map[int][]int64)
+		}
+		b.batches[topic][partition] = append(b.batches[topic][partition], base)
+
+		close(b.produced)
+		b.produced = make(chan struct{})
+	}
+	return base, appendTime, 0
+}
+
+func (b *MockBroker) fetch(r *bufio.Reader, sz int, version apiVersion, client mockClient) (request, time.Duration, int, error) {
+	var req fetchRequestV2
+	var rackID string
+	var session *mockFetchSession
+	var throttle time.Duration
+	var err error
+
+	switch version {
+	case v2:
+		sz, err = read(r, sz, &req)
+	case v5:
+		var v5 mockFetchRequestV5
+		if sz, err = read(r, sz, &v5); err == nil {
+			req = v5.v2()
+		}
+	case v9, v11:
+		var v11 fetchRequestV11
+		if version == v9 {
+			var v9 mockFetchRequestV9
+			if sz, err = read(r, sz, &v9); err == nil {
+				v11 = v9.v11()
+			}
+		} else {
+			sz, err = read(r, sz, &v11)
+		}
+		if err == nil {
+			rackID = v11.RackID
+
+			var sessionErr Error
+			if req, session, sessionErr = b.fetchSession(v11); sessionErr != 0 {
+				return mockFetchResponse(fetchResponseV11{ErrorCode: int16(sessionErr)}, version), 0, sz, nil
+			}
+		}
+	default:
+		return nil, 0, sz, errMockUnsupportedRequest
+	}
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mocks := make(map[string]map[int32]MockResponse)
+	for _, t := range req.Topics {
+		mocks[t.TopicName] = make(map[int32]MockResponse)
+		for _, p := range t.Partitions {
+			mock := b.intercept(MockRequest{API: MockFetch, Topic: t.TopicName, Partition: int(p.Partition), ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+			mocks[t.TopicName][p.Partition] = mock
+		}
+	}
+
+	// Like kafka, wait for MinBytes to be available or MaxWaitTime to expire
+	// before responding, unless a partition has an error or the leader
+	// designates another replica to fetch from.
+	timer := time.NewTimer(duration(req.MaxWaitTime))
+	defer timer.Stop()
+
+	for {
+		res := fetchResponseV11{ThrottleTime: int32(throttle / time.Millisecond)}
+		bytes, failed := 0, false
+
+		b.mutex.Lock()
+		for _, t := range req.Topics {
+			topic := fetchResponseTopicV11{TopicName: t.TopicName}
+			for _, p := range t.Partitions {
+				mock := mocks[t.TopicName][p.Partition]
+				partition := b.fetchPartition(client.node, version, rackID, t.TopicName, p, mock.Error)
+				if n := len(partition.RecordBatches); mock.Corrupt && n != 0 {
+					// The last byte belongs to the last record, which is
+					// covered by the checksum of the batch.
+					partition.RecordBatches[n-1] ^= 0xFF
+				}
+				bytes += int(partition.MessageSetSize)
+				failed = failed || partition.ErrorCode != 0 || partition.PreferredReadReplica >= 0
+				topic.Partitions = append(topic.Partitions, partition)
+			}
+			res.Topics = append(res.Topics, topic)
+		}
+		produced := b.produced
+		b.mutex.Unlock()
+
+		if failed || bytes >= int(req.MinBytes) {
+			return b.fetchResponse(res, version, session), throttle, sz, nil
+		}
+
+		select {
+		case <-produced:
+		case <-timer.C:
+			return b.fetchResponse(res, version, session), throttle, sz, nil
+		case <-b.done:
+			return b.fetchResponse(res, version, session), throttle, sz, nil
+		}
+	}
+}
+
+// mockFetchSession is an incremental fetch session (KIP-227) created by a
+// consumer, holding the fetch parameters of its partitions and the high
+// watermarks last returned for them.
+type mockFetchSession struct {
+	id         int32
+	epoch      int32
+	partitions map[string]map[int32]*mockFetchSessionPartition
+
+	// incremental is false until the session served the full fetch request
+	// that created it.
+	incremental bool
+}
+
+type mockFetchSessionPartition struct {
+	fetchRequestPartitionV2
+	highWaterMark int64
+}
+
+// fetchSession returns the partitions to fetch for a fetch request v11 as a
+// fetch request v2, along with the fetch session of the request, which is nil
+// when the request is not part of a session. Incremental fetch requests add
+// their partitions to the session, or update their fetch parameters, and fetch
+// all the partitions of the session.
+func (b *MockBroker) fetchSession(v11 fetchRequestV11) (fetchRequestV2, *mockFetchSession, Error) {
+	req := fetchRequestV2{
+		ReplicaID:   v11.ReplicaID,
+		MaxWaitTime: v11.MaxWaitTime,
+		MinBytes:    v11.MinBytes,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var session *mockFetchSession
+	switch {
+	case v11.SessionID == 0 && v11.SessionEpoch == 0:
+		b.session++
+		session = &mockFetchSession{id: b.session, epoch: 1, partitions: make(map[string]map[int32]*mockFetchSessionPartition)}
+		b.sessions[session.id] = session
+	case v11.SessionID != 0:
+		var ok bool
+		if session, ok = b.sessions[v11.SessionID]; !ok {
+			return req, nil, FetchSessionIDNotFound
+		}
+		if v11.SessionEpoch == -1 {
+			delete(b.sessions, session.id)
+			session = nil
+			break
+		}
+		if v11.SessionEpoch != session.epoch {
+			return req, nil, InvalidFetchSessionEpoch
+		}
+		session.epoch++
+		session.incremental = true
+	}
+
+	if session == nil {
+		for _, t := range v11.Topics {
+			topic := fetchRequestTopicV2{TopicName: t.TopicName}
+			for _, p := range t.Partitions {
+				topic.Partitions = append(topic.Partitions, fetchRequestPartitionV2{
+					Partition:   p.Partition,
+					FetchOffset: p.FetchOffset,
+					MaxBytes:    p.MaxBytes,
+				})
+			}
+			req.Topics = append(req.Topics, topic)
+		}
+		return req, nil, 0
+	}
+
+	for _, t := range v11.Topics {
+		partitions := session.partitions[t.TopicName]
+		if partitions == nil {
+			partitions = make(map[int32]*mockFetchSessionPartition)
+			session.partitions[t.TopicName] = partitions
+		}
+		for _, p := range t.Partitions {
+			partition := partitions[p.Partition]
+			if partition == nil {
+				partition = &mockFetchSessionPartition{highWaterMark: -1}
+				partitions[p.Partition] = partition
+			}
+			partition.fetchRequestPartitionV2 = fetchRequestPartitionV2{
+				Partition:   p.Partition,
+				FetchOffset: p.FetchOffset,
+				MaxBytes:    p.MaxBytes,
+			}
+		}
+	}
+	for _, t := range v11.ForgottenTopics {
+		for _, p := range t.Partitions {
+			delete(session.partitions[t.TopicName], p)
+		}
+	}
+
+	topics := make([]string, 0, len(session.partitions))
+	for topic := range session.partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		t := fetchRequestTopicV2{TopicName: topic}
+		for _, p := range session.partitions[topic] {
+			t.Partitions = append(t.Partitions, p.fetchRequestPartitionV2)
+		}
+		sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].Partition < t.Partitions[j].Partition })
+		if len(t.Partitions) != 0 {
+			req.Topics = append(req.Topics, t)
+		}
+	}
+	return req, session, 0
+}
+
+// fetchResponse returns res in the version of the fetch request. The responses
+// of incremental fetch requests omit the partitions which have no messages,
+// error or new high watermark since the last response of the session.
+func (b *MockBroker) fetchResponse(res fetchResponseV11, version apiVersion, session *mockFetchSession) request {
+	if session == nil {
+		return mockFetchResponse(res, version)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	res.SessionID = session.id
+	topics := res.Topics
+	res.Topics = nil
+
+	for _, t := range topics {
+		topic := fetchResponseTopicV11{TopicName: t.TopicName}
+		for _, p := range t.Partitions {
+			partition := session.partitions[t.TopicName][p.Partition]
+			if partition == nil {
+				continue
+			}
+			changed := p.MessageSetSize != 0 || p.ErrorCode != 0 || p.HighwaterMarkOffset != partition.highWaterMark
+			if changed || !session.incremental {
+				partition.highWaterMark = p.HighwaterMarkOffset
+				topic.Partitions = append(topic.Partitions, p)
+			}
+		}
+		if len(topic.Partitions) != 0 {
+			res.Topics = append(res.Topics, topic)
+		}
+	}
+	return mockFetchResponse(res, version)
+}
+
+// mockFetchResponse returns res in the version of the fetch request.
+func mockFetchResponse(res fetchResponseV11, version apiVersion) request {
+	switch {
+	case version >= v11:
+		return res
+	case version >= v5:
+		return mockFetchResponseV5{fetchResponseV11: res, sessions: version >= v7}
+	}
+
+	v2 := fetchResponseV2{ThrottleTime: res.ThrottleTime}
+	for _, t := range res.Topics {
+		topic := fetchResponseTopicV2{TopicName: t.TopicName}
+		for _, p := range t.Partitions {
+			topic.Partitions = append(topic.Partitions, fetchResponsePartitionV2{
+				Partition:           p.Partition,
+				ErrorCode:           p.ErrorCode,
+				HighwaterMarkOffset: p.HighwaterMarkOffset,
+				MessageSetSize:      p.MessageSetSize,
+				MessageSet:          p.MessageSet,
+			})
+		}
+		v2.Topics = append(v2.Topics, topic)
+	}
+	return v2
+}
+
+// mockFetchRequestV5 is a fetch request v5, the first version returning the
+// messages as record batches.
+type mockFetchRequestV5 struct {
+	ReplicaID      int32
+	MaxWaitTime    int32
+	MinBytes       int32
+	MaxBytes       int32
+	IsolationLevel int8
+	Topics         []mockFetchRequestTopicV5
+}
+
+type mockFetchRequestTopicV5 struct {
+	TopicName  string
+	Partitions []mockFetchRequestPartitionV5
+}
+
+type mockFetchRequestPartitionV5 struct {
+	Partition      int32
+	FetchOffset    int64
+	LogStartOffset int64
+	MaxBytes       int32
+}
+
+// v2 returns the fields of the request which are handled by the broker.
+func (r mockFetchRequestV5) v2() fetchRequestV2 {
+	req := fetchRequestV2{
+		ReplicaID:   r.ReplicaID,
+		MaxWaitTime: r.MaxWaitTime,
+		MinBytes:    r.MinBytes,
+	}
+	for _, t := range r.Topics {
+		topic := fetchRequestTopicV2{TopicName: t.TopicName}
+		for _, p := range t.Partitions {
+			topic.Partitions = append(topic.Partitions, fetchRequestPartitionV2{
+				Partition:   p.Partition,
+				FetchOffset: p.FetchOffset,
+				MaxBytes:    p.MaxBytes,
+			})
+		}
+		req.Topics = append(req.Topics, topic)
+	}
+	return req
+}
+
+// mockFetchRequestV9 is a fetch request v9, which differs from v11 by the
+// missing rack ID.
+type mockFetchRequestV9 struct {
+	ReplicaID       int32
+	MaxWaitTime     int32
+	MinBytes        int32
+	MaxBytes        int32
+	IsolationLevel  int8
+	SessionID       int32
+	SessionEpoch    int32
+	Topics          []fetchRequestTopicV11
+	ForgottenTopics []fetchRequestForgottenTopicV11
+}
+
+func (r mockFetchRequestV9) v11() fetchRequestV11 {
+	return fetchRequestV11{
+		ReplicaID:       r.ReplicaID,
+		MaxWaitTime:     r.MaxWaitTime,
+		MinBytes:        r.MinBytes,
+		MaxBytes:        r.MaxBytes,
+		IsolationLevel:  r.IsolationLevel,
+		SessionID:       r.SessionID,
+		SessionEpoch:    r.SessionEpoch,
+		Topics:          r.Topics,
+		ForgottenTopics: r.ForgottenTopics,
+	}
+}
+
+// mockFetchResponseV5 writes a fetch response v11 as a response v5, or v7 and
+// above when sessions is true, which starts with the error code and session ID
+// of the response. Their partitions differ from v11 by the missing preferred
+// read replica.
+type mockFetchResponseV5 struct {
+	fetchResponseV11
+	sessions bool
+}
+
+func (r mockFetchResponseV5) size() int32 {
+	size := 4 + sizeofArray(len(r.Topics), func(i int) int32 {
+		t := r.Topics[i]
+		return sizeofString(t.TopicName) +
+			sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() - 4 })
+	})
+	if r.sessions {
+		size += 2 + 4
+	}
+	return size
+}
+
+func (r mockFetchResponseV5) writeTo(w *bufio.Writer) {
+	writeInt32(w, r.ThrottleTime)
+	if r.sessions {
+		writeInt16(w, r.ErrorCode)
+		writeInt32(w, r.SessionID)
+	}
+	writeArray(w, len(r.Topics), func(i int) {
+		t := r.Topics[i]
+		writeString(w, t.TopicName)
+		writeArray(w, len(t.Partitions), func(i int) {
+			p := t.Partitions[i]
+			writeInt32(w, p.Partition)
+			writeInt16(w, p.ErrorCode)
+			writeInt64(w, p.HighwaterMarkOffset)
+			writeInt64(w, p.LastStableOffset)
+			writeInt64(w, p.LogStartOffset)
+			writeArrayLen(w, 0) // aborted transactions
+			writeInt32(w, p.MessageSetSize)
+			if p.RecordBatches != nil {
+				w.Write(p.RecordBatches)
+			} else {
+				p.MessageSet.writeTo(w)
+			}
+		})
+	})
+}
+
+// fetchPartition returns the messages of a partition requested by a fetch
+// request sent to node, the mutex must be held. Followers only serve requests
+// v11 and above from consumers with a rack ID, and leaders designate a follower
+// instead when one is in the rack of the consumer.
+func (b *MockBroker) fetchPartition(node int32, version apiVersion, rackID string, topic string, req fetchRequestPartitionV2, err Error) fetchResponsePartitionV11 {
+	res := fetchResponsePartitionV11{
+		Partition:            req.Partition,
+		HighwaterMarkOffset:  -1,
+		LastStableOffset:     -1,
+		LogStartOffset:       -1,
+		PreferredReadReplica: -1,
+	}
+
+	log, ok := b.partition(topic, int(req.Partition))
+	leader := ok && b.leads(node, topic, int(req.Partition))
+	switch {
+	case err != 0:
+		res.ErrorCode = int16(err)
+		return res
+	case !ok:
+		res.ErrorCode = int16(UnknownTopicOrPartition)
+		return res
+	case !leader && (version < v11 || rackID == ""):
+		res.ErrorCode = int16(NotLeaderForPartition)
+		return res
+	}
+
+	res.HighwaterMarkOffset = int64(len(log))
+	res.LastStableOffset = res.HighwaterMarkOffset
+	res.LogStartOffset = b.logStart(topic, int(req.Partition))
+	if req.FetchOffset < res.LogStartOffset || req.FetchOffset > res.HighwaterMarkOffset {
+		res.ErrorCode = int16(OffsetOutOfRange)
+		return res
+	}
+
+	if leader && rackID != "" {
+		if replica := b.readReplica(node, rackID); replica >= 0 {
+			res.PreferredReadReplica = replica
+			return res
+		}
+	}
+
+	// At least one message is returned even if it exceeds MaxBytes so the
+	// consumers always make progress. Responses v5 and above carry the
+	// messages in a record batch, which keeps their headers.
+	if version >= v5 {
+		if codec := b.codecs[topic]; codec != nil && req.FetchOffset < res.HighwaterMarkOffset {
+			first, last := b.batchOf(topic, int(req.Partition), req.FetchOffset)
+			batch, err := mockRecordBatch(codec, log[first:last]...)
+			if err != nil {
+				res.ErrorCode = int16(Unknown)
+				return res
+			}
+			res.RecordBatches = batch
+			res.MessageSetSize = int32(len(res.RecordBatches))
+			return res
+		}
+
+		msgs := log[req.FetchOffset:]
+		size, n := recordBatchHeaderSize(), 0
+		for ; n < len(msgs); n++ {
+			sz := recordSize(&msgs[n], timestamp(msgs[n].Time)-timestamp(msgs[0].Time), int64(n))
+			sz += varIntLen(int64(sz))
+			if n != 0 && size+int32(sz) > req.MaxBytes {
+				break
+			}
+			size += int32(sz)
+		}
+		if n != 0 {
+			res.RecordBatches, _ = mockRecordBatch(nil, msgs[:n]...)
+			res.MessageSetSize = int32(len(res.RecordBatches))
+		}
+		return res
+	}
+
+	for _, msg := range log[req.FetchOffset:] {
+		item := msg.item()
+		if len(res.MessageSet) != 0 && res.MessageSetSize+item.size() > req.MaxBytes {
+			break
+		}
+		res.MessageSet = append(res.MessageSet, item)
+		res.MessageSetSize += item.size()
+	}
+
+	return res
+}
+
+// mockRecordBatch encodes msgs, which must have consecutive offsets, as a
+// record batch of the v2 message format, compressed with codec unless it is
+// nil. The error is always nil when codec is nil.
+func mockRecordBatch(codec CompressionCodec, msgs ...Message) ([]byte, error) {
+	records := &bytes.Buffer{}
+	rw := bufio.NewWriter(records)
+	for i, msg := range msgs {
+		writeRecord(rw, 0, msgs[0].Time, int64(i), msg)
+	}
+	rw.Flush()
+
+	var attributes int16
+	size := recordBatchSize(msgs...)
+	if codec != nil {
+		compressed, err := codec.Encode(records.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		records = bytes.NewBuffer(compressed)
+		attributes = int16(codec.Code())
+		size = recordBatchHeaderSize() + int32(records.Len())
+	}
+
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	writeRecordBatch(w, nil, attributes, size, func(w *bufio.Writer) {
+		w.Write(records.Bytes())
+	}, msgs...)
+	w.Flush()
+
+	// The base offset of the batch is not covered by its checksum.
+	b := buf.Bytes()
+	binary.BigEndian.PutUint64(b[:8], uint64(msgs[0].Offset))
+	return b, nil
+}
+
+// batchOf returns the offset of the first message of the batch produced with
+// the message at offset to partition of topic, and the offset following the
+// batch. The mutex must be held.
+func (b *MockBroker) batchOf(topic string, partition int, offset int64) (first int64, last int64) {
+	bases := b.batches[topic][partition]
+	i := sort.Search(len(bases), func(i int) bool { return bases[i] > offset })
+	if i != 0 {
+		first = bases[i-1]
+	}
+	if i != len(bases) {
+		last = bases[i]
+	} else {
+		last = int64(len(b.topics[topic][partition]))
+	}
+	return first, last
+}
+
+// readReplica returns the node in rack that leader designates as preferred
+// read replica, or -1 if the leader itself or no node is in rack. The mutex
+// must be held.
+func (b *MockBroker) readReplica(leader int32, rack string) int32 {
+	replica := int32(-1)
+	for _, node := range b.nodes {
+		if node.rack != rack {
+			continue
+		}
+		if node.id == leader {
+			return -1
+		}
+		if replica < 0 {
+			replica = node.id
+		}
+	}
+	return replica
+}
+
+func (b *MockBroker) listOffsets(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req listOffsetRequestV1
+	var res listOffsetResponseV1
+	var throttle time.Duration
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	for _, t := range req.Topics {
+		topic := listOffsetResponseTopicV1{TopicName: t.TopicName}
+
+		for _, p := range t.Partitions {
+			mock := b.intercept(MockRequest{API: MockListOffsets, Topic: t.TopicName, Partition: int(p.Partition), ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+
+			offset := partitionOffsetV1{Partition: p.Partition, Timestamp: -1, Offset: -1}
+			if mock.Error != 0 {
+				offset.ErrorCode = int16(mock.Error)
+			} else {
+				offset.Offset, offset.ErrorCode = b.offsetOf(client.node, t.TopicName, int(p.Partition), p.Time)
+			}
+
+			topic.PartitionOffsets = append(topic.PartitionOffsets, offset)
+		}
+
+		res = append(res, topic)
+	}
+
+	return res, throttle, sz, nil
+}
+
+// offsetOf returns the offset of the first message of partition of topic with
+// a timestamp greater or equal to t, which may also be FirstOffset or
+// LastOffset, as listed by node.
+func (b *MockBroker) offsetOf(node int32, topic string, partition int, t int64) (int64, int16) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	log, ok := b.partition(topic, partition)
+	if !ok {
+		return -1, int16(UnknownTopicOrPartition)
+	}
+	if !b.leads(node, topic, partition) {
+		return -1, int16(NotLeaderForPartition)
+	}
+
+	start := b.logStart(topic, partition)
+	switch t {
+	case FirstOffset:
+		return start, 0
+	case LastOffset:
+		return int64(len(log)), 0
+	}
+
+	log = log[start:]
+	i := sort.Search(len(log), func(i int) bool { return timestamp(log[i].Time) >= t })
+	return start + int64(i), 0
+}
+
+func (b *MockBroker) deleteRecords(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req deleteRecordsRequestV0
+	var res deleteRecordsResponseV0
+	var throttle time.Duration
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	for _, t := range req.Topics {
+		topic := deleteRecordsResponseV0Topic{Name: t.Name}
+
+		for _, p := range t.Partitions {
+			mock := b.intercept(MockRequest{API: MockDeleteRecords, Topic: t.Name, Partition: int(p.PartitionIndex), ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+
+			partition := deleteRecordsResponseV0Partition{PartitionIndex: p.PartitionIndex, LowWatermark: -1}
+			if mock.Error != 0 {
+				partition.ErrorCode = int16(mock.Error)
+			} else {
+				partition.LowWatermark, partition.ErrorCode = b.deleteBefore(client.node, t.Name, int(p.PartitionIndex), p.Offset)
+			}
+
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+
+		res.Topics = append(res.Topics, topic)
+	}
+
+	res.ThrottleTimeMS = milliseconds(throttle)
+	return res, throttle, sz, nil
+}
+
+// deleteBefore deletes the messages of partition of topic before offset, which
+// may be LastOffset to delete all of them, on behalf of node. It returns the
+// offset of the first message left in the partition, or the error code of the
+// partition. Like kafka brokers, offsets beyond the high watermark are rejected
+// with OffsetOutOfRange, and the log start offset never moves backward.
+func (b *MockBroker) deleteBefore(node int32, topic string, partition int, offset int64) (int64, int16) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	log, ok := b.partition(topic, partition)
+	if !ok {
+		return -1, int16(UnknownTopicOrPartition)
+	}
+	if !b.leads(node, topic, partition) {
+		return -1, int16(NotLeaderForPartition)
+	}
+
+	if offset == LastOffset {
+		offset = int64(len(log))
+	}
+	if offset < 0 || offset > int64(len(log)) {
+		return -1, int16(OffsetOutOfRange)
+	}
+
+	start := b.logStart(topic, partition)
+	if offset > start {
+		if b.starts[topic] == nil {
+			b.starts[topic] = make(map[int]int64)
+		}
+		b.starts[topic][partition] = offset
+		start = offset
+	}
+	return start, 0
+}
+
+func (b *MockBroker) metadata(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var topics []string
+	var throttle time.Duration
+
+	sz, err := readStringArray(r, sz, &topics)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	// The client requests all topics by sending an empty list.
+	if len(topics) == 0 {
+		b.mutex.Lock()
+		for topic := range b.topics {
+			topics = append(topics, topic)
+		}
+		b.mutex.Unlock()
+		sort.Strings(topics)
+	}
+
+	res := metadataResponseV1{}
+	replicas := make([]int32, len(b.nodes))
+	b.mutex.Lock()
+	for i, node := range b.nodes {
+		res.Brokers = append(res.Brokers, brokerMetadataV1{NodeID: node.id, Host: node.host, Port: node.port, Rack: node.rack})
+		replicas[i] = node.id
+	}
+	b.mutex.Unlock()
+
+	for _, name := range topics {
+		mock := b.intercept(MockRequest{API: MockMetadata, Topic: name, Partition: -1, ClientID: client.id, Node: int(client.node)})
+		if mock.ThrottleTime > throttle {
+			throttle = mock.ThrottleTime
+		}
+
+		b.mutex.Lock()
+		leaders, ok := b.leaders[name]
+		leaders = append([]int32(nil), leaders...)
+		b.mutex.Unlock()
+
+		topic := topicMetadataV1{TopicName: name}
+		switch {
+		case mock.Error != 0:
+			topic.TopicErrorCode = int16(mock.Error)
+		case !ok:
+			topic.TopicErrorCode = int16(UnknownTopicOrPartition)
+		default:
+			for i, leader := range leaders {
+				topic.Partitions = append(topic.Partitions, partitionMetadataV1{
+					PartitionID: int32(i),
+					Leader:      leader,
+					Replicas:    replicas,
+					Isr:         replicas,
+				})
+			}
+		}
+
+		res.Topics = append(res.Topics, topic)
+	}
+
+	return res, throttle, sz, nil
+}
+
+func (b *MockBroker) findCoordinator(r *bufio.Reader, sz int, version apiVersion, client mockClient) (request, time.Duration, int, error) {
+	var req findCoordinatorRequestV1
+	var err error
+
+	if version >= v1 {
+		sz, err = read(r, sz, &req)
+	} else {
+		sz, err = readString(r, sz, &req.CoordinatorKey)
+	}
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mock := b.intercept(MockRequest{API: MockFindCoordinator, Group: req.CoordinatorKey, ClientID: client.id, Node: int(client.node)})
+
+	res := findCoordinatorResponseV1{
+		ThrottleTimeMS: milliseconds(mock.ThrottleTime),
+		ErrorCode:      int16(mock.Error),
+	}
+	if mock.Error == 0 {
+		res.Coordinator = findCoordinatorResponseCoordinatorV0{
+			NodeID: b.nodes[0].id,
+			Host:   b.nodes[0].host,
+			Port:   b.nodes[0].port,
+		}
+	}
+
+	if version >= v1 {
+		return res, mock.ThrottleTime, sz, nil
+	}
+	return findCoordinatorResponseV0{
+		ErrorCode:   res.ErrorCode,
+		Coordinator: res.Coordinator,
+	}, mock.ThrottleTime, sz, nil
+}
+
+func (b *MockBroker) offsetCommit(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req offsetCommitRequestV2
+	var res offsetCommitResponseV2
+	var throttle time.Duration
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	for _, t := range req.Topics {
+		topic := offsetCommitResponseV2Response{Topic: t.Topic}
+
+		for _, p := range t.Partitions {
+			mock := b.intercept(MockRequest{API: MockOffsetCommit, Topic: t.Topic, Partition: int(p.Partition), Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+
+			partition := offsetCommitResponseV2PartitionResponse{Partition: p.Partition}
+			if mock.Error != 0 {
+				partition.ErrorCode = int16(mock.Error)
+			} else {
+				partition.ErrorCode = b.commit(req.GroupID, t.Topic, int(p.Partition), p.Offset)
+			}
+
+			topic.PartitionResponses = append(topic.PartitionResponses, partition)
+		}
+
+		res.Responses = append(res.Responses, topic)
+	}
+
+	return res, throttle, sz, nil
+}
+
+// commit records offset as the committed offset of group for partition of
+// topic, returning the error code of the partition.
+func (b *MockBroker) commit(group string, topic string, partition int, offset int64) int16 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.partition(topic, partition); !ok {
+		return int16(UnknownTopicOrPartition)
+	}
+
+	topics := b.offsets[group]
+	if topics == nil {
+		topics = make(map[string]map[int]int64)
+		b.offsets[group] = topics
+	}
+	partitions := topics[topic]
+	if partitions == nil {
+		partitions = make(map[int]int64)
+		topics[topic] = partitions
+	}
+	partitions[partition] = offset
+	return 0
+}
+
+func (b *MockBroker) saslHandshake(r *bufio.Reader, sz int) (request, time.Duration, int, error) {
+	var req saslHandshakeRequestV0
+	var res saslHandshakeResponseV0
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	b.mutex.Lock()
+	res.EnabledMechanisms = append([]string{}, b.sasl...)
+	b.mutex.Unlock()
+
+	res.ErrorCode = int16(UnsupportedSASLMechanism)
+	for _, m := range res.EnabledMechanisms {
+		if m == req.Mechanism {
+			res.ErrorCode = 0
+		}
+	}
+
+	return res, 0, sz, nil
+}
+
+func (b *MockBroker) saslAuthenticate(r *bufio.Reader, sz int) (request, time.Duration, int, error) {
+	var req saslAuthenticateRequestV0
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	return saslAuthenticateResponseV0{}, 0, sz, nil
+}
+
+func (b *MockBroker) offsetFetch(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req offsetFetchRequestV1
+	var res offsetFetchResponseV1
+	var throttle time.Duration
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	for _, t := range req.Topics {
+		topic := offsetFetchResponseV1Response{Topic: t.Topic}
+
+		for _, p := range t.Partitions {
+			mock := b.intercept(MockRequest{API: MockOffsetFetch, Topic: t.Topic, Partition: int(p), Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+			if mock.ThrottleTime > throttle {
+				throttle = mock.ThrottleTime
+			}
+
+			partition := offsetFetchResponseV1PartitionResponse{Partition: p, Offset: -1}
+			if mock.Error != 0 {
+				partition.ErrorCode = int16(mock.Error)
+			} else {
+				b.mutex.Lock()
+				if offset, ok := b.offsets[req.GroupID][t.Topic][int(p)]; ok {
+					partition.Offset = offset
+				}
+				b.mutex.Unlock()
+			}
+
+			topic.PartitionResponses = append(topic.PartitionResponses, partition)
+		}
+
+		res.Responses = append(res.Responses, topic)
+	}
+
+	return res, throttle, sz, nil
+}
+
+// mockGroup is a consumer group coordinated by the broker. Like kafka, a
+// rebalance starts when a member joins or leaves the group, or when its session
+// times out, and completes once all the members rejoined the group, or after
+// the rebalance timeout, which evicts the members that did not. The leader of
+// the group then sends the assignments of the members in its SyncGroup request.
+type mockGroup struct {
+	generation int32
+	protocol   string
+	leader     string
+	members    map[string]*mockGroupMember
+	ids        int
+
+	// rebalance is the rebalance in progress, or nil if the members are not
+	// joining the group.
+	rebalance *mockRebalance
+
+	// synced is closed when the leader sends the assignments of the
+	// generation, or when a rebalance starts before it did. stable is true
+	// once the assignments were received.
+	synced chan struct{}
+	stable bool
+}
+
+type mockGroupMember struct {
+	protocols        []joinGroupRequestGroupProtocolV1
+	sessionTimeout   time.Duration
+	rebalanceTimeout time.Duration
+	heartbeat        time.Time
+	assignment       []byte
+}
+
+// mockRebalance holds the members which joined the group during a rebalance,
+// and their JoinGroup responses once it completed.
+type mockRebalance struct {
+	done      chan struct{}
+	joined    map[string]bool
+	responses map[string]joinGroupResponseV1
+	timer     *time.Timer
+}
+
+// group returns the consumer group named id, creating it if it does not exist.
+// The mutex must be held.
+func (b *MockBroker) group(id string) *mockGroup {
+	g := b.groups[id]
+	if g == nil {
+		g = &mockGroup{members: make(map[string]*mockGroupMember), stable: true}
+		b.groups[id] = g
+	}
+	return g
+}
+
+// startRebalance requires the members of g to join the group again, unless a
+// rebalance is already in progress. The mutex must be held.
+func (b *MockBroker) startRebalance(g *mockGroup) {
+	if g.rebalance != nil {
+		return
+	}
+
+	if !g.stable {
+		close(g.synced)
+		g.stable = true
+	}
+
+	var timeout time.Duration
+	for _, member := range g.members {
+		if member.rebalanceTimeout > timeout {
+			timeout = member.rebalanceTimeout
+		}
+	}
+
+	rebalance := &mockRebalance{
+		done:      make(chan struct{}),
+		joined:    make(map[string]bool),
+		responses: make(map[string]joinGroupResponseV1),
+	}
+	rebalance.timer = time.AfterFunc(timeout, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if g.rebalance == rebalance {
+			b.completeRebalance(g)
+		}
+	})
+	g.rebalance = rebalance
+}
+
+// joined completes the rebalance in progress if all the members of g joined
+// the group. The mutex must be held.
+func (b *MockBroker) joined(g *mockGroup) {
+	if g.rebalance != nil && len(g.rebalance.joined) == len(g.members) {
+		b.completeRebalance(g)
+	}
+}
+
+// completeRebalance evicts the members of g which did not join the group during
+// the rebalance in progress, starts the next generation of the group, and
+// responds to the JoinGroup requests of the members. The mutex must be held.
+func (b *MockBroker) completeRebalance(g *mockGroup) {
+	rebalance := g.rebalance
+	rebalance.timer.Stop()
+	g.rebalance = nil
+
+	ids := make([]string, 0, len(g.members))
+	for id := range g.members {
+		if rebalance.joined[id] {
+			ids = append(ids, id)
+		} else {
+			delete(g.members, id)
+		}
+	}
+	sort.Strings(ids)
+
+	g.generation++
+	defer close(rebalance.done)
+
+	if len(ids) == 0 {
+		g.leader, g.protocol = "", ""
+		return
+	}
+
+	if _, ok := g.members[g.leader]; !ok {
+		g.leader = ids[0]
+	}
+
+	// Like kafka, the protocol is the first protocol of the leader which is
+	// supported by all the members.
+	g.protocol = ""
+	for _, p := range g.members[g.leader].protocols {
+		supported := true
+		for _, id := range ids {
+			if g.members[id].metadata(p.ProtocolName) == nil {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			g.protocol = p.ProtocolName
+			break
+		}
+	}
+
+	if g.protocol == "" {
+		for _, id := range ids {
+			rebalance.responses[id] = joinGroupResponseV1{ErrorCode: int16(InconsistentGroupProtocol)}
+			delete(g.members, id)
+		}
+		g.leader = ""
+		return
+	}
+
+	g.synced, g.stable = make(chan struct{}), false
+	for _, id := range ids {
+		res := joinGroupResponseV1{
+			GenerationID:  g.generation,
+			GroupProtocol: g.protocol,
+			LeaderID:      g.leader,
+			MemberID:      id,
+		}
+		if id == g.leader {
+			for _, member := range ids {
+				res.Members = append(res.Members, joinGroupResponseMemberV1{
+					MemberID:       member,
+					MemberMetadata: g.members[member].metadata(g.protocol),
+				})
+			}
+		}
+		g.members[id].assignment = nil
+		rebalance.responses[id] = res
+	}
+}
+
+// metadata returns the metadata of the member for protocol, or nil if the
+// member does not support it.
+func (m *mockGroupMember) metadata(protocol string) []byte {
+	for _, p := range m.protocols {
+		if p.ProtocolName == protocol {
+			if p.ProtocolMetadata == nil {
+				return []byte{}
+			}
+			return p.ProtocolMetadata
+		}
+	}
+	return nil
+}
+
+// expire evicts the members of g which did not send heartbeats during their
+// session timeout, starting a rebalance if any did. The members waiting for a
+// rebalance to complete are not evicted. The mutex must be held.
+func (b *MockBroker) expire(g *mockGroup, now time.Time) {
+	expired := false
+	for id, member := range g.members {
+		if g.rebalance != nil && g.rebalance.joined[id] {
+			continue
+		}
+		if now.Sub(member.heartbeat) > member.sessionTimeout {
+			delete(g.members, id)
+			expired = true
+		}
+	}
+	if expired {
+		if len(g.members) != 0 {
+			b.startRebalance(g)
+		}
+		b.joined(g)
+	}
+}
+
+func (b *MockBroker) joinGroup(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req joinGroupRequestV1
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mock := b.intercept(MockRequest{API: MockJoinGroup, Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+	if mock.Error != 0 {
+		return joinGroupResponseV1{ErrorCode: int16(mock.Error)}, mock.ThrottleTime, sz, nil
+	}
+
+	b.mutex.Lock()
+	now := time.Now()
+	g := b.group(req.GroupID)
+	b.expire(g, now)
+
+	memberID := req.MemberID
+	member := g.members[memberID]
+	switch {
+	case memberID == "":
+		g.ids++
+		memberID = client.id + "-" + strconv.Itoa(g.ids)
+		member = &mockGroupMember{}
+		g.members[memberID] = member
+	case member == nil:
+		b.mutex.Unlock()
+		return joinGroupResponseV1{ErrorCode: int16(UnknownMemberId)}, mock.ThrottleTime, sz, nil
+	}
+
+	member.protocols = req.GroupProtocols
+	member.sessionTimeout = duration(req.SessionTimeout)
+	member.rebalanceTimeout = duration(req.RebalanceTimeout)
+	member.heartbeat = now
+
+	b.startRebalance(g)
+	rebalance := g.rebalance
+	rebalance.joined[memberID] = true
+	b.joined(g)
+	b.mutex.Unlock()
+
+	select {
+	case <-rebalance.done:
+	case <-b.done:
+		return nil, 0, sz, errMockClosed
+	}
+
+	b.mutex.Lock()
+	res, ok := rebalance.responses[memberID]
+	b.mutex.Unlock()
+
+	if !ok {
+		res.ErrorCode = int16(UnknownMemberId)
+	}
+	return res, mock.ThrottleTime, sz, nil
+}
+
+func (b *MockBroker) syncGroup(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req syncGroupRequestV0
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mock := b.intercept(MockRequest{API: MockSyncGroup, Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+	if mock.Error != 0 {
+		return syncGroupResponseV0{ErrorCode: int16(mock.Error)}, mock.ThrottleTime, sz, nil
+	}
+
+	b.mutex.Lock()
+	g := b.group(req.GroupID)
+	if code := b.checkMember(g, req.GenerationID, req.MemberID); code != 0 {
+		b.mutex.Unlock()
+		return syncGroupResponseV0{ErrorCode: code}, mock.ThrottleTime, sz, nil
+	}
+	g.members[req.MemberID].heartbeat = time.Now()
+
+	if req.MemberID == g.leader && !g.stable {
+		for _, a := range req.GroupAssignments {
+			if member, ok := g.members[a.MemberID]; ok {
+				member.assignment = a.MemberAssignments
+			}
+		}
+		close(g.synced)
+		g.stable = true
+	}
+	synced, generation := g.synced, g.generation
+	b.mutex.Unlock()
+
+	select {
+	case <-synced:
+	case <-b.done:
+		return nil, 0, sz, errMockClosed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// A rebalance which started before the leader sent the assignments also
+	// closes the channel.
+	if g.rebalance != nil || g.generation != generation {
+		return syncGroupResponseV0{ErrorCode: int16(RebalanceInProgress)}, mock.ThrottleTime, sz, nil
+	}
+	res := syncGroupResponseV0{MemberAssignments: []byte{}}
+	if member, ok := g.members[req.MemberID]; ok && member.assignment != nil {
+		res.MemberAssignments = member.assignment
+	}
+	return res, mock.ThrottleTime, sz, nil
+}
+
+func (b *MockBroker) heartbeat(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req heartbeatRequestV0
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mock := b.intercept(MockRequest{API: MockHeartbeat, Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+	if mock.Error != 0 {
+		return heartbeatResponseV0{ErrorCode: int16(mock.Error)}, mock.ThrottleTime, sz, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	g := b.group(req.GroupID)
+	b.expire(g, now)
+
+	code := b.checkMember(g, req.GenerationID, req.MemberID)
+	if code == 0 {
+		g.members[req.MemberID].heartbeat = now
+	}
+	return heartbeatResponseV0{ErrorCode: code}, mock.ThrottleTime, sz, nil
+}
+
+func (b *MockBroker) leaveGroup(r *bufio.Reader, sz int, client mockClient) (request, time.Duration, int, error) {
+	var req leaveGroupRequestV0
+
+	sz, err := read(r, sz, &req)
+	if err != nil {
+		return nil, 0, sz, err
+	}
+
+	mock := b.intercept(MockRequest{API: MockLeaveGroup, Group: req.GroupID, ClientID: client.id, Node: int(client.node)})
+	if mock.Error != 0 {
+		return leaveGroupResponseV0{ErrorCode: int16(mock.Error)}, mock.ThrottleTime, sz, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	g := b.group(req.GroupID)
+	if _, ok := g.members[req.MemberID]; !ok {
+		return leaveGroupResponseV0{ErrorCode: int16(UnknownMemberId)}, mock.ThrottleTime, sz, nil
+	}
+	delete(g.members, req.MemberID)
+
+	if g.rebalance != nil {
+		delete(g.rebalance.joined, req.MemberID)
+	} else if len(g.members) != 0 {
+		b.startRebalance(g)
+	} else if !g.stable {
+		close(g.synced)
+		g.stable = true
+	}
+	b.joined(g)
+
+	return leaveGroupResponseV0{}, mock.ThrottleTime, sz, nil
+}
+
+// checkMember returns the error code of a request sent by a member of g for
+// a generation of the group. The mutex must be held.
+func (b *MockBroker) checkMember(g *mockGroup, generation int32, memberID string) int16 {
+	switch {
+	case g.members[memberID] == nil:
+		return int16(UnknownMemberId)
+	case g.rebalance != nil:
+		return int16(RebalanceInProgress)
+	case generation != g.generation:
+		return int16(IllegalGeneration)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,307 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMockBroker(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchTimeout: 10 * time.Millisecond,
+	})
+
+	msgs := make([]Message, 10)
+	for i := range msgs {
+		msgs[i] = Message{Key: []byte(strconv.Itoa(i)), Value: []byte("value-" + strconv.Itoa(i))}
+	}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	written := broker.Messages("test", 0)
+	if n := len(written) + len(broker.Messages("test", 1)); n != len(msgs) {
+		t.Fatalf("expected %d messages to be written; got %d", len(msgs), n)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:   []string{broker.Addr()},
+		Topic:     "test",
+		Partition: 0,
+		MaxWait:   100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	for i, expected := range written {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != int64(i) || string(m.Key) != string(expected.Key) || string(m.Value) != string(expected.Value) {
+			t.Errorf("message %d mismatch: expected %s=%s at offset %d; got %s=%s at offset %d",
+				i, expected.Key, expected.Value, i, m.Key, m.Value, m.Offset)
+		}
+	}
+}
+
+func TestMockBrokerFetchVersions(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.WriteMessages(
+		Message{Value: []byte("A"), Headers: []Header{{Key: "k", Value: []byte("v")}}},
+		Message{Value: []byte("B"), Headers: []Header{{Key: "k", Value: []byte("v")}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []apiVersion{v2, v5, v9, v11} {
+		t.Run("v"+strconv.Itoa(int(version)), func(t *testing.T) {
+			conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			conn.fetchVersion = version
+
+			// Each message is read by a separate request, which are part of
+			// the same fetch session with versions v9 and above.
+			for _, value := range []string{"A", "B"} {
+				batch := conn.ReadBatchWith(ReadBatchConfig{MinBytes: 1, MaxBytes: 1e6, FetchSession: true})
+				msg, err := batch.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				batch.Close()
+				if string(msg.Value) != value {
+					t.Errorf("expected message %s; got %s", value, msg.Value)
+				}
+				// Fetch responses v2 return the messages in the v1 format,
+				// which has no headers.
+				if headers := len(msg.Headers); (version == v2) != (headers == 0) {
+					t.Errorf("unexpected number of headers: %d", headers)
+				}
+				conn.Seek(msg.Offset+1, SeekAbsolute)
+			}
+		})
+	}
+}
+
+func TestMockBrokerOnRequest(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	var failures int32 = 1
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce && atomic.AddInt32(&failures, -1) >= 0 {
+			return MockResponse{Error: NotLeaderForPartition}
+		}
+		if req.API == MockListOffsets {
+			return MockResponse{ThrottleTime: 50 * time.Millisecond}
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != NotLeaderForPartition {
+		t.Fatalf("expected %v; got %v", NotLeaderForPartition, err)
+	}
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	offset, err := conn.ReadLastOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 1 {
+		t.Errorf("expected the last offset to be 1; got %d", offset)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the response to be throttled; got it after %s", elapsed)
+	}
+}
+
+func TestMockBrokerClientID(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	var mutex sync.Mutex
+	clientIDs := make(map[MockAPI][]string)
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		mutex.Lock()
+		clientIDs[req.API] = append(clientIDs[req.API], req.ClientID)
+		mutex.Unlock()
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dialer := &Dialer{ClientID: "test-service"}
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		Dialer:       dialer,
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	if err := w.WriteMessages(ctx, Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		Dialer:  dialer,
+		MaxWait: 100 * time.Millisecond,
+	})
+	if _, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, api := range []MockAPI{MockProduce, MockFetch, MockMetadata} {
+		if len(clientIDs[api]) == 0 {
+			t.Errorf("no %s requests were received", api)
+		}
+		for _, clientID := range clientIDs[api] {
+			if clientID != dialer.ClientID {
+				t.Errorf("expected %s requests to be sent by %q; got %q", api, dialer.ClientID, clientID)
+			}
+		}
+	}
+}
+
+func TestMockBrokerConsumerGroup(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	for partition := 0; partition < 2; partition++ {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.WriteMessages(Message{Value: []byte(strconv.Itoa(partition))})
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newReader := func(assigned chan<- []int) *Reader {
+		return NewReader(ReaderConfig{
+			Brokers:           []string{broker.Addr()},
+			GroupID:           "group",
+			Topic:             "test",
+			MaxWait:           10 * time.Millisecond,
+			HeartbeatInterval: 50 * time.Millisecond,
+			RebalanceTimeout:  5 * time.Second,
+			OnPartitionsAssigned: func(ctx context.Context, partitions map[string][]int) {
+				assigned <- partitions["test"]
+			},
+		})
+	}
+
+	expect := func(assigned <-chan []int, count int) {
+		t.Helper()
+		select {
+		case partitions := <-assigned:
+			if len(partitions) != count {
+				t.Errorf("expected %d partitions to be assigned; got %v", count, partitions)
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the partitions to be assigned")
+		}
+	}
+
+	assigned1 := make(chan []int, 10)
+	r1 := newReader(assigned1)
+	defer r1.Close()
+
+	expect(assigned1, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := r1.ReadMessage(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first member rejoins the group when its heartbeats report the
+	// rebalance, and the partitions are split between the members.
+	assigned2 := make(chan []int, 10)
+	r2 := newReader(assigned2)
+	expect(assigned2, 1)
+	expect(assigned1, 1)
+
+	// The partitions are given back to the first member when the second one
+	// leaves the group.
+	if err := r2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect(assigned1, 2)
+
+	if stats := r1.Stats(); stats.Rebalances != 3 {
+		t.Errorf("expected 3 rebalances; got %d", stats.Rebalances)
+	}
+}
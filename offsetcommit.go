@@ -165,3 +165,150 @@ func (t *offsetCommitResponseV2) readFrom(r *bufio.Reader, size int) (remain int
 
 	return
 }
+
+type offsetCommitRequestV6Partition struct {
+	// Partition ID
+	Partition int32
+
+	// Offset to be committed
+	Offset int64
+
+	// LeaderEpoch holds the leader epoch of the partition when the offset was
+	// consumed, or -1 if unknown
+	LeaderEpoch int32
+
+	// Metadata holds any associated metadata the client wants to keep
+	Metadata string
+}
+
+func (t offsetCommitRequestV6Partition) size() int32 {
+	return sizeofInt32(t.Partition) +
+		sizeofInt64(t.Offset) +
+		sizeofInt32(t.LeaderEpoch) +
+		sizeofString(t.Metadata)
+}
+
+func (t offsetCommitRequestV6Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.Partition)
+	writeInt64(w, t.Offset)
+	writeInt32(w, t.LeaderEpoch)
+	writeString(w, t.Metadata)
+}
+
+type offsetCommitRequestV6Topic struct {
+	// Topic name
+	Topic string
+
+	// Partitions to commit offsets
+	Partitions []offsetCommitRequestV6Partition
+}
+
+func (t offsetCommitRequestV6Topic) size() int32 {
+	return sizeofString(t.Topic) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t offsetCommitRequestV6Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Topic)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+// offsetCommitRequestV6 adds the leader epoch of the committed offsets, it
+// doesn't carry the retention time anymore, the brokers retain the offsets of
+// a group for the duration configured by offsets.retention.minutes once the
+// group is empty.
+type offsetCommitRequestV6 struct {
+	// GroupID holds the unique group identifier
+	GroupID string
+
+	// GenerationID holds the generation of the group.
+	GenerationID int32
+
+	// MemberID assigned by the group coordinator
+	MemberID string
+
+	// Topics to commit offsets
+	Topics []offsetCommitRequestV6Topic
+}
+
+func (t offsetCommitRequestV6) size() int32 {
+	return sizeofString(t.GroupID) +
+		sizeofInt32(t.GenerationID) +
+		sizeofString(t.MemberID) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t offsetCommitRequestV6) writeTo(w *bufio.Writer) {
+	writeString(w, t.GroupID)
+	writeInt32(w, t.GenerationID)
+	writeString(w, t.MemberID)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+// toV6 converts the request to v6, leaderEpoch returns the leader epoch to
+// commit for each partition.
+func (t offsetCommitRequestV2) toV6(leaderEpoch func(topic string, partition int32) int32) offsetCommitRequestV6 {
+	request := offsetCommitRequestV6{
+		GroupID:      t.GroupID,
+		GenerationID: t.GenerationID,
+		MemberID:     t.MemberID,
+		Topics:       make([]offsetCommitRequestV6Topic, len(t.Topics)),
+	}
+
+	for i, topic := range t.Topics {
+		partitions := make([]offsetCommitRequestV6Partition, len(topic.Partitions))
+		for j, p := range topic.Partitions {
+			partitions[j] = offsetCommitRequestV6Partition{
+				Partition:   p.Partition,
+				Offset:      p.Offset,
+				LeaderEpoch: leaderEpoch(topic.Topic, p.Partition),
+				Metadata:    p.Metadata,
+			}
+		}
+		request.Topics[i] = offsetCommitRequestV6Topic{
+			Topic:      topic.Topic,
+			Partitions: partitions,
+		}
+	}
+
+	return request
+}
+
+type offsetCommitResponseV6 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	Responses []offsetCommitResponseV2Response
+}
+
+func (t offsetCommitResponseV6) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Responses), func(i int) int32 { return t.Responses[i].size() })
+}
+
+func (t offsetCommitResponseV6) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Responses), func(i int) { t.Responses[i].writeTo(w) })
+}
+
+func (t *offsetCommitResponseV6) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, withSize int) (fnRemain int, fnErr error) {
+		item := offsetCommitResponseV2Response{}
+		if fnRemain, fnErr = (&item).readFrom(r, withSize); fnErr != nil {
+			return
+		}
+		t.Responses = append(t.Responses, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
@@ -42,3 +42,80 @@ func TestOffsetCommitResponseV2(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestOffsetCommitResponseV6(t *testing.T) {
+	item := offsetCommitResponseV6{
+		ThrottleTimeMS: 1,
+		Responses: []offsetCommitResponseV2Response{
+			{
+				Topic: "a",
+				PartitionResponses: []offsetCommitResponseV2PartitionResponse{
+					{
+						Partition: 1,
+						ErrorCode: 2,
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found offsetCommitResponseV6
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if remain != 0 {
+		t.Errorf("expected 0 remain, got %v", remain)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Error("expected item and found to be the same")
+		t.FailNow()
+	}
+}
+
+func TestOffsetCommitRequestV2ToV6(t *testing.T) {
+	request := offsetCommitRequestV2{
+		GroupID:       "group",
+		GenerationID:  1,
+		MemberID:      "member",
+		RetentionTime: 1000,
+		Topics: []offsetCommitRequestV2Topic{
+			{
+				Topic: "a",
+				Partitions: []offsetCommitRequestV2Partition{
+					{Partition: 0, Offset: 10},
+					{Partition: 1, Offset: 20, Metadata: "meta"},
+				},
+			},
+		},
+	}
+
+	epochs := leaderEpochs{}
+	epochs.set("a", 1, 5)
+
+	expected := offsetCommitRequestV6{
+		GroupID:      "group",
+		GenerationID: 1,
+		MemberID:     "member",
+		Topics: []offsetCommitRequestV6Topic{
+			{
+				Topic: "a",
+				Partitions: []offsetCommitRequestV6Partition{
+					{Partition: 0, Offset: 10, LeaderEpoch: -1},
+					{Partition: 1, Offset: 20, LeaderEpoch: 5, Metadata: "meta"},
+				},
+			},
+		},
+	}
+
+	if found := request.toV6(epochs.get); !reflect.DeepEqual(expected, found) {
+		t.Errorf("expected %#v; got %#v", expected, found)
+	}
+}
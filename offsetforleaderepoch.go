@@ -0,0 +1,252 @@
+package kafka
+
+import (
+	"bufio"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_OffsetForLeaderEpoch
+type offsetForLeaderEpochRequestV2 struct {
+	// Topics holds the partitions to look up the end offsets of.
+	Topics []offsetForLeaderEpochRequestV2Topic
+}
+
+func (t offsetForLeaderEpochRequestV2) size() int32 {
+	return sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t offsetForLeaderEpochRequestV2) writeTo(w *bufio.Writer) {
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+type offsetForLeaderEpochRequestV2Topic struct {
+	Name       string
+	Partitions []offsetForLeaderEpochRequestV2Partition
+}
+
+func (t offsetForLeaderEpochRequestV2Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t offsetForLeaderEpochRequestV2Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+type offsetForLeaderEpochRequestV2Partition struct {
+	PartitionIndex int32
+
+	// CurrentLeaderEpoch is the epoch of the leader known by the client, which
+	// the broker rejects with FencedLeaderEpoch or UnknownLeaderEpoch if it
+	// doesn't match its own, or -1 to skip the check.
+	CurrentLeaderEpoch int32
+
+	// LeaderEpoch is the epoch to look up the end offset of.
+	LeaderEpoch int32
+}
+
+func (t offsetForLeaderEpochRequestV2Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt32(t.CurrentLeaderEpoch) +
+		sizeofInt32(t.LeaderEpoch)
+}
+
+func (t offsetForLeaderEpochRequestV2Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt32(w, t.CurrentLeaderEpoch)
+	writeInt32(w, t.LeaderEpoch)
+}
+
+type offsetForLeaderEpochResponseV2Partition struct {
+	ErrorCode      int16
+	PartitionIndex int32
+
+	// LeaderEpoch is the largest epoch of the log of the partition which is
+	// not greater than the requested one, or -1 if there is none.
+	LeaderEpoch int32
+
+	// EndOffset is the offset following the last record of LeaderEpoch, or -1
+	// if there is none.
+	EndOffset int64
+}
+
+func (t offsetForLeaderEpochResponseV2Partition) size() int32 {
+	return sizeofInt16(t.ErrorCode) +
+		sizeofInt32(t.PartitionIndex) +
+		sizeofInt32(t.LeaderEpoch) +
+		sizeofInt64(t.EndOffset)
+}
+
+func (t offsetForLeaderEpochResponseV2Partition) writeTo(w *bufio.Writer) {
+	writeInt16(w, t.ErrorCode)
+	writeInt32(w, t.PartitionIndex)
+	writeInt32(w, t.LeaderEpoch)
+	writeInt64(w, t.EndOffset)
+}
+
+func (t *offsetForLeaderEpochResponseV2Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt16(r, size, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readInt32(r, remain, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt32(r, remain, &t.LeaderEpoch); err != nil {
+		return
+	}
+	if remain, err = readInt64(r, remain, &t.EndOffset); err != nil {
+		return
+	}
+	return
+}
+
+type offsetForLeaderEpochResponseV2Topic struct {
+	Name       string
+	Partitions []offsetForLeaderEpochResponseV2Partition
+}
+
+func (t offsetForLeaderEpochResponseV2Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t offsetForLeaderEpochResponseV2Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+func (t *offsetForLeaderEpochResponseV2Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item offsetForLeaderEpochResponseV2Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type offsetForLeaderEpochResponseV2 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// Topics holds the end offsets of the partitions of the request.
+	Topics []offsetForLeaderEpochResponseV2Topic
+}
+
+func (t offsetForLeaderEpochResponseV2) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t offsetForLeaderEpochResponseV2) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+func (t *offsetForLeaderEpochResponseV2) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item offsetForLeaderEpochResponseV2Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// offsetForLeaderEpoch looks up the end offsets of the leader epochs of the
+// partitions of the request.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_OffsetForLeaderEpoch
+func (c *Conn) offsetForLeaderEpoch(request offsetForLeaderEpochRequestV2) (offsetForLeaderEpochResponseV2, error) {
+	var response offsetForLeaderEpochResponseV2
+
+	if _, err := c.negotiatedVersion(offsetForLeaderEpochRequest); err != nil {
+		return response, err
+	}
+
+	err := c.readOperation(
+		offsetForLeaderEpochRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(offsetForLeaderEpochRequest, v2, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return offsetForLeaderEpochResponseV2{}, err
+	}
+
+	return response, nil
+}
+
+// OffsetForLeaderEpoch returns the end offset of epoch in the log of the
+// partition of topic, which is the offset following the last record written
+// while epoch was the leader epoch of the partition (KIP-279, KIP-320).
+//
+// The method also returns the leader epoch that the end offset belongs to,
+// which is the largest epoch of the log not greater than epoch; it differs from
+// epoch when the partition had no leader with that epoch. Both are -1 when the
+// log has no epoch before epoch.
+//
+// After a leader change, a consumer validates its position by looking up the
+// end offset of the epoch of the last record that it consumed: when the end
+// offset is lower than its position, the log was truncated and the consumer
+// must reset its position to the end offset to read the records which
+// replaced the truncated ones.
+//
+// The request must be sent to the leader of the partition (see DialLeader),
+// and is only supported by kafka 2.1 and above.
+func (c *Conn) OffsetForLeaderEpoch(topic string, partition int, epoch int) (endOffset int64, leaderEpoch int, err error) {
+	response, err := c.offsetForLeaderEpoch(offsetForLeaderEpochRequestV2{
+		Topics: []offsetForLeaderEpochRequestV2Topic{{
+			Name: topic,
+			Partitions: []offsetForLeaderEpochRequestV2Partition{{
+				PartitionIndex:     int32(partition),
+				CurrentLeaderEpoch: -1,
+				LeaderEpoch:        int32(epoch),
+			}},
+		}},
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+
+	for _, t := range response.Topics {
+		for _, p := range t.Partitions {
+			if t.Name != topic || p.PartitionIndex != int32(partition) {
+				continue
+			}
+			if p.ErrorCode != 0 {
+				return -1, -1, Error(p.ErrorCode)
+			}
+			return p.EndOffset, int(p.LeaderEpoch), nil
+		}
+	}
+	return -1, -1, UnknownTopicOrPartition
+}
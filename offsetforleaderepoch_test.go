@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOffsetForLeaderEpochResponseV2(t *testing.T) {
+	item := offsetForLeaderEpochResponseV2{
+		ThrottleTimeMS: 1,
+		Topics: []offsetForLeaderEpochResponseV2Topic{
+			{
+				Name: "a",
+				Partitions: []offsetForLeaderEpochResponseV2Partition{
+					{
+						PartitionIndex: 0,
+						LeaderEpoch:    3,
+						EndOffset:      42,
+					},
+					{
+						ErrorCode:      int16(FencedLeaderEpoch),
+						PartitionIndex: 1,
+						LeaderEpoch:    -1,
+						EndOffset:      -1,
+					},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found offsetForLeaderEpochResponseV2
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestOffsetForLeaderEpochRequestV2(t *testing.T) {
+	request := offsetForLeaderEpochRequestV2{
+		Topics: []offsetForLeaderEpochRequestV2Topic{
+			{
+				Name: "a",
+				Partitions: []offsetForLeaderEpochRequestV2Partition{
+					{PartitionIndex: 0, CurrentLeaderEpoch: -1, LeaderEpoch: 3},
+					{PartitionIndex: 1, CurrentLeaderEpoch: 5, LeaderEpoch: 4},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
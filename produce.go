@@ -1,6 +1,151 @@
 package kafka
 
-import "bufio"
+import (
+	"bufio"
+	"errors"
+	"time"
+)
+
+// RequiredAcks is the number of acknowledges from partition replicas that a
+// produce request waits for before the messages are considered written.
+type RequiredAcks int
+
+// The values of the constants are the ones used by the protocol.
+const (
+	// requireNone does not wait for any acknowledgement, the broker does not
+	// even send a response to the produce request. It is the zero-value,
+	// which selects the default RequireAll in WriterConfig and RecordBatch, so
+	// it is not exported and is only selected by setting FireAndForget.
+	requireNone RequiredAcks = 0
+
+	// RequireOne waits for the partition leader only, messages may be lost if
+	// the leader fails before the replicas have copied them.
+	RequireOne RequiredAcks = 1
+
+	// RequireAll waits for all in-sync replicas of the partition to
+	// acknowledge the messages, it is the default.
+	RequireAll RequiredAcks = -1
+)
+
+func (acks RequiredAcks) String() string {
+	switch acks {
+	case RequireAll:
+		return "all"
+	case RequireOne:
+		return "one"
+	case requireNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+var errEmptyRecordBatch = errors.New("cannot produce an empty record batch")
+
+// RecordBatch is a batch of records built by the program and written with
+// Conn.Produce.
+type RecordBatch struct {
+	// The records of the batch. The broker assigns their offsets in the order
+	// of the slice, the Offset field of the records is ignored. Records with
+	// a zero Time are timestamped when the batch is produced.
+	Records []Record
+
+	// If not nil, the records are compressed with the codec.
+	CompressionCodec CompressionCodec
+
+	// The acknowledges required for the batch to be considered written,
+	// RequireAll or RequireOne, defaults to RequireAll.
+	RequiredAcks RequiredAcks
+
+	// If true the batch is written without waiting for any acknowledgement,
+	// overriding RequiredAcks, which is the only way to produce with acks=0.
+	// The broker does not respond and the base offset is unknown, errors
+	// reported by the broker are never seen by the client.
+	FireAndForget bool
+
+	// The time the broker waits for the acknowledges of the replicas. The
+	// default is to use the write deadline of the connection.
+	Timeout time.Duration
+
+	// If not nil, the records are written as part of the ongoing transaction
+	// of the producer, the partition must have been added to the transaction
+	// with Conn.AddPartitionsToTxn first. Transactional batches require
+	// RequireAll.
+	Transaction *TransactionalProducer
+
+	// The sequence number of the first record of a transactional batch. The
+	// sequence of each partition starts at zero after Conn.InitProducerID and
+	// is increased by the number of records of each batch written to it, the
+	// broker rejects batches which are out of sequence.
+	BaseSequence int
+}
+
+// batchProducer holds the producer fields of the record batches written by
+// transactional producers.
+type batchProducer struct {
+	transactionalID string
+	id              int64
+	epoch           int16
+	baseSequence    int32
+}
+
+// Produce writes the records of batch to partition of the connection's topic
+// in a single produce request, returning the offset that the broker assigned
+// to the first record. The connection must be established to the leader of
+// the partition.
+//
+// The write is atomic, either all records of the batch are written or none.
+// Unlike WriteMessages, the records may carry headers, which requires kafka
+// 0.11 or above.
+func (c *Conn) Produce(partition int, batch RecordBatch) (baseOffset int64, err error) {
+	if v, err := c.negotiatedVersion(produceRequest); err != nil || v < v3 {
+		return -1, UnsupportedVersion
+	}
+
+	if len(batch.Records) == 0 {
+		return -1, errEmptyRecordBatch
+	}
+
+	acks := batch.RequiredAcks
+	switch {
+	case batch.FireAndForget:
+		acks = requireNone
+	case acks == 0:
+		acks = RequireAll
+	case acks != RequireAll && acks != RequireOne:
+		return -1, InvalidRequiredAcks
+	}
+
+	now := time.Now()
+	msgs := make([]Message, len(batch.Records))
+	for i, r := range batch.Records {
+		msgs[i] = Message{
+			Key:     r.Key,
+			Value:   r.Value,
+			Headers: r.Headers,
+			Time:    r.Time,
+		}
+		if r.Time.IsZero() {
+			msgs[i].Time = now
+		}
+	}
+
+	var producer *batchProducer
+	if txn := batch.Transaction; txn != nil {
+		producer = &batchProducer{
+			transactionalID: txn.TransactionalID,
+			id:              txn.ProducerID,
+			epoch:           txn.ProducerEpoch,
+			baseSequence:    int32(batch.BaseSequence),
+		}
+	}
+
+	baseOffset, _, err = c.produce(v3, batch.CompressionCodec, int32(partition), batch.Timeout, int16(acks), producer, msgs...)
+	if err != nil {
+		return -1, err
+	}
+	return baseOffset, nil
+}
 
 type produceRequestV2 struct {
 	RequiredAcks int16
@@ -50,17 +195,17 @@ func (p produceRequestPartitionV2) writeTo(w *bufio.Writer) {
 }
 
 type produceResponseV2 struct {
-	ThrottleTime int32
 	Topics       []produceResponseTopicV2
+	ThrottleTime int32
 }
 
 func (r produceResponseV2) size() int32 {
-	return 4 + sizeofArray(len(r.Topics), func(i int) int32 { return r.Topics[i].size() })
+	return sizeofArray(len(r.Topics), func(i int) int32 { return r.Topics[i].size() }) + 4
 }
 
 func (r produceResponseV2) writeTo(w *bufio.Writer) {
-	writeInt32(w, r.ThrottleTime)
 	writeArray(w, len(r.Topics), func(i int) { r.Topics[i].writeTo(w) })
+	writeInt32(w, r.ThrottleTime)
 }
 
 type produceResponseTopicV2 struct {
@@ -9,34 +9,53 @@ import (
 type apiKey int16
 
 const (
-	produceRequest          apiKey = 0
-	fetchRequest            apiKey = 1
-	listOffsetRequest       apiKey = 2
-	metadataRequest         apiKey = 3
-	offsetCommitRequest     apiKey = 8
-	offsetFetchRequest      apiKey = 9
-	groupCoordinatorRequest apiKey = 10
-	joinGroupRequest        apiKey = 11
-	heartbeatRequest        apiKey = 12
-	leaveGroupRequest       apiKey = 13
-	syncGroupRequest        apiKey = 14
-	describeGroupsRequest   apiKey = 15
-	listGroupsRequest       apiKey = 16
-	saslHandshakeRequest    apiKey = 17
-	apiVersionsRequest      apiKey = 18
-	createTopicsRequest     apiKey = 19
-	deleteTopicsRequest     apiKey = 20
-	saslAuthenticateRequest apiKey = 36
+	produceRequest                     apiKey = 0
+	fetchRequest                       apiKey = 1
+	listOffsetRequest                  apiKey = 2
+	metadataRequest                    apiKey = 3
+	offsetCommitRequest                apiKey = 8
+	offsetFetchRequest                 apiKey = 9
+	groupCoordinatorRequest            apiKey = 10
+	joinGroupRequest                   apiKey = 11
+	heartbeatRequest                   apiKey = 12
+	leaveGroupRequest                  apiKey = 13
+	syncGroupRequest                   apiKey = 14
+	describeGroupsRequest              apiKey = 15
+	listGroupsRequest                  apiKey = 16
+	saslHandshakeRequest               apiKey = 17
+	apiVersionsRequest                 apiKey = 18
+	createTopicsRequest                apiKey = 19
+	deleteTopicsRequest                apiKey = 20
+	deleteRecordsRequest               apiKey = 21
+	initProducerIDRequest              apiKey = 22
+	offsetForLeaderEpochRequest        apiKey = 23
+	addPartitionsToTxnRequest          apiKey = 24
+	addOffsetsToTxnRequest             apiKey = 25
+	endTxnRequest                      apiKey = 26
+	txnOffsetCommitRequest             apiKey = 28
+	describeAclsRequest                apiKey = 29
+	createAclsRequest                  apiKey = 30
+	deleteAclsRequest                  apiKey = 31
+	describeLogDirsRequest             apiKey = 35
+	saslAuthenticateRequest            apiKey = 36
+	electLeadersRequest                apiKey = 43
+	alterPartitionReassignmentsRequest apiKey = 45
+	listPartitionReassignmentsRequest  apiKey = 46
+	getTelemetrySubscriptionsRequest   apiKey = 71
 )
 
 type apiVersion int16
 
 const (
-	v0 apiVersion = 0
-	v1 apiVersion = 1
-	v2 apiVersion = 2
-	v3 apiVersion = 3
-	v5 apiVersion = 5
+	v0  apiVersion = 0
+	v1  apiVersion = 1
+	v2  apiVersion = 2
+	v3  apiVersion = 3
+	v5  apiVersion = 5
+	v6  apiVersion = 6
+	v7  apiVersion = 7
+	v9  apiVersion = 9
+	v11 apiVersion = 11
 )
 
 type requestHeader struct {
@@ -55,6 +55,32 @@ func TestProtocol(t *testing.T) {
 			},
 		},
 
+		topicMetadataRequestV7{
+			Topics:                 []string{"A", "B", "C"},
+			AllowAutoTopicCreation: true,
+		},
+
+		metadataResponseV7{
+			ThrottleTimeMS: 1,
+			Brokers: []brokerMetadataV1{
+				{NodeID: 1, Host: "localhost", Port: 9001},
+				{NodeID: 2, Host: "localhost", Port: 9002, Rack: "rack2"},
+			},
+			ClusterID:    "cluster",
+			ControllerID: 2,
+			Topics: []topicMetadataV7{
+				{TopicErrorCode: 0, Internal: true, Partitions: []partitionMetadataV7{{
+					PartitionErrorCode: 0,
+					PartitionID:        1,
+					Leader:             2,
+					LeaderEpoch:        3,
+					Replicas:           []int32{1},
+					Isr:                []int32{1},
+					OfflineReplicas:    []int32{2},
+				}}},
+			},
+		},
+
 		listOffsetRequestV1{
 			ReplicaID: 1,
 			Topics: []listOffsetRequestTopicV1{
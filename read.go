@@ -110,11 +110,17 @@ func readBytesWith(r *bufio.Reader, sz int, cb func(*bufio.Reader, int, int) (in
 	return cb(r, sz, n)
 }
 
+// readNewBytes reads n bytes in a new slice, it returns nil when n is negative,
+// which is how null bytes are encoded, and an empty slice when n is zero.
 func readNewBytes(r *bufio.Reader, sz int, n int) ([]byte, int, error) {
 	var err error
 	var b []byte
 	var shortRead bool
 
+	if n == 0 {
+		b = []byte{}
+	}
+
 	if n > 0 {
 		if sz < n {
 			n = sz
@@ -134,6 +140,123 @@ func readNewBytes(r *bufio.Reader, sz int, n int) ([]byte, int, error) {
 	return b, sz, err
 }
 
+// readBytesInto is like readNewBytes but reuses the memory of b when it has
+// enough capacity to hold the n bytes, the returned slice aliases b in that
+// case.
+func readBytesInto(r *bufio.Reader, sz int, n int, b []byte) ([]byte, int, error) {
+	var err error
+	var shortRead bool
+
+	if n < 0 {
+		return nil, sz, nil
+	}
+
+	if sz < n {
+		n = sz
+		shortRead = true
+	}
+
+	if cap(b) < n || b == nil {
+		b = make([]byte, n)
+	}
+
+	n, err = io.ReadFull(r, b[:n])
+	b = b[:n]
+	sz -= n
+
+	if err == nil && shortRead {
+		err = errShortRead
+	}
+
+	return b, sz, err
+}
+
+// readUnsignedVarInt reads an integer in the unsigned varint encoding of the
+// flexible versions of the responses (KIP-482).
+func readUnsignedVarInt(r *bufio.Reader, sz int, v *uint64) (remain int, err error) {
+	*v = 0
+	remain = sz
+	for l, done := 0, false; !done && err == nil; l++ {
+		remain, err = peekRead(r, remain, 1, func(b []byte) {
+			done = b[0]&0x80 == 0
+			*v |= uint64(b[0]&0x7f) << uint(l*7)
+		})
+	}
+	return
+}
+
+// readCompactString reads a compact string, null strings are read as empty.
+func readCompactString(r *bufio.Reader, sz int, v *string) (remain int, err error) {
+	var n uint64
+	if remain, err = readUnsignedVarInt(r, sz, &n); err != nil {
+		return
+	}
+	if n == 0 {
+		*v = ""
+		return
+	}
+	if int(n-1) > remain {
+		return remain, errShortRead
+	}
+	*v, remain, err = readNewString(r, remain, int(n-1))
+	return
+}
+
+// readCompactArrayWith calls cb for each element of a compact array, null
+// arrays are read as empty.
+func readCompactArrayWith(r *bufio.Reader, sz int, cb func(*bufio.Reader, int) (int, error)) (remain int, err error) {
+	var n uint64
+	if remain, err = readUnsignedVarInt(r, sz, &n); err != nil {
+		return
+	}
+	for i := 1; i < int(n); i++ {
+		if remain, err = cb(r, remain); err != nil {
+			break
+		}
+	}
+	return
+}
+
+// readCompactInt32Array reads a compact array of int32, null arrays are read
+// as nil.
+func readCompactInt32Array(r *bufio.Reader, sz int, v *[]int32) (remain int, err error) {
+	var a []int32
+	remain, err = readCompactArrayWith(r, sz, func(r *bufio.Reader, sz int) (int, error) {
+		var i int32
+		sz, err := readInt32(r, sz, &i)
+		a = append(a, i)
+		return sz, err
+	})
+	*v = a
+	return
+}
+
+func readUUID(r *bufio.Reader, sz int, v *uuid) (int, error) {
+	return peekRead(r, sz, 16, func(b []byte) { copy(v[:], b) })
+}
+
+// discardTaggedFields skips the tagged fields which end the structures of the
+// flexible versions of the responses, the client does not use any.
+func discardTaggedFields(r *bufio.Reader, sz int) (remain int, err error) {
+	var n uint64
+	if remain, err = readUnsignedVarInt(r, sz, &n); err != nil {
+		return
+	}
+	for ; n > 0; n-- {
+		var tag, size uint64
+		if remain, err = readUnsignedVarInt(r, remain, &tag); err != nil {
+			return
+		}
+		if remain, err = readUnsignedVarInt(r, remain, &size); err != nil {
+			return
+		}
+		if remain, err = discardN(r, remain, int(size)); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func readArrayLen(r *bufio.Reader, sz int, n *int) (int, error) {
 	var err error
 	var len int32
@@ -368,7 +491,79 @@ func readFetchResponseHeaderV2(r *bufio.Reader, size int) (throttle int32, water
 	return
 }
 
-func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, watermark int64, remain int, err error) {
+func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, watermark int64, logStartOffset int64, remain int, err error) {
+	if remain, err = readInt32(r, size, &throttle); err != nil {
+		return
+	}
+
+	watermark, logStartOffset, _, remain, err = readFetchResponseTopicsV5(r, remain, v5)
+	return
+}
+
+// readFetchResponseHeaderV9 reads the header of a fetch response v9, which
+// differs from v5 by the error code and fetch session ID following the
+// throttle time.
+func readFetchResponseHeaderV9(r *bufio.Reader, size int) (throttle int32, sessionID int32, watermark int64, logStartOffset int64, remain int, err error) {
+	var errorCode int16
+
+	if remain, err = readInt32(r, size, &throttle); err != nil {
+		return
+	}
+
+	if remain, err = readInt16(r, remain, &errorCode); err != nil {
+		return
+	}
+
+	if remain, err = readInt32(r, remain, &sessionID); err != nil {
+		return
+	}
+
+	if errorCode != 0 {
+		err = Error(errorCode)
+		return
+	}
+
+	watermark, logStartOffset, _, remain, err = readFetchResponseTopicsV5(r, remain, v9)
+	return
+}
+
+// readFetchResponseHeaderV11 reads the header of a fetch response v11, which
+// differs from v9 by the preferred read replica following the aborted
+// transactions of the partition.
+func readFetchResponseHeaderV11(r *bufio.Reader, size int) (throttle int32, sessionID int32, watermark int64, logStartOffset int64, preferredReadReplica int32, remain int, err error) {
+	var errorCode int16
+
+	if remain, err = readInt32(r, size, &throttle); err != nil {
+		return
+	}
+
+	if remain, err = readInt16(r, remain, &errorCode); err != nil {
+		return
+	}
+
+	if remain, err = readInt32(r, remain, &sessionID); err != nil {
+		return
+	}
+
+	if errorCode != 0 {
+		err = Error(errorCode)
+		return
+	}
+
+	watermark, logStartOffset, preferredReadReplica, remain, err = readFetchResponseTopicsV5(r, remain, v11)
+	return
+}
+
+// readFetchResponseTopicsV5 reads the topics array of fetch responses v5 and
+// above, up to the message set of the single partition that was requested.
+// The preferred read replica is only part of responses v11 and above, it is -1
+// for older versions.
+//
+// Responses v9 and above may be incremental fetch responses of a fetch session,
+// which omit the partition when it has nothing new, the watermark is then -1.
+func readFetchResponseTopicsV5(r *bufio.Reader, size int, version apiVersion) (watermark int64, logStartOffset int64, preferredReadReplica int32, remain int, err error) {
+	watermark, logStartOffset, preferredReadReplica = -1, -1, -1
+
 	var n int32
 	type AbortedTransaction struct {
 		ProducerId  int64
@@ -384,11 +579,11 @@ func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, water
 	var messageSetSize int32
 	var abortedTransactions []AbortedTransaction
 
-	if remain, err = readInt32(r, size, &throttle); err != nil {
+	if remain, err = readInt32(r, size, &n); err != nil {
 		return
 	}
 
-	if remain, err = readInt32(r, remain, &n); err != nil {
+	if n == 0 && version >= v9 {
 		return
 	}
 
@@ -410,6 +605,10 @@ func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, water
 		return
 	}
 
+	if n == 0 && version >= v9 {
+		return
+	}
+
 	// This error should never trigger, unless there's a bug in the kafka client
 	// or server.
 	if n != 1 {
@@ -437,6 +636,12 @@ func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, water
 		}
 	}
 
+	if version >= v11 {
+		if remain, err = readInt32(r, remain, &preferredReadReplica); err != nil {
+			return
+		}
+	}
+
 	if p.ErrorCode != 0 {
 		err = Error(p.ErrorCode)
 		return
@@ -455,6 +660,7 @@ func readFetchResponseHeaderV5(r *bufio.Reader, size int) (throttle int32, water
 	}
 
 	watermark = p.HighwaterMarkOffset
+	logStartOffset = p.LogStartOffset
 	return
 
 }
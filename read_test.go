@@ -167,3 +167,87 @@ func TestReadNewBytes(t *testing.T) {
 		}
 	})
 }
+
+func TestReadBytesInto(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("foobar")))
+	buf := make([]byte, 0, 8)
+
+	b, remain, err := readBytesInto(r, 6, 3, buf)
+	if string(b) != "foo" || remain != 3 || err != nil {
+		t.Errorf("bad result: %q %d %v", b, remain, err)
+	}
+	if &b[0] != &buf[:1][0] {
+		t.Error("the buffer should have been reused")
+	}
+
+	b, remain, err = readBytesInto(r, remain, -1, buf)
+	if b != nil || remain != 3 || err != nil {
+		t.Errorf("null bytes should return nil: %q %d %v", b, remain, err)
+	}
+
+	b, remain, err = readBytesInto(r, remain, 4, nil)
+	if string(b) != "bar" || remain != 0 || err != errShortRead {
+		t.Errorf("bad result on short read: %q %d %v", b, remain, err)
+	}
+}
+
+func TestReadFetchResponseHeaderV9(t *testing.T) {
+	makeResponse := func(errorCode int16, partitionErrorCode int16) []byte {
+		b := &bytes.Buffer{}
+		w := bufio.NewWriter(b)
+		writeInt32(w, 1)         // throttle time
+		writeInt16(w, errorCode) // error code
+		writeInt32(w, 0)         // session ID
+		writeArrayLen(w, 1)
+		writeString(w, "topic")
+		writeArrayLen(w, 1)
+		writeInt32(w, 0)                  // partition
+		writeInt16(w, partitionErrorCode) // error code
+		writeInt64(w, 42)                 // high watermark
+		writeInt64(w, 42)                 // last stable offset
+		writeInt64(w, 2)                  // log start offset
+		writeArrayLen(w, -1)              // aborted transactions
+		writeInt32(w, 0)                  // message set size
+		w.Flush()
+		return b.Bytes()
+	}
+
+	tests := []struct {
+		scenario           string
+		errorCode          int16
+		partitionErrorCode int16
+		err                error
+	}{
+		{
+			scenario: "no errors",
+		},
+		{
+			scenario:  "response error",
+			errorCode: int16(InvalidFetchSessionEpoch),
+			err:       InvalidFetchSessionEpoch,
+		},
+		{
+			scenario:           "partition error",
+			partitionErrorCode: int16(FencedLeaderEpoch),
+			err:                FencedLeaderEpoch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			b := makeResponse(test.errorCode, test.partitionErrorCode)
+			r := bufio.NewReader(bytes.NewReader(b))
+
+			throttle, _, watermark, logStartOffset, remain, err := readFetchResponseHeaderV9(r, len(b))
+			if err != test.err {
+				t.Fatalf("expected error %v; got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if throttle != 1 || watermark != 42 || logStartOffset != 2 || remain != 0 {
+				t.Errorf("bad header: throttle=%d watermark=%d logStartOffset=%d remain=%d", throttle, watermark, logStartOffset, remain)
+			}
+		})
+	}
+}
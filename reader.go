@@ -9,8 +9,10 @@ import (
 	"io"
 	"log"
 	"math"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,13 +27,30 @@ const (
 	// defaultCommitRetries holds the number commit attempts to make
 	// before giving up
 	defaultCommitRetries = 3
+
+	// maxCorruptBatchAttempts is the number of times partition readers fetch
+	// a corrupted record batch before giving up on the partition.
+	maxCorruptBatchAttempts = 3
 )
 
 var (
 	errOnlyAvailableWithGroup = errors.New("unavailable when GroupID is not set")
 	errNotAvailableWithGroup  = errors.New("unavailable when GroupID is set")
+
+	// errReadReplica is returned by the partition readers when the partition
+	// leader designated another replica to fetch from.
+	errReadReplica = errors.New("the partition leader designated another replica to fetch from")
 )
 
+// ErrFetchInProgress is returned by Reader.SetOffset when called while another
+// goroutine is blocked in a call to FetchMessage or ReadMessage, which would
+// otherwise race to return a message from the previous offset.
+var ErrFetchInProgress = errors.New("cannot set the offset of a reader while a fetch is in progress")
+
+// ErrReadTimeout is returned by the methods of a Reader reading messages when
+// no message was received within ReaderConfig.ReadMessageTimeout.
+var ErrReadTimeout = errors.New("kafka: no message was received before the read timeout")
+
 const (
 	// defaultProtocolType holds the default protocol type documented in the
 	// kafka protocol
@@ -55,6 +74,10 @@ const (
 	// for consumers to issue a join group once a rebalance has been requested
 	defaultRebalanceTimeout = 30 * time.Second
 
+	// defaultCloseTimeout contains the amount of time that the final commit of
+	// the offsets waits for the coordinator when the reader is closed
+	defaultCloseTimeout = 5 * time.Second
+
 	// defaultRetentionTime holds the length of time a the consumer group will be
 	// saved by kafka
 	defaultRetentionTime = time.Hour * 24
@@ -62,6 +85,11 @@ const (
 	// defaultPartitionWatchTime contains the amount of time the kafka-go will wait to
 	// query the brokers looking for partition changes.
 	defaultPartitionWatchTime = 5 * time.Second
+
+	// defaultReadBackoffMin and defaultReadBackoffMax bound the amount of time
+	// a reader waits before retrying to fetch messages after a failure.
+	defaultReadBackoffMin = 100 * time.Millisecond
+	defaultReadBackoffMax = 1 * time.Second
 )
 
 // Reader provides a high-level API for consuming messages from kafka.
@@ -69,6 +97,14 @@ const (
 // A Reader automatically manages reconnections to a kafka server, and
 // blocking methods have context support for asynchronous cancellations.
 type Reader struct {
+	// pollTime and stalledAt are accessed atomically, they are declared first
+	// to ensure 64-bit alignment. pollTime holds the time (in nanoseconds) at
+	// which FetchMessage last returned, or zero while a call is in progress.
+	// stalledAt holds the pollTime value observed when the reader left the
+	// group because MaxProcessingTime was exceeded, zero otherwise.
+	pollTime  int64
+	stalledAt int64
+
 	// immutable fields of the reader
 	config ReaderConfig
 
@@ -85,15 +121,39 @@ type Reader struct {
 	version      int64 // version holds the generation of the spawned readers
 	offset       int64
 	lag          int64
+	fetches      int // number of calls to FetchMessage in progress
 	closed       bool
 	address      string // address of group coordinator
 	generationID int32  // generationID of group
 	memberID     string // memberID of group
+	fenced       error  // set when a static member was fenced by the coordinator
+
+	// groupTopics holds the topics matching GroupTopicRegex when the reader
+	// last joined the group (synchronized on the mutex)
+	groupTopics []string
 
 	// offsetStash should only be managed by the commitLoopInterval.  We store
 	// it here so that it survives rebalances
 	offsetStash offsetStash
 
+	// pollch is signaled when the program calls FetchMessage, it wakes up the
+	// consumer group loop waiting to rejoin after the processing timeout.
+	pollch chan struct{}
+
+	// paused tracks the partitions that the subreaders must not fetch from,
+	// it is shared with the subreaders and survives rebalances.
+	paused pausedPartitions
+
+	// leaderEpochs holds the leader epochs of the partitions that the
+	// subreaders fetched from, which are committed along with the offsets.
+	leaderEpochs leaderEpochs
+
+	// txnConn is the connection to the group coordinator at txnAddress that
+	// CommitMessagesInTxn reuses across calls (synchronized on txnMutex).
+	txnMutex   sync.Mutex
+	txnConn    *Conn
+	txnAddress string
+
 	// reader stats are all made of atomic values, no need for synchronization.
 	once  uint32
 	stctx context.Context
@@ -102,12 +162,40 @@ type Reader struct {
 	stats *readerStats
 }
 
+// logger returns the Logger that the reader reports internal events to.
+func (r *Reader) logger() Logger {
+	return makeLogger(r.config.StructuredLogger, r.config.Logger, r.config.ErrorLogger)
+}
+
 // useConsumerGroup indicates whether the Reader is part of a consumer group.
 func (r *Reader) useConsumerGroup() bool { return r.config.GroupID != "" }
 
+// CommitMode selects whether CommitMessages waits for the offsets to be
+// committed, see ReaderConfig.CommitMode.
+type CommitMode int
+
+const (
+	// CommitSync makes CommitMessages block until the offsets were committed,
+	// and return the error of the commit.
+	CommitSync CommitMode = iota + 1
+
+	// CommitAsync makes CommitMessages return as soon as the offsets were
+	// queued, the errors of the commits are reported to OnCommitError.
+	CommitAsync
+)
+
 // useSyncCommits indicates whether the Reader is configured to perform sync or
 // async commits.
-func (r *Reader) useSyncCommits() bool { return r.config.CommitInterval == 0 }
+func (r *Reader) useSyncCommits() bool {
+	switch r.config.CommitMode {
+	case CommitSync:
+		return true
+	case CommitAsync:
+		return false
+	default:
+		return r.config.CommitInterval == 0
+	}
+}
 
 // membership returns the group generationID and memberID of the reader.
 //
@@ -167,9 +255,7 @@ func (r *Reader) refreshCoordinator() (err error) {
 		r.mutex.Unlock()
 
 		if address != oldAddress {
-			r.withLogger(func(l *log.Logger) {
-				l.Printf("coordinator for group, %v, set to %v\n", r.config.GroupID, address)
-			})
+			r.logger().Info("coordinator changed", "group", r.config.GroupID, "old", oldAddress, "new", address)
 		}
 
 		break
@@ -178,6 +264,24 @@ func (r *Reader) refreshCoordinator() (err error) {
 	return nil
 }
 
+// fence records that the coordinator rejected the reader because another
+// reader joined the group with the same GroupInstanceID. A fenced reader stops
+// participating in the group, rejoining would in turn fence the other reader.
+func (r *Reader) fence(err error) {
+	r.mutex.Lock()
+	r.fenced = err
+	r.mutex.Unlock()
+}
+
+// fencedError returns the error that caused the reader to be fenced, or nil if
+// it was not.
+func (r *Reader) fencedError() error {
+	r.mutex.Lock()
+	err := r.fenced
+	r.mutex.Unlock()
+	return err
+}
+
 // makejoinGroupRequestV1 handles the logic of constructing a joinGroup
 // request
 func (r *Reader) makejoinGroupRequestV1() (joinGroupRequestV1, error) {
@@ -200,7 +304,7 @@ func (r *Reader) makejoinGroupRequestV1() (joinGroupRequestV1, error) {
 			ProtocolName: balancer.ProtocolName(),
 			ProtocolMetadata: groupMetadata{
 				Version:  1,
-				Topics:   []string{r.config.Topic},
+				Topics:   r.topics(),
 				UserData: userData,
 			}.bytes(),
 		})
@@ -211,21 +315,7 @@ func (r *Reader) makejoinGroupRequestV1() (joinGroupRequestV1, error) {
 
 // makeMemberProtocolMetadata maps encoded member metadata ([]byte) into []GroupMember
 func (r *Reader) makeMemberProtocolMetadata(in []joinGroupResponseMemberV1) ([]GroupMember, error) {
-	members := make([]GroupMember, 0, len(in))
-	for _, item := range in {
-		metadata := groupMetadata{}
-		reader := bufio.NewReader(bytes.NewReader(item.MemberMetadata))
-		if remain, err := (&metadata).readFrom(reader, len(item.MemberMetadata)); err != nil || remain != 0 {
-			return nil, fmt.Errorf("unable to read metadata for member, %v: %v\n", item.MemberID, err)
-		}
-
-		members = append(members, GroupMember{
-			ID:       item.MemberID,
-			Topics:   metadata.Topics,
-			UserData: metadata.UserData,
-		})
-	}
-	return members, nil
+	return makeGroupMembers(in)
 }
 
 // partitionReader is an internal interface used to simplify unit testing
@@ -237,9 +327,7 @@ type partitionReader interface {
 // assignTopicPartitions uses the selected GroupBalancer to assign members to
 // their various partitions
 func (r *Reader) assignTopicPartitions(conn partitionReader, group joinGroupResponseV1) (GroupMemberAssignments, error) {
-	r.withLogger(func(l *log.Logger) {
-		l.Println("selected as leader for group,", r.config.GroupID)
-	})
+	r.logger().Info("selected as leader of consumer group", "group", r.config.GroupID)
 
 	balancer, ok := findGroupBalancer(group.GroupProtocol, r.config.GroupBalancers)
 	if !ok {
@@ -251,26 +339,34 @@ func (r *Reader) assignTopicPartitions(conn partitionReader, group joinGroupResp
 		return nil, fmt.Errorf("unable to construct MemberProtocolMetadata: %v", err)
 	}
 
-	topics := extractTopics(members)
-	partitions, err := conn.ReadPartitions(topics...)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read partitions: %v", err)
+	// listing the partitions of no topic would return the partitions of all
+	// topics, which happens when no topic matched GroupTopicRegex.
+	var partitions []Partition
+	if topics := extractTopics(members); len(topics) != 0 {
+		if partitions, err = conn.ReadPartitions(topics...); err != nil {
+			return nil, fmt.Errorf("unable to read partitions: %v", err)
+		}
 	}
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("using '%v' balancer to assign group, %v\n", group.GroupProtocol, r.config.GroupID)
-		for _, member := range members {
-			l.Printf("found member: %v/%#v", member.ID, member.UserData)
-		}
-		for _, partition := range partitions {
-			l.Printf("found topic/partition: %v/%v", partition.Topic, partition.ID)
-		}
-	})
+	r.logger().Info("assigning consumer group partitions", "group", r.config.GroupID, "balancer", group.GroupProtocol)
+	for _, member := range members {
+		r.logger().Debug("found group member", "group", r.config.GroupID, "member", member.ID, "userdata", fmt.Sprintf("%#v", member.UserData))
+	}
+	for _, partition := range partitions {
+		r.logger().Debug("found topic partition", "group", r.config.GroupID, "topic", partition.Topic, "partition", partition.ID)
+	}
 
 	return balancer.AssignGroups(members, partitions), nil
 }
 
 func (r *Reader) leaveGroup(conn *Conn) error {
+	if r.config.GroupInstanceID != "" {
+		// Static members do not leave the group, the coordinator keeps their
+		// partitions until the session times out so they can rejoin without
+		// triggering a rebalance.
+		return nil
+	}
+
 	_, memberID := r.membership()
 	_, err := conn.leaveGroup(leaveGroupRequestV0{
 		GroupID:  r.config.GroupID,
@@ -283,6 +379,29 @@ func (r *Reader) leaveGroup(conn *Conn) error {
 	return nil
 }
 
+// evict leaves the consumer group when the program exceeded MaxProcessingTime.
+// Unlike leaveGroup, static members leave the group too, their partitions are
+// reassigned right away instead of being held until their session times out.
+func (r *Reader) evict(conn *Conn) error {
+	if r.config.GroupInstanceID == "" {
+		return r.leaveGroup(conn)
+	}
+
+	_, memberID := r.membership()
+	response, err := conn.leaveGroupV3(leaveGroupRequestV3{
+		GroupID: r.config.GroupID,
+		Members: []leaveGroupRequestV3Member{
+			{MemberID: memberID, GroupInstanceID: r.config.GroupInstanceID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("leave group failed for group, %v, and member, %v: %v", r.config.GroupID, memberID, err)
+	}
+	r.waitThrottleTime(response.ThrottleTimeMS)
+
+	return nil
+}
+
 // joinGroup attempts to join the reader to the consumer group.
 // Returns GroupMemberAssignments is this Reader was selected as
 // the leader.  Otherwise, GroupMemberAssignments will be nil.
@@ -294,13 +413,14 @@ func (r *Reader) leaveGroup(conn *Conn) error {
 //  * InconsistentGroupProtocol:
 //  * InvalidSessionTimeout:
 //  * GroupAuthorizationFailed:
+//  * FencedInstanceID:
 func (r *Reader) joinGroup(conn *Conn) (GroupMemberAssignments, error) {
 	request, err := r.makejoinGroupRequestV1()
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := conn.joinGroup(request)
+	response, err := r.sendJoinGroup(conn, request)
 	if err != nil {
 		switch err {
 		case UnknownMemberId:
@@ -309,6 +429,10 @@ func (r *Reader) joinGroup(conn *Conn) (GroupMemberAssignments, error) {
 			r.mutex.Unlock()
 			return nil, fmt.Errorf("joinGroup failed: %v", err)
 
+		case FencedInstanceID:
+			r.fence(err)
+			return nil, fmt.Errorf("joinGroup failed: %v", err)
+
 		default:
 			return nil, fmt.Errorf("joinGroup failed: %v", err)
 		}
@@ -323,14 +447,13 @@ func (r *Reader) joinGroup(conn *Conn) (GroupMemberAssignments, error) {
 	r.mutex.Unlock()
 
 	if oldGenerationID != response.GenerationID || oldMemberID != response.MemberID {
-		r.withLogger(func(l *log.Logger) {
-			l.Printf("response membership changed.  generationID: %v => %v, memberID: '%v' => '%v'\n",
-				oldGenerationID,
-				response.GenerationID,
-				oldMemberID,
-				response.MemberID,
-			)
-		})
+		r.logger().Info("consumer group membership changed",
+			"group", r.config.GroupID,
+			"old_generation", oldGenerationID,
+			"new_generation", response.GenerationID,
+			"old_member", oldMemberID,
+			"new_member", response.MemberID,
+		)
 	}
 
 	var assignments GroupMemberAssignments
@@ -342,22 +465,67 @@ func (r *Reader) joinGroup(conn *Conn) (GroupMemberAssignments, error) {
 		}
 		assignments = v
 
-		r.withLogger(func(l *log.Logger) {
-			for memberID, assignment := range assignments {
-				for topic, partitions := range assignment {
-					l.Printf("assigned member/topic/partitions %v/%v/%v\n", memberID, topic, partitions)
-				}
+		for memberID, assignment := range assignments {
+			for topic, partitions := range assignment {
+				r.logger().Info("assigned partitions to group member", "group", r.config.GroupID, "member", memberID, "topic", topic, "partitions", partitions)
 			}
-		})
+		}
 	}
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("joinGroup succeeded for response, %v.  generationID=%v, memberID=%v\n", r.config.GroupID, response.GenerationID, response.MemberID)
-	})
+	r.logger().Info("joined consumer group", "group", r.config.GroupID, "generation", response.GenerationID, "member", response.MemberID)
 
 	return assignments, nil
 }
 
+// sendJoinGroup sends the JoinGroup request to the coordinator, static members
+// use the version of the request carrying the GroupInstanceID.
+func (r *Reader) sendJoinGroup(conn *Conn, request joinGroupRequestV1) (joinGroupResponseV1, error) {
+	if r.config.GroupInstanceID == "" {
+		return conn.joinGroup(request)
+	}
+
+	response, err := conn.joinGroupV5(joinGroupRequestV5{
+		GroupID:          request.GroupID,
+		SessionTimeout:   request.SessionTimeout,
+		RebalanceTimeout: request.RebalanceTimeout,
+		MemberID:         request.MemberID,
+		GroupInstanceID:  r.config.GroupInstanceID,
+		ProtocolType:     request.ProtocolType,
+		GroupProtocols:   request.GroupProtocols,
+	})
+	if err != nil {
+		return joinGroupResponseV1{}, err
+	}
+	r.waitThrottleTime(response.ThrottleTimeMS)
+
+	return response.toV1(), nil
+}
+
+// sendSyncGroup sends the SyncGroup request to the coordinator, static members
+// use the version of the request carrying the GroupInstanceID.
+func (r *Reader) sendSyncGroup(conn *Conn, request syncGroupRequestV0) (syncGroupResponseV0, error) {
+	if r.config.GroupInstanceID == "" {
+		return conn.syncGroups(request)
+	}
+
+	response, err := conn.syncGroupsV3(syncGroupRequestV3{
+		GroupID:          request.GroupID,
+		GenerationID:     request.GenerationID,
+		MemberID:         request.MemberID,
+		GroupInstanceID:  r.config.GroupInstanceID,
+		GroupAssignments: request.GroupAssignments,
+	})
+	if err != nil {
+		return syncGroupResponseV0{}, err
+	}
+	r.waitThrottleTime(response.ThrottleTimeMS)
+
+	return syncGroupResponseV0{
+		ErrorCode:         response.ErrorCode,
+		MemberAssignments: response.MemberAssignments,
+	}, nil
+}
+
 func (r *Reader) makeSyncGroupRequestV0(memberAssignments GroupMemberAssignments) syncGroupRequestV0 {
 	generationID, memberID := r.membership()
 	request := syncGroupRequestV0{
@@ -367,29 +535,9 @@ func (r *Reader) makeSyncGroupRequestV0(memberAssignments GroupMemberAssignments
 	}
 
 	if memberAssignments != nil {
-		request.GroupAssignments = make([]syncGroupRequestGroupAssignmentV0, 0, 1)
-
-		for memberID, topics := range memberAssignments {
-			topics32 := make(map[string][]int32)
-			for topic, partitions := range topics {
-				partitions32 := make([]int32, len(partitions))
-				for i := range partitions {
-					partitions32[i] = int32(partitions[i])
-				}
-				topics32[topic] = partitions32
-			}
-			request.GroupAssignments = append(request.GroupAssignments, syncGroupRequestGroupAssignmentV0{
-				MemberID: memberID,
-				MemberAssignments: groupAssignment{
-					Version: 1,
-					Topics:  topics32,
-				}.bytes(),
-			})
-		}
+		request.GroupAssignments = makeSyncGroupAssignments(memberAssignments)
 
-		r.withErrorLogger(func(logger *log.Logger) {
-			logger.Printf("Syncing %d assignments for generation %d as member %s", len(request.GroupAssignments), generationID, memberID)
-		})
+		r.logger().Debug("syncing consumer group assignments", "group", r.config.GroupID, "assignments", len(request.GroupAssignments), "generation", generationID, "member", memberID)
 	}
 
 	return request
@@ -405,15 +553,20 @@ func (r *Reader) makeSyncGroupRequestV0(memberAssignments GroupMemberAssignments
 //  * IllegalGeneration:
 //  * RebalanceInProgress:
 //  * GroupAuthorizationFailed:
+//  * FencedInstanceID:
 func (r *Reader) syncGroup(conn *Conn, memberAssignments GroupMemberAssignments) (map[string][]int32, error) {
 	request := r.makeSyncGroupRequestV0(memberAssignments)
-	response, err := conn.syncGroups(request)
+	response, err := r.sendSyncGroup(conn, request)
 	if err != nil {
 		switch err {
 		case RebalanceInProgress:
 			// don't leave the group
 			return nil, fmt.Errorf("syncGroup failed: %v", err)
 
+		case FencedInstanceID:
+			r.fence(err)
+			return nil, fmt.Errorf("syncGroup failed: %v", err)
+
 		case UnknownMemberId:
 			r.mutex.Lock()
 			r.memberID = ""
@@ -436,23 +589,16 @@ func (r *Reader) syncGroup(conn *Conn, memberAssignments GroupMemberAssignments)
 
 	if len(assignments.Topics) == 0 {
 		generation, memberID := r.membership()
-		r.withLogger(func(l *log.Logger) {
-			l.Printf("received empty assignments for group, %v as member %s for generation %d", r.config.GroupID, memberID, generation)
-		})
+		r.logger().Warn("received empty assignments", "group", r.config.GroupID, "member", memberID, "generation", generation)
 	}
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("sync group finished for group, %v\n", r.config.GroupID)
-	})
+	r.logger().Info("synced consumer group", "group", r.config.GroupID)
 
 	return assignments.Topics, nil
 }
 
 func (r *Reader) rebalance(conn *Conn) (map[string][]int32, error) {
-	r.stats.rebalances.observe(1)
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("rebalancing consumer group, %v", r.config.GroupID)
-	})
+	r.logger().Info("rebalancing consumer group", "group", r.config.GroupID)
 
 	members, err := r.joinGroup(conn)
 	if err != nil {
@@ -464,6 +610,9 @@ func (r *Reader) rebalance(conn *Conn) (map[string][]int32, error) {
 		return nil, err
 	}
 
+	r.stats.rebalances.observe(1)
+	r.stats.lastRebalance.Store(time.Now())
+
 	return assignments, nil
 }
 
@@ -473,65 +622,98 @@ func (r *Reader) unsubscribe() error {
 	return nil
 }
 
-func (r *Reader) fetchOffsets(conn *Conn, subs map[string][]int32) (map[int]int64, error) {
-	partitions := subs[r.config.Topic]
-	offsets, err := conn.offsetFetch(offsetFetchRequestV1{
-		GroupID: r.config.GroupID,
-		Topics: []offsetFetchRequestV1Topic{
-			{
-				Topic:      r.config.Topic,
-				Partitions: partitions,
-			},
-		},
-	})
+func (r *Reader) fetchOffsets(conn *Conn, subs map[string][]int32) (offsetStash, error) {
+	request := offsetFetchRequestV1{GroupID: r.config.GroupID}
+	for topic, partitions := range subs {
+		request.Topics = append(request.Topics, offsetFetchRequestV1Topic{
+			Topic:      topic,
+			Partitions: partitions,
+		})
+	}
+
+	offsets, err := conn.offsetFetch(request)
 	if err != nil {
 		return nil, err
 	}
 
-	offsetsByPartition := map[int]int64{}
-	for _, pr := range offsets.Responses[0].PartitionResponses {
-		for _, partition := range partitions {
-			if partition == pr.Partition {
-				offset := pr.Offset
-				if offset < 0 {
-					// No offset stored
-					offset = r.config.AutoOffsetReset
+	offsetsByTopicAndPartition := offsetStash{}
+	for _, res := range offsets.Responses {
+		partitions := subs[res.Topic]
+		offsetsByPartition := map[int]int64{}
+		for _, pr := range res.PartitionResponses {
+			for _, partition := range partitions {
+				if partition == pr.Partition {
+					offset := pr.Offset
+					if offset < 0 {
+						// No offset stored
+						offset = r.config.StartOffset
+					}
+					offsetsByPartition[int(partition)] = offset
 				}
-				offsetsByPartition[int(partition)] = offset
 			}
 		}
+		if len(offsetsByPartition) != 0 {
+			offsetsByTopicAndPartition[res.Topic] = offsetsByPartition
+		}
 	}
 
-	return offsetsByPartition, nil
+	return offsetsByTopicAndPartition, nil
 }
 
-func (r *Reader) subscribe(conn *Conn, subs map[string][]int32) error {
-	if len(subs[r.config.Topic]) == 0 {
-		return nil
+// subscribe starts reading the partitions in subs, and returns them in the
+// form passed to the rebalance callbacks of ReaderConfig.
+func (r *Reader) subscribe(conn *Conn, subs map[string][]int32) (map[string][]int, error) {
+	if len(subs) == 0 {
+		return nil, nil
 	}
 
-	offsetsByPartition, err := r.fetchOffsets(conn, subs)
+	offsetsByTopicAndPartition, err := r.fetchOffsets(conn, subs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	partitions := makeTopicPartitions(subs)
+	if r.config.OnPartitionsAssigned != nil {
+		r.config.OnPartitionsAssigned(r.stctx, partitions)
 	}
 
 	r.mutex.Lock()
-	r.start(offsetsByPartition)
+	r.start(offsetsByTopicAndPartition)
 	r.mutex.Unlock()
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("subscribed to partitions: %+v", offsetsByPartition)
-	})
+	r.logger().Info("subscribed to partitions", "group", r.config.GroupID, "offsets", offsetsByTopicAndPartition)
 
-	return nil
+	return partitions, nil
+}
+
+// topics returns the list of topics that the reader subscribes to.
+func (r *Reader) topics() []string {
+	if r.config.GroupTopicRegex != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return r.groupTopics
+	}
+	if len(r.config.GroupTopics) != 0 {
+		return r.config.GroupTopics
+	}
+	return []string{r.config.Topic}
+}
+
+// brokers returns the list of brokers in the order that the reader tries them.
+func (r *Reader) brokers() []string {
+	if r.config.ShuffleBrokers {
+		return shuffledStrings(r.config.Brokers)
+	}
+	return r.config.Brokers
 }
 
 // connect returns a connection to ANY broker
 func (r *Reader) connect() (conn *Conn, err error) {
-	for _, broker := range r.config.Brokers {
+	for _, broker := range r.brokers() {
 		if conn, err = r.config.Dialer.Dial("tcp", broker); err == nil {
 			return
 		}
+		r.logger().Warn("failed to connect to broker, trying the next one", "broker", broker, "error", err)
 	}
 	return // err will be non-nil
 }
@@ -573,26 +755,131 @@ func (r *Reader) heartbeat(conn *Conn) error {
 		return nil
 	}
 
-	_, err := conn.heartbeat(heartbeatRequestV0{
-		GroupID:      r.config.GroupID,
-		GenerationID: generationID,
-		MemberID:     memberID,
-	})
+	var err error
+	if r.config.GroupInstanceID == "" {
+		_, err = conn.heartbeat(heartbeatRequestV0{
+			GroupID:      r.config.GroupID,
+			GenerationID: generationID,
+			MemberID:     memberID,
+		})
+	} else {
+		_, err = conn.heartbeatV3(heartbeatRequestV3{
+			GroupID:         r.config.GroupID,
+			GenerationID:    generationID,
+			MemberID:        memberID,
+			GroupInstanceID: r.config.GroupInstanceID,
+		})
+	}
 	if err != nil {
+		if err == FencedInstanceID {
+			r.fence(err)
+		}
 		return fmt.Errorf("heartbeat failed: %v", err)
 	}
 
 	return nil
 }
 
+// beginPoll records that the program is waiting for the next message.
+func (r *Reader) beginPoll() {
+	atomic.StoreInt64(&r.pollTime, 0)
+	select {
+	case r.pollch <- struct{}{}:
+	default:
+	}
+}
+
+// endPoll records that the program received a message, and is now processing
+// it until the next call to FetchMessage.
+func (r *Reader) endPoll() {
+	atomic.StoreInt64(&r.pollTime, time.Now().UnixNano())
+}
+
+// processingStalled returns the poll time of the reader if the program has not
+// called FetchMessage for longer than MaxProcessingTime, and zero otherwise.
+func (r *Reader) processingStalled(now time.Time) int64 {
+	pollTime := atomic.LoadInt64(&r.pollTime)
+	if pollTime == 0 || now.Sub(time.Unix(0, pollTime)) <= r.config.MaxProcessingTime {
+		return 0
+	}
+	return pollTime
+}
+
+// processingWatchdog leaves the consumer group when the program takes longer
+// than ReaderConfig.MaxProcessingTime between calls to FetchMessage. Returning
+// stops the heartbeat loop, the consumer group loop then waits for the program
+// to call FetchMessage again before rejoining the group.
+func (r *Reader) processingWatchdog(conn *Conn) func(stop <-chan struct{}) {
+	return func(stop <-chan struct{}) {
+		interval := r.config.HeartbeatInterval
+		if interval > r.config.MaxProcessingTime {
+			interval = r.config.MaxProcessingTime
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				pollTime := r.processingStalled(now)
+				if pollTime == 0 {
+					continue
+				}
+
+				r.logger().Warn("processing time exceeded, leaving consumer group",
+					"group", r.config.GroupID,
+					"max_processing_time", r.config.MaxProcessingTime,
+				)
+				atomic.StoreInt64(&r.stalledAt, pollTime)
+
+				if err := r.evict(conn); err != nil {
+					r.logger().Error("failed to leave consumer group", "group", r.config.GroupID, "error", err)
+				}
+
+				// the member ID is not valid anymore once the reader left
+				r.mutex.Lock()
+				r.generationID = 0
+				r.memberID = ""
+				r.mutex.Unlock()
+				return
+
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// waitProcessing blocks until the program calls FetchMessage after the reader
+// left the group because of the processing timeout. The method returns false
+// if the reader was closed.
+func (r *Reader) waitProcessing() bool {
+	stalledAt := atomic.LoadInt64(&r.stalledAt)
+	if stalledAt == 0 {
+		return true
+	}
+
+	// stop fetching messages for the partitions that were given up
+	r.unsubscribe()
+
+	for atomic.LoadInt64(&r.pollTime) == stalledAt {
+		select {
+		case <-r.pollch:
+		case <-r.stctx.Done():
+			return false
+		}
+	}
+
+	atomic.StoreInt64(&r.stalledAt, 0)
+	r.logger().Info("processing resumed, rejoining consumer group", "group", r.config.GroupID)
+	return true
+}
+
 func (r *Reader) heartbeatLoop(conn *Conn) func(stop <-chan struct{}) {
 	return func(stop <-chan struct{}) {
-		r.withLogger(func(l *log.Logger) {
-			l.Printf("started heartbeat for group, %v [%v]", r.config.GroupID, r.config.HeartbeatInterval)
-		})
-		defer r.withLogger(func(l *log.Logger) {
-			l.Println("stopped heartbeat for group,", r.config.GroupID)
-		})
+		r.logger().Debug("started heartbeat", "group", r.config.GroupID, "interval", r.config.HeartbeatInterval)
+		defer r.logger().Debug("stopped heartbeat", "group", r.config.GroupID)
 
 		ticker := time.NewTicker(r.config.HeartbeatInterval)
 		defer ticker.Stop()
@@ -615,17 +902,18 @@ type offsetCommitter interface {
 	offsetCommit(request offsetCommitRequestV2) (offsetCommitResponseV2, error)
 }
 
-func (r *Reader) commitOffsets(conn offsetCommitter, offsetStash offsetStash) error {
-	if len(offsetStash) == 0 {
-		return nil
-	}
-
+// makeOffsetCommitRequest builds the request committing the offsets of the
+// stash for the current generation of the reader.
+func (r *Reader) makeOffsetCommitRequest(offsetStash offsetStash) offsetCommitRequestV2 {
 	generationID, memberID := r.membership()
 	request := offsetCommitRequestV2{
 		GroupID:       r.config.GroupID,
 		GenerationID:  generationID,
 		MemberID:      memberID,
-		RetentionTime: int64(r.config.RetentionTime / time.Millisecond),
+		RetentionTime: int64(defaultRetentionTime / time.Millisecond),
+	}
+	if r.config.RetentionTime != 0 {
+		request.RetentionTime = int64(r.config.RetentionTime / time.Millisecond)
 	}
 
 	for topic, partitions := range offsetStash {
@@ -639,20 +927,59 @@ func (r *Reader) commitOffsets(conn offsetCommitter, offsetStash offsetStash) er
 		request.Topics = append(request.Topics, t)
 	}
 
-	if _, err := conn.offsetCommit(request); err != nil {
+	return request
+}
+
+// leaderEpochCommitter is implemented by offset committers which are able to
+// commit the leader epochs of partitions along with their offsets.
+type leaderEpochCommitter interface {
+	offsetCommitV6(request offsetCommitRequestV6) (offsetCommitResponseV6, error)
+}
+
+// sendOffsetCommit commits the offsets of the stash, along with the leader
+// epochs of the partitions if the coordinator supports it (kafka 2.1+).
+//
+// When retention is non-zero, or ReaderConfig.RetentionTime is set, the offsets
+// are committed with v2 of the request, which carries the retention time but
+// not the leader epochs, since no version of the request has both.
+func (r *Reader) sendOffsetCommit(conn offsetCommitter, offsetStash offsetStash, retention time.Duration) (offsetCommitResponseV2, error) {
+	request := r.makeOffsetCommitRequest(offsetStash)
+
+	if retention == 0 {
+		retention = r.config.RetentionTime
+	}
+	if retention != 0 {
+		request.RetentionTime = int64(retention / time.Millisecond)
+		return conn.offsetCommit(request)
+	}
+
+	if c, ok := conn.(leaderEpochCommitter); ok {
+		response, err := c.offsetCommitV6(request.toV6(r.leaderEpochs.get))
+		if err != UnsupportedVersion {
+			return offsetCommitResponseV2{Responses: response.Responses}, err
+		}
+	}
+
+	return conn.offsetCommit(request)
+}
+
+func (r *Reader) commitOffsets(conn offsetCommitter, offsetStash offsetStash) error {
+	if len(offsetStash) == 0 {
+		return nil
+	}
+
+	if _, err := r.sendOffsetCommit(conn, offsetStash, 0); err != nil {
 		return fmt.Errorf("unable to commit offsets for group, %v: %v", r.config.GroupID, err)
 	}
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("committed offsets: %v", offsetStash)
-	})
+	r.logger().Debug("committed offsets", "group", r.config.GroupID, "offsets", offsetStash)
 
 	return nil
 }
 
 // commitOffsetsWithRetry attempts to commit the specified offsets and retries
-// up to the specified number of times
-func (r *Reader) commitOffsetsWithRetry(conn offsetCommitter, offsetStash offsetStash, retries int) (err error) {
+// up to the specified number of times, or until ctx is canceled.
+func (r *Reader) commitOffsetsWithRetry(ctx context.Context, conn offsetCommitter, offsetStash offsetStash, retries int) (err error) {
 	const (
 		backoffDelayMin = 100 * time.Millisecond
 		backoffDelayMax = 5 * time.Second
@@ -660,7 +987,7 @@ func (r *Reader) commitOffsetsWithRetry(conn offsetCommitter, offsetStash offset
 
 	for attempt := 0; attempt < retries; attempt++ {
 		if attempt != 0 {
-			if !sleep(r.stctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
+			if !sleep(ctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
 				return
 			}
 		}
@@ -673,6 +1000,79 @@ func (r *Reader) commitOffsetsWithRetry(conn offsetCommitter, offsetStash offset
 	return // err will not be nil
 }
 
+// commitExplicitOffsets commits the offsets passed to CommitOffsets. Unlike
+// commitOffsetsWithRetry the commit is attempted only once, and the errors
+// reported for each partition are returned as an *OffsetCommitError.
+func (r *Reader) commitExplicitOffsets(conn offsetCommitter, offsetStash offsetStash, retention time.Duration) error {
+	if len(offsetStash) == 0 {
+		return nil
+	}
+
+	response, err := r.sendOffsetCommit(conn, offsetStash, retention)
+
+	var commitErr *OffsetCommitError
+	for _, t := range response.Responses {
+		for _, p := range t.PartitionResponses {
+			if p.ErrorCode == 0 {
+				continue
+			}
+			if commitErr == nil {
+				commitErr = &OffsetCommitError{Errors: map[string]map[int]Error{}}
+			}
+			partitions, ok := commitErr.Errors[t.Topic]
+			if !ok {
+				partitions = map[int]Error{}
+				commitErr.Errors[t.Topic] = partitions
+			}
+			partitions[int(p.Partition)] = Error(p.ErrorCode)
+		}
+	}
+
+	if commitErr != nil {
+		return commitErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to commit offsets for group, %v: %v", r.config.GroupID, err)
+	}
+
+	r.logger().Debug("committed explicit offsets", "group", r.config.GroupID, "offsets", offsetStash)
+
+	return nil
+}
+
+// OffsetCommitError is returned by Reader.CommitOffsets and
+// Conn.TxnOffsetCommit when the coordinator rejected the commit of some of the
+// partitions.
+type OffsetCommitError struct {
+	// Errors holds the error codes by topic => partition, only the partitions
+	// which failed to be committed are present.
+	Errors map[string]map[int]Error
+}
+
+// Error satisfies the error interface.
+func (e *OffsetCommitError) Error() string {
+	topics := make([]string, 0, len(e.Errors))
+	for topic := range e.Errors {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	s := "failed to commit offsets:"
+	for _, topic := range topics {
+		partitions := make([]int, 0, len(e.Errors[topic]))
+		for partition := range e.Errors[topic] {
+			partitions = append(partitions, partition)
+		}
+		sort.Ints(partitions)
+
+		for _, partition := range partitions {
+			s += fmt.Sprintf(" %s/%d: %v;", topic, partition, e.Errors[topic][partition])
+		}
+	}
+	return strings.TrimSuffix(s, ";")
+}
+
 // offsetStash holds offsets by topic => partition => offset
 type offsetStash map[string]map[int]int64
 
@@ -698,6 +1098,23 @@ func (o offsetStash) reset() {
 	}
 }
 
+// remove deletes the offsets of the partitions present in other from the
+// offsetStash.
+func (o offsetStash) remove(other offsetStash) {
+	for topic, partitions := range other {
+		offsetsByPartition, ok := o[topic]
+		if !ok {
+			continue
+		}
+		for partition := range partitions {
+			delete(offsetsByPartition, partition)
+		}
+		if len(offsetsByPartition) == 0 {
+			delete(o, topic)
+		}
+	}
+}
+
 // commitLoopImmediate handles each commit synchronously
 func (r *Reader) commitLoopImmediate(conn offsetCommitter, stop <-chan struct{}) {
 	offsetsByTopicAndPartition := offsetStash{}
@@ -705,54 +1122,148 @@ func (r *Reader) commitLoopImmediate(conn offsetCommitter, stop <-chan struct{})
 	for {
 		select {
 		case <-stop:
+			r.flushCommits(conn, nil)
 			return
 
 		case req := <-r.commits:
+			if req.offsets != nil {
+				req.errch <- r.commitExplicitOffsets(conn, req.offsets, req.retention)
+				continue
+			}
 			offsetsByTopicAndPartition.merge(req.commits)
-			req.errch <- r.commitOffsetsWithRetry(conn, offsetsByTopicAndPartition, defaultCommitRetries)
+			err := r.commitOffsetsWithRetry(r.stctx, conn, offsetsByTopicAndPartition, defaultCommitRetries)
 			offsetsByTopicAndPartition.reset()
+			if req.errch != nil {
+				req.errch <- err
+			} else if err != nil {
+				r.commitFailed(err)
+			}
 		}
 	}
 }
 
-// commitLoopInterval handles each commit asynchronously with a period defined
-// by ReaderConfig.CommitInterval
+// commitLoopInterval handles the commits with a period defined by
+// ReaderConfig.CommitInterval. With CommitSync, the callers of CommitMessages
+// wait for the next periodic commit.
 func (r *Reader) commitLoopInterval(conn offsetCommitter, stop <-chan struct{}) {
 	ticker := time.NewTicker(r.config.CommitInterval)
 	defer ticker.Stop()
 
+	// waiting holds the channels of the CommitMessages calls waiting for the
+	// next commit.
+	var waiting []chan<- error
+
 	commit := func() {
-		if err := r.commitOffsetsWithRetry(conn, r.offsetStash, defaultCommitRetries); err != nil {
-			r.withErrorLogger(func(l *log.Logger) { l.Print(err) })
-		} else {
+		err := r.commitOffsetsWithRetry(r.stctx, conn, r.offsetStash, defaultCommitRetries)
+		if err == nil {
 			r.offsetStash.reset()
+		} else if len(waiting) == 0 {
+			r.commitFailed(err)
+		}
+		for _, errch := range waiting {
+			errch <- err
 		}
+		waiting = waiting[:0]
 	}
 
 	for {
 		select {
 		case <-stop:
-			commit()
+			r.flushCommits(conn, waiting)
 			return
 
 		case <-ticker.C:
 			commit()
 
 		case req := <-r.commits:
+			if req.offsets != nil {
+				// Pending offsets of these partitions would otherwise
+				// overwrite the explicit ones on the next tick.
+				r.offsetStash.remove(req.offsets)
+				req.errch <- r.commitExplicitOffsets(conn, req.offsets, req.retention)
+				continue
+			}
 			r.offsetStash.merge(req.commits)
+			if req.errch != nil {
+				waiting = append(waiting, req.errch)
+			}
 		}
 	}
 }
 
+// flushCommits commits the offsets which are pending when the commit loop
+// stops, because the reader is closing or the group is rebalancing, including
+// the ones queued by CommitMessages which were not handled yet. The result is
+// sent to the channels in waiting.
+//
+// When the reader is closing the commit is retried until CloseTimeout expires.
+// When the group is rebalancing it is attempted once, within CloseTimeout, so
+// the reader doesn't delay joining the next generation of the group.
+func (r *Reader) flushCommits(conn offsetCommitter, waiting []chan<- error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.CloseTimeout)
+	defer cancel()
+
+	retries := 1
+	select {
+	case <-r.stctx.Done():
+		retries = defaultCommitRetries
+	default:
+	}
+
+	if c, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+		// The connection to the coordinator is shared with the other group
+		// routines, the deadline is cleared so it doesn't apply to the
+		// requests sent after the commit, like the one leaving the group.
+		deadline, _ := ctx.Deadline()
+		c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	for pending := true; pending; {
+		select {
+		case req := <-r.commits:
+			if req.offsets != nil {
+				r.offsetStash.remove(req.offsets)
+				req.errch <- r.commitExplicitOffsets(conn, req.offsets, req.retention)
+				continue
+			}
+			r.offsetStash.merge(req.commits)
+			if req.errch != nil {
+				waiting = append(waiting, req.errch)
+			}
+		default:
+			pending = false
+		}
+	}
+
+	err := r.commitOffsetsWithRetry(ctx, conn, r.offsetStash, retries)
+	if err == nil {
+		r.offsetStash.reset()
+	} else if len(waiting) == 0 {
+		r.commitFailed(err)
+	}
+
+	for _, errch := range waiting {
+		errch <- err
+	}
+}
+
+// commitFailed reports the failure of a commit that no call to CommitMessages
+// waits for.
+func (r *Reader) commitFailed(err error) {
+	r.logger().Error("failed to commit offsets", "group", r.config.GroupID, "error", err)
+	r.stats.errors.observe(1)
+	r.stats.commitErrors.observe(1)
+	if r.config.OnCommitError != nil {
+		r.config.OnCommitError(err)
+	}
+}
+
 // commitLoop processes commits off the commit chan
 func (r *Reader) commitLoop(conn *Conn) func(stop <-chan struct{}) {
 	return func(stop <-chan struct{}) {
-		r.withLogger(func(l *log.Logger) {
-			l.Println("started commit for group,", r.config.GroupID)
-		})
-		defer r.withLogger(func(l *log.Logger) {
-			l.Println("stopped commit for group,", r.config.GroupID)
-		})
+		r.logger().Debug("started commit loop", "group", r.config.GroupID)
+		defer r.logger().Debug("stopped commit loop", "group", r.config.GroupID)
 
 		if r.config.CommitInterval == 0 {
 			r.commitLoopImmediate(conn, stop)
@@ -770,11 +1281,16 @@ func (r *Reader) partitionWatcher(conn partitionReader) func(stop <-chan struct{
 	return func(stop <-chan struct{}) {
 		ticker := time.NewTicker(r.config.PartitionWatchInterval)
 		defer ticker.Stop()
-		ops, err := conn.ReadPartitions(r.config.Topic)
+		topics := r.topics()
+		if len(topics) == 0 {
+			// no topic matched GroupTopicRegex, topicWatcher rebalances the
+			// group once one does.
+			<-stop
+			return
+		}
+		ops, err := conn.ReadPartitions(topics...)
 		if err != nil {
-			r.withErrorLogger(func(l *log.Logger) {
-				l.Printf("Problem getting partitions during startup, %v\n, Returning and setting up handshake", err)
-			})
+			r.logger().Error("failed to read partitions during startup, restarting handshake", "group", r.config.GroupID, "topics", topics, "error", err)
 			return
 		}
 		oParts := len(ops)
@@ -783,17 +1299,13 @@ func (r *Reader) partitionWatcher(conn partitionReader) func(stop <-chan struct{
 			case <-stop:
 				return
 			case <-ticker.C:
-				ops, err := conn.ReadPartitions(r.config.Topic)
+				ops, err := conn.ReadPartitions(topics...)
 				if err != nil {
-					r.withErrorLogger(func(l *log.Logger) {
-						l.Printf("Problem getting partitions while checking for changes, %v\n", err)
-					})
+					r.logger().Error("failed to read partitions while checking for changes", "group", r.config.GroupID, "topics", topics, "error", err)
 					return
 				}
 				if len(ops) != oParts {
-					r.withErrorLogger(func(l *log.Logger) {
-						l.Printf("Partition changes found, reblancing group: %v.", r.config.GroupID)
-					})
+					r.logger().Warn("partition changes found, rebalancing", "group", r.config.GroupID, "topics", topics, "old", oParts, "new", len(ops))
 					return
 				}
 			}
@@ -801,6 +1313,75 @@ func (r *Reader) partitionWatcher(conn partitionReader) func(stop <-chan struct{
 	}
 }
 
+// topicWatcher lists the topics of the cluster every MetadataRefreshInterval
+// and triggers a rebalance when the set of topics matching GroupTopicRegex
+// differs from the one that the reader subscribed to when joining the group.
+// Like partitionWatcher, it returns on error to establish a new connection to
+// the coordinator.
+func (r *Reader) topicWatcher(conn partitionReader) func(stop <-chan struct{}) {
+	return func(stop <-chan struct{}) {
+		ticker := time.NewTicker(r.config.MetadataRefreshInterval)
+		defer ticker.Stop()
+		topics := r.topics()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				matching, err := r.matchGroupTopics(conn)
+				if err != nil {
+					r.logger().Error("failed to list topics while checking for changes", "group", r.config.GroupID, "regex", r.config.GroupTopicRegex.String(), "error", err)
+					return
+				}
+				if !equalStrings(topics, matching) {
+					r.logger().Info("matching topics changed, rebalancing", "group", r.config.GroupID, "regex", r.config.GroupTopicRegex.String(), "old", topics, "new", matching)
+					return
+				}
+			}
+		}
+	}
+}
+
+// matchGroupTopics lists the topics of the cluster and returns the sorted names
+// of the ones matching GroupTopicRegex.
+func (r *Reader) matchGroupTopics(conn partitionReader) ([]string, error) {
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]string, 0)
+	for _, p := range partitions {
+		if r.config.GroupTopicRegex.MatchString(p.Topic) {
+			topics = append(topics, p.Topic)
+		}
+	}
+	sort.Strings(topics)
+
+	// the partitions of a topic are listed together, which leaves duplicate
+	// names once sorted.
+	n := 0
+	for i, topic := range topics {
+		if i == 0 || topic != topics[n-1] {
+			topics[n] = topic
+			n++
+		}
+	}
+	return topics[:n], nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // handshake performs the necessary incantations to join this Reader to the desired
 // consumer group.  handshake will be called whenever the group is disrupted
 // (member join, member leave, coordinator changed, etc)
@@ -830,31 +1411,86 @@ func (r *Reader) handshake() error {
 		_ = conn.Close()
 	}()
 
+	// the topics matching the regex are subscribed to when joining the
+	// group, topicWatcher rebalances it when they change.
+	if r.config.GroupTopicRegex != nil {
+		topics, err := r.matchGroupTopics(conn)
+		if err != nil {
+			return fmt.Errorf("unable to list topics matching %v: %v", r.config.GroupTopicRegex, err)
+		}
+		r.mutex.Lock()
+		r.groupTopics = topics
+		r.mutex.Unlock()
+	}
+
 	// rebalance and fetch assignments
 	assignments, err := r.rebalance(conn)
 	if err != nil {
 		return fmt.Errorf("rebalance failed for consumer group, %v: %v", r.config.GroupID, err)
 	}
 
+	// the commit loop runs in its own group so it outlives the generation long
+	// enough for OnPartitionsRevoked to commit the final offsets.
+	cg := (&runGroup{}).WithContext(r.stctx)
+	cg.Go(r.commitLoop(conn))
+	defer cg.Stop()
+
 	rg := &runGroup{}
 	rg = rg.WithContext(r.stctx)
 	rg.Go(r.heartbeatLoop(conn))
-	rg.Go(r.commitLoop(conn))
 	if r.config.WatchPartitionChanges {
 		rg.Go(r.partitionWatcher(conn))
 	}
+	if r.config.GroupTopicRegex != nil {
+		rg.Go(r.topicWatcher(conn))
+	}
+	if r.config.MaxProcessingTime != 0 {
+		rg.Go(r.processingWatchdog(conn))
+	}
 
 	// subscribe to assignments
-	if err := r.subscribe(conn, assignments); err != nil {
+	partitions, err := r.subscribe(conn, assignments)
+	if err != nil {
 		rg.Stop()
 		return fmt.Errorf("subscribe failed for consumer group, %v: %v\n", r.config.GroupID, err)
 	}
+	if len(partitions) != 0 && r.config.OnPartitionsRevoked != nil {
+		// deferred after cg.Stop so commits are still handled while the
+		// callback runs.
+		defer r.revoke(partitions)
+	}
 
 	rg.Wait()
 
 	return nil
 }
 
+// revoke stops the readers of the partitions that the reader is giving up,
+// then passes them to OnPartitionsRevoked.
+func (r *Reader) revoke(partitions map[string][]int) {
+	r.unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.RebalanceTimeout)
+	defer cancel()
+	r.config.OnPartitionsRevoked(ctx, partitions)
+}
+
+// makeTopicPartitions converts the assignments received from the group
+// coordinator to the topic => partitions form passed to the rebalance
+// callbacks of ReaderConfig.
+func makeTopicPartitions(assignments map[string][]int32) map[string][]int {
+	partitions := make(map[string][]int, len(assignments))
+	for topic, ids := range assignments {
+		list := make([]int, len(ids))
+		for i, id := range ids {
+			list[i] = int(id)
+		}
+		sort.Ints(list)
+		partitions[topic] = list
+	}
+	return partitions
+}
+
 // run provides the main consumer group management loop.  Each iteration performs the
 // handshake to join the Reader to the consumer group.
 func (r *Reader) run() {
@@ -864,16 +1500,35 @@ func (r *Reader) run() {
 		return
 	}
 
-	r.withLogger(func(l *log.Logger) {
-		l.Printf("entering loop for consumer group, %v\n", r.config.GroupID)
-	})
+	r.logger().Debug("entering consumer group loop", "group", r.config.GroupID)
 
 	for {
+		if !r.waitProcessing() {
+			return
+		}
+
 		if err := r.handshake(); err != nil {
 			r.stats.errors.observe(1)
-			r.withErrorLogger(func(l *log.Logger) {
-				l.Println(err)
-			})
+			r.logger().Error("consumer group handshake failed", "group", r.config.GroupID, "error", err)
+		}
+
+		if err := r.fencedError(); err != nil {
+			r.logger().Error("reader was fenced by another member of the consumer group with the same instance id, leaving the consumer group loop",
+				"group", r.config.GroupID,
+				"instance", r.config.GroupInstanceID,
+				"error", err,
+			)
+			r.unsubscribe()
+
+			r.mutex.Lock()
+			version := r.version
+			r.mutex.Unlock()
+
+			select {
+			case r.msgs <- readerMessage{version: version, error: err}:
+			case <-r.stctx.Done():
+			}
+			return
 		}
 
 		select {
@@ -887,16 +1542,64 @@ func (r *Reader) run() {
 // ReaderConfig is a configuration object used to create new instances of
 // Reader.
 type ReaderConfig struct {
-	// The list of broker addresses used to connect to the kafka cluster.
+	// The list of broker addresses used to connect to the kafka cluster. The
+	// brokers are tried in order until one of them responds, so the reader
+	// keeps working as long as one of them is up.
 	Brokers []string
 
+	// ShuffleBrokers makes the reader try the brokers in a random order
+	// instead, spreading the connections of multiple readers configured with
+	// the same list of brokers.
+	ShuffleBrokers bool
+
 	// GroupID holds the optional consumer group id.  If GroupID is specified, then
 	// Partition should NOT be specified e.g. 0
 	GroupID string
 
+	// GroupInstanceID optionally enables static membership of the consumer
+	// group. A static member which restarts and rejoins the group within
+	// SessionTimeout gets its partitions back without triggering a rebalance,
+	// which is useful during rolling deploys. Static readers do not leave the
+	// group when they are closed, only when they exceed MaxProcessingTime.
+	//
+	// Each reader of the group must use a distinct instance ID. When a reader
+	// joins with an ID already in use, the coordinator fences the reader which
+	// previously held it: the fenced reader stops consuming and its
+	// FetchMessage, ReadMessage and CommitMessages methods return
+	// FencedInstanceID.
+	//
+	// Static membership is only supported by kafka 2.3 and above.
+	//
+	// Only used when GroupID is set
+	GroupInstanceID string
+
 	// The topic to read messages from.
 	Topic string
 
+	// GroupTopics is the list of topics that the reader subscribes to when it
+	// is part of a consumer group, the partitions of all the topics are
+	// assigned to the members of the group. The Topic field of messages holds
+	// the topic that they were read from.
+	//
+	// Only used when GroupID is set, in which case either Topic or GroupTopics
+	// may be assigned, but not both.
+	GroupTopics []string
+
+	// GroupTopicRegex subscribes the reader to all the topics whose name
+	// matches the regular expression when it is part of a consumer group,
+	// like the pattern subscriptions of the java client. The Topic field of
+	// messages holds the topic that they were read from.
+	//
+	// The matching topics are listed when the reader joins the group, then
+	// every MetadataRefreshInterval (5m by default). The reader rebalances the
+	// group when the set of matching topics changed, so topics created after
+	// it joined are only consumed once the next refresh found them. The
+	// pattern is not anchored, use ^ and $ to match whole topic names.
+	//
+	// Only used when GroupID is set, in which case neither Topic nor
+	// GroupTopics may be assigned.
+	GroupTopicRegex *regexp.Regexp
+
 	// Partition to read messages from.  Either Partition or GroupID may
 	// be assigned, but not both
 	Partition int
@@ -907,16 +1610,150 @@ type ReaderConfig struct {
 
 	// The capacity of the internal message queue, defaults to 100 if none is
 	// set.
+	//
+	// The queue holds the messages fetched ahead of the program, which bounds
+	// the memory used by the reader. When the queue is full the reader stops
+	// reading the fetched batch, and doesn't send the next fetch request, until
+	// the program reads a message from the queue, no messages are dropped.
+	// The QueueLength stat reports how many messages sit in the queue.
 	QueueCapacity int
 
 	// Min and max number of bytes to fetch from kafka in each request.
 	MinBytes int
 	MaxBytes int
 
+	// PartitionMaxBytes is the maximum number of bytes of records fetched from
+	// a partition in each request, MaxBytes bounds the size of the whole
+	// response. Readers consuming several partitions fetch each of them with
+	// their own requests, and share the queue of fetched messages between the
+	// partitions. Setting a limit lower than MaxBytes keeps a partition with
+	// a large backlog from filling the queue with large batches ahead of the
+	// other partitions. The broker always returns the first record batch of
+	// the partition, even when it exceeds the limit.
+	//
+	// Default: MaxBytes
+	PartitionMaxBytes int
+
 	// Maximum amount of time to wait for new data to come when fetching batches
-	// of messages from kafka.
+	// of messages from kafka. It is the maximum wait time of the fetch requests,
+	// which kafka waits for MinBytes to be available before responding.
 	MaxWait time.Duration
 
+	// ReadBatchTimeout is the amount of time to wait for kafka to respond to
+	// the fetch requests, which is the read deadline of the connection while
+	// the reader waits for a batch of messages. It is distinct from MaxWait so
+	// latency-sensitive programs can set a short MaxWait while keeping a
+	// generous timeout for slow networks or loaded brokers. When it is lower
+	// than MaxWait, kafka waits at most ReadBatchTimeout minus the expected
+	// round trip time.
+	//
+	// Default: 10s, or MaxWait if it is greater
+	ReadBatchTimeout time.Duration
+
+	// MetadataRefreshInterval defines how often the reader looks up the leader
+	// of the partitions it is reading while it is connected to them, like
+	// metadata.max.age.ms of the java client. The reader reconnects to the
+	// new leader of a partition which moved to another broker, for example
+	// after a reassignment or a preferred leader election, instead of waiting
+	// for the old one to reject its fetch requests. The leaders are looked up
+	// in the background, fetches are not delayed. Consumer groups pick up new
+	// partitions when WatchPartitionChanges is set. Readers configured with a
+	// RackID also go back to the partition leader at this interval, to let it
+	// designate another replica to fetch from.
+	//
+	// Default: 5m
+	MetadataRefreshInterval time.Duration
+
+	// IdleTimeout is the amount of time after which the reader reconnects to
+	// a partition when it did not send a fetch request to it, which happens
+	// when the program stops calling FetchMessage or ReadMessage and the queue
+	// of the reader fills up. The next fetch request is then sent on a new
+	// connection, instead of one that a firewall or load balancer may have
+	// silently dropped in the meantime. The time that brokers hold fetch
+	// requests, up to MaxWait, is not counted. It should be shorter than the
+	// idle timeout of the network equipment between the program and the
+	// brokers.
+	//
+	// The default is to keep the connections open until they fail.
+	IdleTimeout time.Duration
+
+	// ReadMessageTimeout bounds the amount of time that FetchMessage,
+	// ReadMessage and ReadMessages wait for a message, they return
+	// ErrReadTimeout when none is received in time. The reader keeps fetching
+	// messages in the background, so the program can call them again in a
+	// loop without creating a context with a deadline on each iteration.
+	// Cancelling the context passed to the methods still interrupts them
+	// before the timeout expires.
+	//
+	// Default: 0 (wait until a message is received or the context is done)
+	ReadMessageTimeout time.Duration
+
+	// CheckCRCs controls whether the CRC32C checksums of the record batches
+	// fetched by the reader are validated. When a batch is corrupted, the
+	// reader fetches it again from a new connection, up to 3 times in case it
+	// was corrupted in transit. If it is still corrupted, FetchMessage and
+	// ReadMessage return ErrCorruptBatch once and the reader stops fetching
+	// the partition, until SetOffset is called or the partition is assigned
+	// to the reader again by a rebalance. Programs that need to read messages
+	// as fast as possible may disable the validation by setting the field to
+	// SkipCRCs.
+	//
+	// Default: ValidateCRCs
+	CheckCRCs CRCValidation
+
+	// RackID is the rack of the reader, it is sent in fetch requests so the
+	// partition leaders may designate a replica in the same rack to fetch from
+	// instead of themselves (KIP-392), which reduces the traffic across racks
+	// or availability zones. The reader goes back to the partition leader
+	// when the replica fails to serve a fetch request, and every
+	// MetadataRefreshInterval so the leader may designate another replica.
+	//
+	// Fetching from the closest replica requires kafka 2.4 or above, with the
+	// broker.rack setting of the brokers set and their replica.selector.class
+	// set to the RackAwareReplicaSelector. The reader always fetches from the
+	// partition leaders when the field is empty.
+	RackID string
+
+	// IsolationLevel controls the visibility of transactional records. With
+	// ReadCommitted the brokers do not return the records past the first
+	// offset of the transactions which are still open, which programs
+	// consuming the output of transactional producers (see
+	// CommitMessagesInTxn) should use. Requires kafka 0.11 or above.
+	//
+	// Default: ReadUncommitted
+	IsolationLevel IsolationLevel
+
+	// FetchSessions makes the reader fetch each partition within an
+	// incremental fetch session (KIP-227), the partition leader then caches
+	// the fetch parameters of the partition. The fetch requests of the reader
+	// only carry the partition when its offset moved, and the responses omit
+	// it when it has no new messages, which reduces the size of the requests
+	// and responses of idle partitions. The reader creates a new session when
+	// the broker evicted it from its cache.
+	//
+	// Each partition is read over its own connection, so the reader holds one
+	// session per partition rather than one per broker, and each session only
+	// saves the few bytes describing a single partition. Brokers cache a
+	// limited number of sessions (max.incremental.fetch.session.cache.slots,
+	// 1000 by default) shared by all clients, readers of many partitions may
+	// take most of them and cause the sessions of other consumers to be
+	// evicted. The option is therefore disabled by default, and is mostly
+	// useful to readers of few partitions which stay idle for long periods.
+	//
+	// Fetch sessions require kafka 2.1 or above, the field is ignored for
+	// older brokers.
+	FetchSessions bool
+
+	// ReadBackoffMin and ReadBackoffMax bound the amount of time the reader
+	// waits after a failed fetch (e.g. because the partition leader moved or
+	// the connection was lost) before trying again. The delay starts at
+	// ReadBackoffMin and doubles after each consecutive failure, up to
+	// ReadBackoffMax. A successful fetch resets the delay to ReadBackoffMin.
+	//
+	// Default to 100ms and 1s
+	ReadBackoffMin time.Duration
+	ReadBackoffMax time.Duration
+
 	// ReadLagInterval sets the frequency at which the reader lag is updated.
 	// Setting this field to a negative value disables lag reporting.
 	ReadLagInterval time.Duration
@@ -931,21 +1768,67 @@ type ReaderConfig struct {
 	GroupBalancers []GroupBalancer
 
 	// HeartbeatInterval sets the optional frequency at which the reader sends the consumer
-	// group heartbeat update.
+	// group heartbeat update. Heartbeats are sent in the background, they keep
+	// the reader in the group while the program is processing messages. The
+	// interval is capped at a third of SessionTimeout, so the coordinator
+	// receives a few heartbeats before the session of the reader expires.
 	//
-	// Default: 3s
+	// Default: 3s, or a tenth of SessionTimeout if it is less than 30s
 	//
 	// Only used when GroupID is set
 	HeartbeatInterval time.Duration
 
 	// CommitInterval indicates the interval at which offsets are committed to
-	// the broker.  If 0, commits will be handled synchronously.
+	// the broker.  If 0, commits will be handled synchronously, unless
+	// CommitMode is set to CommitAsync.
 	//
 	// Default: 0
 	//
 	// Only used when GroupID is set
 	CommitInterval time.Duration
 
+	// CommitMode selects whether CommitMessages, and ReadMessage which calls
+	// it, wait for the offsets to be committed:
+	//
+	// CommitSync: each call waits for the commit, sent right away when
+	// CommitInterval is 0, or with the next periodic commit otherwise, and
+	// returns its error. No offset returned by a successful call is lost if
+	// the program crashes, at the cost of a round trip to the coordinator per
+	// call or of waiting for the interval.
+	//
+	// CommitAsync: calls return as soon as the offsets were queued. The
+	// offsets are committed right away in the background when CommitInterval
+	// is 0, or periodically otherwise. This does not slow down the program,
+	// but the offsets not committed yet when it crashes are lost, so the
+	// messages are consumed again after a restart. Errors are reported to
+	// OnCommitError and counted in the CommitErrors stat.
+	//
+	// Default: CommitSync if CommitInterval is 0, CommitAsync otherwise
+	//
+	// Only used when GroupID is set
+	CommitMode CommitMode
+
+	// OnCommitError, when set, is called with the errors of the commits which
+	// no call to CommitMessages waits for, for example with CommitAsync. It is
+	// called by the goroutine committing the offsets, which blocks until it
+	// returns.
+	//
+	// Only used when GroupID is set
+	OnCommitError func(err error)
+
+	// CloseTimeout bounds the amount of time that Close waits for the offsets
+	// which are pending when the reader is closed to be committed, including
+	// the ones queued by CommitMessages or awaiting CommitInterval, before the
+	// reader leaves the consumer group. The final commit is retried until the
+	// timeout expires. When the group rebalances, the pending offsets are
+	// committed with a single attempt bounded by CloseTimeout before the
+	// reader rejoins the group.
+	//
+	// Default: 5s
+	//
+	// Only used when GroupID is set
+	CloseTimeout time.Duration
+
 	// PartitionWatchInterval indicates how often a reader checks for partition changes.
 	// If a reader sees a partition change (such as a partition add) it will rebalance the group
 	// picking up new partitions.
@@ -961,6 +1844,10 @@ type ReaderConfig struct {
 
 	// SessionTimeout optionally sets the length of time that may pass without a heartbeat
 	// before the coordinator considers the consumer dead and initiates a rebalance.
+	// The value is sent to the coordinator when joining the group, it must be
+	// within the group.min.session.timeout.ms and group.max.session.timeout.ms
+	// bounds configured on the brokers or joining fails with
+	// InvalidSessionTimeout.
 	//
 	// Default: 30s
 	//
@@ -976,10 +1863,38 @@ type ReaderConfig struct {
 	// Only used when GroupID is set
 	RebalanceTimeout time.Duration
 
+	// MaxProcessingTime optionally sets the maximum amount of time the program
+	// may take between calls to FetchMessage (or ReadMessage) before the reader
+	// proactively leaves the consumer group, so its partitions are reassigned
+	// to other members instead of being held by a stuck consumer. The reader
+	// stops heartbeating and fetching messages, and rejoins the group when the
+	// program calls FetchMessage again. Messages which were being processed may
+	// then be redelivered to other members of the group.
+	//
+	// Static members (see GroupInstanceID) leave the group as well, which
+	// requires kafka 2.4 or above. With older versions, their partitions are
+	// only reassigned once SessionTimeout expires.
+	//
+	// This is the equivalent of max.poll.interval.ms of the Java client. The
+	// time spent waiting for messages in FetchMessage is not accounted for.
+	//
+	// Default: 0 (disabled)
+	//
+	// Only used when GroupID is set
+	MaxProcessingTime time.Duration
+
 	// RetentionTime optionally sets the length of time the consumer group will be saved
 	// by the broker
 	//
-	// Default: 24h
+	// Only v2 of the OffsetCommit request carries the retention time, while the
+	// leader epochs of the partitions are only carried by v6 and above. When
+	// RetentionTime is set, the offsets are committed with v2 and without the
+	// leader epochs. Otherwise they are committed along with the leader epochs
+	// if the coordinator supports it (kafka 2.1+), and the
+	// offsets.retention.minutes setting of the brokers applies.
+	//
+	// Default: the retention of the brokers with kafka 2.1 and above, 24h with
+	// older versions
 	//
 	// Only used when GroupID is set
 	RetentionTime time.Duration
@@ -992,6 +1907,62 @@ type ReaderConfig struct {
 	// back to using Logger instead.
 	ErrorLogger *log.Logger
 
+	// OnPartitionsAssigned is invoked synchronously with the topic => partitions
+	// assigned to the reader after each successful rebalance which assigned
+	// partitions to it, before the reader starts fetching messages from them.
+	// Heartbeats keep being sent while the callback runs.
+	//
+	// Only used when GroupID is set
+	OnPartitionsAssigned func(ctx context.Context, partitions map[string][]int)
+
+	// OnPartitionsRevoked is invoked synchronously with the topic => partitions
+	// passed to OnPartitionsAssigned when the reader gives them up, either
+	// because the group is rebalancing or because the reader is being closed.
+	// No more messages are fetched from the partitions when it is called. The
+	// consumer group handshake does not proceed until the callback returns, and
+	// during a rebalance offsets may still be committed with CommitMessages
+	// while it runs, which lets programs flush their state and commit final
+	// offsets; commits are no longer accepted once the reader is closed. The
+	// callback must return within RebalanceTimeout or the reader may be evicted
+	// from the group, the context expires after RebalanceTimeout.
+	//
+	// Only used when GroupID is set
+	OnPartitionsRevoked func(ctx context.Context, partitions map[string][]int)
+
+	// StructuredLogger is a leveled logger receiving entries with key/value
+	// pairs describing the internal state of the reader. If not nil, it takes
+	// precedence over Logger and ErrorLogger.
+	StructuredLogger Logger
+
+	// If not nil, the statistics of the reader are reported to the registry as
+	// they are observed, in addition to being returned by Stats.
+	MetricsRegistry MetricsRegistry
+
+	// KeyDeserializer and ValueDeserializer optionally decode the key and value
+	// of each message returned by FetchMessage and ReadMessage, the results are
+	// set to the DecodedKey and DecodedValue fields of the message. Null keys
+	// and values, like the values of tombstones, are not passed to the
+	// deserializers and leave the decoded fields nil.
+	//
+	// The deserializers are invoked by the goroutine calling the reader
+	// methods, they don't need to be safe for concurrent use unless the reader
+	// itself is used concurrently.
+	KeyDeserializer   Deserializer
+	ValueDeserializer Deserializer
+
+	// Middleware is a chain of functions transforming each message returned by
+	// FetchMessage and ReadMessage, for example to decrypt the values or to
+	// redact them. The functions are applied in order, each receiving the
+	// message returned by the previous one, after the message was fetched and
+	// before it is decoded by the deserializers.
+	//
+	// Like the deserializers, the middleware runs synchronously on the
+	// goroutine calling the reader methods, so slow functions slow down the
+	// consumption. When a function fails, the message is returned as it was
+	// fetched along with a *MiddlewareError, and the remaining functions are
+	// skipped.
+	Middleware []func(Message) (Message, error)
+
 	// AutoOffsetReset decides what to do when there is no initial offset of if the current
 	// offset does not exist any more (e.g. because that data has been deleted).
 	//
@@ -1000,22 +1971,57 @@ type ReaderConfig struct {
 	//
 	// Default: FirstOffset
 	AutoOffsetReset int64
+
+	// StartOffset determines from whence the consumer group should begin
+	// consuming when it finds a partition without a committed offset. It is
+	// applied to each partition independently, offsets committed by the group
+	// always take precedence.
+	//
+	// FirstOffset: start from the earliest offset of the partition.
+	// LastOffset:  start from the latest offset of the partition, only the
+	//              messages produced after the reader joined are consumed.
+	//
+	// Default: AutoOffsetReset
+	//
+	// Only used when GroupID is set
+	StartOffset int64
+
+	// TailLookback positions the reader TailLookback offsets before the last
+	// offset of the partitions that it starts reading from LastOffset, so the
+	// most recent messages are read before following the new ones. It applies
+	// to the partitions of consumer groups without committed offsets when
+	// StartOffset is LastOffset, and to readers set to LastOffset by
+	// SetOffset. Partitions with fewer messages are read from their first
+	// offset.
+	//
+	// Default: 0
+	TailLookback int64
 }
 
 // ReaderStats is a data structure returned by a call to Reader.Stats that exposes
 // details about the behavior of the reader.
 type ReaderStats struct {
-	Dials      int64 `metric:"kafka.reader.dial.count"      type:"counter"`
-	Fetches    int64 `metric:"kafka.reader.fetch.count"     type:"counter"`
-	Messages   int64 `metric:"kafka.reader.message.count"   type:"counter"`
-	Bytes      int64 `metric:"kafka.reader.message.bytes"   type:"counter"`
+	Dials    int64 `metric:"kafka.reader.dial.count"      type:"counter"`
+	Fetches  int64 `metric:"kafka.reader.fetch.count"     type:"counter"`
+	Messages int64 `metric:"kafka.reader.message.count"   type:"counter"`
+	Bytes    int64 `metric:"kafka.reader.message.bytes"   type:"counter"`
+
+	// Rebalances counts the times the reader completed the JoinGroup and
+	// SyncGroup cycle of its consumer group since the previous call to Stats.
+	// Partition readers reconnecting to a new partition leader are not
+	// counted.
 	Rebalances int64 `metric:"kafka.reader.rebalance.count" type:"counter"`
 	Timeouts   int64 `metric:"kafka.reader.timeout.count"   type:"counter"`
 	Errors     int64 `metric:"kafka.reader.error.count"     type:"counter"`
 
+	// CommitErrors counts the commits which failed without a call to
+	// CommitMessages waiting for them, see ReaderConfig.CommitMode.
+	CommitErrors int64 `metric:"kafka.reader.commit.error.count" type:"counter"`
+
 	DialTime   DurationStats `metric:"kafka.reader.dial.seconds"`
 	ReadTime   DurationStats `metric:"kafka.reader.read.seconds"`
 	WaitTime   DurationStats `metric:"kafka.reader.wait.seconds"`
+	Throttled  DurationStats `metric:"kafka.reader.throttle.seconds"`
 	FetchSize  SummaryStats  `metric:"kafka.reader.fetch.size"`
 	FetchBytes SummaryStats  `metric:"kafka.reader.fetch.bytes"`
 
@@ -1024,10 +2030,21 @@ type ReaderStats struct {
 	MinBytes      int64         `metric:"kafka.reader.fetch_bytes.min" type:"gauge"`
 	MaxBytes      int64         `metric:"kafka.reader.fetch_bytes.max" type:"gauge"`
 	MaxWait       time.Duration `metric:"kafka.reader.fetch_wait.max"  type:"gauge"`
+	ReadBackoff   time.Duration `metric:"kafka.reader.backoff"         type:"gauge"`
 	QueueLength   int64         `metric:"kafka.reader.queue.length"    type:"gauge"`
 	QueueCapacity int64         `metric:"kafka.reader.queue.capacity"  type:"gauge"`
 
-	ClientID  string `tag:"client_id"`
+	// LastRebalance is the time at which the reader last completed a
+	// rebalance of its consumer group, it is zero if it never did, and is not
+	// reset when taking a snapshot. The time carries a monotonic clock
+	// reading, so it can be compared with time.Now or time.Since regardless
+	// of changes to the wall clock.
+	LastRebalance time.Time
+
+	ClientID string `tag:"client_id"`
+
+	// Topic holds the topics that the reader consumes, separated by commas
+	// when it subscribes to multiple topics.
 	Topic     string `tag:"topic"`
 	Partition string `tag:"partition"`
 
@@ -1050,11 +2067,38 @@ type readerStats struct {
 	dialTime   summary
 	readTime   summary
 	waitTime   summary
+	throttled  summary
 	fetchSize  summary
 	fetchBytes summary
 	offset     gauge
 	lag        gauge
-	partition  string
+	backoff    gauge
+	// lastRebalance holds the time.Time of the last rebalance.
+	lastRebalance atomic.Value
+	partition     string
+
+	commitErrors counter
+}
+
+// register configures the statistics to be reported to registry.
+func (s *readerStats) register(registry MetricsRegistry) {
+	s.dials.metric = counterMetric{registry, "kafka.reader.dial.count"}
+	s.fetches.metric = counterMetric{registry, "kafka.reader.fetch.count"}
+	s.messages.metric = counterMetric{registry, "kafka.reader.message.count"}
+	s.bytes.metric = counterMetric{registry, "kafka.reader.message.bytes"}
+	s.rebalances.metric = counterMetric{registry, "kafka.reader.rebalance.count"}
+	s.timeouts.metric = counterMetric{registry, "kafka.reader.timeout.count"}
+	s.errors.metric = counterMetric{registry, "kafka.reader.error.count"}
+	s.commitErrors.metric = counterMetric{registry, "kafka.reader.commit.error.count"}
+	s.dialTime.metric = timerMetric{registry, "kafka.reader.dial.seconds"}
+	s.readTime.metric = timerMetric{registry, "kafka.reader.read.seconds"}
+	s.waitTime.metric = timerMetric{registry, "kafka.reader.wait.seconds"}
+	s.throttled.metric = timerMetric{registry, "kafka.reader.throttle.seconds"}
+	s.fetchSize.metric = histogramMetric{registry, "kafka.reader.fetch.size"}
+	s.fetchBytes.metric = histogramMetric{registry, "kafka.reader.fetch.bytes"}
+	s.offset.metric = gaugeMetric{registry, "kafka.reader.offset"}
+	s.lag.metric = gaugeMetric{registry, "kafka.reader.lag"}
+	s.backoff.metric = gaugeMetric{registry, "kafka.reader.backoff"}
 }
 
 // NewReader creates and returns a new Reader configured with config.
@@ -1064,10 +2108,26 @@ func NewReader(config ReaderConfig) *Reader {
 		panic("cannot create a new kafka reader with an empty list of broker addresses")
 	}
 
-	if len(config.Topic) == 0 {
+	if len(config.Topic) == 0 && len(config.GroupTopics) == 0 && config.GroupTopicRegex == nil {
 		panic("cannot create a new kafka reader with an empty topic")
 	}
 
+	if len(config.GroupTopics) != 0 && config.GroupID == "" {
+		panic("GroupTopics may only be specified when GroupID is set")
+	}
+
+	if len(config.GroupTopics) != 0 && len(config.Topic) != 0 {
+		panic("either Topic or GroupTopics may be specified, but not both")
+	}
+
+	if config.GroupTopicRegex != nil && config.GroupID == "" {
+		panic("GroupTopicRegex may only be specified when GroupID is set")
+	}
+
+	if config.GroupTopicRegex != nil && (len(config.Topic) != 0 || len(config.GroupTopics) != 0) {
+		panic("GroupTopicRegex may not be specified with Topic or GroupTopics")
+	}
+
 	if config.Partition < 0 || config.Partition >= math.MaxInt32 {
 		panic(fmt.Sprintf("partition number out of bounds: %d", config.Partition))
 	}
@@ -1080,10 +2140,18 @@ func NewReader(config ReaderConfig) *Reader {
 		panic(fmt.Sprintf("invalid negative maximum batch size (max = %d)", config.MaxBytes))
 	}
 
+	if config.PartitionMaxBytes < 0 {
+		panic(fmt.Sprintf("invalid negative maximum partition batch size (max = %d)", config.PartitionMaxBytes))
+	}
+
 	if config.GroupID != "" && config.Partition != 0 {
 		panic("either Partition or GroupID may be specified, but not both")
 	}
 
+	if config.GroupInstanceID != "" && config.GroupID == "" {
+		panic("GroupInstanceID may only be specified when GroupID is set")
+	}
+
 	if config.GroupID != "" {
 		if len(config.GroupBalancers) == 0 {
 			config.GroupBalancers = []GroupBalancer{
@@ -1108,6 +2176,10 @@ func NewReader(config ReaderConfig) *Reader {
 			panic(fmt.Sprintf("RetentionTime out of bounds: %d", config.RetentionTime))
 		}
 
+		if config.MaxProcessingTime < 0 {
+			panic(fmt.Sprintf("MaxProcessingTime out of bounds: %d", config.MaxProcessingTime))
+		}
+
 		if config.CommitInterval < 0 || (config.CommitInterval/time.Millisecond) >= math.MaxInt32 {
 			panic(fmt.Sprintf("CommitInterval out of bounds: %d", config.CommitInterval))
 		}
@@ -1116,6 +2188,16 @@ func NewReader(config ReaderConfig) *Reader {
 			panic(fmt.Sprintf("PartitionWachInterval out of bounds %d", config.PartitionWatchInterval))
 		}
 
+		switch config.CommitMode {
+		case 0, CommitSync, CommitAsync:
+		default:
+			panic(fmt.Sprintf("invalid commit mode: %d", config.CommitMode))
+		}
+
+		if config.CloseTimeout < 0 {
+			panic(fmt.Sprintf("CloseTimeout out of bounds: %d", config.CloseTimeout))
+		}
+
 	}
 
 	if config.Dialer == nil {
@@ -1138,16 +2220,70 @@ func NewReader(config ReaderConfig) *Reader {
 		config.MaxWait = 10 * time.Second
 	}
 
+	if config.ReadBatchTimeout < 0 {
+		panic(fmt.Sprintf("ReadBatchTimeout out of bounds: %d", config.ReadBatchTimeout))
+	}
+
+	if config.MetadataRefreshInterval < 0 {
+		panic(fmt.Sprintf("MetadataRefreshInterval out of bounds: %d", config.MetadataRefreshInterval))
+	}
+
+	if config.MetadataRefreshInterval == 0 {
+		config.MetadataRefreshInterval = 5 * time.Minute
+	}
+
+	if config.IdleTimeout < 0 {
+		panic(fmt.Sprintf("IdleTimeout out of bounds: %d", config.IdleTimeout))
+	}
+
+	if config.ReadMessageTimeout < 0 {
+		panic(fmt.Sprintf("ReadMessageTimeout out of bounds: %d", config.ReadMessageTimeout))
+	}
+
+	if config.ReadBatchTimeout == 0 {
+		config.ReadBatchTimeout = 10 * time.Second
+		if config.ReadBatchTimeout < config.MaxWait {
+			config.ReadBatchTimeout = config.MaxWait
+		}
+	}
+
+	if config.ReadBackoffMin == 0 {
+		config.ReadBackoffMin = defaultReadBackoffMin
+	}
+
+	if config.ReadBackoffMax == 0 {
+		config.ReadBackoffMax = defaultReadBackoffMax
+		if config.ReadBackoffMax < config.ReadBackoffMin {
+			config.ReadBackoffMax = config.ReadBackoffMin
+		}
+	}
+
+	if config.QueueCapacity < 0 {
+		panic(fmt.Sprintf("QueueCapacity out of bounds: %d", config.QueueCapacity))
+	}
+
+	if config.ReadBackoffMin < 0 || config.ReadBackoffMin > config.ReadBackoffMax {
+		panic(fmt.Sprintf("read backoff out of bounds (min = %s, max = %s)", config.ReadBackoffMin, config.ReadBackoffMax))
+	}
+
 	if config.ReadLagInterval == 0 {
 		config.ReadLagInterval = 1 * time.Minute
 	}
 
+	if config.SessionTimeout == 0 {
+		config.SessionTimeout = defaultSessionTimeout
+	}
+
 	if config.HeartbeatInterval == 0 {
 		config.HeartbeatInterval = defaultHeartbeatInterval
+		// keep the ratio of the defaults for short session timeouts
+		if interval := config.SessionTimeout / 10; interval < config.HeartbeatInterval {
+			config.HeartbeatInterval = interval
+		}
 	}
 
-	if config.SessionTimeout == 0 {
-		config.SessionTimeout = defaultSessionTimeout
+	if interval := config.SessionTimeout / 3; config.HeartbeatInterval > interval {
+		config.HeartbeatInterval = interval
 	}
 
 	if config.PartitionWatchInterval == 0 {
@@ -1158,8 +2294,8 @@ func NewReader(config ReaderConfig) *Reader {
 		config.RebalanceTimeout = defaultRebalanceTimeout
 	}
 
-	if config.RetentionTime == 0 {
-		config.RetentionTime = defaultRetentionTime
+	if config.CloseTimeout == 0 {
+		config.CloseTimeout = defaultCloseTimeout
 	}
 
 	if config.QueueCapacity == 0 {
@@ -1170,6 +2306,18 @@ func NewReader(config ReaderConfig) *Reader {
 		config.AutoOffsetReset = FirstOffset
 	}
 
+	if config.TailLookback < 0 {
+		panic(fmt.Sprintf("TailLookback out of bounds: %d", config.TailLookback))
+	}
+
+	switch config.StartOffset {
+	case 0:
+		config.StartOffset = config.AutoOffsetReset
+	case FirstOffset, LastOffset:
+	default:
+		panic(fmt.Sprintf("StartOffset must be FirstOffset or LastOffset (StartOffset = %d)", config.StartOffset))
+	}
+
 	// when configured as a consumer group; stats should report a partition of -1
 	readerStatsPartition := config.Partition
 	if config.GroupID != "" {
@@ -1190,6 +2338,7 @@ func NewReader(config ReaderConfig) *Reader {
 		cancel:  func() {},
 		done:    make(chan struct{}),
 		commits: make(chan commitRequest, config.QueueCapacity),
+		pollch:  make(chan struct{}, 1),
 		stop:    stop,
 		offset:  FirstOffset,
 		stctx:   stctx,
@@ -1197,6 +2346,7 @@ func NewReader(config ReaderConfig) *Reader {
 			dialTime:   makeSummary(),
 			readTime:   makeSummary(),
 			waitTime:   makeSummary(),
+			throttled:  makeSummary(),
 			fetchSize:  makeSummary(),
 			fetchBytes: makeSummary(),
 			// Generate the string representation of the partition number only
@@ -1207,6 +2357,10 @@ func NewReader(config ReaderConfig) *Reader {
 		offsetStash: offsetStash{},
 	}
 
+	if config.MetricsRegistry != nil {
+		r.stats.register(config.MetricsRegistry)
+	}
+
 	go r.run()
 
 	return r
@@ -1219,6 +2373,9 @@ func (r *Reader) Config() ReaderConfig {
 
 // Close closes the stream, preventing the program from reading any more
 // messages from it.
+//
+// When consumer groups are used, Close commits the offsets which are still
+// pending, waiting up to CloseTimeout, then leaves the consumer group.
 func (r *Reader) Close() error {
 	atomic.StoreUint32(&r.once, 1)
 
@@ -1237,6 +2394,10 @@ func (r *Reader) Close() error {
 		close(r.msgs)
 	}
 
+	r.txnMutex.Lock()
+	r.closeTxnCoordinator()
+	r.txnMutex.Unlock()
+
 	return nil
 }
 
@@ -1244,13 +2405,19 @@ func (r *Reader) Close() error {
 // blocks until a message becomes available, or an error occurs. The program
 // may also specify a context to asynchronously cancel the blocking operation.
 //
-// The method returns io.EOF to indicate that the reader has been closed.
+// The method returns io.EOF to indicate that the reader has been closed, and
+// ErrReadTimeout when ReaderConfig.ReadMessageTimeout expires first.
 //
 // If consumer groups are used, ReadMessage will automatically commit the
 // offset when called.
+//
+// Messages which could not be decoded by the deserializers of the reader are
+// returned along with a *DeserializationError, and committed as well. The same
+// applies to messages which the middleware of the reader failed to transform,
+// which are returned with a *MiddlewareError.
 func (r *Reader) ReadMessage(ctx context.Context) (Message, error) {
 	m, err := r.FetchMessage(ctx)
-	if err != nil {
+	if err != nil && !isMessageError(err) {
 		return Message{}, err
 	}
 
@@ -1260,75 +2427,187 @@ func (r *Reader) ReadMessage(ctx context.Context) (Message, error) {
 		}
 	}
 
-	return m, nil
+	return m, err
 }
 
 // FetchMessage reads and return the next message from the r. The method call
 // blocks until a message becomes available, or an error occurs. The program
 // may also specify a context to asynchronously cancel the blocking operation.
 //
-// The method returns io.EOF to indicate that the reader has been closed.
+// The method returns io.EOF to indicate that the reader has been closed, and
+// ErrReadTimeout when ReaderConfig.ReadMessageTimeout expires first.
 //
 // FetchMessage does not commit offsets automatically when using consumer groups.
 // Use CommitMessages to commit the offset.
+//
+// Messages which could not be decoded by the deserializers of the reader are
+// returned along with a *DeserializationError, and messages which the
+// middleware of the reader failed to transform with a *MiddlewareError. The
+// next call to FetchMessage returns the following message.
 func (r *Reader) FetchMessage(ctx context.Context) (Message, error) {
+	m, _, err := r.fetchMessage(ctx, true)
+	return m, err
+}
+
+// ReadMessages reads up to max messages from r. The method call blocks until a
+// message becomes available, or an error occurs, then returns it along with the
+// messages that the reader already fetched, without waiting for more. The
+// program may also specify a context to asynchronously cancel the blocking
+// operation.
+//
+// The method returns io.EOF to indicate that the reader has been closed. When
+// an error occurs after some messages were read, the messages are returned
+// along with the error, the program should process them before handling it.
+//
+// If consumer groups are used, ReadMessages will automatically commit the
+// offsets of the returned messages, like ReadMessage.
+func (r *Reader) ReadMessages(ctx context.Context, max int) ([]Message, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("kafka.(*Reader).ReadMessages: max must be positive, got %d", max)
+	}
+
+	var msgs []Message
+	var err error
+
+	for wait := true; len(msgs) < max; wait = false {
+		var m Message
+		var ok bool
+
+		if m, ok, err = r.fetchMessage(ctx, wait); !ok {
+			break
+		}
+		if err != nil && !isMessageError(err) {
+			break
+		}
+
+		msgs = append(msgs, m)
+		if err != nil {
+			break
+		}
+	}
+
+	if r.useConsumerGroup() && len(msgs) != 0 {
+		if err := r.CommitMessages(ctx, msgs...); err != nil {
+			return nil, err
+		}
+	}
+
+	return msgs, err
+}
+
+// fetchMessage returns the next message from r. When wait is false, it returns
+// immediately with ok set to false if no messages were fetched yet, ok is true
+// otherwise.
+func (r *Reader) fetchMessage(ctx context.Context, wait bool) (msg Message, ok bool, err error) {
 	r.activateReadLag()
 
+	r.beginPoll()
+	defer r.endPoll()
+
+	r.mutex.Lock()
+	r.fetches++
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		r.fetches--
+		r.mutex.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if wait && r.config.ReadMessageTimeout > 0 {
+		timer := time.NewTimer(r.config.ReadMessageTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	for {
 		r.mutex.Lock()
 
+		if r.fenced != nil {
+			err := r.fenced
+			r.mutex.Unlock()
+			return Message{}, true, err
+		}
+
 		if !r.closed && r.version == 0 {
-			r.start(map[int]int64{r.config.Partition: r.offset})
+			r.start(offsetStash{r.config.Topic: {r.config.Partition: r.offset}})
 		}
 
 		version := r.version
 		r.mutex.Unlock()
 
-		select {
-		case <-ctx.Done():
-			return Message{}, ctx.Err()
+		var m readerMessage
+		var open bool
 
-		case m, ok := <-r.msgs:
-			if !ok {
-				return Message{}, io.EOF
+		if wait {
+			select {
+			case <-ctx.Done():
+				return Message{}, true, ctx.Err()
+			case <-timeout:
+				return Message{}, true, ErrReadTimeout
+			case m, open = <-r.msgs:
+			}
+		} else {
+			select {
+			case m, open = <-r.msgs:
+			default:
+				return Message{}, false, nil
 			}
+		}
 
-			if m.version >= version {
-				r.mutex.Lock()
+		if !open {
+			return Message{}, true, io.EOF
+		}
 
-				switch {
-				case m.error != nil:
-				case version == r.version:
-					r.offset = m.message.Offset + 1
-					r.lag = m.watermark - r.offset
-				}
+		if m.version >= version {
+			r.mutex.Lock()
 
-				r.mutex.Unlock()
+			switch {
+			case m.error != nil:
+			case version == r.version:
+				r.offset = m.message.Offset + 1
+				r.lag = m.watermark - r.offset
+			}
+
+			r.mutex.Unlock()
+
+			switch m.error {
+			case nil:
+			case io.EOF:
+				// io.EOF is used as a marker to indicate that the stream
+				// has been closed, in case it was received from the inner
+				// reader we don't want to confuse the program and replace
+				// the error with io.ErrUnexpectedEOF.
+				m.error = io.ErrUnexpectedEOF
+			}
 
-				switch m.error {
-				case nil:
-				case io.EOF:
-					// io.EOF is used as a marker to indicate that the stream
-					// has been closed, in case it was received from the inner
-					// reader we don't want to confuse the program and replace
-					// the error with io.ErrUnexpectedEOF.
-					m.error = io.ErrUnexpectedEOF
+			if m.error == nil {
+				if err := r.transform(&m.message); err != nil {
+					return m.message, true, err
+				}
+				if err := r.deserialize(&m.message); err != nil {
+					return m.message, true, err
 				}
-
-				return m.message, m.error
 			}
+
+			return m.message, true, m.error
 		}
 	}
 }
 
 // CommitMessages commits the list of messages passed as argument. The program
 // may pass a context to asynchronously cancel the commit operation when it was
-// configured to be blocking.
+// configured to be blocking (see ReaderConfig.CommitMode).
 func (r *Reader) CommitMessages(ctx context.Context, msgs ...Message) error {
 	if !r.useConsumerGroup() {
 		return errOnlyAvailableWithGroup
 	}
 
+	if err := r.fencedError(); err != nil {
+		return err
+	}
+
 	var errch <-chan error
 	var sync = r.useSyncCommits()
 	var creq = commitRequest{
@@ -1362,6 +2641,194 @@ func (r *Reader) CommitMessages(ctx context.Context, msgs ...Message) error {
 	}
 }
 
+// CommitOffsets commits the offsets passed as argument, indexed by topic and
+// partition, for the consumer group of the reader. Contrary to CommitMessages
+// the offsets do not have to be the ones of messages read by the program, and
+// they replace the committed offsets even when they are lower, which lets
+// programs reset the position of the group to an arbitrary point (for example
+// FirstOffset or LastOffset resolved by Conn.ReadFirstOffset and
+// Conn.ReadLastOffset). The offsets are the ones of the next messages to be
+// consumed.
+//
+// The commit is always synchronous, whatever the value of CommitInterval, and
+// is sent once the reader has joined the group, the method blocks until then
+// or until ctx is canceled. When the coordinator rejects the commit of some
+// partitions the method returns an *OffsetCommitError holding the error code
+// of each of them.
+//
+// Note that partitions consumed by the reader keep being read from their
+// current position, messages committed with CommitMessages afterwards will
+// overwrite the offsets set by this method.
+func (r *Reader) CommitOffsets(ctx context.Context, offsets map[string]map[int]int64) error {
+	return r.CommitOffsetsWith(ctx, CommitOptions{}, offsets)
+}
+
+// CommitOptions configures a call to Reader.CommitOffsetsWith.
+type CommitOptions struct {
+	// RetentionTime sets the length of time the broker retains the committed
+	// offsets, overriding ReaderConfig.RetentionTime for this commit.
+	//
+	// Only v2 of the OffsetCommit request carries the retention time, while the
+	// leader epochs of the partitions are only carried by v6 and above, so the
+	// offsets are committed without the leader epochs when it is set, and the
+	// log truncation that they help detect goes unnoticed.
+	//
+	// Default: 0, which applies ReaderConfig.RetentionTime.
+	RetentionTime time.Duration
+}
+
+// CommitOffsetsWith behaves like CommitOffsets, using the options passed as
+// argument for this commit.
+func (r *Reader) CommitOffsetsWith(ctx context.Context, opts CommitOptions, offsets map[string]map[int]int64) error {
+	if opts.RetentionTime < 0 {
+		return fmt.Errorf("invalid retention time: %s", opts.RetentionTime)
+	}
+
+	if !r.useConsumerGroup() {
+		return errOnlyAvailableWithGroup
+	}
+
+	if err := r.fencedError(); err != nil {
+		return err
+	}
+
+	stash := offsetStash{}
+	for topic, partitions := range offsets {
+		if len(partitions) == 0 {
+			continue
+		}
+		stash[topic] = make(map[int]int64, len(partitions))
+		for partition, offset := range partitions {
+			if offset < 0 {
+				return fmt.Errorf("invalid offset %d for partition %d of topic %s", offset, partition, topic)
+			}
+			stash[topic][partition] = offset
+		}
+	}
+
+	errch := make(chan error, 1)
+	creq := commitRequest{
+		offsets:   stash,
+		errch:     errch,
+		retention: opts.RetentionTime,
+	}
+
+	select {
+	case r.commits <- creq:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.stctx.Done():
+		return io.ErrClosedPipe
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errch:
+		return err
+	}
+}
+
+// CommitMessagesInTxn commits the offsets of msgs for the consumer group of the
+// reader as part of the ongoing transaction of producer, which lets programs
+// consuming messages and writing the result of their processing to kafka in
+// transactions process each message exactly once. txn must be connected to the
+// transaction coordinator of the producer (see Dialer.LookupCoordinator).
+//
+// The offsets only become the committed offsets of the group when the
+// transaction is committed with Conn.EndTxn, and are discarded if it is
+// aborted, in which case the program must read the messages again. Messages
+// committed this way must not be committed with CommitMessages as well, and
+// the readers consuming the output of the program should use the
+// ReadCommitted isolation level (see ReaderConfig.IsolationLevel).
+//
+// The records of the transaction are written with Conn.Produce, the package
+// has no transactional Writer. The reader keeps its connection to the group
+// coordinator open between calls, which are serialized.
+//
+// Transactions are supported by kafka 0.11 and above.
+func (r *Reader) CommitMessagesInTxn(ctx context.Context, txn *Conn, producer TransactionalProducer, msgs ...Message) error {
+	if !r.useConsumerGroup() {
+		return errOnlyAvailableWithGroup
+	}
+
+	if err := r.fencedError(); err != nil {
+		return err
+	}
+
+	offsets := offsetStash{}
+	offsets.merge(makeCommits(msgs...))
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	if err := txn.AddOffsetsToTxn(producer, r.config.GroupID); err != nil {
+		return fmt.Errorf("unable to add the offsets of group %v to the transaction: %v", r.config.GroupID, err)
+	}
+
+	r.txnMutex.Lock()
+	defer r.txnMutex.Unlock()
+
+	conn, err := r.txnCoordinator(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	err = conn.TxnOffsetCommit(producer, r.config.GroupID, offsets)
+	switch err.(type) {
+	case nil, Error, *OffsetCommitError:
+	default:
+		// the connection may be broken, it is dialed again by the next call.
+		r.closeTxnCoordinator()
+	}
+	return err
+}
+
+// txnCoordinator returns the connection to the group coordinator used by
+// CommitMessagesInTxn, which is dialed again when the reader found a different
+// coordinator. txnMutex must be held.
+func (r *Reader) txnCoordinator(ctx context.Context) (*Conn, error) {
+	r.mutex.Lock()
+	address, closed := r.address, r.closed
+	r.mutex.Unlock()
+
+	switch {
+	case closed:
+		return nil, io.ErrClosedPipe
+	case address == "" && r.txnConn != nil:
+		// the reader did not join the group yet.
+		return r.txnConn, nil
+	case address == "":
+		var err error
+		if address, err = r.lookupCoordinator(); err != nil {
+			return nil, err
+		}
+	case address == r.txnAddress:
+		return r.txnConn, nil
+	}
+
+	r.closeTxnCoordinator()
+
+	conn, err := r.config.Dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to coordinator, %v", address)
+	}
+	r.txnConn, r.txnAddress = conn, address
+	return conn, nil
+}
+
+// closeTxnCoordinator closes the connection used by CommitMessagesInTxn.
+// txnMutex must be held.
+func (r *Reader) closeTxnCoordinator() {
+	if r.txnConn != nil {
+		r.txnConn.Close()
+		r.txnConn, r.txnAddress = nil, ""
+	}
+}
+
 // ReadLag returns the current lag of the reader by fetching the last offset of
 // the topic and partition and computing the difference between that value and
 // the offset of the last message returned by ReadMessage.
@@ -1390,7 +2857,7 @@ func (r *Reader) ReadLag(ctx context.Context) (lag int64, err error) {
 		var off offsets
 		var err error
 
-		for _, broker := range r.config.Brokers {
+		for _, broker := range r.brokers() {
 			var conn *Conn
 
 			if conn, err = r.config.Dialer.DialLeader(ctx, "tcp", broker, r.config.Topic, r.config.Partition); err != nil {
@@ -1435,8 +2902,9 @@ func (r *Reader) ReadLag(ctx context.Context) (lag int64, err error) {
 	return
 }
 
-// Offset returns the current absolute offset of the reader, or -1
-// if r is backed by a consumer group.
+// Offset returns the current absolute offset of the reader, which is the offset
+// of the next message returned by FetchMessage, or -1 if r is backed by a
+// consumer group. It is safe to call concurrently with the other methods.
 func (r *Reader) Offset() int64 {
 	if r.useConsumerGroup() {
 		return -1
@@ -1445,9 +2913,7 @@ func (r *Reader) Offset() int64 {
 	r.mutex.Lock()
 	offset := r.offset
 	r.mutex.Unlock()
-	r.withLogger(func(log *log.Logger) {
-		log.Printf("looking up offset of kafka reader for partition %d of %s: %d", r.config.Partition, r.config.Topic, offset)
-	})
+	r.logger().Debug("looking up reader offset", "topic", r.config.Topic, "partition", r.config.Partition, "offset", offset)
 	return offset
 }
 
@@ -1465,7 +2931,9 @@ func (r *Reader) Lag() int64 {
 }
 
 // SetOffset changes the offset from which the next batch of messages will be
-// read. The method fails with io.ErrClosedPipe if the reader has already been closed.
+// read. The method fails with io.ErrClosedPipe if the reader has already been closed,
+// and with ErrFetchInProgress if a call to FetchMessage or ReadMessage is waiting
+// for a message, the offset must be set between reads.
 //
 // From version 0.2.0, FirstOffset and LastOffset can be used to indicate the first
 // or last available offset in the partition. Please note while -1 and -2 were accepted
@@ -1482,15 +2950,14 @@ func (r *Reader) SetOffset(offset int64) error {
 
 	if r.closed {
 		err = io.ErrClosedPipe
+	} else if r.fetches != 0 {
+		err = ErrFetchInProgress
 	} else if offset != r.offset {
-		r.withLogger(func(log *log.Logger) {
-			log.Printf("setting the offset of the kafka reader for partition %d of %s from %d to %d",
-				r.config.Partition, r.config.Topic, r.offset, offset)
-		})
+		r.logger().Info("setting reader offset", "topic", r.config.Topic, "partition", r.config.Partition, "old", r.offset, "new", offset)
 		r.offset = offset
 
 		if r.version != 0 {
-			r.start(map[int]int64{r.config.Partition: r.offset})
+			r.start(offsetStash{r.config.Topic: {r.config.Partition: r.offset}})
 		}
 
 		r.activateReadLag()
@@ -1504,7 +2971,7 @@ func (r *Reader) SetOffset(offset int64) error {
 // read given the timestamp t.
 //
 // The method fails if the unable to connect partition leader, or unable to read the offset
-// given the ts, or if the reader has been closed.
+// given the ts, or if the reader has been closed, or if a fetch is in progress.
 func (r *Reader) SetOffsetAt(ctx context.Context, t time.Time) error {
 	r.mutex.Lock()
 	if r.closed {
@@ -1513,7 +2980,7 @@ func (r *Reader) SetOffsetAt(ctx context.Context, t time.Time) error {
 	}
 	r.mutex.Unlock()
 
-	for _, broker := range r.config.Brokers {
+	for _, broker := range r.brokers() {
 		conn, err := r.config.Dialer.DialLeader(ctx, "tcp", broker, r.config.Topic, r.config.Partition)
 		if err != nil {
 			continue
@@ -1532,6 +2999,48 @@ func (r *Reader) SetOffsetAt(ctx context.Context, t time.Time) error {
 	return fmt.Errorf("error setting offset for timestamp %+v", t)
 }
 
+// Pause stops fetching messages from the given partitions of the reader's
+// topic until Resume is called for them. Messages that were already fetched
+// from paused partitions may still be returned by ReadMessage and
+// FetchMessage.
+//
+// Pausing partitions does not affect the membership of a reader that is part
+// of a consumer group: heartbeats keep being sent to the group coordinator,
+// even when all partitions are paused, so the reader isn't evicted from the
+// group. The paused partitions are retained across rebalances.
+//
+// When the reader subscribes to multiple topics, with GroupTopics or
+// GroupTopicRegex, the partitions are paused in each of the topics that it
+// subscribes to when the method is called. PauseTopic pauses the partitions
+// of a single topic.
+func (r *Reader) Pause(partitions ...int) {
+	for _, topic := range r.topics() {
+		r.PauseTopic(topic, partitions...)
+	}
+}
+
+// Resume restarts fetching messages from the given partitions, which were
+// previously paused by a call to Pause. Resuming partitions that are not paused
+// has no effect. Like Pause, the partitions are resumed in each of the topics
+// that the reader subscribes to.
+func (r *Reader) Resume(partitions ...int) {
+	for _, topic := range r.topics() {
+		r.ResumeTopic(topic, partitions...)
+	}
+}
+
+// PauseTopic is like Pause but only pauses the partitions of topic.
+func (r *Reader) PauseTopic(topic string, partitions ...int) {
+	r.logger().Info("pausing partitions", "topic", topic, "partitions", partitions)
+	r.paused.pause(topic, partitions...)
+}
+
+// ResumeTopic is like Resume but only resumes the partitions of topic.
+func (r *Reader) ResumeTopic(topic string, partitions ...int) {
+	r.logger().Info("resuming partitions", "topic", topic, "partitions", partitions)
+	r.paused.resume(topic, partitions...)
+}
+
 // Stats returns a snapshot of the reader stats since the last time the method
 // was called, or since the reader was created if it is called for the first
 // time.
@@ -1548,9 +3057,11 @@ func (r *Reader) Stats() ReaderStats {
 		Rebalances:    r.stats.rebalances.snapshot(),
 		Timeouts:      r.stats.timeouts.snapshot(),
 		Errors:        r.stats.errors.snapshot(),
+		CommitErrors:  r.stats.commitErrors.snapshot(),
 		DialTime:      r.stats.dialTime.snapshotDuration(),
 		ReadTime:      r.stats.readTime.snapshotDuration(),
 		WaitTime:      r.stats.waitTime.snapshotDuration(),
+		Throttled:     r.stats.throttled.snapshotDuration(),
 		FetchSize:     r.stats.fetchSize.snapshot(),
 		FetchBytes:    r.stats.fetchBytes.snapshot(),
 		Offset:        r.stats.offset.snapshot(),
@@ -1558,31 +3069,21 @@ func (r *Reader) Stats() ReaderStats {
 		MinBytes:      int64(r.config.MinBytes),
 		MaxBytes:      int64(r.config.MaxBytes),
 		MaxWait:       r.config.MaxWait,
+		ReadBackoff:   time.Duration(r.stats.backoff.snapshot()),
 		QueueLength:   int64(len(r.msgs)),
 		QueueCapacity: int64(cap(r.msgs)),
 		ClientID:      r.config.Dialer.ClientID,
-		Topic:         r.config.Topic,
+		Topic:         strings.Join(r.topics(), ","),
 		Partition:     r.stats.partition,
 	}
+	if t, ok := r.stats.lastRebalance.Load().(time.Time); ok {
+		stats.LastRebalance = t
+	}
 	// TODO: remove when we get rid of the deprecated field.
 	stats.DeprecatedFetchesWithTypo = stats.Fetches
 	return stats
 }
 
-func (r *Reader) withLogger(do func(*log.Logger)) {
-	if r.config.Logger != nil {
-		do(r.config.Logger)
-	}
-}
-
-func (r *Reader) withErrorLogger(do func(*log.Logger)) {
-	if r.config.ErrorLogger != nil {
-		do(r.config.ErrorLogger)
-	} else {
-		r.withLogger(do)
-	}
-}
-
 func (r *Reader) activateReadLag() {
 	if r.config.ReadLagInterval > 0 && atomic.CompareAndSwapUint32(&r.once, 0, 1) {
 		// read lag will only be calculated when not using consumer groups
@@ -1604,9 +3105,7 @@ func (r *Reader) readLag(ctx context.Context) {
 
 		if err != nil {
 			r.stats.errors.observe(1)
-			r.withErrorLogger(func(log *log.Logger) {
-				log.Printf("kafka reader failed to read lag of partition %d of %s", r.config.Partition, r.config.Topic)
-			})
+			r.logger().Error("failed to read lag", "topic", r.config.Topic, "partition", r.config.Partition, "error", err)
 		} else {
 			r.stats.lag.observe(lag)
 		}
@@ -1619,7 +3118,7 @@ func (r *Reader) readLag(ctx context.Context) {
 	}
 }
 
-func (r *Reader) start(offsetsByPartition map[int]int64) {
+func (r *Reader) start(offsetsByTopicAndPartition offsetStash) {
 	if r.closed {
 		// don't start child reader if parent Reader is closed
 		return
@@ -1631,47 +3130,84 @@ func (r *Reader) start(offsetsByPartition map[int]int64) {
 	r.cancel = cancel
 	r.version++
 
-	r.join.Add(len(offsetsByPartition))
-	for partition, offset := range offsetsByPartition {
-		go func(ctx context.Context, partition int, offset int64, join *sync.WaitGroup) {
-			defer join.Done()
-
-			(&reader{
-				dialer:          r.config.Dialer,
-				logger:          r.config.Logger,
-				errorLogger:     r.config.ErrorLogger,
-				brokers:         r.config.Brokers,
-				topic:           r.config.Topic,
-				partition:       partition,
-				minBytes:        r.config.MinBytes,
-				maxBytes:        r.config.MaxBytes,
-				maxWait:         r.config.MaxWait,
-				version:         r.version,
-				msgs:            r.msgs,
-				stats:           r.stats,
-				autoOffsetReset: r.config.AutoOffsetReset,
-			}).run(ctx, offset)
-		}(ctx, partition, offset, &r.join)
+	for topic, offsetsByPartition := range offsetsByTopicAndPartition {
+		r.join.Add(len(offsetsByPartition))
+		for partition, offset := range offsetsByPartition {
+			go r.runPartitionReader(ctx, topic, partition, offset, r.version, &r.join)
+		}
 	}
 }
 
+// runPartitionReader reads messages from a partition of topic, starting at
+// offset, until ctx is canceled. The messages are tagged with version, which is
+// passed by the caller because r.version is synchronized on the mutex.
+func (r *Reader) runPartitionReader(ctx context.Context, topic string, partition int, offset int64, version int64, join *sync.WaitGroup) {
+	defer join.Done()
+
+	(&reader{
+		dialer:          r.config.Dialer,
+		logger:          r.logger(),
+		brokers:         r.brokers(),
+		topic:           topic,
+		partition:       partition,
+		minBytes:        r.config.MinBytes,
+		maxBytes:        r.config.MaxBytes,
+		partitionMax:    r.config.PartitionMaxBytes,
+		maxWait:         r.config.MaxWait,
+		readTimeout:     r.config.ReadBatchTimeout,
+		metadataRefresh: r.config.MetadataRefreshInterval,
+		idleTimeout:     r.config.IdleTimeout,
+		lookback:        r.config.TailLookback,
+		checkCRCs:       r.config.CheckCRCs,
+		rackID:          r.config.RackID,
+		isolationLevel:  r.config.IsolationLevel,
+		fetchSessions:   r.config.FetchSessions,
+		replica:         -1,
+		backoffMin:      r.config.ReadBackoffMin,
+		backoffMax:      r.config.ReadBackoffMax,
+		version:         version,
+		msgs:            r.msgs,
+		stats:           r.stats,
+		paused:          &r.paused,
+		leaderEpochs:    &r.leaderEpochs,
+		autoOffsetReset: r.config.AutoOffsetReset,
+	}).run(ctx, offset)
+}
+
 // A reader reads messages from kafka and produces them on its channels, it's
 // used as an way to asynchronously fetch messages while the main program reads
 // them using the high level reader API.
 type reader struct {
 	dialer          *Dialer
-	logger          *log.Logger
-	errorLogger     *log.Logger
+	logger          Logger
 	brokers         []string
 	topic           string
 	partition       int
 	minBytes        int
 	maxBytes        int
+	partitionMax    int
 	maxWait         time.Duration
+	readTimeout     time.Duration
+	metadataRefresh time.Duration
+	idleTimeout     time.Duration
+	lookback        int64
+	checkCRCs       CRCValidation
+	rackID          string
+	isolationLevel  IsolationLevel
+	fetchSessions   bool
+	backoffMin      time.Duration
+	backoffMax      time.Duration
 	version         int64
 	msgs            chan<- readerMessage
 	stats           *readerStats
+	paused          *pausedPartitions
+	leaderEpochs    *leaderEpochs
 	autoOffsetReset int64
+
+	// replica is the ID of the broker designated by the partition leader to
+	// fetch from until replicaExpires, or -1 to fetch from the leader.
+	replica        int
+	replicaExpires time.Time
 }
 
 type readerMessage struct {
@@ -1682,9 +3218,6 @@ type readerMessage struct {
 }
 
 func (r *reader) run(ctx context.Context, offset int64) {
-	const backoffDelayMin = 100 * time.Millisecond
-	const backoffDelayMax = 1 * time.Second
-
 	// This is the reader's main loop, it only ends if the context is canceled
 	// and will keep attempting to reader messages otherwise.
 	//
@@ -1694,16 +3227,24 @@ func (r *reader) run(ctx context.Context, offset int64) {
 	// be surfaced to the program.
 	// If the reader wasn't retrying then the program would block indefinitely
 	// on a Read call after reading the first error.
+	paused := false
+	corrupted, corruptOffset := 0, int64(-1)
 	for attempt := 0; true; attempt++ {
-		if attempt != 0 {
-			if !sleep(ctx, backoff(attempt, backoffDelayMin, backoffDelayMax)) {
+		if paused {
+			// The reader didn't leave the read loop because of an error, so
+			// it reconnects as soon as the partition is resumed.
+			paused, attempt = false, 0
+		} else if attempt != 0 {
+			if !sleep(ctx, r.backoff(attempt)) {
 				return
 			}
 		}
 
-		r.withLogger(func(log *log.Logger) {
-			log.Printf("initializing kafka reader for partition %d of %s starting at offset %d", r.partition, r.topic, offset)
-		})
+		if !r.waitResumed(ctx) {
+			return
+		}
+
+		r.logger.Debug("initializing partition reader", "topic", r.topic, "partition", r.partition, "offset", offset)
 
 		conn, start, err := r.initialize(ctx, offset)
 		switch err {
@@ -1712,9 +3253,13 @@ func (r *reader) run(ctx context.Context, offset int64) {
 			// This would happen if the requested offset is passed the last
 			// offset on the partition leader. In that case we're just going
 			// to retry later hoping that enough data has been produced.
-			r.withErrorLogger(func(log *log.Logger) {
-				log.Printf("error initializing the kafka reader for partition %d of %s: %s", r.partition, r.topic, OffsetOutOfRange)
-			})
+			r.logger.Warn("failed to initialize partition reader, retrying", "topic", r.topic, "partition", r.partition, "attempt", attempt, "error", OffsetOutOfRange)
+			continue
+		case NotLeaderForPartition, LeaderNotAvailable:
+			// The leader of the partition is moving to another broker, the
+			// metadata are looked up again until the election completes,
+			// which is not reported as an error to the program.
+			r.logger.Warn("partition leader is changing, retrying", "topic", r.topic, "partition", r.partition, "attempt", attempt, "error", err)
 			continue
 		default:
 			// Wait 4 attempts before reporting the first errors, this helps
@@ -1724,70 +3269,149 @@ func (r *reader) run(ctx context.Context, offset int64) {
 				r.sendError(ctx, err)
 			} else {
 				r.stats.errors.observe(1)
-				r.withErrorLogger(func(log *log.Logger) {
-					log.Printf("error initializing the kafka reader for partition %d of %s: %s", r.partition, r.topic, err)
-				})
+				r.logger.Warn("failed to initialize partition reader, retrying", "topic", r.topic, "partition", r.partition, "attempt", attempt, "error", err)
 			}
 			continue
 		}
 
-		// Resetting the attempt counter ensures that if a failure occurs after
-		// a successful initialization we don't keep increasing the backoff
-		// timeout.
-		attempt = 0
-
 		// Now we're sure to have an absolute offset number, may anything happen
 		// to the connection we know we'll want to restart from this offset.
 		offset = start
 
+		r.leaderEpochs.set(r.topic, r.partition, conn.leaderEpoch)
+
+		if r.replica >= 0 {
+			// Like the java client after refreshing its metadata, the reader
+			// asks the leader again once the metadata expire. The lease
+			// starts once connected so the replica is fetched from at least
+			// once.
+			r.replicaExpires = time.Now().Add(r.metadataRefresh)
+		}
+
 		errcount := 0
+		metadataExpires := time.Now().Add(r.metadataRefresh)
+		var leader <-chan Broker
+		lastFetch := time.Now()
 	readLoop:
 		for {
-			if !sleep(ctx, backoff(errcount, backoffDelayMin, backoffDelayMax)) {
+			if !sleep(ctx, r.backoff(errcount)) {
 				conn.Close()
 				return
 			}
 
+			if r.paused.isPaused(r.topic, r.partition) {
+				// The connection is released while the partition is paused so
+				// it doesn't sit idle until the broker closes it, the next call
+				// to .initialize resumes reading from the current offset.
+				r.logger.Info("pausing partition reader", "topic", r.topic, "partition", r.partition, "offset", offset)
+				conn.Close()
+				paused = true
+				break readLoop
+			}
+
+			if r.idleTimeout != 0 && time.Since(lastFetch) > r.maxWait+r.idleTimeout {
+				// The reader was blocked delivering the messages of the last
+				// fetch response, the connection may have been dropped by the
+				// network since. The broker may have held the fetch request
+				// for up to maxWait before responding.
+				r.logger.Debug("connection idle, reconnecting", "topic", r.topic, "partition", r.partition, "offset", offset)
+				conn.Close()
+				break readLoop
+			}
+
+			if r.replica >= 0 && time.Now().After(r.replicaExpires) {
+				// The partition leader may designate another replica, or
+				// none, since the reader connected to this one.
+				r.logger.Debug("read replica lease expired, fetching from the partition leader", "topic", r.topic, "partition", r.partition, "replica", r.replica)
+				conn.Close()
+				break readLoop
+			}
+
+			if now := time.Now(); now.After(metadataExpires) {
+				// The read replica lease already sends the reader back to the
+				// leader periodically.
+				metadataExpires = now.Add(r.metadataRefresh)
+				if r.replica < 0 && leader == nil {
+					leader = r.dialer.refreshLeader(ctx, conn.RemoteAddr().Network(), r.readTimeout, r.brokers, r.topic, r.partition)
+				}
+			}
+
+			select {
+			case broker := <-leader:
+				leader = nil
+				if broker.ID >= 0 && broker.ID != conn.Broker().ID {
+					r.logger.Info("partition leader moved, reconnecting to the new leader", "topic", r.topic, "partition", r.partition, "offset", offset, "broker", conn.Broker().ID)
+					conn.Close()
+					break readLoop
+				}
+			default:
+			}
+
+			lastFetch = time.Now()
 			switch offset, err = r.read(ctx, offset, conn); err {
 			case nil:
-				errcount = 0
+				// Resetting the attempt counter ensures that if a failure
+				// occurs after a successful fetch we don't keep increasing the
+				// backoff timeout, while consecutive failures to fetch from the
+				// partition leader keep backing off, even across reconnects.
+				errcount, attempt = 0, 0
 			case UnknownTopicOrPartition:
-				r.withErrorLogger(func(log *log.Logger) {
-					log.Printf("failed to read from current broker for partition %d of %s at offset %d, topic or parition not found on this broker, %v", r.partition, r.topic, offset, r.brokers)
-				})
+				r.logger.Warn("topic or partition not found on broker, looking up the partition leader", "topic", r.topic, "partition", r.partition, "offset", offset, "brokers", r.brokers)
 
 				conn.Close()
 
 				// The next call to .initialize will re-establish a connection to the proper
 				// topic/partition broker combo.
-				r.stats.rebalances.observe(1)
 				break readLoop
-			case NotLeaderForPartition:
-				r.withErrorLogger(func(log *log.Logger) {
-					log.Printf("failed to read from current broker for partition %d of %s at offset %d, not the leader", r.partition, r.topic, offset)
-				})
+			case NotLeaderForPartition, LeaderNotAvailable:
+				r.logger.Warn("broker is not the partition leader, looking up the new leader", "topic", r.topic, "partition", r.partition, "offset", offset, "error", err)
 
 				conn.Close()
 
 				// The next call to .initialize will re-establish a connection to the proper
 				// partition leader.
-				r.stats.rebalances.observe(1)
+				break readLoop
+			case FencedLeaderEpoch, UnknownLeaderEpoch:
+				// The leader epoch known by the reader is either older or
+				// newer than the one of the broker, the metadata are looked
+				// up again so the reader doesn't fetch from a deposed leader.
+				r.logger.Warn("partition leader epoch mismatch, refreshing metadata", "topic", r.topic, "partition", r.partition, "offset", offset, "error", err)
+
+				conn.Close()
+				break readLoop
+
+			case errReadReplica:
+				r.logger.Info("fetching from the replica designated by the partition leader", "topic", r.topic, "partition", r.partition, "offset", offset, "replica", r.replica)
+				conn.Close()
 				break readLoop
 
 			case RequestTimedOut:
 				// Timeout on the kafka side, this can be safely retried.
-				errcount = 0
-				r.withLogger(func(log *log.Logger) {
-					log.Printf("no messages received from kafka within the allocated time for partition %d of %s at offset %d", r.partition, r.topic, offset)
-				})
+				errcount, attempt = 0, 0
+				r.logger.Debug("no messages received within the allocated time", "topic", r.topic, "partition", r.partition, "offset", offset)
 				r.stats.timeouts.observe(1)
 				continue
 
+			case FetchSessionIDNotFound, InvalidFetchSessionEpoch:
+				// The broker evicted the fetch session or lost track of its
+				// epoch, the next fetch request creates a new session.
+				errcount, attempt = 0, 0
+				r.logger.Debug("fetch session expired, creating a new one", "topic", r.topic, "partition", r.partition, "offset", offset, "error", err)
+				continue
+
 			case OffsetOutOfRange:
 				// We've probably tried to read an offset that has been deleted,
 				// e.g. because the message exceeded the topic's retention policy.
 				// ReaderConfig.AutoOffsetReset controls where the reader seeks to.
 
+				if r.replica >= 0 {
+					// The replica may be lagging behind, the offset is
+					// checked again against the partition leader.
+					r.logger.Warn("offset out of range on the read replica, fetching from the partition leader", "topic", r.topic, "partition", r.partition, "offset", offset, "replica", r.replica)
+					conn.Close()
+					break readLoop
+				}
+
 				before := offset
 
 				if r.autoOffsetReset == FirstOffset {
@@ -1796,21 +3420,35 @@ func (r *reader) run(ctx context.Context, offset int64) {
 					offset, err = conn.Seek(0, SeekEnd)
 				}
 				if err != nil {
-					r.withErrorLogger(func(log *log.Logger) {
-						log.Printf("error while seeking to new offset: %v", err)
-					})
+					r.logger.Error("failed to seek to new offset", "topic", r.topic, "partition", r.partition, "error", err)
 					conn.Close()
 					break readLoop
 				}
 
-				r.withErrorLogger(func(log *log.Logger) {
-					log.Printf("the kafka reader is reading offset out of range for partition %d of %s, skipping from offset %d to %d (%d messages)", r.partition, r.topic, before, offset, offset-before)
-				})
+				r.logger.Warn("offset out of range, skipping messages", "topic", r.topic, "partition", r.partition, "old", before, "new", offset, "skipped", offset-before)
 
 				// set errcount = 0 so that we retry immediately.
 				errcount = 0
 				continue
 
+			case ErrCorruptBatch:
+				// The batch is fetched again from a new connection in case it
+				// was corrupted in transit. The reader can't read past a batch
+				// which is corrupted on the broker, the error is reported to
+				// the program once and the reader stops.
+				r.stats.errors.observe(1)
+				conn.Close()
+				if offset != corruptOffset {
+					corrupted, corruptOffset = 0, offset
+				}
+				if corrupted++; corrupted < maxCorruptBatchAttempts {
+					r.logger.Warn("record batch is corrupted, fetching it again", "topic", r.topic, "partition", r.partition, "offset", offset, "attempt", corrupted)
+					break readLoop
+				}
+				r.logger.Error("record batch is corrupted, stopping the partition reader", "topic", r.topic, "partition", r.partition, "offset", offset, "attempts", corrupted)
+				r.sendError(ctx, err)
+				return
+
 			case context.Canceled:
 				// Another reader has taken over, we can safely quit.
 				conn.Close()
@@ -1820,9 +3458,7 @@ func (r *reader) run(ctx context.Context, offset int64) {
 				if _, ok := err.(Error); ok {
 					r.sendError(ctx, err)
 				} else {
-					r.withErrorLogger(func(log *log.Logger) {
-						log.Printf("the kafka reader got an unknown error reading partition %d of %s at offset %d: %s", r.partition, r.topic, offset, err)
-					})
+					r.logger.Error("unknown error reading partition", "topic", r.topic, "partition", r.partition, "offset", offset, "error", err)
 					r.stats.errors.observe(1)
 					conn.Close()
 					break readLoop
@@ -1831,10 +3467,51 @@ func (r *reader) run(ctx context.Context, offset int64) {
 
 			errcount++
 		}
+
+		// Unless the leader just designated it, the reader stops fetching from
+		// the read replica whenever it leaves the read loop.
+		if err != errReadReplica {
+			r.replica = -1
+		}
+	}
+}
+
+// backoff returns the delay to wait before the given attempt to fetch messages
+// or reconnect to the partition leader, and reports it in the reader stats.
+func (r *reader) backoff(attempt int) time.Duration {
+	var d time.Duration
+	if attempt != 0 {
+		d = exponentialBackoff(attempt, r.backoffMin, r.backoffMax)
+	}
+	r.stats.backoff.observe(int64(d))
+	return d
+}
+
+// waitResumed blocks until the reader's partition is not paused anymore. The
+// method returns false if ctx was canceled before that happened.
+func (r *reader) waitResumed(ctx context.Context) bool {
+	resumed := r.paused.resumed(r.topic, r.partition)
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		r.logger.Info("resuming partition reader", "topic", r.topic, "partition", r.partition)
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
 func (r *reader) initialize(ctx context.Context, offset int64) (conn *Conn, start int64, err error) {
+	if r.replica >= 0 {
+		if conn, err = r.dialReplica(ctx, offset); err == nil {
+			return conn, offset, nil
+		}
+		r.logger.Warn("failed to connect to the read replica, fetching from the partition leader", "topic", r.topic, "partition", r.partition, "replica", r.replica, "error", err)
+		r.replica = -1
+	}
+
 	for i := 0; i != len(r.brokers) && conn == nil; i++ {
 		var broker = r.brokers[i]
 		var first, last int64
@@ -1860,15 +3537,16 @@ func (r *reader) initialize(ctx context.Context, offset int64) (conn *Conn, star
 			offset = first
 
 		case offset == LastOffset:
-			offset = last
+			offset = last - r.lookback
+			if offset < first {
+				offset = first
+			}
 
 		case offset < first:
 			offset = first
 		}
 
-		r.withLogger(func(log *log.Logger) {
-			log.Printf("the kafka reader for partition %d of %s is seeking to offset %d", r.partition, r.topic, offset)
-		})
+		r.logger.Debug("seeking to offset", "topic", r.topic, "partition", r.partition, "offset", offset)
 
 		if start, err = conn.Seek(offset, SeekAbsolute); err != nil {
 			conn.Close()
@@ -1882,18 +3560,91 @@ func (r *reader) initialize(ctx context.Context, offset int64) (conn *Conn, star
 	return
 }
 
+// dialReplica connects to the read replica designated by the partition leader,
+// positioned at offset. The offset is not validated since the replica only
+// serves fetch requests, an offset out of range sends the reader back to the
+// leader.
+func (r *reader) dialReplica(ctx context.Context, offset int64) (*Conn, error) {
+	var err error
+
+	for _, broker := range r.brokers {
+		var p Partition
+
+		if p, err = r.dialer.LookupPartition(ctx, "tcp", broker, r.topic, r.partition); err != nil {
+			continue
+		}
+
+		for _, replica := range p.Replicas {
+			if replica.ID != r.replica {
+				continue
+			}
+
+			// DialPartition connects to the leader of the partition it is
+			// given, which is replaced by the replica.
+			p.Leader = replica
+
+			t0 := time.Now()
+			conn, err := r.dialer.DialPartition(ctx, "tcp", broker, p)
+			t1 := time.Now()
+			r.stats.dials.observe(1)
+			r.stats.dialTime.observeDuration(t1.Sub(t0))
+
+			if err != nil {
+				return nil, err
+			}
+
+			// The replica validates the leader epoch like the leader does.
+			conn.leaderEpoch = int32(p.LeaderEpoch)
+
+			// Seek would validate the offset with a ListOffsets request,
+			// which only the partition leader serves.
+			conn.mutex.Lock()
+			conn.offset = offset
+			conn.mutex.Unlock()
+			return conn, nil
+		}
+
+		return nil, fmt.Errorf("broker %d is not a replica of partition %d of topic %q", r.replica, r.partition, r.topic)
+	}
+
+	return nil, err
+}
+
 func (r *reader) read(ctx context.Context, offset int64, conn *Conn) (int64, error) {
 	r.stats.fetches.observe(1)
 	r.stats.offset.observe(offset)
 
-	t0 := time.Now()
-	conn.SetReadDeadline(t0.Add(r.maxWait))
+	// The fetch request is held off while the broker throttles the
+	// connection, which does not count against the read timeout.
+	_, throttle := conn.throttled()
 
-	batch := conn.ReadBatch(r.minBytes, r.maxBytes)
+	t0 := time.Now()
+	conn.SetReadDeadline(t0.Add(throttle + r.readTimeout))
+
+	batch := conn.ReadBatchWith(ReadBatchConfig{
+		MinBytes:          r.minBytes,
+		MaxBytes:          r.maxBytes,
+		PartitionMaxBytes: r.partitionMax,
+		MaxWait:           r.maxWait,
+		IsolationLevel:    r.isolationLevel,
+		CheckCRCs:         r.checkCRCs,
+		RackID:            r.rackID,
+		FetchSession:      r.fetchSessions,
+	})
 	highWaterMark := batch.HighWaterMark()
 
 	t1 := time.Now()
 	r.stats.waitTime.observeDuration(t1.Sub(t0))
+	if throttle := batch.Throttle(); throttle > 0 {
+		r.stats.throttled.observeDuration(throttle)
+	}
+
+	if replica := batch.PreferredReadReplica(); replica >= 0 && replica != conn.Broker().ID {
+		batch.Close()
+		conn.SetReadDeadline(time.Time{})
+		r.replica = replica
+		return offset, errReadReplica
+	}
 
 	var msg Message
 	var err error
@@ -1911,6 +3662,11 @@ func (r *reader) read(ctx context.Context, offset int64, conn *Conn) (int64, err
 		}
 
 		if msg, err = batch.ReadMessage(); err != nil {
+			// The batch may end with control records, which the next fetch
+			// must start after.
+			if next := batch.Offset(); next > offset {
+				offset = next
+			}
 			err = batch.Close()
 			break
 		}
@@ -1919,7 +3675,15 @@ func (r *reader) read(ctx context.Context, offset int64, conn *Conn) (int64, err
 		r.stats.messages.observe(1)
 		r.stats.bytes.observe(n)
 
-		if err = r.sendMessage(ctx, msg, highWaterMark); err != nil {
+		if !r.trySendMessage(msg, highWaterMark) {
+			// The queue is full, the reader pauses until the program catches
+			// up, which must not count against the read deadline.
+			conn.SetReadDeadline(time.Time{})
+			err = r.sendMessage(ctx, msg, highWaterMark)
+			deadline = time.Now().Add(safetyTimeout)
+			conn.SetReadDeadline(deadline)
+		}
+		if err != nil {
 			err = batch.Close()
 			break
 		}
@@ -1955,6 +3719,17 @@ func (r *reader) sendMessage(ctx context.Context, msg Message, watermark int64)
 	}
 }
 
+// trySendMessage sends the message to the queue unless it is full, it returns
+// whether the message was sent.
+func (r *reader) trySendMessage(msg Message, watermark int64) bool {
+	select {
+	case r.msgs <- readerMessage{version: r.version, message: msg, watermark: watermark}:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *reader) sendError(ctx context.Context, err error) error {
 	select {
 	case r.msgs <- readerMessage{version: r.version, error: err}:
@@ -1964,18 +3739,97 @@ func (r *reader) sendError(ctx context.Context, err error) error {
 	}
 }
 
-func (r *reader) withLogger(do func(*log.Logger)) {
-	if r.logger != nil {
-		do(r.logger)
+// pausedPartitions is the set of partitions that a reader must not fetch from,
+// by topic and partition. The zero-value is ready to use and holds no paused
+// partitions.
+type pausedPartitions struct {
+	mutex  sync.Mutex
+	paused map[pausedPartition]chan struct{}
+}
+
+type pausedPartition struct {
+	topic     string
+	partition int
+}
+
+// pause marks the partitions of topic as paused.
+func (p *pausedPartitions) pause(topic string, partitions ...int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.paused == nil {
+		p.paused = make(map[pausedPartition]chan struct{})
+	}
+
+	for _, partition := range partitions {
+		key := pausedPartition{topic: topic, partition: partition}
+		if _, ok := p.paused[key]; !ok {
+			p.paused[key] = make(chan struct{})
+		}
 	}
 }
 
-func (r *reader) withErrorLogger(do func(*log.Logger)) {
-	if r.errorLogger != nil {
-		do(r.errorLogger)
-	} else {
-		r.withLogger(do)
+// resume removes the partitions of topic from the set of paused partitions,
+// waking up the subreaders waiting on them.
+func (p *pausedPartitions) resume(topic string, partitions ...int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, partition := range partitions {
+		key := pausedPartition{topic: topic, partition: partition}
+		if resumed, ok := p.paused[key]; ok {
+			close(resumed)
+			delete(p.paused, key)
+		}
+	}
+}
+
+// isPaused returns true if the partition of topic is paused.
+func (p *pausedPartitions) isPaused(topic string, partition int) bool {
+	return p.resumed(topic, partition) != nil
+}
+
+// resumed returns a channel which is closed when the partition of topic is
+// resumed, or nil if the partition is not paused.
+func (p *pausedPartitions) resumed(topic string, partition int) <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.paused[pausedPartition{topic: topic, partition: partition}]
+}
+
+// leaderEpochs holds the leader epochs of partitions by topic => partition.
+// The zero-value is ready to use.
+type leaderEpochs struct {
+	mutex  sync.Mutex
+	epochs map[string]map[int]int32
+}
+
+// set records the leader epoch of a partition.
+func (e *leaderEpochs) set(topic string, partition int, epoch int32) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.epochs == nil {
+		e.epochs = make(map[string]map[int]int32)
+	}
+
+	partitions, ok := e.epochs[topic]
+	if !ok {
+		partitions = make(map[int]int32)
+		e.epochs[topic] = partitions
+	}
+	partitions[partition] = epoch
+}
+
+// get returns the leader epoch of a partition, or -1 if it is unknown.
+func (e *leaderEpochs) get(topic string, partition int32) int32 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if epoch, ok := e.epochs[topic][int(partition)]; ok {
+		return epoch
 	}
+	return -1
 }
 
 // extractTopics returns the unique list of topics represented by the set of
@@ -2,13 +2,21 @@ package kafka
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"net"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	ktesting "github.com/segmentio/kafka-go/testing"
 )
 
 func TestReader(t *testing.T) {
@@ -62,6 +70,11 @@ func TestReader(t *testing.T) {
 			scenario: "reading from an out-of-range offset waits until the context is cancelled",
 			function: testReaderOutOfRangeGetsCanceled,
 		},
+
+		{
+			scenario: "pausing a partition stops fetching messages until it is resumed",
+			function: testReaderPauseResume,
+		},
 	}
 
 	for _, test := range tests {
@@ -304,6 +317,7 @@ func testReaderStats(t *testing.T, ctx context.Context, r *Reader) {
 		MinBytes:      1,
 		MaxBytes:      10000000,
 		MaxWait:       100 * time.Millisecond,
+		ReadBackoff:   0,
 		QueueLength:   0,
 		QueueCapacity: 100,
 		ClientID:      "",
@@ -346,6 +360,131 @@ func testReaderOutOfRangeGetsCanceled(t *testing.T, ctx context.Context, r *Read
 	}
 }
 
+func testReaderPauseResume(t *testing.T, ctx context.Context, r *Reader) {
+	r.Pause(0)
+	prepareReader(t, ctx, r, makeTestSequence(10)...)
+
+	timeout, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	if _, err := r.ReadMessage(timeout); err != context.DeadlineExceeded {
+		t.Error("expected no messages to be read from a paused partition, got:", err)
+	}
+
+	r.Resume(0)
+
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal("reading message after resuming the partition failed:", err)
+	}
+	if m.Offset != 0 {
+		t.Error("expected to read from offset 0 after resuming the partition, got:", m.Offset)
+	}
+}
+
+func TestPausedPartitions(t *testing.T) {
+	p := pausedPartitions{}
+
+	if p.isPaused("A", 0) {
+		t.Error("partitions must not be paused by default")
+	}
+
+	p.pause("A", 0, 1)
+	resumed := p.resumed("A", 0)
+
+	if !p.isPaused("A", 0) || !p.isPaused("A", 1) {
+		t.Error("expected partitions 0 and 1 of topic A to be paused")
+	}
+	if p.isPaused("B", 0) {
+		t.Error("expected partition 0 of topic B not to be paused")
+	}
+
+	p.resume("A", 0, 2)
+
+	select {
+	case <-resumed:
+	default:
+		t.Error("expected the resumed channel to be closed")
+	}
+
+	if p.isPaused("A", 0) {
+		t.Error("expected partition 0 to be resumed")
+	}
+	if !p.isPaused("A", 1) {
+		t.Error("expected partition 1 to still be paused")
+	}
+}
+
+func TestReaderPauseGroupTopics(t *testing.T) {
+	r := &Reader{
+		config: ReaderConfig{
+			GroupID:     "group",
+			GroupTopics: []string{"A", "B"},
+			Dialer:      DefaultDialer,
+		},
+		stats: &readerStats{},
+	}
+
+	r.Pause(0)
+	r.PauseTopic("B", 1)
+
+	for _, test := range []struct {
+		topic     string
+		partition int
+		paused    bool
+	}{
+		{topic: "A", partition: 0, paused: true},
+		{topic: "B", partition: 0, paused: true},
+		{topic: "A", partition: 1, paused: false},
+		{topic: "B", partition: 1, paused: true},
+	} {
+		if paused := r.paused.isPaused(test.topic, test.partition); paused != test.paused {
+			t.Errorf("%s/%d: expected paused to be %t, got %t", test.topic, test.partition, test.paused, paused)
+		}
+	}
+
+	r.ResumeTopic("A", 0)
+
+	if r.paused.isPaused("A", 0) {
+		t.Error("expected partition 0 of topic A to be resumed")
+	}
+	if !r.paused.isPaused("B", 0) {
+		t.Error("expected partition 0 of topic B to still be paused")
+	}
+
+	if topic := r.Stats().Topic; topic != "A,B" {
+		t.Errorf("expected the stats to report the group topics; got %q", topic)
+	}
+}
+
+func TestReaderBackoff(t *testing.T) {
+	r := &reader{
+		backoffMin: 100 * time.Millisecond,
+		backoffMax: 1 * time.Second,
+		stats:      &readerStats{},
+	}
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 0},
+		{attempt: 1, expected: 100 * time.Millisecond},
+		{attempt: 3, expected: 400 * time.Millisecond},
+		{attempt: 10, expected: 1 * time.Second},
+		{attempt: 0, expected: 0},
+	}
+
+	for _, test := range tests {
+		if d := r.backoff(test.attempt); d != test.expected {
+			t.Errorf("attempt %d: expected backoff of %s; got %s", test.attempt, test.expected, d)
+		}
+		if d := time.Duration(r.stats.backoff.snapshot()); d != test.expected {
+			t.Errorf("attempt %d: expected backoff stat of %s; got %s", test.attempt, test.expected, d)
+		}
+	}
+}
+
 func createTopic(t *testing.T, topic string, partitions int) {
 	conn, err := Dial("tcp", "localhost:9092")
 	if err != nil {
@@ -354,22 +493,15 @@ func createTopic(t *testing.T, topic string, partitions int) {
 	}
 	defer conn.Close()
 
-	_, err = conn.createTopics(createTopicsRequestV0{
-		Topics: []createTopicsRequestV0Topic{
-			{
-				Topic:             topic,
-				NumPartitions:     int32(partitions),
-				ReplicationFactor: 1,
-			},
-		},
-		Timeout: int32(30 * time.Second / time.Millisecond),
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = conn.CreateTopicsAndWait(ctx, TopicConfig{
+		Topic:             topic,
+		NumPartitions:     partitions,
+		ReplicationFactor: 1,
 	})
-	switch err {
-	case nil:
-		// ok
-	case TopicAlreadyExists:
-		// ok
-	default:
+	if err != nil {
 		t.Error("bad createTopics", err)
 		t.FailNow()
 	}
@@ -615,6 +747,70 @@ func TestCloseLeavesGroup(t *testing.T) {
 	}
 }
 
+func TestReaderRebalanceCallbacks(t *testing.T) {
+	topic := makeTopic()
+	createTopic(t, topic, 2)
+
+	assigned := make(chan map[string][]int, 1)
+	revoked := make(chan map[string][]int, 1)
+
+	r := NewReader(ReaderConfig{
+		Brokers:  []string{"localhost:9092"},
+		Topic:    topic,
+		GroupID:  makeGroupID(),
+		MinBytes: 1,
+		MaxBytes: 10e6,
+		MaxWait:  100 * time.Millisecond,
+		OnPartitionsAssigned: func(ctx context.Context, partitions map[string][]int) {
+			assigned <- partitions
+		},
+		OnPartitionsRevoked: func(ctx context.Context, partitions map[string][]int) {
+			if err := ctx.Err(); err != nil {
+				t.Errorf("expected a live context when partitions are revoked; got %v", err)
+			}
+			revoked <- partitions
+		},
+	})
+
+	expected := map[string][]int{topic: {0, 1}}
+
+	select {
+	case partitions := <-assigned:
+		if !reflect.DeepEqual(expected, partitions) {
+			t.Errorf("expected assigned partitions %v; got %v", expected, partitions)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("timeout waiting for partitions to be assigned")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error closing reader: %s", err)
+	}
+
+	select {
+	case partitions := <-revoked:
+		if !reflect.DeepEqual(expected, partitions) {
+			t.Errorf("expected revoked partitions %v; got %v", expected, partitions)
+		}
+	default:
+		t.Error("expected partitions to be revoked when closing the reader")
+	}
+}
+
+func TestMakeTopicPartitions(t *testing.T) {
+	partitions := makeTopicPartitions(map[string][]int32{
+		"a": {2, 0, 1},
+		"b": {},
+	})
+	expected := map[string][]int{
+		"a": {0, 1, 2},
+		"b": {},
+	}
+	if !reflect.DeepEqual(expected, partitions) {
+		t.Errorf("expected %v; got %v", expected, partitions)
+	}
+}
+
 func TestConsumerGroup(t *testing.T) {
 	t.Parallel()
 
@@ -724,6 +920,200 @@ func TestReaderPartitionWhenConsumerGroupsEnabled(t *testing.T) {
 
 }
 
+func TestReaderHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		scenario          string
+		sessionTimeout    time.Duration
+		heartbeatInterval time.Duration
+		expected          time.Duration
+	}{
+		{
+			scenario:          "heartbeat less than a third of the session timeout",
+			sessionTimeout:    5 * time.Minute,
+			heartbeatInterval: 10 * time.Second,
+			expected:          10 * time.Second,
+		},
+		{
+			scenario:          "heartbeat equal to a third of the session timeout",
+			sessionTimeout:    30 * time.Second,
+			heartbeatInterval: 10 * time.Second,
+			expected:          10 * time.Second,
+		},
+		{
+			scenario:          "heartbeat greater than the session timeout",
+			heartbeatInterval: time.Minute,
+			expected:          10 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			r := NewReader(ReaderConfig{
+				Brokers:           []string{"localhost:9092"},
+				Topic:             "topic",
+				GroupID:           "group",
+				SessionTimeout:    test.sessionTimeout,
+				HeartbeatInterval: test.heartbeatInterval,
+			})
+			defer r.Close()
+
+			if interval := r.Config().HeartbeatInterval; interval != test.expected {
+				t.Errorf("expected the heartbeat interval to be %s; got %s", test.expected, interval)
+			}
+		})
+	}
+}
+
+func TestNewReaderPanics(t *testing.T) {
+	tests := []struct {
+		scenario string
+		config   ReaderConfig
+	}{
+		{
+			scenario: "negative heartbeat interval",
+			config:   ReaderConfig{GroupID: "group", HeartbeatInterval: -time.Second},
+		},
+		{
+			scenario: "start offset other than the sentinels",
+			config:   ReaderConfig{GroupID: "group", StartOffset: 42},
+		},
+		{
+			scenario: "negative idle timeout",
+			config:   ReaderConfig{IdleTimeout: -time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected NewReader to panic")
+				}
+			}()
+
+			config := test.config
+			config.Brokers = []string{"localhost:9092"}
+			config.Topic = "topic"
+			NewReader(config).Close()
+		})
+	}
+}
+
+func TestReaderDefaultHeartbeatInterval(t *testing.T) {
+	r := NewReader(ReaderConfig{
+		Brokers:        []string{"localhost:9092"},
+		Topic:          "topic",
+		GroupID:        "group",
+		SessionTimeout: 6 * time.Second,
+	})
+	defer r.Close()
+
+	if interval := r.Config().HeartbeatInterval; interval != 600*time.Millisecond {
+		t.Errorf("expected the heartbeat interval to be 600ms; got %s", interval)
+	}
+}
+
+func TestReaderProcessingStalled(t *testing.T) {
+	now := time.Now()
+	r := &Reader{config: ReaderConfig{MaxProcessingTime: time.Second}}
+
+	if pollTime := r.processingStalled(now); pollTime != 0 {
+		t.Errorf("a reader which never returned a message must not be stalled")
+	}
+
+	r.pollTime = now.Add(-500 * time.Millisecond).UnixNano()
+	if pollTime := r.processingStalled(now); pollTime != 0 {
+		t.Errorf("a reader within the processing time must not be stalled")
+	}
+
+	r.pollTime = now.Add(-2 * time.Second).UnixNano()
+	if pollTime := r.processingStalled(now); pollTime != r.pollTime {
+		t.Errorf("expected the reader to be stalled since %d; got %d", r.pollTime, pollTime)
+	}
+
+	r.beginPoll()
+	if pollTime := r.processingStalled(now); pollTime != 0 {
+		t.Errorf("a reader waiting in FetchMessage must not be stalled")
+	}
+}
+
+func TestReaderWaitProcessing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &Reader{
+		cancel: func() {},
+		pollch: make(chan struct{}, 1),
+		stctx:  ctx,
+	}
+	r.pollTime = 42
+	r.stalledAt = 42
+
+	done := make(chan bool)
+	go func() { done <- r.waitProcessing() }()
+
+	select {
+	case <-done:
+		t.Fatal("waitProcessing returned before the program called FetchMessage")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.beginPoll()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("expected waitProcessing to return true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitProcessing did not return after the program called FetchMessage")
+	}
+
+	if r.stalledAt != 0 {
+		t.Error("expected the reader not to be stalled anymore")
+	}
+}
+
+func TestReaderMaxProcessingTime(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	config := ReaderConfig{
+		Brokers:           []string{"localhost:9092"},
+		Topic:             topic,
+		GroupID:           makeGroupID(),
+		HeartbeatInterval: 200 * time.Millisecond,
+		MaxProcessingTime: time.Second,
+		MinBytes:          1,
+		MaxBytes:          1e6,
+		MaxWait:           100 * time.Millisecond,
+	}
+
+	r1 := NewReader(config)
+	defer r1.Close()
+	prepareReader(t, ctx, r1, makeTestSequence(2)...)
+
+	if _, err := r1.ReadMessage(ctx); err != nil {
+		t.Fatalf("bad err: %v", err)
+	}
+
+	// stall the processing loop of the first reader, it must leave the group
+	// and let the second reader consume the remaining message.
+	r2 := NewReader(config)
+	defer r2.Close()
+
+	m, err := r2.FetchMessage(ctx)
+	if err != nil {
+		t.Fatalf("bad err: %v", err)
+	}
+	if m.Offset != 1 {
+		t.Errorf("expected to read the message at offset 1; got %d", m.Offset)
+	}
+}
+
 func TestExtractTopics(t *testing.T) {
 	testCases := map[string]struct {
 		Members []GroupMember
@@ -910,6 +1300,65 @@ func TestReaderAssignTopicPartitions(t *testing.T) {
 	}
 }
 
+func TestReaderGroupTopicRegex(t *testing.T) {
+	conn := &MockConnWatcher{
+		partitions: [][]Partition{
+			{
+				{Topic: "events-a", ID: 0},
+				{Topic: "events-a", ID: 1},
+				{Topic: "logs", ID: 0},
+				{Topic: "events-b", ID: 0},
+			},
+			{
+				{Topic: "events-a", ID: 0},
+				{Topic: "events-a", ID: 1},
+				{Topic: "logs", ID: 0},
+				{Topic: "events-b", ID: 0},
+			},
+			{
+				{Topic: "events-a", ID: 0},
+				{Topic: "events-a", ID: 1},
+				{Topic: "logs", ID: 0},
+				{Topic: "events-b", ID: 0},
+				{Topic: "events-c", ID: 0},
+			},
+		},
+	}
+
+	r := &Reader{}
+	r.config.GroupID = "group"
+	r.config.GroupTopicRegex = regexp.MustCompile(`^events-`)
+	r.config.MetadataRefreshInterval = 10 * time.Millisecond
+
+	topics, err := r.matchGroupTopics(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"events-a", "events-b"}; !reflect.DeepEqual(topics, expected) {
+		t.Fatalf("expected matching topics %v; got %v", expected, topics)
+	}
+	r.groupTopics = topics
+
+	if !reflect.DeepEqual(r.topics(), topics) {
+		t.Errorf("expected the reader to subscribe to %v; got %v", topics, r.topics())
+	}
+
+	// The watcher returns, which triggers a rebalance, once the matching
+	// topics changed on the second refresh.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rg := (&runGroup{}).WithContext(ctx)
+	rg.Go(r.topicWatcher(conn))
+	rg.Wait()
+
+	if ctx.Err() != nil {
+		t.Fatal("topicWatcher didn't see the new topic")
+	}
+	if conn.count != 3 {
+		t.Errorf("expected the topics to be listed 3 times; got %d", conn.count)
+	}
+}
+
 func TestReaderConsumerGroup(t *testing.T) {
 	t.Parallel()
 
@@ -938,6 +1387,13 @@ func TestReaderConsumerGroup(t *testing.T) {
 			function:       testReaderConsumerGroupVerifyPeriodicOffsetCommitter,
 		},
 
+		{
+			scenario:       "commit explicit offsets",
+			partitions:     2,
+			commitInterval: 400 * time.Millisecond,
+			function:       testReaderConsumerGroupCommitOffsets,
+		},
+
 		{
 			scenario:   "rebalance across many partitions and consumers",
 			partitions: 8,
@@ -973,6 +1429,12 @@ func TestReaderConsumerGroup(t *testing.T) {
 			partitions: 2,
 			function:   testReaderConsumerGroupRebalanceOnPartitionAdd,
 		},
+
+		{
+			scenario:   "consumer group reports rebalances in stats",
+			partitions: 1,
+			function:   testReaderConsumerGroupRebalanceStats,
+		},
 	}
 
 	for _, test := range tests {
@@ -1059,33 +1521,30 @@ func testReaderConsumerGroupVerifyOffsetCommitted(t *testing.T, ctx context.Cont
 		t.Errorf("bad fetchOffsets: %v", err)
 	}
 
-	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets) {
+	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets[r.config.Topic]) {
 		t.Errorf("expected %v; got %v", expected, offsets)
 	}
 }
 
-func testReaderConsumerGroupVerifyPeriodicOffsetCommitter(t *testing.T, ctx context.Context, r *Reader) {
-	prepareReader(t, context.Background(), r, makeTestSequence(3)...)
-
-	if _, err := r.FetchMessage(ctx); err != nil {
-		t.Errorf("bad err: %v", err) // skip the first message
-	}
+func testReaderConsumerGroupCommitOffsets(t *testing.T, ctx context.Context, r *Reader) {
+	prepareReader(t, context.Background(), r, makeTestSequence(4)...)
 
 	m, err := r.FetchMessage(ctx)
 	if err != nil {
 		t.Errorf("bad err: %v", err)
 	}
 
-	started := time.Now()
 	if err := r.CommitMessages(ctx, m); err != nil {
 		t.Errorf("bad commit message: %v", err)
 	}
-	if elapsed := time.Now().Sub(started); elapsed > 10*time.Millisecond {
-		t.Errorf("background commits should happen nearly instantly")
-	}
 
-	// wait for committer to pick up the commits
-	time.Sleep(r.config.CommitInterval * 3)
+	// The explicit offsets must win over the pending commit of the message,
+	// even though they are lower.
+	if err := r.CommitOffsets(ctx, map[string]map[int]int64{
+		r.config.Topic: {0: 0, 1: 0},
+	}); err != nil {
+		t.Errorf("bad commit offsets: %v", err)
+	}
 
 	conn, err := r.coordinator()
 	if err != nil {
@@ -1094,18 +1553,18 @@ func testReaderConsumerGroupVerifyPeriodicOffsetCommitter(t *testing.T, ctx cont
 	defer conn.Close()
 
 	offsets, err := r.fetchOffsets(conn, map[string][]int32{
-		r.config.Topic: {0},
+		r.config.Topic: {0, 1},
 	})
 	if err != nil {
 		t.Errorf("bad fetchOffsets: %v", err)
 	}
 
-	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets) {
+	if expected := map[int]int64{0: 0, 1: 0}; !reflect.DeepEqual(expected, offsets[r.config.Topic]) {
 		t.Errorf("expected %v; got %v", expected, offsets)
 	}
 }
 
-func testReaderConsumerGroupVerifyCommitsOnClose(t *testing.T, ctx context.Context, r *Reader) {
+func testReaderConsumerGroupVerifyPeriodicOffsetCommitter(t *testing.T, ctx context.Context, r *Reader) {
 	prepareReader(t, context.Background(), r, makeTestSequence(3)...)
 
 	if _, err := r.FetchMessage(ctx); err != nil {
@@ -1117,18 +1576,59 @@ func testReaderConsumerGroupVerifyCommitsOnClose(t *testing.T, ctx context.Conte
 		t.Errorf("bad err: %v", err)
 	}
 
+	started := time.Now()
 	if err := r.CommitMessages(ctx, m); err != nil {
 		t.Errorf("bad commit message: %v", err)
 	}
-
-	if err := r.Close(); err != nil {
-		t.Errorf("bad Close: %v", err)
+	if elapsed := time.Now().Sub(started); elapsed > 10*time.Millisecond {
+		t.Errorf("background commits should happen nearly instantly")
 	}
 
-	r2 := NewReader(r.config)
-	defer r2.Close()
+	// wait for committer to pick up the commits
+	time.Sleep(r.config.CommitInterval * 3)
 
-	conn, err := r2.coordinator()
+	conn, err := r.coordinator()
+	if err != nil {
+		t.Errorf("unable to connect to coordinator: %v", err)
+	}
+	defer conn.Close()
+
+	offsets, err := r.fetchOffsets(conn, map[string][]int32{
+		r.config.Topic: {0},
+	})
+	if err != nil {
+		t.Errorf("bad fetchOffsets: %v", err)
+	}
+
+	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets[r.config.Topic]) {
+		t.Errorf("expected %v; got %v", expected, offsets)
+	}
+}
+
+func testReaderConsumerGroupVerifyCommitsOnClose(t *testing.T, ctx context.Context, r *Reader) {
+	prepareReader(t, context.Background(), r, makeTestSequence(3)...)
+
+	if _, err := r.FetchMessage(ctx); err != nil {
+		t.Errorf("bad err: %v", err) // skip the first message
+	}
+
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		t.Errorf("bad err: %v", err)
+	}
+
+	if err := r.CommitMessages(ctx, m); err != nil {
+		t.Errorf("bad commit message: %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("bad Close: %v", err)
+	}
+
+	r2 := NewReader(r.config)
+	defer r2.Close()
+
+	conn, err := r2.coordinator()
 	if err != nil {
 		t.Errorf("unable to connect to coordinator: %v", err)
 	}
@@ -1141,7 +1641,7 @@ func testReaderConsumerGroupVerifyCommitsOnClose(t *testing.T, ctx context.Conte
 		t.Errorf("bad fetchOffsets: %v", err)
 	}
 
-	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets) {
+	if expected := map[int]int64{0: m.Offset + 1}; !reflect.DeepEqual(expected, offsets[r.config.Topic]) {
 		t.Errorf("expected %v; got %v", expected, offsets)
 	}
 }
@@ -1177,6 +1677,32 @@ func testReaderConsumerGroupReadContentAcrossPartitions(t *testing.T, ctx contex
 	}
 }
 
+func testReaderConsumerGroupRebalanceStats(t *testing.T, ctx context.Context, r *Reader) {
+	t0 := time.Now()
+	prepareReader(t, ctx, r, makeTestSequence(1)...)
+
+	if _, err := r.ReadMessage(ctx); err != nil {
+		t.Fatalf("bad err: %v", err)
+	}
+
+	stats := r.Stats()
+	if stats.Rebalances != 1 {
+		t.Errorf("expected 1 rebalance; got %d", stats.Rebalances)
+	}
+	if stats.LastRebalance.Before(t0) || stats.LastRebalance.After(time.Now()) {
+		t.Errorf("last rebalance time out of bounds: %v", stats.LastRebalance)
+	}
+
+	// The counter is reset by the snapshot while the time is retained.
+	next := r.Stats()
+	if next.Rebalances != 0 {
+		t.Errorf("expected no rebalances since the last snapshot; got %d", next.Rebalances)
+	}
+	if !next.LastRebalance.Equal(stats.LastRebalance) {
+		t.Errorf("expected last rebalance time %v; got %v", stats.LastRebalance, next.LastRebalance)
+	}
+}
+
 // Build a struct to implement the ReadPartitions interface.
 type MockConnWatcher struct {
 	count      int
@@ -1437,18 +1963,23 @@ func TestOffsetStash(t *testing.T) {
 type mockOffsetCommitter struct {
 	invocations int
 	failCount   int
+	response    offsetCommitResponseV2
 	err         error
+
+	// request is the last request received by the committer.
+	request offsetCommitRequestV2
 }
 
 func (m *mockOffsetCommitter) offsetCommit(request offsetCommitRequestV2) (offsetCommitResponseV2, error) {
 	m.invocations++
+	m.request = request
 
 	if m.failCount > 0 {
 		m.failCount--
 		return offsetCommitResponseV2{}, io.EOF
 	}
 
-	return offsetCommitResponseV2{}, nil
+	return m.response, m.err
 }
 
 func TestCommitOffsetsWithRetry(t *testing.T) {
@@ -1478,7 +2009,7 @@ func TestCommitOffsetsWithRetry(t *testing.T) {
 			conn := &mockOffsetCommitter{failCount: test.Fails}
 
 			r := &Reader{stctx: context.Background()}
-			err := r.commitOffsetsWithRetry(conn, offsets, defaultCommitRetries)
+			err := r.commitOffsetsWithRetry(context.Background(), conn, offsets, defaultCommitRetries)
 			switch {
 			case test.HasError && err == nil:
 				t.Error("bad err: expected not nil; got nil")
@@ -1492,109 +2023,1669 @@ func TestCommitOffsetsWithRetry(t *testing.T) {
 	}
 }
 
-// Test that a reader won't continually rebalance when there are more consumers
-// than partitions in a group.
-// https://github.com/segmentio/kafka-go/issues/200
-func TestRebalanceTooManyConsumers(t *testing.T) {
-	t.Parallel()
+func TestCommitExplicitOffsets(t *testing.T) {
+	offsets := offsetStash{"topic": {0: 10, 1: 20}}
 
-	ctx := context.Background()
-	conf := ReaderConfig{
-		Brokers: []string{"localhost:9092"},
-		GroupID: makeGroupID(),
-		Topic:   makeTopic(),
-		MaxWait: time.Second,
+	t.Run("success", func(t *testing.T) {
+		conn := &mockOffsetCommitter{}
+
+		r := &Reader{stctx: context.Background()}
+		if err := r.commitExplicitOffsets(conn, offsets, 0); err != nil {
+			t.Errorf("bad err: expected nil; got %v", err)
+		}
+		if conn.invocations != 1 {
+			t.Errorf("expected 1 invocation; got %v", conn.invocations)
+		}
+	})
+
+	t.Run("partition errors", func(t *testing.T) {
+		conn := &mockOffsetCommitter{
+			response: offsetCommitResponseV2{
+				Responses: []offsetCommitResponseV2Response{
+					{
+						Topic: "topic",
+						PartitionResponses: []offsetCommitResponseV2PartitionResponse{
+							{Partition: 0},
+							{Partition: 1, ErrorCode: int16(UnknownTopicOrPartition)},
+						},
+					},
+				},
+			},
+			err: UnknownTopicOrPartition,
+		}
+
+		r := &Reader{stctx: context.Background()}
+		err := r.commitExplicitOffsets(conn, offsets, 0)
+
+		commitErr, ok := err.(*OffsetCommitError)
+		if !ok {
+			t.Fatalf("expected *OffsetCommitError; got %T: %v", err, err)
+		}
+
+		expected := map[string]map[int]Error{"topic": {1: UnknownTopicOrPartition}}
+		if !reflect.DeepEqual(expected, commitErr.Errors) {
+			t.Errorf("expected %v; got %v", expected, commitErr.Errors)
+		}
+		if conn.invocations != 1 {
+			t.Errorf("expected 1 invocation; got %v", conn.invocations)
+		}
+	})
+}
+
+func TestReaderFlushCommitsOnClose(t *testing.T) {
+	// The reader is closed, the first attempt of the final commit fails.
+	stctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn := &mockOffsetCommitter{failCount: 1}
+
+	r := &Reader{
+		config: ReaderConfig{
+			GroupID:        "group",
+			CommitInterval: time.Hour,
+			CloseTimeout:   5 * time.Second,
+		},
+		stctx:       stctx,
+		commits:     make(chan commitRequest, 2),
+		offsetStash: offsetStash{"topic": {0: 40}},
 	}
 
-	// Create the first reader and wait for it to become the leader.
-	r1 := NewReader(conf)
-	prepareReader(t, ctx, r1, makeTestSequence(1)...)
-	r1.ReadMessage(ctx)
-	// Clear the stats from the first rebalance.
-	r1.Stats()
+	// Commits queued by CommitMessages which the commit loop did not handle
+	// before it was stopped.
+	errch := make(chan error, 1)
+	r.commits <- commitRequest{commits: []commit{{topic: "topic", partition: 0, offset: 41}}}
+	r.commits <- commitRequest{commits: []commit{{topic: "topic", partition: 1, offset: 42}}, errch: errch}
 
-	// Second reader should cause one rebalance for each r1 and r2.
-	r2 := NewReader(conf)
+	r.flushCommits(conn, nil)
 
-	// Wait for rebalances.
-	time.Sleep(5 * time.Second)
+	if err := <-errch; err != nil {
+		t.Errorf("expected the queued commit to succeed; got %v", err)
+	}
+	if conn.invocations != 2 {
+		t.Errorf("expected the final commit to be retried once; got %d invocations", conn.invocations)
+	}
+	if len(r.offsetStash) != 0 {
+		t.Errorf("expected the committed offsets to be cleared; got %v", r.offsetStash)
+	}
 
-	// Before the fix, r2 would cause continuous rebalances,
-	// as it tried to handshake() repeatedly.
-	rebalances := r1.Stats().Rebalances + r2.Stats().Rebalances
-	if rebalances > 2 {
-		t.Errorf("unexpected rebalances to first reader, got %d", rebalances)
+	committed := offsetStash{}
+	for _, topic := range conn.request.Topics {
+		for _, p := range topic.Partitions {
+			committed.merge([]commit{{topic: topic.Topic, partition: int(p.Partition), offset: p.Offset}})
+		}
+	}
+	if expected := (offsetStash{"topic": {0: 41, 1: 42}}); !reflect.DeepEqual(expected, committed) {
+		t.Errorf("expected %v to be committed; got %v", expected, committed)
+	}
+}
+
+func TestReaderFlushCommitsOnRebalance(t *testing.T) {
+	// The reader is not closed, the group is rebalancing.
+	conn := &mockOffsetCommitter{failCount: 1}
+
+	r := &Reader{
+		config: ReaderConfig{
+			GroupID:        "group",
+			CommitInterval: time.Hour,
+			CloseTimeout:   5 * time.Second,
+		},
+		stctx:       context.Background(),
+		commits:     make(chan commitRequest),
+		offsetStash: offsetStash{"topic": {0: 40}},
+	}
 
+	errch := make(chan error, 1)
+	r.flushCommits(conn, []chan<- error{errch})
+
+	if err := <-errch; err == nil {
+		t.Error("expected the failed commit to be reported")
+	}
+	if conn.invocations != 1 {
+		t.Errorf("expected a single commit attempt; got %d invocations", conn.invocations)
+	}
+	if expected := (offsetStash{"topic": {0: 40}}); !reflect.DeepEqual(expected, r.offsetStash) {
+		t.Errorf("expected the offsets to remain pending; got %v", r.offsetStash)
 	}
 }
-func TestAutoOffsetReset(t *testing.T) {
 
-	t.Parallel()
+func TestReaderFlushCommitsMockBroker(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	topic := makeTopic()
-	w := NewWriter(WriterConfig{
-		Brokers:   []string{"localhost:9092"},
-		Topic:     topic,
-		BatchSize: 1,
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.WriteMessages(
+		Message{Value: []byte("A")},
+		Message{Value: []byte("B")},
+		Message{Value: []byte("C")},
+	)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReader := func() *Reader {
+		return NewReader(ReaderConfig{
+			Brokers:           []string{broker.Addr()},
+			GroupID:           "group",
+			Topic:             "test",
+			MaxWait:           10 * time.Millisecond,
+			HeartbeatInterval: 50 * time.Millisecond,
+			CommitInterval:    time.Hour,
+		})
+	}
+
+	// The offsets are queued by CommitMessages, the periodic commit never
+	// runs before the reader is closed.
+	r1 := newReader()
+	for _, value := range []string{"A", "B"} {
+		m, err := r1.FetchMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Value) != value {
+			t.Fatalf("expected %q; got %q", value, m.Value)
+		}
+		if err := r1.CommitMessages(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r2 := newReader()
+	defer r2.Close()
+
+	m, err := r2.FetchMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Value) != "C" {
+		t.Errorf("expected the reader to resume after the committed offset; got %q at offset %d", m.Value, m.Offset)
+	}
+}
+
+func TestReaderCommitModes(t *testing.T) {
+	t.Run("CommitSync waits for the periodic commit", func(t *testing.T) {
+		conn := &mockOffsetCommitter{}
+		r := &Reader{
+			config: ReaderConfig{
+				GroupID:        "group",
+				CommitInterval: 10 * time.Millisecond,
+				CommitMode:     CommitSync,
+				CloseTimeout:   time.Second,
+			},
+			stctx:       context.Background(),
+			stats:       &readerStats{},
+			commits:     make(chan commitRequest),
+			offsetStash: offsetStash{},
+		}
+		if !r.useSyncCommits() {
+			t.Fatal("expected commits to be synchronous")
+		}
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			r.commitLoopInterval(conn, stop)
+			close(done)
+		}()
+
+		errch := make(chan error, 1)
+		r.commits <- commitRequest{commits: []commit{{topic: "topic", partition: 0, offset: 1}}, errch: errch}
+		if err := <-errch; err != nil {
+			t.Error(err)
+		}
+		close(stop)
+		<-done
+
+		if conn.invocations != 1 {
+			t.Errorf("expected 1 commit; got %d", conn.invocations)
+		}
 	})
 
-	// Wait for topic leader election
-	time.Sleep(10 * time.Second)
-	// Write test data
-	msgs := make([]Message, 500)
-	err := w.WriteMessages(ctx, msgs...)
+	t.Run("CommitAsync reports errors", func(t *testing.T) {
+		conn := &mockOffsetCommitter{err: io.ErrUnexpectedEOF}
+
+		var errs []error
+		r := &Reader{
+			config: ReaderConfig{
+				GroupID:       "group",
+				CommitMode:    CommitAsync,
+				CloseTimeout:  10 * time.Millisecond,
+				OnCommitError: func(err error) { errs = append(errs, err) },
+			},
+			stctx:       context.Background(),
+			stats:       &readerStats{},
+			commits:     make(chan commitRequest, 1),
+			offsetStash: offsetStash{},
+		}
+		if r.useSyncCommits() {
+			t.Fatal("expected commits to be asynchronous")
+		}
+
+		r.commits <- commitRequest{commits: []commit{{topic: "topic", partition: 0, offset: 1}}}
+		r.flushCommits(conn, nil)
+
+		if len(errs) != 1 {
+			t.Errorf("expected OnCommitError to be called once; got %d calls", len(errs))
+		}
+		if n := r.stats.commitErrors.snapshot(); n != 1 {
+			t.Errorf("expected 1 commit error; got %d", n)
+		}
+	})
+}
+
+func TestReaderQueueCapacityBackpressure(t *testing.T) {
+	broker, err := NewMockBroker()
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.Close()
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	var fetches int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch {
+			atomic.AddInt32(&fetches, 1)
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	msgs := make([]Message, 20)
+	for i := range msgs {
+		msgs[i] = Message{Value: []byte(strconv.Itoa(i))}
+	}
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
 
-	// Expect default reader to read from first offset.
 	r := NewReader(ReaderConfig{
-		GroupID:  makeGroupID(),
-		Brokers:  []string{"localhost:9092"},
-		Topic:    topic,
-		MinBytes: 1,
-		MaxBytes: 100,
-		MaxWait:  100 * time.Millisecond,
+		Brokers:       []string{broker.Addr()},
+		Topic:         "test",
+		MaxWait:       10 * time.Millisecond,
+		QueueCapacity: 2,
 	})
 	defer r.Close()
 
-	msg, err := r.ReadMessage(ctx)
-	if err != nil {
+	// The reader starts fetching messages once the first one is read.
+	if _, err := r.ReadMessage(ctx); err != nil {
 		t.Fatal(err)
 	}
+	for r.Stats().QueueLength != 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
 
-	if msg.Offset != 0 {
-		t.Error("expected to get first message")
+	// The reader is blocked on the full queue, so it doesn't fetch more.
+	n := atomic.LoadInt32(&fetches)
+	time.Sleep(100 * time.Millisecond)
+	if m := atomic.LoadInt32(&fetches); m != n {
+		t.Errorf("expected the reader to stop fetching; got %d fetches after %d", m, n)
+	}
+	if s := r.Stats(); s.QueueLength != 2 || s.QueueCapacity != 2 {
+		t.Errorf("expected the queue to hold 2/2 messages; got %d/%d", s.QueueLength, s.QueueCapacity)
 	}
 
-	// Expected AutoOffsetReset = LastOffset to read from last offset.
-	r2 := NewReader(ReaderConfig{
-		GroupID:         makeGroupID(),
-		Brokers:         []string{"localhost:9092"},
-		Topic:           topic,
-		MinBytes:        1,
-		MaxBytes:        100,
-		MaxWait:         100 * time.Millisecond,
-		AutoOffsetReset: LastOffset,
+	for i := 1; i < len(msgs); i++ {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != int64(i) || string(m.Value) != strconv.Itoa(i) {
+			t.Fatalf("expected message %d; got %q at offset %d", i, m.Value, m.Offset)
+		}
+	}
+}
+
+func TestOffsetStashRemove(t *testing.T) {
+	stash := offsetStash{
+		"a": {0: 1, 1: 2},
+		"b": {0: 3},
+	}
+	stash.remove(offsetStash{
+		"a": {1: 0},
+		"b": {0: 0},
+		"c": {0: 0},
 	})
-	defer r2.Close()
 
-	// Write a single test message, the "last" message.
-	go func() {
-		time.Sleep(1 * time.Second)
-		prepareReader(t, ctx, r, Message{Value: []byte("last")})
-	}()
+	if expected := (offsetStash{"a": {0: 1}}); !reflect.DeepEqual(expected, stash) {
+		t.Errorf("expected %v; got %v", expected, stash)
+	}
+}
 
-	msg, err = r2.ReadMessage(ctx)
-	if err != nil {
-		t.Fatal(err)
+func TestReaderCommitOffsetsWithoutConsumerGroup(t *testing.T) {
+	r := &Reader{}
+	if err := r.CommitOffsets(context.Background(), map[string]map[int]int64{"topic": {0: 0}}); err != errOnlyAvailableWithGroup {
+		t.Fatalf("expected %v; got %v", errOnlyAvailableWithGroup, err)
 	}
+}
 
-	if string(msg.Value) != "last" {
-		t.Errorf("expected last message. got offset %d", msg.Offset)
+// mockLeaderEpochCommitter is an offset committer which supports committing the
+// leader epochs of partitions.
+type mockLeaderEpochCommitter struct {
+	mockOffsetCommitter
+
+	// requestV6 is the last v6 request received by the committer.
+	requestV6 offsetCommitRequestV6
+}
+
+func (m *mockLeaderEpochCommitter) offsetCommitV6(request offsetCommitRequestV6) (offsetCommitResponseV6, error) {
+	m.invocations++
+	m.requestV6 = request
+	return offsetCommitResponseV6{}, nil
+}
+
+func TestReaderCommitExplicitOffsetsRetention(t *testing.T) {
+	offsets := offsetStash{"topic": {0: 42}}
+
+	r := &Reader{config: ReaderConfig{GroupID: "group"}}
+	r.leaderEpochs.set("topic", 0, 3)
+
+	t.Run("default", func(t *testing.T) {
+		conn := &mockLeaderEpochCommitter{}
+		if err := r.commitExplicitOffsets(conn, offsets, 0); err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.request.Topics) != 0 {
+			t.Error("expected the offsets to be committed with v6")
+		}
+		if epoch := conn.requestV6.Topics[0].Partitions[0].LeaderEpoch; epoch != 3 {
+			t.Errorf("expected leader epoch 3; got %d", epoch)
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		conn := &mockLeaderEpochCommitter{}
+		if err := r.commitExplicitOffsets(conn, offsets, 30*24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.requestV6.Topics) != 0 {
+			t.Error("expected the offsets to be committed with v2")
+		}
+		if ms := conn.request.RetentionTime; ms != int64(30*24*time.Hour/time.Millisecond) {
+			t.Errorf("unexpected retention time: %dms", ms)
+		}
+		if offset := conn.request.Topics[0].Partitions[0].Offset; offset != 42 {
+			t.Errorf("expected offset 42; got %d", offset)
+		}
+	})
+
+	t.Run("configured retention", func(t *testing.T) {
+		r := &Reader{config: ReaderConfig{GroupID: "group", RetentionTime: time.Hour}}
+		r.leaderEpochs.set("topic", 0, 3)
+
+		conn := &mockLeaderEpochCommitter{}
+		if err := r.commitExplicitOffsets(conn, offsets, 0); err != nil {
+			t.Fatal(err)
+		}
+		if len(conn.requestV6.Topics) != 0 {
+			t.Error("expected the offsets to be committed with v2")
+		}
+		if ms := conn.request.RetentionTime; ms != int64(time.Hour/time.Millisecond) {
+			t.Errorf("unexpected retention time: %dms", ms)
+		}
+	})
+}
+
+func TestReaderStaticMembershipFencing(t *testing.T) {
+	if !ktesting.KafkaIsAtLeast("2.3.0") {
+		t.Skip("static membership requires kafka 2.3 or above")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	config := ReaderConfig{
+		Brokers:           []string{"localhost:9092"},
+		Topic:             topic,
+		GroupID:           makeGroupID(),
+		GroupInstanceID:   "instance-1",
+		HeartbeatInterval: 500 * time.Millisecond,
+		MinBytes:          1,
+		MaxBytes:          1e6,
+	}
+
+	r1 := NewReader(config)
+	defer r1.Close()
+	prepareReader(t, ctx, r1, makeTestSequence(1)...)
+
+	if _, err := r1.FetchMessage(ctx); err != nil {
+		t.Fatalf("bad err: %v", err)
+	}
+
+	// joining with the same instance id fences the first reader
+	r2 := NewReader(config)
+	defer r2.Close()
+
+	if _, err := r2.FetchMessage(ctx); err != nil {
+		t.Fatalf("bad err: %v", err)
+	}
+
+	for {
+		_, err := r1.FetchMessage(ctx)
+		if err == FencedInstanceID {
+			break
+		}
+		if err == ctx.Err() {
+			t.Fatal("the first reader was not fenced")
+		}
+	}
+
+	if err := r1.CommitMessages(ctx, Message{Topic: topic}); err != FencedInstanceID {
+		t.Errorf("expected %v; got %v", FencedInstanceID, err)
+	}
+}
+
+func TestReaderFencedRejectsCommits(t *testing.T) {
+	r := &Reader{
+		config: ReaderConfig{GroupID: "group", GroupInstanceID: "instance"},
+		fenced: FencedInstanceID,
+	}
+
+	if err := r.CommitMessages(context.Background(), Message{}); err != FencedInstanceID {
+		t.Errorf("expected %v; got %v", FencedInstanceID, err)
+	}
+
+	if err := r.CommitOffsets(context.Background(), map[string]map[int]int64{"topic": {0: 0}}); err != FencedInstanceID {
+		t.Errorf("expected %v; got %v", FencedInstanceID, err)
+	}
+}
+
+// Test that a reader won't continually rebalance when there are more consumers
+// than partitions in a group.
+// https://github.com/segmentio/kafka-go/issues/200
+func TestRebalanceTooManyConsumers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := ReaderConfig{
+		Brokers: []string{"localhost:9092"},
+		GroupID: makeGroupID(),
+		Topic:   makeTopic(),
+		MaxWait: time.Second,
+	}
+
+	// Create the first reader and wait for it to become the leader.
+	r1 := NewReader(conf)
+	prepareReader(t, ctx, r1, makeTestSequence(1)...)
+	r1.ReadMessage(ctx)
+	// Clear the stats from the first rebalance.
+	r1.Stats()
+
+	// Second reader should cause one rebalance for each r1 and r2.
+	r2 := NewReader(conf)
+
+	// Wait for rebalances.
+	time.Sleep(5 * time.Second)
+
+	// Before the fix, r2 would cause continuous rebalances,
+	// as it tried to handshake() repeatedly.
+	rebalances := r1.Stats().Rebalances + r2.Stats().Rebalances
+	if rebalances > 2 {
+		t.Errorf("unexpected rebalances to first reader, got %d", rebalances)
+
+	}
+}
+func TestAutoOffsetReset(t *testing.T) {
+
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	w := NewWriter(WriterConfig{
+		Brokers:   []string{"localhost:9092"},
+		Topic:     topic,
+		BatchSize: 1,
+	})
+
+	// Wait for topic leader election
+	time.Sleep(10 * time.Second)
+	// Write test data
+	msgs := make([]Message, 500)
+	err := w.WriteMessages(ctx, msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Expect default reader to read from first offset.
+	r := NewReader(ReaderConfig{
+		GroupID:  makeGroupID(),
+		Brokers:  []string{"localhost:9092"},
+		Topic:    topic,
+		MinBytes: 1,
+		MaxBytes: 100,
+		MaxWait:  100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	msg, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Offset != 0 {
+		t.Error("expected to get first message")
+	}
+
+	// Expected AutoOffsetReset = LastOffset to read from last offset.
+	r2 := NewReader(ReaderConfig{
+		GroupID:         makeGroupID(),
+		Brokers:         []string{"localhost:9092"},
+		Topic:           topic,
+		MinBytes:        1,
+		MaxBytes:        100,
+		MaxWait:         100 * time.Millisecond,
+		AutoOffsetReset: LastOffset,
+	})
+	defer r2.Close()
+
+	// Write a single test message, the "last" message.
+	go func() {
+		time.Sleep(1 * time.Second)
+		prepareReader(t, ctx, r, Message{Value: []byte("last")})
+	}()
+
+	msg, err = r2.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(msg.Value) != "last" {
+		t.Errorf("expected last message. got offset %d", msg.Offset)
+	}
+}
+
+func TestReaderStartOffset(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	const partitions = 3
+
+	topic := makeTopic()
+	createTopic(t, topic, partitions)
+
+	for partition := 0; partition != partitions; partition++ {
+		conn, err := DialLeader(ctx, "tcp", "localhost:9092", topic, partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.WriteMessages(makeTestSequence(2)...)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := ReaderConfig{
+		Brokers:     []string{"localhost:9092"},
+		Topic:       topic,
+		GroupID:     makeGroupID(),
+		MinBytes:    1,
+		MaxBytes:    1e6,
+		MaxWait:     100 * time.Millisecond,
+		StartOffset: FirstOffset,
+	}
+
+	// Without committed offsets, every partition is read from the start.
+	r1 := NewReader(config)
+
+	first := map[int]int64{}
+	for i := 0; i != 2*partitions; i++ {
+		m, err := r1.FetchMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := first[m.Partition]; !ok {
+			first[m.Partition] = m.Offset
+		}
+		if m.Offset == 0 {
+			if err := r1.CommitMessages(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r1.Close()
+
+	if expected := map[int]int64{0: 0, 1: 0, 2: 0}; !reflect.DeepEqual(expected, first) {
+		t.Errorf("expected to start from the first offset of each partition %v; got %v", expected, first)
+	}
+
+	// The committed offsets take precedence over StartOffset.
+	config.StartOffset = LastOffset
+	r2 := NewReader(config)
+	defer r2.Close()
+
+	for i := 0; i != partitions; i++ {
+		m, err := r2.FetchMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != 1 {
+			t.Errorf("expected to resume from the committed offset of partition %d; got offset %d", m.Partition, m.Offset)
+		}
+	}
+}
+
+func TestReaderStartOffsetDefault(t *testing.T) {
+	tests := []struct {
+		scenario        string
+		autoOffsetReset int64
+		startOffset     int64
+		expected        int64
+	}{
+		{
+			scenario: "defaults to the first offset",
+			expected: FirstOffset,
+		},
+		{
+			scenario:        "defaults to AutoOffsetReset",
+			autoOffsetReset: LastOffset,
+			expected:        LastOffset,
+		},
+		{
+			scenario:        "takes precedence over AutoOffsetReset",
+			autoOffsetReset: LastOffset,
+			startOffset:     FirstOffset,
+			expected:        FirstOffset,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			r := NewReader(ReaderConfig{
+				Brokers:         []string{"localhost:9092"},
+				Topic:           "topic",
+				GroupID:         "group",
+				AutoOffsetReset: test.autoOffsetReset,
+				StartOffset:     test.startOffset,
+			})
+			defer r.Close()
+
+			if offset := r.Config().StartOffset; offset != test.expected {
+				t.Errorf("expected StartOffset to be %d; got %d", test.expected, offset)
+			}
+		})
+	}
+}
+
+func TestReaderBrokersFailover(t *testing.T) {
+	t.Parallel()
+
+	// Grab an address that nothing listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{dead, "localhost:9092"},
+		Topic:   topic,
+		GroupID: makeGroupID(),
+		MaxWait: time.Second,
+	})
+	defer r.Close()
+
+	prepareReader(t, ctx, r, makeTestSequence(1)...)
+
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Value) != "0" {
+		t.Errorf("expected message value 0; got %q", m.Value)
+	}
+}
+
+func TestReaderGroupTopics(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	topics := []string{makeTopic(), makeTopic()}
+	for _, topic := range topics {
+		createTopic(t, topic, 2)
+
+		w := NewWriter(WriterConfig{
+			Brokers:   []string{"localhost:9092"},
+			Topic:     topic,
+			Balancer:  &RoundRobin{},
+			BatchSize: 1,
+		})
+		err := w.WriteMessages(ctx, Message{Value: []byte(topic)}, Message{Value: []byte(topic)})
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:     []string{"localhost:9092"},
+		GroupID:     makeGroupID(),
+		GroupTopics: topics,
+		MinBytes:    1,
+		MaxBytes:    1e6,
+		MaxWait:     100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	counts := map[string]int{}
+	for i := 0; i != 4; i++ {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Value) != m.Topic {
+			t.Errorf("expected the message to be read from topic %s; got %s", m.Value, m.Topic)
+		}
+		counts[m.Topic]++
+	}
+
+	if expected := map[string]int{topics[0]: 2, topics[1]: 2}; !reflect.DeepEqual(expected, counts) {
+		t.Errorf("expected %v messages by topic; got %v", expected, counts)
+	}
+}
+
+func TestReaderGroupTopicsSubscription(t *testing.T) {
+	r := &Reader{
+		config: ReaderConfig{
+			GroupID:        "group",
+			GroupTopics:    []string{"A", "B"},
+			GroupBalancers: []GroupBalancer{RangeGroupBalancer{}},
+		},
+	}
+
+	request, err := r.makejoinGroupRequestV1()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := r.makeMemberProtocolMetadata([]joinGroupResponseMemberV1{
+		{MemberID: "member", MemberMetadata: request.GroupProtocols[0].ProtocolMetadata},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if topics := members[0].Topics; !reflect.DeepEqual(topics, []string{"A", "B"}) {
+		t.Errorf("expected the subscription to include all the topics; got %v", topics)
+	}
+}
+
+func TestReaderConcurrentSetOffset(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]Message, 100)
+	for i := range msgs {
+		msgs[i].Value = []byte(strconv.Itoa(i))
+	}
+	_, err = conn.WriteMessages(msgs...)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		MaxWait: 10 * time.Millisecond,
+	})
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			// Leave the other goroutine a chance to set the offset between
+			// reads.
+			time.Sleep(time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			m, err := r.ReadMessage(ctx)
+			cancel()
+			switch err {
+			case nil:
+				if string(m.Value) != strconv.FormatInt(m.Offset, 10) {
+					t.Errorf("message at offset %d has value %q", m.Offset, m.Value)
+				}
+			case context.DeadlineExceeded:
+			default:
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	prng := rand.New(rand.NewSource(0))
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			if err := r.SetOffset(prng.Int63n(50)); err != nil && err != ErrFetchInProgress {
+				t.Fatal(err)
+			}
+			if offset := r.Offset(); offset < 0 || offset > 100 {
+				t.Errorf("offset out of range: %d", offset)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// With no fetch in progress the offset is set, and the next message read
+	// is the one at the offset.
+	if err := r.SetOffset(42); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset != 42 {
+		t.Errorf("expected to read the message at offset 42; got %d", m.Offset)
+	}
+	if offset := r.Offset(); offset != 43 {
+		t.Errorf("expected the offset of the reader to be 43; got %d", offset)
+	}
+}
+
+func TestReaderReadMessages(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	conn, err := DialLeader(context.Background(), "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]Message, 10)
+	for i := range msgs {
+		msgs[i].Value = []byte(strconv.Itoa(i))
+	}
+	_, err = conn.WriteMessages(msgs...)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		MaxWait: 10 * time.Millisecond,
+	})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := r.ReadMessages(ctx, 0); err == nil {
+		t.Error("expected an error when reading zero messages")
+	}
+
+	read, err := r.ReadMessages(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) == 0 || len(read) > 4 {
+		t.Fatalf("expected between 1 and 4 messages; got %d", len(read))
+	}
+
+	// Once the remaining messages were fetched, they are all returned without
+	// waiting for more.
+	for r.Stats().QueueLength < int64(len(msgs)-len(read)) {
+		if ctx.Err() != nil {
+			t.Fatal("timeout waiting for the messages to be fetched")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rest, err := r.ReadMessages(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read = append(read, rest...)
+
+	if len(read) != len(msgs) {
+		t.Fatalf("expected %d messages; got %d", len(msgs), len(read))
+	}
+	for i, m := range read {
+		if m.Offset != int64(i) || string(m.Value) != string(msgs[i].Value) {
+			t.Errorf("message %d mismatch: offset=%d value=%q", i, m.Offset, m.Value)
+		}
+	}
+}
+
+func TestReaderLeaderChange(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	write := func(values ...int) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		msgs := make([]Message, len(values))
+		for i, v := range values {
+			msgs[i].Value = []byte(strconv.Itoa(v))
+		}
+		if _, err := conn.WriteMessages(msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		MaxWait: 100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	read := func(from, to int) {
+		for i := from; i != to; i++ {
+			m, err := r.ReadMessage(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Offset != int64(i) || string(m.Value) != strconv.Itoa(i) {
+				t.Fatalf("expected message %d at offset %d; got %q at offset %d", i, i, m.Value, m.Offset)
+			}
+		}
+	}
+
+	write(0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	read(0, 5)
+
+	// The election of the new leader is still in progress when the reader
+	// first looks it up.
+	var elections int32 = 2
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch && atomic.AddInt32(&elections, -1) >= 0 {
+			return MockResponse{Error: LeaderNotAvailable}
+		}
+		return MockResponse{}
+	})
+	broker.MoveLeader("test", 0, 1)
+
+	write(10, 11, 12, 13, 14)
+	read(5, 15)
+
+	// Reconnecting to the new leader is not a rebalance of a consumer group.
+	if stats := r.Stats(); stats.Rebalances != 0 || stats.Errors != 0 {
+		t.Errorf("expected the leader change to be handled without rebalances or errors; got %d rebalances and %d errors", stats.Rebalances, stats.Errors)
+	}
+}
+
+func TestReaderRackAwareFetching(t *testing.T) {
+	broker, err := NewMockCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+	broker.SetRack(0, "a")
+	broker.SetRack(1, "b")
+	broker.SetRack(2, "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var fetches [3]int
+	var replicaFails bool
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API != MockFetch {
+			return MockResponse{}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches[req.Node]++
+		if req.Node == 1 && replicaFails {
+			return MockResponse{Error: NotLeaderForPartition}
+		}
+		return MockResponse{}
+	})
+	fetchesFrom := func(node int) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return fetches[node]
+	}
+
+	write := func(values ...int) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		msgs := make([]Message, len(values))
+		for i, v := range values {
+			msgs[i].Value = []byte(strconv.Itoa(v))
+		}
+		if _, err := conn.WriteMessages(msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		MaxWait: 100 * time.Millisecond,
+		RackID:  "b",
+	})
+	defer r.Close()
+
+	read := func(from, to int) {
+		for i := from; i != to; i++ {
+			m, err := r.ReadMessage(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Offset != int64(i) || string(m.Value) != strconv.Itoa(i) {
+				t.Fatalf("expected message %d at offset %d; got %q at offset %d", i, i, m.Value, m.Offset)
+			}
+		}
+	}
+
+	write(0, 1, 2, 3, 4)
+	read(0, 5)
+
+	if n := fetchesFrom(1); n == 0 {
+		t.Error("expected the reader to fetch from the replica in its rack")
+	}
+	if n := fetchesFrom(2); n != 0 {
+		t.Errorf("expected the reader not to fetch from the replica in another rack; got %d fetches", n)
+	}
+
+	// The replica leaves the rack of the reader and stops serving fetch
+	// requests, the reader goes back to the partition leader.
+	broker.SetRack(1, "c")
+	mutex.Lock()
+	replicaFails = true
+	leaderFetches := fetches[0]
+	mutex.Unlock()
+
+	for fetchesFrom(0) == leaderFetches {
+		if !sleep(ctx, 10*time.Millisecond) {
+			t.Fatal("expected the reader to fetch from the partition leader again")
+		}
+	}
+
+	write(5, 6, 7, 8, 9)
+	read(5, 10)
+
+	if stats := r.Stats(); stats.Errors != 0 {
+		t.Errorf("expected the replica changes to be handled without errors; got %d errors", stats.Errors)
+	}
+}
+
+func TestReaderReadReplicaLease(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+	broker.SetRack(1, "b")
+
+	var fetches [2]int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch {
+			atomic.AddInt32(&fetches[req.Node], 1)
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteMessages(Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:                 []string{broker.Addr()},
+		Topic:                   "test",
+		MaxWait:                 10 * time.Millisecond,
+		RackID:                  "b",
+		MetadataRefreshInterval: 50 * time.Millisecond,
+	})
+	defer r.Close()
+
+	if _, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader goes back to the partition leader each time the metadata
+	// expire, which designates the replica again.
+	leaderFetches := atomic.LoadInt32(&fetches[0])
+	for atomic.LoadInt32(&fetches[0]) < leaderFetches+2 {
+		if !sleep(ctx, 10*time.Millisecond) {
+			t.Fatal("expected the reader to fetch from the partition leader again")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches[1]); n == 0 {
+		t.Error("expected the reader to fetch from the replica in its rack")
+	}
+}
+
+func TestReaderMaxWaitShorterThanReadBatchTimeout(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	r := NewReader(ReaderConfig{
+		Brokers:          []string{broker.Addr()},
+		Topic:            "test",
+		MaxWait:          100 * time.Millisecond,
+		ReadBatchTimeout: 30 * time.Second,
+	})
+	defer r.Close()
+
+	// The topic is empty, each fetch request returns after MaxWait instead of
+	// blocking until the read timeout of the connection.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := r.ReadMessage(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	stats := r.Stats()
+	if stats.Fetches < 3 {
+		t.Errorf("expected the fetch requests to wait for at most MaxWait; got %d fetches in 1s", stats.Fetches)
+	}
+	if stats.Timeouts != 0 || stats.Errors != 0 {
+		t.Errorf("expected no timeouts or errors; got %d timeouts and %d errors", stats.Timeouts, stats.Errors)
+	}
+}
+
+func TestReaderTailLookback(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := make([]Message, 10)
+	for i := range msgs {
+		msgs[i].Value = []byte(strconv.Itoa(i))
+	}
+	if _, err := conn.WriteMessages(msgs...); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	tests := []struct {
+		lookback int64
+		offset   int64
+	}{
+		{lookback: 3, offset: 7},
+		{lookback: 10, offset: 0},
+		{lookback: 100, offset: 0},
+	}
+
+	for _, test := range tests {
+		r := NewReader(ReaderConfig{
+			Brokers:      []string{broker.Addr()},
+			Topic:        "test",
+			MaxWait:      100 * time.Millisecond,
+			TailLookback: test.lookback,
+		})
+		r.SetOffset(LastOffset)
+
+		m, err := r.ReadMessage(ctx)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != test.offset {
+			t.Errorf("expected a lookback of %d to start at offset %d, got %d", test.lookback, test.offset, m.Offset)
+		}
+	}
+}
+
+func TestReaderReadMessageTimeout(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	r := NewReader(ReaderConfig{
+		Brokers:            []string{broker.Addr()},
+		Topic:              "test",
+		MaxWait:            10 * time.Millisecond,
+		ReadMessageTimeout: 100 * time.Millisecond,
+	})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := r.ReadMessage(ctx); err != ErrReadTimeout {
+		t.Fatalf("expected %v; got %v", ErrReadTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the read to time out after 100ms; returned after %s", elapsed)
+	}
+	if _, err := r.ReadMessages(ctx, 10); err != ErrReadTimeout {
+		t.Fatalf("expected %v; got %v", ErrReadTimeout, err)
+	}
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteMessages(Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader is still usable after a timeout, the next read returns the
+	// message which was written in the meantime.
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Value) != "hello" {
+		t.Errorf("unexpected message value: %q", m.Value)
+	}
+
+	// A done context interrupts the read before the timeout expires.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := r.ReadMessage(canceled); err != context.Canceled {
+		t.Errorf("expected %v; got %v", context.Canceled, err)
+	}
+}
+
+func TestReaderMetadataRefresh(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The old leader keeps timing out the fetch requests instead of rejecting
+	// them once the partition moved, so only a metadata refresh moves the
+	// reader to the new leader.
+	var moved int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch && req.Node == 0 && atomic.LoadInt32(&moved) != 0 {
+			return MockResponse{Error: RequestTimedOut}
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	produce := func(value string) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.WriteMessages(Message{Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:                 []string{broker.Addr()},
+		Topic:                   "test",
+		MaxWait:                 10 * time.Millisecond,
+		ReadBackoffMin:          10 * time.Millisecond,
+		ReadBackoffMax:          10 * time.Millisecond,
+		MetadataRefreshInterval: 50 * time.Millisecond,
+	})
+	defer r.Close()
+
+	produce("A")
+	if m, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	} else if string(m.Value) != "A" {
+		t.Fatalf("unexpected message value: %q", m.Value)
+	}
+
+	broker.MoveLeader("test", 0, 1)
+	atomic.StoreInt32(&moved, 1)
+	produce("B")
+
+	if m, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	} else if string(m.Value) != "B" {
+		t.Fatalf("unexpected message value: %q", m.Value)
+	}
+
+	// Moving to the new leader of a partition doesn't rebalance the group.
+	if stats := r.Stats(); stats.Rebalances != 0 {
+		t.Errorf("expected no rebalances; got %d", stats.Rebalances)
+	}
+}
+
+func TestReaderResumeWithoutBackoff(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	produce := func(value string) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.WriteMessages(Message{Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var once sync.Once
+	paused := make(chan struct{})
+
+	// The backoff is much longer than the time the partition is paused for,
+	// so waiting for it would delay the next message.
+	const backoff = time.Second
+	r := NewReader(ReaderConfig{
+		Brokers:        []string{broker.Addr()},
+		Topic:          "test",
+		MaxWait:        10 * time.Millisecond,
+		ReadBackoffMin: backoff,
+		ReadBackoffMax: backoff,
+		StructuredLogger: LoggerFunc(func(format string, args ...interface{}) {
+			if strings.Contains(fmt.Sprintf(format, args...), "pausing partition reader") {
+				once.Do(func() { close(paused) })
+			}
+		}),
+	})
+	defer r.Close()
+
+	produce("A")
+	if m, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	} else if string(m.Value) != "A" {
+		t.Fatalf("unexpected message value: %q", m.Value)
+	}
+
+	r.Pause(0)
+	select {
+	case <-paused:
+	case <-ctx.Done():
+		t.Fatal("the partition reader was not paused")
+	}
+	produce("B")
+
+	t0 := time.Now()
+	r.Resume(0)
+	if m, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	} else if string(m.Value) != "B" {
+		t.Fatalf("unexpected message value: %q", m.Value)
+	}
+	if d := time.Since(t0); d >= backoff {
+		t.Errorf("expected the reader to resume without backing off; took %s", d)
+	}
+}
+
+func TestReaderCorruptBatch(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// corrupt is the number of fetch requests to corrupt, fetches counts the
+	// fetch requests which were corrupted.
+	var corrupt, fetches int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockFetch && atomic.AddInt32(&corrupt, -1) >= 0 {
+			atomic.AddInt32(&fetches, 1)
+			return MockResponse{Corrupt: true}
+		}
+		return MockResponse{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteMessages(Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+
+	newReader := func() *Reader {
+		return NewReader(ReaderConfig{
+			Brokers:        []string{broker.Addr()},
+			Topic:          "test",
+			MaxWait:        10 * time.Millisecond,
+			ReadBackoffMin: 10 * time.Millisecond,
+			ReadBackoffMax: 10 * time.Millisecond,
+		})
+	}
+
+	// A batch corrupted in transit is fetched again without reporting an
+	// error to the program.
+	atomic.StoreInt32(&corrupt, 1)
+	r := newReader()
+	if m, err := r.ReadMessage(ctx); err != nil {
+		t.Fatal(err)
+	} else if string(m.Value) != "A" {
+		t.Fatalf("unexpected message value: %q", m.Value)
+	}
+	r.Close()
+
+	// The reader gives up on a batch which stays corrupted.
+	atomic.StoreInt32(&corrupt, math.MaxInt32)
+	atomic.StoreInt32(&fetches, 0)
+	r = newReader()
+	defer r.Close()
+	if _, err := r.ReadMessage(ctx); err != ErrCorruptBatch {
+		t.Fatalf("expected %v; got %v", ErrCorruptBatch, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&fetches); n != maxCorruptBatchAttempts {
+		t.Errorf("expected the batch to be fetched %d times; got %d", maxCorruptBatchAttempts, n)
+	}
+}
+
+func TestReaderFetchSessions(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	produce := func(value string) {
+		conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.WriteMessages(Message{Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:        []string{broker.Addr()},
+		Topic:          "test",
+		MaxWait:        10 * time.Millisecond,
+		ReadBackoffMin: 10 * time.Millisecond,
+		ReadBackoffMax: 10 * time.Millisecond,
+		FetchSessions:  true,
+	})
+	defer r.Close()
+
+	for _, value := range []string{"A", "B", "C"} {
+		if value == "C" {
+			// The reader creates a new session after the broker evicted it.
+			broker.EvictFetchSessions()
+		}
+		time.Sleep(50 * time.Millisecond)
+		produce(value)
+
+		if m, err := r.ReadMessage(ctx); err != nil {
+			t.Fatal(err)
+		} else if string(m.Value) != value {
+			t.Fatalf("unexpected message value: %q", m.Value)
+		}
+	}
+
+	if stats := r.Stats(); stats.Errors != 0 {
+		t.Errorf("expected no errors; got %d", stats.Errors)
+	}
+}
+
+func TestReaderIdleTimeout(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialLeader(ctx, "tcp", broker.Addr(), "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteMessages(
+		Message{Value: []byte("A")},
+		Message{Value: []byte("B")},
+		Message{Value: []byte("C")},
+		Message{Value: []byte("D")},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(ReaderConfig{
+		Brokers:       []string{broker.Addr()},
+		Topic:         "test",
+		MaxWait:       50 * time.Millisecond,
+		QueueCapacity: 1,
+		// shorter than MaxWait, the long polling fetch requests of the
+		// reader must not count as idle time
+		IdleTimeout: 10 * time.Millisecond,
+	})
+	defer r.Close()
+
+	for i, value := range []string{"A", "B", "C", "D", "E"} {
+		switch i {
+		case 1:
+			// The reader blocks delivering the messages of the fetch
+			// response while the program does not read them, then
+			// reconnects before fetching again.
+			time.Sleep(150 * time.Millisecond)
+		case 4:
+			if _, err := conn.WriteMessages(Message{Value: []byte("E")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Value) != value {
+			t.Fatalf("unexpected message value at offset %d: %q", m.Offset, m.Value)
+		}
+	}
+
+	if dials := r.Stats().Dials; dials != 2 {
+		t.Errorf("expected the reader to reconnect once; got %d dials", dials)
 	}
 }
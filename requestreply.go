@@ -0,0 +1,314 @@
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Header keys set by RequestReply on the requests it produces. Responders must
+// copy the correlation ID header to their reply, and produce it to the topic
+// and partition carried by the other headers, the partition being encoded as a
+// decimal number.
+const (
+	RequestReplyCorrelationHeader = "kafka-correlation-id"
+	RequestReplyTopicHeader       = "kafka-reply-topic"
+	RequestReplyPartitionHeader   = "kafka-reply-partition"
+)
+
+// ErrReplyTimeout is returned by RequestReply.Request when no reply was
+// received within the timeout of the RequestReply.
+var ErrReplyTimeout = errors.New("kafka: timed out waiting for the reply")
+
+// RequestReplyConfig is a configuration object used to create new instances of
+// RequestReply.
+type RequestReplyConfig struct {
+	// Request configures the writer producing the requests to the topic that
+	// the responders consume.
+	//
+	// The writer must not be asynchronous, since the request is only waited
+	// for once it was written. Unless it is set, BatchTimeout defaults to 10ms
+	// so requests are not held in batches.
+	Request WriterConfig
+
+	// Reply configures the reader consuming the replies, from the topic and
+	// partition where the responders produce them. It cannot be part of a
+	// consumer group, since each RequestReply must see all the replies to its
+	// requests. Programs running multiple instances should give each of them
+	// its own reply partition or topic.
+	//
+	// The reader starts from the end of the partition when the first request
+	// is made, the replies produced before are ignored.
+	Reply ReaderConfig
+
+	// Timeout is the amount of time that Request waits for the reply once the
+	// request was written.
+	//
+	// Default: 30s
+	Timeout time.Duration
+}
+
+// RequestReply implements synchronous request-reply over kafka. Requests are
+// produced with a unique correlation ID, and the replies read from the reply
+// partition are matched to the requests waiting for them by this ID.
+//
+// The requests carry the correlation ID, the reply topic and the reply
+// partition as headers (see the RequestReply*Header constants), which are
+// only supported by kafka 0.11 and above. Replies which do not match a pending
+// request, like the replies received after their request timed out, are
+// dropped.
+//
+// Instances of RequestReply are safe to use concurrently from multiple
+// goroutines.
+type RequestReply struct {
+	config RequestReplyConfig
+	writer *Writer
+	reader *Reader
+
+	// startMutex serializes the calls to start, which look up the position of
+	// the reader without holding mutex.
+	startMutex sync.Mutex
+
+	mutex   sync.Mutex
+	pending map[string]chan<- Message
+	started bool
+	closed  bool
+	err     error
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewRequestReply creates and returns a new RequestReply configured with
+// config. Replies are read in the background once the first request is made.
+func NewRequestReply(config RequestReplyConfig) *RequestReply {
+	if config.Request.Async {
+		panic("the request writer of a RequestReply cannot be asynchronous")
+	}
+
+	if config.Reply.GroupID != "" {
+		panic("the reply reader of a RequestReply cannot be part of a consumer group")
+	}
+
+	if config.Timeout < 0 {
+		panic(fmt.Sprintf("Timeout out of bounds: %s", config.Timeout))
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	if config.Request.BatchTimeout == 0 {
+		config.Request.BatchTimeout = 10 * time.Millisecond
+	}
+
+	return &RequestReply{
+		config:  config,
+		writer:  NewWriter(config.Request),
+		reader:  NewReader(config.Reply),
+		pending: make(map[string]chan<- Message),
+		cancel:  func() {},
+		done:    make(chan struct{}),
+	}
+}
+
+// Request produces msg with a new correlation ID and returns the reply with
+// the same correlation ID. Headers are added to a copy of msg, the one passed
+// by the program is not modified.
+//
+// The method returns ErrReplyTimeout when no reply was received within the
+// configured timeout, or the error of ctx when it is canceled first. The
+// request is no longer pending once the method returned, a late reply is
+// dropped.
+func (rr *RequestReply) Request(ctx context.Context, msg Message) (Message, error) {
+	if err := rr.start(ctx); err != nil {
+		return Message{}, err
+	}
+
+	id, err := makeCorrelationID()
+	if err != nil {
+		return Message{}, err
+	}
+
+	// The request is pending before it is written, the reply may be read
+	// before WriteMessages returns.
+	reply := make(chan Message, 1)
+	rr.mutex.Lock()
+	rr.pending[id] = reply
+	rr.mutex.Unlock()
+	defer rr.forget(id)
+
+	headers := make([]Header, 0, len(msg.Headers)+3)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		Header{Key: RequestReplyCorrelationHeader, Value: []byte(id)},
+		Header{Key: RequestReplyTopicHeader, Value: []byte(rr.config.Reply.Topic)},
+		Header{Key: RequestReplyPartitionHeader, Value: []byte(strconv.Itoa(rr.config.Reply.Partition))},
+	)
+	msg.Headers = headers
+
+	if err := rr.writer.WriteMessages(ctx, msg); err != nil {
+		return Message{}, err
+	}
+
+	timer := time.NewTimer(rr.config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case m := <-reply:
+		return m, nil
+	case <-timer.C:
+		return Message{}, ErrReplyTimeout
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case <-rr.done:
+		rr.mutex.Lock()
+		defer rr.mutex.Unlock()
+		return Message{}, rr.err
+	}
+}
+
+// start positions the reader at the end of the reply partition and starts
+// reading the replies, unless it was already done.
+func (rr *RequestReply) start(ctx context.Context) error {
+	rr.startMutex.Lock()
+	defer rr.startMutex.Unlock()
+
+	rr.mutex.Lock()
+	closed, started := rr.closed, rr.started
+	rr.mutex.Unlock()
+
+	if closed {
+		return io.ErrClosedPipe
+	}
+	if started {
+		return nil
+	}
+
+	// The last offset is looked up before the first request is written, so
+	// its reply cannot be produced before the position of the reader. The
+	// mutex is not held meanwhile, Close doesn't wait for the lookup.
+	offset, err := rr.lastReplyOffset(ctx)
+	if err != nil {
+		return err
+	}
+	if err := rr.reader.SetOffset(offset); err != nil {
+		return err
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if rr.closed {
+		return io.ErrClosedPipe
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rr.cancel = cancel
+	rr.started = true
+	go rr.readLoop(ctx)
+	return nil
+}
+
+func (rr *RequestReply) lastReplyOffset(ctx context.Context) (int64, error) {
+	dialer := rr.config.Reply.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+
+	for _, broker := range rr.config.Reply.Brokers {
+		conn, err := dialer.DialLeader(ctx, "tcp", broker, rr.config.Reply.Topic, rr.config.Reply.Partition)
+		if err != nil {
+			continue
+		}
+
+		deadline, _ := ctx.Deadline()
+		conn.SetDeadline(deadline)
+		offset, err := conn.ReadLastOffset()
+		conn.Close()
+		return offset, err
+	}
+	return 0, fmt.Errorf("error looking up the last offset of partition %d of topic %q", rr.config.Reply.Partition, rr.config.Reply.Topic)
+}
+
+// readLoop reads the replies until ctx is canceled, and delivers them to the
+// requests waiting for them.
+func (rr *RequestReply) readLoop(ctx context.Context) {
+	defer close(rr.done)
+
+	for {
+		msg, err := rr.reader.ReadMessage(ctx)
+		if err != nil {
+			rr.mutex.Lock()
+			if rr.err == nil {
+				rr.err = err
+			}
+			rr.mutex.Unlock()
+			return
+		}
+
+		id := correlationID(msg)
+		rr.mutex.Lock()
+		reply, ok := rr.pending[id]
+		delete(rr.pending, id)
+		rr.mutex.Unlock()
+
+		if ok {
+			reply <- msg
+		}
+	}
+}
+
+func (rr *RequestReply) forget(id string) {
+	rr.mutex.Lock()
+	delete(rr.pending, id)
+	rr.mutex.Unlock()
+}
+
+// Close stops reading replies and closes the reader and the writer of the
+// RequestReply, the pending requests fail with io.ErrClosedPipe.
+func (rr *RequestReply) Close() error {
+	rr.mutex.Lock()
+	started := rr.started
+	if !rr.closed {
+		rr.closed = true
+		rr.err = io.ErrClosedPipe
+		rr.cancel()
+	}
+	rr.mutex.Unlock()
+
+	if started {
+		<-rr.done
+	}
+
+	rerr := rr.reader.Close()
+	werr := rr.writer.Close()
+	if rerr != nil {
+		return rerr
+	}
+	return werr
+}
+
+// correlationID returns the correlation ID carried by msg, or an empty string
+// if it has none.
+func correlationID(msg Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == RequestReplyCorrelationHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func makeCorrelationID() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,213 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestReply(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("requests", 1)
+	broker.CreateTopic("replies", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The responder replies with the value of the requests in upper case.
+	responder := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "requests",
+		MaxWait: 10 * time.Millisecond,
+	})
+	defer responder.Close()
+
+	replies := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "replies",
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer replies.Close()
+
+	go func() {
+		for {
+			req, err := responder.ReadMessage(ctx)
+			if err != nil {
+				return
+			}
+
+			headers := make(map[string]string)
+			for _, h := range req.Headers {
+				headers[h.Key] = string(h.Value)
+			}
+			if headers[RequestReplyTopicHeader] != "replies" || headers[RequestReplyPartitionHeader] != "0" {
+				t.Errorf("unexpected reply headers: %v", headers)
+			}
+
+			replies.WriteMessages(ctx, Message{
+				Value:   bytes.ToUpper(req.Value),
+				Headers: []Header{{Key: RequestReplyCorrelationHeader, Value: []byte(headers[RequestReplyCorrelationHeader])}},
+			})
+		}
+	}()
+
+	rr := NewRequestReply(RequestReplyConfig{
+		Request: WriterConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "requests",
+		},
+		Reply: ReaderConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "replies",
+			MaxWait: 10 * time.Millisecond,
+		},
+	})
+	defer rr.Close()
+
+	var wg sync.WaitGroup
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+
+			msg := Message{Value: []byte(value)}
+			reply, err := rr.Request(ctx, msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(reply.Value) != string(bytes.ToUpper([]byte(value))) {
+				t.Errorf("unexpected reply to %q: %q", value, reply.Value)
+			}
+			if len(msg.Headers) != 0 {
+				t.Errorf("expected the request message to be left unmodified; got headers %v", msg.Headers)
+			}
+		}(value)
+	}
+	wg.Wait()
+
+	rr.mutex.Lock()
+	pending := len(rr.pending)
+	rr.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("expected no pending requests; got %d", pending)
+	}
+}
+
+func TestRequestReplyTimeout(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("requests", 1)
+	broker.CreateTopic("replies", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rr := NewRequestReply(RequestReplyConfig{
+		Request: WriterConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "requests",
+		},
+		Reply: ReaderConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "replies",
+			MaxWait: 10 * time.Millisecond,
+		},
+		Timeout: 100 * time.Millisecond,
+	})
+
+	if _, err := rr.Request(ctx, Message{Value: []byte("hello")}); err != ErrReplyTimeout {
+		t.Errorf("expected %v; got %v", ErrReplyTimeout, err)
+	}
+
+	rr.mutex.Lock()
+	pending := len(rr.pending)
+	rr.mutex.Unlock()
+	if pending != 0 {
+		t.Errorf("expected the request to be removed once it timed out; got %d pending", pending)
+	}
+
+	if n := len(broker.Messages("requests", 0)); n != 1 {
+		t.Errorf("expected 1 request to be written; got %d", n)
+	}
+
+	if err := rr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rr.Request(ctx, Message{Value: []byte("hello")}); err == nil {
+		t.Error("expected an error making a request after closing")
+	}
+}
+
+func TestRequestReplyCloseWhileStarting(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("requests", 1)
+	broker.CreateTopic("replies", 1)
+
+	// The lookup of the last offset of the reply partition blocks until the
+	// RequestReply was closed.
+	lookup := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockListOffsets {
+			once.Do(func() { close(lookup) })
+			<-release
+		}
+		return MockResponse{}
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rr := NewRequestReply(RequestReplyConfig{
+		Request: WriterConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "requests",
+		},
+		Reply: ReaderConfig{
+			Brokers: []string{broker.Addr()},
+			Topic:   "replies",
+			MaxWait: 10 * time.Millisecond,
+		},
+	})
+
+	errch := make(chan error, 1)
+	go func() {
+		_, err := rr.Request(ctx, Message{Value: []byte("hello")})
+		errch <- err
+	}()
+
+	select {
+	case <-lookup:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the lookup of the reply offset")
+	}
+
+	// Close doesn't wait for the lookup, the request fails once it completes.
+	if err := rr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	release <- struct{}{}
+
+	if err := <-errch; err == nil {
+		t.Error("expected the request to fail after closing")
+	}
+	if n := len(broker.Messages("requests", 0)); n != 0 {
+		t.Errorf("expected no request to be written; got %d", n)
+	}
+}
@@ -12,6 +12,10 @@ type Mechanism struct {
 	Password string
 }
 
+func (m Mechanism) Name() string {
+	return "PLAIN"
+}
+
 func (m Mechanism) Start(ctx context.Context) (string, []byte, error) {
 	return "PLAIN", []byte(fmt.Sprintf("\x00%s\x00%s", m.Username, m.Password)), nil
 }
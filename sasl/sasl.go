@@ -29,3 +29,12 @@ type Mechanism interface {
 	// the client to abort the authentication attempt.
 	Next(ctx context.Context, challenge []byte) (done bool, response []byte, err error)
 }
+
+// Namer is an optional interface of the mechanisms which report their name
+// without starting an authentication. A kafka.Dialer configured with several
+// mechanisms uses it to pick the one enabled on the broker without starting
+// the others.
+type Namer interface {
+	// Name returns the authentication mechanism name returned by Start.
+	Name() string
+}
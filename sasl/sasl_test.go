@@ -92,6 +92,8 @@ func testConnect(t *testing.T, mechanism sasl.Mechanism, success bool) {
 
 	d := kafka.Dialer{
 		SASLMechanism: mechanism,
+		// the sasl listener of the test cluster doesn't use TLS
+		AllowPlaintextSASL: true,
 	}
 	_, err := d.DialLeader(ctx, "tcp", saslTestConnect, saslTestTopic, 0)
 	if success && err != nil {
@@ -69,6 +69,10 @@ func Mechanism(algo Algorithm, username, password string) (sasl.Mechanism, error
 	}, nil
 }
 
+func (m *mechanism) Name() string {
+	return m.algo.Name()
+}
+
 func (m *mechanism) Start(ctx context.Context) (string, []byte, error) {
 	m.convo = m.client.NewConversation()
 	str, err := m.convo.Step("")
@@ -0,0 +1,157 @@
+package kafka
+
+import "fmt"
+
+// Deserializer is the interface implemented by types which decode the keys or
+// values of messages read by a Reader, see the KeyDeserializer and
+// ValueDeserializer fields of ReaderConfig.
+//
+// Deserialize receives the topic that the message was read from, so that the
+// lookups of schemas in a registry can be scoped to the topic, and the raw
+// bytes of the key or value, which may be nil.
+type Deserializer interface {
+	Deserialize(topic string, data []byte) (interface{}, error)
+}
+
+// DeserializerFunc is an implementation of the Deserializer interface that
+// makes it possible to use regular functions to decode messages.
+type DeserializerFunc func(topic string, data []byte) (interface{}, error)
+
+// Deserialize calls f, satisfies the Deserializer interface.
+func (f DeserializerFunc) Deserialize(topic string, data []byte) (interface{}, error) {
+	return f(topic, data)
+}
+
+// DeserializationError is returned by Reader.FetchMessage and
+// Reader.ReadMessage along with a message that could not be decoded. The reader
+// moves on to the next message, the program decides whether to skip the
+// message or to stop consuming.
+type DeserializationError struct {
+	// Topic, Partition and Offset identify the message.
+	Topic     string
+	Partition int
+	Offset    int64
+
+	// Key is true if the key of the message failed to be decoded, false if it
+	// was the value.
+	Key bool
+
+	// Err is the error returned by the deserializer.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e *DeserializationError) Error() string {
+	part := "value"
+	if e.Key {
+		part = "key"
+	}
+	return fmt.Sprintf("failed to deserialize the %s of the message at offset %d of %s/%d: %v", part, e.Offset, e.Topic, e.Partition, e.Err)
+}
+
+// deserialize decodes the key and value of msg with the deserializers
+// configured on the reader.
+func (r *Reader) deserialize(msg *Message) error {
+	if d := r.config.KeyDeserializer; d != nil && msg.Key != nil {
+		key, err := d.Deserialize(msg.Topic, msg.Key)
+		if err != nil {
+			return msg.deserializationError(true, err)
+		}
+		msg.DecodedKey = key
+	}
+
+	if d := r.config.ValueDeserializer; d != nil && msg.Value != nil {
+		value, err := d.Deserialize(msg.Topic, msg.Value)
+		if err != nil {
+			return msg.deserializationError(false, err)
+		}
+		msg.DecodedValue = value
+	}
+
+	return nil
+}
+
+func (msg *Message) deserializationError(key bool, err error) *DeserializationError {
+	return &DeserializationError{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       key,
+		Err:       err,
+	}
+}
+
+// Serializer is the interface implemented by types which encode the keys or
+// values of messages written by a Writer, see the KeySerializer and
+// ValueSerializer fields of WriterConfig.
+//
+// Serialize receives the topic that the message is written to and the
+// DecodedKey or DecodedValue field of the message, and returns the bytes set to
+// its Key or Value.
+type Serializer interface {
+	Serialize(topic string, value interface{}) ([]byte, error)
+}
+
+// SerializerFunc is an implementation of the Serializer interface that makes it
+// possible to use regular functions to encode messages.
+type SerializerFunc func(topic string, value interface{}) ([]byte, error)
+
+// Serialize calls f, satisfies the Serializer interface.
+func (f SerializerFunc) Serialize(topic string, value interface{}) ([]byte, error) {
+	return f(topic, value)
+}
+
+// SerializationError is reported by Writer.WriteMessages, in a WriteErrors,
+// when a message could not be encoded by the serializers of the writer. The
+// message is not written, the other messages passed to WriteMessages are.
+type SerializationError struct {
+	// Topic is the topic that the message was written to.
+	Topic string
+
+	// Index is the position of the message in the call to WriteMessages.
+	Index int
+
+	// Key is true if the key of the message failed to be encoded, false if it
+	// was the value.
+	Key bool
+
+	// Err is the error returned by the serializer.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e *SerializationError) Error() string {
+	part := "value"
+	if e.Key {
+		part = "key"
+	}
+	return fmt.Sprintf("failed to serialize the %s of message %d written to %s: %v", part, e.Index, e.Topic, e.Err)
+}
+
+// serialize encodes the decoded key and value of msg with the serializers
+// configured on the writer. index is the position of the message in the call
+// to WriteMessages.
+func (w *Writer) serialize(msg Message, index int) (Message, error) {
+	keys, values := w.config.KeySerializer, w.config.ValueSerializer
+
+	var err error
+	topic := w.topicOf(msg)
+
+	if keys != nil && msg.DecodedKey != nil {
+		if msg.Key, err = keys.Serialize(topic, msg.DecodedKey); err != nil {
+			err = &SerializationError{Topic: topic, Index: index, Key: true, Err: err}
+		}
+	}
+
+	if err == nil && values != nil && msg.DecodedValue != nil {
+		if msg.Value, err = values.Serialize(topic, msg.DecodedValue); err != nil {
+			err = &SerializationError{Topic: topic, Index: index, Err: err}
+		}
+	}
+
+	if err != nil {
+		w.logger().Error("failed to serialize message", "topic", topic, "index", index, "error", err)
+		w.stats.errors.observe(1)
+	}
+	return msg, err
+}
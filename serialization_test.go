@@ -0,0 +1,147 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestReaderDeserializers(t *testing.T) {
+	errBadValue := errors.New("bad value")
+
+	r := &Reader{
+		config: ReaderConfig{
+			KeyDeserializer: DeserializerFunc(func(topic string, data []byte) (interface{}, error) {
+				return topic + ":" + string(data), nil
+			}),
+			ValueDeserializer: DeserializerFunc(func(topic string, data []byte) (interface{}, error) {
+				n, err := strconv.Atoi(string(data))
+				if err != nil {
+					return nil, errBadValue
+				}
+				return n, nil
+			}),
+		},
+		msgs:    make(chan readerMessage, 4),
+		version: 1,
+	}
+
+	for i, value := range [][]byte{[]byte("1"), []byte("oops"), []byte("3"), nil} {
+		r.msgs <- readerMessage{
+			version: 1,
+			message: Message{Topic: "A", Partition: 2, Offset: int64(i), Key: []byte("k"), Value: value},
+		}
+	}
+
+	ctx := context.Background()
+
+	m, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DecodedKey != "A:k" || m.DecodedValue != 1 {
+		t.Errorf("unexpected decoded key and value: %v, %v", m.DecodedKey, m.DecodedValue)
+	}
+
+	m, err = r.ReadMessage(ctx)
+	derr, ok := err.(*DeserializationError)
+	if !ok {
+		t.Fatalf("expected a *DeserializationError; got %v", err)
+	}
+	if derr.Err != errBadValue || derr.Key || derr.Topic != "A" || derr.Partition != 2 || derr.Offset != 1 {
+		t.Errorf("unexpected deserialization error: %+v", derr)
+	}
+	if string(m.Value) != "oops" || m.DecodedValue != nil {
+		t.Errorf("expected the raw message to be returned with the error; got %+v", m)
+	}
+
+	m, err = r.FetchMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset != 2 || m.DecodedValue != 3 {
+		t.Errorf("expected the reader to move past the message which failed to be decoded; got %+v", m)
+	}
+
+	m, err = r.FetchMessage(ctx)
+	if err != nil {
+		t.Fatalf("expected the tombstone not to be passed to the deserializer; got %v", err)
+	}
+	if !m.IsTombstone() || m.DecodedKey != "A:k" || m.DecodedValue != nil {
+		t.Errorf("expected a tombstone with a decoded key and no decoded value; got %+v", m)
+	}
+}
+
+func TestWriterSerializers(t *testing.T) {
+	errBadValue := errors.New("bad value")
+
+	w := NewWriter(WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   "A",
+		KeySerializer: SerializerFunc(func(topic string, value interface{}) ([]byte, error) {
+			return []byte(topic + ":" + value.(string)), nil
+		}),
+		ValueSerializer: SerializerFunc(func(topic string, value interface{}) ([]byte, error) {
+			n, ok := value.(int)
+			if !ok {
+				return nil, errBadValue
+			}
+			return []byte(strconv.Itoa(n)), nil
+		}),
+	})
+	defer w.Close()
+
+	msgs, err := w.prepare([]Message{
+		{DecodedKey: "k", DecodedValue: 1},
+		{DecodedValue: "oops"},
+		{Key: []byte("raw"), Value: []byte("raw")},
+	})
+
+	errs, ok := err.(WriteErrors)
+	if !ok || len(errs) != 3 || errs.Count() != 1 {
+		t.Fatalf("expected WriteErrors for the second message; got %v", err)
+	}
+	serr, ok := errs[1].(*SerializationError)
+	if !ok {
+		t.Fatalf("expected a *SerializationError; got %v", errs[1])
+	}
+	if serr.Err != errBadValue || serr.Key || serr.Topic != "A" || serr.Index != 1 {
+		t.Errorf("unexpected serialization error: %+v", serr)
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("expected the message which failed to be encoded to be dropped; got %d messages", len(msgs))
+	}
+	if string(msgs[0].Key) != "A:k" || string(msgs[0].Value) != "1" {
+		t.Errorf("unexpected encoded key and value: %q, %q", msgs[0].Key, msgs[0].Value)
+	}
+	if string(msgs[1].Key) != "raw" || string(msgs[1].Value) != "raw" {
+		t.Errorf("expected messages without decoded fields to be unchanged; got %q, %q", msgs[1].Key, msgs[1].Value)
+	}
+
+	if err := w.WriteMessages(context.Background(), Message{DecodedValue: "oops"}); err == nil {
+		t.Error("expected an error writing a message which fails to be encoded")
+	}
+
+	if errors := w.Stats().Errors; errors != 2 {
+		t.Errorf("expected 2 errors; got %d", errors)
+	}
+
+	// Writers without a topic pass the topic of each message.
+	multi := NewWriter(WriterConfig{
+		Brokers: []string{"localhost:9092"},
+		KeySerializer: SerializerFunc(func(topic string, value interface{}) ([]byte, error) {
+			return []byte(topic + ":" + value.(string)), nil
+		}),
+	})
+	defer multi.Close()
+
+	msgs, err = multi.prepare([]Message{{Topic: "B", DecodedKey: "k"}, {Topic: "C", DecodedKey: "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msgs[0].Key) != "B:k" || string(msgs[1].Key) != "C:k" {
+		t.Errorf("expected the keys to be serialized with the topics of the messages; got %q, %q", msgs[0].Key, msgs[1].Key)
+	}
+}
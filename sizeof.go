@@ -68,6 +68,43 @@ func sizeofInt32Array(a []int32) int32 {
 	return 4 + (4 * int32(len(a)))
 }
 
+func sizeofUnsignedVarInt(u uint64) int32 {
+	s := int32(1)
+	for u&0x7f != u {
+		s++
+		u >>= 7
+	}
+	return s
+}
+
+func sizeofCompactString(s string) int32 {
+	return sizeofUnsignedVarInt(uint64(len(s))+1) + int32(len(s))
+}
+
+func sizeofCompactArray(n int, f func(int) int32) int32 {
+	s := sizeofUnsignedVarInt(uint64(n + 1))
+	for i := 0; i < n; i++ {
+		s += f(i)
+	}
+	return s
+}
+
+func sizeofCompactInt32Array(a []int32) int32 {
+	if a == nil {
+		return sizeofUnsignedVarInt(0)
+	}
+	return sizeofCompactArray(len(a), func(int) int32 { return 4 })
+}
+
+func sizeofUUID(_ uuid) int32 {
+	return 16
+}
+
+// sizeofTaggedFields is the size of an empty set of tagged fields.
+func sizeofTaggedFields() int32 {
+	return 1
+}
+
 func sizeofStringArray(a []string) int32 {
 	return sizeofArray(len(a), func(i int) int32 { return sizeofString(a[i]) })
 }
@@ -3,23 +3,59 @@ package snappy
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"sync"
 
 	"github.com/golang/snappy"
 	"github.com/segmentio/kafka-go"
 )
 
+// bufferPool holds the scratch buffers that blocks are compressed to, or
+// decompressed from when the messages are framed.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
 func init() {
 	kafka.RegisterCompressionCodec(func() kafka.CompressionCodec {
 		return NewCompressionCodec()
 	})
 }
 
-type CompressionCodec struct{}
+// Framing is an enumeration type used to configure the framing of snappy
+// compressed messages.
+type Framing int
+
+const (
+	// Unframed produces raw snappy blocks, which is what the kafka clients
+	// that don't use the JVM commonly do.
+	Unframed Framing = iota
+
+	// Framed produces blocks wrapped in the xerial framing used by the java
+	// client. Older consumers, which expect this framing, are unable to read
+	// unframed messages.
+	//
+	// The xerial framing is specific to kafka and differs from the framing
+	// format defined by the snappy project.
+	Framed
+)
+
+type CompressionCodec struct {
+	// Framing is the framing of the messages compressed by the codec, both
+	// framings are supported when decompressing messages.
+	Framing Framing
+}
 
 const Code = 2
 
 func NewCompressionCodec() CompressionCodec {
-	return CompressionCodec{}
+	return NewCompressionCodecWith(Unframed)
+}
+
+func NewCompressionCodecWith(framing Framing) CompressionCodec {
+	return CompressionCodec{
+		Framing: framing,
+	}
 }
 
 // Code implements the kafka.CompressionCodec interface.
@@ -29,8 +65,17 @@ func (c CompressionCodec) Code() int8 {
 
 // Encode implements the kafka.CompressionCodec interface.
 func (c CompressionCodec) Encode(src []byte) ([]byte, error) {
-	// NOTE : passing a nil dst means snappy will allocate it.
-	return snappy.Encode(nil, src), nil
+	if c.Framing == Framed {
+		return encode(src), nil
+	}
+
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	// snappy only uses dst when it can hold the largest possible output,
+	// otherwise it allocates a new slice, which is kept for the next call.
+	*buf = snappy.Encode((*buf)[:cap(*buf)], src)
+	return append([]byte(nil), *buf...), nil
 }
 
 // Decode implements the kafka.CompressionCodec interface.
@@ -38,31 +83,83 @@ func (c CompressionCodec) Decode(src []byte) ([]byte, error) {
 	return decode(src)
 }
 
-var xerialHeader = []byte{130, 83, 78, 65, 80, 80, 89, 0}
+var (
+	xerialHeader = []byte{130, 83, 78, 65, 80, 80, 89, 0}
+
+	// xerialVersionInfo holds the version and minimum compatible version of
+	// the framing written after the header.
+	xerialVersionInfo = []byte{0, 0, 0, 1, 0, 0, 0, 1}
+
+	errTruncatedChunk = errors.New("snappy: truncated xerial chunk")
+)
+
+// xerialBlockSize is the size of the uncompressed data of each chunk, it
+// matches the default of the java client.
+const xerialBlockSize = 32 * 1024
+
+// encode compresses src in chunks of xerialBlockSize bytes, each of them
+// prefixed by its size, following the xerial header.
+func encode(src []byte) []byte {
+	dst := make([]byte, 0, len(xerialHeader)+len(xerialVersionInfo)+snappy.MaxEncodedLen(len(src))+4)
+	dst = append(dst, xerialHeader...)
+	dst = append(dst, xerialVersionInfo...)
+
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	var size [4]byte
+	var chunk = *buf
+
+	for len(src) != 0 {
+		n := len(src)
+		if n > xerialBlockSize {
+			n = xerialBlockSize
+		}
+
+		chunk = snappy.Encode(chunk[:cap(chunk)], src[:n])
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		dst = append(dst, size[:]...)
+		dst = append(dst, chunk...)
+		src = src[n:]
+	}
+
+	*buf = chunk
+	return dst
+}
 
 // From github.com/eapache/go-xerial-snappy
 func decode(src []byte) ([]byte, error) {
-	if !bytes.Equal(src[:8], xerialHeader) {
+	if len(src) < len(xerialHeader) || !bytes.Equal(src[:8], xerialHeader) {
 		return snappy.Decode(nil, src)
 	}
 
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
 	var (
 		pos   = uint32(16)
 		max   = uint32(len(src))
 		dst   = make([]byte, 0, len(src))
-		chunk []byte
+		chunk = *buf
 		err   error
 	)
 	for pos < max {
+		if max-pos < 4 {
+			return nil, errTruncatedChunk
+		}
 		size := binary.BigEndian.Uint32(src[pos : pos+4])
 		pos += 4
 
-		chunk, err = snappy.Decode(chunk, src[pos:pos+size])
+		if max-pos < size {
+			return nil, errTruncatedChunk
+		}
+		chunk, err = snappy.Decode(chunk[:cap(chunk)], src[pos:pos+size])
 		if err != nil {
 			return nil, err
 		}
 		pos += size
 		dst = append(dst, chunk...)
+		*buf = chunk
 	}
 	return dst, nil
 }
@@ -25,14 +25,20 @@ type DurationStats struct {
 //
 // Since atomic is used to mutate the statistic the value must be 64-bit aligned.
 // See https://golang.org/pkg/sync/atomic/#pkg-note-BUG
-type counter int64
+type counter struct {
+	value  int64
+	metric metric
+}
 
 func (c *counter) ptr() *int64 {
-	return (*int64)(c)
+	return &c.value
 }
 
 func (c *counter) observe(v int64) {
 	atomic.AddInt64(c.ptr(), v)
+	if c.metric != nil {
+		c.metric.observe(v)
+	}
 }
 
 func (c *counter) snapshot() int64 {
@@ -47,14 +53,27 @@ func (c *counter) snapshot() int64 {
 //
 // Since atomic is used to mutate the statistic the value must be 64-bit aligned.
 // See https://golang.org/pkg/sync/atomic/#pkg-note-BUG
-type gauge int64
+type gauge struct {
+	value  int64
+	metric metric
+}
 
 func (g *gauge) ptr() *int64 {
-	return (*int64)(g)
+	return &g.value
 }
 
 func (g *gauge) observe(v int64) {
 	atomic.StoreInt64(g.ptr(), v)
+	if g.metric != nil {
+		g.metric.observe(v)
+	}
+}
+
+func (g *gauge) add(v int64) {
+	n := atomic.AddInt64(g.ptr(), v)
+	if g.metric != nil {
+		g.metric.observe(n)
+	}
 }
 
 func (g *gauge) snapshot() int64 {
@@ -134,10 +153,11 @@ func (m *maximum) snapshot() int64 {
 }
 
 type summary struct {
-	min   minimum
-	max   maximum
-	sum   counter
-	count counter
+	min    minimum
+	max    maximum
+	sum    counter
+	count  counter
+	metric metric
 }
 
 func makeSummary() summary {
@@ -152,6 +172,9 @@ func (s *summary) observe(v int64) {
 	s.max.observe(v)
 	s.sum.observe(v)
 	s.count.observe(1)
+	if s.metric != nil {
+		s.metric.observe(v)
+	}
 }
 
 func (s *summary) observeDuration(v time.Duration) {
@@ -139,3 +139,189 @@ func (t *syncGroupResponseV0) readFrom(r *bufio.Reader, sz int) (remain int, err
 	}
 	return
 }
+
+// syncGroupRequestV3 adds the group instance ID used by static members.
+type syncGroupRequestV3 struct {
+	// GroupID holds the unique group identifier
+	GroupID string
+
+	// GenerationID holds the generation of the group.
+	GenerationID int32
+
+	// MemberID assigned by the group coordinator
+	MemberID string
+
+	// GroupInstanceID holds the unique identifier of the consumer instance
+	// provided by end user, or the zero string for dynamic members.
+	GroupInstanceID string
+
+	GroupAssignments []syncGroupRequestGroupAssignmentV0
+}
+
+func (t syncGroupRequestV3) size() int32 {
+	return sizeofString(t.GroupID) +
+		sizeofInt32(t.GenerationID) +
+		sizeofString(t.MemberID) +
+		sizeofString(t.GroupInstanceID) +
+		sizeofArray(len(t.GroupAssignments), func(i int) int32 { return t.GroupAssignments[i].size() })
+}
+
+func (t syncGroupRequestV3) writeTo(w *bufio.Writer) {
+	writeString(w, t.GroupID)
+	writeInt32(w, t.GenerationID)
+	writeString(w, t.MemberID)
+	writeNullableString(w, t.GroupInstanceID)
+	writeArray(w, len(t.GroupAssignments), func(i int) { t.GroupAssignments[i].writeTo(w) })
+}
+
+type syncGroupResponseV3 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// ErrorCode holds response error code
+	ErrorCode int16
+
+	// MemberAssignments holds client encoded assignments
+	//
+	// See consumer groups section of https://cwiki.apache.org/confluence/display/KAFKA/A+Guide+To+The+Kafka+Protocol
+	MemberAssignments []byte
+}
+
+func (t syncGroupResponseV3) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofBytes(t.MemberAssignments)
+}
+
+func (t syncGroupResponseV3) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeBytes(w, t.MemberAssignments)
+}
+
+func (t *syncGroupResponseV3) readFrom(r *bufio.Reader, sz int) (remain int, err error) {
+	if remain, err = readInt32(r, sz, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readBytes(r, remain, &t.MemberAssignments); err != nil {
+		return
+	}
+	return
+}
+
+// makeSyncGroupAssignments encodes the assignments computed by the leader of a
+// group.
+func makeSyncGroupAssignments(memberAssignments GroupMemberAssignments) []syncGroupRequestGroupAssignmentV0 {
+	assignments := make([]syncGroupRequestGroupAssignmentV0, 0, len(memberAssignments))
+
+	for memberID, topics := range memberAssignments {
+		topics32 := make(map[string][]int32)
+		for topic, partitions := range topics {
+			partitions32 := make([]int32, len(partitions))
+			for i := range partitions {
+				partitions32[i] = int32(partitions[i])
+			}
+			topics32[topic] = partitions32
+		}
+		assignments = append(assignments, syncGroupRequestGroupAssignmentV0{
+			MemberID: memberID,
+			MemberAssignments: groupAssignment{
+				Version: 1,
+				Topics:  topics32,
+			}.bytes(),
+		})
+	}
+
+	return assignments
+}
+
+// SyncGroupRequest is the request passed to Conn.SyncGroup.
+type SyncGroupRequest struct {
+	// GroupID is the ID of the consumer group.
+	GroupID string
+
+	// GenerationID and MemberID are the values of the JoinGroup response.
+	GenerationID int
+	MemberID     string
+
+	// GroupInstanceID must be set to the value passed to JoinGroup by static
+	// members.
+	GroupInstanceID string
+
+	// Assignments holds the partitions assigned to each member of the group,
+	// only the leader of the group sets them.
+	Assignments GroupMemberAssignments
+}
+
+// SyncGroupResponse is the response returned by Conn.SyncGroup.
+type SyncGroupResponse struct {
+	// Assignments holds the partitions assigned to the member by topic.
+	Assignments map[string][]int
+
+	// UserData is the data that the leader attached to the assignments.
+	UserData []byte
+}
+
+// SyncGroup completes the handshake started by JoinGroup, it sends the
+// assignments computed by the leader of the group, and returns those of the
+// member.
+//
+// The connection must be established to the coordinator of the group, other
+// brokers respond with NotCoordinatorForGroup.
+func (c *Conn) SyncGroup(request SyncGroupRequest) (SyncGroupResponse, error) {
+	var assignments []syncGroupRequestGroupAssignmentV0
+	if request.Assignments != nil {
+		assignments = makeSyncGroupAssignments(request.Assignments)
+	}
+
+	var memberAssignments []byte
+
+	if request.GroupInstanceID == "" {
+		response, err := c.syncGroups(syncGroupRequestV0{
+			GroupID:          request.GroupID,
+			GenerationID:     int32(request.GenerationID),
+			MemberID:         request.MemberID,
+			GroupAssignments: assignments,
+		})
+		if err != nil {
+			return SyncGroupResponse{}, err
+		}
+		memberAssignments = response.MemberAssignments
+	} else {
+		response, err := c.syncGroupsV3(syncGroupRequestV3{
+			GroupID:          request.GroupID,
+			GenerationID:     int32(request.GenerationID),
+			MemberID:         request.MemberID,
+			GroupInstanceID:  request.GroupInstanceID,
+			GroupAssignments: assignments,
+		})
+		if err != nil {
+			return SyncGroupResponse{}, err
+		}
+		memberAssignments = response.MemberAssignments
+	}
+
+	assignment := groupAssignment{}
+	reader := bufio.NewReader(bytes.NewReader(memberAssignments))
+	if _, err := (&assignment).readFrom(reader, len(memberAssignments)); err != nil {
+		return SyncGroupResponse{}, err
+	}
+
+	res := SyncGroupResponse{
+		Assignments: make(map[string][]int, len(assignment.Topics)),
+		UserData:    assignment.UserData,
+	}
+	for topic, partitions32 := range assignment.Topics {
+		partitions := make([]int, len(partitions32))
+		for i := range partitions32 {
+			partitions[i] = int(partitions32[i])
+		}
+		res.Assignments[topic] = partitions
+	}
+	return res, nil
+}
@@ -82,6 +82,34 @@ func TestSyncGroupResponseV0(t *testing.T) {
 	}
 }
 
+func TestSyncGroupResponseV3(t *testing.T) {
+	item := syncGroupResponseV3{
+		ThrottleTimeMS:    1,
+		ErrorCode:         2,
+		MemberAssignments: []byte(`blah`),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	var found syncGroupResponseV3
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if remain != 0 {
+		t.Errorf("expected 0 remain, got %v", remain)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Error("expected item and found to be the same")
+		t.FailNow()
+	}
+}
+
 func BenchmarkSyncGroupResponseV0(t *testing.B) {
 	item := syncGroupResponseV0{
 		ErrorCode:         2,
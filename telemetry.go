@@ -0,0 +1,256 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/base64"
+	"time"
+)
+
+// uuid is the representation of the UUIDs of the kafka protocol.
+type uuid [16]byte
+
+// String returns u in the format used by kafka, which is the URL-safe base64
+// encoding of the UUID without padding.
+func (u uuid) String() string {
+	return base64.RawURLEncoding.EncodeToString(u[:])
+}
+
+// TelemetrySubscription is the subscription of a client to push metrics to
+// the brokers (KIP-714), as requested by the brokers.
+type TelemetrySubscription struct {
+	// ClientInstanceID is the ID assigned by the broker to the client, in the
+	// format used by kafka to report it in the broker-side metrics.
+	ClientInstanceID string
+
+	// SubscriptionID identifies the subscription, it changes each time the
+	// subscription is updated on the brokers.
+	SubscriptionID int
+
+	// AcceptedCompressionTypes holds the codes of the compression codecs
+	// accepted for the metrics pushed to the brokers, in order of preference
+	// (see CompressionCodec.Code).
+	AcceptedCompressionTypes []int8
+
+	// PushInterval is the interval at which the client is expected to push
+	// metrics.
+	PushInterval time.Duration
+
+	// TelemetryMaxBytes is the maximum size of the metrics pushed by the
+	// client.
+	TelemetryMaxBytes int
+
+	// DeltaTemporality is true when the brokers expect the metrics to be
+	// pushed as deltas, and false for cumulative metrics.
+	DeltaTemporality bool
+
+	// RequestedMetrics holds the prefixes of the names of the metrics
+	// requested by the brokers. It is empty when no metrics are requested,
+	// and holds a single empty string when all metrics are requested.
+	RequestedMetrics []string
+}
+
+// See http://kafka.apache.org/protocol.html#The_Messages_GetTelemetrySubscriptions
+type getTelemetrySubscriptionsRequestV0 struct {
+	// ClientInstanceID is zero when the client has not been assigned an ID
+	// yet, which asks the broker to generate one.
+	ClientInstanceID uuid
+}
+
+func (t getTelemetrySubscriptionsRequestV0) size() int32 {
+	return sizeofUUID(t.ClientInstanceID) +
+		sizeofTaggedFields()
+}
+
+func (t getTelemetrySubscriptionsRequestV0) writeTo(w *bufio.Writer) {
+	writeUUID(w, t.ClientInstanceID)
+	writeTaggedFields(w)
+}
+
+type getTelemetrySubscriptionsResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	ErrorCode                int16
+	ClientInstanceID         uuid
+	SubscriptionID           int32
+	AcceptedCompressionTypes []int8
+	PushIntervalMS           int32
+	TelemetryMaxBytes        int32
+	DeltaTemporality         bool
+	RequestedMetrics         []string
+}
+
+func (t getTelemetrySubscriptionsResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofInt16(t.ErrorCode) +
+		sizeofUUID(t.ClientInstanceID) +
+		sizeofInt32(t.SubscriptionID) +
+		sizeofCompactArray(len(t.AcceptedCompressionTypes), func(int) int32 { return 1 }) +
+		sizeofInt32(t.PushIntervalMS) +
+		sizeofInt32(t.TelemetryMaxBytes) +
+		sizeofBool(t.DeltaTemporality) +
+		sizeofCompactArray(len(t.RequestedMetrics), func(i int) int32 { return sizeofCompactString(t.RequestedMetrics[i]) }) +
+		sizeofTaggedFields()
+}
+
+func (t getTelemetrySubscriptionsResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeInt16(w, t.ErrorCode)
+	writeUUID(w, t.ClientInstanceID)
+	writeInt32(w, t.SubscriptionID)
+	writeCompactArray(w, len(t.AcceptedCompressionTypes), func(i int) { writeInt8(w, t.AcceptedCompressionTypes[i]) })
+	writeInt32(w, t.PushIntervalMS)
+	writeInt32(w, t.TelemetryMaxBytes)
+	writeBool(w, t.DeltaTemporality)
+	writeCompactArray(w, len(t.RequestedMetrics), func(i int) { writeCompactString(w, t.RequestedMetrics[i]) })
+	writeTaggedFields(w)
+}
+
+func (t *getTelemetrySubscriptionsResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	if remain, err = readUUID(r, remain, &t.ClientInstanceID); err != nil {
+		return
+	}
+	if remain, err = readInt32(r, remain, &t.SubscriptionID); err != nil {
+		return
+	}
+
+	readCompressionType := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item int8
+		if fnRemain, fnErr = readInt8(r, size, &item); fnErr != nil {
+			return
+		}
+		t.AcceptedCompressionTypes = append(t.AcceptedCompressionTypes, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, readCompressionType); err != nil {
+		return
+	}
+
+	if remain, err = readInt32(r, remain, &t.PushIntervalMS); err != nil {
+		return
+	}
+	if remain, err = readInt32(r, remain, &t.TelemetryMaxBytes); err != nil {
+		return
+	}
+	if remain, err = readBool(r, remain, &t.DeltaTemporality); err != nil {
+		return
+	}
+
+	readRequestedMetric := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item string
+		if fnRemain, fnErr = readCompactString(r, size, &item); fnErr != nil {
+			return
+		}
+		t.RequestedMetrics = append(t.RequestedMetrics, item)
+		return
+	}
+	if remain, err = readCompactArrayWith(r, remain, readRequestedMetric); err != nil {
+		return
+	}
+
+	if remain, err = discardTaggedFields(r, remain); err != nil {
+		return
+	}
+	return
+}
+
+// getTelemetrySubscriptions requests the telemetry subscription of the
+// client.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_GetTelemetrySubscriptions
+func (c *Conn) getTelemetrySubscriptions(request getTelemetrySubscriptionsRequestV0) (getTelemetrySubscriptionsResponseV0, error) {
+	var response getTelemetrySubscriptionsResponseV0
+
+	if _, err := c.negotiatedVersion(getTelemetrySubscriptionsRequest); err != nil {
+		return response, err
+	}
+
+	err := c.readOperation(
+		getTelemetrySubscriptionsRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeFlexibleRequest(getTelemetrySubscriptionsRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				// tagged fields of the response header
+				if remain, err = discardTaggedFields(&c.rbuf, size); err != nil {
+					return
+				}
+				return (&response).readFrom(&c.rbuf, remain)
+			}())
+		},
+	)
+	if err != nil {
+		return getTelemetrySubscriptionsResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// TelemetrySubscriptions requests the subscription of the client to push
+// metrics to the brokers (KIP-714). The first call has the broker assign a
+// client instance ID to the connection, which is sent again by the following
+// calls to refresh the subscription, for example when it has expired after
+// the push interval.
+//
+// Only the subscription is negotiated, pushing the metrics is left to the
+// program. The method returns UnsupportedVersion if the broker does not
+// support client telemetry, which requires kafka 3.7 and above with a
+// metrics reporter configured on the brokers.
+func (c *Conn) TelemetrySubscriptions() (TelemetrySubscription, error) {
+	c.mutex.Lock()
+	id := c.clientInstanceID
+	c.mutex.Unlock()
+
+	response, err := c.getTelemetrySubscriptions(getTelemetrySubscriptionsRequestV0{
+		ClientInstanceID: id,
+	})
+	if err != nil {
+		return TelemetrySubscription{}, err
+	}
+	if response.ErrorCode != 0 {
+		return TelemetrySubscription{}, Error(response.ErrorCode)
+	}
+
+	c.mutex.Lock()
+	c.clientInstanceID = response.ClientInstanceID
+	c.mutex.Unlock()
+
+	return TelemetrySubscription{
+		ClientInstanceID:         response.ClientInstanceID.String(),
+		SubscriptionID:           int(response.SubscriptionID),
+		AcceptedCompressionTypes: response.AcceptedCompressionTypes,
+		PushInterval:             duration(response.PushIntervalMS),
+		TelemetryMaxBytes:        int(response.TelemetryMaxBytes),
+		DeltaTemporality:         response.DeltaTemporality,
+		RequestedMetrics:         response.RequestedMetrics,
+	}, nil
+}
+
+// ClientInstanceID returns the ID assigned by the broker to the client for
+// telemetry (KIP-714), which the brokers use to identify the metrics pushed
+// by the client. The ID is requested by the first call to the method or to
+// TelemetrySubscriptions, and cached by the connection.
+func (c *Conn) ClientInstanceID() (string, error) {
+	c.mutex.Lock()
+	id := c.clientInstanceID
+	c.mutex.Unlock()
+
+	if id != (uuid{}) {
+		return id.String(), nil
+	}
+
+	s, err := c.TelemetrySubscriptions()
+	if err != nil {
+		return "", err
+	}
+	return s.ClientInstanceID, nil
+}
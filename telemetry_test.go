@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestGetTelemetrySubscriptionsResponseV0(t *testing.T) {
+	item := getTelemetrySubscriptionsResponseV0{
+		ThrottleTimeMS:           1,
+		ClientInstanceID:         uuid{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SubscriptionID:           2,
+		AcceptedCompressionTypes: []int8{4, 1},
+		PushIntervalMS:           30000,
+		TelemetryMaxBytes:        1048576,
+		DeltaTemporality:         true,
+		RequestedMetrics:         []string{"org.apache.kafka.client.producer"},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found getTelemetrySubscriptionsResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestUUIDString(t *testing.T) {
+	u := uuid{0xd7, 0xc3, 0x2b, 0xf6, 0x4c, 0x8a, 0x4c, 0x5e, 0x8b, 0x2f, 0x1e, 0x3c, 0x6c, 0x9d, 0x71, 0x0a}
+	if s := u.String(); s != "18Mr9kyKTF6LLx48bJ1xCg" {
+		t.Errorf("expected 18Mr9kyKTF6LLx48bJ1xCg, got %s", s)
+	}
+}
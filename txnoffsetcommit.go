@@ -0,0 +1,269 @@
+package kafka
+
+import (
+	"bufio"
+	"sort"
+	"time"
+)
+
+// See http://kafka.apache.org/protocol.html#The_Messages_TxnOffsetCommit
+type txnOffsetCommitRequestV0 struct {
+	TransactionalID string
+	GroupID         string
+	ProducerID      int64
+	ProducerEpoch   int16
+
+	// Topics holds the offsets to commit.
+	Topics []txnOffsetCommitRequestV0Topic
+}
+
+func (t txnOffsetCommitRequestV0) size() int32 {
+	return sizeofString(t.TransactionalID) +
+		sizeofString(t.GroupID) +
+		sizeofInt64(t.ProducerID) +
+		sizeofInt16(t.ProducerEpoch) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t txnOffsetCommitRequestV0) writeTo(w *bufio.Writer) {
+	writeString(w, t.TransactionalID)
+	writeString(w, t.GroupID)
+	writeInt64(w, t.ProducerID)
+	writeInt16(w, t.ProducerEpoch)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+type txnOffsetCommitRequestV0Topic struct {
+	Name       string
+	Partitions []txnOffsetCommitRequestV0Partition
+}
+
+func (t txnOffsetCommitRequestV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t txnOffsetCommitRequestV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+type txnOffsetCommitRequestV0Partition struct {
+	PartitionIndex  int32
+	CommittedOffset int64
+
+	// CommittedMetadata is written as a null string when empty.
+	CommittedMetadata string
+}
+
+func (t txnOffsetCommitRequestV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt64(t.CommittedOffset) +
+		sizeofString(t.CommittedMetadata)
+}
+
+func (t txnOffsetCommitRequestV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt64(w, t.CommittedOffset)
+	writeNullableString(w, t.CommittedMetadata)
+}
+
+type txnOffsetCommitResponseV0Partition struct {
+	PartitionIndex int32
+	ErrorCode      int16
+}
+
+func (t txnOffsetCommitResponseV0Partition) size() int32 {
+	return sizeofInt32(t.PartitionIndex) +
+		sizeofInt16(t.ErrorCode)
+}
+
+func (t txnOffsetCommitResponseV0Partition) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.PartitionIndex)
+	writeInt16(w, t.ErrorCode)
+}
+
+func (t *txnOffsetCommitResponseV0Partition) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.PartitionIndex); err != nil {
+		return
+	}
+	if remain, err = readInt16(r, remain, &t.ErrorCode); err != nil {
+		return
+	}
+	return
+}
+
+type txnOffsetCommitResponseV0Topic struct {
+	Name       string
+	Partitions []txnOffsetCommitResponseV0Partition
+}
+
+func (t txnOffsetCommitResponseV0Topic) size() int32 {
+	return sizeofString(t.Name) +
+		sizeofArray(len(t.Partitions), func(i int) int32 { return t.Partitions[i].size() })
+}
+
+func (t txnOffsetCommitResponseV0Topic) writeTo(w *bufio.Writer) {
+	writeString(w, t.Name)
+	writeArray(w, len(t.Partitions), func(i int) { t.Partitions[i].writeTo(w) })
+}
+
+func (t *txnOffsetCommitResponseV0Topic) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readString(r, size, &t.Name); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item txnOffsetCommitResponseV0Partition
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Partitions = append(t.Partitions, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+type txnOffsetCommitResponseV0 struct {
+	// ThrottleTimeMS holds the duration in milliseconds for which the request
+	// was throttled due to quota violation (Zero if the request did not violate
+	// any quota)
+	ThrottleTimeMS int32
+
+	// Topics holds the results of the partitions of the request.
+	Topics []txnOffsetCommitResponseV0Topic
+}
+
+func (t txnOffsetCommitResponseV0) size() int32 {
+	return sizeofInt32(t.ThrottleTimeMS) +
+		sizeofArray(len(t.Topics), func(i int) int32 { return t.Topics[i].size() })
+}
+
+func (t txnOffsetCommitResponseV0) writeTo(w *bufio.Writer) {
+	writeInt32(w, t.ThrottleTimeMS)
+	writeArray(w, len(t.Topics), func(i int) { t.Topics[i].writeTo(w) })
+}
+
+func (t *txnOffsetCommitResponseV0) readFrom(r *bufio.Reader, size int) (remain int, err error) {
+	if remain, err = readInt32(r, size, &t.ThrottleTimeMS); err != nil {
+		return
+	}
+
+	fn := func(r *bufio.Reader, size int) (fnRemain int, fnErr error) {
+		var item txnOffsetCommitResponseV0Topic
+		if fnRemain, fnErr = (&item).readFrom(r, size); fnErr != nil {
+			return
+		}
+		t.Topics = append(t.Topics, item)
+		return
+	}
+	if remain, err = readArrayWith(r, remain, fn); err != nil {
+		return
+	}
+
+	return
+}
+
+// txnOffsetCommit commits the offsets of the request as part of the ongoing
+// transaction of the producer.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_TxnOffsetCommit
+func (c *Conn) txnOffsetCommit(request txnOffsetCommitRequestV0) (txnOffsetCommitResponseV0, error) {
+	var response txnOffsetCommitResponseV0
+
+	if _, err := c.negotiatedVersion(txnOffsetCommitRequest); err != nil {
+		return response, err
+	}
+
+	err := c.writeOperation(
+		txnOffsetCommitRequest,
+		func(deadline time.Time, id int32) error {
+			return c.writeRequest(txnOffsetCommitRequest, v0, id, request)
+		},
+		func(deadline time.Time, size int) error {
+			return expectZeroSize(func() (remain int, err error) {
+				return (&response).readFrom(&c.rbuf, size)
+			}())
+		},
+	)
+	if err != nil {
+		return txnOffsetCommitResponseV0{}, err
+	}
+
+	return response, nil
+}
+
+// TxnOffsetCommit commits offsets, indexed by topic and partition, for the
+// consumer group groupID as part of the ongoing transaction of producer. Like
+// the offsets passed to Reader.CommitOffsets, they are the offsets of the next
+// messages to be consumed.
+//
+// The group must have been added to the transaction with Conn.AddOffsetsToTxn
+// first. The offsets only become the committed offsets of the group when the
+// transaction is committed with Conn.EndTxn, and are discarded if it is
+// aborted. When the coordinator rejects the commit of some partitions the
+// method returns an *OffsetCommitError holding the error code of each of them.
+//
+// The request must be sent to the coordinator of the consumer group (see
+// Dialer.LookupCoordinator), and is only supported by kafka 0.11 and above.
+func (c *Conn) TxnOffsetCommit(producer TransactionalProducer, groupID string, offsets map[string]map[int]int64) error {
+	topics := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	request := txnOffsetCommitRequestV0{
+		TransactionalID: producer.TransactionalID,
+		GroupID:         groupID,
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+	}
+	for _, topic := range topics {
+		partitions := make([]int, 0, len(offsets[topic]))
+		for p := range offsets[topic] {
+			partitions = append(partitions, p)
+		}
+		sort.Ints(partitions)
+
+		requestTopic := txnOffsetCommitRequestV0Topic{Name: topic}
+		for _, p := range partitions {
+			requestTopic.Partitions = append(requestTopic.Partitions, txnOffsetCommitRequestV0Partition{
+				PartitionIndex:  int32(p),
+				CommittedOffset: offsets[topic][p],
+			})
+		}
+		request.Topics = append(request.Topics, requestTopic)
+	}
+
+	response, err := c.txnOffsetCommit(request)
+	if err != nil {
+		return err
+	}
+
+	var commitErr *OffsetCommitError
+	for _, t := range response.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode == 0 {
+				continue
+			}
+			if commitErr == nil {
+				commitErr = &OffsetCommitError{Errors: map[string]map[int]Error{}}
+			}
+			partitions, ok := commitErr.Errors[t.Name]
+			if !ok {
+				partitions = map[int]Error{}
+				commitErr.Errors[t.Name] = partitions
+			}
+			partitions[int(p.PartitionIndex)] = Error(p.ErrorCode)
+		}
+	}
+	if commitErr != nil {
+		return commitErr
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTxnOffsetCommitResponseV0(t *testing.T) {
+	item := txnOffsetCommitResponseV0{
+		ThrottleTimeMS: 1,
+		Topics: []txnOffsetCommitResponseV0Topic{
+			{
+				Name: "a",
+				Partitions: []txnOffsetCommitResponseV0Partition{
+					{PartitionIndex: 0},
+					{PartitionIndex: 1, ErrorCode: int16(OffsetMetadataTooLarge)},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	item.writeTo(w)
+	w.Flush()
+
+	if size := item.size(); int(size) != buf.Len() {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	var found txnOffsetCommitResponseV0
+	remain, err := (&found).readFrom(bufio.NewReader(buf), buf.Len())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remain != 0 {
+		t.Fatalf("expected 0 remain, got %v", remain)
+	}
+	if !reflect.DeepEqual(item, found) {
+		t.Fatal("expected item and found to be the same")
+	}
+}
+
+func TestTxnOffsetCommitRequestV0(t *testing.T) {
+	request := txnOffsetCommitRequestV0{
+		TransactionalID: "txn",
+		GroupID:         "group",
+		ProducerID:      42,
+		ProducerEpoch:   3,
+		Topics: []txnOffsetCommitRequestV0Topic{
+			{
+				Name: "a",
+				Partitions: []txnOffsetCommitRequestV0Partition{
+					{PartitionIndex: 0, CommittedOffset: 10},
+					{PartitionIndex: 1, CommittedOffset: 20, CommittedMetadata: "meta"},
+				},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := bufio.NewWriter(buf)
+	request.writeTo(w)
+	w.Flush()
+
+	if size := request.size(); int(size) != buf.Len() {
+		t.Errorf("expected size %d, got %d", buf.Len(), size)
+	}
+}
@@ -65,11 +65,29 @@ func varIntLen(i int64) (l int) {
 	return l
 }
 
+// varBytesLen returns the length written before the nullable bytes b in the
+// records of message format 2, which is -1 for null.
+func varBytesLen(b []byte) int64 {
+	if b == nil {
+		return -1
+	}
+	return int64(len(b))
+}
+
 func writeString(w *bufio.Writer, s string) {
 	writeInt16(w, int16(len(s)))
 	w.WriteString(s)
 }
 
+// writeNullableString writes s, or a null string if s is empty.
+func writeNullableString(w *bufio.Writer, s string) {
+	if s == "" {
+		writeInt16(w, -1)
+		return
+	}
+	writeString(w, s)
+}
+
 func writeBytes(w *bufio.Writer, b []byte) {
 	n := len(b)
 	if b == nil {
@@ -91,6 +109,55 @@ func writeArrayLen(w *bufio.Writer, n int) {
 	writeInt32(w, int32(n))
 }
 
+// writeUnsignedVarInt writes u in the unsigned varint encoding of the flexible
+// versions of the requests (KIP-482).
+func writeUnsignedVarInt(w *bufio.Writer, u uint64) {
+	for u&0x7f != u {
+		w.WriteByte(byte(u&0x7f | 0x80))
+		u >>= 7
+	}
+	w.WriteByte(byte(u))
+}
+
+// writeCompactString writes s with its length encoded as an unsigned varint,
+// plus one to leave zero for null strings.
+func writeCompactString(w *bufio.Writer, s string) {
+	writeUnsignedVarInt(w, uint64(len(s))+1)
+	w.WriteString(s)
+}
+
+// writeCompactArrayLen writes the length of a compact array, or of a null
+// array when n is negative.
+func writeCompactArrayLen(w *bufio.Writer, n int) {
+	writeUnsignedVarInt(w, uint64(n+1))
+}
+
+func writeCompactArray(w *bufio.Writer, n int, f func(int)) {
+	writeCompactArrayLen(w, n)
+	for i := 0; i < n; i++ {
+		f(i)
+	}
+}
+
+// writeCompactInt32Array writes a, or a null array if a is nil.
+func writeCompactInt32Array(w *bufio.Writer, a []int32) {
+	if a == nil {
+		writeCompactArrayLen(w, -1)
+		return
+	}
+	writeCompactArray(w, len(a), func(i int) { writeInt32(w, a[i]) })
+}
+
+func writeUUID(w *bufio.Writer, u uuid) {
+	w.Write(u[:])
+}
+
+// writeTaggedFields writes the empty set of tagged fields which ends the
+// structures of the flexible versions of the requests.
+func writeTaggedFields(w *bufio.Writer) {
+	writeUnsignedVarInt(w, 0)
+}
+
 func writeArray(w *bufio.Writer, n int, f func(int)) {
 	writeArrayLen(w, n)
 	for i := 0; i != n; i++ {
@@ -169,7 +236,7 @@ func writeFetchRequestV2(w *bufio.Writer, correlationID int32, clientID, topic s
 	return w.Flush()
 }
 
-func writeFetchRequestV5(w *bufio.Writer, correlationID int32, clientID, topic string, partition int32, offset int64, minBytes, maxBytes int, maxWait time.Duration, isolationLevel int8) error {
+func writeFetchRequestV5(w *bufio.Writer, correlationID int32, clientID, topic string, partition int32, offset int64, minBytes, maxBytes, partitionMaxBytes int, maxWait time.Duration, isolationLevel int8) error {
 	h := requestHeader{
 		ApiKey:        int16(fetchRequest),
 		ApiVersion:    int16(v5),
@@ -206,7 +273,113 @@ func writeFetchRequestV5(w *bufio.Writer, correlationID int32, clientID, topic s
 	writeInt32(w, partition)
 	writeInt64(w, offset)
 	writeInt64(w, int64(0)) // log start offset only used when is sent by follower
+	writeInt32(w, int32(partitionMaxBytes))
+
+	return w.Flush()
+}
+
+func writeFetchRequestV9(w *bufio.Writer, correlationID int32, clientID, topic string, partition int32, leaderEpoch int32, offset int64, minBytes, maxBytes, partitionMaxBytes int, maxWait time.Duration, isolationLevel int8, session fetchSessionRequest) error {
+	h := requestHeader{
+		ApiKey:        int16(fetchRequest),
+		ApiVersion:    int16(v9),
+		CorrelationID: correlationID,
+		ClientID:      clientID,
+	}
+	h.Size = (h.size() - 4) +
+		4 + // replica ID
+		4 + // max wait time
+		4 + // min bytes
+		4 + // max bytes
+		1 + // isolation level
+		4 + // session ID
+		4 + // session epoch
+		4 + // topic array length
+		session.sizeofTopics(topic) +
+		4 // forgotten topics array length
+
+	h.writeTo(w)
+	writeInt32(w, -1) // replica ID
+	writeInt32(w, milliseconds(maxWait))
+	writeInt32(w, int32(minBytes))
+	writeInt32(w, int32(maxBytes))
+	writeInt8(w, isolationLevel) // isolation level 0 - read uncommitted
+	writeInt32(w, session.id)    // session ID 0 - no session, or a new one
+	writeInt32(w, session.epoch) // session epoch -1 - full fetch request without session
+
+	// topic array, empty when the partition is omitted from the session
+	if session.omitPartition {
+		writeArrayLen(w, 0)
+	} else {
+		writeArrayLen(w, 1)
+		writeString(w, topic)
+
+		// partition array
+		writeArrayLen(w, 1)
+		writeInt32(w, partition)
+		writeInt32(w, leaderEpoch) // -1 disables the leader epoch validation
+		writeInt64(w, offset)
+		writeInt64(w, int64(0)) // log start offset only used when is sent by follower
+		writeInt32(w, int32(partitionMaxBytes))
+	}
+
+	// forgotten topics array
+	writeArrayLen(w, 0)
+
+	return w.Flush()
+}
+
+// writeFetchRequestV11 writes a fetch request v11, which differs from v9 by the
+// rack ID of the consumer following the forgotten topics. The partition leader
+// uses it to designate the closest replica to fetch from.
+func writeFetchRequestV11(w *bufio.Writer, correlationID int32, clientID, topic string, partition int32, leaderEpoch int32, offset int64, minBytes, maxBytes, partitionMaxBytes int, maxWait time.Duration, isolationLevel int8, session fetchSessionRequest, rackID string) error {
+	h := requestHeader{
+		ApiKey:        int16(fetchRequest),
+		ApiVersion:    int16(v11),
+		CorrelationID: correlationID,
+		ClientID:      clientID,
+	}
+	h.Size = (h.size() - 4) +
+		4 + // replica ID
+		4 + // max wait time
+		4 + // min bytes
+		4 + // max bytes
+		1 + // isolation level
+		4 + // session ID
+		4 + // session epoch
+		4 + // topic array length
+		session.sizeofTopics(topic) +
+		4 + // forgotten topics array length
+		sizeofString(rackID)
+
+	h.writeTo(w)
+	writeInt32(w, -1) // replica ID
+	writeInt32(w, milliseconds(maxWait))
+	writeInt32(w, int32(minBytes))
 	writeInt32(w, int32(maxBytes))
+	writeInt8(w, isolationLevel) // isolation level 0 - read uncommitted
+	writeInt32(w, session.id)    // session ID 0 - no session, or a new one
+	writeInt32(w, session.epoch) // session epoch -1 - full fetch request without session
+
+	// topic array, empty when the partition is omitted from the session
+	if session.omitPartition {
+		writeArrayLen(w, 0)
+	} else {
+		writeArrayLen(w, 1)
+		writeString(w, topic)
+
+		// partition array
+		writeArrayLen(w, 1)
+		writeInt32(w, partition)
+		writeInt32(w, leaderEpoch) // -1 disables the leader epoch validation
+		writeInt64(w, offset)
+		writeInt64(w, int64(0)) // log start offset only used when is sent by follower
+		writeInt32(w, int32(partitionMaxBytes))
+	}
+
+	// forgotten topics array
+	writeArrayLen(w, 0)
+
+	writeString(w, rackID) // empty when the consumer has no rack
 
 	return w.Flush()
 }
@@ -245,7 +418,9 @@ func writeProduceRequestV2(w *bufio.Writer, codec CompressionCodec, correlationI
 
 	attributes := int8(CompressionNoneCode)
 	if codec != nil {
-		if msgs, err = compress(codec, msgs...); err != nil {
+		b := acquireEncodeBuffer(0)
+		defer releaseEncodeBuffer(b)
+		if msgs, err = compress(b, codec, msgs...); err != nil {
 			return err
 		}
 		attributes = codec.Code()
@@ -287,22 +462,21 @@ func writeProduceRequestV2(w *bufio.Writer, codec CompressionCodec, correlationI
 	return w.Flush()
 }
 
-func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationID int32, clientID, topic string, partition int32, timeout time.Duration, requiredAcks int16, msgs ...Message) (err error) {
+func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationID int32, clientID, topic string, partition int32, timeout time.Duration, requiredAcks int16, producer *batchProducer, msgs ...Message) (err error) {
 
 	var size int32
 	var compressed []byte
 	var attributes int16
 	if codec != nil {
 		attributes = int16(codec.Code())
-		recordBuf := &bytes.Buffer{}
-		recordBuf.Grow(int(recordBatchSize(msgs...)))
-		compressedWriter := bufio.NewWriter(recordBuf)
+		b := acquireEncodeBuffer(int(recordBatchSize(msgs...)))
+		defer releaseEncodeBuffer(b)
 		for i, msg := range msgs {
-			writeRecord(compressedWriter, 0, msgs[0].Time, int64(i), msg)
+			writeRecord(b.w, 0, msgs[0].Time, int64(i), msg)
 		}
-		compressedWriter.Flush()
+		b.w.Flush()
 
-		compressed, err = codec.Encode(recordBuf.Bytes())
+		compressed, err = codec.Encode(b.buf.Bytes())
 		if err != nil {
 			return
 		}
@@ -312,6 +486,12 @@ func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationI
 		size = recordBatchSize(msgs...)
 	}
 
+	var transactionalID string
+	if producer != nil && producer.transactionalID != "" {
+		transactionalID = producer.transactionalID
+		attributes |= int16(transactional) << 4
+	}
+
 	h := requestHeader{
 		ApiKey:        int16(produceRequest),
 		ApiVersion:    int16(v3),
@@ -319,7 +499,7 @@ func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationI
 		ClientID:      clientID,
 	}
 	h.Size = (h.size() - 4) +
-		2 + // transactional_id
+		sizeofString(transactionalID) + // transactional_id
 		2 + // required acks
 		4 + // timeout
 		4 + // topic array length
@@ -330,7 +510,7 @@ func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationI
 		size
 
 	h.writeTo(w)
-	writeInt16(w, -1)           // null transactional_id
+	writeNullableString(w, transactionalID)
 	writeInt16(w, requiredAcks) // required acks
 	writeInt32(w, milliseconds(timeout))
 
@@ -344,11 +524,11 @@ func writeProduceRequestV3(w *bufio.Writer, codec CompressionCodec, correlationI
 
 	writeInt32(w, size)
 	if codec != nil {
-		err = writeRecordBatch(w, attributes, size, func(w *bufio.Writer) {
+		err = writeRecordBatch(w, producer, attributes, size, func(w *bufio.Writer) {
 			w.Write(compressed)
 		}, msgs...)
 	} else {
-		err = writeRecordBatch(w, attributes, size, func(w *bufio.Writer) {
+		err = writeRecordBatch(w, producer, attributes, size, func(w *bufio.Writer) {
 			for i, msg := range msgs {
 				writeRecord(w, 0, msgs[0].Time, int64(i), msg)
 			}
@@ -394,18 +574,20 @@ func recordBatchHeaderSize() int32 {
 func recordBatchSize(msgs ...Message) (size int32) {
 	size = recordBatchHeaderSize()
 
-	baseTime := msgs[0].Time
+	baseTime := timestamp(msgs[0].Time)
 
 	for i, msg := range msgs {
 
-		sz := recordSize(&msg, msg.Time.Sub(baseTime), int64(i))
+		sz := recordSize(&msg, timestamp(msg.Time)-baseTime, int64(i))
 
 		size += int32(sz + varIntLen(int64(sz)))
 	}
 	return
 }
 
-func writeRecordBatch(w *bufio.Writer, attributes int16, size int32, write func(*bufio.Writer), msgs ...Message) error {
+// writeRecordBatch writes a record batch of msgs, the producer fields of the
+// batch are left unset when producer is nil.
+func writeRecordBatch(w *bufio.Writer, producer *batchProducer, attributes int16, size int32, write func(*bufio.Writer), msgs ...Message) error {
 
 	baseTime := msgs[0].Time
 
@@ -420,14 +602,25 @@ func writeRecordBatch(w *bufio.Writer, attributes int16, size int32, write func(
 	crcBuf.Grow(int(size - 12)) // 12 = batch length + base offset sizes
 	crcWriter := bufio.NewWriter(crcBuf)
 
-	writeInt16(crcWriter, attributes)         // attributes, timestamp type 0 - create time, not part of a transaction, no control messages
+	writeInt16(crcWriter, attributes)         // attributes, timestamp type 0 - create time, no control messages
 	writeInt32(crcWriter, int32(len(msgs)-1)) // max offset
 	writeInt64(crcWriter, timestamp(baseTime))
-	lastTime := timestamp(msgs[len(msgs)-1].Time)
-	writeInt64(crcWriter, int64(lastTime))
-	writeInt64(crcWriter, -1)               // default producer id for now
-	writeInt16(crcWriter, -1)               // default producer epoch for now
-	writeInt32(crcWriter, -1)               // default base sequence
+	maxTime := timestamp(baseTime)
+	for _, msg := range msgs[1:] {
+		if t := timestamp(msg.Time); t > maxTime {
+			maxTime = t
+		}
+	}
+	writeInt64(crcWriter, maxTime)
+	if producer != nil {
+		writeInt64(crcWriter, producer.id)
+		writeInt16(crcWriter, producer.epoch)
+		writeInt32(crcWriter, producer.baseSequence)
+	} else {
+		writeInt64(crcWriter, -1) // no producer id
+		writeInt16(crcWriter, -1) // no producer epoch
+		writeInt32(crcWriter, -1) // no base sequence
+	}
 	writeInt32(crcWriter, int32(len(msgs))) // record count
 
 	write(crcWriter)
@@ -435,8 +628,7 @@ func writeRecordBatch(w *bufio.Writer, attributes int16, size int32, write func(
 		return err
 	}
 
-	crcTable := crc32.MakeTable(crc32.Castagnoli)
-	crcChecksum := crc32.Checksum(crcBuf.Bytes(), crcTable)
+	crcChecksum := crc32.Checksum(crcBuf.Bytes(), crc32cTable)
 
 	writeInt32(w, int32(crcChecksum))
 	if _, err := w.Write(crcBuf.Bytes()); err != nil {
@@ -448,38 +640,41 @@ func writeRecordBatch(w *bufio.Writer, attributes int16, size int32, write func(
 
 var maxDate = time.Date(5000, time.January, 0, 0, 0, 0, 0, time.UTC)
 
-func recordSize(msg *Message, timestampDelta time.Duration, offsetDelta int64) (size int) {
+// recordSize returns the size of the record of msg, where timestampDelta is the
+// difference in milliseconds between the time of msg and the first timestamp
+// of its batch.
+func recordSize(msg *Message, timestampDelta int64, offsetDelta int64) (size int) {
 	size += 1 + // attributes
-		varIntLen(int64(timestampDelta)) +
+		varIntLen(timestampDelta) +
 		varIntLen(offsetDelta) +
-		varIntLen(int64(len(msg.Key))) +
+		varIntLen(varBytesLen(msg.Key)) +
 		len(msg.Key) +
-		varIntLen(int64(len(msg.Value))) +
+		varIntLen(varBytesLen(msg.Value)) +
 		len(msg.Value) +
 		varIntLen(int64(len(msg.Headers)))
 	for _, h := range msg.Headers {
 		size += varIntLen(int64(len([]byte(h.Key)))) +
 			len([]byte(h.Key)) +
-			varIntLen(int64(len(h.Value))) +
+			varIntLen(varBytesLen(h.Value)) +
 			len(h.Value)
 	}
 	return
 }
 
-func compress(codec CompressionCodec, msgs ...Message) ([]Message, error) {
+// compress serializes the messages in b and compresses them in a single
+// message, b must not be released until the message was written.
+func compress(b *encodeBuffer, codec CompressionCodec, msgs ...Message) ([]Message, error) {
 	estimatedLen := 0
 	for _, msg := range msgs {
 		estimatedLen += int(msgSize(msg.Key, msg.Value))
 	}
-	buf := &bytes.Buffer{}
-	buf.Grow(estimatedLen)
-	bufWriter := bufio.NewWriter(buf)
+	b.buf.Grow(estimatedLen)
 	for offset, msg := range msgs {
-		writeMessage(bufWriter, int64(offset), CompressionNoneCode, msg.Time, msg.Key, msg.Value)
+		writeMessage(b.w, int64(offset), CompressionNoneCode, msg.Time, msg.Key, msg.Value)
 	}
-	bufWriter.Flush()
+	b.w.Flush()
 
-	compressed, err := codec.Encode(buf.Bytes())
+	compressed, err := codec.Encode(b.buf.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -516,25 +711,28 @@ func msgSize(key, value []byte) int32 {
 // Messages with magic >2 are called records. This method writes messages using message format 2.
 func writeRecord(w *bufio.Writer, attributes int8, baseTime time.Time, offset int64, msg Message) {
 
-	timestampDelta := msg.Time.Sub(baseTime)
+	// Timestamps are encoded in milliseconds, the delta is computed from the
+	// truncated times so that adding it to the first timestamp of the batch
+	// gives back the timestamp of the message.
+	timestampDelta := timestamp(msg.Time) - timestamp(baseTime)
 	offsetDelta := int64(offset)
 
 	writeVarInt(w, int64(recordSize(&msg, timestampDelta, offsetDelta)))
 
 	writeInt8(w, attributes)
-	writeVarInt(w, int64(timestampDelta))
+	writeVarInt(w, timestampDelta)
 	writeVarInt(w, offsetDelta)
 
-	writeVarInt(w, int64(len(msg.Key)))
+	writeVarInt(w, varBytesLen(msg.Key))
 	w.Write(msg.Key)
-	writeVarInt(w, int64(len(msg.Value)))
+	writeVarInt(w, varBytesLen(msg.Value))
 	w.Write(msg.Value)
 	writeVarInt(w, int64(len(msg.Headers)))
 
 	for _, h := range msg.Headers {
 		writeVarInt(w, int64(len(h.Key)))
 		w.Write([]byte(h.Key))
-		writeVarInt(w, int64(len(h.Value)))
+		writeVarInt(w, varBytesLen(h.Value))
 		w.Write(h.Value)
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"testing"
 	"time"
@@ -49,6 +50,7 @@ func TestWriteVarInt(t *testing.T) {
 func TestWriteOptimizations(t *testing.T) {
 	t.Parallel()
 	t.Run("writeFetchRequestV2", testWriteFetchRequestV2)
+	t.Run("writeFetchRequestV11", testWriteFetchRequestV11)
 	t.Run("writeListOffsetRequestV1", testWriteListOffsetRequestV1)
 	t.Run("writeProduceRequestV2", testWriteProduceRequestV2)
 }
@@ -84,6 +86,75 @@ func testWriteFetchRequestV2(t *testing.T) {
 	)
 }
 
+func testWriteFetchRequestV11(t *testing.T) {
+	const offset = 42
+	const leaderEpoch = 3
+	const minBytes = 10
+	const maxBytes = 1000
+	const partitionMaxBytes = 500
+	const maxWait = 100 * time.Millisecond
+	const rackID = "rack"
+
+	header := requestHeader{
+		ApiKey:        int16(fetchRequest),
+		ApiVersion:    int16(v11),
+		CorrelationID: testCorrelationID,
+		ClientID:      testClientID,
+	}
+	topics := []fetchRequestTopicV11{{
+		TopicName: testTopic,
+		Partitions: []fetchRequestPartitionV11{{
+			Partition:          testPartition,
+			CurrentLeaderEpoch: leaderEpoch,
+			FetchOffset:        offset,
+			MaxBytes:           partitionMaxBytes,
+		}},
+	}}
+
+	tests := []struct {
+		scenario string
+		session  fetchSessionRequest
+		topics   []fetchRequestTopicV11
+	}{
+		{
+			scenario: "without session",
+			session:  noFetchSession,
+			topics:   topics,
+		},
+		{
+			scenario: "incremental with partition",
+			session:  fetchSessionRequest{id: 7, epoch: 2},
+			topics:   topics,
+		},
+		{
+			scenario: "incremental without partition",
+			session:  fetchSessionRequest{id: 7, epoch: 3, omitPartition: true},
+			topics:   []fetchRequestTopicV11{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			testWriteOptimization(t,
+				header,
+				fetchRequestV11{
+					ReplicaID:    -1,
+					MaxWaitTime:  milliseconds(maxWait),
+					MinBytes:     minBytes,
+					MaxBytes:     maxBytes,
+					SessionID:    test.session.id,
+					SessionEpoch: test.session.epoch,
+					Topics:       test.topics,
+					RackID:       rackID,
+				},
+				func(w *bufio.Writer) {
+					writeFetchRequestV11(w, testCorrelationID, testClientID, testTopic, testPartition, leaderEpoch, offset, minBytes, maxBytes, partitionMaxBytes, maxWait, 0, test.session, rackID)
+				},
+			)
+		})
+	}
+}
+
 func testWriteListOffsetRequestV1(t *testing.T) {
 	const time = -1
 	testWriteOptimization(t,
@@ -243,3 +314,57 @@ func TestWriteV2RecordBatch(t *testing.T) {
 		return
 	}
 }
+
+func TestWriteProduceRequestV3Transactional(t *testing.T) {
+	producer := &batchProducer{
+		transactionalID: "txn",
+		id:              42,
+		epoch:           3,
+		baseSequence:    7,
+	}
+	msgs := []Message{
+		{Value: []byte("0"), Time: time.Now()},
+		{Value: []byte("1"), Time: time.Now()},
+	}
+
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	if err := writeProduceRequestV3(w, nil, testCorrelationID, testClientID, testTopic, testPartition, time.Second, -1, producer, msgs...); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+
+	b := buf.Bytes()
+	if size := int(binary.BigEndian.Uint32(b)); size != len(b)-4 {
+		t.Fatalf("expected request size %d, got %d", len(b)-4, size)
+	}
+
+	// skip the size, api key, api version, correlation id, and client id
+	b = b[4+2+2+4+2+len(testClientID):]
+
+	if n := int(binary.BigEndian.Uint16(b)); n != len(producer.transactionalID) || string(b[2:2+n]) != producer.transactionalID {
+		t.Fatalf("expected transactional id %q, got %q", producer.transactionalID, b[2:2+n])
+	}
+
+	// skip the transactional id, required acks, timeout, topics, topic name,
+	// partitions, partition, and record set size
+	b = b[2+len(producer.transactionalID)+2+4+4+2+len(testTopic)+4+4+4:]
+
+	msgSet, err := newMessageSetReader(bufio.NewReader(bytes.NewReader(b)), len(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgSet.v2.checkCRC = true
+	batch := &Batch{msgs: msgSet}
+
+	if _, err := batch.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if batch.ProducerID() != 42 || batch.ProducerEpoch() != 3 || batch.BaseSequence() != 7 {
+		t.Errorf("expected producer 42, epoch 3, and sequence 7; got %d, %d, and %d",
+			batch.ProducerID(), batch.ProducerEpoch(), batch.BaseSequence())
+	}
+	if msgSet.v2.header.transactionType() != transactional {
+		t.Error("expected the record batch to be transactional")
+	}
+}
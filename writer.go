@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,9 +24,18 @@ type Writer struct {
 	mutex  sync.RWMutex
 	closed bool
 
-	join sync.WaitGroup
-	msgs chan writerMessage
-	done chan struct{}
+	join    sync.WaitGroup
+	msgs    chan writerMessage
+	lookups chan partitionLookup
+	done    chan struct{}
+
+	// completions tracks the goroutines waiting for the results of messages
+	// written asynchronously to invoke WriterConfig.Completion.
+	completions sync.WaitGroup
+
+	// async holds the calls to WriteMessages in Async mode which Flush waits
+	// for.
+	async asyncWrites
 
 	// writer stats are all made of atomic values, no need for synchronization.
 	// Use a pointer to ensure 64-bit alignment of the values.
@@ -35,7 +45,8 @@ type Writer struct {
 // WriterConfig is a configuration type used to create new instances of Writer.
 type WriterConfig struct {
 	// The list of brokers used to discover the partitions available on the
-	// kafka cluster.
+	// kafka cluster. The brokers are tried in a random order until one of them
+	// responds, so the writer keeps working as long as one of them is up.
 	//
 	// This field is required, attempting to create a writer with an empty list
 	// of brokers will panic.
@@ -43,8 +54,18 @@ type WriterConfig struct {
 
 	// The topic that the writer will produce messages to.
 	//
-	// This field is required, attempting to create a writer with an empty topic
-	// will panic.
+	// When it is empty, the writer produces each message to the topic set in
+	// its Topic field, and WriteMessages fails with ErrMissingTopic if one of
+	// the messages has none. The partitions of a topic are looked up when the
+	// first message is written to it, and refreshed every RebalanceInterval
+	// like the partitions of the topic of the writer. The connections, the
+	// queue and the limits of the writer are shared by all the topics, and
+	// messages are batched by topic and partition. Balancers which track the
+	// partitions that they route messages to, like LeastBytes, are also shared,
+	// so Hash, RoundRobin or StickyBalancer are better suited.
+	//
+	// When it is set, messages must leave their Topic field empty or set it to
+	// the topic of the writer.
 	Topic string
 
 	// The dialer used by the writer to establish connections to the kafka
@@ -53,7 +74,8 @@ type WriterConfig struct {
 	// If nil, the default dialer is used instead.
 	Dialer *Dialer
 
-	// The balancer used to distribute messages across partitions.
+	// The balancer used to distribute messages across partitions. It is not
+	// invoked when the topic has a single partition.
 	//
 	// The default is to use a round-robin distribution.
 	Balancer Balancer
@@ -68,12 +90,47 @@ type WriterConfig struct {
 	// The default is to use a queue capacity of 100 messages.
 	QueueCapacity int
 
+	// MaxQueuedMessages limits the number of messages held by the writer,
+	// which were passed to WriteMessages but not written nor failed yet,
+	// across all partitions. It bounds the memory used by the writer when the
+	// brokers are slow or unavailable, especially with Async.
+	//
+	// WriteMessages blocks while the limit is reached, until messages are
+	// written or its context is canceled, unless ErrorOnFullQueue is set.
+	//
+	// The default is zero, which only bounds the messages by the internal
+	// queues of the writer and of each partition.
+	MaxQueuedMessages int
+
+	// ErrorOnFullQueue makes WriteMessages return ErrQueueFull instead of
+	// blocking when the messages don't fit within MaxQueuedMessages, in which
+	// case none of the messages of the call are written.
+	//
+	// The messages of a call are accepted all at once, so a call passing more
+	// messages than MaxQueuedMessages always fails with ErrQueueFull, even
+	// when the queue is empty. Programs writing large calls must split them,
+	// or leave ErrorOnFullQueue unset, in which case WriteMessages queues the
+	// messages one by one as others are written.
+	ErrorOnFullQueue bool
+
 	// Limit on how many messages will be buffered before being sent to a
 	// partition.
 	//
 	// The default is to use a target batch size of 100 messages.
 	BatchSize int
 
+	// BatchGroupKey, when set, is called on each message to compute the key of
+	// the group it belongs to. Messages with different group keys are never
+	// combined into the same produce request, even when they are written to the
+	// same partition, and BatchSize, BatchBytes and BatchTimeout apply to each
+	// group of messages.
+	//
+	// Batches are formed in the order that messages are written to a partition,
+	// so writing messages with interleaved group keys results in small batches.
+	//
+	// The default is to batch messages by partition only.
+	BatchGroupKey func(Message) string
+
 	// Controls how many times a writer will attempt to resend records that
 	// have failed with a potentailly transient error. This gets
 	// applied to both async and non. Setting it to 0 will disable
@@ -84,18 +141,57 @@ type WriterConfig struct {
 	// The default is 0.
 	Retries int
 
+	// RetriableErrors, when set, is called with the errors that writing
+	// messages failed with to decide whether they may be retried, both by the
+	// low level retries and the MaxAttempts of WriteMessages. Messages which
+	// failed with other errors are reported without being retried.
+	//
+	// The default is to retry the temporary kafka errors (see Error.Temporary)
+	// and the errors of leaders or replicas which are not available yet, as
+	// well as network errors. Other errors are not retried.
+	RetriableErrors func(error) bool
+
 	// The amount of time waiting before attempting to resend a batch.
 	// This helps putting pressure on the brokers during failure scenarios.
 	//
-	// Defaults to 100ms
+	// Deprecated: use RetryBackoffMin instead, this value is only used as the
+	// default for RetryBackoffMin.
 	RetryBackoffInterval time.Duration
 
+	// RetryBackoffMin and RetryBackoffMax bound the amount of time waiting
+	// before attempting to resend messages. The delay starts at RetryBackoffMin
+	// and doubles after each attempt up to RetryBackoffMax, a random duration
+	// between zero and that delay is then waited (full jitter). This prevents
+	// producers from reconnecting to the brokers in lockstep after an outage.
+	//
+	// Default to 100ms and 1s
+	RetryBackoffMin time.Duration
+	RetryBackoffMax time.Duration
+
 	// Limit the maximum size of a request in bytes before being sent to
 	// a partition.
 	//
+	// Messages are grouped in batches which never exceed the limit, a single
+	// message larger than BatchBytes can't be sent, it is discarded and the
+	// error is logged. MaxMessageBytes can be used to reject such messages
+	// before they are queued instead.
+	//
 	// The default is to use a kafka default value of 1048576.
 	BatchBytes int
 
+	// MaxMessageBytes limits the size of each message, which is the sum of the
+	// lengths of its key, value, and header keys and values. WriteMessages
+	// returns MessageSizeTooLarge without writing any of the messages when one
+	// of them exceeds the limit, saving the round trip to the broker which
+	// would reject it.
+	//
+	// The limit applies to messages individually, while BatchBytes applies to
+	// the batches they are grouped in, it should therefore be lower than
+	// BatchBytes, and than the max.message.bytes configuration of the topic.
+	//
+	// The default is zero, which doesn't limit the size of messages.
+	MaxMessageBytes int
+
 	// Time limit on how often incomplete message batches will be flushed to
 	// kafka.
 	//
@@ -119,21 +215,118 @@ type WriterConfig struct {
 	// The default is to refresh partitions every 15 seconds.
 	RebalanceInterval time.Duration
 
+	// MetadataRefreshInterval defines how often the writers of the partitions
+	// look up the leader of their partition while they are connected to it,
+	// like metadata.max.age.ms of the java client. A writer whose partition
+	// leader moved to another broker, for example after a reassignment or a
+	// preferred leader election, reconnects to the new leader before writing
+	// its next batch instead of waiting for the old one to reject it. The
+	// leaders are looked up in the background, writes are not delayed. New
+	// partitions are picked up every RebalanceInterval.
+	//
+	// The default is to look up the leaders every 5 minutes.
+	MetadataRefreshInterval time.Duration
+
+	// IdleTimeout is the amount of time after which the writers of the
+	// partitions close their connection when no batch was written to it. The
+	// next batch is then written to a new connection, instead of one that a
+	// firewall or load balancer may have silently dropped in the meantime,
+	// which would fail the write. It should be shorter than the idle timeout
+	// of the network equipment between the program and the brokers.
+	//
+	// The default is to keep the connections open until they fail.
+	IdleTimeout time.Duration
+
 	// Number of acknowledges from partition replicas required before receiving
-	// a response to a produce request (default to -1, which means to wait for
-	// all replicas).
-	RequiredAcks int
-
-	// Setting this flag to true causes the WriteMessages method to never block.
-	// It also means that errors are ignored since the caller will not receive
-	// the returned value. Use this only if you don't care about guarantees of
-	// whether the messages were written to kafka.
+	// a response to a produce request, RequireAll or RequireOne (default to
+	// RequireAll, which means to wait for all replicas). NewWriter panics on
+	// other values.
+	RequiredAcks RequiredAcks
+
+	// FireAndForget makes the writer send produce requests which require no
+	// acknowledgement (acks=0), overriding RequiredAcks. It is the only way to
+	// disable acknowledgements, since the zero-value of RequiredAcks selects
+	// RequireAll. The writer does not wait for the brokers to respond, a batch
+	// is considered written as soon as it was sent.
+	// WriteMessages only reports network errors in this mode, messages
+	// rejected by the brokers are silently dropped.
+	FireAndForget bool
+
+	// Setting this flag to true causes the WriteMessages method to return as
+	// soon as the messages were queued, without waiting for them to be written.
+	// WriteMessages still blocks while the queue of the writer is full, which
+	// holds up to QueueCapacity messages, so the program can't produce faster
+	// than the writer delivers. MaxQueuedMessages bounds the messages held by
+	// the writer across all partitions.
+	//
+	// Errors are only reported to Completion and ErrorHandler, they are
+	// ignored if both are nil. Use this only if you don't care about
+	// guarantees of whether the messages were written to kafka, or handle
+	// failures with Completion or ErrorHandler. Flush and Close wait
+	// for the queued messages to be written.
 	Async bool
 
+	// Completion is called in Async mode when all the messages queued by a call
+	// to WriteMessages were written, or failed to be, with the messages passed
+	// to WriteMessages and a nil error if they were all written. Like for the
+	// synchronous mode, there's no way to know which messages failed when the
+	// error is not nil. Only the Retries of the batches apply in Async mode,
+	// MaxAttempts does not.
+	//
+	// The messages passed to Completion are copies of the ones passed to
+	// WriteMessages, taken before the serializers and middleware ran. When the
+	// topic is configured with message.timestamp.type=LogAppendTime, the
+	// brokers ignore the time of the messages and assign their own, in which
+	// case the Time of the messages which were written is set to the log
+	// append time returned by the broker.
+	//
+	// The function is called from goroutines of the writer, possibly
+	// concurrently. Close returns after the calls completed.
+	Completion func(messages []Message, err error)
+
+	// ErrorHandler is called in Async mode with the messages of each batch
+	// which could not be written to a partition after exhausting the retries,
+	// or could not be routed to a partition because the partitions of the
+	// topic could not be looked up, and the error that they failed with. It
+	// allows programs to keep the messages which were not delivered, for
+	// example to write them to a local spool during outages.
+	//
+	// The messages are copies of the ones passed to WriteMessages, taken before
+	// the serializers and middleware ran. Unlike Completion, the handler
+	// receives only the messages which failed, grouped by batch rather than by
+	// call to WriteMessages.
+	//
+	// The function is called sequentially from a single goroutine, failures
+	// are queued while it runs so the writer never waits for it and none are
+	// dropped. Close returns after the calls completed.
+	ErrorHandler func(messages []Message, err error)
+
 	// CompressionCodec set the codec to be used to compress Kafka messages.
-	// Note that messages are allowed to overwrite the compression codec individually.
+	// Note that messages are allowed to overwrite the compression codec individually,
+	// see WriteMessagesWith.
 	CompressionCodec
 
+	// MinCompressBytes is the size under which batches are written without
+	// being compressed, small batches compress poorly so it saves the CPU time
+	// spent compressing them. The size of batches is measured the same way as
+	// for BatchBytes.
+	//
+	// The default is zero, which compresses all batches.
+	MinCompressBytes int
+
+	// MaxOpenPartitions limits the number of partitions that the writer holds
+	// a connection to at the same time, which bounds the file descriptors used
+	// when writing to topics with many partitions.
+	//
+	// Partitions wait for a connection in the order they need one, and give it
+	// up after writing a batch if others are waiting, or when idle, one idle
+	// partition for each waiting one, so no partition starves. The messages of
+	// a partition are still written in order, but a connection is dialed more
+	// often when more partitions are written to than the limit.
+	//
+	// The default is zero, which doesn't limit the number of connections.
+	MaxOpenPartitions int
+
 	// If not nil, specifies a logger used to report internal changes within the
 	// writer.
 	Logger *log.Logger
@@ -142,7 +335,67 @@ type WriterConfig struct {
 	// back to using Logger instead.
 	ErrorLogger *log.Logger
 
+	// StructuredLogger is a leveled logger receiving entries with key/value
+	// pairs describing the internal state of the writer. If not nil, it takes
+	// precedence over Logger and ErrorLogger.
+	StructuredLogger Logger
+
+	// If not nil, the statistics of the writer are reported to the registry as
+	// they are observed, in addition to being returned by Stats.
+	MetricsRegistry MetricsRegistry
+
+	// KeySerializer and ValueSerializer optionally encode the DecodedKey and
+	// DecodedValue fields of the messages passed to WriteMessages, the results
+	// are set to their Key and Value fields. Messages where the decoded field
+	// is nil are written unchanged.
+	//
+	// Messages which fail to be encoded are not written, WriteMessages returns
+	// a WriteErrors holding their *SerializationError after writing the other
+	// messages.
+	//
+	// The serializers are invoked by the goroutines calling WriteMessages, and
+	// must be safe for concurrent use if the writer is used concurrently.
+	KeySerializer   Serializer
+	ValueSerializer Serializer
+
+	// Middleware is a chain of functions transforming each message passed to
+	// WriteMessages before it is balanced to a partition, for example to add
+	// trace context headers or to encrypt the values. The functions are
+	// applied in order, each receiving the message returned by the previous
+	// one, after the serializers encoded the message, so they see the bytes
+	// which are written, unless MiddlewareBeforeSerialization is set.
+	//
+	// Messages which a function fails to transform are not written,
+	// WriteMessages returns a WriteErrors holding their *MiddlewareError after
+	// writing the other messages.
+	//
+	// The middleware is invoked by the goroutines calling WriteMessages, and
+	// must be safe for concurrent use if the writer is used concurrently.
+	Middleware []func(Message) (Message, error)
+
+	// MiddlewareBeforeSerialization applies the Middleware to the messages
+	// before the serializers encode them, so the functions see the DecodedKey
+	// and DecodedValue of the messages.
+	MiddlewareBeforeSerialization bool
+
+	// Hooks invoked with the events of the partition writers, for example to
+	// report metrics without going through Stats or a MetricsRegistry.
+	//
+	// The hooks are invoked sequentially by a goroutine dedicated to them so
+	// they never slow down the writer. Events are dropped if the hooks can't
+	// keep up and QueueCapacity events are already waiting to be delivered.
+	OnBatch func(BatchEvent)
+	OnWrite func(WriteEvent)
+	OnError func(ErrorEvent)
+	OnRetry func(RetryEvent)
+
 	newPartitionWriter func(partition int, config WriterConfig, stats *writerStats) partitionWriter
+	events             *writerEvents
+	errors             *errorHandler
+	slots              *partitionSlots
+	queue              *queueLimit
+	flushes            *flushSignal
+	aborts             *abortSignal
 }
 
 // WriterStats is a data structure returned by a call to Writer.Stats that
@@ -155,16 +408,33 @@ type WriterStats struct {
 	Rebalances int64 `metric:"kafka.writer.rebalance.count" type:"counter"`
 	Errors     int64 `metric:"kafka.writer.error.count"     type:"counter"`
 
-	DialTime   DurationStats `metric:"kafka.writer.dial.seconds"`
-	WriteTime  DurationStats `metric:"kafka.writer.write.seconds"`
-	WaitTime   DurationStats `metric:"kafka.writer.wait.seconds"`
-	Retries    SummaryStats  `metric:"kafka.writer.retries.count"`
-	BatchSize  SummaryStats  `metric:"kafka.writer.batch.size"`
-	BatchBytes SummaryStats  `metric:"kafka.writer.batch.bytes"`
+	DialTime  DurationStats `metric:"kafka.writer.dial.seconds"`
+	WriteTime DurationStats `metric:"kafka.writer.write.seconds"`
+	WaitTime  DurationStats `metric:"kafka.writer.wait.seconds"`
+	Throttled DurationStats `metric:"kafka.writer.throttle.seconds"`
+	Retries   SummaryStats  `metric:"kafka.writer.retries.count"`
+
+	// BatchSize and BatchBytes summarize the number of messages and bytes of
+	// the batches written since the last snapshot, which shows whether the
+	// batches are flushed because they reach BatchSize or BatchBytes, or
+	// because BatchTimeout expires first. InflightBatches is the number of
+	// batches being written when the snapshot was taken.
+	BatchSize       SummaryStats `metric:"kafka.writer.batch.size"`
+	BatchBytes      SummaryStats `metric:"kafka.writer.batch.bytes"`
+	InflightBatches int64        `metric:"kafka.writer.batch.inflight" type:"gauge"`
+
+	// UncompressedBytes and CompressedBytes are the sizes of the batches
+	// before and after compression, CompressionRatio is the quotient of the
+	// two, or zero if no batches were compressed.
+	UncompressedBytes int64   `metric:"kafka.writer.compression.input.bytes"  type:"counter"`
+	CompressedBytes   int64   `metric:"kafka.writer.compression.output.bytes" type:"counter"`
+	CompressionRatio  float64 `metric:"kafka.writer.compression.ratio"        type:"gauge"`
 
 	MaxAttempts          int64         `metric:"kafka.writer.attempts.max"       		type:"gauge"`
 	MaxRetries           int64         `metric:"kafka.writer.retries.max"        		type:"gauge"`
 	RetryBackoffInterval time.Duration `metric:"kafka.writer.retrybackoff.interval"    	type:"gauge"`
+	RetryBackoffMin      time.Duration `metric:"kafka.writer.retrybackoff.min" type:"gauge"`
+	RetryBackoffMax      time.Duration `metric:"kafka.writer.retrybackoff.max" type:"gauge"`
 	MaxBatchSize         int64         `metric:"kafka.writer.batch.max"         		type:"gauge"`
 	BatchTimeout         time.Duration `metric:"kafka.writer.batch.timeout"     		type:"gauge"`
 	ReadTimeout          time.Duration `metric:"kafka.writer.read.timeout"       		type:"gauge"`
@@ -172,11 +442,26 @@ type WriterStats struct {
 	RebalanceInterval    time.Duration `metric:"kafka.writer.rebalance.interval" 		type:"gauge"`
 	RequiredAcks         int64         `metric:"kafka.writer.acks.required"      		type:"gauge"`
 	Async                bool          `metric:"kafka.writer.async"             	 	type:"gauge"`
-	QueueLength          int64         `metric:"kafka.writer.queue.length"      	 	type:"gauge"`
-	QueueCapacity        int64         `metric:"kafka.writer.queue.capacity"     		type:"gauge"`
+
+	// QueueLength is the number of messages held by the writer which were not
+	// written nor failed yet, across all partitions, including the batches
+	// being written. It used to only count the messages waiting in the
+	// internal queue of the writer before being assigned to a partition, so
+	// it is usually higher than before for the same load, and is the value to
+	// compare to QueueCapacity.
+	//
+	// QueueCapacity is MaxQueuedMessages, or the capacity of the internal
+	// queue of the writer when it is zero. QueueFull counts the calls to
+	// WriteMessages which found the queue full.
+	QueueLength   int64 `metric:"kafka.writer.queue.length"     type:"gauge"`
+	QueueCapacity int64 `metric:"kafka.writer.queue.capacity"   type:"gauge"`
+	QueueFull     int64 `metric:"kafka.writer.queue.full.count" type:"counter"`
 
 	ClientID string `tag:"client_id"`
-	Topic    string `tag:"topic"`
+
+	// Topic is the topic that the writer was configured with. It is empty
+	// when the writer produces to the topics set on the messages.
+	Topic string `tag:"topic"`
 }
 
 // writerStats is a struct that contains statistics on a writer.
@@ -194,9 +479,43 @@ type writerStats struct {
 	dialTime       summary
 	writeTime      summary
 	waitTime       summary
+	throttled      summary
 	retries        summary
 	batchSize      summary
 	batchSizeBytes summary
+
+	uncompressedBytes counter
+	compressedBytes   counter
+
+	// pending is the number of messages queued by WriteMessages which were not
+	// written nor failed yet.
+	pending   gauge
+	queueFull counter
+
+	// inflight is the number of batches being written.
+	inflight gauge
+}
+
+// register configures the statistics to be reported to registry.
+func (s *writerStats) register(registry MetricsRegistry) {
+	s.dials.metric = counterMetric{registry, "kafka.writer.dial.count"}
+	s.writes.metric = counterMetric{registry, "kafka.writer.write.count"}
+	s.messages.metric = counterMetric{registry, "kafka.writer.message.count"}
+	s.bytes.metric = counterMetric{registry, "kafka.writer.message.bytes"}
+	s.rebalances.metric = counterMetric{registry, "kafka.writer.rebalance.count"}
+	s.errors.metric = counterMetric{registry, "kafka.writer.error.count"}
+	s.dialTime.metric = timerMetric{registry, "kafka.writer.dial.seconds"}
+	s.writeTime.metric = timerMetric{registry, "kafka.writer.write.seconds"}
+	s.waitTime.metric = timerMetric{registry, "kafka.writer.wait.seconds"}
+	s.throttled.metric = timerMetric{registry, "kafka.writer.throttle.seconds"}
+	s.retries.metric = histogramMetric{registry, "kafka.writer.retries.count"}
+	s.batchSize.metric = histogramMetric{registry, "kafka.writer.batch.size"}
+	s.batchSizeBytes.metric = histogramMetric{registry, "kafka.writer.batch.bytes"}
+	s.uncompressedBytes.metric = counterMetric{registry, "kafka.writer.compression.input.bytes"}
+	s.compressedBytes.metric = counterMetric{registry, "kafka.writer.compression.output.bytes"}
+	s.pending.metric = gaugeMetric{registry, "kafka.writer.queue.length"}
+	s.queueFull.metric = counterMetric{registry, "kafka.writer.queue.full.count"}
+	s.inflight.metric = gaugeMetric{registry, "kafka.writer.batch.inflight"}
 }
 
 // NewWriter creates and returns a new Writer configured with config.
@@ -205,10 +524,6 @@ func NewWriter(config WriterConfig) *Writer {
 		panic("cannot create a kafka writer with an empty list of brokers")
 	}
 
-	if len(config.Topic) == 0 {
-		panic("cannot create a kafka writer with an empty topic")
-	}
-
 	if config.Dialer == nil {
 		config.Dialer = DefaultDialer
 	}
@@ -230,6 +545,22 @@ func NewWriter(config WriterConfig) *Writer {
 	if config.RetryBackoffInterval == 0 {
 		config.RetryBackoffInterval = 100 * time.Millisecond
 	}
+
+	if config.RetryBackoffMin == 0 {
+		config.RetryBackoffMin = config.RetryBackoffInterval
+	}
+
+	if config.RetryBackoffMax == 0 {
+		config.RetryBackoffMax = 1 * time.Second
+		if config.RetryBackoffMax < config.RetryBackoffMin {
+			config.RetryBackoffMax = config.RetryBackoffMin
+		}
+	}
+
+	if config.RetryBackoffMin < 0 || config.RetryBackoffMin > config.RetryBackoffMax {
+		panic(fmt.Sprintf("retry backoff out of bounds (min = %s, max = %s)", config.RetryBackoffMin, config.RetryBackoffMax))
+	}
+
 	if config.QueueCapacity == 0 {
 		config.QueueCapacity = 100
 	}
@@ -243,6 +574,22 @@ func NewWriter(config WriterConfig) *Writer {
 		config.BatchBytes = 1048576
 	}
 
+	if config.MaxMessageBytes < 0 {
+		panic(fmt.Sprintf("MaxMessageBytes out of bounds: %d", config.MaxMessageBytes))
+	}
+
+	if config.MinCompressBytes < 0 {
+		panic(fmt.Sprintf("MinCompressBytes out of bounds: %d", config.MinCompressBytes))
+	}
+
+	if config.MaxOpenPartitions < 0 {
+		panic(fmt.Sprintf("MaxOpenPartitions out of bounds: %d", config.MaxOpenPartitions))
+	}
+
+	if config.MaxQueuedMessages < 0 {
+		panic(fmt.Sprintf("MaxQueuedMessages out of bounds: %d", config.MaxQueuedMessages))
+	}
+
 	if config.BatchTimeout == 0 {
 		config.BatchTimeout = 1 * time.Second
 	}
@@ -259,23 +606,77 @@ func NewWriter(config WriterConfig) *Writer {
 		config.RebalanceInterval = 15 * time.Second
 	}
 
+	if config.MetadataRefreshInterval < 0 {
+		panic(fmt.Sprintf("MetadataRefreshInterval out of bounds: %d", config.MetadataRefreshInterval))
+	}
+
+	if config.MetadataRefreshInterval == 0 {
+		config.MetadataRefreshInterval = 5 * time.Minute
+	}
+
+	if config.IdleTimeout < 0 {
+		panic(fmt.Sprintf("IdleTimeout out of bounds: %d", config.IdleTimeout))
+	}
+
+	switch {
+	case config.FireAndForget:
+		config.RequiredAcks = requireNone
+	case config.RequiredAcks == 0:
+		config.RequiredAcks = RequireAll
+	case config.RequiredAcks != RequireAll && config.RequiredAcks != RequireOne:
+		panic(fmt.Sprintf("RequiredAcks out of bounds: %d", config.RequiredAcks))
+	}
+
+	config.events = newWriterEvents(config)
+	config.errors = newErrorHandler(config)
+	config.slots = newPartitionSlots(config.MaxOpenPartitions)
+	config.queue = newQueueLimit(config.MaxQueuedMessages)
+	config.flushes = &flushSignal{}
+	config.aborts = newAbortSignal()
+
 	w := &Writer{
-		config: config,
-		msgs:   make(chan writerMessage, config.QueueCapacity),
-		done:   make(chan struct{}),
+		config:  config,
+		msgs:    make(chan writerMessage, config.QueueCapacity),
+		lookups: make(chan partitionLookup),
+		done:    make(chan struct{}),
 		stats: &writerStats{
-			dialTime:  makeSummary(),
-			writeTime: makeSummary(),
-			waitTime:  makeSummary(),
-			retries:   makeSummary(),
+			dialTime:       makeSummary(),
+			writeTime:      makeSummary(),
+			waitTime:       makeSummary(),
+			throttled:      makeSummary(),
+			retries:        makeSummary(),
+			batchSize:      makeSummary(),
+			batchSizeBytes: makeSummary(),
 		},
 	}
 
+	if config.MetricsRegistry != nil {
+		w.stats.register(config.MetricsRegistry)
+	}
+
 	w.join.Add(1)
 	go w.run()
 	return w
 }
 
+// WriteOptions carries the options of a call to Writer.WriteMessagesWith,
+// which apply to the messages of the call only.
+type WriteOptions struct {
+	// CompressionCodec compresses the messages instead of the codec of the
+	// writer, NoCompression writes them uncompressed. Messages written with
+	// different codecs are never written in the same batch.
+	//
+	// The default is the CompressionCodec of the writer.
+	CompressionCodec CompressionCodec
+
+	// Balancer distributes the messages across partitions instead of the
+	// balancer of the writer. It is only called from the goroutine of the
+	// writer, like the balancer of the writer is.
+	//
+	// The default is the Balancer of the writer.
+	Balancer Balancer
+}
+
 // WriteMessages writes a batch of messages to the kafka topic configured on this
 // writer.
 //
@@ -292,12 +693,69 @@ func NewWriter(config WriterConfig) *Writer {
 // whole batch failed and re-write the messages later (which could then cause
 // duplicates).
 func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
+	return w.WriteMessagesWith(ctx, WriteOptions{}, msgs...)
+}
+
+// WriteMessagesWith is like WriteMessages but applies opts to the messages, for
+// example to skip compressing payloads which are already compressed:
+//
+//	w.WriteMessagesWith(ctx, kafka.WriteOptions{CompressionCodec: kafka.NoCompression}, msgs...)
+func (w *Writer) WriteMessagesWith(ctx context.Context, opts WriteOptions, msgs ...Message) error {
 	if len(msgs) == 0 {
 		return nil
 	}
 
+	for _, msg := range msgs {
+		switch {
+		case w.config.Topic == "" && msg.Topic == "":
+			return ErrMissingTopic
+		case w.config.Topic != "" && msg.Topic != "" && msg.Topic != w.config.Topic:
+			return &TopicMismatchError{WriterTopic: w.config.Topic, MessageTopic: msg.Topic}
+		}
+	}
+
+	// The messages reported to Completion and ErrorHandler are copies of the
+	// ones passed by the program, before the serializers and the middleware
+	// were applied, so the writer can set their log append time without
+	// modifying the ones of the program.
+	var originals []Message
+	if w.config.Async && (w.config.Completion != nil || w.config.errors != nil) {
+		originals = make([]Message, len(msgs))
+		copy(originals, msgs)
+	}
+
+	msgs, serr := w.prepare(msgs)
+	if len(msgs) == 0 {
+		return serr
+	}
+
+	if errs, ok := serr.(WriteErrors); ok && originals != nil {
+		prepared := originals[:0]
+		for i, msg := range originals {
+			if errs[i] == nil {
+				prepared = append(prepared, msg)
+			}
+		}
+		originals = prepared
+	}
+
+	if max := w.config.MaxMessageBytes; max != 0 {
+		for _, msg := range msgs {
+			if size := msg.size(); size > max {
+				w.logger().Error("message is larger than the maximum size configured with MaxMessageBytes",
+					"topic", w.topicOf(msg),
+					"size", size,
+					"max", max,
+				)
+				w.stats.errors.observe(1)
+				return MessageSizeTooLarge
+			}
+		}
+	}
+
 	var res = make(chan error, len(msgs))
 	var err error
+	var failed error
 	skippedMsgs := 0
 	t0 := time.Now()
 
@@ -309,28 +767,74 @@ func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
 			return io.ErrClosedPipe
 		}
 
-		for _, msg := range msgs {
-			if int(msg.message().size()) > w.config.BatchBytes {
-				if w.config.ErrorLogger != nil {
-					w.config.ErrorLogger.Printf("The message is %d bytes "+
-						"when serialized which is larger than the maximum request size you "+
-						"have configured with the %v configuration.", msg.message().size(), w.config.BatchBytes)
+		// reserved is the number of messages accounted for in the queue limit
+		// which were not queued yet.
+		reserved := 0
+		if w.config.ErrorOnFullQueue {
+			for _, msg := range msgs {
+				if int(msg.message().size()) <= w.config.BatchBytes {
+					reserved++
 				}
+			}
+			if !w.config.queue.tryAcquire(reserved) {
+				w.mutex.RUnlock()
+				w.stats.queueFull.observe(1)
+				return ErrQueueFull
+			}
+		}
+
+		full := false
+		for i, msg := range msgs {
+			if int(msg.message().size()) > w.config.BatchBytes {
+				w.logger().Error("message is larger than the maximum request size configured with BatchBytes",
+					"topic", w.topicOf(msg),
+					"size", msg.message().size(),
+					"max", w.config.BatchBytes,
+				)
 				w.stats.errors.observe(1)
 				//Don't watch for errors from this msg, as it's never sent.
 				skippedMsgs++
 				continue
 			}
+			if reserved == 0 {
+				if !w.config.queue.tryAcquire(1) {
+					if !full {
+						full = true
+						w.stats.queueFull.observe(1)
+					}
+					if err := w.config.queue.acquire(ctx); err != nil {
+						w.mutex.RUnlock()
+						return err
+					}
+				}
+				reserved = 1
+			}
+			wm := writerMessage{
+				msg:      msg,
+				res:      res,
+				codec:    opts.CompressionCodec,
+				balancer: opts.Balancer,
+			}
+			if originals != nil {
+				wm.original = &originals[i]
+			}
+			w.stats.pending.add(1)
 			select {
-			case w.msgs <- writerMessage{
-				msg: msg,
-				res: res,
-			}:
+			case w.msgs <- wm:
+				reserved--
 			case <-ctx.Done():
+				w.stats.pending.add(-1)
+				w.config.queue.release(reserved)
 				w.mutex.RUnlock()
 				return ctx.Err()
 			}
 		}
+
+		if w.config.Async {
+			// Registered while holding the mutex so Close waits for it.
+			w.completions.Add(1)
+			go w.complete(originals, res, len(msgs)-skippedMsgs, w.async.add())
+		}
 		w.mutex.RUnlock()
 
 		if w.config.Async {
@@ -343,11 +847,15 @@ func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
 			select {
 			case e := <-res:
 				if e != nil {
-					if we, ok := e.(*writerError); ok {
+					if we, ok := e.(*writerError); !ok {
+						err = e
+					} else if w.retriable(we.cause) {
 						w.stats.retries.observe(1)
 						retry, err = append(retry, we.msg), we.err
 					} else {
-						err = e
+						// Retrying would fail again, the error is reported once
+						// the retriable messages are done.
+						failed = we.err
 					}
 				}
 			case <-ctx.Done():
@@ -359,7 +867,10 @@ func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
 			break
 		}
 
-		timer := time.NewTimer(backoff(attempt+1, 100*time.Millisecond, 1*time.Second))
+		delay := jitteredBackoff(attempt+1, w.config.RetryBackoffMin, w.config.RetryBackoffMax)
+		w.logger().Warn("retrying failed messages", "topics", w.topicsOf(msgs), "messages", len(msgs), "attempt", attempt+1, "backoff", delay, "error", err)
+
+		timer := time.NewTimer(delay)
 		select {
 		case <-timer.C:
 			// Only clear the error (so we retry the loop) if we have more retries, otherwise
@@ -382,6 +893,14 @@ func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
 	t1 := time.Now()
 	w.stats.writeTime.observeDuration(t1.Sub(t0))
 
+	if err == nil {
+		err = failed
+	}
+
+	if err == nil {
+		err = serr
+	}
+
 	return err
 }
 
@@ -393,7 +912,7 @@ func (w *Writer) WriteMessages(ctx context.Context, msgs ...Message) error {
 // call Stats on a kafka writer and report the metrics to a stats collection
 // system.
 func (w *Writer) Stats() WriterStats {
-	return WriterStats{
+	stats := WriterStats{
 		Dials:                w.stats.dials.snapshot(),
 		Writes:               w.stats.writes.snapshot(),
 		Messages:             w.stats.messages.snapshot(),
@@ -403,12 +922,18 @@ func (w *Writer) Stats() WriterStats {
 		DialTime:             w.stats.dialTime.snapshotDuration(),
 		WriteTime:            w.stats.writeTime.snapshotDuration(),
 		WaitTime:             w.stats.waitTime.snapshotDuration(),
+		Throttled:            w.stats.throttled.snapshotDuration(),
 		Retries:              w.stats.retries.snapshot(),
 		BatchSize:            w.stats.batchSize.snapshot(),
 		BatchBytes:           w.stats.batchSizeBytes.snapshot(),
+		InflightBatches:      w.stats.inflight.snapshot(),
+		UncompressedBytes:    w.stats.uncompressedBytes.snapshot(),
+		CompressedBytes:      w.stats.compressedBytes.snapshot(),
 		MaxAttempts:          int64(w.config.MaxAttempts),
 		MaxRetries:           int64(w.config.Retries),
 		RetryBackoffInterval: w.config.RetryBackoffInterval,
+		RetryBackoffMin:      w.config.RetryBackoffMin,
+		RetryBackoffMax:      w.config.RetryBackoffMax,
 		MaxBatchSize:         int64(w.config.BatchSize),
 		BatchTimeout:         w.config.BatchTimeout,
 		ReadTimeout:          w.config.ReadTimeout,
@@ -416,17 +941,43 @@ func (w *Writer) Stats() WriterStats {
 		RebalanceInterval:    w.config.RebalanceInterval,
 		RequiredAcks:         int64(w.config.RequiredAcks),
 		Async:                w.config.Async,
-		QueueLength:          int64(len(w.msgs)),
-		QueueCapacity:        int64(cap(w.msgs)),
+		QueueLength:          w.stats.pending.snapshot(),
+		QueueCapacity:        int64(w.queueCapacity()),
+		QueueFull:            w.stats.queueFull.snapshot(),
 		ClientID:             w.config.Dialer.ClientID,
 		Topic:                w.config.Topic,
 	}
+	if stats.CompressedBytes != 0 {
+		stats.CompressionRatio = float64(stats.UncompressedBytes) / float64(stats.CompressedBytes)
+	}
+	return stats
+}
+
+// queueCapacity returns the maximum number of messages held by the writer
+// reported by Stats.
+func (w *Writer) queueCapacity() int {
+	if w.config.MaxQueuedMessages != 0 {
+		return w.config.MaxQueuedMessages
+	}
+	return cap(w.msgs)
 }
 
 // Close flushes all buffered messages and closes the writer. The call to Close
 // aborts any concurrent calls to WriteMessages, which then return with the
 // io.ErrClosedPipe error.
 func (w *Writer) Close() (err error) {
+	return w.CloseWithContext(context.Background())
+}
+
+// CloseWithContext is like Close but stops waiting for the buffered messages to
+// be flushed when ctx is canceled or its deadline is exceeded, in which case it
+// returns an *UndeliveredMessagesError reporting how many messages were not
+// written yet.
+//
+// The writes in progress are then aborted, the messages which were not written
+// yet fail with the error of ctx and are reported to ErrorHandler and
+// Completion in the background after the method returned.
+func (w *Writer) CloseWithContext(ctx context.Context) error {
 	w.mutex.Lock()
 
 	if !w.closed {
@@ -436,8 +987,95 @@ func (w *Writer) Close() (err error) {
 	}
 
 	w.mutex.Unlock()
-	w.join.Wait()
-	return
+
+	done := make(chan struct{})
+	go func() {
+		w.join.Wait()
+		w.completions.Wait()
+		w.config.errors.wait()
+		w.config.events.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.config.aborts.abort(ctx.Err())
+		// The undelivered messages may belong to any topic, only the topic
+		// of the writer is logged when it has one.
+		n := int(w.stats.pending.snapshot())
+		keyvals := []interface{}{"messages", n, "error", ctx.Err()}
+		if w.config.Topic != "" {
+			keyvals = append([]interface{}{"topic", w.config.Topic}, keyvals...)
+		}
+		w.logger().Error("closing the writer before all messages were delivered", keyvals...)
+		return &UndeliveredMessagesError{Count: n, Err: ctx.Err()}
+	}
+}
+
+// complete waits for the n results of msgs written asynchronously, and reports
+// them to the Completion function of the writer, if any, before marking the
+// write as done in group.
+func (w *Writer) complete(msgs []Message, res <-chan error, n int, group *asyncWriteGroup) {
+	defer w.completions.Done()
+	defer w.async.done(group)
+
+	var err error
+	for i := 0; i != n; i++ {
+		if e := <-res; e != nil {
+			if we, ok := e.(*writerError); ok {
+				e = we.err
+			}
+			err = e
+		}
+	}
+
+	if w.config.Completion != nil {
+		w.config.Completion(msgs, err)
+	}
+}
+
+// Flush writes the messages queued by the calls to WriteMessages which
+// returned before it was called, without waiting for BatchTimeout to expire,
+// and blocks until they were written, or failed to be. In Async mode, it
+// returns after Completion was called for these messages. Messages queued by
+// concurrent calls to WriteMessages may be written early but are not waited
+// for.
+//
+// The method returns the error of ctx if it is canceled before the messages
+// were written, which keep being written in the background. In synchronous
+// mode, WriteMessages only returns once the messages were written so Flush
+// has nothing to wait for.
+func (w *Writer) Flush(ctx context.Context) error {
+	group := w.async.seal()
+	if group == nil {
+		return nil
+	}
+
+	w.config.flushes.begin()
+	defer w.config.flushes.end()
+
+	select {
+	case <-group.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retriable returns whether writing messages which failed with err may be
+// retried.
+func (w *Writer) retriable(err error) bool {
+	if w.config.RetriableErrors != nil {
+		return w.config.RetriableErrors(err)
+	}
+	return isRetriable(err)
+}
+
+// logger returns the Logger that the writer reports internal events to.
+func (w *Writer) logger() Logger {
+	return makeLogger(w.config.StructuredLogger, w.config.Logger, w.config.ErrorLogger)
 }
 
 func (w *Writer) run() {
@@ -446,58 +1084,291 @@ func (w *Writer) run() {
 	ticker := time.NewTicker(w.config.RebalanceInterval)
 	defer ticker.Stop()
 
-	var rebalance = true
-	var writers = make(map[int]partitionWriter)
-	var partitions []int
-	var err error
-
-	for {
-		if rebalance {
-			w.stats.rebalances.observe(1)
-			rebalance = false
-
-			var newPartitions []int
-			var oldPartitions = partitions
-
-			if newPartitions, err = w.partitions(); err == nil {
-				for _, partition := range diffp(oldPartitions, newPartitions) {
-					w.close(writers[partition])
-					delete(writers, partition)
-				}
+	// topics holds the partitions of the topics that messages are written to.
+	// The partitions are looked up by separate goroutines so a slow or
+	// missing topic does not hold back the messages of the other topics, the
+	// messages routed to a topic whose partitions are not known yet wait for
+	// the lookup to complete.
+	var topics = make(map[string]*writerTopic)
+	var results = make(chan writerTopicPartitions)
+	var inflight int
+
+	lookup := func(topic string, t *writerTopic) {
+		t.lookup = true
+		inflight++
+		go func() {
+			partitions, err := w.partitions(topic)
+			results <- writerTopicPartitions{topic: topic, partitions: partitions, err: err}
+		}()
+	}
 
-				for _, partition := range diffp(newPartitions, oldPartitions) {
-					writers[partition] = w.open(partition)
-				}
-				partitions = newPartitions
-			}
+	get := func(topic string) *writerTopic {
+		t, ok := topics[topic]
+		if !ok {
+			t = newWriterTopic()
+			topics[topic] = t
+			lookup(topic, t)
 		}
+		t.used = true
+		return t
+	}
 
+	w.stats.rebalances.observe(1)
+	if w.config.Topic != "" {
+		get(w.config.Topic)
+	}
+
+	for {
 		select {
 		case wm, ok := <-w.msgs:
 			if !ok {
-				for _, writer := range writers {
-					w.close(writer)
+				// Complete the lookups in progress so the messages waiting
+				// for them are routed before the writers are closed.
+				for ; inflight != 0; inflight-- {
+					w.update(topics, <-results)
+				}
+				for _, t := range topics {
+					for _, writer := range t.writers {
+						w.close(writer)
+					}
 				}
 				return
 			}
-			if len(partitions) != 0 {
-				selectedPartition := w.config.Balancer.Balance(wm.msg, partitions...)
-				writers[selectedPartition].messages() <- wm
+			if t := get(w.topicOf(wm.msg)); t.ready {
+				t.writers[w.balance(wm, t.partitions)].messages() <- wm
 			} else {
-				// No partitions were found because the topic doesn't exist.
-				if err == nil {
-					err = fmt.Errorf("failed to find any partitions for topic %s", w.config.Topic)
-				}
-				wm.res <- &writerError{msg: wm.msg, err: err}
+				t.pending = append(t.pending, wm)
 			}
 
+		case lookup := <-w.lookups:
+			if t := get(w.topicOf(lookup.msg)); t.ready {
+				lookup.res <- w.partitionFor(lookup.msg, t.partitions)
+			} else {
+				t.lookups = append(t.lookups, lookup)
+			}
+
+		case r := <-results:
+			inflight--
+			w.update(topics, r)
+
 		case <-ticker.C:
-			rebalance = true
+			w.stats.rebalances.observe(1)
+
+			// Topics which were not written to since the last tick are
+			// dropped, the others are refreshed.
+			for topic, t := range topics {
+				switch {
+				case t.lookup:
+				case !t.used && topic != w.config.Topic:
+					for _, writer := range t.writers {
+						w.close(writer)
+					}
+					delete(topics, topic)
+				default:
+					t.used = false
+					lookup(topic, t)
+				}
+			}
+		}
+	}
+}
+
+// writerTopic holds the partitions of a topic that a Writer produces to, and
+// the writers of those partitions.
+type writerTopic struct {
+	partitions []int
+	writers    map[int]partitionWriter
+
+	ready  bool // the partitions were looked up
+	lookup bool // a lookup of the partitions is in progress
+	used   bool // messages were routed to the topic since the last rebalance
+
+	// messages and partition lookups waiting for the partitions of the topic
+	pending []writerMessage
+	lookups []partitionLookup
+}
+
+func newWriterTopic() *writerTopic {
+	return &writerTopic{writers: make(map[int]partitionWriter)}
+}
+
+// writerTopicPartitions is the result of a lookup of the partitions of a topic.
+type writerTopicPartitions struct {
+	topic      string
+	partitions []int
+	err        error
+}
+
+// topicOf returns the topic that msg is written to, which is the topic of the
+// writer unless the message has one.
+func (w *Writer) topicOf(msg Message) string {
+	if msg.Topic != "" {
+		return msg.Topic
+	}
+	return w.config.Topic
+}
+
+// topicsOf returns the sorted list of the topics that msgs are written to.
+func (w *Writer) topicsOf(msgs []Message) []string {
+	var topics []string
+	for _, msg := range msgs {
+		topic := w.topicOf(msg)
+		if i := sort.SearchStrings(topics, topic); i == len(topics) || topics[i] != topic {
+			topics = append(topics, "")
+			copy(topics[i+1:], topics[i:])
+			topics[i] = topic
+		}
+	}
+	return topics
+}
+
+// update applies the result of a lookup of the partitions of a topic. The
+// writers of the partitions which were removed are closed and writers are
+// opened for the new ones, then the messages waiting for the lookup are
+// routed.
+//
+// A topic is dropped when it has no partitions, or when its first lookup
+// fails, so the next message written to it looks it up again instead of
+// failing with the same error; the messages waiting for the lookup fail. A
+// failed refresh keeps the partitions which were known.
+func (w *Writer) update(topics map[string]*writerTopic, r writerTopicPartitions) {
+	t := topics[r.topic]
+	t.lookup = false
+
+	// No partitions are found when the topic doesn't exist.
+	missing := r.err == nil && len(r.partitions) == 0
+	if missing {
+		r.err = fmt.Errorf("failed to find any partitions for topic %s", r.topic)
+	}
+
+	switch {
+	case r.err == nil:
+		for _, partition := range diffp(t.partitions, r.partitions) {
+			w.close(t.writers[partition])
+			delete(t.writers, partition)
+		}
+		for _, partition := range diffp(r.partitions, t.partitions) {
+			t.writers[partition] = w.open(r.topic, partition)
+		}
+		t.partitions, t.ready = r.partitions, true
+
+	case t.ready && !missing:
+		w.logger().Warn("failed to refresh the partitions of the topic", "topic", r.topic, "error", r.err)
+		return
+
+	default:
+		w.logger().Error("failed to look up the partitions of the topic", "topic", r.topic, "error", r.err)
+		for _, writer := range t.writers {
+			w.close(writer)
+		}
+		for _, wm := range t.pending {
+			w.stats.pending.add(-1)
+			w.config.queue.release(1)
+			w.config.errors.report([]*Message{wm.original}, r.err)
+			wm.res <- &writerError{msg: wm.msg, err: r.err, cause: r.err}
+		}
+		for _, lookup := range t.lookups {
+			lookup.res <- partitionLookupResult{partition: -1, err: r.err}
+		}
+		delete(topics, r.topic)
+		return
+	}
+
+	for _, wm := range t.pending {
+		t.writers[w.balance(wm, t.partitions)].messages() <- wm
+	}
+	for _, lookup := range t.lookups {
+		lookup.res <- w.partitionFor(lookup.msg, t.partitions)
+	}
+	t.pending, t.lookups = nil, nil
+}
+
+// PartitionFor returns the partition that msg would be written to, without
+// writing anything. The message is routed to its topic like WriteMessages
+// does, the partition is chosen by the balancer of the writer among the
+// partitions of the topic that the writer currently knows of, which are
+// refreshed every RebalanceInterval.
+//
+// Only balancers implementing PartitionLocator are supported, like Hash and
+// StickyBalancer for messages with keys, or StatelessBalancerFunc, since the
+// other balancers would be advanced by the call as if the message had been
+// written. The method fails with ErrStatefulBalancer when the balancer does not
+// implement the interface or can't tell the partition of msg, unless the topic
+// has a single partition.
+//
+// The method takes a message rather than a key so the topic, headers or value
+// of the message may be used to route it, PartitionForKey is a shorthand for
+// writers with a topic routing messages by key.
+func (w *Writer) PartitionFor(msg Message) (int, error) {
+	switch {
+	case w.config.Topic == "" && msg.Topic == "":
+		return -1, ErrMissingTopic
+	case w.config.Topic != "" && msg.Topic != "" && msg.Topic != w.config.Topic:
+		return -1, &TopicMismatchError{WriterTopic: w.config.Topic, MessageTopic: msg.Topic}
+	}
+
+	res := make(chan partitionLookupResult, 1)
+	lookup := partitionLookup{
+		msg: msg,
+		res: res,
+	}
+
+	w.mutex.RLock()
+	closed := w.closed
+	w.mutex.RUnlock()
+	if closed {
+		return -1, io.ErrClosedPipe
+	}
+
+	// the balancer is called from the goroutine of the writer, since
+	// balancers are not required to be safe to use concurrently.
+	select {
+	case w.lookups <- lookup:
+	case <-w.done:
+		return -1, io.ErrClosedPipe
+	}
+
+	r := <-res
+	return r.partition, r.err
+}
+
+// PartitionForKey returns the partition that a message with key would be
+// written to, see PartitionFor. The writer must be configured with a Topic.
+func (w *Writer) PartitionForKey(key []byte) (int, error) {
+	return w.PartitionFor(Message{Key: key})
+}
+
+// partitionFor returns the partition that msg would be written to, when the
+// balancer routes it without changing its state.
+func (w *Writer) partitionFor(msg Message, partitions []int) partitionLookupResult {
+	if len(partitions) == 1 {
+		return partitionLookupResult{partition: partitions[0]}
+	}
+	if l, ok := w.config.Balancer.(PartitionLocator); ok {
+		if p, ok := l.PartitionFor(msg, partitions...); ok {
+			return partitionLookupResult{partition: p}
 		}
 	}
+	return partitionLookupResult{partition: -1, err: ErrStatefulBalancer}
+}
+
+// balance returns the partition that wm is written to.
+//
+// The balancer is not invoked when the topic has a single partition since
+// there is nothing to choose from. The list of partitions is refreshed every
+// RebalanceInterval, so the balancer is used again once partitions are added
+// to the topic.
+func (w *Writer) balance(wm writerMessage, partitions []int) int {
+	if len(partitions) == 1 {
+		return partitions[0]
+	}
+	if wm.balancer != nil {
+		return wm.balancer.Balance(wm.msg, partitions...)
+	}
+	return w.config.Balancer.Balance(wm.msg, partitions...)
 }
 
-func (w *Writer) partitions() (partitions []int, err error) {
+func (w *Writer) partitions(topic string) (partitions []int, err error) {
 	for _, broker := range shuffledStrings(w.config.Brokers) {
 		var conn *Conn
 		var plist []Partition
@@ -507,7 +1378,7 @@ func (w *Writer) partitions() (partitions []int, err error) {
 		}
 
 		conn.SetReadDeadline(time.Now().Add(w.config.ReadTimeout))
-		plist, err = conn.ReadPartitions(w.config.Topic)
+		plist, err = conn.ReadPartitions(topic)
 		conn.Close()
 
 		if err == nil {
@@ -523,8 +1394,10 @@ func (w *Writer) partitions() (partitions []int, err error) {
 	return
 }
 
-func (w *Writer) open(partition int) partitionWriter {
-	return w.config.newPartitionWriter(partition, w.config, w.stats)
+func (w *Writer) open(topic string, partition int) partitionWriter {
+	config := w.config
+	config.Topic = topic
+	return w.config.newPartitionWriter(partition, config, w.stats)
 }
 
 func (w *Writer) close(writer partitionWriter) {
@@ -550,43 +1423,66 @@ type partitionWriter interface {
 }
 
 type writer struct {
-	brokers              []string
-	topic                string
-	partition            int
-	requiredAcks         int
-	batchSize            int
-	maxMessageBytes      int
-	retries              int
-	retryBackoffInterval time.Duration
-	batchTimeout         time.Duration
-	writeTimeout         time.Duration
-	dialer               *Dialer
-	msgs                 chan writerMessage
-	join                 sync.WaitGroup
-	stats                *writerStats
-	codec                CompressionCodec
-	logger               *log.Logger
-	errorLogger          *log.Logger
+	brokers         []string
+	topic           string
+	partition       int
+	requiredAcks    int
+	batchSize       int
+	batchGroupKey   func(Message) string
+	maxMessageBytes int
+	retries         int
+	retriable       func(error) bool
+	retryBackoffMin time.Duration
+	retryBackoffMax time.Duration
+	batchTimeout    time.Duration
+	writeTimeout    time.Duration
+	metadataRefresh time.Duration
+	idleTimeout     time.Duration
+	dialer          *Dialer
+	msgs            chan writerMessage
+	join            sync.WaitGroup
+	stats           *writerStats
+	codec           CompressionCodec
+	minCompress     int
+	measured        measuredCodec // wraps the codec of the batch being written
+	logger          Logger
+	events          *writerEvents
+	errors          *errorHandler
+	slots           *partitionSlots
+	queue           *queueLimit
+	flushes         *flushSignal
+	aborts          *abortSignal
 }
 
 func newWriter(partition int, config WriterConfig, stats *writerStats) *writer {
 	w := &writer{
-		brokers:              config.Brokers,
-		topic:                config.Topic,
-		partition:            partition,
-		requiredAcks:         config.RequiredAcks,
-		batchSize:            config.BatchSize,
-		maxMessageBytes:      config.BatchBytes,
-		batchTimeout:         config.BatchTimeout,
-		writeTimeout:         config.WriteTimeout,
-		retries:              config.Retries,
-		retryBackoffInterval: config.RetryBackoffInterval,
-		dialer:               config.Dialer,
-		msgs:                 make(chan writerMessage, config.QueueCapacity),
-		stats:                stats,
-		codec:                config.CompressionCodec,
-		logger:               config.Logger,
-		errorLogger:          config.ErrorLogger,
+		brokers:         config.Brokers,
+		topic:           config.Topic,
+		partition:       partition,
+		requiredAcks:    int(config.RequiredAcks),
+		batchSize:       config.BatchSize,
+		batchGroupKey:   config.BatchGroupKey,
+		maxMessageBytes: config.BatchBytes,
+		batchTimeout:    config.BatchTimeout,
+		writeTimeout:    config.WriteTimeout,
+		metadataRefresh: config.MetadataRefreshInterval,
+		idleTimeout:     config.IdleTimeout,
+		retries:         config.Retries,
+		retriable:       config.RetriableErrors,
+		retryBackoffMin: config.RetryBackoffMin,
+		retryBackoffMax: config.RetryBackoffMax,
+		dialer:          config.Dialer,
+		msgs:            make(chan writerMessage, config.QueueCapacity),
+		stats:           stats,
+		codec:           config.CompressionCodec,
+		minCompress:     config.MinCompressBytes,
+		logger:          makeLogger(config.StructuredLogger, config.Logger, config.ErrorLogger),
+		events:          config.events,
+		errors:          config.errors,
+		slots:           config.slots,
+		queue:           config.queue,
+		flushes:         config.flushes,
+		aborts:          config.aborts,
 	}
 	w.join.Add(1)
 	go w.run()
@@ -602,20 +1498,6 @@ func (w *writer) messages() chan<- writerMessage {
 	return w.msgs
 }
 
-func (w *writer) withLogger(do func(*log.Logger)) {
-	if w.logger != nil {
-		do(w.logger)
-	}
-}
-
-func (w *writer) withErrorLogger(do func(*log.Logger)) {
-	if w.errorLogger != nil {
-		do(w.errorLogger)
-	} else {
-		w.withLogger(do)
-	}
-}
-
 func (w *writer) run() {
 	defer w.join.Done()
 
@@ -624,26 +1506,55 @@ func (w *writer) run() {
 	batchTimerRunning := false
 	defer batchTimer.Stop()
 
+	idleTimer := time.NewTimer(0)
+	<-idleTimer.C
+	idleTimerRunning := false
+	defer idleTimer.Stop()
+
+	var metadataTick <-chan time.Time
+	if w.metadataRefresh != 0 {
+		metadataTicker := time.NewTicker(w.metadataRefresh)
+		defer metadataTicker.Stop()
+		metadataTick = metadataTicker.C
+	}
+
+	// The leader lookups are canceled when the partition writer stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var leader <-chan Broker
+
 	var conn *Conn
 	var done bool
 	var batch = make([]Message, 0, w.batchSize)
 	var resch = make([](chan<- error), 0, w.batchSize)
+	var originals = make([]*Message, 0, w.batchSize)
 	var lastMsg writerMessage
 	var batchSizeBytes int
+	var batchKey string
+	var batchCodec CompressionCodec
+	var batchStart time.Time
 
+	// When the number of connections is limited, the partition writer holds a
+	// slot while conn is not nil.
 	defer func() {
 		if conn != nil {
 			conn.Close()
+			w.slots.release()
 		}
 	}()
 
 	for !done {
 		var mustFlush bool
-		// lstMsg gets set when the next message would put the maxMessageBytes  over the limit.
+		// lstMsg gets set when the next message would put the maxMessageBytes  over the limit,
+		// or belongs to a different group than the current batch.
 		// If a lstMsg exists we need to add it to the batch so we don't lose it.
-		if len(lastMsg.msg.Value) != 0 {
+		if lastMsg.res != nil {
+			batchKey = w.groupKey(lastMsg.msg)
+			batchCodec = w.messageCodec(lastMsg)
+			batchStart = time.Now()
 			batch = append(batch, lastMsg.msg)
 			resch = append(resch, lastMsg.res)
+			originals = append(originals, lastMsg.original)
 			batchSizeBytes += int(lastMsg.msg.message().size())
 			lastMsg = writerMessage{}
 			if !batchTimerRunning {
@@ -663,8 +1574,19 @@ func (w *writer) run() {
 					lastMsg = wm
 					break
 				}
+				if key, codec := w.groupKey(wm.msg), w.messageCodec(wm); len(batch) == 0 {
+					batchKey, batchCodec, batchStart = key, codec, time.Now()
+				} else if key != batchKey || codecCode(codec) != codecCode(batchCodec) {
+					// Messages of different groups or compressed with different
+					// codecs are never written in the same batch, flush the
+					// current one first.
+					mustFlush = true
+					lastMsg = wm
+					break
+				}
 				batch = append(batch, wm.msg)
 				resch = append(resch, wm.res)
+				originals = append(originals, wm.original)
 				batchSizeBytes += int(wm.msg.message().size())
 				mustFlush = len(batch) >= w.batchSize || batchSizeBytes >= w.maxMessageBytes
 			}
@@ -676,10 +1598,40 @@ func (w *writer) run() {
 		case <-batchTimer.C:
 			mustFlush = true
 			batchTimerRunning = false
+
+		case <-w.flushes.flushing(len(batch) != 0):
+			mustFlush = true
+
+		case <-w.slots.evicted(conn != nil):
+			conn.Close()
+			conn = nil
+			w.slots.release()
+
+		case <-idleTimer.C:
+			idleTimerRunning = false
+			if conn != nil {
+				w.logger.Debug("closing idle connection", "topic", w.topic, "partition", w.partition, "broker", conn.Broker().ID)
+				conn.Close()
+				conn = nil
+				w.slots.release()
+			}
+
+		case <-metadataTick:
+			if conn != nil && leader == nil {
+				leader = w.dialer.refreshLeader(ctx, conn.RemoteAddr().Network(), w.writeTimeout, w.brokers, w.topic, w.partition)
+			}
+
+		case broker := <-leader:
+			leader = nil
+			if conn != nil && broker.ID >= 0 && broker.ID != conn.Broker().ID {
+				w.logger.Info("partition leader moved, reconnecting to the new leader", "topic", w.topic, "partition", w.partition, "broker", conn.Broker().ID)
+				conn.Close()
+				conn = nil
+				w.slots.release()
+			}
 		}
 
 		if mustFlush {
-			w.stats.batchSizeBytes.observe(int64(batchSizeBytes))
 			if batchTimerRunning {
 				if stopped := batchTimer.Stop(); !stopped {
 					<-batchTimer.C
@@ -689,27 +1641,386 @@ func (w *writer) run() {
 			if len(batch) == 0 {
 				continue
 			}
+			w.stats.batchSizeBytes.observe(int64(batchSizeBytes))
+			w.events.batch(BatchEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Bytes: int64(batchSizeBytes), Duration: time.Since(batchStart)})
 			var err error
-			if conn, err = w.write(conn, batch, resch); err != nil {
+			if conn == nil {
+				w.slots.acquire()
+			}
+			w.stats.inflight.add(1)
+			conn, err = w.write(conn, batchCodec, batch, resch, originals)
+			w.stats.inflight.add(-1)
+			if err != nil {
 				if conn != nil {
 					conn.Close()
 					conn = nil
 				}
 			}
+			if conn != nil && w.slots.contended() {
+				conn.Close()
+				conn = nil
+			}
+			if conn == nil {
+				w.slots.release()
+			} else if w.idleTimeout != 0 {
+				if idleTimerRunning && !idleTimer.Stop() {
+					<-idleTimer.C
+				}
+				idleTimer.Reset(w.idleTimeout)
+				idleTimerRunning = true
+			}
+			w.stats.pending.add(-int64(len(batch)))
+			w.queue.release(len(batch))
 			for i := range batch {
 				batch[i] = Message{}
 			}
 
 			for i := range resch {
 				resch[i] = nil
+				originals[i] = nil
 			}
 			batch = batch[:0]
 			resch = resch[:0]
+			originals = originals[:0]
 			batchSizeBytes = 0
 		}
 	}
 }
 
+// partitionSlots limits the number of partition writers holding a connection
+// at the same time. A nil *partitionSlots doesn't limit them.
+type partitionSlots struct {
+	mutex   sync.Mutex
+	free    int
+	waiters []chan struct{}
+
+	// evict holds a request to release a slot for each waiting partition
+	// writer, up to the number of slots, which are received by the idle
+	// partition writers.
+	evict chan struct{}
+}
+
+func newPartitionSlots(max int) *partitionSlots {
+	if max == 0 {
+		return nil
+	}
+	return &partitionSlots{
+		free:  max,
+		evict: make(chan struct{}, max),
+	}
+}
+
+// acquire blocks until a slot is available. Waiting partition writers are
+// queued and get the released slots in the order they called acquire, each of
+// them asks one idle partition writer to release its slot.
+func (s *partitionSlots) acquire() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.free != 0 {
+		s.free--
+		s.mutex.Unlock()
+		return
+	}
+
+	acquired := make(chan struct{})
+	s.waiters = append(s.waiters, acquired)
+	select {
+	case s.evict <- struct{}{}:
+	default:
+	}
+	s.mutex.Unlock()
+
+	<-acquired
+}
+
+// release gives the slot to the first waiting partition writer, if any.
+func (s *partitionSlots) release() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.waiters) == 0 {
+		s.free++
+	} else {
+		close(s.waiters[0])
+		s.waiters[0] = nil
+		s.waiters = s.waiters[1:]
+	}
+
+	// The slot may have been released by a partition writer which wasn't
+	// evicted, the requests exceeding the number of waiting partition writers
+	// are withdrawn so no more slots than needed are released.
+	for len(s.evict) > len(s.waiters) {
+		select {
+		case <-s.evict:
+		default:
+			return
+		}
+	}
+}
+
+// contended returns true if partition writers are waiting for a slot.
+func (s *partitionSlots) contended() bool {
+	if s == nil {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.waiters) != 0
+}
+
+// evicted returns the channel which receives a value when a partition writer
+// holding a slot should release it, or nil if held is false.
+func (s *partitionSlots) evicted(held bool) <-chan struct{} {
+	if s == nil || !held {
+		return nil
+	}
+	return s.evict
+}
+
+// asyncWrites groups the calls to WriteMessages in Async mode until a call to
+// Flush seals the group and waits for them to be done. The zero-value is ready
+// to use.
+type asyncWrites struct {
+	mutex sync.Mutex
+	group *asyncWriteGroup
+}
+
+type asyncWriteGroup struct {
+	pending int
+	sealed  bool
+	flushed chan struct{}
+}
+
+// add registers a call to WriteMessages in the current group, which it
+// returns.
+func (a *asyncWrites) add() *asyncWriteGroup {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.group == nil {
+		a.group = &asyncWriteGroup{flushed: make(chan struct{})}
+	}
+	a.group.pending++
+	return a.group
+}
+
+// done records that the messages of a call to WriteMessages registered in
+// group were all written, or failed to be.
+func (a *asyncWrites) done(group *asyncWriteGroup) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if group.pending--; group.pending == 0 && group.sealed {
+		close(group.flushed)
+	}
+}
+
+// seal returns the current group, or nil if there are no calls to wait for,
+// the calls made afterwards are registered in a new group.
+func (a *asyncWrites) seal() *asyncWriteGroup {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	group := a.group
+	if group == nil {
+		return nil
+	}
+	a.group = nil
+
+	group.sealed = true
+	if group.pending == 0 {
+		close(group.flushed)
+	}
+	return group
+}
+
+// flushSignal tells the partition writers to write their batches right away
+// while calls to Writer.Flush are in progress.
+type flushSignal struct {
+	mutex   sync.Mutex
+	active  int
+	flushed chan struct{}
+}
+
+func (s *flushSignal) begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.active++; s.active == 1 {
+		s.flushed = make(chan struct{})
+		close(s.flushed)
+	}
+}
+
+func (s *flushSignal) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.active--; s.active == 0 {
+		s.flushed = nil
+	}
+}
+
+// flushing returns a closed channel when a flush is in progress and batching
+// is true, or nil otherwise.
+func (s *flushSignal) flushing(batching bool) <-chan struct{} {
+	if s == nil || !batching {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.flushed
+}
+
+// abortSignal tells the partition writers to give up on the messages they did
+// not write yet once the context passed to Writer.CloseWithContext expired. A
+// nil *abortSignal is never aborted.
+type abortSignal struct {
+	mutex   sync.Mutex
+	err     error
+	aborted chan struct{}
+	// conns holds the connections of the writes in progress, which are
+	// interrupted when the writer is aborted.
+	conns map[*Conn]struct{}
+}
+
+func newAbortSignal() *abortSignal {
+	return &abortSignal{
+		aborted: make(chan struct{}),
+		conns:   make(map[*Conn]struct{}),
+	}
+}
+
+func (s *abortSignal) abort(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.aborted)
+	for conn := range s.conns {
+		conn.SetDeadline(time.Now())
+	}
+}
+
+// begin registers a write in progress on conn, it returns the error that the
+// writer was aborted with instead if it already was.
+func (s *abortSignal) begin(conn *Conn) error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err == nil {
+		s.conns[conn] = struct{}{}
+	}
+	return s.err
+}
+
+// end unregisters the write in progress on conn, it returns the error that the
+// writer was aborted with if it was.
+func (s *abortSignal) end(conn *Conn) error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.conns, conn)
+	return s.err
+}
+
+// sleep waits for d to elapse, it returns early with the error that the writer
+// was aborted with if it is aborted in the meantime.
+func (s *abortSignal) sleep(d time.Duration) error {
+	if s == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-s.aborted:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.err
+	}
+}
+
+// queueLimit limits the number of messages held by a writer. A nil *queueLimit
+// doesn't limit them.
+type queueLimit struct {
+	tokens chan struct{}
+}
+
+func newQueueLimit(max int) *queueLimit {
+	if max == 0 {
+		return nil
+	}
+	return &queueLimit{tokens: make(chan struct{}, max)}
+}
+
+// acquire blocks until a message fits in the queue or ctx is canceled.
+func (q *queueLimit) acquire(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	select {
+	case q.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryAcquire returns true if n messages fit in the queue, in which case they
+// are accounted for, or false without blocking.
+func (q *queueLimit) tryAcquire(n int) bool {
+	if q == nil {
+		return true
+	}
+	for i := 0; i != n; i++ {
+		select {
+		case q.tokens <- struct{}{}:
+		default:
+			q.release(i)
+			return false
+		}
+	}
+	return true
+}
+
+// release frees the room of n messages which were written or failed.
+func (q *queueLimit) release(n int) {
+	if q == nil {
+		return
+	}
+	for i := 0; i != n; i++ {
+		<-q.tokens
+	}
+}
+
+// groupKey returns the key of the batch group that msg belongs to.
+func (w *writer) groupKey(msg Message) string {
+	if w.batchGroupKey == nil {
+		return ""
+	}
+	return w.batchGroupKey(msg)
+}
+
 func (w *writer) dial() (conn *Conn, err error) {
 	for _, broker := range shuffledStrings(w.brokers) {
 		t0 := time.Now()
@@ -724,15 +2035,37 @@ func (w *writer) dial() (conn *Conn, err error) {
 	return
 }
 
-func shouldRetry(err error, maxRetries, attempts int) bool {
-	if attempts >= maxRetries {
+func (w *writer) shouldRetry(err error, attempts int) bool {
+	if attempts >= w.retries {
 		return false
 	}
-	//Retry Temporary Kafka errors and all network errors
-	if isTemporary(err) || needsReconnect(err) {
+	if w.retriable != nil {
+		return w.retriable(err)
+	}
+	return isRetriable(err)
+}
+
+// isRetriable returns whether writing messages which failed with err may
+// succeed if retried. On top of the temporary kafka errors, this includes the
+// errors that kafka documents as retriable because the cluster is moving
+// leaders or replicas around, and network errors.
+func isRetriable(err error) bool {
+	switch err {
+	case NotLeaderForPartition,
+		ReplicaNotAvailable,
+		NotCoordinatorForGroup,
+		NotController,
+		ConcurrentTransactions,
+		io.EOF,
+		io.ErrUnexpectedEOF:
 		return true
 	}
-	return false
+	// Error satisfies net.Error, so kafka errors must be told apart from the
+	// network errors first.
+	if e, ok := err.(Error); ok {
+		return e.Temporary()
+	}
+	return isTemporary(err) || needsReconnect(err)
 }
 
 func needsReconnect(err error) bool {
@@ -742,35 +2075,116 @@ func needsReconnect(err error) bool {
 	}
 	return false
 }
-func (w *writer) write(conn *Conn, batch []Message, resch [](chan<- error)) (ret *Conn, err error) {
+
+// messageCodec returns the codec that wm is written with, which is the codec
+// passed to WriteMessagesWith if any, or the codec of the writer.
+func (w *writer) messageCodec(wm writerMessage) CompressionCodec {
+	if wm.codec != nil {
+		return wm.codec
+	}
+	return w.codec
+}
+
+// codecCode returns the code of codec, which is zero when codec is nil.
+func codecCode(codec CompressionCodec) int8 {
+	if codec == nil {
+		return 0
+	}
+	return codec.Code()
+}
+
+// batchCodec returns the codec compressing batch, which is nil if the batch is
+// uncompressed or too small to be compressed.
+func (w *writer) batchCodec(codec CompressionCodec, batch []Message) CompressionCodec {
+	if codec == nil || codec == NoCompression {
+		return nil
+	}
+
+	if w.minCompress != 0 {
+		size := 0
+		for _, msg := range batch {
+			size += int(msg.message().size())
+		}
+		if size < w.minCompress {
+			return nil
+		}
+	}
+
+	w.measured = measuredCodec{CompressionCodec: codec}
+	return &w.measured
+}
+
+// measuredCodec records the sizes of the data that it compresses, which are
+// reported to the writer stats once the batch was written, so the batches
+// compressed again when they are retried are only counted once.
+type measuredCodec struct {
+	CompressionCodec
+	uncompressed int64
+	compressed   int64
+}
+
+func (c *measuredCodec) Encode(src []byte) ([]byte, error) {
+	dst, err := c.CompressionCodec.Encode(src)
+	if err == nil {
+		c.uncompressed, c.compressed = int64(len(src)), int64(len(dst))
+	}
+	return dst, err
+}
+
+// write writes batch to the partition, retrying on transient errors, and sends
+// the result of each message to resch. originals holds the messages reported
+// to Completion and ErrorHandler, when the topic uses LogAppendTime the time
+// assigned by the broker is stored in the non-nil ones before the results are
+// sent.
+func (w *writer) write(conn *Conn, codec CompressionCodec, batch []Message, resch [](chan<- error), originals []*Message) (ret *Conn, err error) {
 	t0 := time.Now()
+	var appendTime time.Time
+	codec = w.batchCodec(codec, batch)
 	attempts := 0
+	var cause error
 	for {
 		if conn == nil {
 			if conn, err = w.dial(); err != nil {
 				w.stats.errors.observe(1)
-				w.withErrorLogger(func(logger *log.Logger) {
-					logger.Printf("error dialing kafka brokers for topic %s (partition %d): %s", w.topic, w.partition, err)
-				})
-				if shouldRetry(err, w.retries, attempts) {
+				w.logger.Error("failed to dial partition leader", "topic", w.topic, "partition", w.partition, "error", err)
+				if w.shouldRetry(err, attempts) {
 					attempts = attempts + 1
 					w.stats.retries.observe(int64(attempts))
-					backoff(attempts, w.retryBackoffInterval, w.retryBackoffInterval)
-					if conn != nil {
-						conn.Close()
+					delay := jitteredBackoff(attempts, w.retryBackoffMin, w.retryBackoffMax)
+					w.events.retry(RetryEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Attempt: attempts, Backoff: delay, Err: err})
+					if err = w.aborts.sleep(delay); err == nil {
+						if conn != nil {
+							conn.Close()
+						}
+						conn = nil
+						continue
 					}
-					conn = nil
-					continue
 				}
+				w.events.error(ErrorEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Duration: time.Since(t0), Err: err})
+				w.errors.report(originals, err)
 				for i, res := range resch {
-					res <- &writerError{msg: batch[i], err: err}
+					res <- &writerError{msg: batch[i], err: err, cause: err}
 				}
 				return
 			}
 		}
 		w.stats.writes.observe(1)
-		conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
-		if _, err = conn.WriteCompressedMessages(w.codec, batch...); err != nil {
+		// The connection holds off sending the batch while the broker throttles
+		// it, which does not count against the write timeout.
+		_, throttle := conn.throttled()
+		conn.SetWriteDeadline(time.Now().Add(throttle + w.writeTimeout))
+		if err = w.aborts.begin(conn); err != nil {
+			cause = err
+			err = fmt.Errorf("error writing messages to %s (partition %d): %s", w.topic, w.partition, err)
+			break
+		}
+		_, _, _, appendTime, err = conn.WriteCompressedMessagesAt(codec, batch...)
+		if aborted := w.aborts.end(conn); err != nil && aborted != nil {
+			// The write was interrupted, or may have been, by the writer
+			// being aborted.
+			err = aborted
+		}
+		if err != nil {
 			//If we get this error, just leave now as this message will never make it.
 			// https://github.com/apache/kafka/blob/trunk/clients/src/main/java/org/apache/kafka/clients/producer/internals/Sender.java#L618
 			if err == DuplicateSequenceNumber {
@@ -778,45 +2192,67 @@ func (w *writer) write(conn *Conn, batch []Message, resch [](chan<- error)) (ret
 				break
 			}
 			w.stats.errors.observe(1)
-			if shouldRetry(err, w.retries, attempts) {
+			if w.shouldRetry(err, attempts) {
 				attempts = attempts + 1
 				w.stats.retries.observe(int64(attempts))
-				w.withErrorLogger(func(l *log.Logger) {
-					l.Printf("retrying batch due to potential transient error to %s (partition %d): %s", w.topic, w.partition, err)
-				})
-				backoff(attempts, w.retryBackoffInterval, w.retryBackoffInterval)
-				if needsReconnect(err) {
-					if conn != nil {
-						conn.Close()
+				delay := jitteredBackoff(attempts, w.retryBackoffMin, w.retryBackoffMax)
+				w.logger.Warn("retrying batch after potentially transient error", "topic", w.topic, "partition", w.partition, "attempt", attempts, "backoff", delay, "error", err)
+				w.events.retry(RetryEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Attempt: attempts, Backoff: delay, Err: err})
+				if e := w.aborts.sleep(delay); e != nil {
+					err = e
+				} else {
+					if needsReconnect(err) {
+						if conn != nil {
+							conn.Close()
+						}
+						conn = nil
 					}
-					conn = nil
+					continue
 				}
-				continue
 			}
+			cause = err
 			err = fmt.Errorf("error writing messages to %s (partition %d): %s", w.topic, w.partition, err)
 			break
 		}
 		//Successful send
+		if throttle, _ := conn.throttled(); throttle > 0 {
+			w.stats.throttled.observeDuration(throttle)
+		}
 		break
 	}
 
+	t1 := time.Now()
+
 	if err != nil {
-		w.withErrorLogger(func(logger *log.Logger) {
-			logger.Print(err)
-		})
+		w.logger.Error("failed to write batch", "topic", w.topic, "partition", w.partition, "messages", len(batch), "error", err)
+		w.events.error(ErrorEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Duration: t1.Sub(t0), Err: err})
+		w.errors.report(originals, err)
 		for i, res := range resch {
-			res <- &writerError{msg: batch[i], err: err}
+			res <- &writerError{msg: batch[i], err: err, cause: cause}
 		}
 	} else {
+		if codec != nil {
+			w.stats.uncompressedBytes.observe(w.measured.uncompressed)
+			w.stats.compressedBytes.observe(w.measured.compressed)
+		}
+		var bytes int64
 		for _, m := range batch {
 			w.stats.messages.observe(1)
 			w.stats.bytes.observe(int64(len(m.Key) + len(m.Value)))
+			bytes += int64(len(m.Key) + len(m.Value))
+		}
+		w.events.write(WriteEvent{Topic: w.topic, Partition: w.partition, Messages: len(batch), Bytes: bytes, Duration: t1.Sub(t0)})
+		if !appendTime.IsZero() {
+			for _, m := range originals {
+				if m != nil {
+					m.Time = appendTime
+				}
+			}
 		}
 		for _, res := range resch {
 			res <- nil
 		}
 	}
-	t1 := time.Now()
 	w.stats.waitTime.observeDuration(t1.Sub(t0))
 	w.stats.batchSize.observe(int64(len(batch)))
 
@@ -824,14 +2260,86 @@ func (w *writer) write(conn *Conn, batch []Message, resch [](chan<- error)) (ret
 	return
 }
 
+type partitionLookup struct {
+	msg Message
+	res chan<- partitionLookupResult
+}
+
+type partitionLookupResult struct {
+	partition int
+	err       error
+}
+
 type writerMessage struct {
 	msg Message
 	res chan<- error
+
+	// codec overrides the codec of the writer when it is not nil.
+	codec CompressionCodec
+
+	// balancer overrides the balancer of the writer when it is not nil.
+	balancer Balancer
+
+	// original is the copy of the message passed to WriteMessages which is
+	// reported to Completion and ErrorHandler, when it is not nil. It receives
+	// the log append time of the message when the topic uses LogAppendTime,
+	// before the result of the message is sent to res.
+	original *Message
+}
+
+// ErrMissingTopic is returned by WriteMessages when the writer has no topic and
+// one of the messages does not set its Topic field.
+var ErrMissingTopic = errors.New("kafka: messages must set their topic when the writer has none")
+
+// ErrStatefulBalancer is returned by Writer.PartitionFor when the partition of
+// a message depends on the state of the balancer of the writer, or when the
+// balancer does not implement PartitionLocator.
+var ErrStatefulBalancer = errors.New("kafka: the partition of the message depends on the state of the balancer of the writer")
+
+// TopicMismatchError is returned by WriteMessages when a message sets a Topic
+// which differs from the topic of the writer.
+type TopicMismatchError struct {
+	// WriterTopic is the topic that the writer was configured with.
+	WriterTopic string
+
+	// MessageTopic is the topic set on the message.
+	MessageTopic string
+}
+
+func (e *TopicMismatchError) Error() string {
+	return fmt.Sprintf("kafka: message topic %q does not match the topic %q of the writer", e.MessageTopic, e.WriterTopic)
+}
+
+// ErrQueueFull is returned by WriteMessages when the messages don't fit within
+// the MaxQueuedMessages of a writer configured with ErrorOnFullQueue. It is
+// always returned when the call writes more messages than MaxQueuedMessages.
+var ErrQueueFull = errors.New("kafka: the queue of the writer is full")
+
+// UndeliveredMessagesError is returned by Writer.CloseWithContext when the
+// context expired before all the buffered messages were written.
+type UndeliveredMessagesError struct {
+	// Count is the number of messages which were neither written nor failed to
+	// be when the context expired.
+	Count int
+
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *UndeliveredMessagesError) Cause() error {
+	return e.Err
+}
+
+func (e *UndeliveredMessagesError) Error() string {
+	return fmt.Sprintf("kafka writer closed with %d undelivered messages: %v", e.Count, e.Err)
 }
 
 type writerError struct {
 	msg Message
 	err error
+	// The error that writing the message failed with, before it was wrapped
+	// into err.
+	cause error
 }
 
 func (e *writerError) Cause() error {
@@ -843,11 +2351,11 @@ func (e *writerError) Error() string {
 }
 
 func (e *writerError) Temporary() bool {
-	return isTemporary(e.err)
+	return isTemporary(e.cause)
 }
 
 func (e *writerError) Timeout() bool {
-	return isTimeout(e.err)
+	return isTimeout(e.cause)
 }
 
 func shuffledStrings(list []string) []string {
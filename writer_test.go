@@ -1,11 +1,19 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -47,6 +55,22 @@ func TestWriter(t *testing.T) {
 			scenario: "writing messsages with a small batch byte size",
 			function: testWriterSmallBatchBytes,
 		},
+		{
+			scenario: "writing messages with different batch group keys",
+			function: testWriterBatchGroupKey,
+		},
+		{
+			scenario: "writing messages without requiring acks",
+			function: testWriterRequireNone,
+		},
+		{
+			scenario: "writing messages invokes the batch and write hooks",
+			function: testWriterEvents,
+		},
+		{
+			scenario: "closing a writer with a context reports the messages which were not delivered",
+			function: testWriterCloseWithContext,
+		},
 	}
 
 	for _, test := range tests {
@@ -161,6 +185,56 @@ func (f *fakeWriter) close() {
 
 }
 
+// stuckWriter never writes the messages it receives, until released.
+type stuckWriter struct {
+	msgs    chan writerMessage
+	release chan struct{}
+}
+
+func (s *stuckWriter) messages() chan<- writerMessage {
+	return s.msgs
+}
+
+func (s *stuckWriter) close() {
+	<-s.release
+}
+
+func testWriterCloseWithContext(t *testing.T) {
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	stuck := &stuckWriter{msgs: make(chan writerMessage, 10), release: make(chan struct{})}
+	defer close(stuck.release)
+
+	w := newTestWriter(WriterConfig{
+		Topic: topic,
+		Async: true,
+		newPartitionWriter: func(p int, config WriterConfig, stats *writerStats) partitionWriter {
+			return stuck
+		},
+	})
+
+	msgs := []Message{{Value: []byte("A")}, {Value: []byte("B")}, {Value: []byte("C")}}
+	if err := w.WriteMessages(context.Background(), msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := w.CloseWithContext(ctx)
+	undelivered, ok := err.(*UndeliveredMessagesError)
+	if !ok {
+		t.Fatalf("expected an *UndeliveredMessagesError; got %v", err)
+	}
+	if undelivered.Count != len(msgs) {
+		t.Errorf("expected %d undelivered messages; got %d", len(msgs), undelivered.Count)
+	}
+	if undelivered.Err != context.DeadlineExceeded {
+		t.Errorf("expected %v; got %v", context.DeadlineExceeded, undelivered.Err)
+	}
+}
+
 func testWriterMaxAttemptsErr(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -411,6 +485,52 @@ func testWriterSmallBatchBytes(t *testing.T) {
 	}
 }
 
+func testWriterBatchGroupKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+	offset, err := readOffset(topic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newTestWriter(WriterConfig{
+		Topic:         topic,
+		BatchSize:     4,
+		BatchTimeout:  500 * time.Millisecond,
+		BatchGroupKey: func(m Message) string { return string(m.Key) },
+		Balancer:      &RoundRobin{},
+	})
+	defer w.Close()
+
+	if err := w.WriteMessages(ctx, []Message{
+		Message{Key: []byte("A"), Value: []byte("1")},
+		Message{Key: []byte("A"), Value: []byte("2")},
+		Message{Key: []byte("B"), Value: []byte("3")},
+		Message{Key: []byte("B"), Value: []byte("4")},
+	}...); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if writes := w.Stats().Writes; writes != 2 {
+		t.Error("expected one batch per group key, got", writes, "writes")
+		return
+	}
+	msgs, err := readPartition(topic, 0, offset)
+
+	if err != nil {
+		t.Error("error reading partition", err)
+		return
+	}
+
+	if len(msgs) != 4 {
+		t.Error("bad messages in partition", msgs)
+	}
+}
+
 func testIntWriterRetryErr(t *testing.T) {
 	//ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	//defer cancel()
@@ -441,7 +561,7 @@ func testIntWriterRetryErr(t *testing.T) {
 		Message{Value: []byte("CantFindMe")},
 	}
 
-	_, err = w.write(nil, failedBatch, errc)
+	_, err = w.write(nil, w.codec, failedBatch, errc, nil)
 	if err == nil {
 		t.Error("expected error, got nothing")
 	}
@@ -454,9 +574,9 @@ func testIntWriterRetryErr(t *testing.T) {
 	// We'll use that good connection at the end to create
 	// a new nother bad test.
 	w.brokers = []string{"localhost:9092"}
-	gcnn, err := w.write(nil, []Message{
+	gcnn, err := w.write(nil, w.codec, []Message{
 		Message{Value: []byte("FindMe")},
-	}, errc)
+	}, errc, nil)
 	if err != nil {
 		t.Error("expected no error, got error: ", err)
 	}
@@ -476,9 +596,9 @@ func testIntWriterRetryErr(t *testing.T) {
 	}
 
 	w.writeTimeout = 0 * time.Second
-	_, err = w.write(gcnn, []Message{
+	_, err = w.write(gcnn, w.codec, []Message{
 		Message{Value: []byte("BadBroker")},
-	}, errc)
+	}, errc, nil)
 	if err == nil {
 		t.Error("expected error, got nothing")
 	}
@@ -487,3 +607,1516 @@ func testIntWriterRetryErr(t *testing.T) {
 		t.Error("Expect retries to be equal to retry count")
 	}
 }
+
+func testWriterRequireNone(t *testing.T) {
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	w := newTestWriter(WriterConfig{
+		Topic:         topic,
+		FireAndForget: true,
+	})
+	defer w.Close()
+
+	if err := w.WriteMessages(context.Background(), makeTestSequence(3)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if acks := w.Stats().RequiredAcks; acks != 0 {
+		t.Errorf("expected the required acks stat to be 0; got %d", acks)
+	}
+
+	// the write only waited for the request to be sent, the messages may not
+	// be visible right away
+	var msgs []Message
+	for attempt := 0; attempt != 10 && len(msgs) != 3; attempt++ {
+		time.Sleep(100 * time.Millisecond)
+
+		var err error
+		if msgs, err = readPartition(topic, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(msgs) != 3 {
+		t.Errorf("expected 3 messages in the partition; got %d", len(msgs))
+	}
+}
+
+func testWriterEvents(t *testing.T) {
+	topic := makeTopic()
+	createTopic(t, topic, 1)
+
+	batches := make(chan BatchEvent, 10)
+	writes := make(chan WriteEvent, 10)
+
+	w := newTestWriter(WriterConfig{
+		Topic:     topic,
+		BatchSize: 3,
+		OnBatch:   func(e BatchEvent) { batches <- e },
+		OnWrite:   func(e WriteEvent) { writes <- e },
+	})
+
+	if err := w.WriteMessages(context.Background(), makeTestSequence(3)...); err != nil {
+		t.Fatal(err)
+	}
+
+	// flushes the events
+	w.Close()
+
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch event; got %d", len(batches))
+	}
+	if e := <-batches; e.Topic != topic || e.Partition != 0 || e.Messages != 3 || e.Bytes == 0 {
+		t.Errorf("unexpected batch event: %+v", e)
+	}
+
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 write event; got %d", len(writes))
+	}
+	if e := <-writes; e.Topic != topic || e.Partition != 0 || e.Messages != 3 || e.Bytes != 3 || e.Duration == 0 {
+		t.Errorf("unexpected write event: %+v", e)
+	}
+}
+
+func TestWriterRequiredAcks(t *testing.T) {
+	tests := []struct {
+		config   WriterConfig
+		expected int64
+	}{
+		{config: WriterConfig{}, expected: -1},
+		{config: WriterConfig{RequiredAcks: RequireAll}, expected: -1},
+		{config: WriterConfig{RequiredAcks: RequireOne}, expected: 1},
+		{config: WriterConfig{RequiredAcks: RequireOne, FireAndForget: true}, expected: 0},
+	}
+
+	for _, test := range tests {
+		test.config.Brokers = []string{"localhost:9092"}
+		test.config.Topic = "test"
+		w := NewWriter(test.config)
+		if acks := w.Stats().RequiredAcks; acks != test.expected {
+			t.Errorf("%+v: expected %d acks; got %d", test.config, test.expected, acks)
+		}
+		w.Close()
+	}
+
+	// Like Conn.Produce, which returns InvalidRequiredAcks, writers reject the
+	// values which are not acknowledgement modes of the protocol.
+	for _, acks := range []RequiredAcks{2, -5} {
+		t.Run(strconv.Itoa(int(acks)), func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected NewWriter to panic with RequiredAcks %d", acks)
+				}
+			}()
+			NewWriter(WriterConfig{Brokers: []string{"localhost:9092"}, Topic: "test", RequiredAcks: acks}).Close()
+		})
+	}
+}
+
+type countingBalancer struct {
+	Balancer
+	calls int
+}
+
+func (b *countingBalancer) Balance(msg Message, partitions ...int) int {
+	b.calls++
+	return b.Balancer.Balance(msg, partitions...)
+}
+
+func TestWriterBalanceSinglePartition(t *testing.T) {
+	balancer := &countingBalancer{Balancer: &RoundRobin{}}
+	w := &Writer{config: WriterConfig{Balancer: balancer}}
+
+	for i := 0; i != 3; i++ {
+		if p := w.balance(writerMessage{}, []int{2}); p != 2 {
+			t.Errorf("expected messages to be written to the only partition; got %d", p)
+		}
+	}
+
+	if balancer.calls != 0 {
+		t.Errorf("expected the balancer not to be called with a single partition; got %d calls", balancer.calls)
+	}
+
+	// partitions were added to the topic
+	w.balance(writerMessage{}, []int{2, 3})
+
+	if balancer.calls != 1 {
+		t.Errorf("expected the balancer to be called once partitions were added; got %d calls", balancer.calls)
+	}
+}
+
+func TestWriterRetryBackoff(t *testing.T) {
+	const min = 100 * time.Millisecond
+	const max = 1 * time.Second
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1 * time.Second,
+		1 * time.Second,
+	}
+
+	var bound time.Duration
+	for i, d := range expected {
+		if found := exponentialBackoff(i+1, min, max); found != d {
+			t.Errorf("attempt %d: expected backoff of %s; got %s", i+1, d, found)
+		}
+		bound += d
+	}
+
+	for i := 0; i != 100; i++ {
+		var total time.Duration
+		for attempt := 1; attempt <= len(expected); attempt++ {
+			d := jitteredBackoff(attempt, min, max)
+			if d < 0 || d > expected[attempt-1] {
+				t.Fatalf("attempt %d: backoff of %s out of bounds [0, %s]", attempt, d, expected[attempt-1])
+			}
+			total += d
+		}
+		if total > bound {
+			t.Fatalf("total retry delay of %s exceeds %s", total, bound)
+		}
+	}
+}
+
+func TestWriterMaxMessageBytes(t *testing.T) {
+	w := NewWriter(WriterConfig{
+		Brokers:         []string{"localhost:9092"},
+		Topic:           "test",
+		MaxMessageBytes: 10,
+	})
+	defer w.Close()
+
+	msgs := []Message{
+		{Key: []byte("key"), Value: []byte("value")},
+		{Key: []byte("key"), Value: []byte("value"), Headers: []Header{{Key: "h", Value: []byte("v")}}},
+	}
+
+	if size := msgs[1].size(); size != 10 {
+		t.Fatalf("expected the size of the message to include the headers; got %d", size)
+	}
+
+	msgs[1].Value = []byte("values")
+
+	if err := w.WriteMessages(context.Background(), msgs...); err != MessageSizeTooLarge {
+		t.Fatalf("expected %v; got %v", MessageSizeTooLarge, err)
+	}
+
+	if n := len(w.msgs); n != 0 {
+		t.Errorf("expected no messages to be queued; got %d", n)
+	}
+
+	if errors := w.Stats().Errors; errors != 1 {
+		t.Errorf("expected 1 error; got %d", errors)
+	}
+}
+
+func TestWriterMinCompressBytes(t *testing.T) {
+	stats := &writerStats{}
+	w := &writer{
+		codec:       testGzipCodec{code: 5},
+		minCompress: 100,
+		stats:       stats,
+	}
+
+	small := []Message{{Value: []byte("small")}}
+	if codec := w.batchCodec(w.codec, small); codec != nil {
+		t.Errorf("expected a single small message not to be compressed; got %T", codec)
+	}
+
+	large := makeTestSequence(20)
+	codec := w.batchCodec(w.codec, large)
+	if codec == nil {
+		t.Fatal("expected a batch larger than MinCompressBytes to be compressed")
+	}
+	if code := codec.Code(); code != 5 {
+		t.Errorf("expected the codec of the writer to be used; got code %d", code)
+	}
+
+	if codec != w.batchCodec(w.codec, large) {
+		t.Error("expected the codec wrapper to be reused across batches")
+	}
+}
+
+func TestWriterCompressionStats(t *testing.T) {
+	codec := testGzipCodec{code: 5}
+	defer registerTestCodec(codec)()
+
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The first produce request fails, the batch is compressed again when it
+	// is retried.
+	var produced int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce && atomic.AddInt32(&produced, 1) == 1 {
+			return MockResponse{Error: NotEnoughReplicas}
+		}
+		return MockResponse{}
+	})
+
+	w := NewWriter(WriterConfig{
+		Brokers:          []string{broker.Addr()},
+		Topic:            "test",
+		BatchTimeout:     10 * time.Millisecond,
+		CompressionCodec: codec,
+		RetryBackoffMin:  time.Millisecond,
+	})
+	defer w.Close()
+
+	value := bytes.Repeat([]byte("A"), 1000)
+	if err := w.WriteMessages(context.Background(), Message{Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&produced); n != 2 {
+		t.Fatalf("expected the batch to be produced twice; got %d produce requests", n)
+	}
+
+	s := w.Stats()
+	if s.UncompressedBytes <= int64(len(value)) || s.UncompressedBytes > 2*int64(len(value)) {
+		t.Errorf("expected the batch to be counted once; got %d uncompressed bytes", s.UncompressedBytes)
+	}
+	if ratio := float64(s.UncompressedBytes) / float64(s.CompressedBytes); s.CompressionRatio != ratio {
+		t.Errorf("expected a compression ratio of %g; got %g", ratio, s.CompressionRatio)
+	}
+}
+
+func TestWriterWriteMessagesWith(t *testing.T) {
+	codec := testGzipCodec{code: 5}
+	defer registerTestCodec(codec)()
+
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	w := NewWriter(WriterConfig{
+		Brokers:          []string{broker.Addr()},
+		Topic:            "test",
+		BatchTimeout:     50 * time.Millisecond,
+		CompressionCodec: codec,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	uncompressed := WriteOptions{CompressionCodec: NoCompression}
+	if err := w.WriteMessagesWith(ctx, uncompressed, Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Stats(); s.Writes != 1 || s.UncompressedBytes != 0 {
+		t.Errorf("expected 1 uncompressed write; got %d writes and %d compressed bytes", s.Writes, s.UncompressedBytes)
+	}
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("B")}); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.Stats(); s.Writes != 1 || s.UncompressedBytes == 0 {
+		t.Errorf("expected 1 compressed write; got %d writes and %d compressed bytes", s.Writes, s.UncompressedBytes)
+	}
+
+	// Messages written concurrently with different codecs are written in
+	// separate batches.
+	var wg sync.WaitGroup
+	for i, opts := range []WriteOptions{uncompressed, {}} {
+		wg.Add(1)
+		go func(i int, opts WriteOptions) {
+			defer wg.Done()
+			if err := w.WriteMessagesWith(ctx, opts, Message{Value: []byte(strconv.Itoa(i))}); err != nil {
+				t.Error(err)
+			}
+		}(i, opts)
+	}
+	wg.Wait()
+
+	if s := w.Stats(); s.Writes != 2 {
+		t.Errorf("expected 2 writes; got %d", s.Writes)
+	}
+	if n := len(broker.Messages("test", 0)); n != 4 {
+		t.Errorf("expected 4 messages to be written; got %d", n)
+	}
+}
+
+func TestWriterMaxQueuedMessages(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The broker doesn't respond to produce requests until unblock is closed,
+	// so the messages pile up in the writers.
+	unblock := make(chan struct{})
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			<-unblock
+		}
+		return MockResponse{}
+	})
+
+	newWriter := func(errorOnFullQueue bool) *Writer {
+		return NewWriter(WriterConfig{
+			Brokers:           []string{broker.Addr()},
+			Topic:             "test",
+			BatchSize:         1,
+			Async:             true,
+			MaxQueuedMessages: 2,
+			ErrorOnFullQueue:  errorOnFullQueue,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	blocking := newWriter(false)
+	failing := newWriter(true)
+
+	for _, w := range []*Writer{blocking, failing} {
+		if err := w.WriteMessages(ctx, Message{Value: []byte("A")}, Message{Value: []byte("B")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := failing.WriteMessages(ctx, Message{Value: []byte("C")}); err != ErrQueueFull {
+		t.Errorf("expected %v; got %v", ErrQueueFull, err)
+	}
+
+	// Calls writing more messages than the limit never fit, even when the
+	// queue is empty.
+	oversized := newWriter(true)
+	abc := []Message{{Value: []byte("A")}, {Value: []byte("B")}, {Value: []byte("C")}}
+	if err := oversized.WriteMessages(ctx, abc...); err != ErrQueueFull {
+		t.Errorf("expected %v; got %v", ErrQueueFull, err)
+	}
+	if s := oversized.Stats(); s.QueueLength != 0 {
+		t.Errorf("expected no messages to be queued; got %d", s.QueueLength)
+	}
+	if err := oversized.Close(); err != nil {
+		t.Error(err)
+	}
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	if err := blocking.WriteMessages(shortCtx, Message{Value: []byte("C")}); err != context.DeadlineExceeded {
+		t.Errorf("expected the write to block until the deadline; got %v", err)
+	}
+
+	for _, w := range []*Writer{blocking, failing} {
+		s := w.Stats()
+		if s.QueueLength != 2 || s.QueueCapacity != 2 || s.QueueFull != 1 {
+			t.Errorf("expected a queue of 2/2 messages which was full once; got %d/%d and %d", s.QueueLength, s.QueueCapacity, s.QueueFull)
+		}
+	}
+
+	close(unblock)
+	for _, w := range []*Writer{blocking, failing} {
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if n := len(broker.Messages("test", 0)); n != 4 {
+		t.Errorf("expected 4 messages to be written; got %d", n)
+	}
+}
+
+func TestWriterBatchStats(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 2)
+
+	// The broker doesn't respond to produce requests until unblock is closed,
+	// so the batches of both partitions stay in flight.
+	unblock := make(chan struct{})
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			<-unblock
+		}
+		return MockResponse{}
+	})
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchSize:    2,
+		BatchTimeout: time.Second,
+		Async:        true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	msgs := []Message{
+		{Value: []byte("A")},
+		{Value: []byte("B")},
+		{Value: []byte("C")},
+		{Value: []byte("D")},
+	}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	for w.stats.inflight.snapshot() != 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the batches to be in flight")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	s := w.Stats()
+	if s.InflightBatches != 2 {
+		t.Errorf("expected 2 batches in flight; got %d", s.InflightBatches)
+	}
+	batchBytes := 2 * int64(msgs[0].message().size())
+	if s.BatchBytes != (SummaryStats{Avg: batchBytes, Min: batchBytes, Max: batchBytes}) {
+		t.Errorf("expected batches of %d bytes; got %+v", batchBytes, s.BatchBytes)
+	}
+
+	close(unblock)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = w.Stats()
+	if s.InflightBatches != 0 {
+		t.Errorf("expected no batches in flight; got %d", s.InflightBatches)
+	}
+	if s.BatchSize != (SummaryStats{Avg: 2, Min: 2, Max: 2}) {
+		t.Errorf("expected batches of 2 messages; got %+v", s.BatchSize)
+	}
+}
+
+func TestWriterThrottledStats(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	const throttle = 50 * time.Millisecond
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			return MockResponse{ThrottleTime: throttle}
+		}
+		return MockResponse{}
+	})
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := w.Stats(); stats.Throttled != (DurationStats{Avg: throttle, Min: throttle, Max: throttle}) {
+		t.Errorf("expected the writer to report a throttle time of %s; got %+v", throttle, stats.Throttled)
+	}
+}
+
+func TestWriterAsyncCompletion(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	type completion struct {
+		messages []Message
+		err      error
+	}
+
+	write := func(msgs ...Message) []completion {
+		var mutex sync.Mutex
+		var completions []completion
+
+		w := NewWriter(WriterConfig{
+			Brokers:      []string{broker.Addr()},
+			Topic:        "test",
+			BatchTimeout: 10 * time.Millisecond,
+			Async:        true,
+			Completion: func(messages []Message, err error) {
+				mutex.Lock()
+				completions = append(completions, completion{messages, err})
+				mutex.Unlock()
+			},
+		})
+
+		if err := w.WriteMessages(context.Background(), msgs...); err != nil {
+			t.Fatal(err)
+		}
+		// Close waits for the messages to be written and Completion to be
+		// called.
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return completions
+	}
+
+	msgs := []Message{{Value: []byte("A")}, {Value: []byte("B")}, {Value: []byte("C")}}
+
+	completions := write(msgs...)
+	if len(completions) != 1 {
+		t.Fatalf("expected Completion to be called once; got %d calls", len(completions))
+	}
+	if c := completions[0]; c.err != nil || len(c.messages) != len(msgs) {
+		t.Errorf("expected %d messages to be written; got %d messages and error %v", len(msgs), len(c.messages), c.err)
+	}
+	if n := len(broker.Messages("test", 0)); n != len(msgs) {
+		t.Errorf("expected %d messages to be written; got %d", len(msgs), n)
+	}
+
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			return MockResponse{Error: InvalidTopic}
+		}
+		return MockResponse{}
+	})
+
+	completions = write(msgs...)
+	if len(completions) != 1 {
+		t.Fatalf("expected Completion to be called once; got %d calls", len(completions))
+	}
+	if c := completions[0]; c.err == nil || !strings.Contains(c.err.Error(), InvalidTopic.Error()) {
+		t.Errorf("expected the messages to fail with %v; got %v", InvalidTopic, c.err)
+	}
+}
+
+func TestWriterAsyncCompletionLogAppendTime(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("create-time", 1)
+	broker.CreateTopic("log-append-time", 1)
+	broker.SetLogAppendTime("log-append-time")
+
+	write := func(topic string, msgs ...Message) []Message {
+		var completed []Message
+
+		w := NewWriter(WriterConfig{
+			Brokers:      []string{broker.Addr()},
+			Topic:        topic,
+			BatchTimeout: 10 * time.Millisecond,
+			Async:        true,
+			Completion: func(messages []Message, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				completed = messages
+			},
+		})
+
+		if err := w.WriteMessages(context.Background(), msgs...); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return completed
+	}
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	msgs := []Message{{Value: []byte("A"), Time: past}, {Value: []byte("B"), Time: past}}
+
+	completed := write("create-time", msgs...)
+	if len(completed) != len(msgs) {
+		t.Fatalf("expected %d messages to be completed; got %d", len(msgs), len(completed))
+	}
+	for i, msg := range completed {
+		if !msg.Time.Equal(past) {
+			t.Errorf("message %d: expected the time of the message %s to be kept; got %s", i, past, msg.Time)
+		}
+	}
+
+	completed = write("log-append-time", msgs...)
+	if len(completed) != len(msgs) {
+		t.Fatalf("expected %d messages to be completed; got %d", len(msgs), len(completed))
+	}
+	written := broker.Messages("log-append-time", 0)
+	if len(written) != len(msgs) {
+		t.Fatalf("expected %d messages to be written; got %d", len(msgs), len(written))
+	}
+	for i, msg := range completed {
+		if !msg.Time.Equal(written[i].Time) || msg.Time.Equal(past) {
+			t.Errorf("message %d: expected the log append time %s; got %s", i, written[i].Time, msg.Time)
+		}
+	}
+	for i, msg := range msgs {
+		if !msg.Time.Equal(past) {
+			t.Errorf("message %d: expected the messages passed to WriteMessages to be left unchanged; got %s", i, msg.Time)
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	var completed []Message
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchTimeout: time.Hour,
+		Async:        true,
+		Completion: func(messages []Message, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			completed = messages
+		},
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal("flushing a writer without messages failed:", err)
+	}
+
+	msgs := []Message{{Value: []byte("A")}, {Value: []byte("B")}, {Value: []byte("C")}}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch is written without waiting for BatchTimeout, and Completion
+	// was called when Flush returns.
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(broker.Messages("test", 0)); n != len(msgs) {
+		t.Errorf("expected %d messages to be written; got %d", len(msgs), n)
+	}
+	if len(completed) != len(msgs) {
+		t.Fatalf("expected %d messages to be completed; got %d", len(msgs), len(completed))
+	}
+	if &completed[0] == &msgs[0] {
+		t.Error("expected Completion to receive a copy of the messages passed to WriteMessages")
+	}
+}
+
+func TestWriterMaxOpenPartitions(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	const partitions = 8
+	broker.CreateTopic("test", partitions)
+
+	w := NewWriter(WriterConfig{
+		Brokers:           []string{broker.Addr()},
+		Topic:             "test",
+		BatchSize:         5,
+		BatchTimeout:      time.Millisecond,
+		MaxOpenPartitions: 2,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	msgs := make([]Message, 200)
+	for i := range msgs {
+		msgs[i].Value = []byte(strconv.Itoa(i))
+	}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	written := 0
+	for p := 0; p < partitions; p++ {
+		last := -1
+		for _, m := range broker.Messages("test", p) {
+			v, _ := strconv.Atoi(string(m.Value))
+			if v <= last {
+				t.Errorf("message %d written after message %d to partition %d", v, last, p)
+			}
+			last = v
+			written++
+		}
+	}
+	if written != len(msgs) {
+		t.Errorf("expected %d messages to be written; got %d", len(msgs), written)
+	}
+}
+
+func TestPartitionSlots(t *testing.T) {
+	s := newPartitionSlots(2)
+	s.acquire()
+	s.acquire()
+
+	if s.contended() {
+		t.Error("expected the slots not to be contended")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire()
+		close(acquired)
+	}()
+
+	// The partition writers holding the slots are asked to release one.
+	select {
+	case <-s.evicted(true):
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the eviction of a slot")
+	}
+	if !s.contended() {
+		t.Error("expected the slots to be contended")
+	}
+	if s.evicted(false) != nil {
+		t.Error("expected partition writers without a slot not to be evicted")
+	}
+
+	s.release()
+	select {
+	case <-acquired:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the slot to be acquired")
+	}
+}
+
+func TestPartitionSlotsQueue(t *testing.T) {
+	s := newPartitionSlots(2)
+	s.acquire()
+	s.acquire()
+
+	// The waiting partition writers are queued one after the other so the
+	// order in which they acquire the slots is deterministic.
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			s.acquire()
+			order <- i
+		}(i)
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			s.mutex.Lock()
+			n := len(s.waiters)
+			s.mutex.Unlock()
+			if n == i+1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for the partition writer to be queued")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Only as many partition writers as there are slots are asked to release
+	// theirs.
+	if n := len(s.evict); n != 2 {
+		t.Errorf("expected 2 evictions; got %d", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.release()
+		select {
+		case n := <-order:
+			if n != i {
+				t.Errorf("expected partition writer %d to acquire the slot; got %d", i, n)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the slot to be acquired")
+		}
+	}
+
+	// The eviction requests are withdrawn once no partition writers wait.
+	if n := len(s.evict); n != 0 {
+		t.Errorf("expected no evictions; got %d", n)
+	}
+	if s.contended() {
+		t.Error("expected the slots not to be contended")
+	}
+}
+
+func TestWriterRetriableErrors(t *testing.T) {
+	tests := []struct {
+		scenario  string
+		err       Error
+		retriable func(error) bool
+		attempts  int32
+	}{
+		{
+			scenario: "retriable errors are retried",
+			err:      NotLeaderForPartition,
+			attempts: 3,
+		},
+		{
+			scenario: "non-retriable errors are not retried",
+			err:      RecordListTooLarge,
+			attempts: 1,
+		},
+		{
+			scenario:  "the classification of errors can be overridden",
+			err:       RecordListTooLarge,
+			retriable: func(err error) bool { return err == RecordListTooLarge },
+			attempts:  3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			broker, err := NewMockBroker()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer broker.Close()
+			broker.CreateTopic("test", 1)
+
+			var attempts int32
+			broker.OnRequest(func(req MockRequest) MockResponse {
+				if req.API == MockProduce {
+					atomic.AddInt32(&attempts, 1)
+					return MockResponse{Error: test.err}
+				}
+				return MockResponse{}
+			})
+
+			w := NewWriter(WriterConfig{
+				Brokers:         []string{broker.Addr()},
+				Topic:           "test",
+				MaxAttempts:     3,
+				BatchTimeout:    10 * time.Millisecond,
+				RetryBackoffMin: time.Millisecond,
+				RetryBackoffMax: time.Millisecond,
+				RetriableErrors: test.retriable,
+			})
+			defer w.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			err = w.WriteMessages(ctx, Message{Value: []byte("hello")})
+			if err == nil || !strings.Contains(err.Error(), test.err.Error()) {
+				t.Errorf("expected an error containing %q; got %v", test.err, err)
+			}
+			if n := atomic.LoadInt32(&attempts); n != test.attempts {
+				t.Errorf("expected %d attempts; got %d", test.attempts, n)
+			}
+		})
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retriable bool
+	}{
+		{err: LeaderNotAvailable, retriable: true},
+		{err: NotLeaderForPartition, retriable: true},
+		{err: NotController, retriable: true},
+		{err: RecordListTooLarge, retriable: false},
+		{err: io.EOF, retriable: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, retriable: true},
+		{err: errors.New("failed to find any partitions"), retriable: false},
+	}
+
+	for _, test := range tests {
+		if retriable := isRetriable(test.err); retriable != test.retriable {
+			t.Errorf("%v: expected retriable to be %t; got %t", test.err, test.retriable, retriable)
+		}
+	}
+
+	// The errors that are only retried by the writer must not change what
+	// Error.Temporary reports.
+	if NotLeaderForPartition.Temporary() {
+		t.Error("NotLeaderForPartition must not be temporary")
+	}
+}
+
+func TestWriterMessageTime(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The messages are written in a single batch, with timestamps which are
+	// not in order and more than a second apart from the first one.
+	past := time.Date(2015, time.March, 14, 15, 9, 26, 535000000, time.UTC)
+	times := []time.Time{
+		past,
+		past.Add(-90 * time.Minute),
+		past.Add(3*time.Second + 897*time.Millisecond),
+		{},
+	}
+	msgs := make([]Message, len(times))
+	for i, t := range times {
+		msgs[i] = Message{Value: []byte(strconv.Itoa(i)), Time: t}
+	}
+
+	before := time.Now().Truncate(time.Millisecond)
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	r := NewReader(ReaderConfig{
+		Brokers: []string{broker.Addr()},
+		Topic:   "test",
+		MaxWait: 10 * time.Millisecond,
+	})
+	defer r.Close()
+
+	for i, expected := range times {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected.IsZero() {
+			// Messages without a time are written with the current time.
+			if m.Time.Before(before) || m.Time.After(after) {
+				t.Errorf("message %d: expected a time between %s and %s; got %s", i, before, after, m.Time)
+			}
+		} else if !m.Time.Equal(expected) {
+			t.Errorf("message %d: expected time %s; got %s", i, expected, m.Time)
+		}
+	}
+}
+
+func TestWriterMetadataRefresh(t *testing.T) {
+	broker, err := NewMockCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	var produces [2]int32
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			atomic.AddInt32(&produces[req.Node], 1)
+		}
+		return MockResponse{}
+	})
+
+	// The writer reports the leader move once the background lookup found it.
+	moved := make(chan struct{})
+	var once sync.Once
+
+	w := NewWriter(WriterConfig{
+		Brokers:                 []string{broker.Addr()},
+		Topic:                   "test",
+		BatchTimeout:            10 * time.Millisecond,
+		MetadataRefreshInterval: 10 * time.Millisecond,
+		StructuredLogger: LoggerFunc(func(format string, args ...interface{}) {
+			if strings.Contains(fmt.Sprintf(format, args...), "partition leader moved") {
+				once.Do(func() { close(moved) })
+			}
+		}),
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old leader rejects the batches written to the partition once it
+	// moved, the writer looks up the new leader before writing the next one.
+	broker.MoveLeader("test", 0, 1)
+	select {
+	case <-moved:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the writer to find the new leader")
+	}
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("B")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(broker.Messages("test", 0)); n != 2 {
+		t.Errorf("expected 2 messages in the partition; got %d", n)
+	}
+	if n0, n1 := atomic.LoadInt32(&produces[0]), atomic.LoadInt32(&produces[1]); n0 != 1 || n1 != 1 {
+		t.Errorf("expected one produce request to each node; got %d and %d", n0, n1)
+	}
+}
+
+func TestWriterIdleTimeout(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "test",
+		BatchTimeout: 10 * time.Millisecond,
+		IdleTimeout:  50 * time.Millisecond,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	if dials := w.Stats().Dials; dials != 1 {
+		t.Fatalf("expected 1 dial; got %d", dials)
+	}
+
+	// The connection is reused while it is not idle for longer than the
+	// timeout, and re-established after.
+	if err := w.WriteMessages(ctx, Message{Value: []byte("B")}); err != nil {
+		t.Fatal(err)
+	}
+	if dials := w.Stats().Dials; dials != 0 {
+		t.Fatalf("expected the connection to be reused; got %d dials", dials)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if err := w.WriteMessages(ctx, Message{Value: []byte("C")}); err != nil {
+		t.Fatal(err)
+	}
+	if dials := w.Stats().Dials; dials != 1 {
+		t.Fatalf("expected the idle connection to be re-established; got %d dials", dials)
+	}
+	if n := len(broker.Messages("test", 0)); n != 3 {
+		t.Errorf("expected 3 messages in the partition; got %d", n)
+	}
+}
+
+func TestWriterPartitionFor(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 3)
+
+	w := NewWriter(WriterConfig{
+		Brokers:           []string{broker.Addr()},
+		Topic:             "test",
+		Balancer:          &Hash{},
+		BatchTimeout:      10 * time.Millisecond,
+		RebalanceInterval: 20 * time.Millisecond,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f")}
+	matches := func(partitions ...int) bool {
+		for _, key := range keys {
+			p, err := w.PartitionFor(Message{Key: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != (&Hash{}).Balance(Message{Key: key}, partitions...) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !matches(0, 1, 2) {
+		t.Fatal("expected the partitions to be chosen by the balancer of the writer")
+	}
+
+	// The message is written to the partition returned by PartitionFor.
+	p, err := w.PartitionFor(Message{Key: keys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessages(ctx, Message{Key: keys[0], Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := broker.Messages("test", p); len(msgs) != 1 || string(msgs[0].Key) != string(keys[0]) {
+		t.Errorf("expected the message to be written to partition %d; got %v", p, msgs)
+	}
+
+	// The partitions added to the topic are picked up on the next rebalance.
+	broker.AddPartitions("test", 3)
+	for !matches(0, 1, 2, 3, 4, 5) {
+		if ctx.Err() != nil {
+			t.Fatal("the new partitions were not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if p, err := w.PartitionForKey(keys[1]); err != nil || p != (&Hash{}).Balance(Message{Key: keys[1]}, 0, 1, 2, 3, 4, 5) {
+		t.Errorf("expected PartitionForKey to return the partition of the key; got %d (%v)", p, err)
+	}
+
+	// Messages without keys would advance the fallback of the balancer.
+	if _, err := w.PartitionFor(Message{}); err != ErrStatefulBalancer {
+		t.Errorf("expected %v for a message without a key; got %v", ErrStatefulBalancer, err)
+	}
+
+	w.Close()
+	if _, err := w.PartitionFor(Message{Key: keys[0]}); err != io.ErrClosedPipe {
+		t.Errorf("expected %v after closing the writer; got %v", io.ErrClosedPipe, err)
+	}
+}
+
+func TestWriterPartitionForStatefulBalancer(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 3)
+
+	balancer := &countingBalancer{Balancer: &RoundRobin{}}
+	w := NewWriter(WriterConfig{
+		Brokers:  []string{broker.Addr()},
+		Topic:    "test",
+		Balancer: balancer,
+	})
+	defer w.Close()
+
+	if _, err := w.PartitionFor(Message{Key: []byte("key")}); err != ErrStatefulBalancer {
+		t.Errorf("expected %v; got %v", ErrStatefulBalancer, err)
+	}
+	if balancer.calls != 0 {
+		t.Errorf("expected the balancer not to be called; got %d calls", balancer.calls)
+	}
+}
+
+func TestWriterPartitionForLocator(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 3)
+
+	// Routes the keys by range, the first letters of the alphabet to the
+	// first partition.
+	byRange := func(msg Message, partitions ...int) int {
+		switch {
+		case len(msg.Key) == 0 || msg.Key[0] < 'i':
+			return partitions[0]
+		case msg.Key[0] < 'q':
+			return partitions[1]
+		default:
+			return partitions[2]
+		}
+	}
+
+	tests := []struct {
+		scenario string
+		balancer Balancer
+		err      error
+	}{
+		{scenario: "stateless functions are called", balancer: StatelessBalancerFunc(byRange)},
+		{scenario: "balancer functions may be stateful", balancer: BalancerFunc(byRange), err: ErrStatefulBalancer},
+		{scenario: "hash fallbacks implementing the interface are called", balancer: &Hash{Fallback: StatelessBalancerFunc(byRange)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			w := NewWriter(WriterConfig{
+				Brokers:  []string{broker.Addr()},
+				Topic:    "test",
+				Balancer: test.balancer,
+			})
+			defer w.Close()
+
+			for key, expected := range map[string]int{"alpha": 0, "kilo": 1, "zulu": 2} {
+				p, err := w.PartitionForKey([]byte(key))
+				if err != test.err {
+					t.Fatalf("expected %v; got %v", test.err, err)
+				}
+				if _, hashed := test.balancer.(*Hash); err == nil && !hashed && p != expected {
+					t.Errorf("%s: expected partition %d; got %d", key, expected, p)
+				}
+			}
+
+			// Messages with a nil key are routed by the fallback of Hash.
+			if p, err := w.PartitionFor(Message{}); err != test.err || (err == nil && p != 0) {
+				t.Errorf("expected partition 0 for a message without a key; got %d (%v)", p, err)
+			}
+		})
+	}
+}
+
+func TestWriterErrorHandler(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The produce requests fail until the writer gives up on the batch.
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			return MockResponse{Error: NotEnoughReplicas}
+		}
+		return MockResponse{}
+	})
+
+	var mutex sync.Mutex
+	var failed []Message
+	var errs []error
+	var calls int32
+
+	w := NewWriter(WriterConfig{
+		Brokers:         []string{broker.Addr()},
+		Topic:           "test",
+		Async:           true,
+		BatchSize:       1,
+		BatchTimeout:    10 * time.Millisecond,
+		Retries:         1,
+		RetryBackoffMin: time.Millisecond,
+		RetryBackoffMax: time.Millisecond,
+		Middleware: []func(Message) (Message, error){
+			func(msg Message) (Message, error) {
+				msg.Value = append([]byte("middleware-"), msg.Value...)
+				return msg, nil
+			},
+		},
+		ErrorHandler: func(msgs []Message, err error) {
+			// The handler is never called concurrently.
+			if n := atomic.AddInt32(&calls, 1); n != 1 {
+				t.Errorf("expected the handler to be called sequentially; got %d concurrent calls", n)
+			}
+			defer atomic.AddInt32(&calls, -1)
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			failed = append(failed, msgs...)
+			errs = append(errs, err)
+		},
+	})
+
+	msgs := []Message{
+		{Key: []byte("a"), Value: []byte("A")},
+		{Key: []byte("b"), Value: []byte("B")},
+		{Key: []byte("c"), Value: []byte("C")},
+	}
+	if err := w.WriteMessages(context.Background(), msgs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close returns once the handler was called for the failed batches.
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(failed) != len(msgs) {
+		t.Fatalf("expected %d failed messages; got %d", len(msgs), len(failed))
+	}
+	// The handler receives the messages as they were passed to WriteMessages,
+	// before the middleware ran.
+	for i, msg := range failed {
+		if string(msg.Key) != string(msgs[i].Key) || string(msg.Value) != string(msgs[i].Value) {
+			t.Errorf("failed message %d: expected %s=%s; got %s=%s", i, msgs[i].Key, msgs[i].Value, msg.Key, msg.Value)
+		}
+	}
+	for _, err := range errs {
+		if err == nil {
+			t.Error("expected the handler to be called with an error")
+		}
+	}
+	if n := len(broker.Messages("test", 0)); n != 0 {
+		t.Errorf("expected no messages to be written; got %d", n)
+	}
+}
+
+func TestWriterCloseWithContextAbort(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("test", 1)
+
+	// The broker never responds to the produce requests.
+	sent := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	broker.OnRequest(func(req MockRequest) MockResponse {
+		if req.API == MockProduce {
+			select {
+			case sent <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		return MockResponse{}
+	})
+
+	completed := make(chan error, 1)
+	w := NewWriter(WriterConfig{
+		Brokers:         []string{broker.Addr()},
+		Topic:           "test",
+		Async:           true,
+		BatchTimeout:    time.Millisecond,
+		WriteTimeout:    time.Minute,
+		RetryBackoffMin: time.Minute,
+		RetryBackoffMax: time.Minute,
+		Completion: func(msgs []Message, err error) {
+			completed <- err
+		},
+	})
+
+	if err := w.WriteMessages(context.Background(), Message{Value: []byte("A")}); err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, ok := w.CloseWithContext(ctx).(*UndeliveredMessagesError); !ok {
+		t.Fatal("expected an *UndeliveredMessagesError")
+	}
+
+	// The write in progress is aborted rather than waiting for the write
+	// timeout, and the message fails with the error of the context.
+	select {
+	case err := <-completed:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Errorf("expected the message to fail with %v; got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the write was not aborted after closing the writer")
+	}
+}
+
+func TestWriterMultipleTopics(t *testing.T) {
+	broker, err := NewMockBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.CreateTopic("a", 2)
+	broker.CreateTopic("b", 1)
+
+	w := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		BatchTimeout: 10 * time.Millisecond,
+		MaxAttempts:  1,
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = w.WriteMessages(ctx,
+		Message{Topic: "a", Value: []byte("1")},
+		Message{Topic: "b", Value: []byte("2")},
+		Message{Topic: "a", Value: []byte("3")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a []string
+	for p := 0; p < 2; p++ {
+		for _, msg := range broker.Messages("a", p) {
+			a = append(a, string(msg.Value))
+		}
+	}
+	sort.Strings(a)
+	if !reflect.DeepEqual(a, []string{"1", "3"}) {
+		t.Errorf("expected messages 1 and 3 in topic a; got %v", a)
+	}
+	if b := broker.Messages("b", 0); len(b) != 1 || string(b[0].Value) != "2" {
+		t.Errorf("expected message 2 in topic b; got %v", b)
+	}
+
+	// Messages without topics are rejected before any message is written.
+	err = w.WriteMessages(ctx, Message{Topic: "b", Value: []byte("4")}, Message{Value: []byte("5")})
+	if err != ErrMissingTopic {
+		t.Errorf("expected %v; got %v", ErrMissingTopic, err)
+	}
+	if n := len(broker.Messages("b", 0)); n != 1 {
+		t.Errorf("expected no more messages in topic b; got %d", n)
+	}
+
+	if err := w.WriteMessages(ctx, Message{Topic: "unknown", Value: []byte("6")}); err == nil {
+		t.Error("expected an error writing to a topic which does not exist")
+	}
+
+	// The failed lookup is not remembered once the topic is created.
+	broker.CreateTopic("unknown", 1)
+	if err := w.WriteMessages(ctx, Message{Topic: "unknown", Value: []byte("6")}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := w.PartitionFor(Message{Key: []byte("key")}); err != ErrMissingTopic {
+		t.Errorf("expected %v; got %v", ErrMissingTopic, err)
+	}
+	if p, err := w.PartitionFor(Message{Topic: "b", Key: []byte("key")}); err != nil || p != 0 {
+		t.Errorf("expected partition 0 of topic b; got %d (%v)", p, err)
+	}
+
+	// Writers with a topic reject messages for other topics.
+	single := NewWriter(WriterConfig{
+		Brokers:      []string{broker.Addr()},
+		Topic:        "a",
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer single.Close()
+
+	err = single.WriteMessages(ctx, Message{Topic: "b", Value: []byte("7")})
+	if e, ok := err.(*TopicMismatchError); !ok || e.WriterTopic != "a" || e.MessageTopic != "b" {
+		t.Errorf("expected a topic mismatch between a and b; got %v", err)
+	}
+	if err := single.WriteMessages(ctx, Message{Topic: "a", Value: []byte("8")}); err != nil {
+		t.Error(err)
+	}
+}
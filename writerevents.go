@@ -0,0 +1,231 @@
+package kafka
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchEvent is passed to WriterConfig.OnBatch when a batch of messages is
+// flushed to be written to a partition.
+type BatchEvent struct {
+	Topic     string
+	Partition int
+
+	// Messages is the number of messages in the batch.
+	Messages int
+
+	// Bytes is the size of the batch, as compared to WriterConfig.BatchBytes.
+	Bytes int64
+
+	// Duration is the time elapsed between the first message being added to
+	// the batch and the batch being flushed.
+	Duration time.Duration
+}
+
+// WriteEvent is passed to WriterConfig.OnWrite when a batch of messages was
+// written to a partition.
+type WriteEvent struct {
+	Topic     string
+	Partition int
+
+	// Messages is the number of messages written.
+	Messages int
+
+	// Bytes is the sum of the sizes of the keys and values of the messages.
+	Bytes int64
+
+	// Duration is the time spent writing the batch, including retries.
+	Duration time.Duration
+}
+
+// ErrorEvent is passed to WriterConfig.OnError when a batch of messages could
+// not be written to a partition after exhausting the retries.
+type ErrorEvent struct {
+	Topic     string
+	Partition int
+
+	// Messages is the number of messages which were not written.
+	Messages int
+
+	// Duration is the time spent trying to write the batch.
+	Duration time.Duration
+
+	// Err is the error of the last attempt.
+	Err error
+}
+
+// RetryEvent is passed to WriterConfig.OnRetry when writing a batch of
+// messages to a partition failed and is about to be retried.
+type RetryEvent struct {
+	Topic     string
+	Partition int
+
+	// Messages is the number of messages in the batch.
+	Messages int
+
+	// Attempt is the number of the retry, starting at 1.
+	Attempt int
+
+	// Backoff is the time waited before retrying.
+	Backoff time.Duration
+
+	// Err is the error of the failed attempt.
+	Err error
+}
+
+// writerEvents invokes the event hooks of a writer in a separate goroutine so
+// the partition writers never wait for them. Events are dropped when the queue
+// is full.
+//
+// A nil *writerEvents discards all events.
+type writerEvents struct {
+	onBatch func(BatchEvent)
+	onWrite func(WriteEvent)
+	onError func(ErrorEvent)
+	onRetry func(RetryEvent)
+	queue   chan func()
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newWriterEvents returns the writerEvents invoking the hooks of config, or nil
+// if none are set.
+func newWriterEvents(config WriterConfig) *writerEvents {
+	if config.OnBatch == nil && config.OnWrite == nil && config.OnError == nil && config.OnRetry == nil {
+		return nil
+	}
+
+	e := &writerEvents{
+		onBatch: config.OnBatch,
+		onWrite: config.OnWrite,
+		onError: config.OnError,
+		onRetry: config.OnRetry,
+		queue:   make(chan func(), config.QueueCapacity),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *writerEvents) run() {
+	defer close(e.done)
+	for f := range e.queue {
+		f()
+	}
+}
+
+// close waits for the queued events to be delivered, no events must be posted
+// after it was called.
+func (e *writerEvents) close() {
+	if e != nil {
+		e.once.Do(func() { close(e.queue) })
+		<-e.done
+	}
+}
+
+func (e *writerEvents) post(f func()) {
+	select {
+	case e.queue <- f:
+	default:
+	}
+}
+
+func (e *writerEvents) batch(event BatchEvent) {
+	if e != nil && e.onBatch != nil {
+		e.post(func() { e.onBatch(event) })
+	}
+}
+
+func (e *writerEvents) write(event WriteEvent) {
+	if e != nil && e.onWrite != nil {
+		e.post(func() { e.onWrite(event) })
+	}
+}
+
+func (e *writerEvents) error(event ErrorEvent) {
+	if e != nil && e.onError != nil {
+		e.post(func() { e.onError(event) })
+	}
+}
+
+func (e *writerEvents) retry(event RetryEvent) {
+	if e != nil && e.onRetry != nil {
+		e.post(func() { e.onRetry(event) })
+	}
+}
+
+// errorHandler invokes the ErrorHandler of a writer sequentially from a single
+// goroutine, which runs while failures are queued so the partition writers
+// never wait for it. Unlike events, the failures are never dropped.
+//
+// A nil *errorHandler discards all failures.
+type errorHandler struct {
+	handle  func([]Message, error)
+	mutex   sync.Mutex
+	queue   []writerFailure
+	running bool
+	join    sync.WaitGroup
+}
+
+type writerFailure struct {
+	msgs []Message
+	err  error
+}
+
+// newErrorHandler returns the errorHandler invoking the ErrorHandler of config,
+// or nil if it is not set or the writer is synchronous.
+func newErrorHandler(config WriterConfig) *errorHandler {
+	if !config.Async || config.ErrorHandler == nil {
+		return nil
+	}
+	return &errorHandler{handle: config.ErrorHandler}
+}
+
+// report queues the messages that msgs point to for the handler, the caller
+// may reuse the slice.
+func (h *errorHandler) report(msgs []*Message, err error) {
+	if h == nil {
+		return
+	}
+
+	failed := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		failed[i] = *msg
+	}
+
+	h.mutex.Lock()
+	h.queue = append(h.queue, writerFailure{msgs: failed, err: err})
+	if !h.running {
+		h.running = true
+		h.join.Add(1)
+		go h.run()
+	}
+	h.mutex.Unlock()
+}
+
+func (h *errorHandler) run() {
+	defer h.join.Done()
+
+	for {
+		h.mutex.Lock()
+		if len(h.queue) == 0 {
+			h.running = false
+			h.mutex.Unlock()
+			return
+		}
+		f := h.queue[0]
+		h.queue[0] = writerFailure{}
+		h.queue = h.queue[1:]
+		h.mutex.Unlock()
+
+		h.handle(f.msgs, f.err)
+	}
+}
+
+// wait waits for the calls to the handler to return, no failures must be
+// reported after it was called.
+func (h *errorHandler) wait() {
+	if h != nil {
+		h.join.Wait()
+	}
+}
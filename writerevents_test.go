@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriterEventsRetryAndError(t *testing.T) {
+	var retries []RetryEvent
+	var errs []ErrorEvent
+
+	config := WriterConfig{
+		Topic: "topic",
+		// nothing listens on this port, dialing the leader always fails
+		Brokers:         []string{"localhost:9099"},
+		BatchSize:       10,
+		Retries:         2,
+		RetryBackoffMin: time.Millisecond,
+		RetryBackoffMax: time.Millisecond,
+		QueueCapacity:   10,
+		Dialer:          DefaultDialer,
+		OnRetry:         func(e RetryEvent) { retries = append(retries, e) },
+		OnError:         func(e ErrorEvent) { errs = append(errs, e) },
+	}
+	config.events = newWriterEvents(config)
+
+	w := newWriter(3, config, &writerStats{})
+	defer w.close()
+
+	if _, err := w.write(nil, w.codec, makeTestSequence(2), nil, nil); err == nil {
+		t.Fatal("expected an error writing to an unreachable broker")
+	}
+
+	// waits for the events to be delivered
+	config.events.close()
+
+	if len(retries) != 2 {
+		t.Fatalf("expected 2 retry events; got %d", len(retries))
+	}
+	for i, e := range retries {
+		if e.Topic != "topic" || e.Partition != 3 || e.Messages != 2 || e.Attempt != i+1 || e.Err == nil {
+			t.Errorf("unexpected retry event: %+v", e)
+		}
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error event; got %d", len(errs))
+	}
+	if e := errs[0]; e.Topic != "topic" || e.Partition != 3 || e.Messages != 2 || e.Err == nil {
+		t.Errorf("unexpected error event: %+v", e)
+	}
+}
+
+func TestWriterEventsDoNotBlock(t *testing.T) {
+	unblock := make(chan struct{})
+	delivered := 0
+
+	events := newWriterEvents(WriterConfig{
+		QueueCapacity: 2,
+		OnError: func(ErrorEvent) {
+			<-unblock
+			delivered++
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i != 10; i++ {
+			events.error(ErrorEvent{Err: errors.New("oops")})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("posting events blocked on the hook")
+	}
+
+	close(unblock)
+	events.close()
+
+	// one event is being delivered while the queue fills up
+	if delivered < 2 || delivered > 3 {
+		t.Errorf("expected the events exceeding the queue capacity to be dropped; got %d delivered", delivered)
+	}
+}
+
+func TestWriterEventsNil(t *testing.T) {
+	var events *writerEvents
+	if events = newWriterEvents(WriterConfig{}); events != nil {
+		t.Fatal("expected no events without hooks")
+	}
+	// must not panic
+	events.batch(BatchEvent{})
+	events.write(WriteEvent{})
+	events.error(ErrorEvent{})
+	events.retry(RetryEvent{})
+	events.close()
+}